@@ -0,0 +1,306 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"checkout/config"
+	"checkout/handlers"
+	"checkout/services"
+	"checkout/utils"
+	"checkout/version"
+
+	"github.com/stripe/stripe-go/v74"
+)
+
+// Exit codes of the commands, for scripts and cron jobs
+const (
+	exitOK       = 0 // Done
+	exitFailure  = 1 // The command failed
+	exitUsage    = 2 // Unknown command or bad flags
+	exitProblems = 3 // The command ran and found problems: check-config errors or reconciliation discrepancies
+)
+
+// command is a subcommand of the checkout binary, named as its first argument
+type command struct {
+	name    string
+	summary string
+	run     func(args []string) int
+}
+
+// commands lists the subcommands; without one the binary runs serve
+var commands []command
+
+func init() {
+	commands = []command{
+		{"serve", "Run the POS web server (the default)", serve},
+		{"init", "Create data/config.json by answering prompts", initConfig},
+		{"check-config", "Check data/config.json for invalid settings", checkConfig},
+		{"export", "Write a business day's transaction log as CSV", exportTransactions},
+		{"reconcile", "Compare a business day's card payments with Stripe", reconcile},
+		{"resend-receipt", "Email the receipt of a past sale again", resendReceipt},
+		{"help", "Show this list", func([]string) int { printUsage(os.Stdout); return exitOK }},
+	}
+}
+
+// run runs the command named by the first argument, or serve when the first argument is a flag
+// or missing, and returns the exit code
+func run(args []string) int {
+	name := "serve"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd.run(args)
+		}
+	}
+	fmt.Fprintf(os.Stderr, "unknown command %q\n\n", name)
+	printUsage(os.Stderr)
+	return exitUsage
+}
+
+// printUsage lists the commands
+func printUsage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: checkout [command] [flags]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Commands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %-15s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, `Run "checkout <command> -h" for the flags of a command.`)
+}
+
+// logFlags are the logging flags every command takes
+type logFlags struct {
+	debug  *bool
+	format *string
+}
+
+// newFlagSet returns the flag set of a command, with the logging flags added. usage names the
+// command's arguments in its help text.
+func newFlagSet(name, usage string) (*flag.FlagSet, *logFlags) {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: checkout %s %s\n", name, usage)
+		flags.PrintDefaults()
+	}
+	return flags, &logFlags{
+		debug:  flags.Bool("debug", false, "Enable debug logging (overrides the configured log level)"),
+		format: flags.String("log-format", "text", "Console log format: text or json"),
+	}
+}
+
+// parseFlags parses a command's flags. When it returns false the command stops with the exit
+// code: exitOK after -h, exitUsage for flags it doesn't know.
+func parseFlags(flags *flag.FlagSet, args []string) (int, bool) {
+	err := flags.Parse(args)
+	if errors.Is(err, flag.ErrHelp) {
+		return exitOK, false
+	} else if err != nil {
+		return exitUsage, false
+	}
+	if flags.NArg() > 0 {
+		fmt.Fprintf(flags.Output(), "unexpected arguments: %s\n", strings.Join(flags.Args(), " "))
+		flags.Usage()
+		return exitUsage, false
+	}
+	return exitOK, true
+}
+
+// setup loads the configuration without prompting and routes logging to the console (plus a
+// rotated file if set). The headless commands log to stderr so their output can be piped.
+func setup(logging *logFlags, console io.Writer) error {
+	if err := config.Load(); err != nil {
+		return err
+	}
+
+	logOptions, err := config.GetLogOptions(*logging.debug, *logging.format)
+	if err != nil {
+		return err
+	}
+	logOptions.Console = console
+	if err := utils.ConfigureLogging(logOptions); err != nil {
+		return fmt.Errorf("failed to configure logging: %w", err)
+	}
+	if *logging.debug {
+		utils.Debug("startup", "Debug logging enabled")
+	}
+	if logOptions.File != "" {
+		utils.Info("startup", "Writing logs to file", "file", logOptions.File, "level", logOptions.Level, "max_size_mb", logOptions.MaxSizeMB, "max_files", logOptions.MaxFiles)
+	}
+	return nil
+}
+
+// configureStripe sets the Stripe key and names this build in the User-Agent of Stripe API calls
+func configureStripe() {
+	stripe.Key = config.GetStripeKey()
+	stripe.SetAppInfo(&stripe.AppInfo{Name: "checkout", Version: version.String(), URL: "https://github.com/codr1/checkout"})
+
+	// Route Stripe calls through an HTTP client that counts API errors for /metrics
+	stripe.SetBackend(stripe.APIBackend, stripe.GetBackendWithConfig(stripe.APIBackend, &stripe.BackendConfig{
+		HTTPClient: services.StripeHTTPClient(),
+	}))
+}
+
+// addDateFlag adds the -date flag of the commands that work on one business day
+func addDateFlag(flags *flag.FlagSet) *string {
+	return flags.String("date", "", "Business day as YYYY-MM-DD (default: yesterday)")
+}
+
+// parseDay returns the business day of a -date value, or yesterday's when it is empty
+func parseDay(date string) (time.Time, error) {
+	if date == "" {
+		return config.BusinessDay(time.Now()).AddDate(0, 0, -1), nil
+	}
+	day, err := time.ParseInLocation("2006-01-02", date, config.GetBusinessLocation())
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid -date %q: use YYYY-MM-DD", date)
+	}
+	return day, nil
+}
+
+// initConfig creates the configuration file interactively. It is the only command that prompts.
+func initConfig(args []string) int {
+	flags, _ := newFlagSet("init", "")
+	if code, ok := parseFlags(flags, args); !ok {
+		return code
+	}
+	if err := config.Init(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitFailure
+	}
+	return exitOK
+}
+
+// checkConfig validates the configuration file after hand editing, without contacting Stripe
+func checkConfig(args []string) int {
+	flags, logging := newFlagSet("check-config", "")
+	if code, ok := parseFlags(flags, args); !ok {
+		return code
+	}
+	if err := setup(logging, os.Stderr); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitFailure
+	}
+
+	problems := config.Check()
+	for _, problem := range problems {
+		fmt.Println(problem)
+	}
+	if len(problems) > 0 {
+		fmt.Fprintf(os.Stderr, "Configuration check found %d problems\n", len(problems))
+		return exitProblems
+	}
+	fmt.Fprintln(os.Stderr, "Configuration OK")
+	return exitOK
+}
+
+// exportTransactions writes the transaction log of a business day to stdout or a file
+func exportTransactions(args []string) int {
+	flags, logging := newFlagSet("export", "[-date YYYY-MM-DD] [-out file]")
+	date := addDateFlag(flags)
+	out := flags.String("out", "", "File to write the CSV to (default: stdout)")
+	if code, ok := parseFlags(flags, args); !ok {
+		return code
+	}
+	if err := setup(logging, os.Stderr); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitFailure
+	}
+	day, err := parseDay(*date)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitUsage
+	}
+
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		file, err := os.Create(*out)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitFailure
+		}
+		defer file.Close()
+		w = file
+	}
+	if err := services.ExportTransactionLog(day, w); err != nil {
+		fmt.Fprintf(os.Stderr, "Export of %s failed: %v\n", day.Format("2006-01-02"), err)
+		return exitFailure
+	}
+	utils.Info("export", "Transaction log exported", "date", day.Format("2006-01-02"), "out", *out)
+	return exitOK
+}
+
+// reconcile compares a business day's card payments with Stripe and prints the report,
+// optionally emailing it to the reconciliation recipients as the nightly job does
+func reconcile(args []string) int {
+	flags, logging := newFlagSet("reconcile", "[-date YYYY-MM-DD] [-email]")
+	date := addDateFlag(flags)
+	email := flags.Bool("email", false, "Also email the report to the reconciliation recipients")
+	if code, ok := parseFlags(flags, args); !ok {
+		return code
+	}
+	if err := setup(logging, os.Stderr); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitFailure
+	}
+	day, err := parseDay(*date)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitUsage
+	}
+	configureStripe()
+
+	var report services.ReconciliationReport
+	if *email {
+		report, err = services.SendReconciliationReport(services.Stripe, day)
+	} else {
+		report, err = services.ReconcileDay(services.Stripe, day)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Reconciliation of %s failed: %v\n", day.Format("2006-01-02"), err)
+		return exitFailure
+	}
+
+	fmt.Print(services.FormatReconciliationReport(report))
+	if len(report.Discrepancies) > 0 {
+		return exitProblems
+	}
+	return exitOK
+}
+
+// resendReceipt emails the receipt of a past sale again
+func resendReceipt(args []string) int {
+	flags, logging := newFlagSet("resend-receipt", "-id <confirmation code> -email <address> [-override]")
+	id := flags.String("id", "", "Confirmation code of the sale")
+	email := flags.String("email", "", "Email address to send the receipt to")
+	override := flags.Bool("override", false, "Send the receipt of a voided or refunded sale anyway")
+	if code, ok := parseFlags(flags, args); !ok {
+		return code
+	}
+	if strings.TrimSpace(*id) == "" || strings.TrimSpace(*email) == "" {
+		fmt.Fprintln(os.Stderr, "-id and -email are required")
+		flags.Usage()
+		return exitUsage
+	}
+	if err := setup(logging, os.Stderr); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitFailure
+	}
+	configureStripe()
+
+	sentMethod, err := handlers.ResendReceipt(services.Stripe, strings.TrimSpace(*id), *email, *override)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Receipt not sent: %v\n", err)
+		return exitFailure
+	}
+	fmt.Printf("Receipt sent by %s\n", sentMethod)
+	return exitOK
+}
@@ -0,0 +1,362 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stripe/stripe-go/v74"
+
+	"checkout/config"
+	"checkout/services"
+	"checkout/services/stripetest"
+	"checkout/templates"
+	"checkout/utils"
+)
+
+// useWorkDir runs the test in an empty directory, where the commands look for data/config.json
+func useWorkDir(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	saved := config.Config
+	t.Setenv("STRIPE_SECRET_KEY", "")
+	t.Setenv("DEFAULT_TAX_RATE", "")
+	t.Cleanup(func() {
+		config.Config = saved
+		os.Chdir(wd)
+	})
+	return dir
+}
+
+// validConfig is a configuration check-config has nothing to say about
+func validConfig() templates.AppConfig {
+	return templates.AppConfig{
+		StripeSecretKey: "sk_test_123",
+		Users:           []templates.User{{Username: "alice", PasswordHash: "$2a$10$hash", Role: templates.RoleAdmin}},
+	}
+}
+
+// writeConfig writes data/config.json in the working directory
+func writeConfig(t *testing.T, cfg templates.AppConfig) {
+	t.Helper()
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(config.DefaultDataDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(config.DefaultDataDir, "config.json"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// useConfiguredWorkDir runs the test in a directory with a valid configuration and an empty
+// transaction log directory
+func useConfiguredWorkDir(t *testing.T) {
+	t.Helper()
+	useWorkDir(t)
+	writeConfig(t, validConfig())
+	config.Config.TransactionsDir = config.DefaultTransactionsDir
+	if err := os.MkdirAll(config.Config.TransactionsDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// runCommand runs the binary's command line and returns its exit code and what it printed
+func runCommand(t *testing.T, args ...string) (code int, stdout, stderr string) {
+	t.Helper()
+	outFile, err := os.CreateTemp(t.TempDir(), "stdout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	errFile, err := os.CreateTemp(t.TempDir(), "stderr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	savedOut, savedErr := os.Stdout, os.Stderr
+	os.Stdout, os.Stderr = outFile, errFile
+
+	code = run(args)
+
+	os.Stdout, os.Stderr = savedOut, savedErr
+	utils.ConfigureLogging(utils.LogOptions{})
+	outFile.Close()
+	errFile.Close()
+	out, _ := os.ReadFile(outFile.Name())
+	errOut, _ := os.ReadFile(errFile.Name())
+	return code, string(out), string(errOut)
+}
+
+// useStripe answers the commands' Stripe calls from memory
+func useStripe(t *testing.T) *stripetest.Client {
+	t.Helper()
+	fake := stripetest.New()
+	saved := services.Stripe
+	services.Stripe = fake
+	t.Cleanup(func() { services.Stripe = saved })
+	return fake
+}
+
+// logSale logs a card sale taken now
+func logSale(t *testing.T, id string, total float64) templates.Transaction {
+	t.Helper()
+	now := time.Now()
+	sale := templates.Transaction{
+		ID:          id,
+		Date:        now.Format("01/02/2006"),
+		Time:        now.Format("15:04:05"),
+		Products:    []templates.Product{{Name: "Coffee", Price: total}},
+		Subtotal:    total,
+		Total:       total,
+		PaymentType: "terminal",
+	}
+	if err := services.SaveTransactionToCSV(sale); err != nil {
+		t.Fatal(err)
+	}
+	return sale
+}
+
+// paidIntent returns a PaymentIntent the POS created and the customer paid
+func paidIntent(t *testing.T, fake *stripetest.Client, cents int64) string {
+	t.Helper()
+	params := &stripe.PaymentIntentParams{Amount: stripe.Int64(cents)}
+	params.AddMetadata(services.MetadataPaymentID, "cart_1")
+	params.AddMetadata(services.MetadataPaymentMethod, "terminal")
+	intent, err := fake.CreatePaymentIntent(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake.SetIntentStatus(intent.ID, stripe.PaymentIntentStatusSucceeded)
+	return intent.ID
+}
+
+// today is the -date value of the current business day
+func today() string {
+	return config.BusinessDay(time.Now()).Format("2006-01-02")
+}
+
+// Without a configuration file the commands fail for a script to see instead of prompting
+func TestCommandsWithoutConfigFail(t *testing.T) {
+	tests := [][]string{
+		{"check-config"},
+		{"export", "-date", "2026-03-13"},
+		{"reconcile", "-date", "2026-03-13"},
+		{"resend-receipt", "-id", "pi_1", "-email", "bob@example.com"},
+	}
+	for _, args := range tests {
+		t.Run(args[0], func(t *testing.T) {
+			useWorkDir(t)
+
+			code, stdout, stderr := runCommand(t, args...)
+
+			if code != exitFailure {
+				t.Errorf("exit code = %d, want %d", code, exitFailure)
+			}
+			if stdout != "" {
+				t.Errorf("printed %q, want nothing", stdout)
+			}
+			if !strings.Contains(stderr, "checkout init") {
+				t.Errorf("stderr = %q, want it to point at checkout init", stderr)
+			}
+			if _, err := os.Stat(filepath.Join(config.DefaultDataDir, "config.json")); !os.IsNotExist(err) {
+				t.Errorf("config.json created without init: %v", err)
+			}
+		})
+	}
+}
+
+func TestCommandUsage(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     []string
+		wantCode int
+	}{
+		{"unknown command", []string{"bogus"}, exitUsage},
+		{"unknown flag", []string{"export", "-bogus"}, exitUsage},
+		{"stray argument", []string{"check-config", "now"}, exitUsage},
+		{"bad date", []string{"export", "-date", "13/03/2026"}, exitUsage},
+		{"resend without an email", []string{"resend-receipt", "-id", "pi_1"}, exitUsage},
+		{"command help", []string{"export", "-h"}, exitOK},
+		{"help", []string{"help"}, exitOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useWorkDir(t)
+			writeConfig(t, validConfig())
+
+			code, stdout, stderr := runCommand(t, tt.args...)
+
+			if code != tt.wantCode {
+				t.Errorf("exit code = %d, want %d; stderr: %s", code, tt.wantCode, stderr)
+			}
+			if tt.wantCode == exitUsage && !strings.Contains(stderr, "Usage: checkout") && !strings.Contains(stderr, "-date") {
+				t.Errorf("stderr = %q, want the usage", stderr)
+			}
+			if tt.name == "help" && !strings.Contains(stdout, "resend-receipt") {
+				t.Errorf("help = %q, want the commands listed", stdout)
+			}
+		})
+	}
+}
+
+func TestCheckConfig(t *testing.T) {
+	problems := validConfig()
+	problems.Users[0].Role = templates.RoleCashier
+	problems.BusinessDayClose = "3am"
+	problems.RetentionMonths = 1
+
+	tests := []struct {
+		name       string
+		cfg        templates.AppConfig
+		wantCode   int
+		wantStdout []string
+	}{
+		{"valid", validConfig(), exitOK, nil},
+		{"problems", problems, exitProblems, []string{"no admin user", "businessDayClose", "retentionMonths"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useWorkDir(t)
+			writeConfig(t, tt.cfg)
+
+			code, stdout, stderr := runCommand(t, "check-config")
+
+			if code != tt.wantCode {
+				t.Errorf("exit code = %d, want %d; stderr: %s", code, tt.wantCode, stderr)
+			}
+			if lines := strings.Count(stdout, "\n"); lines != len(tt.wantStdout) {
+				t.Errorf("printed %d problems, want %d:\n%s", lines, len(tt.wantStdout), stdout)
+			}
+			for _, want := range tt.wantStdout {
+				if !strings.Contains(stdout, want) {
+					t.Errorf("problems = %q, want one about %s", stdout, want)
+				}
+			}
+		})
+	}
+}
+
+func TestExportCommand(t *testing.T) {
+	useConfiguredWorkDir(t)
+	logSale(t, "pi_export", 4.50)
+
+	// To stdout, for a pipe
+	code, stdout, stderr := runCommand(t, "export", "-date", today())
+	if code != exitOK {
+		t.Fatalf("exit code = %d; stderr: %s", code, stderr)
+	}
+	if !strings.HasPrefix(stdout, strings.Join(services.TransactionCSVHeader, ",")) || !strings.Contains(stdout, "pi_export") {
+		t.Errorf("export = %q, want the day's log", stdout)
+	}
+	if strings.Contains(stderr, "pi_export,") {
+		t.Errorf("the log went to stderr too: %s", stderr)
+	}
+
+	// To a file
+	code, stdout, _ = runCommand(t, "export", "-date", today(), "-out", "sales.csv")
+	written, err := os.ReadFile("sales.csv")
+	if code != exitOK || err != nil || string(written) == "" {
+		t.Fatalf("export to file = %d, %v", code, err)
+	}
+	if stdout != "" {
+		t.Errorf("printed %q with -out, want nothing", stdout)
+	}
+
+	// A day without sales is just the header
+	code, stdout, _ = runCommand(t, "export", "-date", "2020-01-01")
+	if code != exitOK || strings.TrimSpace(stdout) != strings.Join(services.TransactionCSVHeader, ",") {
+		t.Errorf("empty day = %d, %q, want only the header", code, stdout)
+	}
+}
+
+func TestReconcileCommand(t *testing.T) {
+	tests := []struct {
+		name     string
+		log      func(t *testing.T, fake *stripetest.Client)
+		wantCode int
+		wantOut  string
+	}{
+		{"matched", func(t *testing.T, fake *stripetest.Client) {
+			logSale(t, paidIntent(t, fake, 450), 4.50)
+		}, exitOK, "Discrepancies:                  0"},
+		{"logged but not paid", func(t *testing.T, fake *stripetest.Client) {
+			logSale(t, paidIntent(t, fake, 450), 4.50)
+			logSale(t, "pi_missing", 3.25)
+		}, exitProblems, "Missing in Stripe  pi_missing"},
+		{"Stripe down", func(t *testing.T, fake *stripetest.Client) {
+			for range 5 {
+				fake.FailNext("ListCheckoutSessions", &stripe.Error{HTTPStatusCode: 500, Msg: "down"})
+			}
+		}, exitFailure, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useConfiguredWorkDir(t)
+			fake := useStripe(t)
+			tt.log(t, fake)
+
+			code, stdout, stderr := runCommand(t, "reconcile", "-date", today())
+
+			if code != tt.wantCode {
+				t.Errorf("exit code = %d, want %d; stderr: %s", code, tt.wantCode, stderr)
+			}
+			if !strings.Contains(stdout, tt.wantOut) {
+				t.Errorf("report = %q, want %q", stdout, tt.wantOut)
+			}
+		})
+	}
+}
+
+func TestResendReceiptCommand(t *testing.T) {
+	tests := []struct {
+		name     string
+		voided   bool
+		args     []string
+		wantCode int
+	}{
+		{"sale", false, nil, exitOK},
+		{"unknown sale", false, []string{"-id", "pi_unknown"}, exitFailure},
+		{"invalid email", false, []string{"-email", "bob@"}, exitFailure},
+		{"voided sale", true, nil, exitFailure},
+		{"voided sale with override", true, []string{"-override"}, exitOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useConfiguredWorkDir(t)
+			fake := useStripe(t)
+			id := paidIntent(t, fake, 450)
+			sale := logSale(t, id, 4.50)
+			if tt.voided {
+				if err := services.SaveVoidTransaction(&sale, "Rung up twice", "alice"); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			// Later flags win, so a case's flags replace the defaults
+			args := append([]string{"resend-receipt", "-id", id, "-email", "bob@example.com"}, tt.args...)
+			code, stdout, stderr := runCommand(t, args...)
+
+			if code != tt.wantCode {
+				t.Fatalf("exit code = %d, want %d; stderr: %s", code, tt.wantCode, stderr)
+			}
+			sent := fake.Intent(id).ReceiptEmail == "bob@example.com"
+			if tt.wantCode == exitOK && (!sent || stdout != "Receipt sent by email\n") {
+				t.Errorf("printed %q, Stripe receipt email set: %v; want the receipt sent", stdout, sent)
+			}
+			if tt.wantCode != exitOK && (sent || stdout != "") {
+				t.Errorf("printed %q, Stripe receipt email set: %v; want nothing sent", stdout, sent)
+			}
+		})
+	}
+}
@@ -0,0 +1,118 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+// useBusinessHours sets the business timezone and times for the test
+func useBusinessHours(t *testing.T, timezone, dayClose, start, end string) *time.Location {
+	t.Helper()
+	saved := Config
+	Config.BusinessTimezone = timezone
+	Config.BusinessDayClose = dayClose
+	Config.BusinessHoursStart = start
+	Config.BusinessHoursEnd = end
+	t.Cleanup(func() { Config = saved })
+
+	location, err := time.LoadLocation(timezone)
+	if err != nil {
+		t.Skipf("timezone data unavailable: %v", err)
+	}
+	return location
+}
+
+// A sale finished after midnight but before the day closes belongs to the night before
+func TestBusinessDay(t *testing.T) {
+	newYork := useBusinessHours(t, "America/New_York", "03:00", "", "")
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2026, time.March, day, hour, minute, 0, 0, newYork)
+	}
+	tests := []struct {
+		name     string
+		dayClose string
+		at       time.Time
+		want     string
+	}{
+		{"evening", "03:00", at(13, 21, 30), "2026-03-13"},
+		{"last minute before midnight", "03:00", at(13, 23, 59), "2026-03-13"},
+		{"midnight", "03:00", at(14, 0, 0), "2026-03-13"},
+		{"QR paid just after midnight", "03:00", at(14, 0, 3), "2026-03-13"},
+		{"last minute before close", "03:00", at(14, 2, 59), "2026-03-13"},
+		{"close", "03:00", at(14, 3, 0), "2026-03-14"},
+		{"morning", "03:00", at(14, 9, 0), "2026-03-14"},
+		{"across the month", "03:00", time.Date(2026, time.April, 1, 1, 0, 0, 0, newYork), "2026-03-31"},
+		{"in UTC but after midnight in the business timezone", "03:00", time.Date(2026, time.March, 14, 5, 30, 0, 0, time.UTC), "2026-03-13"},
+		{"in UTC the next day but before midnight in the business timezone", "03:00", time.Date(2026, time.March, 14, 3, 30, 0, 0, time.UTC), "2026-03-13"},
+		{"without a close time the day ends at midnight", "", at(14, 0, 3), "2026-03-14"},
+		{"invalid close time ends the day at midnight", "3am", at(14, 0, 3), "2026-03-14"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			Config.BusinessDayClose = tt.dayClose
+
+			day := BusinessDay(tt.at)
+
+			if got := day.Format("2006-01-02"); got != tt.want {
+				t.Errorf("BusinessDay(%s) = %s, want %s", tt.at.In(newYork).Format(time.DateTime), got, tt.want)
+			}
+			if day.Hour() != 0 || day.Minute() != 0 || day.Location().String() != newYork.String() {
+				t.Errorf("BusinessDay(%s) = %s, want midnight in the business timezone", tt.at, day)
+			}
+		})
+	}
+}
+
+func TestBusinessDayStart(t *testing.T) {
+	newYork := useBusinessHours(t, "America/New_York", "03:00", "", "")
+	day := time.Date(2026, time.March, 13, 0, 0, 0, 0, newYork)
+
+	start := BusinessDayStart(day)
+
+	if want := time.Date(2026, time.March, 13, 3, 0, 0, 0, newYork); !start.Equal(want) {
+		t.Errorf("BusinessDayStart = %s, want %s", start, want)
+	}
+	// Every minute of the business day maps back to it, up to the next day's start
+	next := BusinessDayStart(day.AddDate(0, 0, 1))
+	for at := start; at.Before(next); at = at.Add(time.Hour) {
+		if got := BusinessDay(at); !got.Equal(day) {
+			t.Errorf("BusinessDay(%s) = %s, want %s", at, got, day)
+		}
+	}
+	if got := BusinessDay(next); got.Equal(day) {
+		t.Errorf("next day's start %s still in %s", next, day)
+	}
+}
+
+func TestWithinBusinessHours(t *testing.T) {
+	newYork := useBusinessHours(t, "America/New_York", "", "", "")
+	at := func(hour, minute int) time.Time { return time.Date(2026, time.March, 13, hour, minute, 0, 0, newYork) }
+	tests := []struct {
+		name       string
+		start, end string
+		at         time.Time
+		want       bool
+	}{
+		{"open", "09:00", "17:00", at(12, 0), true},
+		{"opening minute", "09:00", "17:00", at(9, 0), true},
+		{"closing minute", "09:00", "17:00", at(17, 0), false},
+		{"before opening", "09:00", "17:00", at(8, 59), false},
+		{"overnight, before midnight", "18:00", "02:00", at(23, 0), true},
+		{"overnight, after midnight", "18:00", "02:00", at(1, 30), true},
+		{"overnight, after closing", "18:00", "02:00", at(2, 0), false},
+		{"overnight, afternoon", "18:00", "02:00", at(15, 0), false},
+		{"checked in the business timezone", "09:00", "17:00", time.Date(2026, time.March, 13, 20, 0, 0, 0, time.UTC), true},
+		{"no hours set", "", "", at(3, 0), true},
+		{"only a start", "09:00", "", at(3, 0), true},
+		{"invalid time", "9am", "17:00", at(3, 0), true},
+		{"same start and end", "09:00", "09:00", at(3, 0), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			Config.BusinessHoursStart, Config.BusinessHoursEnd = tt.start, tt.end
+			if got := WithinBusinessHours(tt.at); got != tt.want {
+				t.Errorf("WithinBusinessHours(%s) with hours %s-%s = %v, want %v", tt.at.In(newYork).Format("15:04"), tt.start, tt.end, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,142 @@
+package config
+
+import (
+	"fmt"
+	"maps"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"checkout/i18n"
+	"checkout/templates"
+	"checkout/utils"
+)
+
+// Check validates the loaded configuration without contacting Stripe or changing anything, and
+// returns a description of each problem found. It applies the checks the settings page applies
+// to each value, for configuration files edited by hand.
+func Check() []string {
+	var problems []string
+	addf := func(format string, args ...any) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if GetStripeKey() == "" && !Config.DemoMode {
+		addf("no Stripe secret key: set stripeSecretKey or the STRIPE_SECRET_KEY environment variable")
+	}
+
+	// Someone has to be able to log in, and someone has to be able to change the settings
+	hasAdmin := false
+	for _, user := range Config.Users {
+		if user.Role != templates.RoleAdmin && user.Role != templates.RoleCashier {
+			addf("user %s has unknown role %q", user.Username, user.Role)
+		}
+		if user.PasswordHash == "" {
+			addf("user %s has no password", user.Username)
+		}
+		hasAdmin = hasAdmin || user.Role == templates.RoleAdmin
+	}
+	if !hasAdmin {
+		addf("no admin user: add one with -add-user <name> -role admin")
+	}
+
+	// Numbers against the limits of their settings
+	for _, section := range GetSettingSections() {
+		for _, field := range section.Fields {
+			if field.Type != "number" {
+				continue
+			}
+			// Zero is left out of the file and means the default
+			number, err := strconv.ParseFloat(field.Value, 64)
+			if err != nil || number == 0 {
+				continue
+			}
+			if err := validateSettingNumber(field, number); err != nil {
+				addf("%v", err)
+			}
+		}
+	}
+
+	if _, err := utils.ParseLogLevel(Config.LogLevel); err != nil {
+		addf("logLevel: %v", err)
+	}
+	if Config.BusinessTimezone != "" {
+		if _, err := time.LoadLocation(Config.BusinessTimezone); err != nil {
+			addf("businessTimezone: %v", err)
+		}
+	}
+	if Config.Locale != "" && !i18n.IsSupported(Config.Locale) {
+		addf("locale: language must be one of %s", strings.Join(i18n.Locales(), ", "))
+	}
+	for _, setting := range []struct{ name, value string }{
+		{"businessHoursStart", Config.BusinessHoursStart},
+		{"businessHoursEnd", Config.BusinessHoursEnd},
+		{"businessDayClose", Config.BusinessDayClose},
+		{"dailyReportTime", Config.DailyReportTime},
+		{"reconciliationTime", Config.ReconciliationTime},
+	} {
+		if _, err := time.Parse("15:04", setting.value); setting.value != "" && err != nil {
+			addf("%s: time must be HH:MM, e.g. 09:00", setting.name)
+		}
+	}
+
+	if err := ValidateTaxMode(Config.TaxMode); err != nil {
+		addf("taxMode: %v", err)
+	}
+	for _, locationID := range slices.Sorted(maps.Keys(Config.TaxModeLocationOverrides)) {
+		if err := ValidateTaxMode(Config.TaxModeLocationOverrides[locationID]); err != nil {
+			addf("taxModeLocationOverrides[%s]: %v", locationID, err)
+		}
+	}
+	if err := ValidateServiceFee(Config.ServiceFeeMode, Config.ServiceFeeAmount, Config.ServiceFeeMaxPercent); err != nil {
+		addf("serviceFeeMode: %v", err)
+	}
+	if _, err := ParseServiceFeeMethods(strings.Join(Config.ServiceFeeMethods, ",")); err != nil {
+		addf("serviceFeeMethods: %v", err)
+	}
+	if _, err := ParseTerminalPaymentMethodTypes(strings.Join(Config.TerminalPaymentMethodTypes, ",")); err != nil {
+		addf("terminalPaymentMethodTypes: %v", err)
+	}
+	if err := ValidateStatementDescriptorSuffix(Config.StatementDescriptorSuffix); err != nil {
+		addf("statementDescriptorSuffix: %v", err)
+	}
+	if _, err := ParseTipPresets(FormatTipPresets(Config.TippingPresetPercentages)); err != nil {
+		addf("tippingPresetPercentages: %v", err)
+	}
+	if _, err := ParseEmailTypoDomains(Config.EmailTypoDomains); err != nil {
+		addf("emailTypoDomains: %v", err)
+	}
+	if months := Config.RetentionMonths; months < 0 || (months > 0 && months < MinRetentionMonths) {
+		addf("retentionMonths: retention must be 0 (never delete) or at least %d months", MinRetentionMonths)
+	}
+
+	targets := GetOrderWebhookURLs()
+	if Config.UpdateCheckURL != "" {
+		targets = append(targets, Config.UpdateCheckURL)
+	}
+	for _, target := range targets {
+		if parsed, err := url.Parse(target); err != nil || (parsed.Scheme != "https" && parsed.Scheme != "http") || parsed.Host == "" {
+			addf("%q is not an http or https URL", target)
+		}
+	}
+
+	// Vendor payouts go to connected accounts, which a typo would send to the business instead
+	seen := make(map[string]bool)
+	for _, vendor := range Config.Vendors {
+		if vendor.ID == "" {
+			addf("vendor %q has no ID", vendor.Name)
+			continue
+		}
+		if seen[vendor.ID] {
+			addf("vendor ID %s is used more than once", vendor.ID)
+		}
+		seen[vendor.ID] = true
+		if !strings.HasPrefix(vendor.AccountID, "acct_") {
+			addf("vendor %s has no Stripe connected account (acct_...)", vendor.ID)
+		}
+	}
+
+	return problems
+}
@@ -3,14 +3,20 @@ package config
 import (
 	"bufio"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log/slog"
+	"net/url"
 	"os"
 	"path/filepath"
 	"reflect"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
+	"unicode"
 
+	"checkout/i18n"
 	"checkout/templates"
 	"checkout/utils"
 )
@@ -39,35 +45,90 @@ const (
 	// If SSE doesn't send completion event, client triggers hard refresh
 	PaymentFailsafeTimeout = (120 + 3) * time.Second
 
+	// Default window after a sale during which it can be voided
+	DefaultVoidWindowMinutes = 30
+
+	// Default time webhook payment states stay cached
+	DefaultWebhookCacheTTLMinutes = 15
+
+	// Default time a payment waits for a webhook before its status is fetched from Stripe
+	DefaultWebhookFallbackSeconds = 30
+
+	// Default time an untouched cart is kept before it is cleared
+	DefaultCartIdleTimeoutMinutes = 15
+
+	// Default time between checks that the selected reader is reachable
+	DefaultReaderKeepAliveMinutes = 10
+
+	// Default time a reader can go without answering before it is marked degraded
+	DefaultReaderDegradedAfterMinutes = 20
+
+	// Default age of a webhook signature timestamp before the event is rejected (Stripe's default)
+	DefaultWebhookToleranceSeconds = 300
+
+	// Default attempts at delivering an order webhook event before it is dead-lettered
+	DefaultOrderWebhookMaxAttempts = 8
+
+	// Default wrong PINs allowed when switching users before a password login is required
+	DefaultPINMaxAttempts = 5
+
+	// Default time a payment link sent to a customer stays payable
+	DefaultSentLinkExpiryHours = 72
+
+	// Default number of products a register can star
+	DefaultMaxFavorites = 8
+
+	// Default decimal places of a measured quantity, e.g. 1.25 lb
+	DefaultQuantityDecimals = 2
+
+	// Default highest price of a custom item rung up at the register
+	DefaultCustomItemMaxPrice = 10000
+
+	// Default age at which transaction files are compressed into monthly archives
+	DefaultArchiveAfterMonths = 18
+
+	// Shortest retention period after which archived records may be deleted
+	MinRetentionMonths = 12
+
+	// Default log file rotation
+	DefaultLogMaxSizeMB = 10
+	DefaultLogMaxFiles  = 5
+
+	// Longest statement descriptor suffix Stripe accepts
+	MaxStatementDescriptorSuffix = 22
+
+	// Most preset tip buttons shown on screen
+	MaxTipPresets = 6
+
 	// Payment status endpoints
 	PollEndpoint          = "/get-payment-status"
 	CancelRefreshEndpoint = "/cancel-or-refresh-payment"
 )
 
-// PaymentProgressMessages provides consistent status messages
+// PaymentProgressMessages maps each payment type and status to the key of its message
 var PaymentProgressMessages = map[string]map[string]string{
 	"qr": {
-		"default":    "Waiting for QR code scan...",
-		"processing": "Processing QR payment...",
-		"scanning":   "Please scan the QR code with your camera app",
+		"default":    "payment.qr.default",
+		"processing": "payment.qr.processing",
+		"scanning":   "payment.qr.scanning",
 	},
 	"terminal": {
-		"default":    "Processing on terminal...",
-		"processing": "Please complete the transaction on the payment terminal",
-		"waiting":    "Waiting for terminal interaction...",
-		"receipt":    "Please take your receipt from the terminal",
+		"default":    "payment.terminal.default",
+		"processing": "payment.terminal.processing",
+		"waiting":    "payment.terminal.waiting",
+		"receipt":    "payment.terminal.receipt",
 	},
 }
 
-// GetPaymentMessage retrieves the appropriate message for a payment type and status
+// GetPaymentMessage retrieves the appropriate message for a payment type and status in the configured language
 func GetPaymentMessage(paymentType, status string) string {
 	if messages, exists := PaymentProgressMessages[paymentType]; exists {
 		if message, exists := messages[status]; exists {
-			return message
+			return i18n.T(message)
 		}
-		return messages["default"]
+		return i18n.T(messages["default"])
 	}
-	return "Processing payment..."
+	return i18n.T("payment.default")
 }
 
 // GetPaymentTimeoutSeconds returns the payment timeout as an integer (for JavaScript/templates)
@@ -82,6 +143,13 @@ func GetFailsafeTimeoutSeconds() int {
 
 // GetCommunicationStrategy determines whether to use polling or webhooks
 func GetCommunicationStrategy() string {
+	// Simulated payments never produce webhooks
+	if Config.DemoMode {
+		return "polling"
+	}
+	if WebhookDevForwarding() {
+		return "webhooks"
+	}
 	websiteName := strings.TrimSpace(Config.WebsiteName)
 	if websiteName != "" && websiteName != "localhost" {
 		return "webhooks"
@@ -89,46 +157,39 @@ func GetCommunicationStrategy() string {
 	return "polling"
 }
 
+// WebhookDevForwarding reports whether a POS on localhost takes webhooks forwarded by
+// `stripe listen --forward-to`, turned on with Force Webhook Strategy or FORCE_WEBHOOK_STRATEGY=true.
+// With a real website name the setting changes nothing, as webhooks are used anyway.
+func WebhookDevForwarding() bool {
+	force := Config.ForceWebhookStrategy
+	if value := os.Getenv("FORCE_WEBHOOK_STRATEGY"); value != "" {
+		force, _ = strconv.ParseBool(value)
+	}
+	websiteName := strings.TrimSpace(Config.WebsiteName)
+	return force && (websiteName == "" || websiteName == "localhost")
+}
+
 // Config holds the application configuration
 var Config templates.AppConfig
 
-// Load loads the application configuration from file or prompts user to create it
+// ErrNoConfig is returned by Load when there is no configuration file yet
+var ErrNoConfig = errors.New("configuration file not found")
+
+// Load loads the application configuration from file. It never prompts, so it can run from
+// cron and scripts; a missing file returns ErrNoConfig, and Init creates one interactively.
 func Load() error {
 	configPath := filepath.Join(DefaultDataDir, "config.json")
 
-	// Create data directory if it doesn't exist
-	if err := os.MkdirAll(DefaultDataDir, 0755); err != nil {
-		return fmt.Errorf("failed to create data directory: %w", err)
-	}
-
 	// Check if config file exists
 	if _, err := os.Stat(configPath); os.IsNotExist(err) {
-		utils.Info("config", "Configuration file not found", "config_path", configPath)
-		// Config file doesn't exist, ask user if they want to create it
-		fmt.Print("Would you like to create a configuration file? (y/n): ")
-		reader := bufio.NewReader(os.Stdin)
-		response, err := reader.ReadString('\n')
-		if err != nil {
-			return fmt.Errorf("error reading input: %w", err)
-		}
-
-		response = strings.TrimSpace(strings.ToLower(response))
-		if response != "y" && response != "yes" {
-			return fmt.Errorf("configuration file required to run the application")
-		}
-
-		// Prompt for configuration values
-		if err := promptForConfig(); err != nil {
-			return fmt.Errorf("error creating configuration: %w", err)
+		// Leave an example next to it for hand editing
+		if err := os.MkdirAll(DefaultDataDir, 0755); err != nil {
+			return fmt.Errorf("failed to create data directory: %w", err)
 		}
-
-		// Save configuration to file
-		if err := saveConfig(configPath); err != nil {
-			return fmt.Errorf("error saving configuration: %w", err)
+		if err := WriteDefaultFile("config.example.json", filepath.Join(DefaultDataDir, "config.example.json")); err != nil {
+			utils.Warn("config", "Could not write example configuration", "error", err)
 		}
-
-		utils.Info("config", "Configuration file created successfully", "config_path", configPath)
-		return nil
+		return fmt.Errorf("%w: %s (run \"checkout init\" to create it, or copy config.example.json)", ErrNoConfig, configPath)
 	} else if err != nil {
 		return fmt.Errorf("error checking configuration file: %w", err)
 	}
@@ -139,7 +200,14 @@ func Load() error {
 		return fmt.Errorf("error reading configuration file: %w", err)
 	}
 
-	// Parse config
+	// Parse config; fields with a non-zero default keep it when the file doesn't set them
+	Config.CartIdleTimeoutMinutes = DefaultCartIdleTimeoutMinutes
+	Config.ReaderKeepAliveMinutes = DefaultReaderKeepAliveMinutes
+	Config.SentLinkExpiryHours = DefaultSentLinkExpiryHours
+	Config.ArchiveAfterMonths = DefaultArchiveAfterMonths
+	Config.MaxFavorites = DefaultMaxFavorites
+	Config.CustomItemMaxPrice = DefaultCustomItemMaxPrice
+	Config.QuantityDecimals = DefaultQuantityDecimals
 	if err := json.Unmarshal(data, &Config); err != nil {
 		return fmt.Errorf("error parsing configuration file: %w", err)
 	}
@@ -155,6 +223,17 @@ func Load() error {
 		Config.TransactionsDir = DefaultTransactionsDir
 	}
 
+	i18n.SetLocale(Config.Locale)
+
+	// Configs from before user accounts have one shared password
+	if migrated, err := migratePasswordToUsers(); err != nil {
+		return err
+	} else if migrated {
+		if err := saveConfig(configPath); err != nil {
+			return fmt.Errorf("error saving configuration: %w", err)
+		}
+	}
+
 	// Override with environment variable if available
 	envStripeKey := os.Getenv("STRIPE_SECRET_KEY")
 	if envStripeKey != "" && envStripeKey != Config.StripeSecretKey {
@@ -172,51 +251,75 @@ func Load() error {
 	return nil
 }
 
+// Init creates the configuration file by prompting for its values on the terminal. It refuses
+// to replace a configuration file that already exists.
+func Init() error {
+	configPath := filepath.Join(DefaultDataDir, "config.json")
+	if _, err := os.Stat(configPath); err == nil {
+		return fmt.Errorf("configuration file %s already exists; edit it or use the settings page", configPath)
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("error checking configuration file: %w", err)
+	}
+
+	// Create data directory if it doesn't exist
+	if err := os.MkdirAll(DefaultDataDir, 0755); err != nil {
+		return fmt.Errorf("failed to create data directory: %w", err)
+	}
+
+	// Prompt for configuration values
+	if err := promptForConfig(); err != nil {
+		return fmt.Errorf("error creating configuration: %w", err)
+	}
+
+	// Save configuration to file
+	if err := saveConfig(configPath); err != nil {
+		return fmt.Errorf("error saving configuration: %w", err)
+	}
+
+	utils.Info("config", "Configuration file created successfully", "config_path", configPath)
+	return nil
+}
+
 // promptForConfig prompts the user for configuration values
 func promptForConfig() error {
 	reader := bufio.NewReader(os.Stdin)
 
 	// Initialize config with defaults
 	Config = templates.AppConfig{
-		Port:            DefaultPort,
-		DataDir:         DefaultDataDir,
-		TransactionsDir: DefaultTransactionsDir,
+		Port:                   DefaultPort,
+		DataDir:                DefaultDataDir,
+		TransactionsDir:        DefaultTransactionsDir,
+		CartIdleTimeoutMinutes: DefaultCartIdleTimeoutMinutes,
+		ReaderKeepAliveMinutes: DefaultReaderKeepAliveMinutes,
+		SentLinkExpiryHours:    DefaultSentLinkExpiryHours,
+		ArchiveAfterMonths:     DefaultArchiveAfterMonths,
+		MaxFavorites:           DefaultMaxFavorites,
+		CustomItemMaxPrice:     DefaultCustomItemMaxPrice,
+		QuantityDecimals:       DefaultQuantityDecimals,
 	}
 
-	// Password (prompt first for security)
+	// Admin password (prompt first for security)
 	var password string
-	valid := false
-	for !valid {
-		fmt.Print("Enter Admin Password (min 8 characters): ")
+	for {
+		fmt.Printf("Enter Admin Password (min %d characters): ", MinPasswordLength)
 		passwordInput, err := reader.ReadString('\n')
 		if err != nil {
 			return err
 		}
 
 		password = sanitizeInput(strings.TrimSpace(passwordInput))
-		if len(password) < 8 {
-			fmt.Println("Password must be at least 8 characters long. Please try again.")
-			continue
-		}
-
-		// Check for valid characters (letters, numbers, and printable symbols)
-		validChars := true
-		for _, char := range password {
-			// ASCII printable range (32-126) includes letters, numbers, and symbols
-			if char < 32 || char > 126 {
-				validChars = false
-				break
-			}
-		}
-
-		if !validChars {
-			fmt.Println("Password contains invalid characters. Please use only letters, numbers, and keyboard symbols.")
+		if err := ValidatePassword(password); err != nil {
+			fmt.Printf("Invalid password: %v. Please try again.\n", err)
 			continue
 		}
-
-		valid = true
+		break
 	}
-	Config.Password = password
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+	Config.Users = []templates.User{{Username: "admin", PasswordHash: hash, Role: templates.RoleAdmin}}
+	fmt.Println("Created user \"admin\". Add cashiers with -add-user.")
 
 	// Stripe Keys
 	fmt.Print("Enter Stripe Secret Key: ")
@@ -475,14 +578,27 @@ func saveConfig(path string) error {
 		return fmt.Errorf("error marshaling configuration: %w", err)
 	}
 
-	// Write to file
-	if err := os.WriteFile(path, data, 0600); err != nil {
+	// Write to a temporary file and rename it over the old one, so a crash never leaves half a config
+	tempPath := path + ".tmp"
+	if err := os.WriteFile(tempPath, data, 0600); err != nil {
 		return fmt.Errorf("error writing configuration file: %w", err)
 	}
+	if err := os.Rename(tempPath, path); err != nil {
+		os.Remove(tempPath)
+		return fmt.Errorf("error replacing configuration file: %w", err)
+	}
 
 	return nil
 }
 
+// SetDataDirs points the POS at moved data directories (see services.MigrateData)
+func SetDataDirs(dataDir, transactionsDir string) error {
+	Config.DataDir = dataDir
+	Config.TransactionsDir = transactionsDir
+	configPath := filepath.Join(DefaultDataDir, "config.json")
+	return saveConfig(configPath)
+}
+
 // GetStripeKey returns the Stripe secret key from config or environment
 func GetStripeKey() string {
 	// First try environment variable
@@ -512,15 +628,22 @@ func GetStripePublicKey() string {
 	return ""
 }
 
-// GetStripeWebhookSecret returns the webhook secret from config or environment
-func GetStripeWebhookSecret() string {
-	// First try environment variable
-	if secret := os.Getenv("STRIPE_WEBHOOK_SECRET"); secret != "" {
-		return secret
+// GetStripeWebhookSecrets returns the webhook signing secrets from environment or config.
+// Several can be given comma-separated, so a new secret is accepted alongside the old one while rotating.
+func GetStripeWebhookSecrets() []string {
+	// First try environment variable, then config
+	value := os.Getenv("STRIPE_WEBHOOK_SECRET")
+	if value == "" {
+		value = Config.StripeWebhookSecret
 	}
 
-	// Then try config
-	return Config.StripeWebhookSecret
+	var secrets []string
+	for _, secret := range strings.Split(value, ",") {
+		if secret = strings.TrimSpace(secret); secret != "" {
+			secrets = append(secrets, secret)
+		}
+	}
+	return secrets
 }
 
 // SetTippingLocationOverride sets a location-specific tipping override
@@ -547,6 +670,21 @@ func RemoveTippingLocationOverride(locationID string) error {
 	return saveConfig(configPath)
 }
 
+// SetReceiptLogo records the file name of the uploaded receipt logo ("" when removed)
+func SetReceiptLogo(filename string) error {
+	Config.ReceiptLogo = filename
+	configPath := filepath.Join(DefaultDataDir, "config.json")
+	return saveConfig(configPath)
+}
+
+// GetReceiptFooter returns the message printed at the bottom of receipts
+func GetReceiptFooter() string {
+	if footer := strings.TrimSpace(Config.ReceiptFooter); footer != "" {
+		return footer
+	}
+	return "Thank you!"
+}
+
 // GetTippingEnabledForLocation returns whether tipping is enabled for a specific location
 func GetTippingEnabledForLocation(locationID string) bool {
 	// Check for location-specific override first
@@ -572,52 +710,474 @@ func GetTippingConfig(locationID string) (bool, float64, float64, bool) {
 	return tippingEnabled, minAmount, maxAmount, allowCustom
 }
 
-// IsSMSEnabled returns true if AWS SNS is configured for SMS receipts
-func IsSMSEnabled() bool {
-	return Config.AWSAccessKeyID != "" && Config.AWSSecretAccessKey != "" && Config.AWSRegion != ""
+// GetVoidWindow returns how long after a sale it can still be voided
+func GetVoidWindow() time.Duration {
+	minutes := Config.VoidWindowMinutes
+	if minutes <= 0 {
+		minutes = DefaultVoidWindowMinutes
+	}
+	return time.Duration(minutes) * time.Minute
+}
+
+// GetWebhookCacheTTL returns how long a payment state reported by a webhook stays cached
+func GetWebhookCacheTTL() time.Duration {
+	minutes := Config.WebhookCacheTTLMinutes
+	if minutes <= 0 {
+		minutes = DefaultWebhookCacheTTLMinutes
+	}
+	return time.Duration(minutes) * time.Minute
+}
+
+// GetWebhookFallbackDelay returns how long a payment can go without a webhook update
+// before its status is checked with the Stripe API in webhook mode
+func GetWebhookFallbackDelay() time.Duration {
+	seconds := Config.WebhookFallbackSeconds
+	if seconds <= 0 {
+		seconds = DefaultWebhookFallbackSeconds
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// GetWebhookSilenceLimit returns how long the webhook endpoint can go without an event before
+// it is reported as degraded (0 = never)
+func GetWebhookSilenceLimit() time.Duration {
+	if Config.WebhookSilenceMinutes <= 0 {
+		return 0
+	}
+	return time.Duration(Config.WebhookSilenceMinutes) * time.Minute
+}
+
+// GetWebhookTolerance returns how far a webhook's signature timestamp may be from the local clock
+func GetWebhookTolerance() time.Duration {
+	seconds := Config.WebhookToleranceSeconds
+	if seconds <= 0 {
+		seconds = DefaultWebhookToleranceSeconds
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// GetCartIdleTimeout returns how long the cart can sit unchanged before it is cleared (0 = never)
+func GetCartIdleTimeout() time.Duration {
+	if Config.CartIdleTimeoutMinutes <= 0 {
+		return 0
+	}
+	return time.Duration(Config.CartIdleTimeoutMinutes) * time.Minute
+}
+
+// GetAutoLockTimeout returns how long a session can go without a change before its screen locks (0 = never)
+func GetAutoLockTimeout() time.Duration {
+	if Config.AutoLockMinutes <= 0 {
+		return 0
+	}
+	return time.Duration(Config.AutoLockMinutes) * time.Minute
+}
+
+// CheckoutFlow is what the payment success screen does after a sale
+type CheckoutFlow struct {
+	AutoCloseSeconds     int  // Seconds before the screen closes for the next sale (0 = until closed)
+	AutoPrintReceipt     bool // Open the receipt with its print dialog
+	AutoOpenTicket       bool // Open the fulfillment ticket for printing (only with fulfillment tickets on)
+	ReceiptFormCollapsed bool // Fold the receipt form away behind a button
+}
+
+// GetCheckoutFlow returns the Checkout Flow settings. The defaults are a success screen that stays
+// open until closed, with the receipt form shown and the fulfillment ticket (if used) opened.
+func GetCheckoutFlow() CheckoutFlow {
+	autoClose := Config.SuccessAutoCloseSeconds
+	if autoClose < 0 {
+		autoClose = 0
+	}
+	return CheckoutFlow{
+		AutoCloseSeconds:     autoClose,
+		AutoPrintReceipt:     Config.AutoPrintReceipt,
+		AutoOpenTicket:       Config.FulfillmentTickets && !Config.TicketOpenManually,
+		ReceiptFormCollapsed: Config.CollapseReceiptForm,
+	}
+}
+
+// GetPINMaxAttempts returns how many wrong PINs a register can enter when switching users, or
+// wrong passwords and PINs on the lock screen, before it needs a full password login
+func GetPINMaxAttempts() int {
+	if Config.PINMaxAttempts <= 0 {
+		return DefaultPINMaxAttempts
+	}
+	return Config.PINMaxAttempts
+}
+
+// GetReaderKeepAliveInterval returns how often the selected reader is checked (0 = never)
+func GetReaderKeepAliveInterval() time.Duration {
+	if Config.ReaderKeepAliveMinutes <= 0 {
+		return 0
+	}
+	return time.Duration(Config.ReaderKeepAliveMinutes) * time.Minute
+}
+
+// GetSentLinkExpiry returns how long a payment link sent to a customer stays payable (0 = until cancelled)
+func GetSentLinkExpiry() time.Duration {
+	if Config.SentLinkExpiryHours <= 0 {
+		return 0
+	}
+	return time.Duration(Config.SentLinkExpiryHours) * time.Hour
+}
+
+// GetReaderDegradedAfter returns how long a reader can go without answering before it is degraded
+func GetReaderDegradedAfter() time.Duration {
+	minutes := Config.ReaderDegradedAfterMinutes
+	if minutes <= 0 {
+		minutes = DefaultReaderDegradedAfterMinutes
+	}
+	return time.Duration(minutes) * time.Minute
+}
+
+// WithinBusinessHours reports whether t falls between the configured business hours in the
+// business timezone. Hours that end before they start run past midnight. Without both times,
+// with an invalid one, or with the same start and end, every time is within business hours.
+func WithinBusinessHours(t time.Time) bool {
+	start, errStart := time.Parse("15:04", Config.BusinessHoursStart)
+	end, errEnd := time.Parse("15:04", Config.BusinessHoursEnd)
+	if errStart != nil || errEnd != nil || start.Equal(end) {
+		return true
+	}
+
+	local := t.In(GetBusinessLocation())
+	minute := local.Hour()*60 + local.Minute()
+	from := start.Hour()*60 + start.Minute()
+	to := end.Hour()*60 + end.Minute()
+	if from <= to {
+		return minute >= from && minute < to
+	}
+	return minute >= from || minute < to
+}
+
+// BusinessDay returns the business day t falls in, as midnight of its date in the business
+// timezone. Times after midnight and before the business day close time belong to the day before.
+func BusinessDay(t time.Time) time.Time {
+	local := t.In(GetBusinessLocation())
+	day := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, local.Location())
+	if dayClose, err := time.Parse("15:04", Config.BusinessDayClose); err == nil {
+		if local.Hour()*60+local.Minute() < dayClose.Hour()*60+dayClose.Minute() {
+			day = day.AddDate(0, 0, -1)
+		}
+	}
+	return day
+}
+
+// BusinessDayStart returns when the business day of day's date starts: midnight, or the
+// business day close time, in the business timezone
+func BusinessDayStart(day time.Time) time.Time {
+	local := day.In(GetBusinessLocation())
+	start := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, local.Location())
+	if dayClose, err := time.Parse("15:04", Config.BusinessDayClose); err == nil {
+		start = time.Date(local.Year(), local.Month(), local.Day(), dayClose.Hour(), dayClose.Minute(), 0, 0, local.Location())
+	}
+	return start
+}
+
+// SupportedTerminalPaymentMethodTypes are the payment method types a Stripe Terminal reader can collect
+var SupportedTerminalPaymentMethodTypes = []string{"card_present", "interac_present"}
+
+// DefaultTerminalPaymentMethodTypes is used when no terminal payment method types are configured
+var DefaultTerminalPaymentMethodTypes = []string{"card_present"}
+
+// GetTerminalPaymentMethodTypes returns the payment method types for terminal PaymentIntents
+func GetTerminalPaymentMethodTypes() []string {
+	if len(Config.TerminalPaymentMethodTypes) == 0 {
+		return DefaultTerminalPaymentMethodTypes
+	}
+	return Config.TerminalPaymentMethodTypes
+}
+
+// ParseTerminalPaymentMethodTypes parses a comma-separated list of terminal payment method types
+// (e.g. "card_present, interac_present"). Each must be one Stripe Terminal supports.
+func ParseTerminalPaymentMethodTypes(value string) ([]string, error) {
+	types := []string{}
+	seen := make(map[string]bool)
+	for _, part := range strings.Split(value, ",") {
+		paymentType := strings.ToLower(strings.TrimSpace(part))
+		if paymentType == "" || seen[paymentType] {
+			continue
+		}
+		if !slices.Contains(SupportedTerminalPaymentMethodTypes, paymentType) {
+			return nil, fmt.Errorf("unsupported terminal payment method type %q (supported: %s)",
+				paymentType, strings.Join(SupportedTerminalPaymentMethodTypes, ", "))
+		}
+		seen[paymentType] = true
+		types = append(types, paymentType)
+	}
+	return types, nil
+}
+
+// Tax modes
+const (
+	TaxModeLocal     = "local"      // Tax is calculated from the rates in settings
+	TaxModeStripeTax = "stripe_tax" // Tax is calculated by Stripe Tax
+)
+
+// GetTaxMode returns how tax is calculated at a Stripe Terminal Location: its override if it
+// has one, otherwise the configured mode
+func GetTaxMode(locationID string) string {
+	if mode, exists := Config.TaxModeLocationOverrides[locationID]; exists {
+		return mode
+	}
+	if Config.TaxMode == "" {
+		return TaxModeLocal
+	}
+	return Config.TaxMode
 }
 
-// GetConfigFields returns config fields with their metadata for template generation
-func GetConfigFields() map[string][]map[string]interface{} {
-	return map[string][]map[string]interface{}{
-		"stripe": {
-			{"name": "StripeSecretKey", "label": "Stripe Secret Key", "type": "password", "id": "stripe-secret-key", "value": Config.StripeSecretKey},
-			{"name": "StripePublicKey", "label": "Stripe Public Key", "type": "text", "id": "stripe-public-key", "value": Config.StripePublicKey},
-			{"name": "StripeWebhookSecret", "label": "Stripe Webhook Secret", "type": "password", "id": "stripe-webhook-secret", "value": Config.StripeWebhookSecret},
-			{"name": "StripeTerminalLocationID", "label": "Terminal Location", "type": "text", "id": "stripe-terminal-location", "value": Config.StripeTerminalLocationID},
-		},
-		"business": {
-			{"name": "BusinessName", "label": "Business Name", "type": "text", "id": "business-name", "value": Config.BusinessName},
-			{"name": "BusinessStreet", "label": "Street Address", "type": "text", "id": "business-street", "value": Config.BusinessStreet},
-			{"name": "BusinessCity", "label": "City", "type": "text", "id": "business-city", "value": Config.BusinessCity},
-			{"name": "BusinessState", "label": "State", "type": "text", "id": "business-state", "value": Config.BusinessState},
-			{"name": "BusinessZIP", "label": "ZIP Code", "type": "text", "id": "business-zip", "value": Config.BusinessZIP},
-		},
-		"tax": {
-			{"name": "BusinessTaxID", "label": "Business Tax ID", "type": "text", "id": "business-tax-id", "value": Config.BusinessTaxID},
-			{"name": "SalesTaxNumber", "label": "Sales Tax Number", "type": "text", "id": "sales-tax-number", "value": Config.SalesTaxNumber},
-			{"name": "VATNumber", "label": "VAT Number", "type": "text", "id": "vat-number", "value": Config.VATNumber},
-			{"name": "DefaultTaxRate", "label": "Default Tax Rate", "type": "number", "id": "default-tax-rate", "value": Config.DefaultTaxRate * 100, "step": "0.0001", "min": "0", "max": "100"},
-		},
-		"system": {
-			{"name": "ServerAddress", "label": "Server Address", "type": "text", "id": "server-address", "value": Config.ServerAddress},
-			{"name": "Port", "label": "Port", "type": "text", "id": "port", "value": Config.Port},
-			{"name": "DataDir", "label": "Data Directory", "type": "text", "id": "data-dir", "value": Config.DataDir},
-			{"name": "TransactionsDir", "label": "Transactions Dir", "type": "text", "id": "transactions-dir", "value": Config.TransactionsDir},
-			{"name": "WebsiteName", "label": "Website Name", "type": "text", "id": "website-name", "value": Config.WebsiteName},
-		},
-		"tipping": {
-			{"name": "TippingEnabled", "label": "Tipping Enabled", "type": "checkbox", "id": "tipping-enabled", "value": Config.TippingEnabled},
-			{"name": "TippingMinAmount", "label": "Min Amount", "type": "number", "id": "tipping-min-amount", "value": Config.TippingMinAmount, "step": "0.01", "min": "0"},
-			{"name": "TippingMaxAmount", "label": "Max Amount", "type": "number", "id": "tipping-max-amount", "value": Config.TippingMaxAmount, "step": "0.01", "min": "0"},
-			{"name": "TippingAllowCustomAmount", "label": "Allow Custom Amounts", "type": "checkbox", "id": "tipping-allow-custom", "value": Config.TippingAllowCustomAmount},
-		},
-		"sms": {
-			{"name": "AWSAccessKeyID", "label": "AWS Access Key", "type": "text", "id": "aws-access-key", "value": Config.AWSAccessKeyID},
-			{"name": "AWSSecretAccessKey", "label": "AWS Secret Access Key", "type": "password", "id": "aws-secret-key", "value": Config.AWSSecretAccessKey},
-			{"name": "AWSRegion", "label": "AWS Region", "type": "text", "id": "aws-region", "value": Config.AWSRegion},
-		},
+// ValidateTaxMode checks a tax mode setting
+func ValidateTaxMode(mode string) error {
+	switch mode {
+	case "", TaxModeLocal, TaxModeStripeTax:
+		return nil
+	}
+	return fmt.Errorf("tax mode must be %s or %s (empty = %s)", TaxModeLocal, TaxModeStripeTax, TaxModeLocal)
+}
+
+// SetTaxModeLocationOverride sets the tax mode of one location; an empty mode makes it follow TaxMode again
+func SetTaxModeLocationOverride(locationID, mode string) error {
+	if err := ValidateTaxMode(mode); err != nil {
+		return err
+	}
+	if Config.TaxModeLocationOverrides == nil {
+		Config.TaxModeLocationOverrides = make(map[string]string)
+	}
+
+	if mode == "" {
+		delete(Config.TaxModeLocationOverrides, locationID)
+	} else {
+		Config.TaxModeLocationOverrides[locationID] = mode
+	}
+	configPath := filepath.Join(DefaultDataDir, "config.json")
+	return saveConfig(configPath)
+}
+
+// Service fee modes
+const (
+	ServiceFeePercentage = "percentage" // ServiceFeeAmount is a percentage of the sale
+	ServiceFeeFixed      = "fixed"      // ServiceFeeAmount is added to every sale
+)
+
+// SupportedServiceFeeMethods are the payment methods a service fee can be charged on.
+// Gift cards are never charged one, since store credit was already paid for.
+var SupportedServiceFeeMethods = []string{"terminal", "manual", "qr", "cash"}
+
+// DefaultServiceFeeMethods is used when no methods are configured: the card payments
+var DefaultServiceFeeMethods = []string{"terminal", "manual", "qr"}
+
+// GetServiceFeeMethods returns the payment methods the service fee is charged on
+func GetServiceFeeMethods() []string {
+	if len(Config.ServiceFeeMethods) == 0 {
+		return DefaultServiceFeeMethods
+	}
+	return Config.ServiceFeeMethods
+}
+
+// ParseServiceFeeMethods parses a comma-separated list of payment methods (e.g. "terminal, qr")
+func ParseServiceFeeMethods(value string) ([]string, error) {
+	methods := []string{}
+	for _, part := range strings.Split(value, ",") {
+		method := strings.ToLower(strings.TrimSpace(part))
+		if method == "" || slices.Contains(methods, method) {
+			continue
+		}
+		if !slices.Contains(SupportedServiceFeeMethods, method) {
+			return nil, fmt.Errorf("unsupported service fee payment method %q (supported: %s)",
+				method, strings.Join(SupportedServiceFeeMethods, ", "))
+		}
+		methods = append(methods, method)
+	}
+	return methods, nil
+}
+
+// ValidateServiceFee checks a service fee against its mode and the jurisdiction's cap.
+// A percentage fee must not exceed the cap; fixed fees are lowered to the cap sale by sale.
+func ValidateServiceFee(mode string, amount, maxPercent float64) error {
+	switch mode {
+	case "", ServiceFeeFixed:
+	case ServiceFeePercentage:
+		if maxPercent > 0 && amount > maxPercent {
+			return fmt.Errorf("a service fee of %g%% is over the %g%% cap", amount, maxPercent)
+		}
+	default:
+		return fmt.Errorf("service fee type must be %s or %s (empty = no service fee)", ServiceFeePercentage, ServiceFeeFixed)
+	}
+	return nil
+}
+
+// ValidateStatementDescriptorSuffix checks a suffix against Stripe's statement descriptor rules
+func ValidateStatementDescriptorSuffix(suffix string) error {
+	if len(suffix) > MaxStatementDescriptorSuffix {
+		return fmt.Errorf("statement descriptor suffix must be at most %d characters", MaxStatementDescriptorSuffix)
+	}
+	if strings.ContainsAny(suffix, `<>\'"*`) {
+		return fmt.Errorf(`statement descriptor suffix can't contain < > \ ' " or *`)
+	}
+	if suffix != "" && !strings.ContainsFunc(suffix, unicode.IsLetter) {
+		return fmt.Errorf("statement descriptor suffix must contain at least one letter")
+	}
+	return nil
+}
+
+// ParseTipPresets parses a comma-separated list of tip percentages (e.g. "15, 18, 20").
+// Each percentage must be 1-100 and at most MaxTipPresets may be given.
+func ParseTipPresets(value string) ([]int, error) {
+	presets := []int{}
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(part), "%"))
+		if part == "" {
+			continue
+		}
+		percentage, err := strconv.Atoi(part)
+		if err != nil {
+			return nil, fmt.Errorf("tip preset %q is not a whole number", part)
+		}
+		if percentage < 1 || percentage > 100 {
+			return nil, fmt.Errorf("tip preset %d%% must be between 1 and 100", percentage)
+		}
+		presets = append(presets, percentage)
+	}
+	if len(presets) > MaxTipPresets {
+		return nil, fmt.Errorf("at most %d tip presets are allowed", MaxTipPresets)
+	}
+	return presets, nil
+}
+
+// FormatTipPresets formats tip percentages for the settings editor
+func FormatTipPresets(presets []int) string {
+	parts := make([]string, len(presets))
+	for i, percentage := range presets {
+		parts[i] = strconv.Itoa(percentage)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// GetLogOptions returns the log output settings; debug overrides the configured level
+func GetLogOptions(debug bool, format string) (utils.LogOptions, error) {
+	level, err := utils.ParseLogLevel(Config.LogLevel)
+	if err != nil {
+		return utils.LogOptions{}, err
+	}
+	if debug {
+		level = slog.LevelDebug
+	}
+
+	opts := utils.LogOptions{
+		Level:     level,
+		Format:    format,
+		File:      Config.LogFile,
+		MaxSizeMB: Config.LogMaxSizeMB,
+		MaxFiles:  Config.LogMaxFiles,
+	}
+	if opts.MaxSizeMB <= 0 {
+		opts.MaxSizeMB = DefaultLogMaxSizeMB
+	}
+	if opts.MaxFiles <= 0 {
+		opts.MaxFiles = DefaultLogMaxFiles
+	}
+	return opts, nil
+}
+
+// GetBusinessLocation returns the business timezone, falling back to the server's local time
+func GetBusinessLocation() *time.Location {
+	if Config.BusinessTimezone == "" {
+		return time.Local
+	}
+	location, err := time.LoadLocation(Config.BusinessTimezone)
+	if err != nil {
+		utils.Warn("config", "Invalid business timezone, using server time", "timezone", Config.BusinessTimezone, "error", err)
+		return time.Local
+	}
+	return location
+}
+
+// IsEmailEnabled returns true if an SMTP server and sender address are configured
+func IsEmailEnabled() bool {
+	return Config.SMTPHost != "" && Config.EmailFrom != ""
+}
+
+// GetDailyReportRecipients returns the configured daily report recipients
+func GetDailyReportRecipients() []string {
+	var recipients []string
+	for _, recipient := range strings.Split(Config.DailyReportRecipients, ",") {
+		if recipient = strings.TrimSpace(recipient); recipient != "" {
+			recipients = append(recipients, recipient)
+		}
+	}
+	return recipients
+}
+
+// GetReconciliationRecipients returns the configured reconciliation report recipients
+func GetReconciliationRecipients() []string {
+	var recipients []string
+	for _, recipient := range strings.Split(Config.ReconciliationRecipients, ",") {
+		if recipient = strings.TrimSpace(recipient); recipient != "" {
+			recipients = append(recipients, recipient)
+		}
+	}
+	return recipients
+}
+
+// ParseEmailTypoDomains parses comma-separated typo=domain pairs (e.g. "exmaple.com=example.com")
+func ParseEmailTypoDomains(value string) (map[string]string, error) {
+	typos := make(map[string]string)
+	for _, pair := range strings.Split(value, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		typo, domain, ok := strings.Cut(pair, "=")
+		typo, domain = strings.ToLower(strings.TrimSpace(typo)), strings.ToLower(strings.TrimSpace(domain))
+		if !ok || typo == "" || domain == "" || strings.Contains(typo, "@") || strings.Contains(domain, "@") {
+			return nil, fmt.Errorf("%q is not a typo=domain pair", pair)
+		}
+		typos[typo] = domain
+	}
+	return typos, nil
+}
+
+// GetEmailTypoDomains returns the configured misspelled email domains and the domains meant
+func GetEmailTypoDomains() map[string]string {
+	typos, err := ParseEmailTypoDomains(Config.EmailTypoDomains)
+	if err != nil {
+		utils.Warn("config", "Invalid misspelled email domains, ignoring them", "error", err)
+		return nil
+	}
+	return typos
+}
+
+// GetAPIKeys returns the keys accepted by the JSON API; none means the API is disabled
+func GetAPIKeys() []string {
+	var keys []string
+	for _, key := range strings.Split(Config.APIKeys, ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// GetOrderWebhookURLs returns the URLs order events are posted to; none means order webhooks are off
+func GetOrderWebhookURLs() []string {
+	var urls []string
+	for _, target := range strings.Split(Config.OrderWebhookURLs, ",") {
+		if target = strings.TrimSpace(target); target != "" {
+			urls = append(urls, target)
+		}
+	}
+	return urls
+}
+
+// GetOrderWebhookMaxAttempts returns how many times an order event is sent before it is dead-lettered
+func GetOrderWebhookMaxAttempts() int {
+	if Config.OrderWebhookMaxAttempts <= 0 {
+		return DefaultOrderWebhookMaxAttempts
 	}
+	return Config.OrderWebhookMaxAttempts
+}
+
+// IsSMSEnabled returns true if AWS SNS is configured for SMS receipts
+func IsSMSEnabled() bool {
+	return Config.AWSAccessKeyID != "" && Config.AWSSecretAccessKey != "" && Config.AWSRegion != ""
 }
 
 // UpdateConfigField updates a config field by name using reflection
@@ -631,6 +1191,148 @@ func UpdateConfigField(fieldName string, value interface{}) error {
 	if !field.CanSet() {
 		return fmt.Errorf("field %s cannot be set", fieldName)
 	}
+	setting, isSetting := getSettingField(fieldName)
+	if !isSetting {
+		return fmt.Errorf("field %s is not a setting", fieldName)
+	}
+
+	// Pointing at another directory would start an empty history there and leave the old one behind
+	if fieldName == "DataDir" || fieldName == "TransactionsDir" {
+		return &InvalidSettingError{Field: fieldName, Err: errors.New("the data is moved by stopping the POS and running it with -migrate-data <new directory>")}
+	}
+
+	// The logo file is replaced by uploading a new image
+	if fieldName == "ReceiptLogo" {
+		return fmt.Errorf("field %s is set by uploading a logo", fieldName)
+	}
+
+	// Tip presets are edited as a comma-separated list
+	if fieldName == "TippingPresetPercentages" {
+		presets, err := ParseTipPresets(fmt.Sprintf("%v", value))
+		if err != nil {
+			return &InvalidSettingError{Field: fieldName, Err: err}
+		}
+		Config.TippingPresetPercentages = presets
+		return saveConfig(filepath.Join(DefaultDataDir, "config.json"))
+	}
+
+	// Terminal payment method types are edited as a comma-separated list
+	if fieldName == "TerminalPaymentMethodTypes" {
+		types, err := ParseTerminalPaymentMethodTypes(fmt.Sprintf("%v", value))
+		if err != nil {
+			return &InvalidSettingError{Field: fieldName, Err: err}
+		}
+		Config.TerminalPaymentMethodTypes = types
+		return saveConfig(filepath.Join(DefaultDataDir, "config.json"))
+	}
+
+	// Service fee methods are edited as a comma-separated list
+	if fieldName == "ServiceFeeMethods" {
+		methods, err := ParseServiceFeeMethods(fmt.Sprintf("%v", value))
+		if err != nil {
+			return &InvalidSettingError{Field: fieldName, Err: err}
+		}
+		Config.ServiceFeeMethods = methods
+		return saveConfig(filepath.Join(DefaultDataDir, "config.json"))
+	}
+
+	// The fee type, amount and cap are checked together, so changing any one can't go over the cap
+	if fieldName == "ServiceFeeMode" || fieldName == "ServiceFeeAmount" || fieldName == "ServiceFeeMaxPercent" {
+		mode, amount, maxPercent := Config.ServiceFeeMode, Config.ServiceFeeAmount, Config.ServiceFeeMaxPercent
+		text := strings.TrimSpace(fmt.Sprintf("%v", value))
+		switch fieldName {
+		case "ServiceFeeMode":
+			mode = strings.ToLower(text)
+			value = mode
+		case "ServiceFeeAmount":
+			amount, _ = strconv.ParseFloat(text, 64)
+		case "ServiceFeeMaxPercent":
+			maxPercent, _ = strconv.ParseFloat(text, 64)
+		}
+		if err := ValidateServiceFee(mode, amount, maxPercent); err != nil {
+			return &InvalidSettingError{Field: fieldName, Err: err}
+		}
+	}
+
+	if fieldName == "TaxMode" {
+		mode := strings.ToLower(strings.TrimSpace(fmt.Sprintf("%v", value)))
+		if err := ValidateTaxMode(mode); err != nil {
+			return &InvalidSettingError{Field: fieldName, Err: err}
+		}
+		value = mode
+	}
+
+	if fieldName == "BusinessHoursStart" || fieldName == "BusinessHoursEnd" || fieldName == "BusinessDayClose" {
+		text := strings.TrimSpace(fmt.Sprintf("%v", value))
+		parsed, err := time.Parse("15:04", text)
+		if text != "" && err != nil {
+			return &InvalidSettingError{Field: fieldName, Err: errors.New("time must be HH:MM, e.g. 09:00")}
+		}
+		// A later close would move the evening's sales to the day before
+		if fieldName == "BusinessDayClose" && text != "" && parsed.Hour() >= 12 {
+			return &InvalidSettingError{Field: fieldName, Err: errors.New("the business day must end before 12:00")}
+		}
+		value = text
+	}
+
+	// Records younger than a year are never deletable, whatever was typed
+	if fieldName == "RetentionMonths" {
+		months, err := strconv.Atoi(strings.TrimSpace(fmt.Sprintf("%v", value)))
+		if err != nil || months < 0 || (months > 0 && months < MinRetentionMonths) {
+			return &InvalidSettingError{Field: fieldName, Err: fmt.Errorf("retention must be 0 (never delete) or at least %d months", MinRetentionMonths)}
+		}
+	}
+
+	if fieldName == "EmailTypoDomains" {
+		if _, err := ParseEmailTypoDomains(fmt.Sprintf("%v", value)); err != nil {
+			return &InvalidSettingError{Field: fieldName, Err: err}
+		}
+	}
+
+	if fieldName == "PhoneCountry" {
+		country := strings.ToUpper(strings.TrimSpace(fmt.Sprintf("%v", value)))
+		if country != "" && (len(country) != 2 || strings.Trim(country, "ABCDEFGHIJKLMNOPQRSTUVWXYZ") != "") {
+			return &InvalidSettingError{Field: fieldName, Err: errors.New("country must be a two-letter code, e.g. US")}
+		}
+		value = country
+	}
+
+	if fieldName == "UpdateCheckURL" {
+		text := strings.TrimSpace(fmt.Sprintf("%v", value))
+		if parsed, err := url.Parse(text); text != "" && (err != nil || (parsed.Scheme != "https" && parsed.Scheme != "http") || parsed.Host == "") {
+			return &InvalidSettingError{Field: fieldName, Err: errors.New("the update check URL must be an http or https URL")}
+		}
+		value = text
+	}
+
+	if fieldName == "OrderWebhookURLs" {
+		var urls []string
+		for _, target := range strings.Split(fmt.Sprintf("%v", value), ",") {
+			if target = strings.TrimSpace(target); target == "" {
+				continue
+			}
+			if parsed, err := url.Parse(target); err != nil || (parsed.Scheme != "https" && parsed.Scheme != "http") || parsed.Host == "" {
+				return &InvalidSettingError{Field: fieldName, Err: fmt.Errorf("%q is not an http or https URL", target)}
+			}
+			urls = append(urls, target)
+		}
+		value = strings.Join(urls, ", ")
+	}
+
+	if fieldName == "StatementDescriptorSuffix" {
+		if err := ValidateStatementDescriptorSuffix(strings.TrimSpace(fmt.Sprintf("%v", value))); err != nil {
+			return &InvalidSettingError{Field: fieldName, Err: err}
+		}
+		value = strings.TrimSpace(fmt.Sprintf("%v", value))
+	}
+
+	if fieldName == "Locale" {
+		locale := strings.ToLower(strings.TrimSpace(fmt.Sprintf("%v", value)))
+		if locale != "" && !i18n.IsSupported(locale) {
+			return &InvalidSettingError{Field: fieldName, Err: fmt.Errorf("language must be one of %s", strings.Join(i18n.Locales(), ", "))}
+		}
+		value = locale
+	}
 
 	// Convert value to appropriate type
 	switch field.Kind() {
@@ -643,13 +1345,27 @@ func UpdateConfigField(fieldName string, value interface{}) error {
 	case reflect.Float64:
 		if str, ok := value.(string); ok {
 			if floatVal, err := strconv.ParseFloat(str, 64); err == nil {
-				// Handle percentage conversion for DefaultTaxRate
-				if fieldName == "DefaultTaxRate" {
-					floatVal = floatVal / 100.0 // Convert percentage to decimal
+				if err := validateSettingNumber(setting, floatVal); err != nil {
+					return &InvalidSettingError{Field: fieldName, Err: err}
+				}
+				// Percentages are stored as decimals
+				if setting.Format == "percentage" {
+					floatVal = floatVal / 100.0
 				}
 				field.SetFloat(floatVal)
 			} else {
-				return fmt.Errorf("cannot convert %s to float64", str)
+				return &InvalidSettingError{Field: fieldName, Err: fmt.Errorf("%s must be a number", setting.Label)}
+			}
+		}
+	case reflect.Int:
+		if str, ok := value.(string); ok {
+			if intVal, err := strconv.Atoi(str); err == nil {
+				if err := validateSettingNumber(setting, float64(intVal)); err != nil {
+					return &InvalidSettingError{Field: fieldName, Err: err}
+				}
+				field.SetInt(int64(intVal))
+			} else {
+				return &InvalidSettingError{Field: fieldName, Err: fmt.Errorf("%s must be a whole number", setting.Label)}
 			}
 		}
 	case reflect.Bool:
@@ -661,7 +1377,12 @@ func UpdateConfigField(fieldName string, value interface{}) error {
 		return fmt.Errorf("unsupported field type: %s", field.Kind())
 	}
 
+	// The new language applies from the next page rendered
+	if fieldName == "Locale" {
+		i18n.SetLocale(Config.Locale)
+	}
+
 	// Save config
-	configPath := filepath.Join(Config.DataDir, "config.json")
+	configPath := filepath.Join(DefaultDataDir, "config.json")
 	return saveConfig(configPath)
 }
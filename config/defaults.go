@@ -0,0 +1,40 @@
+package config
+
+import (
+	"embed"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"checkout/utils"
+)
+
+// defaults holds the starter files written out on first run
+//
+//go:embed defaults/config.example.json defaults/products.json
+var defaults embed.FS
+
+// WriteDefaultFile copies an embedded starter file (e.g. "products.json") to path.
+// An existing file is left untouched.
+func WriteDefaultFile(name, path string) error {
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("error checking %s: %w", path, err)
+	}
+
+	data, err := defaults.ReadFile("defaults/" + name)
+	if err != nil {
+		return fmt.Errorf("no default %s: %w", name, err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("error creating directory for %s: %w", path, err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("error writing %s: %w", path, err)
+	}
+
+	utils.Info("config", "Wrote default file", "file", path)
+	return nil
+}
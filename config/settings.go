@@ -0,0 +1,226 @@
+package config
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+
+	"checkout/templates"
+)
+
+// SettingField is a config field shown on the settings page, described by its `setting` struct tag
+type SettingField struct {
+	Name    string // AppConfig field name
+	Section string
+	Label   string
+	Type    string // text, password, number, checkbox, textarea or file
+	ID      string
+	Help    string
+	Step    string // Number fields only
+	Min     string
+	Max     string
+	Format  string // "percentage" = stored as a decimal, edited as a percentage
+	Value   string // Current value as shown in the editor
+}
+
+// SettingSection is a titled group of settings
+type SettingSection struct {
+	Name   string
+	Title  string
+	Fields []SettingField
+}
+
+// InvalidSettingError is returned by UpdateConfigField when a value is rejected, as opposed to
+// failing to save it
+type InvalidSettingError struct {
+	Field string
+	Err   error
+}
+
+func (e *InvalidSettingError) Error() string {
+	return fmt.Sprintf("invalid value for %s: %v", e.Field, e.Err)
+}
+
+func (e *InvalidSettingError) Unwrap() error {
+	return e.Err
+}
+
+// settingSectionTitles lists the settings sections in display order
+var settingSectionTitles = []struct{ name, title string }{
+	{"stripe", "Stripe Configuration"},
+	{"business", "Business Information"},
+	{"tax", "Tax Configuration"},
+	{"system", "System Configuration"},
+	{"hours", "Business Hours and Readers"},
+	{"tipping", "Tipping Configuration"},
+	{"fees", "Service Fee"},
+	{"flow", "Checkout Flow"},
+	{"email", "Email Configuration"},
+	{"reports", "Daily Report"},
+	{"branding", "Receipt Branding"},
+	{"tickets", "Fulfillment Tickets"},
+	{"archive", "Data Retention"},
+	{"sms", "SMS Configuration"},
+	{"orderwebhooks", "Order Webhooks"},
+	{"vendors", "Market Vendors"},
+}
+
+// settingTagKeys are the keys a `setting` tag may set. Help text can contain commas, so a
+// comma only starts a new key when one of these follows it.
+var settingTagKeys = []string{"section", "label", "type", "id", "help", "step", "min", "max", "format"}
+
+// GetSettingSections returns the editable settings grouped by section, with their current values
+func GetSettingSections() []SettingSection {
+	bySection := make(map[string][]SettingField)
+	configType := reflect.TypeOf(Config)
+	configValue := reflect.ValueOf(Config)
+	for i := 0; i < configType.NumField(); i++ {
+		field, ok := parseSettingTag(configType.Field(i))
+		if !ok {
+			continue
+		}
+		field.Value = settingValue(field, configValue.Field(i))
+		bySection[field.Section] = append(bySection[field.Section], field)
+	}
+
+	sections := make([]SettingSection, 0, len(settingSectionTitles))
+	for _, section := range settingSectionTitles {
+		if fields := bySection[section.name]; len(fields) > 0 {
+			sections = append(sections, SettingSection{Name: section.name, Title: section.title, Fields: fields})
+		}
+	}
+	return sections
+}
+
+// GetSettingSection returns a single settings section by name
+func GetSettingSection(name string) SettingSection {
+	for _, section := range GetSettingSections() {
+		if section.Name == name {
+			return section
+		}
+	}
+	return SettingSection{Name: name}
+}
+
+// SearchSettings returns the settings whose label, help text or name contains the query, grouped
+// by section. A section whose title matches is returned whole.
+func SearchSettings(query string) []SettingSection {
+	query = strings.ToLower(strings.TrimSpace(query))
+	if query == "" {
+		return GetSettingSections()
+	}
+
+	var matches []SettingSection
+	for _, section := range GetSettingSections() {
+		if strings.Contains(strings.ToLower(section.Title), query) {
+			matches = append(matches, section)
+			continue
+		}
+		var fields []SettingField
+		for _, field := range section.Fields {
+			if strings.Contains(strings.ToLower(field.Label), query) ||
+				strings.Contains(strings.ToLower(field.Help), query) ||
+				strings.Contains(strings.ToLower(field.Name), query) {
+				fields = append(fields, field)
+			}
+		}
+		if len(fields) > 0 {
+			matches = append(matches, SettingSection{Name: section.Name, Title: section.Title, Fields: fields})
+		}
+	}
+	return matches
+}
+
+// getSettingField returns the settings metadata of a config field, if it is shown on the settings page
+func getSettingField(fieldName string) (SettingField, bool) {
+	structField, found := reflect.TypeOf(templates.AppConfig{}).FieldByName(fieldName)
+	if !found {
+		return SettingField{}, false
+	}
+	return parseSettingTag(structField)
+}
+
+// parseSettingTag reads the `setting` tag of a config field; fields without one or tagged "-" are not settings
+func parseSettingTag(structField reflect.StructField) (SettingField, bool) {
+	tag := structField.Tag.Get("setting")
+	if tag == "" || tag == "-" {
+		return SettingField{}, false
+	}
+
+	values := make(map[string]string)
+	key := ""
+	for _, part := range strings.Split(tag, ",") {
+		if name, value, found := strings.Cut(part, ":"); found && slices.Contains(settingTagKeys, name) {
+			key = name
+			values[key] = value
+		} else if key != "" {
+			values[key] += "," + part
+		}
+	}
+
+	return SettingField{
+		Name:    structField.Name,
+		Section: values["section"],
+		Label:   values["label"],
+		Type:    values["type"],
+		ID:      values["id"],
+		Help:    values["help"],
+		Step:    values["step"],
+		Min:     values["min"],
+		Max:     values["max"],
+		Format:  values["format"],
+	}, true
+}
+
+// settingValue formats a config value for its editor
+func settingValue(field SettingField, value reflect.Value) string {
+	switch value.Kind() {
+	case reflect.Float64:
+		number := value.Float()
+		if field.Format == "percentage" {
+			// Round away float noise such as 8.250000000000002
+			number = math.Round(number*100*1e8) / 1e8
+		}
+		return strconv.FormatFloat(number, 'f', -1, 64)
+	case reflect.Slice:
+		if presets, ok := value.Interface().([]int); ok {
+			return FormatTipPresets(presets)
+		}
+		parts := make([]string, value.Len())
+		for i := range parts {
+			parts[i] = fmt.Sprint(value.Index(i).Interface())
+		}
+		return strings.Join(parts, ", ")
+	default:
+		return fmt.Sprint(value.Interface())
+	}
+}
+
+// validateSettingNumber checks a number entered in the settings editor against the min, max and
+// step of its tag. The step is counted from min (or zero), as browsers do.
+func validateSettingNumber(field SettingField, number float64) error {
+	if field.Min != "" {
+		if min, err := strconv.ParseFloat(field.Min, 64); err == nil && number < min {
+			return fmt.Errorf("%s must be at least %s", field.Label, field.Min)
+		}
+	}
+	if field.Max != "" {
+		if max, err := strconv.ParseFloat(field.Max, 64); err == nil && number > max {
+			return fmt.Errorf("%s must be at most %s", field.Label, field.Max)
+		}
+	}
+	if field.Step != "" {
+		step, err := strconv.ParseFloat(field.Step, 64)
+		if err == nil && step > 0 {
+			base, _ := strconv.ParseFloat(field.Min, 64)
+			steps := (number - base) / step
+			if math.Abs(steps-math.Round(steps)) > 1e-6 {
+				return fmt.Errorf("%s must be in steps of %s", field.Label, field.Step)
+			}
+		}
+	}
+	return nil
+}
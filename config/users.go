@@ -0,0 +1,185 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"checkout/templates"
+	"checkout/utils"
+)
+
+// MinPasswordLength is the shortest password accepted for a user
+const MinPasswordLength = 8
+
+// Lengths of the numeric PIN a user switches in with at the register
+const (
+	MinPINLength = 4
+	MaxPINLength = 8
+)
+
+// dummyPasswordHash is compared against when a username is unknown, so a login takes as long
+// whether or not the user exists
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("no such user"), bcrypt.DefaultCost)
+
+// AuthenticateUser checks a username and password and returns the user they belong to
+func AuthenticateUser(username, password string) (templates.User, bool) {
+	user, found := GetUser(username)
+	if !found {
+		_ = bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(password))
+		return templates.User{}, false
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)) != nil {
+		return templates.User{}, false
+	}
+	return user, true
+}
+
+// GetUser returns the configured user with a username (case-insensitive)
+func GetUser(username string) (templates.User, bool) {
+	username = strings.TrimSpace(username)
+	for _, user := range Config.Users {
+		if strings.EqualFold(user.Username, username) {
+			return user, true
+		}
+	}
+	return templates.User{}, false
+}
+
+// AddUser adds a user, or replaces the password and role of an existing one, and saves the config
+func AddUser(username, password, role string) error {
+	username = strings.TrimSpace(username)
+	if username == "" {
+		return errors.New("username is required")
+	}
+	if role != templates.RoleAdmin && role != templates.RoleCashier {
+		return fmt.Errorf("role must be %s or %s", templates.RoleAdmin, templates.RoleCashier)
+	}
+	if err := ValidatePassword(password); err != nil {
+		return err
+	}
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	user := templates.User{Username: username, PasswordHash: hash, Role: role}
+	replaced := false
+	for i := range Config.Users {
+		if strings.EqualFold(Config.Users[i].Username, username) {
+			// A new password doesn't change how the user switches in at the register
+			user.PINHash = Config.Users[i].PINHash
+			Config.Users[i] = user
+			replaced = true
+		}
+	}
+	if !replaced {
+		Config.Users = append(Config.Users, user)
+	}
+	return saveConfig(filepath.Join(DefaultDataDir, "config.json"))
+}
+
+// ValidatePassword checks a new password: at least MinPasswordLength characters, all of them
+// letters, numbers or keyboard symbols
+func ValidatePassword(password string) error {
+	if len(password) < MinPasswordLength {
+		return fmt.Errorf("password must be at least %d characters long", MinPasswordLength)
+	}
+	for _, char := range password {
+		// ASCII printable range (32-126) includes letters, numbers, and symbols
+		if char < 32 || char > 126 {
+			return errors.New("password contains invalid characters; use only letters, numbers, and keyboard symbols")
+		}
+	}
+	return nil
+}
+
+// AuthenticatePIN checks the PIN a user switches in with at the register. A user without a PIN
+// can't switch in this way.
+func AuthenticatePIN(username, pin string) (templates.User, bool) {
+	user, found := GetUser(username)
+	if !found || user.PINHash == "" {
+		_ = bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(pin))
+		return templates.User{}, false
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.PINHash), []byte(pin)) != nil {
+		return templates.User{}, false
+	}
+	return user, true
+}
+
+// PINUsers returns the users who can switch in at the register with a PIN
+func PINUsers() []templates.User {
+	var users []templates.User
+	for _, user := range Config.Users {
+		if user.PINHash != "" {
+			users = append(users, user)
+		}
+	}
+	return users
+}
+
+// SetUserPIN sets the PIN a user switches in with at the register, or removes it when pin is
+// empty, and saves the config
+func SetUserPIN(username, pin string) error {
+	pin = strings.TrimSpace(pin)
+	hash := ""
+	if pin != "" {
+		if err := ValidatePIN(pin); err != nil {
+			return err
+		}
+		var err error
+		if hash, err = hashPassword(pin); err != nil {
+			return err
+		}
+	}
+
+	for i := range Config.Users {
+		if strings.EqualFold(Config.Users[i].Username, strings.TrimSpace(username)) {
+			Config.Users[i].PINHash = hash
+			return saveConfig(filepath.Join(DefaultDataDir, "config.json"))
+		}
+	}
+	return fmt.Errorf("no user named %q", username)
+}
+
+// ValidatePIN checks a new PIN: MinPINLength to MaxPINLength digits
+func ValidatePIN(pin string) error {
+	if len(pin) < MinPINLength || len(pin) > MaxPINLength {
+		return fmt.Errorf("PIN must be %d to %d digits long", MinPINLength, MaxPINLength)
+	}
+	for _, char := range pin {
+		if char < '0' || char > '9' {
+			return errors.New("PIN must contain only digits")
+		}
+	}
+	return nil
+}
+
+// hashPassword returns the bcrypt hash stored for a password
+func hashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", fmt.Errorf("error hashing password: %w", err)
+	}
+	return string(hash), nil
+}
+
+// migratePasswordToUsers turns the single shared password of an older config into an "admin"
+// user, so existing installs keep working with that password. Returns whether the config changed.
+func migratePasswordToUsers() (bool, error) {
+	if len(Config.Users) > 0 || Config.Password == "" {
+		return false, nil
+	}
+	hash, err := hashPassword(Config.Password)
+	if err != nil {
+		return false, err
+	}
+	Config.Users = []templates.User{{Username: "admin", PasswordHash: hash, Role: templates.RoleAdmin}}
+	Config.Password = ""
+	utils.Info("config", "Migrated the shared password to an admin user", "username", "admin")
+	return true, nil
+}
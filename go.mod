@@ -10,3 +10,5 @@ require (
 )
 
 require github.com/skip2/go-qrcode v0.0.0-20200617195104-da1b6568686e
+
+require golang.org/x/crypto v0.38.0
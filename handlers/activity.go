@@ -0,0 +1,20 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"checkout/services"
+	"checkout/templates/pos"
+)
+
+// activityShown is how many entries the POS activity feed lists
+const activityShown = 20
+
+// ActivityHandler renders the latest entries of the activity feed: payments, declines, receipts
+// and reader status changes
+func (a *App) ActivityHandler(w http.ResponseWriter, r *http.Request) {
+	if err := pos.ActivityFeed(services.RecentActivity(activityShown), time.Now()).Render(r.Context(), w); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
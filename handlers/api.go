@@ -0,0 +1,630 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/stripe/stripe-go/v74"
+
+	"checkout/config"
+	"checkout/services"
+	"checkout/services/validation"
+	"checkout/templates"
+	"checkout/utils"
+)
+
+// Stable error codes returned by the JSON API. Integrations should branch on these, not the message.
+const (
+	APIErrorUnauthorized      = "unauthorized"        // Missing or unknown API key
+	APIErrorDisabled          = "api_disabled"        // No API keys are configured
+	APIErrorSetupRequired     = "setup_required"      // Startup checks are failing; see /setup
+	APIErrorInvalidRequest    = "invalid_request"     // Malformed body or parameters
+	APIErrorNotFound          = "not_found"           // Unknown endpoint, product, payment or transaction
+	APIErrorCartEmpty         = "cart_empty"          // A payment was requested for an empty cart
+	APIErrorInvalidTotal      = "invalid_total"       // The cart total is zero or negative
+	APIErrorPaymentInProgress = "payment_in_progress" // The cart is locked while it is being paid for
+	APIErrorReaderUnavailable = "reader_unavailable"  // No terminal reader is selected, or it is offline
+	APIErrorStripe            = "stripe_error"        // Stripe rejected or failed a request
+	APIErrorMixedVendors      = "mixed_vendors"       // The cart's items belong to more than one market vendor
+	APIErrorInternal          = "internal_error"
+)
+
+// Payment statuses reported by the JSON API
+const (
+	APIPaymentPending   = "pending"   // Waiting on the customer (QR code) or the reader (terminal)
+	APIPaymentSucceeded = "succeeded" // Paid and recorded in the transaction log
+	APIPaymentFailed    = "failed"    // Declined, cancelled or expired; the cart is left as it was
+)
+
+// apiMaxQuantity caps the quantity of a single cart item request
+const apiMaxQuantity = 100
+
+// APIError is the body of every failed API request: {"error": {"code": "...", "message": "..."}}
+type APIError struct {
+	Status  int    `json:"-"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *APIError) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+// APIErrorResponse wraps an APIError
+type APIErrorResponse struct {
+	Error *APIError `json:"error"`
+}
+
+// APIProducts is the response of GET /api/v1/products
+type APIProducts struct {
+	Products []templates.Product `json:"products"`
+}
+
+// APICartSummary holds the cart totals
+type APICartSummary struct {
+	Subtotal   float64 `json:"subtotal"`
+	Tax        float64 `json:"tax"`
+	ServiceFee float64 `json:"serviceFee,omitempty"` // Service fee of the selected payment method
+	Total      float64 `json:"total"`
+}
+
+// APICart is the response of every cart endpoint: the items in cart order (one per unit) and their totals.
+// Items are removed by their index in this list.
+type APICart struct {
+	Items   []templates.Product `json:"items"`
+	Summary APICartSummary      `json:"summary"`
+}
+
+// APICartItemRequest adds a catalog product by productID or sku, or a custom item by name and price
+type APICartItemRequest struct {
+	ProductID   string  `json:"productID,omitempty"`
+	SKU         string  `json:"sku,omitempty"`
+	Name        string  `json:"name,omitempty"`
+	Description string  `json:"description,omitempty"`
+	Price       float64 `json:"price,omitempty"`
+	Quantity    float64 `json:"quantity,omitempty"` // Defaults to 1; may have decimals for products sold by weight or length
+}
+
+// APICreateCartRequest is the body of POST /api/v1/cart, which replaces the cart with these items
+type APICreateCartRequest struct {
+	Items []APICartItemRequest `json:"items"`
+}
+
+// APIPaymentRequest is the body of POST /api/v1/payments
+type APIPaymentRequest struct {
+	Method   string `json:"method"`             // "qr" or "terminal"
+	Note     string `json:"note,omitempty"`     // Note or order reference recorded with the sale
+	ReaderID string `json:"readerID,omitempty"` // Terminal payments: the reader to use (default: the last one picked on the POS)
+}
+
+// APIPayment describes a payment started through the API
+type APIPayment struct {
+	ID       string  `json:"id"`
+	Method   string  `json:"method"`
+	Status   string  `json:"status"`
+	Amount   float64 `json:"amount,omitempty"`
+	URL      string  `json:"url,omitempty"`      // QR payments: the payment link to show or send the customer
+	ReaderID string  `json:"readerID,omitempty"` // Terminal payments: the reader collecting the card
+	Message  string  `json:"message,omitempty"`  // Why a payment failed
+}
+
+// APITransactions is the response of GET /api/v1/transactions
+type APITransactions struct {
+	Date         string                  `json:"date"`
+	Transactions []templates.Transaction `json:"transactions"`
+}
+
+// APIMiddleware authenticates API requests by key, sent as "Authorization: Bearer <key>" or "X-API-Key".
+// The keys are configured in settings, separately from the admin password.
+func (a *App) APIMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		keys := config.GetAPIKeys()
+		if len(keys) == 0 {
+			writeAPIError(w, &APIError{http.StatusForbidden, APIErrorDisabled, "The API is disabled; add an API key in settings"})
+			return
+		}
+
+		if !validAPIKey(requestAPIKey(r), keys) {
+			utils.Warn("api", "Rejected API request with an invalid key", "path", r.URL.Path, "remote_addr", r.RemoteAddr)
+			writeAPIError(w, &APIError{http.StatusUnauthorized, APIErrorUnauthorized, "A valid API key is required"})
+			return
+		}
+
+		if problem := services.SetupProblem(); problem != "" {
+			writeAPIError(w, &APIError{http.StatusServiceUnavailable, APIErrorSetupRequired, problem})
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requestAPIKey returns the key a request was sent with
+func requestAPIKey(r *http.Request) string {
+	if key, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); found {
+		return strings.TrimSpace(key)
+	}
+	return strings.TrimSpace(r.Header.Get("X-API-Key"))
+}
+
+// validAPIKey compares a key against every configured key in constant time
+func validAPIKey(key string, keys []string) bool {
+	valid := false
+	for _, candidate := range keys {
+		if subtle.ConstantTimeCompare([]byte(key), []byte(candidate)) == 1 {
+			valid = true
+		}
+	}
+	return key != "" && valid
+}
+
+// APINotFoundHandler answers requests for API endpoints that don't exist
+func (a *App) APINotFoundHandler(w http.ResponseWriter, r *http.Request) {
+	writeAPIError(w, &APIError{http.StatusNotFound, APIErrorNotFound, fmt.Sprintf("No endpoint %s %s", r.Method, r.URL.Path)})
+}
+
+// APIProductsHandler lists the product catalog
+func (a *App) APIProductsHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, APIProducts{Products: services.Catalog.Products()})
+}
+
+// APIGetCartHandler returns the cart and its totals
+func (a *App) APIGetCartHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, apiCart(a.cart(r)))
+}
+
+// APICreateCartHandler starts a new cart, replacing the current one, with the requested items
+func (a *App) APICreateCartHandler(w http.ResponseWriter, r *http.Request) {
+	cart := a.cart(r)
+	if apiErr := a.checkCartUnlocked(cart); apiErr != nil {
+		writeAPIError(w, apiErr)
+		return
+	}
+
+	var req APICreateCartRequest
+	if apiErr := decodeAPIRequest(w, r, &req, true); apiErr != nil {
+		writeAPIError(w, apiErr)
+		return
+	}
+
+	// Check every item before touching the cart so a bad item doesn't leave it half built
+	items := []templates.Product{}
+	for _, item := range req.Items {
+		lines, apiErr := apiCartLines(item)
+		if apiErr != nil {
+			writeAPIError(w, apiErr)
+			return
+		}
+		items = append(items, lines...)
+	}
+
+	cart.Reset()
+	cart.Replace(items)
+	cart.Touch()
+	utils.Info("api", "Cart created", "items", len(items))
+	writeJSON(w, http.StatusCreated, apiCart(cart))
+}
+
+// APIClearCartHandler empties the cart
+func (a *App) APIClearCartHandler(w http.ResponseWriter, r *http.Request) {
+	cart := a.cart(r)
+	if apiErr := a.checkCartUnlocked(cart); apiErr != nil {
+		writeAPIError(w, apiErr)
+		return
+	}
+
+	cart.Reset()
+	utils.Info("api", "Cart cleared")
+	writeJSON(w, http.StatusOK, apiCart(cart))
+}
+
+// APIAddCartItemHandler adds a catalog product or custom item to the cart
+func (a *App) APIAddCartItemHandler(w http.ResponseWriter, r *http.Request) {
+	cart := a.cart(r)
+	if apiErr := a.checkCartUnlocked(cart); apiErr != nil {
+		writeAPIError(w, apiErr)
+		return
+	}
+
+	var item APICartItemRequest
+	if apiErr := decodeAPIRequest(w, r, &item, false); apiErr != nil {
+		writeAPIError(w, apiErr)
+		return
+	}
+
+	lines, apiErr := apiCartLines(item)
+	if apiErr != nil {
+		writeAPIError(w, apiErr)
+		return
+	}
+
+	cart.Add(lines...)
+	cart.Touch()
+	writeJSON(w, http.StatusCreated, apiCart(cart))
+}
+
+// APIRemoveCartItemHandler removes the cart item at the index in the path
+func (a *App) APIRemoveCartItemHandler(w http.ResponseWriter, r *http.Request) {
+	cart := a.cart(r)
+	if apiErr := a.checkCartUnlocked(cart); apiErr != nil {
+		writeAPIError(w, apiErr)
+		return
+	}
+
+	index, err := strconv.Atoi(r.PathValue("index"))
+	if err != nil {
+		index = -1
+	}
+	if _, removed := cart.Remove(index); !removed {
+		writeAPIError(w, &APIError{http.StatusNotFound, APIErrorNotFound, fmt.Sprintf("No cart item at index %s", r.PathValue("index"))})
+		return
+	}
+	cart.Touch()
+	writeJSON(w, http.StatusOK, apiCart(cart))
+}
+
+// APICreatePaymentHandler starts paying for the cart with a payment link (qr) or the selected reader (terminal).
+// Poll GET /api/v1/payments/{id} until the payment is no longer pending; the sale is recorded
+// and the cart cleared when the poll sees it complete, the same as on the POS screen.
+func (a *App) APICreatePaymentHandler(w http.ResponseWriter, r *http.Request) {
+	cart := a.cart(r)
+	var req APIPaymentRequest
+	if apiErr := decodeAPIRequest(w, r, &req, false); apiErr != nil {
+		writeAPIError(w, apiErr)
+		return
+	}
+
+	if cart.Len() == 0 {
+		writeAPIError(w, &APIError{http.StatusConflict, APIErrorCartEmpty, "Add items to the cart before starting a payment"})
+		return
+	}
+	// The payment method decides the service fee, if any
+	cart.SetPaymentMethod(req.Method)
+	if err := services.QuoteStripeTax(a.Stripe, cart); err != nil {
+		utils.Error("api", "Error calculating Stripe Tax", "error", err)
+		writeAPIError(w, &APIError{http.StatusBadGateway, APIErrorStripe, err.Error()})
+		return
+	}
+	summary := services.CalculateCartSummary(cart)
+	if summary.Total <= 0 {
+		writeAPIError(w, &APIError{http.StatusConflict, APIErrorInvalidTotal, "The cart total must be greater than zero"})
+		return
+	}
+	if apiErr := a.checkCartUnlocked(cart); apiErr != nil {
+		writeAPIError(w, apiErr)
+		return
+	}
+	if _, err := services.CartVendor(cart.Items()); err != nil {
+		var mixed *services.MixedVendorsError
+		if errors.As(err, &mixed) {
+			writeAPIError(w, &APIError{http.StatusConflict, APIErrorMixedVendors, "The cart has items from more than one vendor (" + strings.Join(mixed.Vendors, ", ") + "); check out each vendor's items separately"})
+		} else {
+			writeAPIError(w, &APIError{http.StatusInternalServerError, APIErrorInternal, err.Error()})
+		}
+		return
+	}
+
+	// API payments are never tipped on screen
+	cart.SetTip(0)
+	if req.Note != "" {
+		cart.SetNote(services.SanitizeNote(req.Note))
+	}
+
+	var payment APIPayment
+	var apiErr *APIError
+	switch req.Method {
+	case "qr":
+		payment, apiErr = a.startAPIQRPayment(cart, summary.Total)
+	case "terminal":
+		payment, apiErr = a.startAPITerminalPayment(cart, summary, req.ReaderID)
+	default:
+		apiErr = &APIError{http.StatusBadRequest, APIErrorInvalidRequest, `method must be "qr" or "terminal"`}
+	}
+	if apiErr != nil {
+		writeAPIError(w, apiErr)
+		return
+	}
+
+	utils.Info("api", "Payment started", "payment_id", payment.ID, "method", payment.Method, "status", payment.Status, "amount", payment.Amount)
+	writeJSON(w, http.StatusCreated, payment)
+}
+
+// startAPIQRPayment creates a payment link for the cart and tracks it like a QR code shown on screen
+func (a *App) startAPIQRPayment(cart *services.CartStore, amount float64) (APIPayment, *APIError) {
+	paymentLink, err := services.CreatePaymentLink(a.Stripe, cart, amount, "", services.PaymentLinkKindQR)
+	if err != nil {
+		utils.Error("api", "Error creating payment link", "amount", amount, "error", err)
+		return APIPayment{}, &APIError{http.StatusBadGateway, APIErrorStripe, err.Error()}
+	}
+
+	a.Payments.AddPayment(newQRPaymentState(cart, paymentLink.ID, paymentLink.URL, a.Clock.Now()))
+
+	return APIPayment{
+		ID:     paymentLink.ID,
+		Method: "qr",
+		Status: APIPaymentPending,
+		Amount: amount,
+		URL:    paymentLink.URL,
+	}, nil
+}
+
+// startAPITerminalPayment sends the cart total to the given reader, or the one picked last on the
+// POS since the API has no register of its own
+func (a *App) startAPITerminalPayment(cart *services.CartStore, summary templates.CartSummary, readerID string) (APIPayment, *APIError) {
+	if readerID == "" {
+		readerID = services.Terminal.SelectedReaderID()
+	}
+	if readerID == "" || !isReaderOnline(readerID) {
+		return APIPayment{}, &APIError{http.StatusConflict, APIErrorReaderUnavailable, "Select an online terminal reader on the POS first"}
+	}
+
+	params := services.NewPaymentIntentParams(summary.Total, "terminal", cart.Note())
+	if err := services.RouteToVendor(params, cart.Items()); err != nil {
+		return APIPayment{}, &APIError{http.StatusInternalServerError, APIErrorInternal, err.Error()}
+	}
+	intent, err := a.Stripe.CreatePaymentIntent(params)
+	if err != nil {
+		utils.Error("api", "Error creating payment intent", "amount", summary.Total, "error", err)
+		return APIPayment{}, &APIError{http.StatusBadGateway, APIErrorStripe, err.Error()}
+	}
+
+	reader, err := a.processPaymentOnTerminal(cart, intent.ID, readerID, summary)
+	if err != nil {
+		utils.Error("api", "Error sending payment to reader", "intent_id", intent.ID, "reader_id", readerID, "error", err)
+		return APIPayment{}, &APIError{http.StatusBadGateway, APIErrorStripe, err.Error()}
+	}
+	if reader == nil || reader.Action == nil {
+		return APIPayment{}, &APIError{http.StatusBadGateway, APIErrorStripe, "The reader returned no payment status"}
+	}
+
+	payment := APIPayment{
+		ID:       intent.ID,
+		Method:   "terminal",
+		Amount:   summary.Total,
+		ReaderID: readerID,
+	}
+
+	switch reader.Action.Status {
+	case stripe.TerminalReaderActionStatusSucceeded:
+		pi := reader.Action.ProcessPaymentIntent.PaymentIntent
+		if pi == nil || pi.Status != stripe.PaymentIntentStatusSucceeded {
+			payment.Status = APIPaymentFailed
+			payment.Message = "Payment declined by terminal"
+			if pi != nil && pi.LastPaymentError != nil && pi.LastPaymentError.Msg != "" {
+				payment.Message = pi.LastPaymentError.Msg
+			}
+			return payment, nil
+		}
+
+		_ = a.Events.LogPaymentEvent(cart, intent.ID, PaymentEventSuccess, "terminal", cart.Items(), summary, "")
+		cart.Clear()
+		payment.Status = APIPaymentSucceeded
+
+	case stripe.TerminalReaderActionStatusFailed:
+		payment.Status = APIPaymentFailed
+		payment.Message = reader.Action.FailureMessage
+
+	default:
+		// Still on the reader; polling the payment completes it
+		items := cart.Items()
+		terminalState := &TerminalPaymentState{
+			PaymentIntentID: intent.ID,
+			ReaderID:        readerID,
+			StartTime:       a.Clock.Now(),
+			Cart:            items,
+			Summary:         summary,
+			Note:            cart.Note(),
+			CartHash:        services.CartHash(items),
+			CartStore:       cart,
+		}
+		a.Payments.AddPayment(terminalState)
+		payment.Status = APIPaymentPending
+	}
+
+	return payment, nil
+}
+
+// APIGetPaymentHandler reports a payment's status. A pending payment is checked with Stripe,
+// so polling this endpoint is what completes and records it.
+func (a *App) APIGetPaymentHandler(w http.ResponseWriter, r *http.Request) {
+	paymentID := r.PathValue("id")
+
+	if state, exists := a.Payments.GetPayment(paymentID); exists {
+		var result PaymentStatusResult
+		switch state.GetPaymentType() {
+		case "qr":
+			result = a.checkQRPaymentStatus(paymentID)
+		case "terminal":
+			result = a.checkTerminalPaymentStatus(paymentID)
+		}
+
+		payment := APIPayment{ID: paymentID, Method: state.GetPaymentType(), Status: APIPaymentPending}
+		if _, stillPending := a.Payments.GetPayment(paymentID); stillPending {
+			writeJSON(w, http.StatusOK, payment)
+			return
+		}
+		if transaction, err := services.LoadTransactionByID(paymentID); err == nil {
+			payment.Status = APIPaymentSucceeded
+			payment.Amount = transaction.AmountPaid()
+		} else {
+			payment.Status = APIPaymentFailed
+			payment.Message = result.Message
+		}
+		writeJSON(w, http.StatusOK, payment)
+		return
+	}
+
+	transaction, err := services.LoadTransactionByID(paymentID)
+	if err != nil {
+		writeAPIError(w, &APIError{http.StatusNotFound, APIErrorNotFound, fmt.Sprintf("No payment %s", paymentID)})
+		return
+	}
+	writeJSON(w, http.StatusOK, APIPayment{
+		ID:     paymentID,
+		Method: transaction.PaymentType,
+		Status: APIPaymentSucceeded,
+		Amount: transaction.AmountPaid(),
+	})
+}
+
+// APITransactionsHandler lists a day's successful transactions (?date=YYYY-MM-DD, default today)
+func (a *App) APITransactionsHandler(w http.ResponseWriter, r *http.Request) {
+	day := config.BusinessDay(time.Now())
+	if date := r.URL.Query().Get("date"); date != "" {
+		parsed, err := time.ParseInLocation("2006-01-02", date, config.GetBusinessLocation())
+		if err != nil {
+			writeAPIError(w, &APIError{http.StatusBadRequest, APIErrorInvalidRequest, "date must be YYYY-MM-DD"})
+			return
+		}
+		day = parsed
+	}
+
+	transactions, err := services.LoadTransactionsForDay(day)
+	if err != nil {
+		utils.Error("api", "Error loading transactions", "date", day.Format("2006-01-02"), "error", err)
+		writeAPIError(w, &APIError{http.StatusInternalServerError, APIErrorInternal, "Error reading the transaction log"})
+		return
+	}
+	writeJSON(w, http.StatusOK, APITransactions{Date: day.Format("2006-01-02"), Transactions: transactions})
+}
+
+// APITransactionHandler returns a single successful transaction by its ID
+func (a *App) APITransactionHandler(w http.ResponseWriter, r *http.Request) {
+	transaction, err := services.LoadTransactionByID(r.PathValue("id"))
+	if err != nil {
+		writeAPIError(w, &APIError{http.StatusNotFound, APIErrorNotFound, fmt.Sprintf("No transaction %s", r.PathValue("id"))})
+		return
+	}
+	writeJSON(w, http.StatusOK, transaction)
+}
+
+// checkCartUnlocked refuses cart changes and new payments while the cart is being paid for
+func (a *App) checkCartUnlocked(cart *services.CartStore) *APIError {
+	if a.Payments.ActiveCountFor(cart) > 0 || cart.Split() != nil || a.manualAuthPending() {
+		return &APIError{http.StatusConflict, APIErrorPaymentInProgress, "The cart is being paid for; wait for the payment to finish"}
+	}
+	return nil
+}
+
+// apiCartLines resolves an item request into cart lines, one per unit, or a single line for the
+// measured quantity of a product sold by weight or length
+func apiCartLines(item APICartItemRequest) ([]templates.Product, *APIError) {
+	var product templates.Product
+	switch {
+	case item.ProductID != "":
+		for _, p := range services.Catalog.Products() {
+			if p.ID == item.ProductID {
+				product = p
+				break
+			}
+		}
+		if product.ID == "" {
+			return nil, &APIError{http.StatusNotFound, APIErrorNotFound, fmt.Sprintf("No product %s", item.ProductID)}
+		}
+	case item.SKU != "":
+		found, exists := services.FindProductBySKU(services.NormalizeSKU(item.SKU))
+		if !exists {
+			return nil, &APIError{http.StatusNotFound, APIErrorNotFound, fmt.Sprintf("No product with SKU %s", item.SKU)}
+		}
+		product = found
+	case item.Name != "":
+		if item.Price <= 0 {
+			return nil, &APIError{http.StatusBadRequest, APIErrorInvalidRequest, "price must be greater than zero"}
+		}
+		custom, err := services.NewCustomProduct(item.Name, item.Description, strconv.FormatFloat(item.Price, 'f', -1, 64))
+		if err != nil {
+			return nil, &APIError{http.StatusBadRequest, APIErrorInvalidRequest, err.Error()}
+		}
+		product = custom
+	default:
+		return nil, &APIError{http.StatusBadRequest, APIErrorInvalidRequest, "an item needs a productID, sku, or name and price"}
+	}
+	if !product.GiftCard {
+		product = services.SaleLine(product, time.Now())
+	}
+
+	if services.SoldByMeasure(product) {
+		text := ""
+		if item.Quantity != 0 {
+			text = strconv.FormatFloat(item.Quantity, 'f', -1, 64)
+		}
+		quantity, err := validation.Quantity(text, services.QuantityDecimals(), services.MaxMeasuredQuantity)
+		if err != nil {
+			return nil, &APIError{http.StatusBadRequest, APIErrorInvalidRequest, err.Error()}
+		}
+		return []templates.Product{services.MeasuredLine(product, quantity)}, nil
+	}
+
+	quantity := item.Quantity
+	if quantity == 0 {
+		quantity = 1
+	}
+	if quantity != math.Trunc(quantity) || quantity < 0 || quantity > apiMaxQuantity {
+		return nil, &APIError{http.StatusBadRequest, APIErrorInvalidRequest, fmt.Sprintf("quantity must be a whole number between 1 and %d", apiMaxQuantity)}
+	}
+
+	lines := make([]templates.Product, 0, int(quantity))
+	for i := 0; i < int(quantity); i++ {
+		line := product
+		// Each gift card sold through the API is a new card
+		if product.GiftCard {
+			giftCard, err := services.NewGiftCardLine(product, "")
+			if err != nil {
+				return nil, &APIError{http.StatusInternalServerError, APIErrorInternal, err.Error()}
+			}
+			line = giftCard
+		}
+		lines = append(lines, line)
+	}
+	return lines, nil
+}
+
+// apiCart returns the cart in its API shape
+func apiCart(cart *services.CartStore) APICart {
+	summary := services.CalculateCartSummary(cart)
+	items := cart.Items()
+	return APICart{
+		Items: items,
+		Summary: APICartSummary{
+			Subtotal:   summary.Subtotal,
+			Tax:        summary.Tax,
+			ServiceFee: summary.ServiceFee,
+			Total:      summary.Total,
+		},
+	}
+}
+
+// decodeAPIRequest reads a JSON request body; an empty body is accepted only when optional
+func decodeAPIRequest(w http.ResponseWriter, r *http.Request, v interface{}, optional bool) *APIError {
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		if errors.Is(err, io.EOF) && optional {
+			return nil
+		}
+		return &APIError{http.StatusBadRequest, APIErrorInvalidRequest, "Invalid JSON body: " + err.Error()}
+	}
+	return nil
+}
+
+// writeJSON writes a JSON response with the given status
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		utils.Error("api", "Error writing API response", "error", err)
+	}
+}
+
+// writeAPIError writes an error response in the API's error shape
+func writeAPIError(w http.ResponseWriter, apiErr *APIError) {
+	writeJSON(w, apiErr.Status, APIErrorResponse{Error: apiErr})
+}
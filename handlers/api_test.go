@@ -0,0 +1,360 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stripe/stripe-go/v74"
+
+	"checkout/config"
+	"checkout/services/stripetest"
+	"checkout/templates"
+)
+
+// testAPIKey is the API key apiClient sends
+const testAPIKey = "key_integration"
+
+// apiClient is an integration calling the JSON API served by NewRouter
+type apiClient struct {
+	t      *testing.T
+	server *httptest.Server
+	key    string
+}
+
+// newAPIClient serves app with the API enabled and a coffee and a bagel in the catalog
+func newAPIClient(t *testing.T, app *App) *apiClient {
+	t.Helper()
+	config.Config.APIKeys = testAPIKey
+	useCatalog(t,
+		templates.Product{ID: "coffee", Name: "Coffee", Price: 4.50},
+		templates.Product{ID: "bagel", Name: "Bagel", Price: 3.25, SKU: "0012345"},
+	)
+	server := httptest.NewServer(NewRouter(app))
+	t.Cleanup(server.Close)
+	return &apiClient{t: t, server: server, key: testAPIKey}
+}
+
+// call sends a request with body as JSON, decodes a successful response into out and returns
+// the status. A failed request's error is returned instead.
+func (c *apiClient) call(method, path string, body, out any) (int, *APIError) {
+	c.t.Helper()
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			c.t.Fatal(err)
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, c.server.URL+path, reader)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.key != "" {
+		req.Header.Set("Authorization", "Bearer "+c.key)
+	}
+	resp, err := c.server.Client().Do(req)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if got := resp.Header.Get("Content-Type"); got != "application/json" {
+		c.t.Errorf("%s %s Content-Type = %q, want application/json", method, path, got)
+	}
+	if resp.StatusCode >= 400 {
+		var failed APIErrorResponse
+		if err := json.NewDecoder(resp.Body).Decode(&failed); err != nil || failed.Error == nil {
+			c.t.Fatalf("%s %s = %d without an error body: %v", method, path, resp.StatusCode, err)
+		}
+		return resp.StatusCode, failed.Error
+	}
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			c.t.Fatalf("%s %s: decoding response: %v", method, path, err)
+		}
+	}
+	return resp.StatusCode, nil
+}
+
+// expectAPIError fails unless a request was rejected with the given status and stable code
+func expectAPIError(t *testing.T, step string, status int, apiErr *APIError, wantStatus int, wantCode string) {
+	t.Helper()
+	if apiErr == nil || status != wantStatus || apiErr.Code != wantCode {
+		t.Errorf("%s = %d %+v, want %d %s", step, status, apiErr, wantStatus, wantCode)
+	}
+}
+
+func TestAPIAuthentication(t *testing.T) {
+	tests := []struct {
+		name       string
+		keys       string
+		send       func(req *http.Request)
+		wantStatus int
+		wantCode   string
+	}{
+		{"disabled without keys", "", func(req *http.Request) { req.Header.Set("X-API-Key", testAPIKey) }, http.StatusForbidden, APIErrorDisabled},
+		{"no key", testAPIKey, func(*http.Request) {}, http.StatusUnauthorized, APIErrorUnauthorized},
+		{"wrong key", testAPIKey, func(req *http.Request) { req.Header.Set("Authorization", "Bearer key_other") }, http.StatusUnauthorized, APIErrorUnauthorized},
+		{"bearer key", testAPIKey, func(req *http.Request) { req.Header.Set("Authorization", "Bearer "+testAPIKey) }, http.StatusOK, ""},
+		{"X-API-Key", testAPIKey, func(req *http.Request) { req.Header.Set("X-API-Key", testAPIKey) }, http.StatusOK, ""},
+		{"any of several keys", "key_kiosk, " + testAPIKey, func(req *http.Request) { req.Header.Set("X-API-Key", testAPIKey) }, http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, _, _ := newTestApp(t)
+			newAPIClient(t, app)
+			config.Config.APIKeys = tt.keys
+
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/products", nil)
+			tt.send(req)
+			rec := httptest.NewRecorder()
+			NewRouter(app).ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.wantCode != "" {
+				var failed APIErrorResponse
+				if err := json.Unmarshal(rec.Body.Bytes(), &failed); err != nil || failed.Error == nil || failed.Error.Code != tt.wantCode {
+					t.Errorf("body = %s, want error code %s", rec.Body, tt.wantCode)
+				}
+			}
+		})
+	}
+}
+
+func TestAPICart(t *testing.T) {
+	app, _, _ := newTestApp(t)
+	api := newAPIClient(t, app)
+
+	var products APIProducts
+	if status, apiErr := api.call(http.MethodGet, "/api/v1/products", nil, &products); apiErr != nil || len(products.Products) != 2 {
+		t.Fatalf("products = %d %+v %v", status, apiErr, products)
+	}
+
+	var cart APICart
+	status, apiErr := api.call(http.MethodPost, "/api/v1/cart", APICreateCartRequest{Items: []APICartItemRequest{
+		{ProductID: "coffee", Quantity: 2},
+		{Name: "Muffin", Price: 2.75},
+	}}, &cart)
+	if apiErr != nil || status != http.StatusCreated {
+		t.Fatalf("create cart = %d %+v", status, apiErr)
+	}
+	if len(cart.Items) != 3 || cart.Items[0].Name != "Coffee" || cart.Items[2].Name != "Muffin" {
+		t.Errorf("cart items = %v, want two coffees and a muffin", cart.Items)
+	}
+	if cart.Summary.Subtotal != 11.75 || cart.Summary.Total != cart.Summary.Subtotal+cart.Summary.Tax {
+		t.Errorf("summary = %+v, want a subtotal of 11.75", cart.Summary)
+	}
+
+	api.call(http.MethodPost, "/api/v1/cart/items", APICartItemRequest{SKU: " 0012345 "}, &cart)
+	api.call(http.MethodDelete, "/api/v1/cart/items/0", nil, &cart)
+	if len(cart.Items) != 3 || cart.Items[0].Name != "Coffee" || cart.Items[2].Name != "Bagel" {
+		t.Errorf("cart items = %v, want a coffee, the muffin and the scanned bagel", cart.Items)
+	}
+
+	// The API's cart is its own, apart from any register's
+	if sessionCart(app).Len() != 0 {
+		t.Errorf("API items went into a register's cart")
+	}
+	var fetched APICart
+	api.call(http.MethodGet, "/api/v1/cart", nil, &fetched)
+	if len(fetched.Items) != 3 || fetched.Summary != cart.Summary {
+		t.Errorf("fetched cart = %+v, want %+v", fetched, cart)
+	}
+
+	status, apiErr = api.call(http.MethodPost, "/api/v1/cart", APICreateCartRequest{Items: []APICartItemRequest{{ProductID: "bagel"}, {ProductID: "scone"}}}, nil)
+	expectAPIError(t, "create with an unknown product", status, apiErr, http.StatusNotFound, APIErrorNotFound)
+	api.call(http.MethodGet, "/api/v1/cart", nil, &fetched)
+	if len(fetched.Items) != 3 {
+		t.Errorf("a rejected cart replaced the cart with %v", fetched.Items)
+	}
+
+	api.call(http.MethodDelete, "/api/v1/cart", nil, &cart)
+	if len(cart.Items) != 0 || cart.Summary.Total != 0 {
+		t.Errorf("cleared cart = %+v", cart)
+	}
+}
+
+func TestAPIRejectsInvalidRequests(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		body       any
+		wantStatus int
+		wantCode   string
+	}{
+		{"unknown product", http.MethodPost, "/api/v1/cart/items", APICartItemRequest{ProductID: "scone"}, http.StatusNotFound, APIErrorNotFound},
+		{"unknown SKU", http.MethodPost, "/api/v1/cart/items", APICartItemRequest{SKU: "999"}, http.StatusNotFound, APIErrorNotFound},
+		{"custom item without a price", http.MethodPost, "/api/v1/cart/items", APICartItemRequest{Name: "Muffin"}, http.StatusBadRequest, APIErrorInvalidRequest},
+		{"fractional quantity", http.MethodPost, "/api/v1/cart/items", APICartItemRequest{ProductID: "coffee", Quantity: 1.5}, http.StatusBadRequest, APIErrorInvalidRequest},
+		{"too many", http.MethodPost, "/api/v1/cart/items", APICartItemRequest{ProductID: "coffee", Quantity: apiMaxQuantity + 1}, http.StatusBadRequest, APIErrorInvalidRequest},
+		{"empty item", http.MethodPost, "/api/v1/cart/items", APICartItemRequest{}, http.StatusBadRequest, APIErrorInvalidRequest},
+		{"unknown field", http.MethodPost, "/api/v1/cart/items", map[string]any{"productID": "coffee", "qty": 2}, http.StatusBadRequest, APIErrorInvalidRequest},
+		{"no item at the index", http.MethodDelete, "/api/v1/cart/items/3", nil, http.StatusNotFound, APIErrorNotFound},
+		{"index isn't a number", http.MethodDelete, "/api/v1/cart/items/first", nil, http.StatusNotFound, APIErrorNotFound},
+		{"payment for an empty cart", http.MethodPost, "/api/v1/payments", APIPaymentRequest{Method: "qr"}, http.StatusConflict, APIErrorCartEmpty},
+		{"unknown payment", http.MethodGet, "/api/v1/payments/pi_unknown", nil, http.StatusNotFound, APIErrorNotFound},
+		{"unknown transaction", http.MethodGet, "/api/v1/transactions/pi_unknown", nil, http.StatusNotFound, APIErrorNotFound},
+		{"bad date", http.MethodGet, "/api/v1/transactions?date=yesterday", nil, http.StatusBadRequest, APIErrorInvalidRequest},
+		{"unknown endpoint", http.MethodGet, "/api/v1/customers", nil, http.StatusNotFound, APIErrorNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, _, _ := newTestApp(t)
+			api := newAPIClient(t, app)
+
+			status, apiErr := api.call(tt.method, tt.path, tt.body, nil)
+			expectAPIError(t, tt.name, status, apiErr, tt.wantStatus, tt.wantCode)
+		})
+	}
+}
+
+func TestAPIQRPayment(t *testing.T) {
+	app, fake, _ := newTestApp(t)
+	api := newAPIClient(t, app)
+	api.call(http.MethodPost, "/api/v1/cart/items", APICartItemRequest{ProductID: "coffee"}, nil)
+
+	var payment APIPayment
+	status, apiErr := api.call(http.MethodPost, "/api/v1/payments", APIPaymentRequest{Method: "qr"}, &payment)
+	if apiErr != nil || status != http.StatusCreated {
+		t.Fatalf("start payment = %d %+v", status, apiErr)
+	}
+	if payment.Status != APIPaymentPending || payment.Method != "qr" || payment.URL == "" {
+		t.Errorf("payment = %+v, want a pending payment link", payment)
+	}
+	if amount := fake.LinkAmount(payment.ID); amount != int64(payment.Amount*100+0.5) {
+		t.Errorf("link charges %d cents for a payment of %v", amount, payment.Amount)
+	}
+
+	status, apiErr = api.call(http.MethodPost, "/api/v1/cart/items", APICartItemRequest{ProductID: "bagel"}, nil)
+	expectAPIError(t, "change the cart being paid", status, apiErr, http.StatusConflict, APIErrorPaymentInProgress)
+	status, apiErr = api.call(http.MethodPost, "/api/v1/payments", APIPaymentRequest{Method: "qr"}, nil)
+	expectAPIError(t, "pay twice", status, apiErr, http.StatusConflict, APIErrorPaymentInProgress)
+
+	var polled APIPayment
+	api.call(http.MethodGet, "/api/v1/payments/"+payment.ID, nil, &polled)
+	if polled.Status != APIPaymentPending {
+		t.Errorf("unpaid link = %+v, want pending", polled)
+	}
+
+	fake.CompleteLink(payment.ID, "customer@example.com")
+	api.call(http.MethodGet, "/api/v1/payments/"+payment.ID, nil, &polled)
+	if polled.Status != APIPaymentSucceeded || polled.Amount != payment.Amount {
+		t.Fatalf("paid link = %+v, want succeeded for %v", polled, payment.Amount)
+	}
+
+	var cart APICart
+	api.call(http.MethodGet, "/api/v1/cart", nil, &cart)
+	if len(cart.Items) != 0 {
+		t.Errorf("paid cart still has %v", cart.Items)
+	}
+	var transaction templates.Transaction
+	api.call(http.MethodGet, "/api/v1/transactions/"+payment.ID, nil, &transaction)
+	if transaction.ID != payment.ID || transaction.PaymentType != "qr" {
+		t.Errorf("transaction = %+v, want the QR sale", transaction)
+	}
+	var day APITransactions
+	api.call(http.MethodGet, "/api/v1/transactions", nil, &day)
+	if len(day.Transactions) != 1 || day.Transactions[0].ID != payment.ID {
+		t.Errorf("today's transactions = %+v, want the sale", day.Transactions)
+	}
+	api.call(http.MethodGet, "/api/v1/payments/"+payment.ID, nil, &polled)
+	if polled.Status != APIPaymentSucceeded {
+		t.Errorf("recorded payment = %+v, want succeeded", polled)
+	}
+}
+
+func TestAPITerminalPayment(t *testing.T) {
+	tests := []struct {
+		name        string
+		finish      func(fake *stripetest.Client, intentID string)
+		wantStatus  string
+		wantCartLen int
+	}{
+		{"approved", func(fake *stripetest.Client, intentID string) {
+			fake.SetIntentStatus(intentID, stripe.PaymentIntentStatusSucceeded)
+		}, APIPaymentSucceeded, 0},
+		{"declined", func(fake *stripetest.Client, intentID string) {
+			fake.DeclineOnReader(intentID)
+		}, APIPaymentFailed, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, fake, _ := newTestApp(t)
+			api := newAPIClient(t, app)
+			api.call(http.MethodPost, "/api/v1/cart/items", APICartItemRequest{ProductID: "coffee"}, nil)
+
+			var payment APIPayment
+			status, apiErr := api.call(http.MethodPost, "/api/v1/payments", APIPaymentRequest{Method: "terminal", ReaderID: stripetest.ReaderID}, &payment)
+			if apiErr != nil || status != http.StatusCreated {
+				t.Fatalf("start payment = %d %+v", status, apiErr)
+			}
+			if payment.Status != APIPaymentPending || payment.ReaderID != stripetest.ReaderID {
+				t.Errorf("payment = %+v, want pending on the reader", payment)
+			}
+
+			tt.finish(fake, payment.ID)
+			var polled APIPayment
+			api.call(http.MethodGet, "/api/v1/payments/"+payment.ID, nil, &polled)
+
+			if polled.Status != tt.wantStatus {
+				t.Errorf("payment = %+v, want %s", polled, tt.wantStatus)
+			}
+			if tt.wantStatus == APIPaymentFailed && polled.Message == "" {
+				t.Errorf("failed payment doesn't say why")
+			}
+			var cart APICart
+			api.call(http.MethodGet, "/api/v1/cart", nil, &cart)
+			if len(cart.Items) != tt.wantCartLen {
+				t.Errorf("cart has %d items, want %d", len(cart.Items), tt.wantCartLen)
+			}
+		})
+	}
+}
+
+func TestAPIPaymentErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		request    APIPaymentRequest
+		fail       string
+		wantStatus int
+		wantCode   string
+	}{
+		{"unknown method", APIPaymentRequest{Method: "cash"}, "", http.StatusBadRequest, APIErrorInvalidRequest},
+		{"unknown reader", APIPaymentRequest{Method: "terminal", ReaderID: "tmr_missing"}, "", http.StatusConflict, APIErrorReaderUnavailable},
+		{"Stripe rejects the link", APIPaymentRequest{Method: "qr"}, "CreatePaymentLink", http.StatusBadGateway, APIErrorStripe},
+		{"Stripe rejects the intent", APIPaymentRequest{Method: "terminal", ReaderID: stripetest.ReaderID}, "CreatePaymentIntent", http.StatusBadGateway, APIErrorStripe},
+		{"reader unreachable", APIPaymentRequest{Method: "terminal", ReaderID: stripetest.ReaderID}, "ProcessReaderPayment", http.StatusBadGateway, APIErrorStripe},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, fake, _ := newTestApp(t)
+			api := newAPIClient(t, app)
+			api.call(http.MethodPost, "/api/v1/cart/items", APICartItemRequest{ProductID: "coffee"}, nil)
+			if tt.fail != "" {
+				fake.FailNext(tt.fail, errors.New("stripe unavailable"))
+			}
+
+			status, apiErr := api.call(http.MethodPost, "/api/v1/payments", tt.request, nil)
+			expectAPIError(t, "start payment", status, apiErr, tt.wantStatus, tt.wantCode)
+
+			// Nothing is left holding the cart, so the integration can fix the request and retry
+			if n := app.Payments.GetActiveCount(); n != 0 {
+				t.Errorf("%d payments tracked after the failure", n)
+			}
+			status, apiErr = api.call(http.MethodPost, "/api/v1/cart/items", APICartItemRequest{ProductID: "bagel"}, nil)
+			if apiErr != nil {
+				t.Errorf("cart locked after the failure: %d %+v", status, apiErr)
+			}
+		})
+	}
+}
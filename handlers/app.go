@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"checkout/services"
+	"checkout/templates"
+)
+
+// App owns the dependencies and in-memory payment state the handlers work with.
+// Each App is independent, so several can run side by side (for example in tests).
+// The catalog and terminal selection live in the stores of the services package.
+type App struct {
+	Config   *templates.AppConfig
+	Stripe   services.StripeClient
+	Carts    *services.CartSessions // Sales in progress, one per session (see App.cart)
+	Payments *PaymentStateManager   // In-flight terminal and QR payments
+	SSE      *SSEBroadcaster        // Browser connections waiting on payment updates
+	Events   *PaymentEventLogger    // Writes payment outcomes to the transaction log
+	Webhooks *WebhookStateCache     // Payment states reported by Stripe webhooks
+	Display  *CustomerDisplay       // Customer-facing screens waiting on cart and payment changes
+	Clock    Clock                  // Time the payment timeouts and expiries are measured against
+
+	sessions       loginSessions            // Signed-in users
+	manualAuth     manualAuthentication     // Manual card payment waiting on 3D Secure
+	productImport  pendingProductImport     // Catalog upload waiting for confirmation
+	terminalEmail  terminalEmailCollections // Readers asking customers for a receipt email
+	receiptResends receiptResends           // Recent receipt resends, for rate limiting
+}
+
+// NewApp creates an App with empty payment state, connects the customer display and the POS
+// screens to cart and payment changes, and starts its background webhook cache cleanup and idle cart sweep
+func NewApp(cfg *templates.AppConfig, stripeClient services.StripeClient) *App {
+	return newApp(cfg, stripeClient, systemClock{})
+}
+
+// newApp creates an App whose payment timeouts and expiries follow the given clock
+func newApp(cfg *templates.AppConfig, stripeClient services.StripeClient, clock Clock) *App {
+	payments := NewPaymentStateManager(clock)
+	app := &App{
+		Config:   cfg,
+		Stripe:   stripeClient,
+		Carts:    services.NewCartSessions(),
+		Payments: payments,
+		SSE:      NewSSEBroadcaster(clock),
+		Events:   NewPaymentEventLogger(payments, stripeClient, cfg),
+		Webhooks: NewWebhookStateCache(clock),
+		Display:  NewCustomerDisplay(),
+		Clock:    clock,
+	}
+	app.Carts.OnChange(app.Display.Notify)
+	app.Carts.OnChange(publishCartUpdated)
+	payments.OnChange(app.Display.Notify)
+	app.Events.OnSale(app.Display.SaleCompleted)
+	app.startWebhookCacheCleanup()
+	app.startIdleCartSweep()
+	app.startSentLinkCheck()
+	return app
+}
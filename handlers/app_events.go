@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"checkout/i18n"
+	"checkout/services"
+	"checkout/utils"
+)
+
+const (
+	appEventsHeartbeat   = 20 * time.Second // Finds dropped connections and keeps proxies from closing an idle stream
+	appEventsReconnectIn = 3000             // Milliseconds the browser waits before reconnecting a dropped stream
+)
+
+// AppEventsHandler streams the updates pushed to a POS screen over SSE: cart changes, toasts,
+// reader status and payments recorded by webhook while nobody was watching. Payment progress
+// keeps its own stream (/payment-events). The stream ends when the session does.
+func (a *App) AppEventsHandler(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "SSE not supported by client", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	client := services.SubscribeAppEvents(sessionToken(r))
+	defer services.UnsubscribeAppEvents(client)
+
+	heartbeat := time.NewTicker(appEventsHeartbeat)
+	defer heartbeat.Stop()
+
+	if _, err := fmt.Fprintf(w, "retry: %d\n\n", appEventsReconnectIn); err != nil {
+		return
+	}
+	flusher.Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event := <-client.Events:
+			if err := writeAppEvent(w, event); err != nil {
+				utils.Debug("sse", "Error writing app event, closing stream", "event", event.Name, "error", err)
+				return
+			}
+			flusher.Flush()
+		case <-heartbeat.C:
+			if _, ok := a.sessionUser(r); !ok {
+				return // Signed out; reconnecting sends the browser to the login page
+			}
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// writeAppEvent writes an event with its detail as JSON data, which the page passes on as the
+// detail of the event of the same name
+func writeAppEvent(w http.ResponseWriter, event services.AppEvent) error {
+	var detail any = true
+	if event.Detail != nil {
+		detail = event.Detail
+	}
+	data, err := json.Marshal(detail)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Name, data)
+	return err
+}
+
+// publishCartUpdated tells the POS screens the cart changed, so a change made by a webhook,
+// the idle cart sweep or another screen shows without a click
+func publishCartUpdated() {
+	services.PublishAppEvent(services.AppEvent{Name: services.EventCartUpdated})
+}
+
+// publishPaymentRecovered tells the POS screens that a webhook recorded a payment nobody was
+// watching, for example one whose modal was closed, so the cart and banners catch up
+func publishPaymentRecovered(paymentID, paymentType string) {
+	utils.Info("payment", "Payment completed by webhook with no screen watching", "payment_id", paymentID, "payment_type", paymentType)
+	services.PublishAppEvent(services.AppEvent{Name: services.EventPaymentRecovered, Detail: map[string]string{"paymentId": paymentID, "type": paymentType}})
+	services.PublishToast("success", i18n.T("toast.payment_recovered"))
+}
@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"net/url"
+	"sync"
+	"testing"
+
+	"github.com/stripe/stripe-go/v74"
+)
+
+func TestAppsDontShareCartsOrPayments(t *testing.T) {
+	qrApp, qrStripe, _ := newTestApp(t)
+	terminalApp, terminalStripe, _ := newTestApp(t)
+	addToCart(qrApp, "Coffee", 4.50)
+	addToCart(terminalApp, "Bagel", 3.25)
+	addToCart(terminalApp, "Juice", 5.00)
+
+	displayUpdates := terminalApp.Display.Subscribe()
+	defer terminalApp.Display.Unsubscribe(displayUpdates)
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		postForm(qrApp.GenerateQRCodeHandler, "/generate-qr-code", url.Values{})
+	}()
+	go func() {
+		defer wg.Done()
+		postForm(terminalApp.ProcessPaymentHandler, "/process-payment", url.Values{"payment_method": {"terminal"}})
+	}()
+	wg.Wait()
+
+	if terminal, qr := qrApp.Payments.GetActiveCountByType(); qr != 1 || terminal != 0 {
+		t.Errorf("QR app payments = %d QR, %d terminal; want 1, 0", qr, terminal)
+	}
+	if terminal, qr := terminalApp.Payments.GetActiveCountByType(); qr != 0 || terminal != 1 {
+		t.Errorf("terminal app payments = %d QR, %d terminal; want 0, 1", qr, terminal)
+	}
+	if qrStripe.Calls("ProcessReaderPayment") != 0 || terminalStripe.Calls("CreatePaymentLink") != 0 {
+		t.Errorf("a payment went through the other App's Stripe client")
+	}
+
+	// Finishing one App's sale clears only its own cart
+	intentID := terminalApp.Payments.GetStatesByType("terminal")[0].GetID()
+	terminalStripe.SetIntentStatus(intentID, stripe.PaymentIntentStatusSucceeded)
+	if result := terminalApp.checkTerminalPaymentStatus(intentID); !result.ShouldStop {
+		t.Fatalf("terminal payment didn't complete")
+	}
+	if sessionCart(terminalApp).Len() != 0 {
+		t.Errorf("terminal app cart has %d items after its sale, want 0", sessionCart(terminalApp).Len())
+	}
+	if items := sessionCart(qrApp).Items(); len(items) != 1 || items[0].Name != "Coffee" {
+		t.Errorf("QR app cart = %v, want its coffee still waiting on the QR payment", items)
+	}
+
+	// Changes to one App's cart don't redraw the other App's customer display
+	for len(displayUpdates) > 0 {
+		<-displayUpdates
+	}
+	addToCart(qrApp, "Muffin", 2.75)
+	select {
+	case <-displayUpdates:
+		t.Errorf("terminal app's display was told about the QR app's cart")
+	default:
+	}
+
+	// Each App writes its sales to its own transaction log
+	linkID := qrApp.Payments.GetStatesByType("qr")[0].GetID()
+	qrStripe.CompleteLink(linkID, "customer@example.com")
+	if result := qrApp.checkQRPaymentStatus(linkID); !result.ShouldStop {
+		t.Fatalf("QR payment didn't complete")
+	}
+	if qrApp.Config.TransactionsDir == terminalApp.Config.TransactionsDir {
+		t.Fatalf("both apps log transactions to %s", qrApp.Config.TransactionsDir)
+	}
+	logs := []struct {
+		name  string
+		app   *App
+		own   string
+		other string
+	}{
+		{"QR app", qrApp, linkID, intentID},
+		{"terminal app", terminalApp, intentID, linkID},
+	}
+	for _, log := range logs {
+		if rows := transactionRowsIn(t, log.app.Config.TransactionsDir, log.own); rows == 0 {
+			t.Errorf("%s log has no rows of its own sale", log.name)
+		}
+		if rows := transactionRowsIn(t, log.app.Config.TransactionsDir, log.other); rows != 0 {
+			t.Errorf("%s log has %d rows of the other app's sale, want 0", log.name, rows)
+		}
+	}
+}
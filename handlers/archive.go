@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"checkout/config"
+	"checkout/services"
+	"checkout/templates/reports"
+	"checkout/utils"
+)
+
+// ArchiveHandler shows the disk used by each month of transaction files (GET), archives the
+// months past the threshold now (POST action=archive), and deletes an archived month past the
+// retention period once its deletion is typed out (POST action=delete)
+func (a *App) ArchiveHandler(w http.ResponseWriter, r *http.Request) {
+	message, errorMessage := "", ""
+
+	switch r.Method {
+	case http.MethodGet:
+
+	case http.MethodPost:
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "Invalid form data", http.StatusBadRequest)
+			return
+		}
+		switch r.FormValue("action") {
+		case "archive":
+			archived, err := services.ArchiveOldMonths(time.Now())
+			if err != nil {
+				utils.Error("archive", "Archiving failed", "error", err)
+				errorMessage = "Archiving stopped: " + err.Error()
+			} else if len(archived) == 0 {
+				message = "There was nothing to archive."
+			} else {
+				utils.Info("audit", "Transaction files archived", "months", archived, "user", currentUsername(r))
+				message = fmt.Sprintf("Archived %s.", strings.Join(archived, ", "))
+			}
+		case "delete":
+			month := r.FormValue("month")
+			if err := services.DeleteArchivedMonth(month, strings.TrimSpace(r.FormValue("confirmation")), currentUsername(r), time.Now()); err != nil {
+				utils.Warn("archive", "Archived month not deleted", "month", month, "error", err)
+				errorMessage = fmt.Sprintf("%s not deleted: %s", month, err.Error())
+			} else {
+				message = fmt.Sprintf("Deleted the records of %s.", month)
+			}
+		default:
+			http.Error(w, "Unknown action", http.StatusBadRequest)
+			return
+		}
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	months, err := services.DataUsage(time.Now())
+	if err != nil {
+		utils.Error("archive", "Error summarizing data usage", "error", err)
+		if errorMessage == "" {
+			errorMessage = "Could not read the transaction files: " + err.Error()
+		}
+	}
+
+	component := reports.ArchivePage(months, config.Config.ArchiveAfterMonths, config.Config.RetentionMonths, message, errorMessage)
+	if err := component.Render(r.Context(), w); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -1,15 +1,47 @@
 package handlers
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"html"
 	"net/http"
+	"sync"
+	"time"
 
 	"checkout/config"
+	"checkout/i18n"
+	"checkout/services"
 	"checkout/templates"
 	"checkout/utils"
 )
 
-// Authentication middleware
-func AuthMiddleware(next http.Handler) http.Handler {
+// sessionCookieName holds the session token of a signed-in user
+const sessionCookieName = "auth"
+
+// sessionLifetime is how long a login lasts
+const sessionLifetime = 8 * time.Hour
+
+// loginSessions tracks signed-in users by session token. Sessions are kept in memory, so a
+// restart signs everyone out.
+type loginSessions struct {
+	byToken map[string]*loginSession
+	mutex   sync.Mutex
+}
+
+// loginSession is one signed-in browser. Only the username is kept; the role is read from
+// the config on every request, so changing or removing a user takes effect immediately.
+type loginSession struct {
+	username       string
+	expires        time.Time
+	lastActivity   time.Time // Last request that changed something (see ScreenLockMiddleware)
+	locked         bool      // The screen is locked until the password or PIN is entered again
+	pinAttempts    int       // Wrong PINs entered since the last switch (see SwitchUserHandler)
+	unlockAttempts int       // Wrong passwords or PINs entered on the lock screen (see UnlockHandler)
+}
+
+// AuthMiddleware sends requests without a valid session to the login page and passes the
+// signed-in user to handlers and templates through the request context
+func (a *App) AuthMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Skip authentication for login page and static assets
 		if r.URL.Path == "/login" || r.URL.Path == "/static/" || r.URL.Path == "/static/css/styles.css" {
@@ -17,34 +49,74 @@ func AuthMiddleware(next http.Handler) http.Handler {
 			return
 		}
 
-		// Check if authenticated
-		cookie, err := r.Cookie("auth")
-		if err != nil || cookie.Value != "authenticated" {
+		user, ok := a.sessionUser(r)
+		if !ok {
+			// HTMX would follow a redirect and swap the login page into a fragment of the POS,
+			// so the whole page is sent there instead
+			if isHTMX(r) {
+				w.Header().Set("HX-Redirect", "/login")
+				w.WriteHeader(http.StatusOK)
+				return
+			}
 			http.Redirect(w, r, "/login", http.StatusSeeOther)
 			return
 		}
 
-		next.ServeHTTP(w, r)
+		// Payments are credited to the user who last changed something at the register
+		if !isSafeMethod(r.Method) {
+			a.cart(r).SetCashier(user.Username)
+		}
+
+		next.ServeHTTP(w, r.WithContext(templates.WithUser(r.Context(), user)))
 	})
 }
 
+// AdminOnly restricts a route to admins. Cashiers get a 403 and an error toast.
+func (a *App) AdminOnly(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !templates.IsAdmin(r.Context()) {
+			user, _ := templates.CurrentUser(r.Context())
+			utils.Warn("auth", "Admin-only route refused", "user", user.Username, "role", user.Role, "method", r.Method, "path", r.URL.Path)
+			setToast(w, "error", "toast.admin_only")
+			http.Error(w, "Only an admin can do that", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// AdminOnlyChanges lets everyone read a route but restricts changing it to admins
+func (a *App) AdminOnlyChanges(next http.HandlerFunc) http.HandlerFunc {
+	admin := a.AdminOnly(next)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if isSafeMethod(r.Method) {
+			next(w, r)
+			return
+		}
+		admin(w, r)
+	}
+}
+
 // LoginHandler handles the login page
-func LoginHandler(w http.ResponseWriter, r *http.Request) {
+func (a *App) LoginHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method == "POST" {
 		if err := r.ParseForm(); err != nil {
 			http.Error(w, "Error parsing form", http.StatusBadRequest)
 			return
 		}
 
-		if r.FormValue("password") == config.Config.Password {
+		if user, ok := config.AuthenticateUser(r.FormValue("username"), r.FormValue("password")); ok {
 			// Set authentication cookie
 			http.SetCookie(w, &http.Cookie{
-				Name:     "auth",
-				Value:    "authenticated",
+				Name:     sessionCookieName,
+				Value:    a.startSession(user.Username),
 				Path:     "/",
-				MaxAge:   3600 * 8, // 8 hours
+				MaxAge:   int(sessionLifetime.Seconds()),
 				HttpOnly: true,
+				SameSite: http.SameSiteLaxMode,
 			})
+			issueCSRFToken(w)
+			utils.Info("auth", "User signed in", "user", user.Username, "role", user.Role)
 
 			// For HTMX requests, we need to set specific headers to ensure proper redirection
 			// Skip any target processing entirely to prevent content from loading in the error div
@@ -56,36 +128,56 @@ func LoginHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		// Wrong PIN - direct error message in the target element
+		utils.Warn("auth", "Failed sign-in", "user", r.FormValue("username"))
+
+		// Wrong username or password - direct error message in the target element
 		// Using HTTP 200 status because HTMX only processes successful responses for DOM insertion by default
 		// The error is communicated to the user through the response content, not the HTTP status code
 		w.Header().Set("Content-Type", "text/html")
-		if _, err := w.Write([]byte(`<div class="error-message">Invalid password. Please try again.</div>`)); err != nil {
+		if _, err := w.Write([]byte(`<div class="error-message">` + html.EscapeString(i18n.T("login.invalid")) + `</div>`)); err != nil {
 			utils.Error("auth", "Error writing error message to response", "error", err)
 		}
 		return
 	}
 
 	// Check if already logged in
-	cookie, err := r.Cookie("auth")
-	if err == nil && cookie.Value == "authenticated" {
+	if _, ok := a.sessionUser(r); ok {
 		http.Redirect(w, r, "/", http.StatusSeeOther)
 		return
 	}
 
 	// Display login page using templ
 	component := templates.LoginPage()
-	err = component.Render(r.Context(), w)
+	err := component.Render(r.Context(), w)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 	}
 }
 
 // LogoutHandler handles user logout
-func LogoutHandler(w http.ResponseWriter, r *http.Request) {
+func (a *App) LogoutHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if cookie, err := r.Cookie(sessionCookieName); err == nil {
+		a.sessions.mutex.Lock()
+		delete(a.sessions.byToken, cookie.Value)
+		a.sessions.mutex.Unlock()
+		a.Carts.Drop(sessionCartKey(cookie.Value))
+	}
+
 	// Clear authentication cookie
 	http.SetCookie(w, &http.Cookie{
-		Name:     "auth",
+		Name:     sessionCookieName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+	})
+	http.SetCookie(w, &http.Cookie{
+		Name:     csrfCookieName,
 		Value:    "",
 		Path:     "/",
 		MaxAge:   -1,
@@ -97,3 +189,73 @@ func LogoutHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("HX-Redirect", "/login")
 	w.WriteHeader(http.StatusOK)
 }
+
+// startSession signs a user in and returns the new session token
+func (a *App) startSession(username string) string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		utils.Error("auth", "Error generating session token", "error", err)
+		return ""
+	}
+	token := hex.EncodeToString(b)
+
+	a.sessions.mutex.Lock()
+	defer a.sessions.mutex.Unlock()
+	if a.sessions.byToken == nil {
+		a.sessions.byToken = make(map[string]*loginSession)
+	}
+	// Drop expired sessions while we're here
+	for t, session := range a.sessions.byToken {
+		if time.Now().After(session.expires) {
+			delete(a.sessions.byToken, t)
+			a.Carts.Drop(sessionCartKey(t))
+		}
+	}
+	now := time.Now()
+	a.sessions.byToken[token] = &loginSession{username: username, expires: now.Add(sessionLifetime), lastActivity: now}
+	return token
+}
+
+// sessionUser returns the user signed in with the request's session cookie. A user removed
+// from the config is signed out.
+func (a *App) sessionUser(r *http.Request) (templates.User, bool) {
+	cookie, err := r.Cookie(sessionCookieName)
+	if err != nil || cookie.Value == "" {
+		return templates.User{}, false
+	}
+
+	a.sessions.mutex.Lock()
+	session, found := a.sessions.byToken[cookie.Value]
+	if found && time.Now().After(session.expires) {
+		delete(a.sessions.byToken, cookie.Value)
+		a.Carts.Drop(sessionCartKey(cookie.Value))
+		found = false
+	}
+	a.sessions.mutex.Unlock()
+	if !found {
+		return templates.User{}, false
+	}
+
+	return config.GetUser(session.username)
+}
+
+// cart returns the sale in progress of the request's session: the signed-in browser's login
+// session, or for the REST API the key the request was sent with. Each session has its own
+// cart, so registers signed in at the same time don't ring up into each other's sale.
+func (a *App) cart(r *http.Request) *services.CartStore {
+	if cookie, err := r.Cookie(sessionCookieName); err == nil && cookie.Value != "" {
+		return a.Carts.Get(sessionCartKey(cookie.Value))
+	}
+	return a.Carts.Get("api:" + requestAPIKey(r))
+}
+
+// sessionCartKey is the key of a login session's cart in App.Carts
+func sessionCartKey(token string) string {
+	return "session:" + token
+}
+
+// currentUsername returns the signed-in user's name for audit log entries
+func currentUsername(r *http.Request) string {
+	user, _ := templates.CurrentUser(r.Context())
+	return user.Username
+}
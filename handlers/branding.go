@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"io"
+	"net/http"
+
+	"checkout/services"
+	"checkout/templates/settings"
+	"checkout/utils"
+)
+
+// ReceiptLogoHandler serves the receipt logo (GET), replaces it with an uploaded image (POST),
+// or removes it (DELETE). Uploads and removals re-render the logo setting.
+func (a *App) ReceiptLogoHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		path := services.ReceiptLogoPath()
+		if path == "" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Cache-Control", "no-cache")
+		http.ServeFile(w, r, path)
+		return
+
+	case http.MethodPost:
+		// Leave room for the multipart framing around the file
+		r.Body = http.MaxBytesReader(w, r.Body, services.MaxReceiptLogoSize+64<<10)
+		file, _, err := r.FormFile("logo")
+		if err != nil {
+			setToast(w, "warning", "toast.logo_invalid")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		defer file.Close()
+
+		data, err := io.ReadAll(io.LimitReader(file, services.MaxReceiptLogoSize+1))
+		if err == nil {
+			err = services.SaveReceiptLogo(data)
+		}
+		if err != nil {
+			utils.Warn("branding", "Rejected receipt logo", "error", err)
+			setToastText(w, "error", err.Error())
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		setToast(w, "success", "toast.logo_updated")
+
+	case http.MethodDelete:
+		if err := services.RemoveReceiptLogo(); err != nil {
+			utils.Error("branding", "Error removing receipt logo", "error", err)
+			http.Error(w, "Error removing logo", http.StatusInternalServerError)
+			return
+		}
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := settings.ReceiptLogoSetting().Render(r.Context(), w); err != nil {
+		utils.Error("branding", "Error rendering receipt logo setting", "error", err)
+	}
+}
@@ -0,0 +1,18 @@
+package handlers
+
+import "time"
+
+// Clock tells the time to the payment timeout and expiry logic: payment states, the webhook
+// cache, the progress countdown and the SSE timeout. An App runs on the system clock; a fake one
+// lets the expiry paths be reached without waiting for them.
+type Clock interface {
+	Now() time.Time
+}
+
+// systemClock is the real time
+type systemClock struct{}
+
+// Now returns the current time
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
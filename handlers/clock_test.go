@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/a-h/templ"
+	"github.com/stripe/stripe-go/v74"
+
+	"checkout/config"
+	"checkout/services"
+)
+
+func TestTerminalPaymentTimesOutByClock(t *testing.T) {
+	app, fake, clock := newTestApp(t)
+	addToCart(app, "Coffee", 4.50)
+	intentID := startTerminalPayment(t, app)
+
+	clock.Advance(config.PaymentTimeout - time.Second)
+	if result := app.checkTerminalPaymentStatus(intentID); result.ShouldStop {
+		t.Fatalf("payment stopped a second before the timeout")
+	}
+	if fake.Intent(intentID).Status == stripe.PaymentIntentStatusCanceled {
+		t.Fatalf("intent cancelled before the timeout")
+	}
+
+	clock.Advance(2 * time.Second)
+	if result := app.checkTerminalPaymentStatus(intentID); !result.ShouldStop {
+		t.Fatalf("payment still polling after the timeout")
+	}
+	if _, tracked := app.Payments.GetPayment(intentID); tracked {
+		t.Errorf("timed out payment is still tracked")
+	}
+	if update, queued := app.SSE.pending[intentID]; !queued || update.event != "modal-update" {
+		t.Errorf("timeout wasn't queued for the browser as a modal update: %+v", update)
+	}
+}
+
+func TestQRPaymentTimesOutByClock(t *testing.T) {
+	app, fake, clock := newTestApp(t)
+	addToCart(app, "Coffee", 4.50)
+	postForm(app.GenerateQRCodeHandler, "/generate-qr-code", url.Values{})
+	linkID := app.Payments.GetStatesByType("qr")[0].GetID()
+
+	clock.Advance(config.PaymentTimeout - time.Second)
+	if result := app.checkQRPaymentStatus(linkID); result.ShouldStop {
+		t.Fatalf("QR payment stopped a second before the timeout")
+	}
+
+	clock.Advance(2 * time.Second)
+	if result := app.checkQRPaymentStatus(linkID); !result.ShouldStop {
+		t.Fatalf("QR payment still polling after the timeout")
+	}
+	if fake.Calls("DeactivatePaymentLink") != 1 {
+		t.Errorf("expired payment link wasn't deactivated")
+	}
+}
+
+func TestPaymentStatesExpireByClock(t *testing.T) {
+	clock := newFakeClock()
+	cart := services.NewCartStore()
+	payments := NewPaymentStateManager(clock)
+	payments.AddPayment(newQRPaymentState(cart, "plink_1", "https://buy.stripe.test/plink_1", clock.Now()))
+
+	clock.Advance(config.PaymentTimeout)
+	payments.CleanupExpired()
+	if _, found := payments.GetPayment("plink_1"); !found {
+		t.Fatalf("payment removed at the timeout, not after it")
+	}
+
+	clock.Advance(time.Second)
+	payments.CleanupExpired()
+	if _, found := payments.GetPayment("plink_1"); found {
+		t.Errorf("payment kept after the timeout")
+	}
+}
+
+func TestWebhookCacheExpiresByClock(t *testing.T) {
+	app, _, clock := newTestApp(t)
+	app.setCachedPaymentState("pi_1", "payment_intent", &WebhookPaymentState{ID: "pi_1", Status: "processing", Livemode: isLiveMode()})
+
+	clock.Advance(config.GetWebhookCacheTTL())
+	if _, found := app.GetCachedPaymentState("pi_1", "payment_intent"); !found {
+		t.Fatalf("state missing at the TTL, not after it")
+	}
+
+	clock.Advance(time.Second)
+	if _, found := app.GetCachedPaymentState("pi_1", "payment_intent"); found {
+		t.Errorf("state still answers after the TTL")
+	}
+	app.cleanupExpiredStates()
+	if _, cached := app.Webhooks.ByPaymentIntent["pi_1"]; cached {
+		t.Errorf("expired state wasn't evicted")
+	}
+}
+
+func TestQueuedSSEUpdatesExpireByClock(t *testing.T) {
+	clock := newFakeClock()
+	broadcaster := NewSSEBroadcaster(clock)
+	broadcaster.BroadcastModalUpdate("pi_old", templ.Raw("<p>done</p>"))
+
+	clock.Advance(config.PaymentTimeout + time.Second)
+	broadcaster.BroadcastModalUpdate("pi_new", templ.Raw("<p>done</p>"))
+
+	if _, queued := broadcaster.pending["pi_old"]; queued {
+		t.Errorf("update queued longer than the payment timeout was kept")
+	}
+	if update, queued := broadcaster.pending["pi_new"]; !queued || !update.queuedAt.Equal(clock.Now()) {
+		t.Errorf("new update queued at %v, want the clock's %v", update.queuedAt, clock.Now())
+	}
+}
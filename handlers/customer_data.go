@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"checkout/services"
+	"checkout/templates/reports"
+	"checkout/utils"
+)
+
+// CustomerDataHandler finds what the receipt and payment update logs hold about a customer,
+// by email or phone (GET customer=...), downloads it as JSON (format=json), and replaces the
+// customer's contact with its hash in every log once REDACT is typed (POST action=redact)
+func (a *App) CustomerDataHandler(w http.ResponseWriter, r *http.Request) {
+	message, errorMessage := "", ""
+
+	switch r.Method {
+	case http.MethodGet:
+
+	case http.MethodPost:
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "Invalid form data", http.StatusBadRequest)
+			return
+		}
+		if r.FormValue("action") != "redact" {
+			http.Error(w, "Unknown action", http.StatusBadRequest)
+			return
+		}
+		contact, err := services.ParseCustomerContact(r.FormValue("customer"))
+		switch {
+		case err != nil:
+			errorMessage = err.Error()
+		case strings.TrimSpace(r.FormValue("confirmation")) != "REDACT":
+			errorMessage = "Type REDACT to confirm."
+		default:
+			result, err := services.RedactCustomerData(contact, currentUsername(r))
+			if err != nil {
+				utils.Error("services", "Customer contact redaction failed", "contact", contact.Redacted(), "error", err)
+				errorMessage = "Redaction stopped: " + err.Error()
+			} else if result.Records == 0 {
+				message = "No records hold that contact."
+			} else {
+				message = fmt.Sprintf("Replaced the contact with %s in %d records of %d files.", result.Hash, result.Records, result.Files)
+			}
+		}
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	customer := strings.TrimSpace(r.FormValue("customer"))
+	var export *services.CustomerDataExport
+	if customer != "" && errorMessage == "" && message == "" {
+		contact, err := services.ParseCustomerContact(customer)
+		if err != nil {
+			errorMessage = err.Error()
+		} else if found, err := services.ExportCustomerData(contact, time.Now()); err != nil {
+			utils.Error("services", "Customer data export failed", "contact", contact.Redacted(), "error", err)
+			errorMessage = "Could not read the logs: " + err.Error()
+		} else {
+			export = &found
+		}
+	}
+
+	if export != nil && r.FormValue("format") == "json" {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="customer-data-%s.json"`, time.Now().Format("2006-01-02")))
+		utils.Info("audit", "Customer data exported", "records", len(export.Receipts)+len(export.Updates), "user", currentUsername(r))
+		if err := json.NewEncoder(w).Encode(export); err != nil {
+			utils.Error("services", "Error encoding customer data export", "error", err)
+		}
+		return
+	}
+
+	component := reports.CustomerDataPage(customer, export, message, errorMessage)
+	if err := component.Render(r.Context(), w); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,254 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/a-h/templ"
+
+	"checkout/services"
+	"checkout/templates/checkout"
+	"checkout/utils"
+)
+
+const (
+	displayCookieName   = "display_token"
+	displayThanksFor    = 8 * time.Second  // How long the thank-you screen stays up after a sale
+	displayHeartbeat    = 20 * time.Second // Keeps proxies from closing an idle stream
+	displayReconnectIn  = 3000             // Milliseconds the browser waits before reconnecting a dropped stream
+	displayCookieMaxAge = 365 * 24 * 60 * 60
+)
+
+// CustomerDisplay tells open customer displays when the cart, the payments in flight or the
+// last sale change, so they can redraw
+type CustomerDisplay struct {
+	subscribers map[chan struct{}]struct{}
+	lastSale    time.Time
+	mutex       sync.Mutex
+}
+
+// NewCustomerDisplay creates a customer display notifier with no displays connected
+func NewCustomerDisplay() *CustomerDisplay {
+	return &CustomerDisplay{subscribers: make(map[chan struct{}]struct{})}
+}
+
+// Subscribe returns a channel that receives a value whenever the display should redraw.
+// Notifications that arrive while one is still pending are merged.
+func (cd *CustomerDisplay) Subscribe() chan struct{} {
+	ch := make(chan struct{}, 1)
+	cd.mutex.Lock()
+	cd.subscribers[ch] = struct{}{}
+	cd.mutex.Unlock()
+	return ch
+}
+
+// Unsubscribe stops notifications on a channel from Subscribe
+func (cd *CustomerDisplay) Unsubscribe(ch chan struct{}) {
+	cd.mutex.Lock()
+	delete(cd.subscribers, ch)
+	cd.mutex.Unlock()
+}
+
+// Notify asks every connected display to redraw. It never blocks.
+func (cd *CustomerDisplay) Notify() {
+	cd.mutex.Lock()
+	defer cd.mutex.Unlock()
+	for ch := range cd.subscribers {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// SaleCompleted shows the thank-you screen on every display
+func (cd *CustomerDisplay) SaleCompleted() {
+	cd.mutex.Lock()
+	cd.lastSale = time.Now()
+	cd.mutex.Unlock()
+	cd.Notify()
+}
+
+// thankingUntil returns when the thank-you screen of the last sale ends
+func (cd *CustomerDisplay) thankingUntil() time.Time {
+	cd.mutex.Lock()
+	defer cd.mutex.Unlock()
+	return cd.lastSale.Add(displayThanksFor)
+}
+
+// displayToken returns the token customer displays sign in with; empty means the display is disabled
+func (a *App) displayToken() string {
+	return strings.TrimSpace(a.Config.CustomerDisplayToken)
+}
+
+// displayAuthorized checks the token in the query or the cookie set by CustomerDisplayHandler,
+// writing an error response when it doesn't match
+func (a *App) displayAuthorized(w http.ResponseWriter, r *http.Request) bool {
+	token := a.displayToken()
+	if token == "" {
+		http.Error(w, "The customer display is disabled; set a display token in settings", http.StatusForbidden)
+		return false
+	}
+
+	sent := r.URL.Query().Get("token")
+	if sent == "" {
+		if cookie, err := r.Cookie(displayCookieName); err == nil {
+			sent = cookie.Value
+		}
+	}
+	if subtle.ConstantTimeCompare([]byte(sent), []byte(token)) != 1 {
+		utils.Warn("display", "Rejected customer display request with an invalid token", "path", r.URL.Path, "remote_addr", r.RemoteAddr)
+		http.Error(w, "A valid display token is required", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+// CustomerDisplayHandler serves the customer-facing screen. Opening it with ?token=... stores the
+// token in a cookie and reloads without it, so the token doesn't stay in the address bar.
+func (a *App) CustomerDisplayHandler(w http.ResponseWriter, r *http.Request) {
+	if !a.displayAuthorized(w, r) {
+		return
+	}
+
+	if token := r.URL.Query().Get("token"); token != "" {
+		http.SetCookie(w, &http.Cookie{
+			Name:     displayCookieName,
+			Value:    token,
+			Path:     "/customer-display",
+			MaxAge:   displayCookieMaxAge,
+			HttpOnly: true,
+			SameSite: http.SameSiteStrictMode,
+		})
+		utils.Info("display", "Customer display connected", "remote_addr", r.RemoteAddr)
+		http.Redirect(w, r, "/customer-display", http.StatusSeeOther)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := checkout.CustomerDisplayPage(a.Config.BusinessName, a.displayScreen()).Render(r.Context(), w); err != nil {
+		utils.Error("display", "Error rendering customer display", "error", err)
+	}
+}
+
+// CustomerDisplayEventsHandler streams the screen to show over SSE, redrawn whenever the cart,
+// the payments in flight or the last sale change. The browser reconnects on its own after a drop
+// and gets the current screen straight away.
+func (a *App) CustomerDisplayEventsHandler(w http.ResponseWriter, r *http.Request) {
+	if !a.displayAuthorized(w, r) {
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "SSE not supported by client", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	updates := a.Display.Subscribe()
+	defer a.Display.Unsubscribe(updates)
+
+	heartbeat := time.NewTicker(displayHeartbeat)
+	defer heartbeat.Stop()
+
+	// Fires when the thank-you screen should give way to the cart
+	thanksOver := time.NewTimer(displayThanksFor)
+	thanksOver.Stop()
+	defer thanksOver.Stop()
+
+	if _, err := fmt.Fprintf(w, "retry: %d\n\n", displayReconnectIn); err != nil {
+		return
+	}
+
+	send := func() bool {
+		if until := a.Display.thankingUntil(); time.Now().Before(until) {
+			thanksOver.Reset(time.Until(until))
+		}
+		var html bytes.Buffer
+		if err := a.displayScreen().Render(r.Context(), &html); err != nil {
+			utils.Error("display", "Error rendering customer display screen", "error", err)
+			return true
+		}
+		if err := writeDisplayEvent(w, html.String()); err != nil {
+			return false
+		}
+		flusher.Flush()
+		return true
+	}
+
+	if !send() {
+		return
+	}
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-updates:
+			if !send() {
+				return
+			}
+		case <-thanksOver.C:
+			if !send() {
+				return
+			}
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// writeDisplayEvent writes a screen event, one data line per line of HTML as SSE requires
+func writeDisplayEvent(w http.ResponseWriter, html string) error {
+	if _, err := fmt.Fprint(w, "event: screen\n"); err != nil {
+		return err
+	}
+	for _, line := range strings.Split(html, "\n") {
+		if _, err := fmt.Fprintf(w, "data: %s\n", line); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprint(w, "\n")
+	return err
+}
+
+// displayScreen picks what the customer sees: the payment in progress, a thank-you after a sale,
+// or the cart. With several registers signed in it follows the one that last changed its cart or
+// started a payment. Only items and totals are shown, never notes or customer details.
+func (a *App) displayScreen() templ.Component {
+	cart := a.Carts.Latest()
+	if state, ok := a.Payments.Newest(); ok {
+		if paying := state.GetCartStore(); paying != nil {
+			cart = paying
+		}
+		amount := services.ChargeAmount(cart, services.CalculateCartSummary(cart))
+		if qrState, ok := state.(*QRPaymentState); ok {
+			qrBase64 := ""
+			if qrState.URL != "" {
+				var err error
+				if qrBase64, err = qrCodeBase64(qrState.URL); err != nil {
+					utils.Error("display", "Error generating QR code for customer display", "payment_link_id", qrState.PaymentLinkID, "error", err)
+				}
+			}
+			return checkout.CustomerDisplayQR(qrBase64, amount)
+		}
+		return checkout.CustomerDisplayTerminal(amount)
+	}
+
+	if cart.Len() == 0 && time.Now().Before(a.Display.thankingUntil()) {
+		return checkout.CustomerDisplayThanks(a.Config.BusinessName)
+	}
+
+	return checkout.CustomerDisplayCart(cart.Items(), services.CalculateCartSummary(cart), cart.Tip())
+}
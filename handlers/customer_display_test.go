@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// Each App checks customer displays against the token of its own configuration
+func TestCustomerDisplayTokenPerApp(t *testing.T) {
+	shop, _, _ := newTestApp(t)
+	cafe, _, _ := newTestApp(t)
+	shop.Config.CustomerDisplayToken = "shop-token"
+	cafe.Config.CustomerDisplayToken = " cafe-token "
+
+	tests := []struct {
+		name  string
+		app   *App
+		token string
+		want  int
+	}{
+		{"own token", shop, "shop-token", http.StatusSeeOther},
+		{"own token, trimmed", cafe, "cafe-token", http.StatusSeeOther},
+		{"other app's token", shop, "cafe-token", http.StatusUnauthorized},
+		{"no token", cafe, "", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			tt.app.CustomerDisplayHandler(rec, httptest.NewRequest(http.MethodGet, "/customer-display?token="+tt.token, nil))
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+
+	// An App without a token has the display turned off, whatever the other App's setting
+	shop.Config.CustomerDisplayToken = ""
+	rec := httptest.NewRecorder()
+	shop.CustomerDisplayHandler(rec, httptest.NewRequest(http.MethodGet, "/customer-display?token=cafe-token", nil))
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("status with the display disabled = %d, want %d", rec.Code, http.StatusForbidden)
+	}
+}
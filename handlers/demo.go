@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"net/http"
+
+	"checkout/services"
+	"checkout/utils"
+)
+
+// DemoOutcomeHandler sets whether the simulated reader approves or declines the next payments.
+// It is driven by the selector in the demo banner.
+func (a *App) DemoOutcomeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !a.Config.DemoMode {
+		http.Error(w, "Demo mode is off", http.StatusConflict)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Error parsing form", http.StatusBadRequest)
+		return
+	}
+
+	services.SetDemoDecline(r.FormValue("outcome") == "decline")
+	w.WriteHeader(http.StatusOK)
+}
+
+// applyDemoMode is called after the DemoMode setting changes. Leaving demo mode drops the
+// simulated payments, so none of them can reach the live Stripe account. Either way the startup
+// checks run again to load the terminal locations and readers of the client now in use.
+func (a *App) applyDemoMode() {
+	utils.Info("demo", "Demo mode switched", "enabled", a.Config.DemoMode)
+
+	if !a.Config.DemoMode {
+		removed := a.Payments.ClearDemoAndClearCart()
+		for _, id := range removed {
+			a.SSE.RemoveConnection(id)
+		}
+		a.clearDemoWebhookStates()
+		services.ResetDemoStripe()
+		// A split sale started in demo mode was paid with simulated tenders
+		for _, cart := range a.Carts.All() {
+			cart.ClearSplit()
+		}
+	}
+
+	// The selected location and reader belong to the other account
+	services.Terminal.Reset()
+
+	// A failure (e.g. no Stripe key when leaving demo mode) sends the operator to the setup page
+	_ = services.RunStartupChecks()
+}
+
+// clearDemoWebhookStates removes cached payment states of simulated payments
+func (a *App) clearDemoWebhookStates() {
+	a.Webhooks.Mutex.Lock()
+	defer a.Webhooks.Mutex.Unlock()
+
+	for id := range a.Webhooks.ByPaymentIntent {
+		if services.IsDemoID(id) {
+			delete(a.Webhooks.ByPaymentIntent, id)
+		}
+	}
+	for id := range a.Webhooks.ByPaymentLink {
+		if services.IsDemoID(id) {
+			delete(a.Webhooks.ByPaymentLink, id)
+		}
+	}
+	for id := range a.Webhooks.Fallbacks {
+		if services.IsDemoID(id) {
+			delete(a.Webhooks.Fallbacks, id)
+		}
+	}
+}
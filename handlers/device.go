@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+
+	"checkout/services"
+	"checkout/utils"
+)
+
+// Device cookie: identifies a register's browser so each keeps its own reader and favorites
+const (
+	deviceCookieName   = "pos_device"
+	deviceCookieMaxAge = 3600 * 24 * 365 * 5
+)
+
+// deviceID returns the ID of the browser making the request, issuing one on its first visit
+func deviceID(w http.ResponseWriter, r *http.Request) string {
+	if cookie, err := r.Cookie(deviceCookieName); err == nil && cookie.Value != "" {
+		return cookie.Value
+	}
+
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		utils.Error("pos", "Error generating device ID", "error", err)
+		return ""
+	}
+	id := hex.EncodeToString(b)
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     deviceCookieName,
+		Value:    id,
+		Path:     "/",
+		MaxAge:   deviceCookieMaxAge,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return id
+}
+
+// selectedReader returns the reader the requesting register's payments go to
+func selectedReader(w http.ResponseWriter, r *http.Request) services.ReaderChoice {
+	return services.SelectedReaderFor(deviceID(w, r))
+}
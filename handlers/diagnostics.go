@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"checkout/services"
+	"checkout/templates/reports"
+	"checkout/utils"
+)
+
+// ReaderDiagnosticsHandler shows every reader at the selected location as Stripe reports it,
+// with its last action and when it last answered, and the same details as text for support
+func (a *App) ReaderDiagnosticsHandler(w http.ResponseWriter, r *http.Request) {
+	report := services.ReaderDiagnosticsReport()
+	location := services.Terminal.SelectedLocation()
+	text := services.FormatReaderDiagnostics(report, location, time.Now())
+
+	if err := reports.ReaderDiagnosticsPage(report, location, text).Render(r.Context(), w); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// ReaderConnectivityTestHandler sets and clears a reader's display to check that Stripe reaches it.
+// A reader taking a payment is not tested, as clearing its display would cancel the payment.
+func (a *App) ReaderConnectivityTestHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid form data", http.StatusBadRequest)
+		return
+	}
+
+	readerID := r.FormValue("reader_id")
+	if !readerAtLocation(readerID) {
+		http.Error(w, "Unknown reader", http.StatusBadRequest)
+		return
+	}
+
+	var message string
+	passed := false
+	if len(a.Payments.TerminalPaymentsForReader(readerID)) > 0 {
+		message = "Not tested: the reader is taking a payment"
+	} else if elapsed, err := services.TestReaderConnectivity(readerID); err != nil {
+		utils.Warn("terminal", "Reader connectivity test failed", "reader_id", readerID, "error", err)
+		message = "Failed: " + err.Error()
+	} else {
+		passed = true
+		message = fmt.Sprintf("Passed in %d ms", elapsed.Milliseconds())
+	}
+	utils.Info("audit", "Reader connectivity tested", "reader_id", readerID, "passed", passed, "user", currentUsername(r))
+
+	if err := reports.ReaderTestResult(message, passed).Render(r.Context(), w); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// ReaderLogsHandler shows the last reader-related lines of the log file
+func (a *App) ReaderLogsHandler(w http.ResponseWriter, r *http.Request) {
+	logFile := strings.TrimSpace(a.Config.LogFile)
+	var lines []string
+	errorMessage := ""
+	if logFile == "" {
+		errorMessage = "No log file is configured (System section, Log File), so only the console has the logs."
+	} else if found, err := services.ReaderLogLines(logFile); err != nil {
+		utils.Warn("terminal", "Error reading reader log lines", "file", logFile, "error", err)
+		errorMessage = "Could not read the log file: " + err.Error()
+	} else {
+		lines = found
+	}
+
+	if err := reports.ReaderLogLines(lines, errorMessage).Render(r.Context(), w); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// readerAtLocation reports whether a reader is registered at the selected location
+func readerAtLocation(readerID string) bool {
+	for _, reader := range services.Terminal.Readers() {
+		if reader.ID == readerID {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,362 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stripe/stripe-go/v74"
+	"golang.org/x/crypto/bcrypt"
+
+	"checkout/config"
+	"checkout/handlers/htmx"
+	"checkout/services"
+	"checkout/services/stripetest"
+	"checkout/templates"
+)
+
+// register is a browser signed in to the POS served by NewRouter, sending requests as HTMX does
+type register struct {
+	t      *testing.T
+	server *httptest.Server
+	client *http.Client
+	csrf   string
+}
+
+// response is what the register got back from one request
+type response struct {
+	status int
+	header http.Header
+	body   string
+}
+
+// newRegister serves app on a test server, with a coffee in the catalog, and signs a cashier in
+func newRegister(t *testing.T, app *App) *register {
+	t.Helper()
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Config.Users = []templates.User{{Username: "cashier", PasswordHash: string(hash), Role: templates.RoleCashier}}
+	useCatalog(t, templates.Product{ID: "coffee", Name: "Coffee", Price: 4.50})
+
+	server := httptest.NewServer(NewRouter(app))
+	t.Cleanup(server.Close)
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg := &register{t: t, server: server, client: &http.Client{
+		Jar:           jar,
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}}
+
+	login := reg.do(http.MethodPost, "/login", url.Values{"username": {"cashier"}, "password": {"secret"}})
+	if login.header.Get("HX-Redirect") != "/" {
+		t.Fatalf("login = %d, want HX-Redirect to the POS", login.status)
+	}
+	serverURL, _ := url.Parse(server.URL)
+	for _, cookie := range jar.Cookies(serverURL) {
+		if cookie.Name == csrfCookieName {
+			reg.csrf = cookie.Value
+		}
+	}
+	if reg.csrf == "" {
+		t.Fatalf("login didn't issue a CSRF token")
+	}
+	return reg
+}
+
+// do sends a request with the session's cookies and CSRF token and reads the whole response
+func (reg *register) do(method, path string, form url.Values) response {
+	reg.t.Helper()
+	var body io.Reader
+	if method == http.MethodGet && form != nil {
+		path += "?" + form.Encode()
+	} else {
+		body = strings.NewReader(form.Encode())
+	}
+	req, err := http.NewRequest(method, reg.server.URL+path, body)
+	if err != nil {
+		reg.t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("HX-Request", "true")
+	req.Header.Set(templates.CSRFHeaderName, reg.csrf)
+
+	resp, err := reg.client.Do(req)
+	if err != nil {
+		reg.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		reg.t.Fatal(err)
+	}
+	return response{status: resp.StatusCode, header: resp.Header, body: string(data)}
+}
+
+// webhook delivers a signed Stripe event to the server as Stripe would
+func (reg *register) webhook(eventType string, object any) int {
+	reg.t.Helper()
+	config.Config.StripeWebhookSecret = testWebhookSecret
+	payload, signature := signedEvent(reg.t, webhookEvent{Type: eventType, Object: object})
+	req, err := http.NewRequest(http.MethodPost, reg.server.URL+"/stripe-webhook", strings.NewReader(string(payload)))
+	if err != nil {
+		reg.t.Fatal(err)
+	}
+	req.Header.Set("Stripe-Signature", signature)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		reg.t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+// addCoffee rings up a coffee
+func (reg *register) addCoffee() {
+	reg.t.Helper()
+	resp := reg.do(http.MethodPost, "/add-to-cart", url.Values{"id": {"coffee"}})
+	expectEvents(reg.t, "add to cart", resp, "cartUpdated")
+}
+
+// pollStatus asks for a payment's status as the progress modal's failsafe does
+func (reg *register) pollStatus(paymentType, paymentID string) response {
+	reg.t.Helper()
+	return reg.do(http.MethodGet, "/get-payment-status", url.Values{"type": {paymentType}, "payment_id": {paymentID}})
+}
+
+// expectEvents checks that a response fired each of the HX-Trigger events
+func expectEvents(t *testing.T, step string, resp response, events ...string) {
+	t.Helper()
+	fired := htmx.Events(resp.header)
+	for _, event := range events {
+		if _, ok := fired[event]; !ok {
+			t.Errorf("%s: HX-Trigger = %q, want %s", step, resp.header.Get(htmx.TriggerHeader), event)
+		}
+	}
+}
+
+// expectMarkup checks that a response rendered each of the markers of a component
+func expectMarkup(t *testing.T, step string, resp response, markers ...string) {
+	t.Helper()
+	for _, marker := range markers {
+		if !strings.Contains(resp.body, marker) {
+			t.Errorf("%s: response lacks %s:\n%.600s", step, marker, resp.body)
+		}
+	}
+}
+
+// expectRecorded checks the transaction log in the temporary data directory has the sale
+func expectRecorded(t *testing.T, paymentID, paymentType string, total float64) *templates.Transaction {
+	t.Helper()
+	logs, _ := filepath.Glob(filepath.Join(config.Config.TransactionsDir, "*.csv"))
+	if len(logs) != 1 {
+		t.Fatalf("transaction logs = %v, want today's", logs)
+	}
+	transaction, err := services.LoadTransactionByID(paymentID)
+	if err != nil {
+		t.Fatalf("sale %s wasn't recorded: %v", paymentID, err)
+	}
+	if transaction.PaymentType != paymentType || transaction.Total != total {
+		t.Errorf("recorded %s sale of %.2f, want %s of %.2f", transaction.PaymentType, transaction.Total, paymentType, total)
+	}
+	return transaction
+}
+
+// receiptRecords reads the receipt logs in the temporary data directory
+func receiptRecords(t *testing.T) []templates.ReceiptRecord {
+	t.Helper()
+	logs, _ := filepath.Glob(filepath.Join(config.Config.TransactionsDir, "receipts", "receipts-*.json"))
+	var records []templates.ReceiptRecord
+	for _, log := range logs {
+		file, err := os.Open(log)
+		if err != nil {
+			t.Fatal(err)
+		}
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			var record templates.ReceiptRecord
+			if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+				t.Fatalf("%s: %v", log, err)
+			}
+			records = append(records, record)
+		}
+		file.Close()
+	}
+	return records
+}
+
+// sendReceipt fills in the success modal's receipt form and checks the request was logged
+func (reg *register) sendReceipt(paymentID, email string) {
+	reg.t.Helper()
+	resp := reg.do(http.MethodPost, "/update-receipt-info", url.Values{"confirmation_code": {paymentID}, "receipt_email": {email}})
+	expectEvents(reg.t, "receipt", resp, "closeModal", "showToast")
+
+	for _, record := range receiptRecords(reg.t) {
+		if record.ID == paymentID {
+			if record.ReceiptEmail != email || record.DeliveryMethod != "email" {
+				reg.t.Errorf("receipt record = %+v, want an email to %s", record, email)
+			}
+			return
+		}
+	}
+	reg.t.Errorf("no receipt record for %s", paymentID)
+}
+
+// confirmationCode returns the payment ID a success modal shows
+func confirmationCode(resp response) string {
+	_, rest, _ := strings.Cut(resp.body, `data-confirmation-code="`)
+	code, _, _ := strings.Cut(rest, `"`)
+	return code
+}
+
+func TestEndToEndTerminalPayment(t *testing.T) {
+	app, fake, _ := newTestApp(t)
+	reg := newRegister(t, app)
+	reg.addCoffee()
+
+	started := reg.do(http.MethodPost, "/process-payment", url.Values{"payment_method": {"terminal"}})
+	expectEvents(t, "start", started, "showModal")
+	expectMarkup(t, "start", started, `id="terminal-payment-container"`, `class="payment-progress terminal-progress"`, `hx-post="/cancel-or-refresh-payment"`)
+	states := app.Payments.GetStatesByType("terminal")
+	if len(states) != 1 {
+		t.Fatalf("terminal payments = %d, want 1", len(states))
+	}
+	intentID := states[0].GetID()
+	if fake.Calls("ProcessReaderPayment") != 1 {
+		t.Errorf("payment wasn't sent to the reader")
+	}
+
+	// Until the customer taps, the status check keeps the progress up and polling going
+	waiting := reg.pollStatus("terminal", intentID)
+	expectMarkup(t, "waiting", waiting, `class="payment-progress terminal-progress"`, intentID)
+	if _, stop := htmx.Events(waiting.header)["stopPolling"]; stop {
+		t.Errorf("waiting: polling stopped before the payment finished")
+	}
+	if _, tracked := app.Payments.GetPayment(intentID); !tracked || app.Payments.IsConcluded(intentID) {
+		t.Fatalf("payment isn't in progress while the reader waits")
+	}
+
+	fake.SetIntentStatus(intentID, stripe.PaymentIntentStatusSucceeded)
+	paid := reg.pollStatus("terminal", intentID)
+	expectEvents(t, "paid", paid, "stopPolling")
+	expectMarkup(t, "paid", paid, `class="payment-success"`, `data-confirmation-code="`+intentID+`"`, `hx-post="/update-receipt-info"`)
+	if _, tracked := app.Payments.GetPayment(intentID); tracked || !app.Payments.IsConcluded(intentID) {
+		t.Errorf("payment still in progress after it succeeded")
+	}
+	if n := len(app.Carts.All()); n != 1 || app.Carts.Latest().Len() != 0 {
+		t.Errorf("cart wasn't cleared by the sale")
+	}
+
+	// A status check that arrives after the success doesn't record the sale again
+	again := reg.pollStatus("terminal", intentID)
+	expectEvents(t, "after", again, "stopPolling")
+	if strings.Contains(again.body, `class="payment-success"`) {
+		t.Errorf("after: success shown twice")
+	}
+
+	expectRecorded(t, intentID, "terminal", 4.50)
+	reg.sendReceipt(intentID, "customer@example.com")
+}
+
+func TestEndToEndQRPaymentCompletedByWebhook(t *testing.T) {
+	app, fake, _ := newTestApp(t)
+	config.Config.WebsiteName = "pos.example.com" // Webhooks, rather than status checks, complete payments
+	reg := newRegister(t, app)
+	reg.addCoffee()
+
+	started := reg.do(http.MethodPost, "/generate-qr-code", nil)
+	expectEvents(t, "start", started, "showModal")
+	expectMarkup(t, "start", started, `id="qr-payment-container"`, `class="payment-progress qr-progress"`, `data:image/png;base64,`)
+	states := app.Payments.GetStatesByType("qr")
+	if len(states) != 1 {
+		t.Fatalf("QR payments = %d, want 1", len(states))
+	}
+	linkID := states[0].GetID()
+
+	waiting := reg.pollStatus("qr", linkID)
+	expectMarkup(t, "waiting", waiting, `class="payment-progress qr-progress"`)
+	if _, stop := htmx.Events(waiting.header)["stopPolling"]; stop {
+		t.Errorf("waiting: polling stopped before the link was paid")
+	}
+
+	// The customer pays on their phone and Stripe reports the completed checkout
+	session := fake.CompleteLink(linkID, "customer@example.com")
+	session.Livemode = isLiveMode()
+	if status := reg.webhook("checkout.session.completed", session); status != http.StatusOK {
+		t.Fatalf("webhook = %d, want 200", status)
+	}
+	if _, tracked := app.Payments.GetPayment(linkID); tracked || !app.Payments.IsConcluded(linkID) {
+		t.Errorf("payment still in progress after the webhook completed it")
+	}
+	if cached, found := app.GetCachedPaymentState(linkID, "payment_link"); !found || cached.Status != "completed" || !cached.Consumed {
+		t.Errorf("webhook state = %+v, want completed and consumed by the sale", cached)
+	}
+	if app.Carts.Latest().Len() != 0 {
+		t.Errorf("cart wasn't cleared by the sale")
+	}
+
+	// The success modal waits for the browser's update stream, which opens after the webhook here
+	stream := reg.do(http.MethodGet, "/payment-events", url.Values{"payment_id": {linkID}, "type": {"qr"}})
+	expectMarkup(t, "update stream", stream, "event: modal-update", `class="payment-success"`, `data-confirmation-code="`+linkID+`"`)
+
+	// The failsafe status check finds the sale already recorded and stops polling
+	after := reg.pollStatus("qr", linkID)
+	expectEvents(t, "after", after, "stopPolling")
+	if strings.Contains(after.body, `class="payment-success"`) {
+		t.Errorf("after: success shown twice")
+	}
+
+	expectRecorded(t, linkID, "qr", 4.50)
+	updates, _ := filepath.Glob(filepath.Join(config.Config.TransactionsDir, "updates", "*.json"))
+	if len(updates) != 1 {
+		t.Fatalf("payment update logs = %v, want today's", updates)
+	}
+	if data, _ := os.ReadFile(updates[0]); !strings.Contains(string(data), "customer@example.com") {
+		t.Errorf("email Stripe collected wasn't logged: %s", data)
+	}
+	reg.sendReceipt(linkID, "customer@example.com")
+}
+
+func TestEndToEndManualCard(t *testing.T) {
+	app, fake, _ := newTestApp(t)
+	reg := newRegister(t, app)
+	reg.addCoffee()
+
+	declined := reg.do(http.MethodPost, "/manual-card-form", url.Values{"payment_method_id": {stripetest.DeclinePaymentMethod}, "cardholder": {"Pat Doe"}})
+	expectEvents(t, "declined", declined, "showModal")
+	expectMarkup(t, "declined", declined, `class="decline-retry"`, `hx-get="/manual-card-form?retry=1"`)
+	if _, cleared := htmx.Events(declined.header)["cartUpdated"]; cleared || app.Carts.Latest().Len() != 1 {
+		t.Errorf("declined: cart changed by a declined card")
+	}
+	if logs, _ := filepath.Glob(filepath.Join(config.Config.TransactionsDir, "*.csv")); len(logs) != 0 {
+		t.Errorf("declined: sale recorded in %v", logs)
+	}
+
+	approved := reg.do(http.MethodPost, "/manual-card-form", url.Values{"payment_method_id": {"pm_card_visa"}, "cardholder": {"Pat Doe"}})
+	expectEvents(t, "approved", approved, "showModal", "cartUpdated")
+	expectMarkup(t, "approved", approved, `class="payment-success"`)
+	if fake.Calls("CreatePaymentIntent") != 1 {
+		t.Errorf("retry created %d PaymentIntents, want the declined one reused", fake.Calls("CreatePaymentIntent"))
+	}
+	if app.Carts.Latest().Len() != 0 {
+		t.Errorf("cart wasn't cleared by the sale")
+	}
+
+	intentID := confirmationCode(approved)
+	transaction := expectRecorded(t, intentID, "manual", 4.50)
+	if !strings.HasPrefix(transaction.RetryOf, "manual:"+intentID) {
+		t.Errorf("recorded retry of %q, want the declined attempt", transaction.RetryOf)
+	}
+	reg.sendReceipt(intentID, "customer@example.com")
+}
@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"checkout/handlers/htmx"
+	"checkout/services"
+	"checkout/utils"
+)
+
+// ToggleFavoriteHandler stars or unstars a product for this device's quick-access row
+func (a *App) ToggleFavoriteHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Error parsing form", http.StatusBadRequest)
+		return
+	}
+
+	productID := r.FormValue("id")
+	if _, err := services.ToggleFavorite(deviceID(w, r), productID); errors.Is(err, services.ErrFavoritesFull) {
+		setToast(w, "warning", "toast.favorites_full", a.Config.MaxFavorites)
+		w.WriteHeader(http.StatusOK)
+		return
+	} else if err != nil {
+		utils.Error("favorites", "Error toggling favorite", "product_id", productID, "error", err)
+		setToast(w, "error", "toast.favorite_error")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	htmx.Trigger(w, "categoryChanged")
+	w.WriteHeader(http.StatusOK)
+}
+
+// ReorderFavoritesHandler saves the order the favorites were dragged into, given as repeated id values
+func (a *App) ReorderFavoritesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Error parsing form", http.StatusBadRequest)
+		return
+	}
+
+	if err := services.ReorderFavorites(deviceID(w, r), r.Form["id"]); err != nil {
+		utils.Error("favorites", "Error reordering favorites", "error", err)
+		setToast(w, "error", "toast.favorite_error")
+		htmx.Trigger(w, "categoryChanged")
+	}
+	w.WriteHeader(http.StatusOK)
+}
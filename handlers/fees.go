@@ -0,0 +1,46 @@
+package handlers
+
+import (
+	"net/http"
+	"slices"
+
+	"checkout/handlers/htmx"
+	"checkout/services"
+	"checkout/templates/checkout"
+	"checkout/utils"
+)
+
+// PaymentMethodHandler keeps the payment method picked on the checkout form, which decides the
+// service fee shown in the cart. GET renders the picker; POST saves the pick and refreshes the cart.
+func (a *App) PaymentMethodHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		if err := checkout.PaymentMethodPicker(selectedPaymentMethod(a.cart(r))).Render(r.Context(), w); err != nil {
+			utils.Error("payment", "Error rendering payment method picker", "error", err)
+		}
+	case http.MethodPost:
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "Error parsing form", http.StatusBadRequest)
+			return
+		}
+		method := r.FormValue("fee_method")
+		if !slices.Contains([]string{"terminal", "manual", "qr"}, method) {
+			setToast(w, "error", "toast.invalid_payment_method")
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		a.cart(r).SetPaymentMethod(method)
+		htmx.Trigger(w, "cartUpdated")
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// selectedPaymentMethod returns the payment method picked for the sale in progress, or the default
+func selectedPaymentMethod(cart *services.CartStore) string {
+	if method := cart.PaymentMethod(); method != "" {
+		return method
+	}
+	return services.DefaultPaymentMethod
+}
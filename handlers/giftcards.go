@@ -0,0 +1,157 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+
+	"checkout/handlers/htmx"
+	"checkout/i18n"
+	"checkout/services"
+	"checkout/templates"
+	"checkout/templates/pos"
+	"checkout/utils"
+)
+
+// GiftCardsHandler looks up gift card balances and history.
+// GET without a code shows the lookup form; with a code it shows the card.
+func (a *App) GiftCardsHandler(w http.ResponseWriter, r *http.Request) {
+	code := services.NormalizeGiftCardCode(r.URL.Query().Get("code"))
+	if code == "" {
+		if err := renderInfoModal(w, r, pos.GiftCardsModal()); err != nil {
+			utils.Error("giftcard", "Error rendering gift card lookup", "error", err)
+		}
+		return
+	}
+
+	card, err := services.GetGiftCard(code)
+	if err != nil {
+		if err := pos.GiftCardNotFound(code).Render(r.Context(), w); err != nil {
+			utils.Error("giftcard", "Error rendering gift card lookup", "error", err)
+		}
+		return
+	}
+
+	history, err := services.GiftCardHistory(code)
+	if err != nil {
+		utils.Error("giftcard", "Error reading gift card history", "code", services.GiftCardLabel(code), "error", err)
+	}
+	if err := pos.GiftCardDetails(card, history).Render(r.Context(), w); err != nil {
+		utils.Error("giftcard", "Error rendering gift card details", "code", services.GiftCardLabel(code), "error", err)
+	}
+}
+
+// SellGiftCardHandler adds a gift card product to the cart, loading either a new card
+// or the existing card whose code was entered. The card is credited when the sale succeeds.
+func (a *App) SellGiftCardHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Error parsing form", http.StatusBadRequest)
+		return
+	}
+
+	var product templates.Product
+	productID := r.FormValue("id")
+	for _, p := range services.Catalog.Products() {
+		if p.ID == productID && p.GiftCard {
+			product = p
+			break
+		}
+	}
+	if product.ID == "" {
+		http.Error(w, "Gift card product not found", http.StatusNotFound)
+		return
+	}
+
+	line, err := services.NewGiftCardLine(product, strings.TrimSpace(r.FormValue("code")))
+	if err != nil {
+		setToastText(w, "warning", err.Error())
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	a.cart(r).Add(line)
+	a.cart(r).Touch()
+	utils.Info("giftcard", "Gift card added to cart", "code", services.GiftCardLabel(line.GiftCardCode), "amount", line.Price)
+	htmx.Trigger(w, "closeModal", "cartUpdated", "scrollCartToBottom")
+	w.WriteHeader(http.StatusOK)
+}
+
+// RedeemGiftCardHandler pays toward the cart with store credit.
+// GET asks for the card code; POST applies up to the card balance as a split tender,
+// leaving any remainder to be paid with another payment method.
+func (a *App) RedeemGiftCardHandler(w http.ResponseWriter, r *http.Request) {
+	cart := a.cart(r)
+	if cart.Len() == 0 {
+		setToast(w, "warning", "toast.cart_empty")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if rejectNonPositiveTotal(w, cart) {
+		return
+	}
+
+	// Store credit can't buy more store credit, whether a new card or a reload of any card
+	for _, product := range cart.Items() {
+		if product.GiftCardCode != "" {
+			setToast(w, "warning", "toast.gift_card_buys_gift_card")
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+	}
+
+	// Gift cards pay no service fee
+	total := services.CartTotalWithoutFee(cart)
+	remaining := total
+	if split := cart.Split(); split != nil {
+		remaining = split.Remaining(total)
+	}
+
+	if r.Method == http.MethodGet {
+		if err := renderInfoModal(w, r, pos.RedeemGiftCardModal(remaining)); err != nil {
+			utils.Error("giftcard", "Error rendering gift card redemption form", "error", err)
+		}
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Error parsing form", http.StatusBadRequest)
+		return
+	}
+
+	code := services.NormalizeGiftCardCode(r.FormValue("code"))
+	paymentID := services.NewPaymentID()
+	applied, err := services.RedeemGiftCard(code, remaining, paymentID)
+	if err != nil {
+		utils.Info("giftcard", "Gift card redemption rejected", "code", services.GiftCardLabel(code), "error", err)
+		setToastText(w, "warning", err.Error())
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	// The redemption is one tender of a split sale; other methods pay whatever is left
+	split := services.StartSplitPayment(cart)
+	split.PendingAmount = applied
+	component, _ := a.completeSplitTender(cart, paymentID, services.GiftCardPaymentMethod)
+
+	setToast(w, "success", "toast.gift_card_applied", i18n.Money(applied))
+	if err := renderModal(w, r, component, "cartUpdated"); err != nil {
+		utils.Error("giftcard", "Error rendering gift card redemption result", "error", err)
+	}
+}
+
+// renderGiftCardSale asks whether a gift card product sale issues a new card or reloads one
+func renderGiftCardSale(w http.ResponseWriter, r *http.Request, product templates.Product) {
+	if err := renderInfoModal(w, r, pos.GiftCardSaleModal(product)); err != nil {
+		utils.Error("giftcard", "Error rendering gift card sale form", "product", product.Name, "error", err)
+	}
+}
@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"checkout/templates"
+)
+
+// A cart that sells or reloads a gift card can't be paid with any gift card, not only the one
+// it loads
+func TestGiftCardCantPayForGiftCards(t *testing.T) {
+	const loaded, other = "ABCD-EFGH-JKLM-7KQ2", "WXYZ-WXYZ-WXYZ-3RT5"
+	tests := []struct {
+		name     string
+		giftCard string // Code of the gift card line in the cart, "" for none
+		method   string
+		code     string
+		refused  bool
+	}{
+		{"asking for the code", loaded, http.MethodGet, "", true},
+		{"the card being loaded", loaded, http.MethodPost, loaded, true},
+		{"the card being loaded, lowercase", loaded, http.MethodPost, strings.ToLower(loaded), true},
+		{"another card", loaded, http.MethodPost, other, true},
+		{"cart without a gift card", "", http.MethodPost, other, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, _, _ := newTestApp(t)
+			addToCart(app, "Coffee", 4.50)
+			if tt.giftCard != "" {
+				sessionCart(app).Add(templates.Product{ID: "gift", Name: "Gift Card", Price: 25, GiftCardCode: tt.giftCard})
+			}
+
+			req := httptest.NewRequest(tt.method, "/redeem-gift-card", strings.NewReader(url.Values{"code": {tt.code}}.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			req.Header.Set("HX-Request", "true")
+			req.AddCookie(&http.Cookie{Name: sessionCookieName, Value: testSession})
+			rec := httptest.NewRecorder()
+			app.RedeemGiftCardHandler(rec, req)
+
+			toast := toastMessage(rec)
+			if refused := strings.Contains(toast, "sells or reloads a gift card"); refused != tt.refused {
+				t.Errorf("toast = %q, refused %v, want %v", toast, refused, tt.refused)
+			}
+			if tt.refused && rec.Body.Len() != 0 {
+				t.Errorf("refusal rendered a form or result:\n%s", rec.Body.String())
+			}
+			if split := sessionCart(app).Split(); split != nil {
+				t.Errorf("split payment started with %v", split)
+			}
+		})
+	}
+}
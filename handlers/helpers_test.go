@@ -0,0 +1,174 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stripe/stripe-go/v74"
+	"github.com/stripe/stripe-go/v74/webhook"
+
+	"checkout/config"
+	"checkout/services"
+	"checkout/services/stripetest"
+	"checkout/templates"
+	"checkout/utils"
+)
+
+// TestMain keeps the handlers' logging out of the test output
+func TestMain(m *testing.M) {
+	utils.ConfigureLogging(utils.LogOptions{Console: io.Discard})
+	os.Exit(m.Run())
+}
+
+// fakeClock is a Clock that only moves when a test advances it
+type fakeClock struct {
+	mutex sync.Mutex
+	now   time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2026, 3, 14, 15, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// newTestApp returns an App on a fake Stripe client and clock, with its own configuration whose
+// data directory is a temporary directory, and the fake's reader as the only reader
+func newTestApp(t *testing.T) (*App, *stripetest.Client, *fakeClock) {
+	t.Helper()
+	dir := t.TempDir()
+	cfg := config.Config
+	cfg.DataDir = dir
+	cfg.TransactionsDir = filepath.Join(dir, "transactions")
+	if err := os.MkdirAll(cfg.TransactionsDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// Services that aren't handed the App's configuration read the global one, so it points at
+	// the latest App's directory rather than the working directory's data
+	saved := config.Config
+	config.Config.DataDir = cfg.DataDir
+	config.Config.TransactionsDir = cfg.TransactionsDir
+	t.Cleanup(func() { config.Config = saved })
+
+	services.Terminal.SetReaders([]templates.StripeReader{{ID: stripetest.ReaderID, Label: "Test Reader", Status: "online", LocationID: stripetest.LocationID}})
+	t.Cleanup(func() { services.Terminal.SetReaders(nil) })
+
+	fake := stripetest.New()
+	clock := newFakeClock()
+	return newApp(&cfg, fake, clock), fake, clock
+}
+
+// testSession is the login session token postForm sends requests with
+const testSession = "test-session"
+
+// sessionCart returns the cart of the requests postForm sends
+func sessionCart(app *App) *services.CartStore {
+	return app.Carts.Get(sessionCartKey(testSession))
+}
+
+// addToCart puts a product at the given price in the cart of the test session
+func addToCart(app *App, name string, price float64) {
+	sessionCart(app).Add(templates.Product{ID: strings.ToLower(name), Name: name, Price: price})
+}
+
+// postForm sends a form POST to a handler as HTMX would from the test session and returns the
+// recorded response
+func postForm(handler http.HandlerFunc, path string, form url.Values) *httptest.ResponseRecorder {
+	return postFormAs(testSession, handler, path, form)
+}
+
+// postFormAs sends a form POST to a handler as HTMX would from the given login session
+func postFormAs(session string, handler http.HandlerFunc, path string, form url.Values) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("HX-Request", "true")
+	req.AddCookie(&http.Cookie{Name: sessionCookieName, Value: session})
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	return rec
+}
+
+// testWebhookSecret is the signing secret signedEvent signs with
+const testWebhookSecret = "whsec_test_secret"
+
+var nextEventID atomic.Int64
+
+// webhookEvent is a Stripe event for signedEvent. An empty ID gets a new one, and a zero Created
+// the current time.
+type webhookEvent struct {
+	ID      string
+	Type    string
+	Created time.Time
+	Object  any
+}
+
+// signedEvent returns a Stripe event as JSON signed with testWebhookSecret, and its
+// Stripe-Signature header. The event is in the POS's Stripe mode.
+func signedEvent(t *testing.T, event webhookEvent) ([]byte, string) {
+	t.Helper()
+	if event.ID == "" {
+		event.ID = fmt.Sprintf("evt_test_%d", nextEventID.Add(1))
+	}
+	if event.Created.IsZero() {
+		event.Created = time.Now()
+	}
+	data, err := json.Marshal(event.Object)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, err := json.Marshal(map[string]any{
+		"id":          event.ID,
+		"object":      "event",
+		"api_version": stripe.APIVersion,
+		"type":        event.Type,
+		"created":     event.Created.Unix(),
+		"livemode":    isLiveMode(),
+		"data":        map[string]json.RawMessage{"object": data},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed := webhook.GenerateTestSignedPayload(&webhook.UnsignedPayload{Payload: payload, Secret: testWebhookSecret})
+	return payload, signed.Header
+}
+
+// postWebhook delivers a new signed event of the given type to the App's webhook handler
+func postWebhook(t *testing.T, app *App, eventType string, object any) *httptest.ResponseRecorder {
+	t.Helper()
+	return postEvent(t, app, webhookEvent{Type: eventType, Object: object})
+}
+
+// postEvent delivers a signed event to the App's webhook handler and returns the response
+func postEvent(t *testing.T, app *App, event webhookEvent) *httptest.ResponseRecorder {
+	t.Helper()
+	config.Config.StripeWebhookSecret = testWebhookSecret
+	payload, signature := signedEvent(t, event)
+	req := httptest.NewRequest(http.MethodPost, "/stripe-webhook", bytes.NewReader(payload))
+	req.Header.Set("Stripe-Signature", signature)
+	rec := httptest.NewRecorder()
+	app.StripeWebhookHandler(rec, req)
+	return rec
+}
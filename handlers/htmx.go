@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"checkout/handlers/htmx"
+	"checkout/i18n"
+	"checkout/templates"
+	"checkout/utils"
+)
+
+// isHTMX reports whether a request was made by HTMX, as opposed to the browser loading the URL itself
+func isHTMX(r *http.Request) bool {
+	return r.Header.Get("HX-Request") == "true"
+}
+
+// Fragment serves an endpoint that returns part of the POS page, such as the cart or a modal.
+// Only HTMX can use such a response, so a browser that loads the URL itself (typed in, bookmarked,
+// or reached again after signing in) is sent to the POS page at anchor instead. A form posted
+// without HTMX still runs: success lands on the POS page, and a failure the handler would have
+// shown as a toast gets an error page.
+func (a *App) Fragment(anchor string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if isHTMX(r) {
+			next(w, r)
+			return
+		}
+
+		pageURL := "/"
+		if anchor != "" {
+			pageURL += "#" + anchor
+		}
+		if isSafeMethod(r.Method) {
+			http.Redirect(w, r, pageURL, http.StatusSeeOther)
+			return
+		}
+
+		rec := newFragmentRecorder()
+		next(rec, r)
+
+		// Cookies set by the handler (e.g. a new CSRF token) still reach the browser
+		for _, cookie := range rec.header.Values("Set-Cookie") {
+			w.Header().Add("Set-Cookie", cookie)
+		}
+		if redirect := rec.header.Get("HX-Redirect"); redirect != "" {
+			http.Redirect(w, r, redirect, http.StatusSeeOther)
+			return
+		}
+		if message, failed := rec.failure(); failed {
+			utils.Info("http", "Showing error page for request made without HTMX", "method", r.Method, "path", r.URL.Path, "status", rec.status)
+			renderErrorPage(w, r, rec.errorStatus(), message, pageURL)
+			return
+		}
+		http.Redirect(w, r, pageURL, http.StatusSeeOther)
+	}
+}
+
+// renderErrorPage shows a full page with an error message and a link back to the POS, for
+// requests that can't show a toast
+func renderErrorPage(w http.ResponseWriter, r *http.Request, status int, message, backURL string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if err := templates.ErrorPage(message, backURL).Render(r.Context(), w); err != nil {
+		utils.Error("http", "Error rendering error page", "error", err)
+	}
+}
+
+// fragmentRecorder holds a handler's response to a request made without HTMX, so Fragment can
+// decide what the browser gets instead
+type fragmentRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func newFragmentRecorder() *fragmentRecorder {
+	return &fragmentRecorder{header: make(http.Header), status: http.StatusOK}
+}
+
+func (fr *fragmentRecorder) Header() http.Header { return fr.header }
+
+func (fr *fragmentRecorder) Write(b []byte) (int, error) { return fr.body.Write(b) }
+
+func (fr *fragmentRecorder) WriteHeader(status int) { fr.status = status }
+
+// failure returns the message of the error or warning toast the handler set, or the error body
+// when it answered with an error status
+func (fr *fragmentRecorder) failure() (string, bool) {
+	if detail, ok := htmx.Events(fr.header)["showToast"].(json.RawMessage); ok {
+		var toast htmx.Toast
+		if err := json.Unmarshal(detail, &toast); err == nil && (toast.Type == "error" || toast.Type == "warning") {
+			return toast.Message, true
+		}
+	}
+
+	if fr.status >= http.StatusBadRequest {
+		message := strings.TrimSpace(fr.body.String())
+		if message == "" || strings.HasPrefix(message, "<") {
+			message = i18n.T("error.request_failed")
+		}
+		return message, true
+	}
+	return "", false
+}
+
+// errorStatus is the status of the error page: the handler's own, or 400 when it reported the
+// problem in a toast on a 200
+func (fr *fragmentRecorder) errorStatus() int {
+	if fr.status >= http.StatusBadRequest {
+		return fr.status
+	}
+	return http.StatusBadRequest
+}
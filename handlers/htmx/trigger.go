@@ -0,0 +1,90 @@
+// Package htmx sets the HX-Trigger response header that fires client-side events, such as a
+// toast or closing the modal. Events are marshaled with encoding/json, so any message is escaped,
+// and events added by different steps of a handler are merged into the one header.
+package htmx
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"checkout/utils"
+)
+
+// TriggerHeader is the response header HTMX reads events from
+const TriggerHeader = "HX-Trigger"
+
+// Toast is the detail of a showToast event. Level is "success", "warning" or "error".
+type Toast struct {
+	Message string `json:"message"`
+	Type    string `json:"type"`
+}
+
+// TriggerEvents adds events to the response, keeping those already set; an event set twice
+// keeps its latest detail. Events without a detail take true.
+func TriggerEvents(w http.ResponseWriter, events map[string]any) {
+	merged := Events(w.Header())
+	for name, detail := range events {
+		merged[name] = detail
+	}
+
+	value, err := json.Marshal(merged)
+	if err != nil {
+		utils.Error("http", "Error encoding HX-Trigger events", "error", err)
+		return
+	}
+	w.Header().Set(TriggerHeader, string(value))
+}
+
+// Trigger adds events without a detail to the response
+func Trigger(w http.ResponseWriter, names ...string) {
+	events := make(map[string]any, len(names))
+	for _, name := range names {
+		events[name] = true
+	}
+	TriggerEvents(w, events)
+}
+
+// TriggerToast shows a toast with a message, which may contain any characters
+func TriggerToast(w http.ResponseWriter, level, message string) {
+	TriggerEvents(w, map[string]any{"showToast": Toast{Message: message, Type: level}})
+}
+
+// ShowModal opens the modal around the response
+func ShowModal(w http.ResponseWriter) {
+	Trigger(w, "showModal")
+}
+
+// CloseModal closes the modal
+func CloseModal(w http.ResponseWriter) {
+	Trigger(w, "closeModal")
+}
+
+// Events returns the events already set in a response header, by name. Details are kept as raw
+// JSON; a header of plain comma-separated event names gives each the detail true.
+func Events(header http.Header) map[string]any {
+	events := make(map[string]any)
+	value := strings.TrimSpace(header.Get(TriggerHeader))
+	if value == "" {
+		return events
+	}
+
+	if strings.HasPrefix(value, "{") {
+		var raw map[string]json.RawMessage
+		if err := json.Unmarshal([]byte(value), &raw); err != nil {
+			utils.Warn("http", "Replacing HX-Trigger header that isn't valid JSON", "value", value, "error", err)
+			return events
+		}
+		for name, detail := range raw {
+			events[name] = detail
+		}
+		return events
+	}
+
+	for _, name := range strings.Split(value, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			events[name] = true
+		}
+	}
+	return events
+}
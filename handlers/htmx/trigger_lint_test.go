@@ -0,0 +1,53 @@
+package htmx
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Every HX-Trigger header is written through this package, so events are escaped and merged.
+// A string naming the header anywhere else in the code, such as a Header().Set or a
+// fmt.Sprintf of the JSON, means a handler is writing it by hand. Comments, tests and the
+// generated templ files are left out.
+func TestHXTriggerOnlySetHere(t *testing.T) {
+	root := filepath.Join("..", "..")
+	here := filepath.Join(root, "handlers", "htmx")
+	fset := token.NewFileSet()
+
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if path == here || path != root && strings.HasPrefix(d.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		name := d.Name()
+		if !strings.HasSuffix(name, ".go") || strings.HasSuffix(name, "_test.go") || strings.HasSuffix(name, "_templ.go") {
+			return nil
+		}
+
+		file, err := parser.ParseFile(fset, path, nil, parser.SkipObjectResolution)
+		if err != nil {
+			return err
+		}
+		ast.Inspect(file, func(n ast.Node) bool {
+			lit, ok := n.(*ast.BasicLit)
+			if ok && lit.Kind == token.STRING && strings.Contains(strings.ToLower(lit.Value), "hx-trigger") {
+				t.Errorf("%s: %s names the HX-Trigger header; use the htmx package to set events", fset.Position(lit.Pos()), lit.Value)
+			}
+			return true
+		})
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
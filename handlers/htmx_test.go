@@ -0,0 +1,216 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"checkout/config"
+	"checkout/handlers/htmx"
+	"checkout/i18n"
+	"checkout/templates"
+)
+
+func TestFragment(t *testing.T) {
+	tests := []struct {
+		name         string
+		method       string
+		hx           bool
+		handler      http.HandlerFunc
+		wantStatus   int
+		wantLocation string
+		wantBody     string
+		wantErrPage  bool
+	}{
+		{
+			name:   "HTMX gets the fragment",
+			method: http.MethodGet, hx: true,
+			handler:    func(w http.ResponseWriter, r *http.Request) { w.Write([]byte(`<div id="cart-items"></div>`)) },
+			wantStatus: http.StatusOK, wantBody: `<div id="cart-items"></div>`,
+		},
+		{
+			name:   "HTMX gets the handler's error",
+			method: http.MethodPost, hx: true,
+			handler:    func(w http.ResponseWriter, r *http.Request) { http.Error(w, "Invalid index", http.StatusBadRequest) },
+			wantStatus: http.StatusBadRequest, wantBody: "Invalid index",
+		},
+		{
+			name:   "browser load goes to the POS page",
+			method: http.MethodGet,
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				t.Error("handler ran for a page load")
+			},
+			wantStatus: http.StatusSeeOther, wantLocation: "/#cart",
+		},
+		{
+			name:   "form post without HTMX lands on the POS page",
+			method: http.MethodPost,
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				htmx.Trigger(w, "cartUpdated")
+				w.Write([]byte(`<div id="cart-items"></div>`))
+			},
+			wantStatus: http.StatusSeeOther, wantLocation: "/#cart",
+		},
+		{
+			name:   "success toast lands on the POS page",
+			method: http.MethodPost,
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				htmx.TriggerToast(w, "success", "Added")
+			},
+			wantStatus: http.StatusSeeOther, wantLocation: "/#cart",
+		},
+		{
+			name:   "warning toast becomes an error page",
+			method: http.MethodPost,
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				htmx.TriggerToast(w, "warning", "Finish the split payment first")
+			},
+			wantStatus: http.StatusBadRequest, wantBody: "Finish the split payment first", wantErrPage: true,
+		},
+		{
+			name:   "error status becomes an error page",
+			method: http.MethodPost,
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				http.Error(w, "Reader is offline", http.StatusServiceUnavailable)
+			},
+			wantStatus: http.StatusServiceUnavailable, wantBody: "Reader is offline", wantErrPage: true,
+		},
+		{
+			name:   "error status with an HTML body gets a generic message",
+			method: http.MethodPost,
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusInternalServerError)
+				w.Write([]byte(`<div class="error"></div>`))
+			},
+			wantStatus: http.StatusInternalServerError, wantBody: i18n.T("error.request_failed"), wantErrPage: true,
+		},
+		{
+			name:   "HX-Redirect becomes a redirect",
+			method: http.MethodPost,
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("HX-Redirect", "/login")
+			},
+			wantStatus: http.StatusSeeOther, wantLocation: "/login",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, _, _ := newTestApp(t)
+			req := httptest.NewRequest(tt.method, "/cart-items", nil)
+			if tt.hx {
+				req.Header.Set("HX-Request", "true")
+			}
+			rec := httptest.NewRecorder()
+
+			app.Fragment("cart", tt.handler)(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if got := rec.Header().Get("Location"); got != tt.wantLocation {
+				t.Errorf("Location = %q, want %q", got, tt.wantLocation)
+			}
+			body := rec.Body.String()
+			if !strings.Contains(body, tt.wantBody) {
+				t.Errorf("body = %q, want %q in it", body, tt.wantBody)
+			}
+			isErrPage := strings.HasPrefix(body, "<!doctype html>")
+			if isErrPage != tt.wantErrPage {
+				t.Errorf("full error page = %v, want %v:\n%s", isErrPage, tt.wantErrPage, body)
+			}
+			if tt.wantErrPage && !strings.Contains(body, `href="/#cart"`) {
+				t.Errorf("error page doesn't link back to the cart:\n%s", body)
+			}
+			if !tt.hx && len(htmx.Events(rec.Header())) != 0 {
+				t.Errorf("HTMX events %v sent to a browser without HTMX", htmx.Events(rec.Header()))
+			}
+		})
+	}
+}
+
+// A form posted without HTMX may still need to set a cookie, such as a new CSRF token
+func TestFragmentKeepsCookies(t *testing.T) {
+	app, _, _ := newTestApp(t)
+	req := httptest.NewRequest(http.MethodPost, "/switch-user", nil)
+	rec := httptest.NewRecorder()
+
+	app.Fragment("", func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: csrfCookieName, Value: "fresh-token"})
+	})(rec, req)
+
+	if rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "/" {
+		t.Errorf("response = %d to %q, want 303 to /", rec.Code, rec.Header().Get("Location"))
+	}
+	if cookies := rec.Result().Cookies(); len(cookies) != 1 || cookies[0].Value != "fresh-token" {
+		t.Errorf("cookies = %v, want the handler's", cookies)
+	}
+}
+
+// The same cart endpoints through the router, with and without the HX-Request header
+func TestCartEndpointsWithAndWithoutHTMX(t *testing.T) {
+	app, _, _ := newTestApp(t)
+	config.Config.Users = []templates.User{{Username: "alice", Role: templates.RoleAdmin}}
+	config.Config.MetricsAddress = ""
+	router := NewRouter(app)
+	session := app.startSession("alice")
+	useCatalog(t, templates.Product{ID: "coffee", Name: "Coffee", Price: 4.50})
+	cart := app.Carts.Get(sessionCartKey(session))
+
+	send := func(method, path string, form url.Values, hx bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.AddCookie(&http.Cookie{Name: sessionCookieName, Value: session})
+		req.AddCookie(&http.Cookie{Name: csrfCookieName, Value: "route-test-token"})
+		req.Header.Set(templates.CSRFHeaderName, "route-test-token")
+		if hx {
+			req.Header.Set("HX-Request", "true")
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run("add to cart", func(t *testing.T) {
+		if rec := send(http.MethodPost, "/add-to-cart", url.Values{"id": {"coffee"}}, true); rec.Code != http.StatusOK {
+			t.Errorf("HTMX add = %d, want 200", rec.Code)
+		}
+		rec := send(http.MethodPost, "/add-to-cart", url.Values{"id": {"coffee"}}, false)
+		if rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "/#cart" {
+			t.Errorf("browser add = %d to %q, want 303 to /#cart", rec.Code, rec.Header().Get("Location"))
+		}
+		if cart.Len() != 2 {
+			t.Errorf("cart has %d items, want both adds", cart.Len())
+		}
+	})
+
+	fragments := []struct {
+		path, page, want string
+	}{
+		{"/cart-items", "/#cart", "Coffee"},
+		{"/products", "/#products", "Coffee"},
+		{"/cart-summary", "/#checkout", "9.00"}, // Both coffees,
+		{"/settings", "/#settings", `id="settings-content"`},
+	}
+	for _, f := range fragments {
+		t.Run(f.path, func(t *testing.T) {
+			if rec := send(http.MethodGet, f.path, nil, true); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), f.want) {
+				t.Errorf("HTMX load = %d, want the fragment with %q:\n%s", rec.Code, f.want, rec.Body.String())
+			}
+			if rec := send(http.MethodGet, f.path, nil, false); rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != f.page {
+				t.Errorf("browser load = %d to %q, want 303 to %s", rec.Code, rec.Header().Get("Location"), f.page)
+			}
+		})
+	}
+
+	t.Run("remove a missing item", func(t *testing.T) {
+		if rec := send(http.MethodPost, "/remove-from-cart", url.Values{"index": {"9"}}, true); rec.Code != http.StatusBadRequest || strings.HasPrefix(rec.Body.String(), "<!doctype html>") {
+			t.Errorf("HTMX remove = %d, want a bare 400:\n%s", rec.Code, rec.Body.String())
+		}
+		rec := send(http.MethodPost, "/remove-from-cart", url.Values{"index": {"9"}}, false)
+		if body := rec.Body.String(); rec.Code != http.StatusBadRequest || !strings.HasPrefix(body, "<!doctype html>") || !strings.Contains(body, "<p>Invalid index</p>") {
+			t.Errorf("browser remove = %d, want the error page:\n%s", rec.Code, body)
+		}
+	})
+}
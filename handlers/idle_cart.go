@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"checkout/config"
+	"checkout/handlers/htmx"
+	"checkout/services"
+	"checkout/utils"
+)
+
+// startIdleCartSweep periodically clears the carts that have sat unchanged past the idle timeout
+func (a *App) startIdleCartSweep() {
+	go func() {
+		ticker := time.NewTicker(30 * time.Second)
+		defer ticker.Stop()
+
+		for now := range ticker.C {
+			for _, cart := range a.Carts.All() {
+				a.sweepIdleCart(cart, now)
+			}
+		}
+	}()
+}
+
+// sweepIdleCart clears a session's cart if it has been idle for the configured timeout.
+// A cart that is being paid for is never cleared: an active QR or terminal payment,
+// a manual card payment waiting on 3D Secure, or a split sale with tenders already taken.
+// The session's POS finds the notice on its next check; other registers aren't told.
+func (a *App) sweepIdleCart(cart *services.CartStore, now time.Time) bool {
+	timeout := config.GetCartIdleTimeout()
+	if timeout == 0 || cart.Len() == 0 {
+		return false
+	}
+
+	// Payments that timed out without being cleaned up don't hold the cart
+	a.Payments.CleanupExpired()
+	if a.Payments.ActiveCountFor(cart) > 0 || services.SplitPaymentInProgress(cart) || a.manualAuthPending() {
+		cart.Touch()
+		return false
+	}
+
+	if !cart.IdleExpired(now, timeout) {
+		return false
+	}
+
+	cart.ClearIdle()
+	utils.Info("audit", "Cart cleared after inactivity", "idle_timeout", timeout)
+	return true
+}
+
+// manualAuthPending reports whether a manual card payment is waiting on 3D Secure
+func (a *App) manualAuthPending() bool {
+	a.manualAuth.mutex.Lock()
+	defer a.manualAuth.mutex.Unlock()
+	return a.manualAuth.intentID != ""
+}
+
+// IdleCartCheckHandler is polled by the POS; once after the cart is cleared for inactivity
+// it refreshes the cart and tells the cashier why it emptied
+func (a *App) IdleCartCheckHandler(w http.ResponseWriter, r *http.Request) {
+	if a.cart(r).TakeIdleNotice() {
+		setToast(w, "warning", "toast.cart_idle_cleared")
+		htmx.Trigger(w, "cartUpdated")
+	}
+	w.WriteHeader(http.StatusOK)
+}
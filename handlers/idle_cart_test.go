@@ -0,0 +1,189 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"checkout/config"
+	"checkout/handlers/htmx"
+	"checkout/services"
+	"checkout/templates"
+)
+
+// sweepAt runs the idle sweep over every cart as the ticker would at the given time
+func sweepAt(app *App, now time.Time) {
+	for _, cart := range app.Carts.All() {
+		app.sweepIdleCart(cart, now)
+	}
+}
+
+func TestIdleSweepBoundaries(t *testing.T) {
+	tests := []struct {
+		name           string
+		timeoutMinutes int
+		items          int
+		idle           time.Duration
+		wantCleared    bool
+	}{
+		{"disabled", 0, 1, 24 * time.Hour, false},
+		{"empty cart", 5, 0, time.Hour, false},
+		{"just under the timeout", 5, 1, 5*time.Minute - time.Nanosecond, false},
+		{"at the timeout", 5, 1, 5 * time.Minute, true},
+		{"past the timeout", 5, 3, time.Hour, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, _, _ := newTestApp(t)
+			config.Config.CartIdleTimeoutMinutes = tt.timeoutMinutes
+			cart := sessionCart(app)
+			for range tt.items {
+				cart.Add(templates.Product{ID: "coffee", Name: "Coffee", Price: 4.50})
+			}
+
+			// A cart nobody has changed since startup starts its idle clock at the first sweep
+			start := time.Now()
+			if app.sweepIdleCart(cart, start) {
+				t.Fatalf("first sweep cleared the cart")
+			}
+			cleared := app.sweepIdleCart(cart, start.Add(tt.idle))
+
+			if cleared != tt.wantCleared {
+				t.Errorf("cleared = %v after %v idle, want %v", cleared, tt.idle, tt.wantCleared)
+			}
+			if tt.wantCleared && (cart.Len() != 0 || !cart.TakeIdleNotice()) {
+				t.Errorf("cart has %d items, want it emptied with a notice for the POS", cart.Len())
+			}
+			if !tt.wantCleared && (cart.Len() != tt.items || cart.TakeIdleNotice()) {
+				t.Errorf("cart has %d items, want %d and no notice", cart.Len(), tt.items)
+			}
+		})
+	}
+}
+
+// Changing the cart restarts its idle clock
+func TestIdleSweepCountsFromLastChange(t *testing.T) {
+	app, _, _ := newTestApp(t)
+	config.Config.CartIdleTimeoutMinutes = 5
+	timeout := config.GetCartIdleTimeout()
+	useCatalog(t, templates.Product{ID: "coffee", Name: "Coffee", Price: 4.50})
+	addToCart(app, "Coffee", 4.50)
+	sweepAt(app, time.Now().Add(-time.Minute))
+
+	postForm(app.AddToCartHandler, "/add-to-cart", url.Values{"id": {"coffee"}})
+	sweepAt(app, time.Now().Add(timeout-time.Second))
+	if sessionCart(app).Len() == 0 {
+		t.Fatalf("cart swept less than %v after it changed", timeout)
+	}
+
+	sweepAt(app, time.Now().Add(timeout))
+	if sessionCart(app).Len() != 0 {
+		t.Errorf("cart unchanged for %v wasn't swept", timeout)
+	}
+}
+
+func TestIdleSweepNeverClearsCartsBeingPaid(t *testing.T) {
+	tests := []struct {
+		name  string
+		start func(t *testing.T, app *App)
+	}{
+		{"terminal payment", func(t *testing.T, app *App) { startTerminalPayment(t, app) }},
+		{"QR payment", func(t *testing.T, app *App) {
+			postForm(app.GenerateQRCodeHandler, "/generate-qr-code", url.Values{})
+		}},
+		{"manual card waiting on 3D Secure", func(t *testing.T, app *App) {
+			app.manualAuth.mutex.Lock()
+			app.manualAuth.intentID = "pi_3ds"
+			app.manualAuth.mutex.Unlock()
+		}},
+		{"split sale with a tender taken", func(t *testing.T, app *App) {
+			cart := sessionCart(app)
+			services.StartSplitPayment(cart)
+			cart.SetSplitTenders([]templates.Tender{{PaymentID: "cash_1", Method: "cash", Amount: 2}})
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, _, _ := newTestApp(t)
+			config.Config.CartIdleTimeoutMinutes = 5
+			addToCart(app, "Coffee", 4.50)
+			tt.start(t, app)
+
+			start := time.Now()
+			sweepAt(app, start)
+			sweepAt(app, start.Add(24*time.Hour))
+
+			if sessionCart(app).Len() != 1 || sessionCart(app).TakeIdleNotice() {
+				t.Errorf("cart being paid for was swept")
+			}
+		})
+	}
+}
+
+// A payment that ran out its time no longer holds the cart, which then gets a full idle period
+// from when the payment last held it
+func TestIdleSweepAfterPaymentExpires(t *testing.T) {
+	app, _, clock := newTestApp(t)
+	config.Config.CartIdleTimeoutMinutes = 5
+	timeout := config.GetCartIdleTimeout()
+	addToCart(app, "Coffee", 4.50)
+	startTerminalPayment(t, app)
+	sweepAt(app, time.Now().Add(time.Hour))
+
+	clock.Advance(config.PaymentTimeout + time.Second)
+	sweepAt(app, time.Now().Add(timeout-time.Second))
+	if sessionCart(app).Len() == 0 {
+		t.Fatalf("cart swept as soon as its payment expired")
+	}
+
+	sweepAt(app, time.Now().Add(timeout))
+	if sessionCart(app).Len() != 0 {
+		t.Errorf("cart left idle after its payment expired wasn't swept")
+	}
+}
+
+// A split sale started without any tender taken is still just a cart
+func TestIdleSweepClearsSplitWithoutTenders(t *testing.T) {
+	app, _, _ := newTestApp(t)
+	config.Config.CartIdleTimeoutMinutes = 5
+	addToCart(app, "Coffee", 4.50)
+	services.StartSplitPayment(sessionCart(app))
+
+	start := time.Now()
+	sweepAt(app, start)
+	sweepAt(app, start.Add(config.GetCartIdleTimeout()))
+
+	if sessionCart(app).Len() != 0 {
+		t.Errorf("idle split sale with nothing paid wasn't swept")
+	}
+}
+
+func TestIdleCartCheckNotifiesOnce(t *testing.T) {
+	app, _, _ := newTestApp(t)
+	config.Config.CartIdleTimeoutMinutes = 5
+	addToCart(app, "Coffee", 4.50)
+	start := time.Now()
+	sweepAt(app, start)
+	sweepAt(app, start.Add(config.GetCartIdleTimeout()))
+
+	check := func() map[string]any {
+		req := httptest.NewRequest(http.MethodGet, "/idle-cart-check", nil)
+		req.AddCookie(&http.Cookie{Name: sessionCookieName, Value: testSession})
+		rec := httptest.NewRecorder()
+		app.IdleCartCheckHandler(rec, req)
+		return htmx.Events(rec.Header())
+	}
+
+	events := check()
+	if _, ok := events["cartUpdated"]; !ok {
+		t.Errorf("events = %v, want cartUpdated to refresh the emptied cart", events)
+	}
+	if _, ok := events["showToast"]; !ok {
+		t.Errorf("events = %v, want a toast saying why the cart emptied", events)
+	}
+	if events := check(); len(events) != 0 {
+		t.Errorf("second check events = %v, want none", events)
+	}
+}
@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"checkout/services"
+	"checkout/utils"
+	"checkout/version"
+)
+
+// MetricsHandler serves Prometheus metrics in the text exposition format
+func (a *App) MetricsHandler(w http.ResponseWriter, r *http.Request) {
+	// Gauges that are cheap to read are sampled at scrape time
+	terminalCount, qrCount := a.Payments.GetActiveCountByType()
+	services.SetActivePayments("terminal", terminalCount)
+	services.SetActivePayments("qr", qrCount)
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if err := services.WriteMetrics(w); err != nil {
+		utils.Error("metrics", "Error writing metrics", "error", err)
+	}
+}
+
+// HealthHandler reports whether the POS can take payments: Stripe is reachable
+// (checked at most once a minute) and the transactions directory is writable.
+// Responds 503 when any check fails so load balancers and monitors can alert on it. A restricted
+// Stripe account, webhooks gone quiet, webhook settings only partly made or a clock too far off
+// Stripe's are reported as status "warning" with a 200. The webhook configuration is included as
+// checked last, and the clock's skew once it has been measured.
+func (a *App) HealthHandler(w http.ResponseWriter, r *http.Request) {
+	checks := map[string]services.HealthCheck{
+		"stripe":           services.CheckStripeHealth(),
+		"stripe_account":   services.CheckStripeAccountHealth(),
+		"transactions_dir": services.CheckTransactionsDirHealth(),
+		"webhooks":         services.CheckWebhookHealth(time.Now()),
+		"webhook_config":   services.CheckWebhookConfigHealth(),
+		"clock":            services.CheckClockHealth(),
+	}
+
+	status := "ok"
+	for _, check := range checks {
+		if !check.OK {
+			status = "unhealthy"
+		} else if check.Warning != "" && status == "ok" {
+			status = "warning"
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if status == "unhealthy" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	response := map[string]interface{}{
+		"status":         status,
+		"checks":         checks,
+		"webhook_config": services.WebhookConfigStatus(),
+	}
+	if clock := services.ClockSkewStatus(); clock.Known() {
+		response["clock_skew_seconds"] = clock.Seconds()
+	}
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		utils.Error("health", "Error writing health response", "error", err)
+	}
+}
+
+// VersionHandler reports the running build, and the newer release the daily update check found
+func (a *App) VersionHandler(w http.ResponseWriter, r *http.Request) {
+	response := struct {
+		version.Info
+		LatestVersion string `json:"latestVersion,omitempty"`
+	}{Info: version.Get()}
+	if release, ok := services.AvailableUpdate(); ok {
+		response.LatestVersion = release.Version
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		utils.Error("version", "Error writing version response", "error", err)
+	}
+}
@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"net/http"
+
+	"checkout/services"
+	"checkout/templates/checkout"
+	"checkout/utils"
+)
+
+// SaleNoteHandler keeps the note entered on the checkout form for the sale in progress.
+// GET renders the note field with the current note; POST saves the typed note.
+func (a *App) SaleNoteHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		if err := checkout.SaleNoteInput(a.cart(r).Note()).Render(r.Context(), w); err != nil {
+			utils.Error("payment", "Error rendering sale note", "error", err)
+		}
+	case http.MethodPost:
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "Error parsing form", http.StatusBadRequest)
+			return
+		}
+		a.cart(r).SetNote(services.SanitizeNote(r.FormValue("note")))
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// UpdateSaleNoteHandler changes the note of a completed sale from the success modal
+// and re-renders the sale's details with the new note
+func (a *App) UpdateSaleNoteHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Error parsing form", http.StatusBadRequest)
+		return
+	}
+
+	confirmationCode := r.FormValue("confirmation_code")
+	if _, err := services.UpdateSaleNote(a.Stripe, confirmationCode, r.FormValue("note")); err != nil {
+		utils.Error("payment", "Error updating sale note", "confirmation_code", confirmationCode, "error", err)
+		w.Header().Set("HX-Reswap", "none")
+		setToast(w, "error", "toast.note_error")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	transaction, err := services.LoadTransactionByID(confirmationCode)
+	if err != nil {
+		utils.Error("payment", "Error reloading sale after note update", "confirmation_code", confirmationCode, "error", err)
+		w.Header().Set("HX-Reswap", "none")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	setToast(w, "success", "toast.note_saved")
+	if err := checkout.PaymentCardDetails(transaction).Render(r.Context(), w); err != nil {
+		utils.Error("payment", "Error rendering sale details", "confirmation_code", confirmationCode, "error", err)
+	}
+}
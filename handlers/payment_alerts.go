@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"net/http"
+
+	"checkout/handlers/htmx"
+	"checkout/i18n"
+	"checkout/services"
+	"checkout/templates"
+	"checkout/templates/pos"
+	"checkout/utils"
+)
+
+// PaymentAlertsHandler renders the POS banner for payments that need the cashier's attention,
+// such as a payment still in progress, a payment link paid twice, a register not on the reader
+// it picked or a card reader that stopped answering; admins are also told when the Stripe account is restricted, the clock is too
+// far off for webhooks, the webhook settings are only partly made or a newer release is out
+func (a *App) PaymentAlertsHandler(w http.ResponseWriter, r *http.Request) {
+	cart := a.cart(r)
+	if state, ok := a.resumablePayment(cart); ok {
+		amount := services.ChargeAmount(cart, services.CalculateCartSummary(cart))
+		if err := pos.PaymentInProgress(state.GetPaymentType(), amount).Render(r.Context(), w); err != nil {
+			utils.Error("payment", "Error rendering payment in progress banner", "error", err)
+		}
+	}
+	if account := services.StripeAccountStatus(); account.Restricted() && templates.IsAdmin(r.Context()) {
+		if err := pos.StripeAccountAlert(account).Render(r.Context(), w); err != nil {
+			utils.Error("stripe", "Error rendering Stripe account banner", "error", err)
+		}
+	}
+	if clock := services.ClockSkewStatus(); clock.Excessive() && templates.IsAdmin(r.Context()) {
+		if err := pos.ClockSkewAlert(clock).Render(r.Context(), w); err != nil {
+			utils.Error("clock", "Error rendering clock skew banner", "error", err)
+		}
+	}
+	if templates.IsAdmin(r.Context()) && services.ShowWebhookConfigAlert() {
+		if err := pos.WebhookConfigAlert().Render(r.Context(), w); err != nil {
+			utils.Error("communication", "Error rendering webhook configuration banner", "error", err)
+		}
+	}
+	if release, ok := services.AvailableUpdate(); ok && templates.IsAdmin(r.Context()) {
+		if err := pos.UpdateAvailable(release).Render(r.Context(), w); err != nil {
+			utils.Error("update", "Error rendering update banner", "error", err)
+		}
+	}
+	choice := selectedReader(w, r)
+	if choice.Fallback != "" {
+		if err := pos.ReaderFallbackAlert(choice, services.Terminal.Readers()).Render(r.Context(), w); err != nil {
+			utils.Error("pos", "Error rendering reader fallback banner", "error", err)
+		}
+	}
+	reader, lastSeen, degraded := services.DegradedReader(choice.ReaderID)
+	component := pos.PaymentAlerts(services.PendingDuplicatePayments(), reader, lastSeen, degraded)
+	if err := component.Render(r.Context(), w); err != nil {
+		utils.Error("payment", "Error rendering payment alerts", "error", err)
+	}
+}
+
+// RefundDuplicatePaymentHandler refunds a duplicate payment link payment in full
+func (a *App) RefundDuplicatePaymentHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Error parsing form", http.StatusBadRequest)
+		return
+	}
+
+	payment, err := services.RefundDuplicatePayment(a.Stripe, r.FormValue("session_id"), currentUsername(r))
+	if err != nil {
+		utils.Error("payment", "Error refunding duplicate payment", "session_id", r.FormValue("session_id"), "error", err)
+		setToastText(w, "warning", err.Error())
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	setToast(w, "success", "toast.duplicate_refunded", i18n.Money(payment.Amount))
+	htmx.Trigger(w, "paymentAlertsChanged")
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,176 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stripe/stripe-go/v74"
+
+	"checkout/handlers/htmx"
+	"checkout/i18n"
+	"checkout/services"
+)
+
+// startTerminalPayment sends the cart to the fake's reader and returns the PaymentIntent's ID
+func startTerminalPayment(t *testing.T, app *App) string {
+	t.Helper()
+	postForm(app.ProcessPaymentHandler, "/process-payment", url.Values{"payment_method": {"terminal"}})
+	states := app.Payments.GetStatesByType("terminal")
+	if len(states) != 1 {
+		t.Fatalf("terminal payment states = %d, want 1", len(states))
+	}
+	return states[0].GetID()
+}
+
+func TestCancelAfterTerminalPaymentSucceeded(t *testing.T) {
+	app, fake, _ := newTestApp(t)
+	addToCart(app, "Coffee", 4.50)
+	intentID := startTerminalPayment(t, app)
+
+	// The customer taps while the cashier's cancel is on its way
+	fake.SetIntentStatus(intentID, stripe.PaymentIntentStatusSucceeded)
+	rec := postForm(app.CancelOrRefreshPaymentHandler, "/cancel-or-refresh-payment", url.Values{"payment_id": {intentID}, "type": {"terminal"}})
+
+	if got := fake.Calls("CancelPaymentIntent") + fake.Calls("CancelReaderAction"); got != 0 {
+		t.Errorf("a paid payment was cancelled on Stripe (%d cancel calls)", got)
+	}
+	if fake.Intent(intentID).Status != stripe.PaymentIntentStatusSucceeded {
+		t.Errorf("intent status = %s, want succeeded", fake.Intent(intentID).Status)
+	}
+	if trigger := rec.Header().Get("HX-Trigger"); !strings.Contains(trigger, "showToast") {
+		t.Errorf("HX-Trigger = %q, want the completed-before-cancel toast", trigger)
+	}
+	if _, tracked := app.Payments.GetPayment(intentID); tracked {
+		t.Errorf("payment still tracked after completing")
+	}
+	if sessionCart(app).Len() != 0 {
+		t.Errorf("cart has %d items after the sale, want 0", sessionCart(app).Len())
+	}
+	transaction, err := services.LoadTransactionByID(intentID)
+	if err != nil {
+		t.Fatalf("sale wasn't recorded: %v", err)
+	}
+	if transaction.Total != 4.50 {
+		t.Errorf("recorded total = %.2f, want 4.50", transaction.Total)
+	}
+}
+
+func TestCancelTerminalPaymentBeforeTap(t *testing.T) {
+	app, fake, _ := newTestApp(t)
+	addToCart(app, "Coffee", 4.50)
+	intentID := startTerminalPayment(t, app)
+
+	postForm(app.CancelOrRefreshPaymentHandler, "/cancel-or-refresh-payment", url.Values{"payment_id": {intentID}, "type": {"terminal"}})
+
+	if fake.Intent(intentID).Status != stripe.PaymentIntentStatusCanceled {
+		t.Errorf("intent status = %s, want canceled", fake.Intent(intentID).Status)
+	}
+	if fake.Calls("CancelReaderAction") != 1 {
+		t.Errorf("reader action wasn't cancelled")
+	}
+	if _, err := services.LoadTransactionByID(intentID); err == nil {
+		t.Errorf("a cancelled payment was recorded as a sale")
+	}
+	if sessionCart(app).Len() != 1 {
+		t.Errorf("cart has %d items after cancelling, want 1", sessionCart(app).Len())
+	}
+}
+
+// toastMessage is the text of the toast a response shows, or "" without one
+func toastMessage(rec *httptest.ResponseRecorder) string {
+	detail, _ := htmx.Events(rec.Header())["showToast"].(json.RawMessage)
+	var toast htmx.Toast
+	_ = json.Unmarshal(detail, &toast)
+	return toast.Message
+}
+
+// Clearing a stuck reader cancels only the payments waiting on it; a QR payment the customer is
+// still scanning and the cart being paid for are left alone
+func TestClearReaderKeepsCartAndOtherPayments(t *testing.T) {
+	app, fake, _ := newTestApp(t)
+	addToCart(app, "Coffee", 4.50)
+	postForm(app.GenerateQRCodeHandler, "/generate-qr-code", url.Values{})
+	qrStates := app.Payments.GetStatesByType("qr")
+	if len(qrStates) != 1 {
+		t.Fatalf("QR payments = %d, want 1", len(qrStates))
+	}
+	linkID := qrStates[0].GetID()
+	intentID := startTerminalPayment(t, app)
+
+	// The terminal payment's modal is open and listening for the result
+	stream := httptest.NewRecorder()
+	if app.SSE.AddConnection(intentID, "terminal", stream) == nil {
+		t.Fatal("update stream not opened")
+	}
+
+	rec := postForm(app.ClearTerminalTransactionHandler, "/clear-terminal-transaction", url.Values{})
+
+	if fake.Calls("CancelReaderAction") != 1 {
+		t.Errorf("reader action wasn't cancelled")
+	}
+	if fake.Intent(intentID).Status != stripe.PaymentIntentStatusCanceled {
+		t.Errorf("intent status = %s, want canceled", fake.Intent(intentID).Status)
+	}
+	if _, tracked := app.Payments.GetPayment(intentID); tracked {
+		t.Errorf("stuck terminal payment still tracked")
+	}
+	if !strings.Contains(stream.Body.String(), i18n.T("status.cancelled_title")) {
+		t.Errorf("terminal modal wasn't told of the cancellation:\n%s", stream.Body.String())
+	}
+	if _, tracked := app.Payments.GetPayment(linkID); !tracked {
+		t.Errorf("unrelated QR payment was dropped")
+	}
+	if fake.Calls("DeactivatePaymentLink") != 0 {
+		t.Errorf("unrelated QR link was deactivated")
+	}
+	if items := sessionCart(app).Items(); len(items) != 1 || items[0].Name != "Coffee" {
+		t.Errorf("cart = %v, want the coffee kept", items)
+	}
+	if got, want := toastMessage(rec), i18n.T("toast.reader_cleared", 1); got != want {
+		t.Errorf("toast = %q, want %q", got, want)
+	}
+}
+
+func TestClearReaderToasts(t *testing.T) {
+	tests := []struct {
+		name      string
+		start     bool
+		cancelErr error
+		wantToast string
+		wantKept  bool
+	}{
+		{"nothing pending", false, nil, i18n.T("toast.reader_nothing_pending"), false},
+		{"payment cancelled", true, nil, i18n.T("toast.reader_cleared", 1), false},
+		{"intent can't be cancelled", true, errors.New("payment_intent_unexpected_state"), i18n.T("toast.reader_cancel_failed", 1), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, fake, _ := newTestApp(t)
+			addToCart(app, "Coffee", 4.50)
+			var intentID string
+			if tt.start {
+				intentID = startTerminalPayment(t, app)
+			}
+			if tt.cancelErr != nil {
+				fake.FailNext("CancelPaymentIntent", tt.cancelErr)
+			}
+
+			rec := postForm(app.ClearTerminalTransactionHandler, "/clear-terminal-transaction", url.Values{})
+
+			if got := toastMessage(rec); got != tt.wantToast {
+				t.Errorf("toast = %q, want %q", got, tt.wantToast)
+			}
+			// A payment that couldn't be cancelled may have gone through, so it is still checked on
+			if _, tracked := app.Payments.GetPayment(intentID); intentID != "" && tracked != tt.wantKept {
+				t.Errorf("payment tracked = %v, want %v", tracked, tt.wantKept)
+			}
+			if sessionCart(app).Len() != 1 {
+				t.Errorf("cart has %d items, want 1", sessionCart(app).Len())
+			}
+		})
+	}
+}
@@ -1,40 +1,75 @@
 package handlers
 
 import (
-	"fmt"
 	"net/http"
+	"sync"
 
 	"github.com/stripe/stripe-go/v74"
-	"github.com/stripe/stripe-go/v74/paymentintent"
 
 	"checkout/config"
+	"checkout/i18n"
 	"checkout/services"
-	"checkout/templates"
 	"checkout/templates/checkout"
 	"checkout/utils"
 )
 
+// manualAuthentication is the manual card payment waiting on 3D Secure in the browser.
+// Only this intent can be completed through /confirm-manual-payment.
+type manualAuthentication struct {
+	intentID string
+	mutex    sync.Mutex
+}
+
 // ManualCardFormHandler handles the manual card entry form
-func ManualCardFormHandler(w http.ResponseWriter, r *http.Request) {
+func (a *App) ManualCardFormHandler(w http.ResponseWriter, r *http.Request) {
+	cart := a.cart(r)
 	// Check if cart is empty first (for both GET and POST)
-	if len(services.AppState.CurrentCart) == 0 {
+	if cart.Len() == 0 {
 		// Send a toast message for empty cart
-		w.Header().Set("HX-Trigger", `{"showToast": {"message": "Cart is empty. Please add items before entering card details.", "type": "warning"}}`)
+		setToast(w, "warning", "toast.cart_empty_manual")
 		w.WriteHeader(http.StatusOK) // Changed from BadRequest to OK since this is a valid user action
 		utils.Warn("payment", "Manual card entry rejected - cart empty")
 		return
 	}
 
+	if rejectNonPositiveTotal(w, cart) {
+		return
+	}
+	if rejectMixedVendors(w, cart) {
+		return
+	}
+
+	cart.SetPaymentMethod("manual")
+	if !a.quoteStripeTax(w, cart) {
+		return
+	}
+
 	// If this is a POST request, process the card payment
 	if r.Method == "POST" {
-		processManualCardPayment(w, r)
+		a.processManualCardPayment(w, r)
 		return
 	}
 
-	// For GET requests, just show the card entry form
+	// For GET requests, ask for a tip when one applies, then show the card entry form.
+	// Retrying a declined card keeps the tip chosen for it.
+	if r.URL.Query().Get("retry") == "" {
+		if a.offerTip(w, r, "manual") {
+			return
+		}
+		cart.SetTip(0)
+	}
+	renderManualCardForm(w, r)
+}
+
+// renderManualCardForm shows the card entry form in the modal
+func renderManualCardForm(w http.ResponseWriter, r *http.Request) {
 	// Get Stripe publishable key from config
 	stripePublicKey := config.GetStripePublicKey()
 	component := checkout.ManualCardForm(stripePublicKey)
+	if config.Config.DemoMode {
+		// Stripe Elements can't be used without Stripe; a test card number is typed in instead
+		component = checkout.DemoManualCardForm()
+	}
 
 	// Use renderInfoModal to set proper HTMX headers for modal display
 	if err := renderInfoModal(w, r, component); err != nil {
@@ -44,7 +79,8 @@ func ManualCardFormHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 // processManualCardPayment handles the complete manual card payment flow
-func processManualCardPayment(w http.ResponseWriter, r *http.Request) {
+func (a *App) processManualCardPayment(w http.ResponseWriter, r *http.Request) {
+	cart := a.cart(r)
 	if err := r.ParseForm(); err != nil {
 		http.Error(w, "Error parsing form", http.StatusBadRequest)
 		return
@@ -54,37 +90,41 @@ func processManualCardPayment(w http.ResponseWriter, r *http.Request) {
 	paymentMethodID := r.FormValue("payment_method_id")
 	cardholder := r.FormValue("cardholder")
 
+	// In demo mode the card number is typed in and mapped to a simulated payment method
+	if config.Config.DemoMode {
+		var ok bool
+		if paymentMethodID, ok = services.DemoPaymentMethod(r.FormValue("card_number")); !ok {
+			renderManualPaymentError(w, r, i18n.T("manual.demo_cards_only", services.DemoApproveCard, services.DemoDeclineCard), "")
+			return
+		}
+	}
+
 	// Validate required fields (only payment method ID and cardholder are required)
 	if paymentMethodID == "" {
-		renderManualPaymentError(w, r, "Please enter your card details", "")
+		renderManualPaymentError(w, r, i18n.T("manual.card_details_required"), "")
 		return
 	}
 
 	if cardholder == "" {
-		renderManualPaymentError(w, r, "Please enter the cardholder name", "")
+		renderManualPaymentError(w, r, i18n.T("manual.cardholder_required"), "")
 		return
 	}
 
-	// Calculate cart summary with taxes
-	summary := services.CalculateCartSummary()
-
-	// Create a payment intent for manual card processing
-	params := &stripe.PaymentIntentParams{
-		Amount:             stripe.Int64(int64(summary.Total * 100)), // Convert to cents
-		Currency:           stripe.String("usd"),
-		CaptureMethod:      stripe.String("automatic"),
-		PaymentMethodTypes: []*string{stripe.String("card")},
-	}
+	// Calculate cart summary with taxes; split sales charge only the current tender
+	summary := services.CalculateCartSummary(cart)
+	amount := services.ChargeAmount(cart, summary)
 
-	intent, err := paymentintent.New(params)
+	// Create a payment intent for manual card processing, or retry the sale's declined one
+	intent, err := a.paymentIntentFor(cart, amount, "manual")
 	if err != nil {
-		utils.Error("payment", "Error creating payment intent", "amount", summary.Total, "error", err)
-		w.Header().Set("HX-Trigger", `{"showToast": "Error processing payment"}`)
+		utils.Error("payment", "Error creating payment intent", "amount", amount, "error", err)
+		setPaymentErrorToast(w, err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
 	intentID := intent.ID
+	services.RecordPaymentStarted("manual")
 
 	// The payment method was already created by Stripe Elements on the frontend
 	// We just need to confirm the payment intent with the existing payment method
@@ -92,26 +132,15 @@ func processManualCardPayment(w http.ResponseWriter, r *http.Request) {
 		PaymentMethod: stripe.String(paymentMethodID),
 	}
 
-	intent, err = paymentintent.Confirm(intentID, confirmParams)
+	intent, err = a.Stripe.ConfirmPaymentIntent(intentID, confirmParams)
 	if err != nil {
 		utils.Error("payment", "Error confirming payment intent", "intent_id", intentID, "error", err)
 
 		// Handle specific error types
 		if stripeErr, ok := err.(*stripe.Error); ok {
-			switch stripeErr.Code {
-			case stripe.ErrorCodeCardDeclined:
-				renderManualPaymentError(w, r, "Your card was declined", intentID)
-			case stripe.ErrorCodeInsufficientFunds:
-				renderManualPaymentError(w, r, "Insufficient funds", intentID)
-			case stripe.ErrorCodeIncorrectCVC:
-				renderManualPaymentError(w, r, "Incorrect CVC", intentID)
-			case stripe.ErrorCodeExpiredCard:
-				renderManualPaymentError(w, r, "Your card has expired", intentID)
-			default:
-				renderManualPaymentError(w, r, "Payment failed: "+stripeErr.Msg, intentID)
-			}
+			a.renderManualDecline(w, r, manualDeclineMessage(stripeErr.Code, stripeErr.Msg), intentID, string(stripeErr.Code))
 		} else {
-			renderManualPaymentError(w, r, "Payment processing failed", intentID)
+			renderManualPaymentError(w, r, i18n.T("manual.processing_failed"), intentID)
 		}
 		return
 	}
@@ -120,35 +149,45 @@ func processManualCardPayment(w http.ResponseWriter, r *http.Request) {
 	switch intent.Status {
 	case stripe.PaymentIntentStatusSucceeded:
 		// Payment successful
-		handleManualPaymentSuccess(w, r, intent)
+		a.handleManualPaymentSuccess(w, r, intent)
 	case stripe.PaymentIntentStatusRequiresAction:
 		// 3D Secure or other authentication required
-		renderManualPaymentAuthentication(w, r, intent)
+		a.renderManualPaymentAuthentication(w, r, intent)
 	default:
 		// Other status - treat as failure
-		renderManualPaymentError(w, r, fmt.Sprintf("Payment status: %s", intent.Status), intentID)
+		renderManualPaymentError(w, r, i18n.T("manual.payment_status", intent.Status), intentID)
 	}
 }
 
 // handleManualPaymentSuccess handles a successful manual card payment
-func handleManualPaymentSuccess(w http.ResponseWriter, r *http.Request, intent *stripe.PaymentIntent) {
+func (a *App) handleManualPaymentSuccess(w http.ResponseWriter, r *http.Request, intent *stripe.PaymentIntent) {
+	cart := a.cart(r)
 	utils.Info("payment", "Manual card payment succeeded", "intent_id", intent.ID, "amount", float64(intent.Amount)/100)
 
+	// A split tender returns to the split form until the balance is paid
+	if component, ok := a.completeSplitTender(cart, intent.ID, "manual"); ok {
+		if err := renderModal(w, r, component, "cartUpdated"); err != nil {
+			utils.Error("payment", "Error rendering split payment result", "intent_id", intent.ID, "error", err)
+		}
+		return
+	}
+
 	// Calculate cart summary for transaction record
-	summary := services.CalculateCartSummary()
+	summary := services.CalculateCartSummary(cart)
 
 	// Save transaction (no email provided - will be collected via receipt form)
-	_ = GlobalPaymentEventLogger.LogPaymentEvent(
+	_ = a.Events.LogPaymentEvent(
+		cart,
 		intent.ID,
 		PaymentEventSuccess,
 		"manual",
-		services.AppState.CurrentCart,
+		cart.Items(),
 		summary,
 		"", // No email - will be collected post-payment via receipt form
 	)
 
 	// Clear cart
-	services.AppState.CurrentCart = []templates.Product{}
+	cart.Clear()
 
 	// Render success modal (always show receipt form)
 	if err := renderSuccessModal(w, r, intent.ID, false); err != nil {
@@ -166,21 +205,99 @@ func renderManualPaymentError(w http.ResponseWriter, r *http.Request, errorMessa
 	}
 }
 
-// renderManualPaymentAuthentication handles 3D Secure authentication
-func renderManualPaymentAuthentication(w http.ResponseWriter, r *http.Request, intent *stripe.PaymentIntent) {
-	utils.Warn("payment", "Manual payment requires authentication", "intent_id", intent.ID)
+// renderManualDecline renders a payment Stripe turned down, with the failure code behind it
+func (a *App) renderManualDecline(w http.ResponseWriter, r *http.Request, errorMessage, intentID, code string) {
+	utils.Error("payment", "Manual payment declined", "intent_id", intentID, "error_message", errorMessage, "code", code)
 
-	// For 3D Secure, we would typically redirect to the authentication URL
-	// or handle it client-side with Stripe Elements
-	authMessage := "This payment requires additional authentication. Please contact support."
-	if intent.NextAction != nil && intent.NextAction.RedirectToURL != nil {
-		authMessage = fmt.Sprintf("Please complete authentication at: %s", intent.NextAction.RedirectToURL.URL)
+	if err := a.renderDeclineModal(w, r, errorMessage, intentID, code, "manual"); err != nil {
+		utils.Error("payment", "Error rendering manual payment decline modal", "intent_id", intentID, "error", err)
 	}
+}
+
+// manualDeclineMessage maps a Stripe decline code to the message shown to the cashier
+func manualDeclineMessage(code stripe.ErrorCode, stripeMessage string) string {
+	switch code {
+	case stripe.ErrorCodeCardDeclined:
+		return i18n.T("decline.card_declined")
+	case stripe.ErrorCodeInsufficientFunds:
+		return i18n.T("decline.insufficient_funds")
+	case stripe.ErrorCodeIncorrectCVC:
+		return i18n.T("decline.incorrect_cvc")
+	case stripe.ErrorCodeExpiredCard:
+		return i18n.T("decline.expired_card")
+	default:
+		return i18n.T("decline.other", stripeMessage)
+	}
+}
+
+// renderManualPaymentAuthentication hands a 3D Secure payment to the browser.
+// Stripe.js runs the issuer's challenge with the intent's client secret and then
+// posts the intent ID to ConfirmManualPaymentHandler, which checks the outcome with Stripe.
+func (a *App) renderManualPaymentAuthentication(w http.ResponseWriter, r *http.Request, intent *stripe.PaymentIntent) {
+	utils.Info("payment", "Manual payment requires authentication", "intent_id", intent.ID)
 
-	// Use PaymentDeclinedModal as a fallback for authentication requirements
-	if err := renderErrorModal(w, r, authMessage, intent.ID); err != nil {
+	a.manualAuth.mutex.Lock()
+	a.manualAuth.intentID = intent.ID
+	a.manualAuth.mutex.Unlock()
+
+	component := checkout.ManualCardAuthentication(config.GetStripePublicKey(), intent.ClientSecret, intent.ID)
+	if err := renderInfoModal(w, r, component); err != nil {
 		utils.Error("payment", "Error rendering authentication modal", "intent_id", intent.ID, "error", err)
 	}
 }
 
+// ConfirmManualPaymentHandler finishes a manual card payment after 3D Secure.
+// The browser only reports that authentication ended; the intent is always re-fetched
+// from Stripe and only its status decides whether the sale is logged.
+func (a *App) ConfirmManualPaymentHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Error parsing form", http.StatusBadRequest)
+		return
+	}
+
+	intentID := r.FormValue("intent_id")
+
+	// Only the intent this POS handed to the browser can be completed, and only once
+	a.manualAuth.mutex.Lock()
+	expected := a.manualAuth.intentID
+	if intentID != "" && intentID == expected {
+		a.manualAuth.intentID = ""
+	}
+	a.manualAuth.mutex.Unlock()
+
+	if intentID == "" || intentID != expected {
+		utils.Warn("payment", "Rejected manual payment confirmation for unexpected intent", "intent_id", intentID, "expected_intent_id", expected)
+		renderManualPaymentError(w, r, i18n.T("manual.cant_confirm"), intentID)
+		return
+	}
+
+	intent, err := services.GetPaymentIntent(a.Stripe, intentID)
+	if err != nil {
+		utils.Error("payment", "Error retrieving payment intent after authentication", "intent_id", intentID, "error", err)
+		renderManualPaymentError(w, r, i18n.T("manual.verify_failed"), intentID)
+		return
+	}
+
+	switch intent.Status {
+	case stripe.PaymentIntentStatusSucceeded:
+		a.handleManualPaymentSuccess(w, r, intent)
+	case stripe.PaymentIntentStatusRequiresPaymentMethod:
+		// Authentication failed or the card was declined afterwards
+		if intent.LastPaymentError != nil {
+			a.renderManualDecline(w, r, manualDeclineMessage(intent.LastPaymentError.Code, intent.LastPaymentError.Msg), intentID, string(intent.LastPaymentError.Code))
+		} else {
+			renderManualPaymentError(w, r, i18n.T("manual.authentication_failed"), intentID)
+		}
+	case stripe.PaymentIntentStatusRequiresAction:
+		renderManualPaymentError(w, r, i18n.T("manual.authentication_incomplete"), intentID)
+	default:
+		renderManualPaymentError(w, r, i18n.T("manual.payment_status", intent.Status), intentID)
+	}
+}
+
 // Card validation is handled by Stripe Elements on the client-side
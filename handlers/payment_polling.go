@@ -3,21 +3,21 @@ package handlers
 import (
 	"context"
 	"fmt"
-	"math"
 	"net/http"
+	"strings"
 	"sync"
 	"time"
 
 	"checkout/config"
+	"checkout/handlers/htmx"
+	"checkout/i18n"
 	"checkout/services"
+	"checkout/templates"
 	"checkout/templates/checkout"
 	"checkout/utils"
 
 	"github.com/a-h/templ"
 	"github.com/stripe/stripe-go/v74"
-	"github.com/stripe/stripe-go/v74/paymentintent"
-	"github.com/stripe/stripe-go/v74/paymentlink"
-	"github.com/stripe/stripe-go/v74/terminal/reader"
 )
 
 // SSEConnection represents a Server-Sent Events connection
@@ -27,20 +27,35 @@ type SSEConnection struct {
 	PaymentID string
 	Type      string // "qr" or "terminal"
 	Done      chan bool
+	Concluded bool // A final result queued before the browser connected was already sent
 }
 
 // SSEBroadcaster manages SSE connections and broadcasting
 type SSEBroadcaster struct {
 	connections map[string]*SSEConnection
+	pending     map[string]pendingSSEUpdate // Last update per payment sent before its connection opened
+	clock       Clock                       // Ages queued updates
 	mutex       sync.RWMutex
 }
 
-// Global SSE broadcaster instance
-var GlobalSSEBroadcaster = &SSEBroadcaster{
-	connections: make(map[string]*SSEConnection),
+// pendingSSEUpdate is an update that arrived (usually by webhook) before the browser opened its
+// SSE connection. It is sent as soon as the connection opens instead of being dropped.
+type pendingSSEUpdate struct {
+	event    string // "payment-update" or "modal-update"
+	html     string
+	queuedAt time.Time
 }
 
-// AddConnection adds a new SSE connection
+// NewSSEBroadcaster creates a broadcaster with no connections, whose queued updates expire by the clock
+func NewSSEBroadcaster(clock Clock) *SSEBroadcaster {
+	return &SSEBroadcaster{
+		connections: make(map[string]*SSEConnection),
+		pending:     make(map[string]pendingSSEUpdate),
+		clock:       clock,
+	}
+}
+
+// AddConnection adds a new SSE connection and sends it any update queued for the payment
 func (b *SSEBroadcaster) AddConnection(paymentID, paymentType string, w http.ResponseWriter) *SSEConnection {
 	b.mutex.Lock()
 	defer b.mutex.Unlock()
@@ -59,7 +74,18 @@ func (b *SSEBroadcaster) AddConnection(paymentID, paymentType string, w http.Res
 	}
 
 	b.connections[paymentID] = conn
+	services.SetActiveSSEConnections(len(b.connections))
 	utils.Debug("sse", "New connection established", "payment_type", paymentType, "payment_id", paymentID)
+
+	if update, found := b.pending[paymentID]; found {
+		delete(b.pending, paymentID)
+		if err := writeSSEEvent(conn, update.event, update.html); err != nil {
+			utils.Error("sse", "Error sending queued update", "payment_id", paymentID, "error", err)
+		} else {
+			conn.Concluded = update.event == "modal-update"
+			utils.Info("sse", "Queued update sent on connect", "payment_id", paymentID, "event", update.event, "age", b.clock.Now().Sub(update.queuedAt))
+		}
+	}
 	return conn
 }
 
@@ -71,21 +97,33 @@ func (b *SSEBroadcaster) RemoveConnection(paymentID string) {
 	if conn, exists := b.connections[paymentID]; exists {
 		close(conn.Done)
 		delete(b.connections, paymentID)
+		services.SetActiveSSEConnections(len(b.connections))
 		utils.Debug("sse", "Connection removed", "payment_id", paymentID)
 	}
 }
 
+// Connected reports whether a browser is watching a payment's updates
+func (b *SSEBroadcaster) Connected(paymentID string) bool {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	_, exists := b.connections[paymentID]
+	return exists
+}
+
 // BroadcastPaymentUpdate sends a payment update to relevant SSE connections
 func (b *SSEBroadcaster) BroadcastPaymentUpdate(paymentID string, component templ.Component) {
-	b.mutex.RLock()
-	conn, exists := b.connections[paymentID]
-	b.mutex.RUnlock()
+	b.broadcast(paymentID, "payment-update", component)
+}
 
-	if !exists {
-		utils.Info("sse", "No connection found for payment", "payment_id", paymentID)
-		return
-	}
+// BroadcastModalUpdate sends a payment update that replaces the entire modal content. Final
+// results are sent this way.
+func (b *SSEBroadcaster) BroadcastModalUpdate(paymentID string, component templ.Component) {
+	b.broadcast(paymentID, "modal-update", component)
+}
 
+// broadcast renders a component and sends it as the given event. Without a connection the update
+// is queued for AddConnection, since a webhook can beat the browser to /payment-events.
+func (b *SSEBroadcaster) broadcast(paymentID, event string, component templ.Component) {
 	// Render the component to HTML
 	html, err := templ.ToGoHTML(context.Background(), component)
 	if err != nil {
@@ -93,54 +131,62 @@ func (b *SSEBroadcaster) BroadcastPaymentUpdate(paymentID string, component temp
 		return
 	}
 
-	// Write SSE event
-	if _, err := fmt.Fprint(conn.Writer, "event: payment-update\n"); err != nil {
-		utils.Error("sse", "Error writing SSE event header", "error", err)
-		return
-	}
-	if _, err := fmt.Fprintf(conn.Writer, "data: %s\n\n", html); err != nil {
-		utils.Error("sse", "Error writing SSE data", "error", err)
-		return
-	}
-
-	conn.Flusher.Flush()
-	utils.Debug("sse", "Payment update sent", "payment_id", paymentID)
-}
-
-// BroadcastModalUpdate sends a payment update that replaces the entire modal content
-func (b *SSEBroadcaster) BroadcastModalUpdate(paymentID string, component templ.Component) {
-	b.mutex.RLock()
+	// Checking and queueing under one lock, so a connection opening in between can't miss the update
+	b.mutex.Lock()
 	conn, exists := b.connections[paymentID]
-	b.mutex.RUnlock()
+	if !exists {
+		b.queueUpdate(paymentID, event, string(html))
+	}
+	b.mutex.Unlock()
 
 	if !exists {
-		utils.Debug("sse", "No connection found for modal update", "payment_id", paymentID)
+		utils.Debug("sse", "No connection found, queued update", "payment_id", paymentID, "event", event)
 		return
 	}
 
-	// Render the component to HTML
-	html, err := templ.ToGoHTML(context.Background(), component)
-	if err != nil {
-		utils.Error("sse", "Error rendering component", "payment_id", paymentID, "error", err)
+	if err := writeSSEEvent(conn, event, string(html)); err != nil {
+		utils.Error("sse", "Error writing SSE event", "payment_id", paymentID, "event", event, "error", err)
 		return
 	}
+	utils.Debug("sse", "Update sent", "payment_id", paymentID, "event", event)
+}
 
-	// Write SSE event for modal update
-	if _, err := fmt.Fprint(conn.Writer, "event: modal-update\n"); err != nil {
-		utils.Error("sse", "Error writing modal-update event header", "error", err)
-		return
+// queueUpdate keeps the last update for a payment without a connection. A final result is
+// never replaced by a later progress update. Must be called with the mutex held.
+func (b *SSEBroadcaster) queueUpdate(paymentID, event, html string) {
+	now := b.clock.Now()
+	for id, update := range b.pending {
+		if now.Sub(update.queuedAt) > config.PaymentTimeout {
+			delete(b.pending, id)
+		}
 	}
-	if _, err := fmt.Fprintf(conn.Writer, "data: %s\n\n", html); err != nil {
-		utils.Error("sse", "Error writing SSE data", "error", err)
+
+	if existing, found := b.pending[paymentID]; found && existing.event == "modal-update" && event != "modal-update" {
 		return
 	}
+	b.pending[paymentID] = pendingSSEUpdate{event: event, html: html, queuedAt: now}
+}
 
+// writeSSEEvent writes one event to a connection and flushes it. Each line of the HTML is sent
+// as its own data line, which the browser joins back together.
+func writeSSEEvent(conn *SSEConnection, event, html string) error {
+	if _, err := fmt.Fprintf(conn.Writer, "event: %s\n", event); err != nil {
+		return err
+	}
+	for _, line := range strings.Split(html, "\n") {
+		if _, err := fmt.Fprintf(conn.Writer, "data: %s\n", line); err != nil {
+			return err
+		}
+	}
+	if _, err := fmt.Fprint(conn.Writer, "\n"); err != nil {
+		return err
+	}
 	conn.Flusher.Flush()
-	utils.Debug("sse", "Modal update sent", "payment_id", paymentID)
+	return nil
 }
 
 // PaymentSSEHandler handles SSE connections for payment updates
-func PaymentSSEHandler(w http.ResponseWriter, r *http.Request) {
+func (a *App) PaymentSSEHandler(w http.ResponseWriter, r *http.Request) {
 	paymentID := r.URL.Query().Get("payment_id")
 	paymentType := r.URL.Query().Get("type") // "qr" or "terminal"
 
@@ -159,7 +205,7 @@ func PaymentSSEHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 
 	// Add connection to broadcaster
-	conn := GlobalSSEBroadcaster.AddConnection(paymentID, paymentType, w)
+	conn := a.SSE.AddConnection(paymentID, paymentType, w)
 	if conn == nil {
 		utils.Error("sse", "Failed to add connection", "payment_id", paymentID, "reason", "SSE not supported by client")
 		http.Error(w, "SSE not supported by client", http.StatusInternalServerError)
@@ -168,10 +214,22 @@ func PaymentSSEHandler(w http.ResponseWriter, r *http.Request) {
 
 	utils.Debug("sse", "Connection established successfully", "payment_type", paymentType, "payment_id", paymentID)
 
-	// Set up timeout
-	timeout := time.NewTimer(config.PaymentTimeout)
+	// The result already arrived by webhook and was sent on connect
+	if conn.Concluded {
+		a.SSE.RemoveConnection(paymentID)
+		utils.Debug("sse", "Payment concluded before connection", "payment_id", paymentID, "payment_type", paymentType)
+		return
+	}
+
+	// Set up timeout for the rest of the payment's time, so a reconnecting browser doesn't restart it
+	timeout := time.NewTimer(a.paymentTimeRemaining(paymentID))
 	defer timeout.Stop()
 
+	// Check once right away so a result cached before the browser connected isn't held back a tick
+	if a.checkPaymentForSSE(paymentID, paymentType) {
+		return
+	}
+
 	// Determine communication strategy
 	strategy := config.GetCommunicationStrategy()
 	utils.Debug("sse", "Using communication strategy", "strategy", strategy, "payment_id", paymentID)
@@ -184,123 +242,94 @@ func PaymentSSEHandler(w http.ResponseWriter, r *http.Request) {
 		for {
 			select {
 			case <-conn.Done:
-				GlobalSSEBroadcaster.RemoveConnection(paymentID)
+				a.SSE.RemoveConnection(paymentID)
 				return
 			case <-r.Context().Done():
-				GlobalSSEBroadcaster.RemoveConnection(paymentID)
+				a.SSE.RemoveConnection(paymentID)
 				return
 			case <-timeout.C:
 				// Payment timeout - send expiration event and cleanup
-				handleSSETimeout(paymentID, paymentType)
-				GlobalSSEBroadcaster.RemoveConnection(paymentID)
+				a.handleSSETimeout(paymentID, paymentType)
+				a.SSE.RemoveConnection(paymentID)
 				return
 			case <-ticker.C:
 				// Poll for payment status changes
-				var result PaymentStatusResult
-				switch paymentType {
-				case "qr":
-					result = checkQRPaymentStatus(paymentID)
-				case "terminal":
-					result = checkTerminalPaymentStatus(paymentID)
-				default:
-					utils.Error("sse", "Unknown payment type in polling", "payment_type", paymentType)
-					continue
-				}
-
-				if result.ShouldStop {
-					// Payment completed/failed - broadcast final result and cleanup
-					if result.Component != nil {
-						GlobalSSEBroadcaster.BroadcastModalUpdate(paymentID, result.Component)
-					}
-					GlobalSSEBroadcaster.RemoveConnection(paymentID)
-					utils.Debug("sse", "Payment concluded via polling", "payment_id", paymentID, "payment_type", paymentType)
+				if a.checkPaymentForSSE(paymentID, paymentType) {
 					return
 				}
 			}
 		}
 	} else {
 		// Webhook mode: Wait passively for webhook-triggered SSE events
-		// Determine communication strategy
-		strategy := config.GetCommunicationStrategy()
-		utils.Debug("sse", "Using communication strategy", "strategy", strategy, "payment_id", paymentID)
-
-		if strategy == "polling" {
-			// Polling mode: Actively check Stripe API every 2 seconds
-			ticker := time.NewTicker(2 * time.Second)
-			defer ticker.Stop()
-
-			for {
-				select {
-				case <-conn.Done:
-					GlobalSSEBroadcaster.RemoveConnection(paymentID)
-					return
-				case <-r.Context().Done():
-					GlobalSSEBroadcaster.RemoveConnection(paymentID)
-					return
-				case <-timeout.C:
-					// Payment timeout - send expiration event and cleanup
-					handleSSETimeout(paymentID, paymentType)
-					GlobalSSEBroadcaster.RemoveConnection(paymentID)
-					return
-				case <-ticker.C:
-					// Poll for payment status changes
-					var result PaymentStatusResult
-					switch paymentType {
-					case "qr":
-						result = checkQRPaymentStatus(paymentID)
-					case "terminal":
-						result = checkTerminalPaymentStatus(paymentID)
-					default:
-						utils.Error("sse", "Unknown payment type in polling", "payment_type", paymentType)
-						continue
-					}
-
-					if result.ShouldStop {
-						// Payment completed/failed - broadcast final result and cleanup
-						if result.Component != nil {
-							GlobalSSEBroadcaster.BroadcastModalUpdate(paymentID, result.Component)
-						}
-						GlobalSSEBroadcaster.RemoveConnection(paymentID)
-						utils.Debug("sse", "Payment concluded via polling", "payment_id", paymentID, "payment_type", paymentType)
-						return
-					}
-				}
-			}
-		} else {
-			// Webhook mode: Wait passively for webhook-triggered SSE events
-			for {
-				select {
-				case <-conn.Done:
-					GlobalSSEBroadcaster.RemoveConnection(paymentID)
-					return
-				case <-r.Context().Done():
-					GlobalSSEBroadcaster.RemoveConnection(paymentID)
-					return
-				case <-timeout.C:
-					// Payment timeout - send expiration event and cleanup
-					handleSSETimeout(paymentID, paymentType)
-					GlobalSSEBroadcaster.RemoveConnection(paymentID)
-					return
-				}
+		for {
+			select {
+			case <-conn.Done:
+				a.SSE.RemoveConnection(paymentID)
+				return
+			case <-r.Context().Done():
+				a.SSE.RemoveConnection(paymentID)
+				return
+			case <-timeout.C:
+				// Payment timeout - send expiration event and cleanup
+				a.handleSSETimeout(paymentID, paymentType)
+				a.SSE.RemoveConnection(paymentID)
+				return
 			}
 		}
 	}
 }
 
+// checkPaymentForSSE checks a payment's status once and, if it concluded, sends the final result
+// and closes the connection. It returns true when the connection is done.
+func (a *App) checkPaymentForSSE(paymentID, paymentType string) bool {
+	var result PaymentStatusResult
+	switch paymentType {
+	case "qr":
+		result = a.checkQRPaymentStatus(paymentID)
+	case "terminal":
+		result = a.checkTerminalPaymentStatus(paymentID)
+	default:
+		utils.Error("sse", "Unknown payment type in polling", "payment_type", paymentType)
+		return false
+	}
+
+	if !result.ShouldStop {
+		return false
+	}
+
+	// Payment completed/failed - broadcast final result and cleanup
+	if result.Component != nil {
+		a.SSE.BroadcastModalUpdate(paymentID, result.Component)
+	}
+	a.SSE.RemoveConnection(paymentID)
+	utils.Debug("sse", "Payment concluded via status check", "payment_id", paymentID, "payment_type", paymentType)
+	return true
+}
+
 // SSE functions removed - architecture uses event-driven completion notifications only
 // Progress display is handled by client-side JavaScript countdown
 // Completion events are triggered by webhook handlers
 
+// paymentTimeRemaining is how long a payment has left before it times out. A payment that isn't
+// tracked gets the full timeout.
+func (a *App) paymentTimeRemaining(paymentID string) time.Duration {
+	state, exists := a.Payments.GetPayment(paymentID)
+	if !exists {
+		return config.PaymentTimeout
+	}
+	return max(0, config.PaymentTimeout-a.Clock.Now().Sub(state.GetStartTime()))
+}
+
 // handleSSETimeout handles payment timeout via SSE
-func handleSSETimeout(paymentID, paymentType string) {
+func (a *App) handleSSETimeout(paymentID, paymentType string) {
 	utils.Info("sse", "SSE timeout triggered", "payment_id", paymentID, "payment_type", paymentType)
 	switch paymentType {
 	case "qr":
 		// QR timeout handler does its own BroadcastModalUpdate() + RemoveConnection()
-		handleQRPaymentTimeout(paymentID)
+		a.handleQRPaymentTimeout(paymentID)
 	case "terminal":
 		// Fetch the real PaymentIntent from Stripe
-		intent, err := paymentintent.Get(paymentID, nil)
+		intent, err := services.GetPaymentIntent(a.Stripe, paymentID)
 		if err != nil {
 			utils.Error("payment", "Error fetching PaymentIntent for timeout handling", "payment_id", paymentID, "error", err)
 			// If we can't fetch it, create a minimal intent for cleanup
@@ -310,7 +339,7 @@ func handleSSETimeout(paymentID, paymentType string) {
 			}
 		}
 		// Terminal timeout handler does its own BroadcastModalUpdate() + RemoveConnection()
-		handleTerminalPaymentTimeout(paymentID, intent)
+		a.handleTerminalPaymentTimeout(paymentID, intent)
 	}
 }
 
@@ -358,64 +387,28 @@ func createPaymentProgressComponent(paymentID string, progress ProgressInfo, pay
 	return createPaymentProgressComponentWithOptions(options)
 }
 
-// createPaymentProgressComponentWithOptions creates a payment progress component with advanced options
-// Now returns raw HTML that templates can embed with real-time server-calculated progress
+// createPaymentProgressComponentWithOptions creates the progress of a payment in progress. SSE
+// updates and status checks render the same component, so every path keeps the cancel button.
 func createPaymentProgressComponentWithOptions(opts PaymentProgressOptions) templ.Component {
-	// Determine the status message
 	statusMessage := config.GetPaymentMessage(opts.PaymentType, "default")
 	if opts.StatusMessage != "" {
 		statusMessage = opts.StatusMessage
 	}
 
-	// Build additional info for display
-	var additionalInfo string
-	if opts.PaymentType == "terminal" && opts.ReaderID != "" {
-		additionalInfo = fmt.Sprintf(
-			"<p><small>Reader: %s | Payment ID: %s</small></p>",
-			opts.ReaderID,
-			opts.PaymentID,
-		)
-	} else {
-		additionalInfo = fmt.Sprintf("<p><small>Payment ID: %s</small></p>", opts.PaymentID)
-	}
-
-	// Generate the progress HTML with stop-polling trigger when final state reached
-	var stopPollingAttr string
-	if opts.Progress.SecondsRemaining <= 0 {
-		// Payment has timed out, stop polling
-		stopPollingAttr = `hx-trigger="none"`
-	}
-
-	// Generate the progress HTML (single line to avoid newline issues in SSE)
-	progressHTML := fmt.Sprintf(
-		`<div class="payment-progress %s-progress" %s><h4>%s in Progress</h4><p>%s</p><p>Payment expires in <span id="countdown">%d</span> seconds</p><div class="progress-bar"><div class="progress-fill" style="width: %.1f%%;"></div></div>%s</div>`,
-		opts.PaymentType,
-		stopPollingAttr,
-		getPaymentTypeDisplayString(opts.PaymentType),
-		statusMessage,
-		opts.Progress.SecondsRemaining,
-		opts.Progress.ProgressWidth,
-		additionalInfo,
-	)
-
-	return templ.Raw(progressHTML)
-}
-
-// Helper function since we can't access template functions from handlers
-func getPaymentTypeDisplayString(paymentType string) string {
-	switch paymentType {
-	case "qr":
-		return "QR Code Payment"
-	case "terminal":
-		return "Terminal Payment"
-	default:
-		return "Payment"
-	}
+	return checkout.PaymentProgress(checkout.PaymentProgressState{
+		PaymentID:        opts.PaymentID,
+		PaymentType:      opts.PaymentType,
+		StatusMessage:    statusMessage,
+		ReaderID:         opts.ReaderID,
+		SecondsRemaining: opts.Progress.SecondsRemaining,
+		ProgressWidth:    opts.Progress.ProgressWidth,
+	})
 }
 
-// calculateProgressInfo calculates progress bar and countdown information
-func calculateProgressInfo(creationTime time.Time, _ time.Duration) ProgressInfo {
-	elapsed := time.Since(creationTime)
+// calculateProgressInfo calculates progress bar and countdown information for a payment started at
+// creationTime, as of now
+func calculateProgressInfo(creationTime, now time.Time) ProgressInfo {
+	elapsed := now.Sub(creationTime)
 	remaining := PAYMENT_POLLING_TIMEOUT - elapsed
 
 	secondsRemaining := int(remaining.Seconds())
@@ -436,7 +429,7 @@ func calculateProgressInfo(creationTime time.Time, _ time.Duration) ProgressInfo
 }
 
 // checkPaymentStatusGeneric handles the common polling logic for both QR and terminal payments
-func checkPaymentStatusGeneric(w http.ResponseWriter, r *http.Request, config PaymentPollingConfig) {
+func (a *App) checkPaymentStatusGeneric(w http.ResponseWriter, r *http.Request, config PaymentPollingConfig) {
 	// Check both form data (from hx-vals) and URL query parameters
 	paymentID := r.FormValue("payment_id")
 	if paymentID == "" {
@@ -463,15 +456,15 @@ func checkPaymentStatusGeneric(w http.ResponseWriter, r *http.Request, config Pa
 
 		// Create a proper modal with cancel option instead of leaving user stuck
 		component := checkout.TerminalInteractionResultModal(
-			"Payment Information Missing",
-			"Unable to check payment status - payment information is missing. This may indicate a technical issue or an expired payment session.",
+			i18n.T("status.missing_title"),
+			i18n.T("status.missing_message"),
 			"",   // no reference ID
 			true, // show close button
 			"",   // default close action
 		)
 
 		// Set header to stop any polling
-		w.Header().Set("HX-Trigger", "stopPolling")
+		htmx.Trigger(w, "stopPolling")
 		w.WriteHeader(http.StatusOK)
 		if err := component.Render(r.Context(), w); err != nil {
 			utils.Error("http", "Error rendering missing payment info modal", "error", err)
@@ -484,9 +477,9 @@ func checkPaymentStatusGeneric(w http.ResponseWriter, r *http.Request, config Pa
 	// Handle different payment types
 	switch config.PaymentType {
 	case "qr":
-		result = checkQRPaymentStatus(paymentID)
+		result = a.checkQRPaymentStatus(paymentID)
 	case "terminal":
-		result = checkTerminalPaymentStatus(paymentID)
+		result = a.checkTerminalPaymentStatus(paymentID)
 	default:
 		result = PaymentStatusResult{
 			Message:    "Unknown payment type",
@@ -497,7 +490,7 @@ func checkPaymentStatusGeneric(w http.ResponseWriter, r *http.Request, config Pa
 	// Handle timeout, success, or failure
 	if result.ShouldStop {
 		// Add HTMX header to stop polling
-		w.Header().Set("HX-Trigger", "stopPolling")
+		htmx.Trigger(w, "stopPolling")
 		if result.Component != nil {
 			w.WriteHeader(http.StatusOK)
 			if err := result.Component.Render(r.Context(), w); err != nil {
@@ -522,13 +515,28 @@ func checkPaymentStatusGeneric(w http.ResponseWriter, r *http.Request, config Pa
 
 // checkQRPaymentStatus checks QR payment link status
 // checkQRPaymentStatus checks QR payment link status
-func checkQRPaymentStatus(paymentLinkID string) PaymentStatusResult {
+func (a *App) checkQRPaymentStatus(paymentLinkID string) PaymentStatusResult {
 	// Check if this is a new payment link we haven't seen before
-	if _, exists := GlobalPaymentStateManager.GetPayment(paymentLinkID); !exists {
+	if _, exists := a.Payments.GetPayment(paymentLinkID); !exists {
+		// A payment already recorded needs no more calls to Stripe
+		if cachedState, found := a.GetCachedPaymentState(paymentLinkID, "payment_link"); a.Payments.IsConcluded(paymentLinkID) || (found && cachedState.Consumed) {
+			utils.Debug("payment", "Payment link already concluded", "payment_link_id", paymentLinkID)
+			return PaymentStatusResult{
+				Component: checkout.TerminalInteractionResultModal(
+					i18n.T("status.concluded_title"),
+					i18n.T("status.concluded_message"),
+					paymentLinkID,
+					true, // hasCloseButton
+					"",   // no additional message
+				),
+				ShouldStop: true,
+			}
+		}
+
 		// Before creating new state, check if the payment link is still active on Stripe
 		// This prevents creating new state for already-expired payments
-		paymentLinkStatus, err := services.CheckPaymentLinkStatus(paymentLinkID)
-		if err != nil {
+		paymentLinkStatus, err := services.CheckPaymentLinkStatus(a.Stripe, paymentLinkID, time.Time{})
+		if err != nil && !services.IsTransientStripeError(err) {
 			utils.Error("payment", "Error checking payment link status for new state", "payment_link_id", paymentLinkID, "error", err)
 			return PaymentStatusResult{
 				Message:    "Error checking payment status",
@@ -536,8 +544,8 @@ func checkQRPaymentStatus(paymentLinkID string) PaymentStatusResult {
 			}
 		}
 
-		// If payment link is inactive, it's already expired - don't recreate state
-		if !paymentLinkStatus.Active {
+		// If Stripe is unreachable, track the payment anyway so the regular timeout still applies
+		if err == nil && !paymentLinkStatus.Active {
 			utils.Debug("payment", "Payment link is inactive, showing expired message", "payment_link_id", paymentLinkID)
 			return PaymentStatusResult{
 				Component:  checkout.PaymentExpired(paymentLinkID),
@@ -547,43 +555,57 @@ func checkQRPaymentStatus(paymentLinkID string) PaymentStatusResult {
 
 		utils.Debug("payment", "Payment link is still active, creating new state", "payment_link_id", paymentLinkID, "active", paymentLinkStatus.Active)
 
-		// Only create new state if the payment link is still active
-		qrState := &QRPaymentState{
-			PaymentLinkID: paymentLinkID,
-			CreationTime:  time.Now(),
-		}
-		GlobalPaymentStateManager.AddPayment(qrState)
+		// Only create new state if the payment link is still active. The session the QR code was
+		// shown on isn't known, so no cart is cleared when it is paid.
+		a.Payments.AddPayment(&QRPaymentState{PaymentLinkID: paymentLinkID, CreationTime: a.Clock.Now()})
 	}
 
-	state, _ := GlobalPaymentStateManager.GetPayment(paymentLinkID)
-	progress := calculateProgressInfo(state.GetStartTime(), PAYMENT_POLLING_TIMEOUT)
+	state, _ := a.Payments.GetPayment(paymentLinkID)
+	progress := calculateProgressInfo(state.GetStartTime(), a.Clock.Now())
 
 	// Check for timeout
 	if progress.SecondsRemaining <= 0 {
-		return handleQRPaymentTimeout(paymentLinkID)
+		return a.handleQRPaymentTimeout(paymentLinkID)
 	}
 
 	// First, check webhook cache if available
-	if cachedState, found := GetCachedPaymentState(paymentLinkID, "payment_link"); found {
+	if cachedState, found := a.GetCachedPaymentState(paymentLinkID, "payment_link"); found {
 		utils.Debug("payment", "Using cached state for QR payment link", "payment_link_id", paymentLinkID, "status", cachedState.Status)
+		if isFinalStatus(cachedState.Status) {
+			services.RecordStatusCheck(config.GetCommunicationStrategy(), "webhook_cache")
+		}
 
 		// Handle cached payment completion
 		if cachedState.Status == "completed" {
 			paymentLinkStatus := services.PaymentLinkStatus{
 				CustomerEmail: cachedState.Metadata["customer_email"],
+				SessionID:     cachedCheckoutSessionID(cachedState),
 			}
-			return handleQRPaymentSuccess(paymentLinkID, paymentLinkStatus)
+			a.consumeCachedPaymentState(paymentLinkID, "payment_link")
+			return a.handleQRPaymentSuccess(paymentLinkID, paymentLinkStatus)
 		}
 
 		// Handle cached inactive/expired state
 		if cachedState.Status == "inactive" {
-			return handleQRPaymentTimeout(paymentLinkID)
+			a.consumeCachedPaymentState(paymentLinkID, "payment_link")
+			return a.handleQRPaymentTimeout(paymentLinkID)
+		}
+	}
+
+	// In webhook mode, keep waiting for the webhook unless it is overdue
+	if !a.shouldQueryStripe(paymentLinkID, "payment_link", state.GetStartTime()) {
+		return PaymentStatusResult{
+			Component: createPaymentProgressComponent(paymentLinkID, progress, "qr"),
 		}
 	}
 
 	// Fallback to direct Stripe API call if no cached state
 	utils.Debug("payment", "No cached state found, checking Stripe API", "payment_link_id", paymentLinkID)
-	paymentLinkStatus, err := services.CheckPaymentLinkStatus(paymentLinkID)
+	paymentLinkStatus, err := services.CheckPaymentLinkStatus(a.Stripe, paymentLinkID, state.GetStartTime())
+	if services.IsTransientStripeError(err) {
+		utils.Warn("payment", "Temporary error checking payment link status, will retry", "payment_link_id", paymentLinkID, "error", err)
+		return transientStripeErrorResult(paymentLinkID, "qr", progress, "")
+	}
 	if err != nil {
 		utils.Error("payment", "Error checking payment link status", "payment_link_id", paymentLinkID, "error", err)
 		return PaymentStatusResult{
@@ -592,9 +614,17 @@ func checkQRPaymentStatus(paymentLinkID string) PaymentStatusResult {
 		}
 	}
 
-	// Handle completed payment
+	// Handle completed payment. The result is cached like a webhook would, so a status check
+	// racing this one answers from the cache instead of asking Stripe again.
 	if paymentLinkStatus.Completed {
-		return handleQRPaymentSuccess(paymentLinkID, paymentLinkStatus)
+		a.cachePaymentLinkCompletion(paymentLinkID, paymentLinkStatus)
+		a.consumeCachedPaymentState(paymentLinkID, "payment_link")
+		return a.handleQRPaymentSuccess(paymentLinkID, paymentLinkStatus)
+	}
+
+	// The link was deactivated without being paid (expired or canceled elsewhere); stop checking it
+	if !paymentLinkStatus.Active {
+		return a.handleQRPaymentTimeout(paymentLinkID)
 	}
 
 	// Continue polling - render progress using our reusable function
@@ -605,20 +635,46 @@ func checkQRPaymentStatus(paymentLinkID string) PaymentStatusResult {
 	}
 }
 
+// cachePaymentLinkCompletion caches a completion found with the Stripe API the way
+// checkout.session.completed would have
+func (a *App) cachePaymentLinkCompletion(paymentLinkID string, status services.PaymentLinkStatus) {
+	metadata := make(map[string]string)
+	if status.CustomerEmail != "" {
+		metadata["customer_email"] = status.CustomerEmail
+	}
+	a.setCachedPaymentState(paymentLinkID, "payment_link", &WebhookPaymentState{
+		ID:           paymentLinkID,
+		Status:       "completed",
+		PaymentType:  "payment_link",
+		Metadata:     metadata,
+		EventCreated: a.Clock.Now().Unix(),
+		Livemode:     isLiveMode(),
+		AdditionalData: map[string]interface{}{
+			"checkout_session_id": status.SessionID,
+		},
+	})
+}
+
+// cachedCheckoutSessionID returns the checkout session that completed a cached payment link
+func cachedCheckoutSessionID(state *WebhookPaymentState) string {
+	sessionID, _ := state.AdditionalData["checkout_session_id"].(string)
+	return sessionID
+}
+
 // checkTerminalPaymentStatus checks terminal payment status
-func checkTerminalPaymentStatus(intentID string) PaymentStatusResult {
+func (a *App) checkTerminalPaymentStatus(intentID string) PaymentStatusResult {
 	utils.Debug("payment", "Checking terminal payment status", "intent_id", intentID)
-	state, exists := GlobalPaymentStateManager.GetPayment(intentID)
+	state, exists := a.Payments.GetPayment(intentID)
 	if !exists {
 		utils.Debug("payment", "No cached payment state found", "intent_id", intentID)
 
 		// Clean up any remaining SSE connection to prevent orphaned polling
-		GlobalSSEBroadcaster.RemoveConnection(intentID)
+		a.SSE.RemoveConnection(intentID)
 
 		// Payment session not found - render a final "session concluded" message
 		component := checkout.TerminalInteractionResultModal(
-			"Payment Session Concluded",
-			"This payment session is no longer active.",
+			i18n.T("status.concluded_title"),
+			i18n.T("status.concluded_message"),
 			intentID,
 			true, // hasCloseButton
 			"",   // no additional message
@@ -631,12 +687,12 @@ func checkTerminalPaymentStatus(intentID string) PaymentStatusResult {
 	utils.Debug("payment", "Found cached payment state", "intent_id", intentID)
 
 	terminalState := state.(*TerminalPaymentState)
-	progress := calculateProgressInfo(state.GetStartTime(), PAYMENT_POLLING_TIMEOUT)
+	progress := calculateProgressInfo(state.GetStartTime(), a.Clock.Now())
 
 	// Check for timeout
 	if progress.SecondsRemaining <= 0 {
 		// Fetch the real PaymentIntent to see its actual status
-		intent, err := paymentintent.Get(intentID, nil)
+		intent, err := services.GetPaymentIntent(a.Stripe, intentID)
 		if err != nil {
 			utils.Error("payment", "Error fetching PaymentIntent for timeout handling", "intent_id", intentID, "error", err)
 			// If we can't fetch it, create a minimal intent for cleanup
@@ -645,21 +701,38 @@ func checkTerminalPaymentStatus(intentID string) PaymentStatusResult {
 				Status: stripe.PaymentIntentStatusRequiresPaymentMethod,
 			}
 		}
-		return handleTerminalPaymentTimeout(intentID, intent)
+		return a.handleTerminalPaymentTimeout(intentID, intent)
 	}
 
 	// First, check webhook cache if available
-	if cachedState, found := GetCachedPaymentState(intentID, "payment_intent"); found {
+	if cachedState, found := a.GetCachedPaymentState(intentID, "payment_intent"); found {
 		utils.Debug("payment", "Using cached webhook state", "intent_id", intentID, "status", cachedState.Status)
+		if isFinalStatus(cachedState.Status) {
+			services.RecordStatusCheck(config.GetCommunicationStrategy(), "webhook_cache")
+		}
 
-		// Handle cached payment success
-		if cachedState.Status == "succeeded" || cachedState.Status == "charge_succeeded" {
+		// Handle cached payment success (reader action success implies capture for terminal intents)
+		if cachedState.Status == "succeeded" || cachedState.Status == "charge_succeeded" || cachedState.Status == "action_succeeded" {
 			// Create a mock intent object with the status we need
 			intent := &stripe.PaymentIntent{
 				ID:     intentID,
 				Status: stripe.PaymentIntentStatusSucceeded,
 			}
-			return handleTerminalPaymentSuccess(intentID, terminalState, intent)
+			a.consumeCachedPaymentState(intentID, "payment_intent")
+			return a.handleTerminalPaymentSuccess(intentID, terminalState, intent)
+		}
+
+		// Handle cached reader action failures (e.g. card declined on the terminal)
+		if cachedState.Status == "action_failed" {
+			intent := &stripe.PaymentIntent{
+				ID:     intentID,
+				Status: stripe.PaymentIntentStatusRequiresPaymentMethod,
+				LastPaymentError: &stripe.Error{
+					Msg: i18n.T("terminal.failed_detail", cachedState.LastPaymentError),
+				},
+			}
+			a.consumeCachedPaymentState(intentID, "payment_intent")
+			return a.handleTerminalPaymentFailure(intentID, intent)
 		}
 
 		// Handle cached payment failures
@@ -672,13 +745,32 @@ func checkTerminalPaymentStatus(intentID string) PaymentStatusResult {
 					Msg: cachedState.LastPaymentError,
 				},
 			}
-			return handleTerminalPaymentFailure(intentID, intent)
+			a.consumeCachedPaymentState(intentID, "payment_intent")
+			return a.handleTerminalPaymentFailure(intentID, intent)
+		}
+	}
+
+	// In webhook mode, keep waiting for the webhook unless it is overdue
+	if !a.shouldQueryStripe(intentID, "payment_intent", state.GetStartTime()) {
+		options := PaymentProgressOptions{
+			PaymentID:     intentID,
+			PaymentType:   "terminal",
+			Progress:      progress,
+			StatusMessage: "Waiting for customer to present payment method on terminal...",
+			ReaderID:      terminalState.ReaderID,
+		}
+		return PaymentStatusResult{
+			Component: createPaymentProgressComponentWithOptions(options),
 		}
 	}
 
 	// Fallback to direct Stripe API call if no cached state
 	utils.Debug("payment", "No cached webhook state found, checking Stripe API", "intent_id", intentID)
-	intent, err := paymentintent.Get(intentID, nil)
+	intent, err := services.GetPaymentIntent(a.Stripe, intentID)
+	if services.IsTransientStripeError(err) {
+		utils.Warn("payment", "Temporary error fetching PaymentIntent, will retry", "intent_id", intentID, "error", err)
+		return transientStripeErrorResult(intentID, "terminal", progress, terminalState.ReaderID)
+	}
 	if err != nil {
 		utils.Error("payment", "Error fetching PaymentIntent", "intent_id", intentID, "error", err)
 		return PaymentStatusResult{
@@ -689,7 +781,7 @@ func checkTerminalPaymentStatus(intentID string) PaymentStatusResult {
 
 	// IMPORTANT: For terminal payments, also check the reader action status
 	// Card declines often show up as failed reader actions before PaymentIntent status changes
-	terminalReader, readerErr := reader.Get(terminalState.ReaderID, nil)
+	terminalReader, readerErr := services.GetTerminalReader(a.Stripe, terminalState.ReaderID)
 	if readerErr != nil {
 		utils.Debug("payment", "Could not fetch terminal reader for action check", "reader_id", terminalState.ReaderID, "error", readerErr)
 		// Continue with PaymentIntent-only logic as fallback
@@ -702,7 +794,7 @@ func checkTerminalPaymentStatus(intentID string) PaymentStatusResult {
 			// Reader succeeded but we need to verify the PaymentIntent status too
 			if intent.Status == stripe.PaymentIntentStatusSucceeded {
 				utils.Info("payment", "Terminal reader action and payment both succeeded", "intent_id", intentID)
-				return handleTerminalPaymentSuccess(intentID, terminalState, intent)
+				return a.handleTerminalPaymentSuccess(intentID, terminalState, intent)
 			}
 
 		case stripe.TerminalReaderActionStatusFailed:
@@ -715,11 +807,11 @@ func checkTerminalPaymentStatus(intentID string) PaymentStatusResult {
 					ID:     intent.ID,
 					Status: intent.Status,
 					LastPaymentError: &stripe.Error{
-						Msg: fmt.Sprintf("Terminal error: %s", terminalReader.Action.FailureMessage),
+						Msg: i18n.T("terminal.failed_detail", terminalReader.Action.FailureMessage),
 					},
 				}
 			}
-			return handleTerminalPaymentFailure(intentID, enhancedIntent)
+			return a.handleTerminalPaymentFailure(intentID, enhancedIntent)
 
 		case stripe.TerminalReaderActionStatusInProgress:
 			// Still in progress, continue with PaymentIntent status checking below
@@ -732,37 +824,35 @@ func checkTerminalPaymentStatus(intentID string) PaymentStatusResult {
 				ID:     intent.ID,
 				Status: intent.Status,
 				LastPaymentError: &stripe.Error{
-					Msg: fmt.Sprintf("Unknown terminal status: %s", terminalReader.Action.Status),
+					Msg: i18n.T("terminal.unknown_status", terminalReader.Action.Status),
 				},
 			}
-			return handleTerminalPaymentFailure(intentID, unknownStatusIntent)
+			return a.handleTerminalPaymentFailure(intentID, unknownStatusIntent)
 		}
 	}
 
 	// Check for various payment states
 	switch intent.Status {
 	case stripe.PaymentIntentStatusSucceeded:
-		return handleTerminalPaymentSuccess(intentID, terminalState, intent)
+		return a.handleTerminalPaymentSuccess(intentID, terminalState, intent)
 
 	case stripe.PaymentIntentStatusCanceled:
-		return handleTerminalPaymentFailure(intentID, intent)
+		return a.handleTerminalPaymentFailure(intentID, intent)
 
 	case stripe.PaymentIntentStatusRequiresPaymentMethod:
 		// This is NORMAL for terminal payments - terminal is waiting for customer to present card
-		elapsed := time.Since(terminalState.StartTime)
-		secondsRemaining := int(math.Max(0, config.PaymentTimeout.Seconds()-elapsed.Seconds()))
-		progressWidth := math.Min(100, (elapsed.Seconds()/config.PaymentTimeout.Seconds())*100)
+		progress := calculateProgressInfo(terminalState.StartTime, a.Clock.Now())
 
 		// Check if we've timed out
-		if secondsRemaining <= 0 {
-			return handleTerminalPaymentTimeout(intentID, intent)
+		if progress.SecondsRemaining <= 0 {
+			return a.handleTerminalPaymentTimeout(intentID, intent)
 		}
 
 		// Show "waiting for card" progress
 		options := PaymentProgressOptions{
 			PaymentID:     intentID,
 			PaymentType:   "terminal",
-			Progress:      ProgressInfo{SecondsRemaining: secondsRemaining, ProgressWidth: progressWidth},
+			Progress:      progress,
 			StatusMessage: "Waiting for customer to present payment method on terminal...",
 			ReaderID:      terminalState.ReaderID,
 			PaymentStatus: string(intent.Status),
@@ -776,22 +866,20 @@ func checkTerminalPaymentStatus(intentID string) PaymentStatusResult {
 		stripe.PaymentIntentStatusRequiresConfirmation,
 		stripe.PaymentIntentStatusRequiresAction:
 		// Payment is still in progress, continue polling
-		elapsed := time.Since(terminalState.StartTime)
-		secondsRemaining := int(math.Max(0, config.PaymentTimeout.Seconds()-elapsed.Seconds()))
-		progressWidth := math.Min(100, (elapsed.Seconds()/config.PaymentTimeout.Seconds())*100)
+		progress := calculateProgressInfo(terminalState.StartTime, a.Clock.Now())
 
 		var statusMessage string
 		if intent.NextAction != nil &&
 			intent.NextAction.Type == stripe.PaymentIntentNextActionType("display_terminal_receipt") {
-			statusMessage = "Please take your receipt from the terminal."
+			statusMessage = config.GetPaymentMessage("terminal", "receipt")
 		} else {
-			statusMessage = fmt.Sprintf("Processing payment on terminal... (Status: %s)", intent.Status)
+			statusMessage = i18n.T("payment.terminal.status", intent.Status)
 		}
 
 		options := PaymentProgressOptions{
 			PaymentID:     intentID,
 			PaymentType:   "terminal",
-			Progress:      ProgressInfo{SecondsRemaining: secondsRemaining, ProgressWidth: progressWidth},
+			Progress:      progress,
 			StatusMessage: statusMessage,
 			ReaderID:      terminalState.ReaderID,
 			PaymentStatus: string(intent.Status),
@@ -810,20 +898,38 @@ func checkTerminalPaymentStatus(intentID string) PaymentStatusResult {
 	}
 }
 
+// transientStripeErrorResult keeps polling through a temporary Stripe outage.
+// If the outage outlasts the payment, the regular timeout handling concludes it.
+func transientStripeErrorResult(paymentID, paymentType string, progress ProgressInfo, readerID string) PaymentStatusResult {
+	options := PaymentProgressOptions{
+		PaymentID:     paymentID,
+		PaymentType:   paymentType,
+		Progress:      progress,
+		StatusMessage: "Temporary connectivity issue with Stripe, retrying...",
+		ReaderID:      readerID,
+	}
+	return PaymentStatusResult{
+		Message:    "Temporary connectivity issue",
+		Component:  createPaymentProgressComponentWithOptions(options),
+		ShouldStop: false,
+	}
+}
+
 // Helper functions for QR payment handling
-func handleQRPaymentTimeout(paymentLinkID string) PaymentStatusResult {
+func (a *App) handleQRPaymentTimeout(paymentLinkID string) PaymentStatusResult {
+	if !a.Payments.Conclude(paymentLinkID) {
+		return alreadyConcluded(paymentLinkID)
+	}
 	utils.Info("payment", "Payment link timed out", "payment_link_id", paymentLinkID, "timeout", PAYMENT_POLLING_TIMEOUT)
 
 	// Deactivate the payment link
-	_, err := paymentlink.Update(paymentLinkID, &stripe.PaymentLinkParams{
-		Active: stripe.Bool(false),
-	})
+	_, err := a.Stripe.DeactivatePaymentLink(paymentLinkID)
 	if err != nil {
 		utils.Error("payment", "Error deactivating payment link", "payment_link_id", paymentLinkID, "error", err)
 	}
 
 	// Log transaction as expired
-	_ = GlobalPaymentEventLogger.LogPaymentEventQuick(paymentLinkID, PaymentEventExpired, "qr")
+	_ = a.Events.LogPaymentEventQuick(paymentLinkID, PaymentEventExpired, "qr")
 
 	// Create timeout component that replaces the entire modal
 	component := checkout.PaymentExpired(paymentLinkID)
@@ -832,11 +938,11 @@ func handleQRPaymentTimeout(paymentLinkID string) PaymentStatusResult {
 	utils.Debug("sse", "Sending QR payment timeout", "payment_link_id", paymentLinkID)
 
 	// Use modal update to replace entire modal content
-	GlobalSSEBroadcaster.BroadcastModalUpdate(paymentLinkID, component)
+	a.SSE.BroadcastModalUpdate(paymentLinkID, component)
 
 	// Clean up state and SSE connection
-	GlobalPaymentStateManager.RemovePayment(paymentLinkID)
-	GlobalSSEBroadcaster.RemoveConnection(paymentLinkID)
+	a.Payments.RemovePayment(paymentLinkID)
+	a.SSE.RemoveConnection(paymentLinkID)
 
 	return PaymentStatusResult{
 		Component:  component,
@@ -844,18 +950,54 @@ func handleQRPaymentTimeout(paymentLinkID string) PaymentStatusResult {
 	}
 }
 
-func handleQRPaymentSuccess(paymentLinkID string, paymentLinkStatus services.PaymentLinkStatus) PaymentStatusResult {
-	utils.Info("payment", "Payment link completed successfully", "payment_link_id", paymentLinkID)
+func (a *App) handleQRPaymentSuccess(paymentLinkID string, paymentLinkStatus services.PaymentLinkStatus) PaymentStatusResult {
+	if !a.Payments.Conclude(paymentLinkID) {
+		return alreadyConcluded(paymentLinkID)
+	}
+	utils.Info("payment", "Payment link completed successfully", "payment_link_id", paymentLinkID, "session_id", paymentLinkStatus.SessionID)
 
-	// Calculate cart summary for transaction record
-	summary := services.CalculateCartSummary()
+	// The link is paid; stop anyone else who scanned the code from paying it again
+	if _, err := a.Stripe.DeactivatePaymentLink(paymentLinkID); err != nil {
+		utils.Error("payment", "Error deactivating paid payment link", "payment_link_id", paymentLinkID, "error", err)
+	}
+
+	// The sale belongs to the session the QR code was shown on
+	var register *services.CartStore
+	var cart []templates.Product
+	var summary templates.CartSummary
+	if state, exists := a.Payments.GetPayment(paymentLinkID); exists {
+		register = state.GetCartStore()
+		// Record the cart the QR code was shown for; the cashier may have started another since
+		if qrState, ok := state.(*QRPaymentState); ok {
+			cart, summary = qrState.Cart, qrState.Summary
+		}
+	}
+
+	// A split tender returns to the split form until the balance is paid
+	if register != nil {
+		if component, ok := a.completeSplitTender(register, paymentLinkID, "qr"); ok {
+			a.Payments.RemovePayment(paymentLinkID)
+			return PaymentStatusResult{Component: component, ShouldStop: true}
+		}
+	}
+
+	// Stripe Tax charged tax for the customer's address at checkout; record that instead of the estimate
+	if paymentLinkStatus.AutomaticTax && paymentLinkStatus.SessionID != "" {
+		taxed, err := services.ApplySessionTax(a.Stripe, paymentLinkStatus.SessionID, cart, summary)
+		if err != nil {
+			utils.Error("tax", "Error reading Stripe Tax from checkout session, recording the estimate", "payment_link_id", paymentLinkID, "error", err)
+		} else {
+			summary = taxed
+		}
+	}
 
 	// Save transaction and log Stripe-collected customer info
-	_ = GlobalPaymentEventLogger.LogPaymentEventWithStripeEmail(
+	_ = a.Events.LogPaymentEventWithStripeEmail(
+		register,
 		paymentLinkID,
 		PaymentEventSuccess,
 		"qr",
-		services.AppState.CurrentCart,
+		cart,
 		summary,
 		"",                              // No pre-payment email - customer will provide email via receipt form
 		paymentLinkStatus.CustomerEmail, // Stripe-collected email (logged separately)
@@ -863,10 +1005,10 @@ func handleQRPaymentSuccess(paymentLinkID string, paymentLinkStatus services.Pay
 
 	// Create success component that replaces the entire modal
 	// Always shows receipt form for email/phone collection (TODO: // When we add a customer DB, we may have pre-authorized CCs)
-	component := checkout.PaymentSuccess(paymentLinkID)
+	component := checkout.PaymentSuccess(paymentLinkID, config.GetCheckoutFlow())
 
 	// Clean up state - the polling loop will handle SSE broadcast and connection cleanup
-	GlobalPaymentStateManager.RemovePaymentAndClearCart(paymentLinkID)
+	a.Payments.RemovePaymentAndClearCart(paymentLinkID)
 
 	utils.Debug("sse", "QR payment success - returning component for polling loop", "payment_link_id", paymentLinkID)
 
@@ -876,22 +1018,31 @@ func handleQRPaymentSuccess(paymentLinkID string, paymentLinkStatus services.Pay
 	}
 }
 
-func handleTerminalPaymentSuccess(
+func (a *App) handleTerminalPaymentSuccess(
 	intentID string,
 	terminalState *TerminalPaymentState,
 	_ *stripe.PaymentIntent,
 ) PaymentStatusResult {
+	if !a.Payments.Conclude(intentID) {
+		return alreadyConcluded(intentID)
+	}
 	utils.Info("payment", "Terminal payment completed successfully", "intent_id", intentID)
 
+	// A split tender returns to the split form until the balance is paid
+	if component, ok := a.completeSplitTender(terminalState.CartStore, intentID, "terminal"); ok {
+		a.Payments.RemovePayment(intentID)
+		return PaymentStatusResult{Component: component, ShouldStop: true}
+	}
+
 	// Save transaction
-	_ = GlobalPaymentEventLogger.LogPaymentEventFromState(terminalState, PaymentEventSuccess, "")
+	_ = a.Events.LogPaymentEventFromState(terminalState, PaymentEventSuccess, "")
 
-	// Create success component that replaces the entire modal
-	// Always shows receipt form for email/phone collection
-	component := checkout.PaymentSuccess(intentID)
+	// Create success component that replaces the entire modal; the receipt email is
+	// collected on the reader or with the receipt form
+	component := a.paymentSuccessComponent(intentID, terminalState.ReaderID)
 
 	// Clean up state - the polling loop will handle SSE broadcast and connection cleanup
-	GlobalPaymentStateManager.RemovePaymentAndClearCart(intentID)
+	a.Payments.RemovePaymentAndClearCart(intentID)
 
 	utils.Debug("sse", "Terminal payment success - returning component for polling loop", "intent_id", intentID)
 
@@ -901,19 +1052,22 @@ func handleTerminalPaymentSuccess(
 	}
 }
 
-func handleTerminalPaymentTimeout(intentID string, _ *stripe.PaymentIntent) PaymentStatusResult {
+func (a *App) handleTerminalPaymentTimeout(intentID string, _ *stripe.PaymentIntent) PaymentStatusResult {
+	if !a.Payments.Conclude(intentID) {
+		return alreadyConcluded(intentID)
+	}
 	utils.Info("payment", "Terminal payment timed out", "intent_id", intentID, "timeout", PAYMENT_POLLING_TIMEOUT)
 
-	state, _ := GlobalPaymentStateManager.GetPayment(intentID)
+	state, _ := a.Payments.GetPayment(intentID)
 	terminalState := state.(*TerminalPaymentState)
 
 	// Log transaction as expired
-	_ = GlobalPaymentEventLogger.LogPaymentEventFromState(terminalState, PaymentEventExpired, "")
+	_ = a.Events.LogPaymentEventFromState(terminalState, PaymentEventExpired, "")
 
 	// Create timeout component that replaces the entire modal
 	component := checkout.TerminalInteractionResultModal(
-		"Payment Timed Out",
-		fmt.Sprintf("Customer did not present payment method within %.0f seconds.", config.PaymentTimeout.Seconds()),
+		i18n.T("status.timed_out_title"),
+		i18n.T("status.timed_out_message", config.PaymentTimeout.Seconds()),
 		intentID,
 		true, // hasCloseButton
 		"",   // no additional message
@@ -923,11 +1077,11 @@ func handleTerminalPaymentTimeout(intentID string, _ *stripe.PaymentIntent) Paym
 	utils.Debug("sse", "Sending terminal payment timeout", "intent_id", intentID)
 
 	// Use modal update to replace entire modal content
-	GlobalSSEBroadcaster.BroadcastModalUpdate(intentID, component)
+	a.SSE.BroadcastModalUpdate(intentID, component)
 
 	// Clean up state and SSE connection
-	GlobalPaymentStateManager.RemovePayment(intentID)
-	GlobalSSEBroadcaster.RemoveConnection(intentID)
+	a.Payments.RemovePayment(intentID)
+	a.SSE.RemoveConnection(intentID)
 
 	return PaymentStatusResult{
 		Component:  component,
@@ -935,43 +1089,66 @@ func handleTerminalPaymentTimeout(intentID string, _ *stripe.PaymentIntent) Paym
 	}
 }
 
-func handleTerminalPaymentFailure(intentID string, intent *stripe.PaymentIntent) PaymentStatusResult {
+func (a *App) handleTerminalPaymentFailure(intentID string, intent *stripe.PaymentIntent) PaymentStatusResult {
+	if !a.Payments.Conclude(intentID) {
+		return alreadyConcluded(intentID)
+	}
 	utils.Info("payment", "Terminal payment failed", "intent_id", intentID, "status", intent.Status)
 
-	state, _ := GlobalPaymentStateManager.GetPayment(intentID)
+	state, _ := a.Payments.GetPayment(intentID)
 	terminalState := state.(*TerminalPaymentState)
 
 	// Create failure message
-	failureMessage := "Payment failed"
-	if intent.LastPaymentError != nil && intent.LastPaymentError.Msg != "" {
-		failureMessage = intent.LastPaymentError.Msg
+	failureMessage, failureCode := i18n.T("status.failed"), ""
+	if intent.LastPaymentError != nil {
+		if intent.LastPaymentError.Msg != "" {
+			failureMessage = intent.LastPaymentError.Msg
+		}
+		failureCode = string(intent.LastPaymentError.Code)
 	}
 
 	// Log transaction as failed
-	_ = GlobalPaymentEventLogger.LogPaymentEventFromState(terminalState, PaymentEventFailed, "")
+	_ = a.Events.LogPaymentEventFromState(terminalState, PaymentEventFailed, "")
+
+	// The decline belongs to the sale on the register unless the cashier has moved on to another
+	retryMethod := ""
+	if terminalState.CartStore.Hash() == terminalState.CartHash {
+		retryMethod = recordDeclinedAttempt(terminalState.CartStore, intentID, "terminal")
+	}
 
 	// Create failure component that replaces the entire modal
-	component := checkout.PaymentDeclinedModal(failureMessage, intentID)
+	component := checkout.PaymentDeclinedModal(failureMessage, intentID, services.IsAccountError(failureCode), retryMethod)
 
 	// Send failure via SSE to replace entire modal content - this removes the SSE container
 	utils.Debug("sse", "Sending terminal payment failure", "intent_id", intentID)
 
 	// Use modal update to replace entire modal content
-	GlobalSSEBroadcaster.BroadcastModalUpdate(intentID, component)
+	a.SSE.BroadcastModalUpdate(intentID, component)
 
 	// Clean up state and SSE connection
-	GlobalPaymentStateManager.RemovePayment(intentID)
-	GlobalSSEBroadcaster.RemoveConnection(intentID)
+	a.Payments.RemovePayment(intentID)
+	a.SSE.RemoveConnection(intentID)
 
 	return PaymentStatusResult{
+		Message:    failureMessage,
 		Component:  component,
 		ShouldStop: true,
 	}
 }
 
+// alreadyConcluded is the status result for a payment whose outcome another path recorded first.
+// That path shows the result, so there is nothing to broadcast.
+func alreadyConcluded(paymentID string) PaymentStatusResult {
+	utils.Debug("payment", "Payment outcome already recorded by another path", "payment_id", paymentID)
+	return PaymentStatusResult{
+		Message:    i18n.T("status.concluded_message"),
+		ShouldStop: true,
+	}
+}
+
 // GetPaymentStatusHandler - endpoint for checking payment status
 // Used by failsafe timeout and can be used for any payment status check
-func GetPaymentStatusHandler(w http.ResponseWriter, r *http.Request) {
+func (a *App) GetPaymentStatusHandler(w http.ResponseWriter, r *http.Request) {
 	paymentType := r.URL.Query().Get("type")
 	if paymentType == "" {
 		paymentType = r.FormValue("type")
@@ -988,13 +1165,13 @@ func GetPaymentStatusHandler(w http.ResponseWriter, r *http.Request) {
 			PaymentType:     "qr",
 			TimeoutDuration: PAYMENT_POLLING_TIMEOUT,
 		}
-		checkPaymentStatusGeneric(w, r, config)
+		a.checkPaymentStatusGeneric(w, r, config)
 	case "terminal":
 		config := PaymentPollingConfig{
 			PaymentType:     "terminal",
 			TimeoutDuration: PAYMENT_POLLING_TIMEOUT,
 		}
-		checkPaymentStatusGeneric(w, r, config)
+		a.checkPaymentStatusGeneric(w, r, config)
 	default:
 		http.Error(w, "invalid payment type, must be 'qr' or 'terminal'", http.StatusBadRequest)
 	}
@@ -1003,7 +1180,7 @@ func GetPaymentStatusHandler(w http.ResponseWriter, r *http.Request) {
 // CancelOrRefreshPaymentHandler - endpoint for cancel + hard refresh
 // Cancels the payment server-side, then returns current state (like GetPaymentStatusHandler)
 // Used by both cancel buttons and timeout handling for consistent behavior
-func CancelOrRefreshPaymentHandler(w http.ResponseWriter, r *http.Request) {
+func (a *App) CancelOrRefreshPaymentHandler(w http.ResponseWriter, r *http.Request) {
 	if err := r.ParseForm(); err != nil {
 		utils.Error("payment", "Error parsing form in cancel/refresh", "error", err)
 		http.Error(w, "Error parsing form", http.StatusBadRequest)
@@ -1035,36 +1212,31 @@ func CancelOrRefreshPaymentHandler(w http.ResponseWriter, r *http.Request) {
 
 	utils.Info("payment", "Starting cancel+refresh", "payment_type", paymentType, "payment_id", paymentID)
 
-	// Step 1: Cancel the payment server-side
-	cancelSuccess := cancelPaymentServerSide(paymentID, paymentType)
-	if cancelSuccess {
-		utils.Info("payment", "Successfully cancelled payment in cancel/refresh", "payment_type", paymentType, "payment_id", paymentID)
-
-		// Since cancel was successful, return expired component directly
-		// Don't do another Stripe check as it might see stale/cached data
-		var expiredComponent templ.Component
-		switch paymentType {
-		case "qr":
-			expiredComponent = checkout.PaymentExpired(paymentID)
-		case "terminal":
-			expiredComponent = checkout.TerminalInteractionResultModal(
-				"Payment Cancelled",
-				"The payment has been cancelled.",
-				paymentID,
-				true, // hasCloseButton
-				"",   // no additional message
-			)
-		default:
-			http.Error(w, "invalid payment type", http.StatusBadRequest)
+	// Terminal payments can complete while the cancel is on its way, so they settle their own outcome
+	if paymentType == "terminal" {
+		if component, completed, ok := a.cancelTerminalPayment(paymentID); ok {
+			if completed {
+				setToast(w, "warning", "toast.payment_completed_before_cancel")
+			}
+			if err := component.Render(r.Context(), w); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+			}
 			return
 		}
+	}
+
+	// Step 1: Cancel the QR payment link server-side
+	if paymentType == "qr" {
+		if a.cancelQRPaymentServerSide(paymentID) {
+			utils.Info("payment", "Successfully cancelled payment in cancel/refresh", "payment_type", paymentType, "payment_id", paymentID)
 
-		utils.Debug("payment", "Returning expired component after successful cancel", "payment_type", paymentType, "payment_id", paymentID)
-		if err := expiredComponent.Render(r.Context(), w); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			// Since cancel was successful, return expired component directly
+			// Don't do another Stripe check as it might see stale/cached data
+			if err := checkout.PaymentExpired(paymentID).Render(r.Context(), w); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+			}
+			return
 		}
-		return
-	} else {
 		utils.Warn("payment", "Cancel attempt failed in cancel/refresh, continuing with refresh", "payment_type", paymentType, "payment_id", paymentID)
 	}
 
@@ -1076,79 +1248,124 @@ func CancelOrRefreshPaymentHandler(w http.ResponseWriter, r *http.Request) {
 			PaymentType:     "qr",
 			TimeoutDuration: PAYMENT_POLLING_TIMEOUT,
 		}
-		checkPaymentStatusGeneric(w, r, config)
+		a.checkPaymentStatusGeneric(w, r, config)
 	case "terminal":
 		config := PaymentPollingConfig{
 			PaymentType:     "terminal",
 			TimeoutDuration: PAYMENT_POLLING_TIMEOUT,
 		}
-		checkPaymentStatusGeneric(w, r, config)
+		a.checkPaymentStatusGeneric(w, r, config)
 	default:
 		http.Error(w, "invalid payment type, must be 'qr' or 'terminal'", http.StatusBadRequest)
 	}
 }
 
-// cancelPaymentServerSide attempts to cancel a payment server-side
-// Returns true if cancellation succeeded, false if it failed (but that's ok)
-func cancelPaymentServerSide(paymentID, paymentType string) bool {
-	switch paymentType {
-	case "qr":
-		return cancelQRPaymentServerSide(paymentID)
-	case "terminal":
-		return cancelTerminalPaymentServerSide(paymentID)
-	default:
-		utils.Warn("payment", "Unknown payment type in cancel operation", "payment_type", paymentType)
-		return false
-	}
-}
-
 // cancelQRPaymentServerSide cancels a QR payment link
-func cancelQRPaymentServerSide(paymentLinkID string) bool {
+func (a *App) cancelQRPaymentServerSide(paymentLinkID string) bool {
 	// Deactivate the payment link in Stripe
-	_, err := paymentlink.Update(paymentLinkID, &stripe.PaymentLinkParams{Active: stripe.Bool(false)})
+	_, err := a.Stripe.DeactivatePaymentLink(paymentLinkID)
 	if err != nil {
 		utils.Error("payment", "Error cancelling QR payment link", "payment_link_id", paymentLinkID, "error", err)
 		return false
 	}
 
 	// Log the cancellation
-	_ = GlobalPaymentEventLogger.LogPaymentEventQuick(paymentLinkID, PaymentEventCancelled, "qr")
+	_ = a.Events.LogPaymentEventQuick(paymentLinkID, PaymentEventCancelled, "qr")
 
 	utils.Info("payment", "Successfully cancelled QR payment link", "payment_link_id", paymentLinkID)
 	return true
 }
 
-// cancelTerminalPaymentServerSide cancels a terminal payment
-func cancelTerminalPaymentServerSide(paymentIntentID string) bool {
-	state, found := GlobalPaymentStateManager.GetPayment(paymentIntentID)
+// cancelTerminalPayment cancels a terminal payment unless the customer has already paid.
+// The intent is fetched first: one that succeeded or is processing goes down the normal success
+// path instead, with completed set so the cashier can be told. Otherwise the reader's action is
+// cancelled before the intent, and the modal says which step failed, if any. ok is false when the
+// payment isn't tracked, as it may have concluded already.
+func (a *App) cancelTerminalPayment(intentID string) (component templ.Component, completed, ok bool) {
+	state, found := a.Payments.GetPayment(intentID)
 	if !found {
-		utils.Debug("payment", "Terminal payment not found in active states during cancel", "payment_intent_id", paymentIntentID)
-		return false // Not found, but that's ok - might already be concluded
+		utils.Debug("payment", "Terminal payment not found in active states during cancel", "payment_intent_id", intentID)
+		return nil, false, false
 	}
-
-	terminalState, ok := state.(*TerminalPaymentState)
-	if !ok {
-		utils.Error("payment", "Payment is not a terminal payment during cancel", "payment_id", paymentIntentID)
-		return false
+	terminalState, isTerminal := state.(*TerminalPaymentState)
+	if !isTerminal {
+		utils.Error("payment", "Payment is not a terminal payment during cancel", "payment_id", intentID)
+		return nil, false, false
 	}
 
-	// Try to cancel the reader action first
-	_, err := reader.CancelAction(terminalState.ReaderID, &stripe.TerminalReaderCancelActionParams{})
+	intent, err := a.Stripe.GetPaymentIntent(intentID)
 	if err != nil {
-		utils.Warn("payment", "Error cancelling reader action", "payment_intent_id", paymentIntentID, "reader_id", terminalState.ReaderID, "error", err)
-		// Continue anyway - try to cancel the payment intent
+		// Stripe refuses to cancel a paid intent, so trying anyway is safe
+		utils.Warn("payment", "Could not check PaymentIntent before cancelling", "payment_intent_id", intentID, "error", err)
+	} else if paymentCompleting(intent) {
+		return a.completeInsteadOfCancel(intentID, terminalState, intent), true, true
 	}
 
-	// Cancel the Payment Intent
-	_, cancelErr := paymentintent.Cancel(paymentIntentID, nil)
-	if cancelErr != nil {
-		utils.Error("payment", "Error cancelling PaymentIntent", "payment_intent_id", paymentIntentID, "error", cancelErr)
-		return false
+	// The reader is stopped first so the customer can't tap while the intent is being cancelled
+	_, readerErr := a.Stripe.CancelReaderAction(terminalState.ReaderID)
+	if readerErr != nil {
+		utils.Warn("payment", "Error cancelling reader action", "payment_intent_id", intentID, "reader_id", terminalState.ReaderID, "error", readerErr)
 	}
 
-	// Log the cancellation
-	_ = GlobalPaymentEventLogger.LogPaymentEventFromState(terminalState, PaymentEventCancelled, "")
+	if intent == nil || intent.Status != stripe.PaymentIntentStatusCanceled {
+		if _, cancelErr := a.Stripe.CancelPaymentIntent(intentID); cancelErr != nil {
+			utils.Error("payment", "Error cancelling PaymentIntent", "payment_intent_id", intentID, "error", cancelErr)
 
-	utils.Info("payment", "Successfully cancelled terminal payment", "payment_intent_id", paymentIntentID)
-	return true
+			// The customer may have paid between the check and the cancel
+			if latest, err := a.Stripe.GetPaymentIntent(intentID); err == nil && paymentCompleting(latest) {
+				return a.completeInsteadOfCancel(intentID, terminalState, latest), true, true
+			}
+
+			// The payment stays tracked, so a payment that still goes through is recorded
+			return checkout.TerminalInteractionResultModal(
+				i18n.T("status.cancel_failed_title"),
+				i18n.T("status.cancel_failed_message", stripeErrorText(cancelErr)),
+				intentID,
+				true, // hasCloseButton
+				"",   // no additional message
+			), false, true
+		}
+	}
+
+	if a.Payments.Conclude(intentID) {
+		_ = a.Events.LogPaymentEventFromState(terminalState, PaymentEventCancelled, "")
+	}
+	a.Payments.RemovePayment(intentID)
+	a.SSE.RemoveConnection(intentID)
+	utils.Info("payment", "Successfully cancelled terminal payment", "payment_intent_id", intentID, "reader_reset", readerErr == nil)
+
+	message := i18n.T("status.cancelled_message")
+	if readerErr != nil {
+		message = i18n.T("status.cancelled_reader_not_reset", stripeErrorText(readerErr))
+	}
+	return checkout.TerminalInteractionResultModal(
+		i18n.T("status.cancelled_title"),
+		message,
+		intentID,
+		true, // hasCloseButton
+		"",   // no additional message
+	), false, true
+}
+
+// paymentCompleting reports whether a terminal PaymentIntent has been paid or is being charged,
+// so it must not be reported as cancelled
+func paymentCompleting(intent *stripe.PaymentIntent) bool {
+	return intent.Status == stripe.PaymentIntentStatusSucceeded || intent.Status == stripe.PaymentIntentStatusProcessing
+}
+
+// completeInsteadOfCancel records a terminal payment the customer completed before the cashier's
+// cancel reached Stripe, exactly as if the status check had found it
+func (a *App) completeInsteadOfCancel(intentID string, terminalState *TerminalPaymentState, intent *stripe.PaymentIntent) templ.Component {
+	utils.Warn("payment", "Terminal payment completed before it could be cancelled", "payment_intent_id", intentID, "status", intent.Status)
+	result := a.handleTerminalPaymentSuccess(intentID, terminalState, intent)
+	a.SSE.RemoveConnection(intentID)
+	return result.Component
+}
+
+// stripeErrorText returns the message of a Stripe API error, or the error itself for other failures
+func stripeErrorText(err error) string {
+	if stripeErr, ok := err.(*stripe.Error); ok && stripeErr.Msg != "" {
+		return stripeErr.Msg
+	}
+	return err.Error()
 }
@@ -1,17 +1,19 @@
 package handlers
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 	"strings"
 
 	"github.com/a-h/templ"
 	"github.com/stripe/stripe-go/v74"
-	"github.com/stripe/stripe-go/v74/paymentintent"
 
 	"checkout/config"
+	"checkout/handlers/htmx"
+	"checkout/i18n"
 	"checkout/services"
-	"checkout/templates"
+	"checkout/services/validation"
 	"checkout/templates/checkout"
 	"checkout/utils"
 )
@@ -20,28 +22,18 @@ import (
 // These functions eliminate the repeated HTMX modal pattern found throughout the payment handlers
 
 // renderModal renders any templ component in a modal with proper HTMX headers
-// This is the core abstraction that eliminates 15+ instances of duplicated modal code
+// This is the core abstraction that eliminates 15+ instances of duplicated modal code.
+// events are fired along with showModal (e.g. "cartUpdated"); a toast set before is kept.
 func renderModal(
 	w http.ResponseWriter,
 	r *http.Request,
 	component templ.Component,
-	additionalTriggers ...string,
+	events ...string,
 ) error {
 	// Set the standard HTMX headers for modal display
 	w.Header().Set("HX-Retarget", "#modal-content") // Target the modal content div
 	w.Header().Set("HX-Reswap", "innerHTML")        // Replace content inside the div
-
-	// Handle different trigger patterns - simple vs complex triggers
-	var trigger string
-	if len(additionalTriggers) > 0 {
-		// Complex trigger: {"showModal": true, "cartUpdated": true, ...}
-		triggers := append([]string{`"showModal": true`}, additionalTriggers...)
-		trigger = "{" + strings.Join(triggers, ", ") + "}"
-	} else {
-		// Simple trigger: showModal (no quotes for simple triggers)
-		trigger = `showModal`
-	}
-	w.Header().Set("HX-Trigger", trigger)
+	htmx.Trigger(w, append([]string{"showModal"}, events...)...)
 
 	w.WriteHeader(http.StatusOK)
 	return component.Render(r.Context(), w)
@@ -51,15 +43,84 @@ func renderModal(
 // Replaces the common pattern of showing PaymentDeclinedModal with error messages
 func renderErrorModal(w http.ResponseWriter, r *http.Request, message, id string) error {
 	utils.Debug("payment", "Rendering error modal", "message", message, "id", id)
-	return renderModal(w, r, checkout.PaymentDeclinedModal(message, id))
+	return renderModal(w, r, checkout.PaymentDeclinedModal(message, id, false, ""))
+}
+
+// renderDeclineModal shows a failed payment with the Stripe failure code behind it, noting when
+// the code points at a restriction on the Stripe account rather than the customer's card.
+// A declined card payment is recorded with the sale and offered for retry.
+func (a *App) renderDeclineModal(w http.ResponseWriter, r *http.Request, message, id, code, method string) error {
+	utils.Debug("payment", "Rendering decline modal", "message", message, "id", id, "code", code, "payment_method", method)
+	retryMethod := recordDeclinedAttempt(a.cart(r), id, method)
+	return renderModal(w, r, checkout.PaymentDeclinedModal(message, id, services.IsAccountError(code), retryMethod))
+}
+
+// recordDeclinedAttempt adds a declined card payment to the sale in progress, so a retry reuses
+// its PaymentIntent. Returns the payment method to retry with, "" when it can't be retried.
+func recordDeclinedAttempt(cart *services.CartStore, intentID, method string) string {
+	if intentID == "" || !services.RetryablePaymentMethod(method) {
+		return ""
+	}
+	cart.RecordDeclinedAttempt(services.PaymentAttempt{IntentID: intentID, Method: method})
+	return method
+}
+
+// paymentIntentFor returns the PaymentIntent for charging amount, reusing the sale's declined one
+// when it can be retried (see services.PaymentIntentFor). A reused intent's earlier outcome is
+// forgotten, so the retry is tracked like a new payment.
+func (a *App) paymentIntentFor(cart *services.CartStore, amount float64, method string) (*stripe.PaymentIntent, error) {
+	intent, reused, err := services.PaymentIntentFor(a.Stripe, cart, amount, method)
+	if err == nil && reused {
+		a.Payments.Reopen(intent.ID)
+		a.resetCachedPaymentState(intent.ID)
+	}
+	return intent, err
+}
+
+// stripeErrorCode returns the code of a Stripe API error, or "" for any other error
+func stripeErrorCode(err error) string {
+	var stripeErr *stripe.Error
+	if errors.As(err, &stripeErr) {
+		return string(stripeErr.Code)
+	}
+	return ""
+}
+
+// setPaymentErrorToast reports a payment that couldn't be started, pointing at the Stripe
+// account when it is the cause
+func setPaymentErrorToast(w http.ResponseWriter, err error) {
+	if services.IsAccountError(stripeErrorCode(err)) {
+		setToast(w, "error", "toast.payment_account_restricted")
+		return
+	}
+	setToast(w, "error", "toast.payment_error")
+}
+
+// rejectMixedVendors stops card payments for carts that can't be paid out to a single market
+// vendor (see services.CartVendor); those are paid in cash or checked out vendor by vendor.
+// Returns true if the request was answered.
+func rejectMixedVendors(w http.ResponseWriter, cart *services.CartStore) bool {
+	_, err := services.CartVendor(cart.Items())
+	if err == nil {
+		return false
+	}
+	var mixed *services.MixedVendorsError
+	if errors.As(err, &mixed) {
+		setToast(w, "warning", "toast.mixed_vendors", strings.Join(mixed.Vendors, ", "))
+	} else {
+		utils.Error("payment", "Cart can't be paid out to its vendor", "error", err)
+		setToast(w, "error", "toast.vendor_unavailable")
+	}
+	w.WriteHeader(http.StatusOK)
+	return true
 }
 
 // renderSuccessModal - Specialized helper for success cases
 // Replaces the common pattern of showing success modals with cart updates
 func renderSuccessModal(w http.ResponseWriter, r *http.Request, paymentID string, hasEmail bool) error {
 	utils.Info("payment", "Rendering success modal", "payment_id", paymentID, "has_email", hasEmail)
-	// Always show receipt form after payment completion
-	return renderModal(w, r, checkout.PaymentSuccess(paymentID), `"cartUpdated": true`)
+	// Always show receipt form after payment completion; the Checkout Flow settings decide what happens next
+	return renderModal(w, r, checkout.PaymentSuccess(paymentID, config.GetCheckoutFlow()), "cartUpdated")
 }
 
 // renderInfoModal - Specialized helper for informational modals
@@ -69,56 +130,50 @@ func renderInfoModal(w http.ResponseWriter, r *http.Request, component templ.Com
 }
 
 // ProcessPaymentHandler handles payment processing
-func ProcessPaymentHandler(w http.ResponseWriter, r *http.Request) {
-	if len(services.AppState.CurrentCart) == 0 {
-		w.Header().Set("HX-Trigger", `{"showToast": {"message": "Cart is empty", "type": "warning"}}`)
+func (a *App) ProcessPaymentHandler(w http.ResponseWriter, r *http.Request) {
+	cart := a.cart(r)
+	if cart.Len() == 0 {
+		setToast(w, "warning", "toast.cart_empty")
 		w.WriteHeader(http.StatusOK) // Changed from BadRequest to OK since this is a valid user action
 		return
 	}
 
+	if rejectNonPositiveTotal(w, cart) {
+		return
+	}
+	if rejectMixedVendors(w, cart) {
+		return
+	}
+
 	if err := r.ParseForm(); err != nil {
 		http.Error(w, "Error parsing form", http.StatusBadRequest)
 		return
 	}
 
 	paymentMethod := r.FormValue("payment_method")
-
-	// Calculate cart summary with taxes
-	summary := services.CalculateCartSummary()
-
-	// Create a payment intent with appropriate payment method
-	params := &stripe.PaymentIntentParams{
-		Amount:        stripe.Int64(int64(summary.Total * 100)), // Convert to cents
-		Currency:      stripe.String("usd"),
-		CaptureMethod: stripe.String("automatic"),
+	cart.SetPaymentMethod(paymentMethod)
+	if !a.quoteStripeTax(w, cart) {
+		return
 	}
 
-	// Configure payment method types based on the payment method
-	switch paymentMethod {
-	case "terminal":
-		params.PaymentMethodTypes = []*string{
-			stripe.String("card_present"),
-		}
-	case "manual":
-		params.PaymentMethodTypes = []*string{
-			stripe.String("card"),
-		}
-		// Additional fields for manual card entry would be processed here
-	case "qr":
-		params.PaymentMethodTypes = []*string{
-			stripe.String("card"),
-		}
-		// QR code specific configuration would go here
-	default:
-		params.PaymentMethodTypes = []*string{
-			stripe.String("card_present"),
-		}
+	// The note field is part of the form, so take its latest value in case the typed note wasn't saved yet
+	if r.Form.Has("note") {
+		cart.SetNote(services.SanitizeNote(r.FormValue("note")))
 	}
 
-	intent, err := paymentintent.New(params)
+	// Terminal customers tip on the reader, so no on-screen tip carries over
+	cart.SetTip(0)
+
+	// Calculate cart summary with taxes
+	summary := services.CalculateCartSummary(cart)
+
+	// Split sales charge only the current tender
+	amount := services.ChargeAmount(cart, summary)
+
+	intent, err := a.paymentIntentFor(cart, amount, paymentMethod)
 	if err != nil {
-		utils.Error("payment", "Error creating payment intent", "payment_method", paymentMethod, "amount", summary.Total, "error", err)
-		w.Header().Set("HX-Trigger", `{"showToast": "Error processing payment"}`)
+		utils.Error("payment", "Error creating payment intent", "payment_method", paymentMethod, "amount", amount, "error", err)
+		setPaymentErrorToast(w, err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
@@ -129,7 +184,7 @@ func ProcessPaymentHandler(w http.ResponseWriter, r *http.Request) {
 	switch paymentMethod {
 	case "terminal":
 		// Delegate all terminal processing to payment_terminal.go
-		result := ProcessTerminalPayment(w, r, intent, "", summary)
+		result := a.ProcessTerminalPayment(w, r, intent, "", summary)
 		if result.ShouldStop {
 			if result.PaymentSuccess {
 				paymentSuccess = true
@@ -162,35 +217,50 @@ func ProcessPaymentHandler(w http.ResponseWriter, r *http.Request) {
 		return
 
 	default:
-		w.Header().Set("HX-Trigger", `{"showToast": "Invalid payment method"}`)
+		setToast(w, "error", "toast.invalid_payment_method")
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 
 	// Handle successful payment (terminal immediate success)
 	if paymentSuccess {
+		// A split tender returns to the split form until the balance is paid
+		if component, ok := a.completeSplitTender(cart, intent.ID, paymentMethod); ok {
+			if renderErr := renderModal(w, r, component, "cartUpdated"); renderErr != nil {
+				utils.Error("payment", "Error rendering split payment result", "intent_id", intent.ID, "error", renderErr)
+			}
+			return
+		}
+
 		// Log the successful transaction (no email - will be collected post-payment)
-		_ = GlobalPaymentEventLogger.LogPaymentEvent(
+		_ = a.Events.LogPaymentEvent(
+			cart,
 			intent.ID,
 			PaymentEventSuccess,
 			paymentMethod,
-			services.AppState.CurrentCart,
+			cart.Items(),
 			summary,
 			"", // No email - will be collected post-payment via receipt form
 		)
 
 		// Clear cart
-		services.AppState.CurrentCart = []templates.Product{}
+		cart.Clear()
 
-		// Show success modal (always show receipt form)
-		if renderErr := renderSuccessModal(w, r, intent.ID, false); renderErr != nil {
+		// Show success modal; terminal payments may collect the receipt email on the reader
+		var renderErr error
+		if paymentMethod == "terminal" {
+			renderErr = renderModal(w, r, a.paymentSuccessComponent(intent.ID, selectedReader(w, r).ReaderID), "cartUpdated")
+		} else {
+			renderErr = renderSuccessModal(w, r, intent.ID, false)
+		}
+		if renderErr != nil {
 			utils.Error("payment", "Error rendering payment success modal", "intent_id", intent.ID, "error", renderErr)
 		}
 	}
 }
 
 // ReceiptInfoHandler handles receipt information updates and sending
-func ReceiptInfoHandler(w http.ResponseWriter, r *http.Request) {
+func (a *App) ReceiptInfoHandler(w http.ResponseWriter, r *http.Request) {
 	if err := r.ParseForm(); err != nil {
 		http.Error(w, "Error parsing form", http.StatusBadRequest)
 		return
@@ -206,13 +276,57 @@ func ReceiptInfoHandler(w http.ResponseWriter, r *http.Request) {
 	// Validate that at least email is provided (phone only if SMS is enabled)
 	if email == "" {
 		if phone != "" && !config.IsSMSEnabled() {
-			renderReceiptError(w, "Please provide an email address. SMS receipts are not currently enabled.")
+			renderReceiptError(w, i18n.T("receipt.email_required_no_sms"))
 		} else {
-			renderReceiptError(w, "Please provide an email address.")
+			renderReceiptError(w, i18n.T("receipt.email_required"))
 		}
 		return
 	}
 
+	email, phone, err := validateReceiptContact(email, phone)
+	if err != nil {
+		renderReceiptError(w, err.Error())
+		return
+	}
+
+	sentMethod, err := sendReceipt(a.Stripe, confirmationCode, email, phone, "manual_receipt")
+	if errors.Is(err, errReceiptNotRecorded) {
+		renderReceiptError(w, i18n.T("receipt.not_recorded"))
+		return
+	} else if err != nil {
+		renderReceiptError(w, i18n.T("receipt.send_failed"))
+		return
+	}
+
+	// Success - render success component
+	renderReceiptSuccess(w, sentMethod)
+}
+
+// validateReceiptContact checks the receipt email and phone typed by the cashier, either of which
+// may be empty, and returns them normalized: the phone number in E.164 form
+func validateReceiptContact(email, phone string) (string, string, error) {
+	if strings.TrimSpace(email) != "" {
+		var err error
+		if email, err = validation.Email(email, config.GetEmailTypoDomains()); err != nil {
+			return "", "", err
+		}
+	}
+	if strings.TrimSpace(phone) != "" {
+		var err error
+		if phone, err = validation.Phone(phone, config.Config.PhoneCountry); err != nil {
+			return "", "", err
+		}
+	}
+	return strings.TrimSpace(email), strings.TrimSpace(phone), nil
+}
+
+// errReceiptNotRecorded is returned by sendReceipt when the receipt record can't be saved
+var errReceiptNotRecorded = errors.New("receipt request not recorded")
+
+// sendReceipt records a receipt request, sets the email on the Stripe payment so Stripe sends
+// its receipt too, and sends the email and SMS receipts. source names where the contact details
+// came from in the payment update log. It returns how the receipt was sent (e.g. "email and SMS").
+func sendReceipt(stripeClient services.StripeClient, confirmationCode, email, phone, source string) (string, error) {
 	// Determine delivery method
 	var deliveryMethod string
 	if email != "" && phone != "" {
@@ -223,12 +337,18 @@ func ReceiptInfoHandler(w http.ResponseWriter, r *http.Request) {
 		deliveryMethod = "sms"
 	}
 
+	// Have Stripe send its receipt too; failures are recorded but don't stop our receipt
+	var stripeError string
+	if err := services.UpdatePaymentReceiptEmail(stripeClient, confirmationCode, email, source); err != nil {
+		stripeError = err.Error()
+	}
+
 	// Create initial receipt record
 	receiptRecord := services.CreateReceiptRecord(confirmationCode, email, phone, deliveryMethod, "pending")
+	receiptRecord.ErrorMessage = stripeError
 	if err := services.SaveReceiptRecord(receiptRecord); err != nil {
 		utils.Error("receipt", "Error saving receipt record", "confirmation_code", confirmationCode, "error", err)
-		renderReceiptError(w, "Error recording receipt request. Please try again.")
-		return
+		return "", fmt.Errorf("%w: %v", errReceiptNotRecorded, err)
 	}
 
 	// Simulate receipt sending (replace with actual email/SMS service)
@@ -258,27 +378,17 @@ func ReceiptInfoHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Update receipt delivery status
-	var finalStatus string
-	var errorMessage string
 	if sendError != nil {
-		finalStatus = "failed"
-		errorMessage = sendError.Error()
 		utils.Error("receipt", "Error sending receipt", "confirmation_code", confirmationCode, "method", deliveryMethod, "error", sendError)
-
-		// Log the failure
-		_ = services.UpdateReceiptDeliveryStatus(confirmationCode, finalStatus, errorMessage)
-
-		renderReceiptError(w, "Failed to send receipt. Please check your contact information and try again.")
-		return
-	} else {
-		finalStatus = "sent"
-		// Log the success
-		_ = services.UpdateReceiptDeliveryStatus(confirmationCode, finalStatus, "")
+		_ = services.UpdateReceiptDeliveryStatus(confirmationCode, "failed", sendError.Error())
+		services.RecordReceiptActivity(confirmationCode, email, phone, false)
+		return "", sendError
 	}
+	_ = services.UpdateReceiptDeliveryStatus(confirmationCode, "sent", "")
+	services.RecordReceiptActivity(confirmationCode, email, phone, true)
 
-	// Success - render success component
-	utils.Info("receipt", "Receipt sent successfully", "confirmation_code", confirmationCode, "method", sentMethod)
-	renderReceiptSuccess(w, sentMethod)
+	utils.Info("receipt", "Receipt sent successfully", "confirmation_code", confirmationCode, "method", sentMethod, "source", source)
+	return sentMethod, nil
 }
 
 // sendEmailReceipt simulates sending an email receipt
@@ -317,7 +427,8 @@ func renderReceiptSuccess(w http.ResponseWriter, method string) {
 	// and show a green success toast notification
 
 	w.Header().Set("Content-Type", "text/html")
-	w.Header().Set("HX-Trigger", fmt.Sprintf(`{"closeModal": true, "showToastSuccess": "Receipt sent to %s!"}`, method))
+	setToast(w, "success", "toast.receipt_sent", method)
+	htmx.CloseModal(w)
 	w.WriteHeader(http.StatusOK)
 	_, _ = w.Write([]byte("")) // Empty response since we're just triggering events
 }
@@ -328,7 +439,7 @@ func renderReceiptError(w http.ResponseWriter, errorMessage string) {
 	// This allows the user to try again without losing their input
 
 	w.Header().Set("Content-Type", "text/html")
-	w.Header().Set("HX-Trigger", fmt.Sprintf(`{"showToast": "%s"}`, errorMessage))
+	setToastText(w, "error", errorMessage)
 	w.WriteHeader(http.StatusOK)
 	_, _ = w.Write([]byte("")) // Empty response since we're just showing a toast
 }
@@ -337,18 +448,18 @@ func renderReceiptError(w http.ResponseWriter, errorMessage string) {
 
 // ClearPaymentStates clears all payment-related state
 // ClearPaymentStates clears all payment-related state
-func ClearPaymentStates() {
-	GlobalPaymentStateManager.ClearAll()
+func (a *App) ClearPaymentStates() {
+	a.Payments.ClearAll()
 	utils.Info("payment", "All payment states cleared")
 }
 
 // ClearExpiredPaymentStates removes expired payment states
-func ClearExpiredPaymentStates() {
-	GlobalPaymentStateManager.CleanupExpired()
+func (a *App) ClearExpiredPaymentStates() {
+	a.Payments.CleanupExpired()
 	utils.Info("payment", "Expired payment states cleared")
 }
 
 // GetActivePaymentStatesCount returns the number of active payment states
-func GetActivePaymentStatesCount() (int, int) {
-	return GlobalPaymentStateManager.GetActiveCountByType()
+func (a *App) GetActivePaymentStatesCount() (int, int) {
+	return a.Payments.GetActiveCountByType()
 }
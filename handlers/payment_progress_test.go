@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"html"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/a-h/templ"
+	"github.com/stripe/stripe-go/v74"
+
+	"checkout/config"
+)
+
+// expectCancelForm checks that rendered progress lets the cashier cancel the payment and checks
+// its status on its own when updates stop arriving
+func expectCancelForm(t *testing.T, where, rendered, paymentID, paymentType string) {
+	t.Helper()
+	page := html.UnescapeString(rendered)
+	vals := fmt.Sprintf(`hx-vals="{"payment_id": "%s", "type": "%s"}"`, paymentID, paymentType)
+	elements := map[string][]string{
+		"cancel button": {`class="cancel-btn"`, `hx-post="/cancel-or-refresh-payment"`, vals},
+		"failsafe":      {`hx-get="/get-payment-status"`, vals, fmt.Sprintf(`hx-trigger="load delay:%ds"`, config.GetFailsafeTimeoutSeconds())},
+	}
+	for name, attributes := range elements {
+		if tag := elementWith(page, attributes[0]); tag == "" || !containsAll(tag, attributes) {
+			t.Errorf("%s has no %s with %v:\n%s", where, name, attributes, page)
+		}
+	}
+}
+
+// elementWith returns the opening tag that has the given attribute, or "" if none does
+func elementWith(page, attribute string) string {
+	at := strings.Index(page, attribute)
+	if at < 0 {
+		return ""
+	}
+	start := strings.LastIndex(page[:at], "<")
+	end := strings.Index(page[at:], ">")
+	if start < 0 || end < 0 {
+		return ""
+	}
+	return page[start : at+end+1]
+}
+
+func containsAll(s string, parts []string) bool {
+	for _, part := range parts {
+		if !strings.Contains(s, part) {
+			return false
+		}
+	}
+	return true
+}
+
+func renderComponent(t *testing.T, component templ.Component) string {
+	t.Helper()
+	if component == nil {
+		t.Fatal("no component rendered")
+	}
+	var buf bytes.Buffer
+	if err := component.Render(context.Background(), &buf); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+// The progress of a payment can come from the container that opened it, an SSE update or a status
+// check; each has the same cancel button
+func TestPaymentProgressHasCancelForm(t *testing.T) {
+	tests := []struct {
+		name      string
+		start     func(app *App) (body, id string)
+		check     func(app *App, id string) PaymentStatusResult
+		broadcast func(app *App, id string)
+	}{
+		{
+			name: "qr",
+			start: func(app *App) (string, string) {
+				rec := postForm(app.GenerateQRCodeHandler, "/generate-qr-code", url.Values{})
+				return rec.Body.String(), app.Payments.GetStatesByType("qr")[0].GetID()
+			},
+			check:     (*App).checkQRPaymentStatus,
+			broadcast: func(app *App, id string) { app.sendQRSSEUpdate(id, "open") },
+		},
+		{
+			name: "terminal",
+			start: func(app *App) (string, string) {
+				rec := postForm(app.ProcessPaymentHandler, "/process-payment", url.Values{"payment_method": {"terminal"}})
+				return rec.Body.String(), app.Payments.GetStatesByType("terminal")[0].GetID()
+			},
+			check: (*App).checkTerminalPaymentStatus,
+			broadcast: func(app *App, id string) {
+				app.sendTerminalSSEUpdate(id, &stripe.PaymentIntent{ID: id, Status: stripe.PaymentIntentStatusProcessing})
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, _, _ := newTestApp(t)
+			addToCart(app, "Coffee", 4.50)
+
+			body, id := tt.start(app)
+			expectCancelForm(t, "payment container", body, id, tt.name)
+
+			result := tt.check(app, id)
+			if result.ShouldStop {
+				t.Fatalf("status check concluded a pending payment")
+			}
+			expectCancelForm(t, "status check", renderComponent(t, result.Component), id, tt.name)
+
+			stream := httptest.NewRecorder()
+			if app.SSE.AddConnection(id, tt.name, stream) == nil {
+				t.Fatal("update stream not opened")
+			}
+			before := stream.Body.Len()
+			tt.broadcast(app, id)
+			update := stream.Body.String()[before:]
+			if !strings.Contains(update, "event: payment-update") {
+				t.Fatalf("no progress update sent:\n%s", update)
+			}
+			expectCancelForm(t, "SSE update", update, id, tt.name)
+		})
+	}
+}
@@ -2,75 +2,88 @@ package handlers
 
 import (
 	"encoding/base64"
-	"fmt"
 	"net/http"
 
 	"github.com/skip2/go-qrcode"
-	"github.com/stripe/stripe-go/v74"
-	"github.com/stripe/stripe-go/v74/paymentlink"
 
+	"checkout/handlers/htmx"
 	"checkout/services"
 	"checkout/templates/checkout"
 	"checkout/utils"
 )
 
 // GenerateQRCodeHandler handles QR code generation for payment links
-func GenerateQRCodeHandler(w http.ResponseWriter, r *http.Request) {
+func (a *App) GenerateQRCodeHandler(w http.ResponseWriter, r *http.Request) {
+	cart := a.cart(r)
 	// Check if cart is empty first
-	if len(services.AppState.CurrentCart) == 0 {
+	if cart.Len() == 0 {
 		// Send a toast message for empty cart
-		w.Header().Set("HX-Trigger", `{"showToast": {"message": "Cart is empty. Please add items before generating a QR code.", "type": "warning"}}`)
+		setToast(w, "warning", "toast.cart_empty_qr")
 		w.WriteHeader(http.StatusOK) // Changed from BadRequest to OK since this is a valid user action
 		utils.Info("payment", "QR generation rejected - cart empty")
 		return
 	}
 
-	utils.Info("payment", "Starting QR code generation", "cart_items", len(services.AppState.CurrentCart))
-	summary := services.CalculateCartSummary()
+	if rejectNonPositiveTotal(w, cart) {
+		return
+	}
+	if rejectMixedVendors(w, cart) {
+		return
+	}
+
+	cart.SetPaymentMethod("qr")
+	if !a.quoteStripeTax(w, cart) {
+		return
+	}
+
+	// Ask for a tip first when one applies; the tip form continues to the QR code
+	if a.offerTip(w, r, "qr") {
+		return
+	}
+	cart.SetTip(0)
+
+	a.generateQRCode(w, r)
+}
+
+// generateQRCode creates a payment link for the amount due, including any tip, and shows its QR code
+func (a *App) generateQRCode(w http.ResponseWriter, r *http.Request) {
+	cart := a.cart(r)
+	utils.Info("payment", "Starting QR code generation", "cart_items", cart.Len())
+	// Split sales charge only the current tender
+	amount := services.ChargeAmount(cart, services.CalculateCartSummary(cart))
 
 	// Create and configure payment link (no email - receipt will be collected post-payment)
-	paymentLink, err := services.CreatePaymentLink(summary.Total, "")
+	paymentLink, err := services.CreatePaymentLink(a.Stripe, cart, amount, "", services.PaymentLinkKindQR)
 	if err != nil {
-		utils.Error("payment", "Error creating payment link", "amount", summary.Total, "error", err)
+		utils.Error("payment", "Error creating payment link", "amount", amount, "error", err)
 		// Send error via toast message
-		w.Header().Set("HX-Trigger", fmt.Sprintf(`{"showToast": "Error creating payment link: %s"}`, err.Error()))
+		setToast(w, "error", "toast.payment_link_error", err.Error())
 		return
 	}
 
 	// Note: We don't create a transaction record for link creation anymore
 	// The actual payment transaction will be logged when the payment is completed
-	utils.Info("payment", "Payment link created", "payment_link_id", paymentLink.ID, "amount", summary.Total)
+	utils.Info("payment", "Payment link created", "payment_link_id", paymentLink.ID, "amount", amount)
+	services.CancelDeclinedPayment(a.Stripe, cart)
 
 	// Use the payment link URL for the QR code
-	stripePaymentLink := paymentLink.URL
-
-	// Generate the QR code using the go-qrcode library
-	qrCode, err := qrcode.New(stripePaymentLink, qrcode.Medium)
+	qrBase64, err := qrCodeBase64(paymentLink.URL)
 	if err != nil {
 		utils.Error("payment", "Error generating QR code", "payment_link_id", paymentLink.ID, "error", err)
 		// Send error via toast message
-		w.Header().Set("HX-Trigger", `{"showToast": "Error generating QR code"}`)
+		setToast(w, "error", "toast.qr_error")
 		return
 	}
 
-	// Convert QR code to PNG image data
-	qrPNG, err := qrCode.PNG(256)
-	if err != nil {
-		utils.Error("payment", "Error converting QR code to PNG", "payment_link_id", paymentLink.ID, "error", err)
-		// Send error via toast message
-		w.Header().Set("HX-Trigger", `{"showToast": "Error generating QR code image"}`)
-		return
-	}
-
-	// Encode the PNG as base64 for embedding in HTML
-	qrBase64 := base64.StdEncoding.EncodeToString(qrPNG)
+	// Track the payment right away so the customer display can show the same code
+	a.Payments.AddPayment(newQRPaymentState(cart, paymentLink.ID, paymentLink.URL, a.Clock.Now()))
 
 	// Set the HTMX trigger to show modal
-	w.Header().Set("HX-Trigger", "showModal")
+	htmx.ShowModal(w)
 
 	// Use the QRCodeDisplay template to render the QR code in the modal
 	// No email collected pre-payment - receipt will be collected post-payment
-	qrDisplay := checkout.QRCodeDisplay(qrBase64, paymentLink.ID, summary.Total)
+	qrDisplay := checkout.QRCodeDisplay(qrBase64, paymentLink.ID, amount)
 	if err := qrDisplay.Render(r.Context(), w); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -78,7 +91,8 @@ func GenerateQRCodeHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 // CancelTransactionHandler handles cancelling the entire transaction and resetting state
-func CancelTransactionHandler(w http.ResponseWriter, r *http.Request) {
+func (a *App) CancelTransactionHandler(w http.ResponseWriter, r *http.Request) {
+	cart := a.cart(r)
 	if err := r.ParseForm(); err != nil {
 		http.Error(w, "Error parsing form", http.StatusBadRequest)
 		return
@@ -88,7 +102,7 @@ func CancelTransactionHandler(w http.ResponseWriter, r *http.Request) {
 
 	// If we have a payment link ID, deactivate it in Stripe
 	if paymentLinkID != "" {
-		_, err := paymentlink.Update(paymentLinkID, &stripe.PaymentLinkParams{Active: stripe.Bool(false)})
+		_, err := a.Stripe.DeactivatePaymentLink(paymentLinkID)
 		if err != nil {
 			utils.Error("payment", "Error cancelling payment link during transaction cancellation", "payment_link_id", paymentLinkID, "error", err)
 			// Continue anyway - we still want to clear local state
@@ -97,17 +111,42 @@ func CancelTransactionHandler(w http.ResponseWriter, r *http.Request) {
 		}
 
 		// Log the cancellation using the unified event logger
-		_ = GlobalPaymentEventLogger.LogPaymentEventQuick(paymentLinkID, PaymentEventCancelled, "qr")
+		_ = a.Events.LogPaymentEventQuick(paymentLinkID, PaymentEventCancelled, "qr")
+	}
+
+	// Cancelling one tender of a split sale goes back to the split form; the captured tenders and cart stay
+	if cart.Split() != nil {
+		a.Payments.RemovePayment(paymentLinkID)
+		cart.SetSplitPending(0)
+		setToast(w, "warning", "toast.qr_cancelled")
+		if err := renderModal(w, r, splitPaymentForm(cart)); err != nil {
+			utils.Error("payment", "Error rendering split payment form", "error", err)
+		}
+		return
 	}
 
 	// Clear all payment states and cart using unified state manager
-	GlobalPaymentStateManager.ClearAllAndClearCart()
+	a.Payments.ClearCartAndPayments(cart)
 
 	utils.Info("payment", "Transaction cancelled - cart and payment states cleared")
 
 	// Close modal and show success toast
 	w.Header().Set("Content-Type", "text/html")
-	w.Header().Set("HX-Trigger", `{"closeModal": true, "showToast": {"message": "Transaction cancelled - cart cleared", "type": "success"}, "cartUpdated": true}`)
+	setToast(w, "success", "toast.transaction_cancelled")
+	htmx.Trigger(w, "closeModal", "cartUpdated")
 	w.WriteHeader(http.StatusOK)
 	_, _ = w.Write([]byte("")) // Empty response since we're just triggering events
 }
+
+// qrCodeBase64 encodes a URL as a QR code PNG in base64, for embedding in HTML
+func qrCodeBase64(url string) (string, error) {
+	qrCode, err := qrcode.New(url, qrcode.Medium)
+	if err != nil {
+		return "", err
+	}
+	qrPNG, err := qrCode.PNG(256)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(qrPNG), nil
+}
@@ -0,0 +1,155 @@
+package handlers
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"checkout/config"
+	"checkout/services"
+	"checkout/services/stripetest"
+)
+
+// useGlobalStripe replaces the package-level client for the test, so it can show it isn't called
+func useGlobalStripe(t *testing.T) *stripetest.Client {
+	t.Helper()
+	global := stripetest.New()
+	saved := services.Stripe
+	services.Stripe = global
+	t.Cleanup(func() { services.Stripe = saved })
+	return global
+}
+
+func TestGenerateQRCodeUsesAppClient(t *testing.T) {
+	app, fake, _ := newTestApp(t)
+	global := useGlobalStripe(t)
+	addToCart(app, "Coffee", 4.50)
+
+	rec := postForm(app.GenerateQRCodeHandler, "/generate-qr-code", url.Values{})
+
+	if got := fake.Calls("CreatePaymentLink"); got != 1 {
+		t.Fatalf("App client CreatePaymentLink calls = %d, want 1", got)
+	}
+	if got := global.Calls("CreatePaymentLink") + global.Calls("GetPaymentIntent"); got != 0 {
+		t.Errorf("package-level client was called %d times", got)
+	}
+	states := app.Payments.GetStatesByType("qr")
+	if len(states) != 1 {
+		t.Fatalf("QR payment states = %d, want 1", len(states))
+	}
+	if amount := fake.LinkAmount(states[0].GetID()); amount != 450 {
+		t.Errorf("link amount = %d cents, want 450", amount)
+	}
+	if body := rec.Body.String(); !strings.Contains(body, `id="qr-payment-container"`) || !strings.Contains(body, states[0].GetID()) {
+		t.Errorf("response isn't the QR code of the link:\n%s", body)
+	}
+}
+
+func TestTerminalPaymentUsesAppClient(t *testing.T) {
+	app, fake, _ := newTestApp(t)
+	global := useGlobalStripe(t)
+	addToCart(app, "Coffee", 4.50)
+
+	postForm(app.ProcessPaymentHandler, "/process-payment", url.Values{"payment_method": {"terminal"}})
+
+	if got := fake.Calls("ProcessReaderPayment"); got != 1 {
+		t.Fatalf("App client ProcessReaderPayment calls = %d, want 1", got)
+	}
+	states := app.Payments.GetStatesByType("terminal")
+	if len(states) != 1 {
+		t.Fatalf("terminal payment states = %d, want 1", len(states))
+	}
+
+	result := app.checkTerminalPaymentStatus(states[0].GetID())
+	if result.ShouldStop {
+		t.Errorf("payment completed before the reader did")
+	}
+	if fake.Calls("GetPaymentIntent") == 0 {
+		t.Errorf("status check didn't ask the App client for the intent")
+	}
+	if got := global.Calls("GetPaymentIntent") + global.Calls("GetReader"); got != 0 {
+		t.Errorf("package-level client was called %d times", got)
+	}
+}
+
+// stripeReads is how many times the fake was asked about payment links and their sessions
+func stripeReads(fake *stripetest.Client) int {
+	return fake.Calls("GetPaymentLink") + fake.Calls("ListCheckoutSessions")
+}
+
+// Each check of an unpaid link costs a look at the link and one page of its sessions. Once the
+// link is paid, or has expired and been deactivated, checks stop calling Stripe.
+func TestQRStatusChecksStopCallingStripe(t *testing.T) {
+	tests := []struct {
+		name   string
+		finish func(app *App, fake *stripetest.Client, clock *fakeClock, linkID string)
+	}{
+		{"paid", func(app *App, fake *stripetest.Client, _ *fakeClock, linkID string) {
+			fake.CompleteLink(linkID, "customer@example.com")
+		}},
+		{"expired", func(app *App, _ *stripetest.Client, clock *fakeClock, _ string) {
+			clock.Advance(config.PaymentTimeout + time.Second)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, fake, clock := newTestApp(t)
+			addToCart(app, "Coffee", 4.50)
+			postForm(app.GenerateQRCodeHandler, "/generate-qr-code", url.Values{})
+			linkID := app.Payments.GetStatesByType("qr")[0].GetID()
+
+			for poll := 1; poll <= 3; poll++ {
+				if result := app.checkQRPaymentStatus(linkID); result.ShouldStop {
+					t.Fatalf("poll %d stopped before the link was paid", poll)
+				}
+				if got := fake.Calls("ListCheckoutSessions"); got != poll {
+					t.Errorf("poll %d: listed sessions %d times, want once per poll", poll, got)
+				}
+			}
+
+			tt.finish(app, fake, clock, linkID)
+			if result := app.checkQRPaymentStatus(linkID); !result.ShouldStop {
+				t.Fatalf("payment not concluded")
+			}
+			concluded := stripeReads(fake)
+
+			for range 5 {
+				if result := app.checkQRPaymentStatus(linkID); !result.ShouldStop {
+					t.Errorf("check after the payment concluded kept polling")
+				}
+			}
+			if got := stripeReads(fake) - concluded; got != 0 {
+				t.Errorf("%d Stripe calls after the payment concluded, want none", got)
+			}
+		})
+	}
+}
+
+// In webhook mode the completion comes from the webhook cache without listing sessions at all
+func TestQRStatusCheckUsesWebhookCompletion(t *testing.T) {
+	app, fake, _ := newTestApp(t)
+	config.Config.WebsiteName = "pos.example.com"
+	addToCart(app, "Coffee", 4.50)
+	postForm(app.GenerateQRCodeHandler, "/generate-qr-code", url.Values{})
+	linkID := app.Payments.GetStatesByType("qr")[0].GetID()
+	started := stripeReads(fake)
+
+	for range 3 {
+		app.checkQRPaymentStatus(linkID)
+	}
+	if got := stripeReads(fake) - started; got != 0 {
+		t.Errorf("%d Stripe calls while waiting for the webhook, want none", got)
+	}
+
+	// Recording the sale looks up the card once; checks after that don't call Stripe
+	app.cachePaymentLinkCompletion(linkID, services.PaymentLinkStatus{Completed: true, SessionID: "cs_paid"})
+	if result := app.checkQRPaymentStatus(linkID); !result.ShouldStop {
+		t.Fatalf("cached completion didn't conclude the payment")
+	}
+	concluded := stripeReads(fake)
+	app.checkQRPaymentStatus(linkID)
+	if got := stripeReads(fake) - concluded; got != 0 {
+		t.Errorf("%d Stripe calls after the payment concluded, want none", got)
+	}
+}
@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"checkout/i18n"
+	"checkout/services/validation"
+	"checkout/templates"
+	"checkout/templates/pos"
+	"checkout/utils"
+)
+
+// quickChargeDefaultName is used in the transaction log when no description is entered
+const quickChargeDefaultName = "Quick Sale"
+
+// QuickChargeHandler charges an entered amount without building a cart.
+// GET shows the quick charge form; POST creates a single ad-hoc line item
+// and starts the chosen payment method with it.
+func (a *App) QuickChargeHandler(w http.ResponseWriter, r *http.Request) {
+	cart := a.cart(r)
+	if r.Method == http.MethodGet {
+		if err := renderInfoModal(w, r, pos.QuickChargeModal(a.Config.QuickChargeMaxAmount)); err != nil {
+			utils.Error("payment", "Error rendering quick charge modal", "error", err)
+		}
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Error parsing form", http.StatusBadRequest)
+		return
+	}
+
+	// Quick charges replace the cart, so never discard items the cashier already rang up
+	if cart.Len() > 0 {
+		setToast(w, "warning", "toast.quick_charge_cart_not_empty")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	maxAmount := a.Config.QuickChargeMaxAmount
+	amount, err := validation.Price(r.FormValue("amount"), maxAmount)
+	var invalid *validation.Error
+	if errors.As(err, &invalid) && invalid.Key == "validation.price_too_large" {
+		utils.Warn("payment", "Quick charge rejected - over limit", "amount", r.FormValue("amount"), "max_amount", maxAmount)
+		setToast(w, "warning", "toast.quick_charge_limit", i18n.Money(maxAmount))
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if err != nil {
+		setToastText(w, "warning", err.Error())
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if amount == 0 {
+		setToast(w, "warning", "toast.amount_positive")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	name := strings.TrimSpace(r.FormValue("description"))
+	if name == "" {
+		name = quickChargeDefaultName
+	}
+
+	// Single ad-hoc line item; without a tax category it is taxed at the default rate
+	cart.Replace([]templates.Product{{
+		ID:    fmt.Sprintf("custom-%d", time.Now().UnixNano()),
+		Name:  name,
+		Price: amount,
+	}})
+
+	paymentMethod := r.FormValue("payment_method")
+	cart.SetPaymentMethod(paymentMethod)
+	utils.Info("payment", "Starting quick charge", "amount", amount, "description", name, "payment_method", paymentMethod)
+
+	// Hand off to the regular payment flows, which record the transaction as usual
+	switch paymentMethod {
+	case "terminal":
+		a.ProcessPaymentHandler(w, r)
+	case "qr":
+		// The quick charge form doesn't target the modal, so point the QR display at it
+		w.Header().Set("HX-Retarget", "#modal-content")
+		w.Header().Set("HX-Reswap", "innerHTML")
+		a.GenerateQRCodeHandler(w, r)
+	case "manual":
+		renderManualCardForm(w, r)
+	default:
+		cart.Clear()
+		setToast(w, "error", "toast.invalid_payment_method")
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
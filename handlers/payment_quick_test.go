@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestQuickChargeRejectsInvalidAmounts(t *testing.T) {
+	tests := []struct {
+		name      string
+		amount    string
+		wantToast string
+	}{
+		{"NaN", "NaN", "NaN"},
+		{"infinity", "Inf", "Inf"},
+		{"over the limit", "150", "limited to $100.00"},
+		{"zero", "0", "greater than zero"},
+		{"fraction of a cent", "12.345", "2 decimal places"},
+		{"empty", "", "Enter a price"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, fake, _ := newTestApp(t)
+			app.Config.QuickChargeMaxAmount = 100
+
+			rec := postForm(app.QuickChargeHandler, "/quick-charge", url.Values{
+				"amount": {tt.amount}, "payment_method": {"terminal"},
+			})
+
+			if items := sessionCart(app).Items(); len(items) != 0 {
+				t.Errorf("cart = %v, want nothing rung up", items)
+			}
+			if calls := fake.Calls("CreatePaymentIntent"); calls != 0 {
+				t.Errorf("%d PaymentIntents created, want none", calls)
+			}
+			if toast := toastMessage(rec); toast == "" || !strings.Contains(toast, tt.wantToast) {
+				t.Errorf("toast = %q, want one mentioning %q", toast, tt.wantToast)
+			}
+		})
+	}
+}
+
+func TestQuickChargeRingsUpAmount(t *testing.T) {
+	app, _, _ := newTestApp(t)
+	app.Config.QuickChargeMaxAmount = 100
+
+	postForm(app.QuickChargeHandler, "/quick-charge", url.Values{
+		"amount": {"99.99"}, "description": {"Deposit"}, "payment_method": {"manual"},
+	})
+
+	items := sessionCart(app).Items()
+	if len(items) != 1 || items[0].Name != "Deposit" || items[0].Price != 99.99 {
+		t.Errorf("cart = %v, want the $99.99 deposit", items)
+	}
+}
@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"net/http"
+
+	"checkout/services"
+	"checkout/templates/checkout"
+	"checkout/utils"
+)
+
+// resumablePayment returns the payment in flight for a session's cart, if any. A payment taken
+// for a cart that has since changed is left to expire rather than offered again.
+func (a *App) resumablePayment(cart *services.CartStore) (PaymentState, bool) {
+	state, ok := a.Payments.NewestFor(cart)
+	if !ok || a.Payments.IsConcluded(state.GetID()) {
+		return nil, false
+	}
+	if state.GetCartHash() != services.CartHash(cart.Items()) {
+		return nil, false
+	}
+	return state, true
+}
+
+// ResumePaymentHandler shows the progress modal of the payment in flight again, with its live
+// status updates, after the page was refreshed or the modal closed while the customer was paying
+func (a *App) ResumePaymentHandler(w http.ResponseWriter, r *http.Request) {
+	cart := a.cart(r)
+	state, ok := a.resumablePayment(cart)
+	if !ok {
+		setToast(w, "warning", "toast.no_payment_in_progress")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	amount := services.ChargeAmount(cart, services.CalculateCartSummary(cart))
+	utils.Info("payment", "Resuming payment progress", "payment_id", state.GetID(), "payment_type", state.GetPaymentType())
+
+	switch state := state.(type) {
+	case *QRPaymentState:
+		url := state.URL
+		if url == "" {
+			// States rebuilt from a status check don't know the link's URL
+			paymentLink, err := a.Stripe.GetPaymentLink(state.PaymentLinkID)
+			if err != nil {
+				utils.Error("payment", "Error retrieving payment link to resume", "payment_link_id", state.PaymentLinkID, "error", err)
+				setToast(w, "error", "toast.qr_error")
+				w.WriteHeader(http.StatusOK)
+				return
+			}
+			url = paymentLink.URL
+		}
+		qrBase64, err := qrCodeBase64(url)
+		if err != nil {
+			utils.Error("payment", "Error generating QR code to resume", "payment_link_id", state.PaymentLinkID, "error", err)
+			setToast(w, "error", "toast.qr_error")
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		if err := renderInfoModal(w, r, checkout.QRCodeDisplay(qrBase64, state.PaymentLinkID, amount)); err != nil {
+			utils.Error("payment", "Error rendering resumed QR payment", "payment_link_id", state.PaymentLinkID, "error", err)
+		}
+
+	case *TerminalPaymentState:
+		component := checkout.TerminalPaymentContainer(state.PaymentIntentID, state.ReaderID, amount, state.Email)
+		if err := renderInfoModal(w, r, component); err != nil {
+			utils.Error("payment", "Error rendering resumed terminal payment", "intent_id", state.PaymentIntentID, "error", err)
+		}
+	}
+}
@@ -0,0 +1,226 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/a-h/templ"
+
+	"checkout/config"
+	"checkout/handlers/htmx"
+	"checkout/i18n"
+	"checkout/services"
+	"checkout/services/validation"
+	"checkout/templates"
+	"checkout/templates/checkout"
+	"checkout/utils"
+)
+
+// SplitPaymentHandler pays the cart with more than one payment method.
+// GET shows the split form with the tenders taken so far; POST charges one tender
+// for the entered amount with the chosen method.
+func (a *App) SplitPaymentHandler(w http.ResponseWriter, r *http.Request) {
+	cart := a.cart(r)
+	if cart.Len() == 0 {
+		setToast(w, "warning", "toast.cart_empty")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if rejectNonPositiveTotal(w, cart) {
+		return
+	}
+
+	// The split balance includes the tax, so it is quoted before the first tender
+	if !a.quoteStripeTax(w, cart) {
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		if err := renderInfoModal(w, r, splitPaymentForm(cart)); err != nil {
+			utils.Error("payment", "Error rendering split payment form", "error", err)
+		}
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Error parsing form", http.StatusBadRequest)
+		return
+	}
+
+	total := services.CartTotalWithoutFee(cart)
+	remaining := total
+	if split := cart.Split(); split != nil {
+		remaining = split.Remaining(total)
+	}
+
+	// NaN would pass both range checks below and corrupt the split's remaining amount
+	amount, err := validation.Price(r.FormValue("amount"), 0)
+	if err != nil {
+		setToastText(w, "warning", err.Error())
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if amount == 0 {
+		setToast(w, "warning", "toast.amount_positive")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if amount > remaining+0.005 {
+		setToast(w, "warning", "toast.amount_over_remaining", i18n.Money(remaining))
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	split := services.StartSplitPayment(cart)
+	cart.SetSplitPending(amount)
+
+	paymentMethod := r.FormValue("payment_method")
+	cart.SetPaymentMethod(paymentMethod)
+	utils.Info("payment", "Starting split tender", "confirmation_code", split.ConfirmationCode, "amount", amount, "remaining", remaining, "payment_method", paymentMethod)
+
+	// Card tenders go through the regular payment flows, which charge services.ChargeAmount
+	switch paymentMethod {
+	case "terminal":
+		a.ProcessPaymentHandler(w, r)
+	case "qr":
+		w.Header().Set("HX-Retarget", "#modal-content")
+		w.Header().Set("HX-Reswap", "innerHTML")
+		a.GenerateQRCodeHandler(w, r)
+	case "manual":
+		renderManualCardForm(w, r)
+	case services.CashPaymentMethod:
+		component, _ := a.completeSplitTender(cart, services.NewPaymentID(), services.CashPaymentMethod)
+		if err := renderModal(w, r, component, "cartUpdated"); err != nil {
+			utils.Error("payment", "Error rendering split payment result", "error", err)
+		}
+	default:
+		cart.SetSplitPending(0)
+		setToast(w, "error", "toast.invalid_payment_method")
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+// CancelSplitPaymentHandler abandons a split sale. If tenders were already captured the cashier
+// is shown exactly which ones and can refund them; the cart is kept either way.
+func (a *App) CancelSplitPaymentHandler(w http.ResponseWriter, r *http.Request) {
+	cart := a.cart(r)
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Error parsing form", http.StatusBadRequest)
+		return
+	}
+
+	split := cart.Split()
+	if split == nil || len(split.Tenders) == 0 {
+		cart.ClearSplit()
+		setToast(w, "success", "toast.split_cancelled")
+		htmx.Trigger(w, "closeModal", "cartUpdated")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.FormValue("refund") != "true" {
+		if err := renderInfoModal(w, r, checkout.SplitCancelConfirm(split.Tenders)); err != nil {
+			utils.Error("payment", "Error rendering split cancel confirmation", "error", err)
+		}
+		return
+	}
+
+	reversal, failed := services.VoidSplitTenders(a.Stripe, split.ConfirmationCode, split.Tenders, "split payment cancelled", cart.Cashier())
+	utils.Info("audit", "Split payment cancelled", "confirmation_code", split.ConfirmationCode, "reversal", reversal, "failed_tenders", len(failed), "user", currentUsername(r))
+
+	if len(failed) > 0 {
+		// Keep only what is still captured so the cashier can retry or refund it in Stripe
+		cart.SetSplitTenders(failed)
+		w.Header().Set("HX-Retarget", "#modal-content")
+		w.Header().Set("HX-Reswap", "innerHTML")
+		setToast(w, "error", "toast.split_refunds_failed", len(failed))
+		htmx.Trigger(w, "showModal", "cartUpdated")
+		w.WriteHeader(http.StatusOK)
+		if err := checkout.SplitCancelConfirm(failed).Render(r.Context(), w); err != nil {
+			utils.Error("payment", "Error rendering split cancel confirmation", "error", err)
+		}
+		return
+	}
+
+	cart.ClearSplit()
+	setToast(w, "success", "toast.split_cancelled_with", reversal)
+	htmx.Trigger(w, "closeModal", "cartUpdated")
+	w.WriteHeader(http.StatusOK)
+}
+
+// completeSplitTender records a captured payment toward the split sale in progress.
+// It returns the modal to show next: the split form while a balance remains, or the
+// success modal once the cart is paid in full. ok is false when no split is in progress.
+func (a *App) completeSplitTender(cart *services.CartStore, paymentID, paymentMethod string) (component templ.Component, ok bool) {
+	split := cart.Split()
+	if split == nil {
+		return nil, false
+	}
+
+	summary := services.CalculateCartSummary(cart)
+	tender := templates.Tender{
+		PaymentID: paymentID,
+		Method:    paymentMethod,
+		Amount:    split.AmountDue(summary.Total),
+	}
+	tender.ServiceFee = services.ServiceFeeFor(tender.Amount, paymentMethod)
+	if paymentMethod != services.CashPaymentMethod && paymentMethod != services.GiftCardPaymentMethod {
+		card, err := services.GetPaymentCardDetails(a.Stripe, paymentID)
+		if err != nil {
+			utils.Warn("payment", "Could not look up card details for split tender", "payment_id", paymentID, "error", err)
+		}
+		tender.CardBrand, tender.CardLast4, tender.ReceiptURL = card.Brand, card.Last4, card.ReceiptURL
+		tender.PayoutAccount = card.PayoutAccount
+	}
+
+	a.Events.recordMetrics(paymentID, PaymentEventSuccess, paymentMethod)
+	if err := services.RecordSplitTender(cart, tender); err != nil {
+		utils.Error("payment", "Error saving split tender", "confirmation_code", split.ConfirmationCode, "payment_id", paymentID, "error", err)
+	}
+	if recorded := cart.Split(); recorded != nil {
+		split = recorded
+	}
+
+	if split.Remaining(summary.Total) > 0 {
+		return splitPaymentForm(cart), true
+	}
+
+	// Paid in full: log the line items under the split's confirmation code and finish the sale,
+	// with the service fees charged on its tenders
+	summary.ServiceFee = split.ServiceFees()
+	summary.Total += summary.ServiceFee
+	_ = a.Events.LogPaymentEvent(
+		cart,
+		split.ConfirmationCode,
+		PaymentEventSuccess,
+		services.SplitPaymentMethod,
+		cart.Items(),
+		summary,
+		"",
+	)
+	utils.Info("payment", "Split payment completed", "confirmation_code", split.ConfirmationCode, "tenders", len(split.Tenders), "total", summary.Total)
+
+	cart.ClearSplit()
+	cart.Clear()
+	return checkout.PaymentSuccess(split.ConfirmationCode, config.GetCheckoutFlow()), true
+}
+
+// splitPaymentForm builds the split form for the current cart and split state
+func splitPaymentForm(cart *services.CartStore) templ.Component {
+	total := services.CartTotalWithoutFee(cart)
+	split := cart.Split()
+	if split == nil {
+		return checkout.SplitPaymentForm(nil, total, total)
+	}
+	return checkout.SplitPaymentForm(split.Tenders, total, split.Remaining(total))
+}
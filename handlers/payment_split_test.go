@@ -0,0 +1,41 @@
+package handlers
+
+import (
+	"net/url"
+	"testing"
+)
+
+// A tender that isn't a positive number of cents no greater than the balance starts no split
+func TestSplitPaymentRejectsInvalidTenders(t *testing.T) {
+	tests := []struct {
+		name   string
+		amount string
+	}{
+		{"NaN", "NaN"},
+		{"infinity", "Inf"},
+		{"negative infinity", "-Inf"},
+		{"zero", "0"},
+		{"fraction of a cent", "1.005"},
+		{"over the balance", "100"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, fake, _ := newTestApp(t)
+			addToCart(app, "Coffee", 4.50)
+
+			rec := postForm(app.SplitPaymentHandler, "/split-payment", url.Values{
+				"amount": {tt.amount}, "payment_method": {"terminal"},
+			})
+
+			if split := sessionCart(app).Split(); split != nil {
+				t.Errorf("split started: %+v", split)
+			}
+			if calls := fake.Calls("CreatePaymentIntent"); calls != 0 {
+				t.Errorf("%d PaymentIntents created, want none", calls)
+			}
+			if toastMessage(rec) == "" {
+				t.Errorf("no warning for tender %q", tt.amount)
+			}
+		})
+	}
+}
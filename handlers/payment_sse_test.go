@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"checkout/config"
+)
+
+// openPaymentEvents opens /payment-events as the payment modal does and returns what it sent,
+// failing if the stream is still open after a few seconds
+func openPaymentEvents(t *testing.T, app *App, paymentID, paymentType string) string {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/payment-events?payment_id="+paymentID+"&type="+paymentType, nil)
+	rec := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		app.PaymentSSEHandler(rec, req)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("stream still open after the payment concluded")
+	}
+	return rec.Body.String()
+}
+
+// queuedUpdate returns the update waiting for a payment's browser to connect
+func queuedUpdate(app *App, paymentID string) (pendingSSEUpdate, bool) {
+	app.SSE.mutex.RLock()
+	defer app.SSE.mutex.RUnlock()
+	update, found := app.SSE.pending[paymentID]
+	return update, found
+}
+
+// A webhook can beat the browser to /payment-events; the result is queued and sent as soon as the
+// modal connects, whichever way the POS learns of payments
+func TestQueuedResultSentOnConnect(t *testing.T) {
+	strategies := []struct {
+		name        string
+		websiteName string
+	}{
+		{"polling", ""},
+		{"webhooks", "pos.example.com"},
+	}
+	payments := []struct {
+		name    string
+		pending pendingPayment
+		webhook func(t *testing.T, app *App, id string)
+	}{
+		{"qr", pendingPayments[0], func(t *testing.T, app *App, id string) {
+			postWebhook(t, app, "checkout.session.completed", checkoutSessionFixture(t, id))
+		}},
+		{"terminal", pendingPayments[1], func(t *testing.T, app *App, id string) {
+			postWebhook(t, app, "payment_intent.succeeded", paymentIntent(id, "succeeded"))
+		}},
+	}
+	for _, strategy := range strategies {
+		for _, payment := range payments {
+			t.Run(strategy.name+"/"+payment.name, func(t *testing.T) {
+				app, fake, _ := newTestApp(t)
+				config.Config.WebsiteName = strategy.websiteName
+				if got := config.GetCommunicationStrategy(); got != strategy.name {
+					t.Fatalf("strategy = %s, want %s", got, strategy.name)
+				}
+				addToCart(app, "Coffee", 4.50)
+				id := payment.pending.start(t, app)
+				payment.pending.succeed(fake, id)
+
+				payment.webhook(t, app, id)
+				queued, found := queuedUpdate(app, id)
+				if !found || queued.event != "modal-update" {
+					t.Fatalf("queued %+v, want the result waiting for the modal", queued)
+				}
+				body := openPaymentEvents(t, app, id, payment.name)
+
+				if n := strings.Count(body, "event: modal-update"); n != 1 {
+					t.Errorf("sent %d modal updates, want the queued result once:\n%s", n, body)
+				}
+				if !strings.Contains(body, `class="payment-success"`) {
+					t.Errorf("stream is missing the success modal:\n%s", body)
+				}
+				for _, line := range strings.Split(strings.TrimSpace(queued.html), "\n") {
+					if !strings.Contains(body, "data: "+line) {
+						t.Errorf("stream didn't send the queued result's line %q", line)
+						break
+					}
+				}
+				if _, found := queuedUpdate(app, id); found {
+					t.Errorf("result still queued after the modal connected")
+				}
+				if app.SSE.Connected(id) {
+					t.Errorf("connection still registered after the stream closed")
+				}
+				if rows := transactionRows(t, id); rows != 1 {
+					t.Errorf("sale written %d times, want once", rows)
+				}
+			})
+		}
+	}
+}
@@ -15,28 +15,93 @@ type PaymentState interface {
 	GetID() string
 	GetPaymentType() string
 	GetStartTime() time.Time
-	IsExpired(timeout time.Duration) bool
+	IsExpired(now time.Time, timeout time.Duration) bool
 	GetMetadata() map[string]interface{}
+	GetCartHash() string // Fingerprint of the cart the payment was started for (services.CartHash)
+	// Cart of the session the payment was started from, cleared when it completes; nil when not known
+	GetCartStore() *services.CartStore
 }
 
 // PaymentStateManager manages all payment states
 type PaymentStateManager struct {
-	states map[string]PaymentState
-	mutex  sync.RWMutex
+	states    map[string]PaymentState
+	concluded map[string]time.Time // Payments whose outcome was recorded, and when
+	onChange  func()               // Called after payments start or end (see OnChange)
+	clock     Clock                // Time payments expire and claims are dropped by
+	mutex     sync.RWMutex
 }
 
-// NewPaymentStateManager creates a new payment state manager
-func NewPaymentStateManager() *PaymentStateManager {
+// NewPaymentStateManager creates a new payment state manager whose payments expire by the given clock
+func NewPaymentStateManager(clock Clock) *PaymentStateManager {
 	return &PaymentStateManager{
-		states: make(map[string]PaymentState),
+		states:    make(map[string]PaymentState),
+		concluded: make(map[string]time.Time),
+		clock:     clock,
 	}
 }
 
+// OnChange registers fn to be called after a payment is added or removed. fn must not block.
+func (psm *PaymentStateManager) OnChange(fn func()) {
+	psm.mutex.Lock()
+	defer psm.mutex.Unlock()
+	psm.onChange = fn
+}
+
+// changed calls the OnChange function; mutators defer it before taking the lock so it runs
+// after the lock is released
+func (psm *PaymentStateManager) changed() {
+	psm.mutex.RLock()
+	fn := psm.onChange
+	psm.mutex.RUnlock()
+	if fn != nil {
+		fn()
+	}
+}
+
+// Conclude claims the recording of a payment's final outcome. A webhook and the status checks
+// can see the same result at the same time, so only the first caller gets true and writes the
+// transaction; the others leave it alone. Claims are kept for twice the payment timeout, longer
+// than any path can still be checking the payment.
+func (psm *PaymentStateManager) Conclude(id string) bool {
+	psm.mutex.Lock()
+	defer psm.mutex.Unlock()
+
+	now := psm.clock.Now()
+	for concludedID, at := range psm.concluded {
+		if now.Sub(at) > 2*config.PaymentTimeout {
+			delete(psm.concluded, concludedID)
+		}
+	}
+
+	if _, done := psm.concluded[id]; done {
+		return false
+	}
+	psm.concluded[id] = now
+	return true
+}
+
+// IsConcluded reports whether a payment's final outcome has been recorded
+func (psm *PaymentStateManager) IsConcluded(id string) bool {
+	psm.mutex.RLock()
+	defer psm.mutex.RUnlock()
+	_, done := psm.concluded[id]
+	return done
+}
+
+// Reopen forgets a declined payment's outcome, so a retry on the same PaymentIntent can conclude
+func (psm *PaymentStateManager) Reopen(id string) {
+	psm.mutex.Lock()
+	defer psm.mutex.Unlock()
+	delete(psm.concluded, id)
+}
+
 // AddPayment adds a payment state to the manager
 func (psm *PaymentStateManager) AddPayment(state PaymentState) {
+	defer psm.changed()
 	psm.mutex.Lock()
 	defer psm.mutex.Unlock()
 	psm.states[state.GetID()] = state
+	services.RecordPaymentStarted(state.GetPaymentType())
 }
 
 // GetPayment retrieves a payment state by ID
@@ -49,6 +114,7 @@ func (psm *PaymentStateManager) GetPayment(id string) (PaymentState, bool) {
 
 // RemovePayment removes a payment state by ID
 func (psm *PaymentStateManager) RemovePayment(id string) {
+	defer psm.changed()
 	psm.mutex.Lock()
 	defer psm.mutex.Unlock()
 	delete(psm.states, id)
@@ -56,11 +122,13 @@ func (psm *PaymentStateManager) RemovePayment(id string) {
 
 // CleanupExpired removes all expired payment states
 func (psm *PaymentStateManager) CleanupExpired() {
+	defer psm.changed()
 	psm.mutex.Lock()
 	defer psm.mutex.Unlock()
+	now := psm.clock.Now()
 	for id, state := range psm.states {
 		// Use consistent timeout for all payment types
-		if state.IsExpired(config.PaymentTimeout) {
+		if state.IsExpired(now, config.PaymentTimeout) {
 			delete(psm.states, id)
 		}
 	}
@@ -73,6 +141,19 @@ func (psm *PaymentStateManager) GetActiveCount() int {
 	return len(psm.states)
 }
 
+// ActiveCountFor returns the number of active payments started from a cart
+func (psm *PaymentStateManager) ActiveCountFor(cart *services.CartStore) int {
+	psm.mutex.RLock()
+	defer psm.mutex.RUnlock()
+	count := 0
+	for _, state := range psm.states {
+		if state.GetCartStore() == cart {
+			count++
+		}
+	}
+	return count
+}
+
 // GetActiveCountByType returns counts by payment type
 func (psm *PaymentStateManager) GetActiveCountByType() (int, int) {
 	psm.mutex.RLock()
@@ -105,71 +186,164 @@ func (psm *PaymentStateManager) GetStatesByType(paymentType string) []PaymentSta
 	return states
 }
 
+// Newest returns the most recently started payment, or false when none is in flight
+func (psm *PaymentStateManager) Newest() (PaymentState, bool) {
+	psm.mutex.RLock()
+	defer psm.mutex.RUnlock()
+
+	var newest PaymentState
+	for _, state := range psm.states {
+		if newest == nil || state.GetStartTime().After(newest.GetStartTime()) {
+			newest = state
+		}
+	}
+	return newest, newest != nil
+}
+
+// NewestFor returns the most recently started payment of a cart, or false when none is in flight
+func (psm *PaymentStateManager) NewestFor(cart *services.CartStore) (PaymentState, bool) {
+	psm.mutex.RLock()
+	defer psm.mutex.RUnlock()
+
+	var newest PaymentState
+	for _, state := range psm.states {
+		if state.GetCartStore() != cart {
+			continue
+		}
+		if newest == nil || state.GetStartTime().After(newest.GetStartTime()) {
+			newest = state
+		}
+	}
+	return newest, newest != nil
+}
+
+// TerminalPaymentsForReader returns the terminal payments waiting on a reader
+func (psm *PaymentStateManager) TerminalPaymentsForReader(readerID string) []*TerminalPaymentState {
+	psm.mutex.RLock()
+	defer psm.mutex.RUnlock()
+
+	var states []*TerminalPaymentState
+	for _, state := range psm.states {
+		if terminalState, ok := state.(*TerminalPaymentState); ok && terminalState.ReaderID == readerID {
+			states = append(states, terminalState)
+		}
+	}
+	return states
+}
+
 // ClearAll removes all payment states
 func (psm *PaymentStateManager) ClearAll() {
+	defer psm.changed()
 	psm.mutex.Lock()
 	defer psm.mutex.Unlock()
 	psm.states = make(map[string]PaymentState)
 }
 
-// RemovePaymentAndClearCart removes a payment state and clears the cart in one operation
-// This replaces the common pattern of: RemovePayment() + services.ClearPaymentState()
+// RemovePaymentAndClearCart removes the state of a completed payment and clears the cart it paid for.
+// The cart is only cleared while it still matches the one the payment was started for: a cashier
+// who began the next sale while a slow payment was pending keeps the new cart. Failed and expired
+// payments use RemovePayment instead, so the same cart can be retried.
 func (psm *PaymentStateManager) RemovePaymentAndClearCart(id string) {
+	defer psm.changed()
 	psm.mutex.Lock()
 	defer psm.mutex.Unlock()
 
-	// DEBUG: Log cart state before clearing
-	utils.Debug("payment", "RemovePaymentAndClearCart called", "payment_id", id, "cart_items_before", len(services.AppState.CurrentCart))
-
 	// Remove the payment state
+	state, exists := psm.states[id]
 	delete(psm.states, id)
 
-	// Clear the cart since the transaction is complete/cancelled
-	services.AppState.CurrentCart = []templates.Product{}
+	var cart *services.CartStore
+	if exists {
+		cart = state.GetCartStore()
+	}
+	if cart == nil {
+		utils.Warn("payment", "Completed payment has no cart to clear", "payment_id", id, "state_found", exists)
+		return
+	}
+
+	// Clear the cart since the transaction is complete, unless it is no longer the cart that was paid for
+	if !cart.ClearIfHash(state.GetCartHash()) {
+		utils.Warn("payment", "Cart changed while the payment was pending, leaving it in place",
+			"payment_id", id, "cart_items", cart.Len())
+		return
+	}
 
 	// DEBUG: Log cart state after clearing
-	utils.Debug("payment", "Removed payment state and cleared cart", "payment_id", id, "cart_items_after", len(services.AppState.CurrentCart))
+	utils.Debug("payment", "Removed payment state and cleared cart", "payment_id", id, "cart_items_after", cart.Len())
 }
 
-// ClearAllAndClearCart removes all payment states and clears the cart in one operation
-// This replaces the pattern of: ClearAll() + services.ClearPaymentState()
-func (psm *PaymentStateManager) ClearAllAndClearCart() {
+// ClearCartAndPayments removes the payment states started from a cart and clears it in one
+// operation; the payments of other sessions are left alone
+func (psm *PaymentStateManager) ClearCartAndPayments(cart *services.CartStore) {
+	defer psm.changed()
 	psm.mutex.Lock()
 	defer psm.mutex.Unlock()
 
-	// Clear all payment states
-	psm.states = make(map[string]PaymentState)
+	for id, state := range psm.states {
+		if state.GetCartStore() == cart {
+			delete(psm.states, id)
+		}
+	}
 
-	// Clear the cart since all transactions are being reset
-	services.AppState.CurrentCart = []templates.Product{}
+	// Clear the cart since its transactions are being reset
+	cart.Clear()
 
-	utils.Info("payment", "Cleared all payment states and cart")
+	utils.Info("payment", "Cleared cart and its payment states")
 }
 
-// ClearByTypeAndClearCart removes all payment states of a specific type and clears the cart
-// Useful for clearing all QR or all terminal payments at once
-func (psm *PaymentStateManager) ClearByTypeAndClearCart(paymentType string) {
+// ClearDemoAndClearCart removes the simulated payments made in demo mode and returns their IDs.
+// The carts they were started from are cleared, since those belonged to practice sales.
+func (psm *PaymentStateManager) ClearDemoAndClearCart() []string {
+	defer psm.changed()
 	psm.mutex.Lock()
 	defer psm.mutex.Unlock()
 
-	removedCount := 0
+	var removed []string
 	for id, state := range psm.states {
-		if state.GetPaymentType() == paymentType {
+		if services.IsDemoID(id) {
 			delete(psm.states, id)
-			removedCount++
+			removed = append(removed, id)
+			if cart := state.GetCartStore(); cart != nil {
+				cart.Clear()
+			}
 		}
 	}
-	// Clear the cart if any payments were removed
-	if removedCount > 0 {
-		services.AppState.CurrentCart = []templates.Product{}
-		utils.Info("payment", "Removed payment states by type and cleared cart", "payment_type", paymentType, "removed_count", removedCount)
+	if len(removed) > 0 {
+		utils.Info("payment", "Removed demo payment states and cleared their carts", "removed_count", len(removed))
 	}
+	return removed
 }
 
 // QRPaymentState represents QR payment link state
 type QRPaymentState struct {
 	PaymentLinkID string
+	URL           string // Payment link URL in the QR code, empty when the state was created from a status check
 	CreationTime  time.Time
+	Note          string              // Sale note when the QR code was shown
+	RetryOf       string              // Declined card payments of the sale before the QR code (see services.FormatPaymentAttempts)
+	Cashier       string              // User working the register when the QR code was shown
+	Cart          []templates.Product // Cart when the QR code was shown
+	Summary       templates.CartSummary
+	CartHash      string
+	CartStore     *services.CartStore // Cart of the session the QR code was shown on, nil when not known
+}
+
+// newQRPaymentState tracks a payment link shown for a cart at the given time, keeping a copy of
+// the cart
+func newQRPaymentState(cart *services.CartStore, paymentLinkID, url string, createdAt time.Time) *QRPaymentState {
+	items := cart.Items()
+	return &QRPaymentState{
+		PaymentLinkID: paymentLinkID,
+		URL:           url,
+		CreationTime:  createdAt,
+		Note:          cart.Note(),
+		RetryOf:       services.FormatPaymentAttempts(cart.PaymentAttempts()),
+		Cashier:       cart.Cashier(),
+		Cart:          items,
+		Summary:       services.CalculateCartSummary(cart),
+		CartHash:      services.CartHash(items),
+		CartStore:     cart,
+	}
 }
 
 // GetID returns the payment link ID
@@ -187,19 +361,32 @@ func (q *QRPaymentState) GetStartTime() time.Time {
 	return q.CreationTime
 }
 
-// IsExpired checks if the QR payment has expired
-func (q *QRPaymentState) IsExpired(timeout time.Duration) bool {
-	return time.Since(q.CreationTime) > timeout
+// IsExpired checks if the QR payment has expired by now
+func (q *QRPaymentState) IsExpired(now time.Time, timeout time.Duration) bool {
+	return now.Sub(q.CreationTime) > timeout
 }
 
 // GetMetadata returns QR-specific metadata
 func (q *QRPaymentState) GetMetadata() map[string]interface{} {
 	return map[string]interface{}{
 		"payment_link_id": q.PaymentLinkID,
+		"url":             q.URL,
 		"creation_time":   q.CreationTime,
+		"note":            q.Note,
+		"cart_size":       len(q.Cart),
 	}
 }
 
+// GetCartHash returns the fingerprint of the cart the QR code was shown for
+func (q *QRPaymentState) GetCartHash() string {
+	return q.CartHash
+}
+
+// GetCartStore returns the cart of the session the QR code was shown on
+func (q *QRPaymentState) GetCartStore() *services.CartStore {
+	return q.CartStore
+}
+
 // TerminalPaymentState represents terminal payment state
 type TerminalPaymentState struct {
 	PaymentIntentID string
@@ -208,6 +395,11 @@ type TerminalPaymentState struct {
 	Email           string
 	Cart            []templates.Product
 	Summary         templates.CartSummary
+	Note            string // Sale note when the payment was sent to the reader
+	RetryOf         string // Declined card payments of the sale before this one (see services.FormatPaymentAttempts)
+	Cashier         string // User working the register when the payment was sent to the reader
+	CartHash        string
+	CartStore       *services.CartStore // Cart of the session that sent the payment to the reader
 }
 
 // GetID returns the payment intent ID
@@ -225,9 +417,9 @@ func (t *TerminalPaymentState) GetStartTime() time.Time {
 	return t.StartTime
 }
 
-// IsExpired checks if the terminal payment has expired
-func (t *TerminalPaymentState) IsExpired(timeout time.Duration) bool {
-	return time.Since(t.StartTime) > timeout
+// IsExpired checks if the terminal payment has expired by now
+func (t *TerminalPaymentState) IsExpired(now time.Time, timeout time.Duration) bool {
+	return now.Sub(t.StartTime) > timeout
 }
 
 // GetMetadata returns terminal-specific metadata
@@ -239,9 +431,20 @@ func (t *TerminalPaymentState) GetMetadata() map[string]interface{} {
 		"email":             t.Email,
 		"cart_size":         len(t.Cart),
 		"total":             t.Summary.Total,
+		"note":              t.Note,
 	}
 }
 
+// GetCartHash returns the fingerprint of the cart sent to the reader
+func (t *TerminalPaymentState) GetCartHash() string {
+	return t.CartHash
+}
+
+// GetCartStore returns the cart of the session that sent the payment to the reader
+func (t *TerminalPaymentState) GetCartStore() *services.CartStore {
+	return t.CartStore
+}
+
 // PaymentEventType represents different types of payment events
 type PaymentEventType string
 
@@ -253,17 +456,52 @@ const (
 )
 
 // PaymentEventLogger handles transaction logging with predefined event types
-type PaymentEventLogger struct{}
+type PaymentEventLogger struct {
+	payments *PaymentStateManager  // Payments whose start times are used to time completed payments
+	stripe   services.StripeClient // Looks up the card behind a payment
+	config   *templates.AppConfig  // Decides the transaction log directory
+	onSale   func()                // Called after a successful payment is saved, if set
+}
+
+// NewPaymentEventLogger creates an event logger that times payments tracked by the given manager,
+// looks up their cards through stripeClient and writes to the transaction logs of cfg
+func NewPaymentEventLogger(payments *PaymentStateManager, stripeClient services.StripeClient, cfg *templates.AppConfig) *PaymentEventLogger {
+	return &PaymentEventLogger{payments: payments, stripe: stripeClient, config: cfg}
+}
+
+// OnSale registers fn to be called after each successful payment is saved. fn must not block.
+func (pel *PaymentEventLogger) OnSale(fn func()) {
+	pel.onSale = fn
+}
+
+// savedSale is the note of a sale that is no longer on the register, such as one sent to the
+// customer as a payment link. Logging it leaves the register's tip and note alone.
+type savedSale struct {
+	note    string
+	cashier string
+}
+
+// LogPaymentEvent logs a payment event with standardized transaction creation. register is the
+// cart of the session the sale was rung up on, whose tip and note go with it; nil when not known.
+func (pel *PaymentEventLogger) LogPaymentEvent(register *services.CartStore, paymentID string, eventType PaymentEventType, paymentMethod string, cart []templates.Product, summary templates.CartSummary, email string) error {
+	return pel.logPaymentEvent(register, paymentID, eventType, paymentMethod, cart, summary, nil)
+}
 
-// LogPaymentEvent logs a payment event with standardized transaction creation
-func (pel *PaymentEventLogger) LogPaymentEvent(paymentID string, eventType PaymentEventType, paymentMethod string, cart []templates.Product, summary templates.CartSummary, email string) error {
+// logPaymentEvent logs a payment event for the sale on a register, or for a saved sale
+func (pel *PaymentEventLogger) logPaymentEvent(register *services.CartStore, paymentID string, eventType PaymentEventType, paymentMethod string, cart []templates.Product, summary templates.CartSummary, saved *savedSale) error {
 	now := time.Now()
 
 	// Create standardized payment type string
 	paymentTypeStr := pel.getPaymentTypeString(paymentMethod, eventType)
 
-	// Calculate per-item taxes for the cart
-	_, itemTaxes := services.CalculateCartSummaryWithItemTaxes()
+	// Split sales are counted per tender as each one completes
+	if paymentMethod != services.SplitPaymentMethod {
+		pel.recordMetrics(paymentID, eventType, paymentMethod)
+	}
+
+	// Per-item taxes of the cart paid for, from Stripe Tax when it quoted the cart
+	itemTaxes := services.ItemTaxes(cart)
+	itemTaxComponents := services.ItemTaxComponents(cart)
 
 	transaction := templates.Transaction{
 		ID:           paymentID,
@@ -273,18 +511,85 @@ func (pel *PaymentEventLogger) LogPaymentEvent(paymentID string, eventType Payme
 		ProductTaxes: itemTaxes, // Store individual tax amounts
 		Subtotal:     summary.Subtotal,
 		Tax:          summary.Tax,
+		ServiceFee:   summary.ServiceFee,
 		Total:        summary.Total,
 		PaymentType:  paymentTypeStr,
+		// The payment ID doubles as the confirmation code shown to the customer
+		ConfirmationCode: paymentID,
 		// StripeCustomerEmail will be tracked separately via payment update records
+		ProductTaxComponents: itemTaxComponents,
+	}
+
+	// Record the card used so disputes can be matched to the sale
+	// (split sales record the card on each tender instead, and returns take no new payment)
+	if eventType == PaymentEventSuccess && paymentMethod != services.SplitPaymentMethod && paymentMethod != services.ReturnPaymentMethod {
+		card, err := services.GetPaymentCardDetails(pel.stripe, paymentID)
+		if err != nil {
+			utils.Warn("payment", "Could not look up card details for transaction", "payment_id", paymentID, "error", err)
+		}
+		transaction.CardBrand = card.Brand
+		transaction.CardLast4 = card.Last4
+		transaction.StripeReceiptURL = card.ReceiptURL
+		transaction.PayoutAccount = card.PayoutAccount
+
+		// Terminal tips are chosen on the reader; QR and manual tips were chosen on screen
+		switch paymentMethod {
+		case "terminal":
+			transaction.TipAmount = card.Tip
+		case "qr", "manual":
+			if saved == nil && register != nil {
+				transaction.TipAmount = register.TakeTip()
+			}
+		}
+	}
+
+	// The note belongs to the sale, so it is kept on retries and cleared once the sale is paid
+	if eventType == PaymentEventSuccess && saved != nil {
+		transaction.Note = saved.note
+		transaction.CashierID = saved.cashier
+	} else if eventType == PaymentEventSuccess {
+		transaction.Note = pel.saleNote(register, paymentID)
+		transaction.RetryOf = pel.saleRetryOf(register, paymentID)
+		transaction.CashierID = pel.saleCashier(register, paymentID)
+		if register != nil {
+			register.SetNote("")
+		}
+	}
+
+	// Orders with items to prepare get the day's next number for the fulfillment ticket and receipt
+	if eventType == PaymentEventSuccess && config.Config.FulfillmentTickets && len(services.TicketLines(&transaction)) > 0 {
+		number, err := services.NextOrderNumber()
+		if err != nil {
+			utils.Error("payment", "Error issuing order number", "payment_id", paymentID, "error", err)
+		}
+		transaction.OrderNumber = number
 	}
 
 	// Save transaction with error logging
-	if err := services.SaveTransactionToCSV(transaction); err != nil {
+	if err := services.SaveTransactionTo(services.TransactionsDir(pel.config), transaction); err != nil {
 		utils.Error("payment", "Error saving transaction", "payment_type", paymentTypeStr, "payment_id", paymentID, "error", err)
 		return err
 	}
 
-	utils.Info("payment", "Successfully logged transaction", "payment_type", paymentTypeStr, "payment_id", paymentID, "amount", summary.Total)
+	utils.Info("payment", "Successfully logged transaction", "payment_type", paymentTypeStr, "payment_id", paymentID, "amount", summary.Total, "cashier", transaction.CashierID)
+
+	switch eventType {
+	case PaymentEventSuccess:
+		services.RecordPaymentActivity(services.ActivitySale, paymentID, paymentMethod, transaction.AmountPaid())
+	case PaymentEventFailed:
+		services.RecordPaymentActivity(services.ActivityDeclined, "", paymentMethod, summary.Total)
+	}
+
+	// Load any gift cards sold in the sale now that it is paid
+	if eventType == PaymentEventSuccess {
+		if err := services.IssueGiftCards(paymentID, cart); err != nil {
+			utils.Error("giftcard", "Error loading gift cards for sale", "payment_id", paymentID, "error", err)
+		}
+		services.RecordStripeTax(pel.stripe, paymentID, cart)
+		if pel.onSale != nil {
+			pel.onSale()
+		}
+	}
 	return nil
 }
 
@@ -303,29 +608,26 @@ func (pel *PaymentEventLogger) LogPaymentEventFromState(state PaymentState, even
 			email = s.Email
 		}
 	case *QRPaymentState:
-		// For QR payments, use current cart state
-		cart = services.AppState.CurrentCart
+		// The cart the QR code was shown for; the cashier may have started another since
+		cart = s.Cart
+		summary = s.Summary
 		paymentMethod = "qr"
-		// Calculate summary if not provided
-		if summary.Total == 0 {
-			summary = services.CalculateCartSummary()
-		}
 	default:
 		// Fallback to current cart state
-		cart = services.AppState.CurrentCart
 		paymentMethod = "unknown"
-		if summary.Total == 0 {
-			summary = services.CalculateCartSummary()
+		if register := state.GetCartStore(); register != nil {
+			cart = register.Items()
+			summary = services.CalculateCartSummary(register)
 		}
 	}
 
-	return pel.LogPaymentEvent(state.GetID(), eventType, paymentMethod, cart, summary, email)
+	return pel.LogPaymentEvent(state.GetCartStore(), state.GetID(), eventType, paymentMethod, cart, summary, email)
 }
 
 // LogPaymentEventWithStripeEmail logs a payment event including Stripe-collected customer info
-func (pel *PaymentEventLogger) LogPaymentEventWithStripeEmail(paymentID string, eventType PaymentEventType, paymentMethod string, cart []templates.Product, summary templates.CartSummary, email string, stripeEmail string) error {
+func (pel *PaymentEventLogger) LogPaymentEventWithStripeEmail(register *services.CartStore, paymentID string, eventType PaymentEventType, paymentMethod string, cart []templates.Product, summary templates.CartSummary, email string, stripeEmail string) error {
 	// First log the standard transaction
-	if err := pel.LogPaymentEvent(paymentID, eventType, paymentMethod, cart, summary, email); err != nil {
+	if err := pel.LogPaymentEvent(register, paymentID, eventType, paymentMethod, cart, summary, email); err != nil {
 		return err
 	}
 
@@ -337,9 +639,81 @@ func (pel *PaymentEventLogger) LogPaymentEventWithStripeEmail(paymentID string,
 	return nil
 }
 
+// LogSentLinkPayment logs the sale of a payment link sent to a customer once it is paid. The sale
+// left the register when the link was sent, so the cart and note come from the link.
+func (pel *PaymentEventLogger) LogSentLinkPayment(link templates.SentLink, summary templates.CartSummary, stripeEmail string) error {
+	if err := pel.logPaymentEvent(nil, link.PaymentLinkID, PaymentEventSuccess, "qr", link.Cart, summary, &savedSale{note: link.Note, cashier: link.SentBy}); err != nil {
+		return err
+	}
+	if stripeEmail != "" {
+		return services.LogStripeCustomerInfo(link.PaymentLinkID, stripeEmail)
+	}
+	return nil
+}
+
 // LogPaymentEventQuick logs a simple payment event (for failures/cancellations without detailed cart data)
 func (pel *PaymentEventLogger) LogPaymentEventQuick(paymentID string, eventType PaymentEventType, paymentMethod string) error {
-	return pel.LogPaymentEvent(paymentID, eventType, paymentMethod, []templates.Product{}, templates.CartSummary{}, "")
+	return pel.LogPaymentEvent(nil, paymentID, eventType, paymentMethod, []templates.Product{}, templates.CartSummary{}, "")
+}
+
+// recordMetrics counts a payment outcome, timing it from when its payment state was created
+func (pel *PaymentEventLogger) recordMetrics(paymentID string, eventType PaymentEventType, paymentMethod string) {
+	var startTime time.Time
+	if state, exists := pel.payments.GetPayment(paymentID); exists {
+		startTime = state.GetStartTime()
+	}
+	services.RecordPaymentCompleted(paymentMethod, string(eventType), startTime)
+}
+
+// saleNote returns the note for a payment: the one captured in its payment state when the
+// payment started, or the note currently entered for the sale on the register
+func (pel *PaymentEventLogger) saleNote(register *services.CartStore, paymentID string) string {
+	if state, exists := pel.payments.GetPayment(paymentID); exists {
+		switch s := state.(type) {
+		case *TerminalPaymentState:
+			return s.Note
+		case *QRPaymentState:
+			return s.Note
+		}
+	}
+	if register == nil {
+		return ""
+	}
+	return register.Note()
+}
+
+// saleRetryOf returns the declined card payments a payment follows: those captured in its payment
+// state when the payment started, or those of the sale in progress on the register
+func (pel *PaymentEventLogger) saleRetryOf(register *services.CartStore, paymentID string) string {
+	if state, exists := pel.payments.GetPayment(paymentID); exists {
+		switch s := state.(type) {
+		case *TerminalPaymentState:
+			return s.RetryOf
+		case *QRPaymentState:
+			return s.RetryOf
+		}
+	}
+	if register == nil {
+		return ""
+	}
+	return services.FormatPaymentAttempts(register.PaymentAttempts())
+}
+
+// saleCashier returns the user a payment is credited to: the one working the register when the
+// payment started, or the one working it now
+func (pel *PaymentEventLogger) saleCashier(register *services.CartStore, paymentID string) string {
+	if state, exists := pel.payments.GetPayment(paymentID); exists {
+		switch s := state.(type) {
+		case *TerminalPaymentState:
+			return s.Cashier
+		case *QRPaymentState:
+			return s.Cashier
+		}
+	}
+	if register == nil {
+		return ""
+	}
+	return register.Cashier()
 }
 
 // getPaymentTypeString creates a standardized payment type string
@@ -357,7 +731,3 @@ func (pel *PaymentEventLogger) getPaymentTypeString(paymentMethod string, eventT
 		return paymentMethod + "_unknown"
 	}
 }
-
-// Global instances
-var GlobalPaymentStateManager = NewPaymentStateManager()
-var GlobalPaymentEventLogger = &PaymentEventLogger{}
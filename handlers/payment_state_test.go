@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stripe/stripe-go/v74"
+
+	"checkout/config"
+	"checkout/services/stripetest"
+)
+
+// pendingPayment is a way of paying for the cart: how a test starts it, has the customer pay and
+// checks on it
+type pendingPayment struct {
+	name    string
+	start   func(t *testing.T, app *App) string
+	succeed func(fake *stripetest.Client, id string)
+	check   func(app *App, id string) PaymentStatusResult
+}
+
+var pendingPayments = []pendingPayment{
+	{
+		name: "qr",
+		start: func(t *testing.T, app *App) string {
+			t.Helper()
+			postForm(app.GenerateQRCodeHandler, "/generate-qr-code", url.Values{})
+			states := app.Payments.GetStatesByType("qr")
+			if len(states) != 1 {
+				t.Fatalf("QR payment states = %d, want 1", len(states))
+			}
+			return states[0].GetID()
+		},
+		succeed: func(fake *stripetest.Client, id string) { fake.CompleteLink(id, "customer@example.com") },
+		check:   (*App).checkQRPaymentStatus,
+	},
+	{
+		name:  "terminal",
+		start: startTerminalPayment,
+		succeed: func(fake *stripetest.Client, id string) {
+			fake.SetIntentStatus(id, stripe.PaymentIntentStatusSucceeded)
+		},
+		check: (*App).checkTerminalPaymentStatus,
+	},
+}
+
+// The cashier may start ringing up the next customer while a slow payment is pending; that cart
+// must survive the earlier payment completing
+func TestCompletedPaymentClearsOnlyItsCart(t *testing.T) {
+	for _, payment := range pendingPayments {
+		t.Run(payment.name, func(t *testing.T) {
+			tests := []struct {
+				name      string
+				addDuring []string
+				wantItems []string
+			}{
+				{"cart unchanged", nil, nil},
+				{"new items added during the payment", []string{"Bagel", "Muffin"}, []string{"Coffee", "Bagel", "Muffin"}},
+			}
+			for _, tt := range tests {
+				t.Run(tt.name, func(t *testing.T) {
+					app, fake, _ := newTestApp(t)
+					addToCart(app, "Coffee", 4.50)
+					id := payment.start(t, app)
+
+					for _, name := range tt.addDuring {
+						addToCart(app, name, 3.25)
+					}
+					payment.succeed(fake, id)
+					if result := payment.check(app, id); !result.ShouldStop {
+						t.Fatalf("payment not concluded")
+					}
+
+					if _, tracked := app.Payments.GetPayment(id); tracked {
+						t.Errorf("payment still tracked after completing")
+					}
+					items := sessionCart(app).Items()
+					if len(items) != len(tt.wantItems) {
+						t.Fatalf("cart = %v, want %v", items, tt.wantItems)
+					}
+					for i, item := range items {
+						if item.Name != tt.wantItems[i] {
+							t.Errorf("cart item %d = %s, want %s", i, item.Name, tt.wantItems[i])
+						}
+					}
+				})
+			}
+		})
+	}
+}
+
+// A payment that doesn't go through leaves the cart for the cashier to retry
+func TestUnsuccessfulPaymentKeepsCart(t *testing.T) {
+	tests := []struct {
+		name  string
+		start func(t *testing.T, app *App) string
+		fail  func(fake *stripetest.Client, clock *fakeClock, id string)
+		check func(app *App, id string) PaymentStatusResult
+	}{
+		{"qr link expired", pendingPayments[0].start, func(_ *stripetest.Client, clock *fakeClock, _ string) {
+			clock.Advance(config.PaymentTimeout + time.Second)
+		}, (*App).checkQRPaymentStatus},
+		{"terminal declined", startTerminalPayment, func(fake *stripetest.Client, _ *fakeClock, id string) {
+			fake.DeclineOnReader(id)
+		}, (*App).checkTerminalPaymentStatus},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, fake, clock := newTestApp(t)
+			addToCart(app, "Coffee", 4.50)
+			id := tt.start(t, app)
+
+			tt.fail(fake, clock, id)
+			if result := tt.check(app, id); !result.ShouldStop {
+				t.Fatalf("payment not concluded")
+			}
+
+			if items := sessionCart(app).Items(); len(items) != 1 || items[0].Name != "Coffee" {
+				t.Errorf("cart = %v, want the coffee kept for a retry", items)
+			}
+		})
+	}
+}
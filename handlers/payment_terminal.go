@@ -1,19 +1,23 @@
 package handlers
 
 import (
-	"fmt"
+	"math"
 	"net/http"
 	"time"
 
 	"github.com/stripe/stripe-go/v74"
-	"github.com/stripe/stripe-go/v74/terminal/reader"
 
+	"checkout/i18n"
 	"checkout/services"
 	"checkout/templates"
 	"checkout/templates/checkout"
 	"checkout/utils"
 )
 
+// readerRetryDelay is how long to wait before sending a payment to a degraded reader again,
+// giving a reader that was idle off Wi-Fi time to reconnect
+const readerRetryDelay = 3 * time.Second
+
 // TerminalProcessingResult represents the result of terminal payment processing
 type TerminalProcessingResult struct {
 	Success        bool
@@ -24,13 +28,13 @@ type TerminalProcessingResult struct {
 }
 
 // ProcessTerminalPayment handles all terminal-specific payment processing logic
-func ProcessTerminalPayment(w http.ResponseWriter, r *http.Request, intent *stripe.PaymentIntent, email string, summary templates.CartSummary) TerminalProcessingResult {
-	// Use the user's selected reader
-	selectedReaderID := services.AppState.SelectedReaderID
+func (a *App) ProcessTerminalPayment(w http.ResponseWriter, r *http.Request, intent *stripe.PaymentIntent, email string, summary templates.CartSummary) TerminalProcessingResult {
+	// Use the reader this register picked
+	selectedReaderID := selectedReader(w, r).ReaderID
 	if selectedReaderID == "" {
 		utils.Error("payment", "No terminal reader selected", "intent_id", intent.ID)
 		if renderErr := renderErrorModal(w, r,
-			"Please select a terminal reader before attempting payment.",
+			i18n.T("terminal.select_reader"),
 			intent.ID); renderErr != nil {
 			utils.Error("payment", "Error rendering no reader selected modal", "intent_id", intent.ID, "error", renderErr)
 		}
@@ -41,11 +45,18 @@ func ProcessTerminalPayment(w http.ResponseWriter, r *http.Request, intent *stri
 		}
 	}
 
+	// A reader that stopped answering the keep-alive checks may be shown online when it isn't
+	readerDegraded, _ := services.Terminal.ReaderDegraded(selectedReaderID)
+	if readerDegraded {
+		utils.Info("payment", "Checking degraded reader before payment", "reader_id", selectedReaderID, "intent_id", intent.ID)
+		services.PingReader(selectedReaderID)
+	}
+
 	// Verify the selected reader is online
 	if !isReaderOnline(selectedReaderID) {
 		utils.Error("payment", "Selected terminal reader is not online", "reader_id", selectedReaderID, "intent_id", intent.ID)
 		if renderErr := renderErrorModal(w, r,
-			"The selected terminal reader is not online. Please check reader status or select a different reader.",
+			i18n.T("terminal.reader_offline"),
 			intent.ID); renderErr != nil {
 			utils.Error("payment", "Error rendering reader offline modal", "intent_id", intent.ID, "error", renderErr)
 		}
@@ -57,14 +68,20 @@ func ProcessTerminalPayment(w http.ResponseWriter, r *http.Request, intent *stri
 	}
 
 	// Process payment on the terminal reader
-	processedReader, err := processPaymentOnTerminal(intent.ID, selectedReaderID, summary)
+	a.clearReaderEmailCollections(selectedReaderID)
+	processedReader, err := a.processPaymentOnTerminal(a.cart(r), intent.ID, selectedReaderID, summary)
+	if err != nil && readerDegraded {
+		utils.Warn("payment", "Degraded reader didn't take the payment, retrying", "reader_id", selectedReaderID, "intent_id", intent.ID, "error", err)
+		time.Sleep(readerRetryDelay)
+		processedReader, err = a.processPaymentOnTerminal(a.cart(r), intent.ID, selectedReaderID, summary)
+	}
 	if err != nil {
 		utils.Error("payment", "Error commanding reader to process PaymentIntent", "reader_id", selectedReaderID, "intent_id", intent.ID, "error", err)
-		errMsg := "Error communicating with the payment terminal."
+		errMsg := i18n.T("terminal.communication_error")
 		if stripeErr, ok := err.(*stripe.Error); ok {
-			errMsg = fmt.Sprintf("Terminal communication error: %s", stripeErr.Msg)
+			errMsg = i18n.T("terminal.communication_error_detail", stripeErr.Msg)
 		}
-		if renderErr := renderErrorModal(w, r, errMsg, intent.ID); renderErr != nil {
+		if renderErr := a.renderDeclineModal(w, r, errMsg, intent.ID, stripeErrorCode(err), "terminal"); renderErr != nil {
 			utils.Error("payment", "Error rendering terminal communication error modal", "intent_id", intent.ID, "error", renderErr)
 		}
 		return TerminalProcessingResult{
@@ -74,13 +91,15 @@ func ProcessTerminalPayment(w http.ResponseWriter, r *http.Request, intent *stri
 		}
 	}
 
+	services.Terminal.ReaderSeen(selectedReaderID, time.Now())
+
 	// Handle terminal processing result
-	return handleTerminalActionResult(w, r, intent, selectedReaderID, processedReader, email, summary)
+	return a.handleTerminalActionResult(w, r, intent, selectedReaderID, processedReader, email, summary)
 }
 
 // isReaderOnline checks if a specific reader ID is online
 func isReaderOnline(readerID string) bool {
-	for _, reader := range services.AppState.SiteStripeReaders {
+	for _, reader := range services.Terminal.Readers() {
 		if reader.ID == readerID && reader.Status == "online" {
 			return true
 		}
@@ -90,12 +109,12 @@ func isReaderOnline(readerID string) bool {
 
 // processPaymentOnTerminal processes payment intent on a terminal reader
 // with tipping configuration based on business rules
-func processPaymentOnTerminal(intentID, readerID string, summary templates.CartSummary) (*stripe.TerminalReader, error) {
+func (a *App) processPaymentOnTerminal(cart *services.CartStore, intentID, readerID string, summary templates.CartSummary) (*stripe.TerminalReader, error) {
 	// Determine if tipping should be enabled for this transaction
 	shouldEnableTipping := services.ShouldEnableTipping(
 		summary.Total,
-		services.AppState.CurrentCart,
-		services.AppState.SelectedStripeLocation.ID,
+		cart.Items(),
+		services.Terminal.SelectedLocation().ID,
 	)
 
 	readerParams := &stripe.TerminalReaderProcessPaymentIntentParams{
@@ -104,20 +123,27 @@ func processPaymentOnTerminal(intentID, readerID string, summary templates.CartS
 			SkipTipping: stripe.Bool(!shouldEnableTipping), // Skip tipping if business rules say no
 		},
 	}
+	if shouldEnableTipping {
+		// Percentages are calculated on the pre-tax amount. The preset buttons themselves come from
+		// the reader's Terminal Configuration in Stripe, which the API can't set per payment.
+		readerParams.ProcessConfig.Tipping = &stripe.TerminalReaderProcessPaymentIntentProcessConfigTippingParams{
+			AmountEligible: stripe.Int64(int64(math.Round(summary.Subtotal * 100))),
+		}
+	}
 
 	utils.Info("payment", "Attempting to process PaymentIntent on terminal reader",
 		"intent_id", intentID, "reader_id", readerID, "tipping_enabled", shouldEnableTipping, "amount", summary.Total)
-	return reader.ProcessPaymentIntent(readerID, readerParams)
+	return a.Stripe.ProcessReaderPayment(readerID, readerParams)
 }
 
 // handleTerminalActionResult handles the result of a terminal reader action
-func handleTerminalActionResult(w http.ResponseWriter, r *http.Request, intent *stripe.PaymentIntent,
+func (a *App) handleTerminalActionResult(w http.ResponseWriter, r *http.Request, intent *stripe.PaymentIntent,
 	selectedReaderID string, processedReader *stripe.TerminalReader, email string, summary templates.CartSummary) TerminalProcessingResult {
 
 	if processedReader == nil || processedReader.Action == nil {
 		utils.Error("payment", "Unexpected nil reader or action after ProcessPaymentIntent",
 			"intent_id", intent.ID, "reader_id", selectedReaderID)
-		errMsg := "An unexpected error occurred with the terminal. Payment status is unclear."
+		errMsg := i18n.T("terminal.unexpected_error")
 		if renderErr := renderErrorModal(w, r, errMsg, intent.ID); renderErr != nil {
 			utils.Error("payment", "Error rendering nil action/reader modal", "intent_id", intent.ID, "error", renderErr)
 		}
@@ -132,17 +158,17 @@ func handleTerminalActionResult(w http.ResponseWriter, r *http.Request, intent *
 
 	switch processedReader.Action.Status {
 	case stripe.TerminalReaderActionStatusSucceeded:
-		return handleTerminalSuccess(w, r, intent, processedReader)
+		return a.handleTerminalSuccess(w, r, intent, processedReader)
 
 	case stripe.TerminalReaderActionStatusFailed:
-		return handleTerminalFailure(w, r, intent, processedReader)
+		return a.handleTerminalFailure(w, r, intent, processedReader)
 
 	case stripe.TerminalReaderActionStatusInProgress:
-		return handleTerminalInProgress(w, r, intent, selectedReaderID, email, summary)
+		return a.handleTerminalInProgress(w, r, intent, selectedReaderID, email, summary)
 
 	default:
 		utils.Error("payment", "Unexpected terminal reader action status", "status", processedReader.Action.Status, "intent_id", intent.ID)
-		errMsg := fmt.Sprintf("Unexpected terminal status: %s", processedReader.Action.Status)
+		errMsg := i18n.T("terminal.unexpected_status", processedReader.Action.Status)
 		if renderErr := renderErrorModal(w, r, errMsg, intent.ID); renderErr != nil {
 			utils.Error("payment", "Error rendering unexpected status modal", "intent_id", intent.ID, "error", renderErr)
 		}
@@ -155,11 +181,11 @@ func handleTerminalActionResult(w http.ResponseWriter, r *http.Request, intent *
 }
 
 // handleTerminalSuccess handles successful terminal payment
-func handleTerminalSuccess(w http.ResponseWriter, r *http.Request, intent *stripe.PaymentIntent, processedReader *stripe.TerminalReader) TerminalProcessingResult {
+func (a *App) handleTerminalSuccess(w http.ResponseWriter, r *http.Request, intent *stripe.PaymentIntent, processedReader *stripe.TerminalReader) TerminalProcessingResult {
 	pi := processedReader.Action.ProcessPaymentIntent.PaymentIntent
 	if pi == nil {
 		utils.Error("payment", "PaymentIntent is nil within successful reader action", "intent_id", intent.ID)
-		errMsg := "Payment confirmation missing after successful terminal interaction."
+		errMsg := i18n.T("terminal.confirmation_missing")
 		if renderErr := renderErrorModal(w, r, errMsg, intent.ID); renderErr != nil {
 			utils.Error("payment", "Error rendering PI nil in action modal", "intent_id", intent.ID, "error", renderErr)
 		}
@@ -181,12 +207,15 @@ func handleTerminalSuccess(w http.ResponseWriter, r *http.Request, intent *strip
 			Message:        "Payment succeeded",
 		}
 	} else {
-		declineMessage := "Payment declined by terminal."
-		if pi.LastPaymentError != nil && pi.LastPaymentError.Msg != "" {
-			declineMessage = fmt.Sprintf("Payment declined: %s", pi.LastPaymentError.Msg)
+		declineMessage, declineCode := i18n.T("terminal.declined"), ""
+		if pi.LastPaymentError != nil {
+			if pi.LastPaymentError.Msg != "" {
+				declineMessage = i18n.T("terminal.declined_detail", pi.LastPaymentError.Msg)
+			}
+			declineCode = string(pi.LastPaymentError.Code)
 		}
 		utils.Error("payment", "PaymentIntent not successful after terminal success", "intent_id", pi.ID, "status", string(pi.Status), "decline_reason", declineMessage)
-		if renderErr := renderErrorModal(w, r, declineMessage, pi.ID); renderErr != nil {
+		if renderErr := a.renderDeclineModal(w, r, declineMessage, pi.ID, declineCode, "terminal"); renderErr != nil {
 			utils.Error("payment", "Error rendering payment declined modal", "intent_id", pi.ID, "error", renderErr)
 		}
 		return TerminalProcessingResult{
@@ -198,14 +227,14 @@ func handleTerminalSuccess(w http.ResponseWriter, r *http.Request, intent *strip
 }
 
 // handleTerminalFailure handles failed terminal payment
-func handleTerminalFailure(w http.ResponseWriter, r *http.Request, intent *stripe.PaymentIntent, processedReader *stripe.TerminalReader) TerminalProcessingResult {
-	errMsg := "Payment failed at terminal."
+func (a *App) handleTerminalFailure(w http.ResponseWriter, r *http.Request, intent *stripe.PaymentIntent, processedReader *stripe.TerminalReader) TerminalProcessingResult {
+	errMsg := i18n.T("terminal.failed")
 	if processedReader.Action.FailureMessage != "" {
-		errMsg = fmt.Sprintf("Terminal error: %s", processedReader.Action.FailureMessage)
+		errMsg = i18n.T("terminal.failed_detail", processedReader.Action.FailureMessage)
 	}
 	utils.Error("payment", "Terminal reader action failed", "intent_id", intent.ID,
 		"failure_message", processedReader.Action.FailureMessage, "failure_code", processedReader.Action.FailureCode)
-	if renderErr := renderErrorModal(w, r, errMsg, intent.ID); renderErr != nil {
+	if renderErr := a.renderDeclineModal(w, r, errMsg, intent.ID, processedReader.Action.FailureCode, "terminal"); renderErr != nil {
 		utils.Error("payment", "Error rendering reader action failed modal", "intent_id", intent.ID, "error", renderErr)
 	}
 	return TerminalProcessingResult{
@@ -216,23 +245,29 @@ func handleTerminalFailure(w http.ResponseWriter, r *http.Request, intent *strip
 }
 
 // handleTerminalInProgress handles in-progress terminal payment (sets up polling)
-func handleTerminalInProgress(w http.ResponseWriter, r *http.Request, intent *stripe.PaymentIntent,
+func (a *App) handleTerminalInProgress(w http.ResponseWriter, r *http.Request, intent *stripe.PaymentIntent,
 	selectedReaderID, email string, summary templates.CartSummary) TerminalProcessingResult {
+	cart := a.cart(r)
 
 	utils.Info("payment", "Terminal payment in progress - switching to polling",
 		"intent_id", intent.ID, "reader_id", selectedReaderID)
 
 	// Store the active payment details for polling handlers
+	items := cart.Items()
 	terminalState := &TerminalPaymentState{
 		PaymentIntentID: intent.ID,
 		ReaderID:        selectedReaderID,
-		StartTime:       time.Now(),
+		StartTime:       a.Clock.Now(),
 		Email:           email,
-		Cart:            make([]templates.Product, len(services.AppState.CurrentCart)),
+		Cart:            items,
 		Summary:         summary,
+		Note:            cart.Note(),
+		RetryOf:         services.FormatPaymentAttempts(cart.PaymentAttempts()),
+		Cashier:         cart.Cashier(),
+		CartHash:        services.CartHash(items),
+		CartStore:       cart,
 	}
-	copy(terminalState.Cart, services.AppState.CurrentCart)
-	GlobalPaymentStateManager.AddPayment(terminalState)
+	a.Payments.AddPayment(terminalState)
 
 	// Render terminal payment container with SSE support
 	component := checkout.TerminalPaymentContainer(
@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"checkout/config"
+	"checkout/handlers/htmx"
+	"checkout/services"
+	"checkout/templates"
+	"checkout/utils"
+)
+
+// VoidPaymentHandler reverses a just-completed payment and puts its items back in the cart
+// so the sale can be redone. Only payments inside the configured void window can be voided.
+func (a *App) VoidPaymentHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Error parsing form", http.StatusBadRequest)
+		return
+	}
+
+	paymentID := r.FormValue("payment_id")
+
+	transaction, err := services.LoadTransactionByID(paymentID)
+	if err != nil {
+		utils.Warn("payment", "Void requested for unknown transaction", "payment_id", paymentID, "error", err)
+		setToast(w, "error", "toast.transaction_not_found")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if transaction.Voided {
+		setToast(w, "warning", "toast.already_voided")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	// Returns refunded the original payment and took no new one, so there is nothing to reverse
+	if transaction.PaymentType == services.ReturnPaymentMethod {
+		setToast(w, "warning", "toast.return_not_voidable")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if !IsWithinVoidWindow(transaction) {
+		utils.Info("payment", "Void window has passed", "payment_id", paymentID, "date", transaction.Date, "time", transaction.Time)
+		setToast(w, "warning", "toast.void_window_passed")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	// Gift cards sold in the sale are taken back, which can't happen once they are spent
+	if err := services.CheckGiftCardLoadsReversible(transaction.ID); err != nil {
+		utils.Info("payment", "Void blocked by gift card", "payment_id", paymentID, "error", err)
+		setToast(w, "warning", "toast.cant_void", err.Error())
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	// Split sales are reversed tender by tender
+	if len(transaction.Tenders) > 0 {
+		reversal, failed := services.VoidSplitTenders(a.Stripe, transaction.ConfirmationCode, transaction.Tenders, "sale voided", a.cart(r).Cashier())
+		if len(failed) > 0 {
+			utils.Error("payment", "Error voiding split payment", "payment_id", paymentID, "failed_tenders", len(failed), "reversed", reversal)
+			setToast(w, "error", "toast.split_void_failed", len(failed), len(transaction.Tenders))
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		a.completeVoid(w, r, transaction, reversal)
+		return
+	}
+
+	reversal, err := services.VoidPayment(a.Stripe, paymentID)
+	if err != nil {
+		utils.Error("payment", "Error voiding payment", "payment_id", paymentID, "error", err)
+		setToast(w, "error", "toast.void_error")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	a.completeVoid(w, r, transaction, reversal)
+}
+
+// completeVoid logs the reversal of a voided sale and puts its items back in the cart
+func (a *App) completeVoid(w http.ResponseWriter, r *http.Request, transaction *templates.Transaction, reversal string) {
+	paymentID := transaction.ID
+
+	if err := services.SaveVoidTransaction(transaction, reversal, a.cart(r).Cashier()); err != nil {
+		// The payment is already reversed in Stripe, so still restore the cart
+		utils.Error("payment", "Error saving void transaction", "payment_id", paymentID, "error", err)
+	}
+
+	if err := services.ReverseGiftCardLoads(paymentID); err != nil {
+		utils.Error("payment", "Error reversing gift card loads", "payment_id", paymentID, "error", err)
+	}
+
+	services.RestoreCartFromTransaction(a.cart(r), transaction)
+	utils.Info("payment", "Payment voided", "payment_id", paymentID, "reversal", reversal, "items_restored", len(transaction.Products), "user", currentUsername(r))
+
+	setToast(w, "success", "toast.payment_voided")
+	htmx.Trigger(w, "closeModal", "cartUpdated")
+	w.WriteHeader(http.StatusOK)
+}
+
+// IsWithinVoidWindow reports whether a transaction is recent enough to be voided
+func IsWithinVoidWindow(transaction *templates.Transaction) bool {
+	completedAt, err := time.ParseInLocation("01/02/2006 15:04:05", fmt.Sprintf("%s %s", transaction.Date, transaction.Time), time.Local)
+	if err != nil {
+		utils.Error("payment", "Error parsing transaction time", "transaction_id", transaction.ID, "error", err)
+		return false
+	}
+	return time.Since(completedAt) <= config.GetVoidWindow()
+}
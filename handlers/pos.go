@@ -1,13 +1,15 @@
 package handlers
 
 import (
-	"fmt"
 	"net/http"
 	"strconv"
 	"strings"
 	"time"
 
+	"checkout/config"
+	"checkout/handlers/htmx"
 	"checkout/services"
+	"checkout/services/validation"
 	"checkout/templates"
 	"checkout/templates/checkout"
 	"checkout/templates/pos"
@@ -15,12 +17,14 @@ import (
 )
 
 // ProductsHandler renders the products list
-func ProductsHandler(w http.ResponseWriter, r *http.Request) {
-	products := services.GetCurrentProducts()
-	subcategories := services.GetCurrentSubcategories()
-	currentPath := services.AppState.CategoryData.CurrentPath
-
-	component := pos.ProductsList(products, subcategories, currentPath)
+func (a *App) ProductsHandler(w http.ResponseWriter, r *http.Request) {
+	cart := a.cart(r)
+	products := services.GetCurrentProducts(cart)
+	subcategories := services.GetCurrentSubcategories(cart)
+	currentPath := cart.CategoryPath()
+	device := deviceID(w, r)
+
+	component := pos.ProductsList(products, subcategories, currentPath, services.Favorites(device), services.FavoriteIDs(device))
 	err := component.Render(r.Context(), w)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -28,7 +32,7 @@ func ProductsHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 // NavigateCategoryHandler handles category navigation
-func NavigateCategoryHandler(w http.ResponseWriter, r *http.Request) {
+func (a *App) NavigateCategoryHandler(w http.ResponseWriter, r *http.Request) {
 	if err := r.ParseForm(); err != nil {
 		http.Error(w, "Error parsing form", http.StatusBadRequest)
 		return
@@ -71,21 +75,21 @@ func NavigateCategoryHandler(w http.ResponseWriter, r *http.Request) {
 
 	utils.Debug("category", "Parsed path", "path", path)
 
-	// Navigate to the category
-	services.AppState.CategoryData.CurrentPath = path
+	// Navigate this register to the category, leaving the others where they are
+	a.cart(r).SetCategoryPath(path)
 
-	utils.Debug("category", "Updated current path", "currentPath", services.AppState.CategoryData.CurrentPath)
+	utils.Debug("category", "Updated current path", "currentPath", path)
 
 	// Return updated products view
-	w.Header().Set("HX-Trigger", "categoryChanged")
-	ProductsHandler(w, r)
+	htmx.Trigger(w, "categoryChanged")
+	a.ProductsHandler(w, r)
 }
 
 // CartItemsHandler renders only the cart items (for scrollable area)
-func CartItemsHandler(w http.ResponseWriter, r *http.Request) {
-	utils.Debug("cart", "CartItemsHandler called", "cart_items", len(services.AppState.CurrentCart))
+func (a *App) CartItemsHandler(w http.ResponseWriter, r *http.Request) {
+	utils.Debug("cart", "CartItemsHandler called", "cart_items", a.cart(r).Len())
 
-	component := pos.CartItems(services.AppState.CurrentCart)
+	component := pos.CartItems(a.cart(r).Items())
 	err := component.Render(r.Context(), w)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -93,12 +97,19 @@ func CartItemsHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 // CartSummaryHandler renders only the cart summary (for fixed bottom area)
-func CartSummaryHandler(w http.ResponseWriter, r *http.Request) {
-	utils.Debug("cart", "CartSummaryHandler called", "cart_items", len(services.AppState.CurrentCart))
+func (a *App) CartSummaryHandler(w http.ResponseWriter, r *http.Request) {
+	cart := a.cart(r)
+	utils.Debug("cart", "CartSummaryHandler called", "cart_items", cart.Len())
+
+	summary := services.CalculateCartSummary(cart)
 
-	summary := services.CalculateCartSummary()
+	// Show the balance of a split sale that has captured tenders
+	paid := 0.0
+	if split := cart.Split(); split != nil {
+		paid = split.Paid()
+	}
 
-	component := pos.CartSummary(summary)
+	component := pos.CartSummary(summary, paid)
 	err := component.Render(r.Context(), w)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -106,8 +117,8 @@ func CartSummaryHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 // CheckoutFormHandler renders the checkout form
-func CheckoutFormHandler(w http.ResponseWriter, r *http.Request) {
-	component := checkout.Form()
+func (a *App) CheckoutFormHandler(w http.ResponseWriter, r *http.Request) {
+	component := checkout.Form(a.cart(r).Note(), selectedPaymentMethod(a.cart(r)))
 	err := component.Render(r.Context(), w)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -115,7 +126,8 @@ func CheckoutFormHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 // AddToCartHandler adds a service to the cart
-func AddToCartHandler(w http.ResponseWriter, r *http.Request) {
+func (a *App) AddToCartHandler(w http.ResponseWriter, r *http.Request) {
+	cart := a.cart(r)
 	if err := r.ParseForm(); err != nil {
 		http.Error(w, "Error parsing form", http.StatusBadRequest)
 		return
@@ -123,10 +135,22 @@ func AddToCartHandler(w http.ResponseWriter, r *http.Request) {
 
 	serviceID := r.FormValue("id")
 
-	for _, product := range services.AppState.Products {
+	for _, product := range services.Catalog.Products() {
 		if product.ID == serviceID {
-			services.AppState.CurrentCart = append(services.AppState.CurrentCart, product)
-			w.Header().Set("HX-Trigger", `{"cartUpdated": true, "scrollCartToBottom": true}`)
+			if product.GiftCard {
+				renderGiftCardSale(w, r, product)
+				return
+			}
+			product = services.SaleLine(product, time.Now())
+			if services.SoldByMeasure(product) {
+				a.addMeasuredProduct(w, r, product)
+				return
+			}
+			startsSale := cart.Len() == 0
+			cart.Add(product)
+			cart.Touch()
+			a.warnOutsideHours(w, startsSale)
+			htmx.Trigger(w, "cartUpdated", "scrollCartToBottom")
 			return
 		}
 	}
@@ -134,61 +158,383 @@ func AddToCartHandler(w http.ResponseWriter, r *http.Request) {
 	http.Error(w, "Service not found", http.StatusNotFound)
 }
 
-// AddCustomProductHandler adds a custom product to the cart
-func AddCustomProductHandler(w http.ResponseWriter, r *http.Request) {
+// addMeasuredProduct adds a product sold by weight or length to the cart. Without a quantity it
+// asks for one; a rejected quantity is shown as a toast, leaving the form open to correct it.
+func (a *App) addMeasuredProduct(w http.ResponseWriter, r *http.Request, product templates.Product) {
+	cart := a.cart(r)
+	if _, entered := r.Form["quantity"]; !entered {
+		if err := renderInfoModal(w, r, pos.QuantityModal(product)); err != nil {
+			utils.Error("cart", "Error rendering quantity form", "product", product.Name, "error", err)
+		}
+		return
+	}
+
+	quantity, err := validation.Quantity(r.FormValue("quantity"), services.QuantityDecimals(), services.MaxMeasuredQuantity)
+	if err != nil {
+		setToastText(w, "warning", err.Error())
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	line := services.MeasuredLine(product, quantity)
+	startsSale := cart.Len() == 0
+	cart.Add(line)
+	cart.Touch()
+	utils.Info("cart", "Measured item added to cart", "product", product.Name, "quantity", quantity, "unit", product.UnitType, "price", line.Price)
+	a.warnOutsideHours(w, startsSale)
+	htmx.Trigger(w, "closeModal", "cartUpdated", "scrollCartToBottom")
+}
+
+// warnOutsideHours shows a toast warning that a sale was started outside business hours. Nothing
+// is shown when the cart already had items, the time is within business hours, or the warning is
+// turned off. The sale goes ahead either way.
+func (a *App) warnOutsideHours(w http.ResponseWriter, startsSale bool) {
+	if !startsSale || !a.Config.WarnOutsideBusinessHours || config.WithinBusinessHours(time.Now()) {
+		return
+	}
+	utils.Info("cart", "Sale started outside business hours", "business_hours_start", a.Config.BusinessHoursStart, "business_hours_end", a.Config.BusinessHoursEnd)
+	setToast(w, "warning", "toast.outside_business_hours", a.Config.BusinessHoursStart, a.Config.BusinessHoursEnd)
+}
+
+// ScanHandler adds the product matching a scanned SKU/barcode to the cart.
+// Unknown codes open a form to create a new product with that code.
+func (a *App) ScanHandler(w http.ResponseWriter, r *http.Request) {
+	cart := a.cart(r)
 	if err := r.ParseForm(); err != nil {
 		http.Error(w, "Error parsing form", http.StatusBadRequest)
 		return
 	}
 
-	name := r.FormValue("name")
-	description := r.FormValue("description")
-	priceStr := r.FormValue("price")
+	code := services.NormalizeSKU(r.FormValue("code"))
+	if code == "" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
 
-	price, err := strconv.ParseFloat(priceStr, 64)
-	if err != nil {
+	if product, exists := services.FindProductBySKU(code); exists {
+		utils.Debug("cart", "Scanned product", "sku", code, "product", product.Name)
+		if product.GiftCard {
+			renderGiftCardSale(w, r, product)
+			return
+		}
+		product = services.SaleLine(product, time.Now())
+		if services.SoldByMeasure(product) {
+			a.addMeasuredProduct(w, r, product)
+			return
+		}
+		startsSale := cart.Len() == 0
+		cart.Add(product)
+		cart.Touch()
+		a.warnOutsideHours(w, startsSale)
+		htmx.Trigger(w, "cartUpdated", "scrollCartToBottom")
+		return
+	}
+
+	utils.Info("cart", "Unknown barcode scanned", "sku", code)
+	if !templates.IsAdmin(r.Context()) {
+		// Only admins can add products to the catalog
+		setToast(w, "warning", "toast.unknown_barcode", code)
+		return
+	}
+	setToast(w, "warning", "toast.unknown_barcode", code)
+	if err := renderModal(w, r, pos.NewProductModal(code)); err != nil {
+		utils.Error("cart", "Error rendering new product modal", "sku", code, "error", err)
+	}
+}
+
+// CreateProductHandler adds a new product to the catalog and puts it in the cart
+func (a *App) CreateProductHandler(w http.ResponseWriter, r *http.Request) {
+	cart := a.cart(r)
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Error parsing form", http.StatusBadRequest)
+		return
+	}
+
+	price, err := strconv.ParseFloat(r.FormValue("price"), 64)
+	if err != nil || price < 0 {
 		http.Error(w, "Invalid price", http.StatusBadRequest)
 		return
 	}
 
-	// Create custom service
-	customProduct := templates.Product{
-		ID:          fmt.Sprintf("custom-%d", time.Now().UnixNano()),
-		Name:        name,
-		Description: description,
+	product, err := services.AddProduct(templates.Product{
+		Name:        r.FormValue("name"),
+		Description: r.FormValue("description"),
 		Price:       price,
+		Category:    r.FormValue("category"),
+		SKU:         r.FormValue("sku"),
+	})
+	if err != nil {
+		utils.Error("products", "Error creating product", "name", r.FormValue("name"), "sku", r.FormValue("sku"), "error", err)
+		setToastText(w, "error", err.Error())
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	startsSale := cart.Len() == 0
+	cart.Add(product)
+	cart.Touch()
+	a.warnOutsideHours(w, startsSale)
+	htmx.Trigger(w, "cartUpdated", "scrollCartToBottom", "categoryChanged", "closeModal")
+}
+
+// AddCustomProductHandler adds a custom product to the cart
+func (a *App) AddCustomProductHandler(w http.ResponseWriter, r *http.Request) {
+	cart := a.cart(r)
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Error parsing form", http.StatusBadRequest)
+		return
+	}
+
+	customProduct, err := services.NewCustomProduct(r.FormValue("name"), r.FormValue("description"), r.FormValue("price"))
+	if err != nil {
+		utils.Warn("cart", "Custom item rejected", "name", services.TruncateText(r.FormValue("name"), services.MaxItemNameLength), "error", err)
+		setToastText(w, "warning", err.Error())
+		w.WriteHeader(http.StatusOK)
+		return
 	}
 
 	// Add to cart
-	services.AppState.CurrentCart = append(services.AppState.CurrentCart, customProduct)
-	w.Header().Set("HX-Trigger", `{"cartUpdated": true, "scrollCartToBottom": true, "closeModal": true}`)
+	startsSale := cart.Len() == 0
+	cart.Add(customProduct)
+	cart.Touch()
+	if err := services.RecordCustomProduct(customProduct, time.Now()); err != nil {
+		utils.Error("cart", "Error recording recent custom item", "name", customProduct.Name, "error", err)
+	}
+	a.warnOutsideHours(w, startsSale)
+	htmx.Trigger(w, "cartUpdated", "scrollCartToBottom", "closeModal")
+}
+
+// SaveCustomProductHandler adds a custom cart line to the catalog, so an item rung up often
+// doesn't have to be typed again
+func (a *App) SaveCustomProductHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Error parsing form", http.StatusBadRequest)
+		return
+	}
+
+	index, err := strconv.Atoi(r.FormValue("index"))
+	if err != nil {
+		index = -1
+	}
+	product, err := services.SaveCustomProduct(a.cart(r), index)
+	if err != nil {
+		utils.Error("products", "Error saving custom item as a product", "index", index, "error", err)
+		setToastText(w, "error", err.Error())
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	utils.Info("audit", "Custom item saved as a product", "product_id", product.ID, "name", product.Name, "price", product.Price, "user", currentUsername(r))
+	setToast(w, "success", "toast.custom_item_saved", product.Name)
+	htmx.Trigger(w, "cartUpdated", "categoryChanged")
 }
 
 // RemoveFromCartHandler removes an item from the cart
-func RemoveFromCartHandler(w http.ResponseWriter, r *http.Request) {
+func (a *App) RemoveFromCartHandler(w http.ResponseWriter, r *http.Request) {
+	cart := a.cart(r)
 	if err := r.ParseForm(); err != nil {
 		http.Error(w, "Error parsing form", http.StatusBadRequest)
 		return
 	}
 
+	// Removing items could leave a split sale with more captured than it costs
+	if services.SplitPaymentInProgress(cart) {
+		setToast(w, "warning", "toast.split_blocks_remove")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
 	indexStr := r.FormValue("index")
 	index, err := strconv.Atoi(indexStr)
-	if err != nil || index < 0 || index >= len(services.AppState.CurrentCart) {
+	if err != nil {
+		index = -1
+	}
+
+	// Remove item at index
+	if _, removed := cart.Remove(index); !removed {
+		http.Error(w, "Invalid index", http.StatusBadRequest)
+		return
+	}
+	cart.Touch()
+	htmx.Trigger(w, "cartUpdated")
+}
+
+// EditCartPriceHandler overrides the price of a single cart item for this sale.
+// GET shows the override form; POST applies the new price and reason to the cart item only,
+// leaving the catalog product unchanged.
+func (a *App) EditCartPriceHandler(w http.ResponseWriter, r *http.Request) {
+	cart := a.cart(r)
+	if !a.Config.AllowPriceOverrides {
+		setToast(w, "warning", "toast.price_overrides_disabled")
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	if services.SplitPaymentInProgress(cart) {
+		setToast(w, "warning", "toast.split_blocks_price")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Error parsing form", http.StatusBadRequest)
+		return
+	}
+
+	index, err := strconv.Atoi(r.FormValue("index"))
+	if err != nil {
+		index = -1
+	}
+	item, ok := cart.Item(index)
+	if !ok {
 		http.Error(w, "Invalid index", http.StatusBadRequest)
 		return
 	}
 
-	// Remove item at index
-	services.AppState.CurrentCart = append(
-		services.AppState.CurrentCart[:index],
-		services.AppState.CurrentCart[index+1:]...)
-	w.Header().Set("HX-Trigger", "cartUpdated")
+	// A return's amount is checked against the original sale when it is added
+	if item.ReturnOf != "" {
+		setToast(w, "warning", "toast.return_amount_locked")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		if err := renderInfoModal(w, r, pos.EditPriceModal(index, item)); err != nil {
+			utils.Error("cart", "Error rendering edit price modal", "error", err)
+		}
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// validation.Price turns away NaN, infinities and fractions of a cent, which would
+	// otherwise reach the cart summary and the Stripe amounts
+	price, err := validation.Price(r.FormValue("price"), 0)
+	if err != nil {
+		setToastText(w, "warning", err.Error())
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if price == 0 {
+		setToast(w, "warning", "toast.price_positive")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	reason := strings.TrimSpace(r.FormValue("reason"))
+	if reason == "" {
+		setToast(w, "warning", "toast.price_reason_required")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	// Keep the catalog price from the first override so repeated edits are still audited against it
+	originalPrice := item.Price
+	if item.OverrideReason != "" {
+		originalPrice = item.OriginalPrice
+	}
+
+	// A measured line's new price is per unit, so the quantity still prices the line
+	item, ok = cart.Update(index, func(item *templates.Product) {
+		item.OriginalPrice = originalPrice
+		if services.IsMeasuredLine(*item) {
+			services.SetMeasuredUnitPrice(item, price)
+		} else {
+			item.Price = price
+		}
+		item.OverrideReason = reason
+	})
+	if !ok {
+		http.Error(w, "Invalid index", http.StatusBadRequest)
+		return
+	}
+	cart.Touch()
+
+	utils.Info("audit", "Cart price overridden",
+		"product", item.Name, "product_id", item.ID, "original_price", originalPrice, "new_price", item.Price, "reason", reason, "user", currentUsername(r))
+
+	htmx.Trigger(w, "cartUpdated", "closeModal")
+	w.WriteHeader(http.StatusOK)
+}
+
+// EditCartDescriptionHandler replaces the description of a single cart item for this sale.
+// GET shows the form; POST applies the description to the cart item only, leaving the catalog
+// product unchanged. An empty description restores the catalog description.
+func (a *App) EditCartDescriptionHandler(w http.ResponseWriter, r *http.Request) {
+	cart := a.cart(r)
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Error parsing form", http.StatusBadRequest)
+		return
+	}
+
+	index, err := strconv.Atoi(r.FormValue("index"))
+	if err != nil {
+		index = -1
+	}
+	item, ok := cart.Item(index)
+	if !ok {
+		http.Error(w, "Invalid index", http.StatusBadRequest)
+		return
+	}
+
+	// The description names the gift card the line loads
+	if item.GiftCardCode != "" {
+		setToast(w, "warning", "toast.gift_card_description_locked")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		if err := renderInfoModal(w, r, pos.EditDescriptionModal(index, item)); err != nil {
+			utils.Error("cart", "Error rendering edit description modal", "error", err)
+		}
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	description := services.SanitizeLineDescription(r.FormValue("description"))
+	item, ok = cart.Update(index, func(item *templates.Product) {
+		// Keep the catalog description from the first edit so it can be restored
+		if !item.DescriptionEdited {
+			item.OriginalDescription = item.Description
+		}
+
+		if description == "" || description == item.OriginalDescription {
+			item.Description = item.OriginalDescription
+			item.OriginalDescription = ""
+			item.DescriptionEdited = false
+		} else {
+			item.Description = description
+			item.DescriptionEdited = true
+		}
+	})
+	if !ok {
+		http.Error(w, "Invalid index", http.StatusBadRequest)
+		return
+	}
+	cart.Touch()
+
+	utils.Info("cart", "Cart item description changed", "product", item.Name, "product_id", item.ID, "edited", item.DescriptionEdited)
+
+	htmx.Trigger(w, "cartUpdated", "closeModal")
+	w.WriteHeader(http.StatusOK)
 }
 
 // TriggerCartUpdateHandler sends a cartUpdated event to refresh the cart display
 // This is used by SSE events when payment completes to refresh the cart
-func TriggerCartUpdateHandler(w http.ResponseWriter, r *http.Request) {
+func (a *App) TriggerCartUpdateHandler(w http.ResponseWriter, r *http.Request) {
 	utils.Debug("cart", "Triggering cart update event")
-	w.Header().Set("HX-Trigger", "cartUpdated")
+	htmx.Trigger(w, "cartUpdated")
 	w.WriteHeader(http.StatusOK)
 }
@@ -1,74 +1,43 @@
 package handlers
 
 import (
-	"fmt"
 	"net/http"
+	"time"
 
+	"checkout/handlers/htmx"
+	"checkout/i18n"
 	"checkout/services"
+	"checkout/templates/checkout"
 	"checkout/templates/pos"
 	"checkout/utils"
-
-	"github.com/stripe/stripe-go/v74/terminal/reader"
 )
 
-// POSHandler renders the main Point of Sale page.
-// It now also handles the logic for selecting a default terminal reader.
-func POSHandler(w http.ResponseWriter, r *http.Request) {
-	availableReaders := services.AppState.SiteStripeReaders
-	currentSelectedReaderID := services.AppState.SelectedReaderID
-	isCurrentSelectionValid := false
-
-	if currentSelectedReaderID != "" {
-		for _, reader := range availableReaders {
-			if reader.ID == currentSelectedReaderID {
-				isCurrentSelectionValid = true
-				break
-			}
-		}
+// POSHandler renders the main Point of Sale page, with the reader this register's payments go to
+// selected. A register that isn't on the reader it picked is warned by the payment alerts banner.
+func (a *App) POSHandler(w http.ResponseWriter, r *http.Request) {
+	availableReaders := services.Terminal.Readers()
+	choice := selectedReader(w, r)
+	if choice.Fallback != "" {
+		utils.Warn("pos", "Register is not using the reader it picked", "reader_id", choice.ReaderID,
+			"picked_reader_id", choice.Remembered, "reason", choice.Fallback)
+	} else if choice.ReaderID == "" {
+		utils.Warn("pos", "No readers available to select")
 	}
 
-	if !isCurrentSelectionValid {
-		newSelectedReaderID := ""
-		// Try to find the first online reader
-		for _, reader := range availableReaders {
-			if reader.Status == "online" {
-				newSelectedReaderID = reader.ID
-				break
-			}
-		}
-		// If no online reader, and readers are available, select the first one
-		if newSelectedReaderID == "" && len(availableReaders) > 0 {
-			newSelectedReaderID = availableReaders[0].ID
-		}
-
-		if newSelectedReaderID != "" {
-			utils.Debug("pos", "Defaulting to reader due to invalid selection",
-				"new_reader_id", newSelectedReaderID, "previous_reader_id", currentSelectedReaderID)
-			services.AppState.SelectedReaderID = newSelectedReaderID
-			currentSelectedReaderID = newSelectedReaderID
-		} else if len(availableReaders) > 0 {
-			// This case means a reader was selected (first in list) but might be offline.
-			// services.AppState.SelectedReaderID would have been set above.
-			// currentSelectedReaderID is already updated.
-			utils.Warn("pos", "No online readers available - using first reader", "reader_id", currentSelectedReaderID)
-		} else {
-			utils.Warn("pos", "No readers available to select")
-			// currentSelectedReaderID remains ""
-			services.AppState.SelectedReaderID = "" // Ensure it's cleared if no readers
-		}
-	} else {
-		utils.Debug("pos", "Using previously selected valid reader", "reader_id", currentSelectedReaderID)
-	}
+	// A refresh during a payment goes straight back to its progress rather than inviting a second charge
+	_, resumePayment := a.resumablePayment(a.cart(r))
 
-	component := pos.Page(availableReaders, currentSelectedReaderID)
+	component := pos.Page(availableReaders, choice.ReaderID, resumePayment)
 	if err := component.Render(r.Context(), w); err != nil {
 		utils.Error("pos", "Error rendering POS layout", "error", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 	}
 }
 
-// SetSelectedReaderHandler handles the request to change the currently selected Stripe Terminal reader.
-func SetSelectedReaderHandler(w http.ResponseWriter, r *http.Request) {
+// SetSelectedReaderHandler sets the Stripe Terminal reader this register's payments go to and
+// remembers it across restarts. The reader dropdown is swapped for the new selection, and the
+// payment alerts refreshed so a fallback warning goes away.
+func (a *App) SetSelectedReaderHandler(w http.ResponseWriter, r *http.Request) {
 	if err := r.ParseForm(); err != nil {
 		utils.Error("pos", "Error parsing form in SetSelectedReaderHandler", "error", err)
 		http.Error(w, "Error parsing form", http.StatusBadRequest)
@@ -78,14 +47,15 @@ func SetSelectedReaderHandler(w http.ResponseWriter, r *http.Request) {
 	readerID := r.FormValue("reader_id")
 	if readerID == "" {
 		utils.Warn("pos", "SetSelectedReaderHandler called with empty reader_id")
-		w.Header().Set("HX-Trigger", `{"showToast": "No reader ID provided"}`)
+		setToast(w, "error", "toast.no_reader_id")
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 
 	isValidReader := false
 	var selectedReaderLabel string
-	for _, reader := range services.AppState.SiteStripeReaders {
+	readers := services.Terminal.Readers()
+	for _, reader := range readers {
 		if reader.ID == readerID {
 			isValidReader = true
 			selectedReaderLabel = reader.Label
@@ -98,65 +68,180 @@ func SetSelectedReaderHandler(w http.ResponseWriter, r *http.Request) {
 
 	if !isValidReader {
 		utils.Warn("pos", "Invalid reader_id provided to SetSelectedReaderHandler", "reader_id", readerID)
-		w.Header().Set("HX-Trigger", `{"showToast": "Invalid reader selected"}`)
+		setToast(w, "error", "toast.invalid_reader")
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 
-	services.AppState.SelectedReaderID = readerID
-	utils.Info("pos", "Stripe Terminal reader selected", "reader_id", readerID, "reader_label", selectedReaderLabel)
+	device := deviceID(w, r)
+	if err := services.RememberReader(device, readerID); err != nil {
+		// The selection still applies until the next restart
+		utils.Error("pos", "Error saving selected reader", "reader_id", readerID, "error", err)
+	}
+	utils.Info("pos", "Stripe Terminal reader selected", "reader_id", readerID, "reader_label", selectedReaderLabel, "device", device)
+
+	setToast(w, "success", "toast.reader_selected", selectedReaderLabel)
+	htmx.Trigger(w, "paymentAlertsChanged")
+	if err := pos.ReaderSelect(readers, readerID).Render(r.Context(), w); err != nil {
+		utils.Error("pos", "Error rendering reader select", "error", err)
+	}
+}
 
-	toastMessage := fmt.Sprintf("Reader '%s' selected.", selectedReaderLabel)
-	w.Header().Set("HX-Trigger", fmt.Sprintf(`{"showToast": {"message": %q, "type": "success"}}`, toastMessage))
+// SetLocationHandler switches the active Stripe Terminal Location.
+// Readers are reloaded for the new location, so the page is refreshed to show them.
+func (a *App) SetLocationHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		utils.Error("pos", "Error parsing form in SetLocationHandler", "error", err)
+		http.Error(w, "Error parsing form", http.StatusBadRequest)
+		return
+	}
+
+	locationID := r.FormValue("location_id")
+	if locationID == "" {
+		setToast(w, "error", "toast.no_location_id")
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// Don't strand a payment in progress on the previous location's reader
+	if len(a.Payments.GetStatesByType("terminal")) > 0 {
+		setToast(w, "warning", "toast.payment_blocks_location")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	location, err := services.SelectStripeLocation(locationID)
+	if err != nil && location.ID == "" {
+		utils.Warn("pos", "Invalid location_id provided to SetLocationHandler", "location_id", locationID, "error", err)
+		setToast(w, "error", "toast.invalid_location")
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		// The switch still applies for this session
+		utils.Error("pos", "Error saving selected location", "location_id", locationID, "error", err)
+	}
+
+	w.Header().Set("HX-Refresh", "true")
 	w.WriteHeader(http.StatusOK)
-	// Optionally, could also trigger a refresh of a part of the page if needed,
-	// but for now, just a toast. The POSHandler will pick up the new selection on next full page load/navigation.
-	// To make the dropdown visually update immediately without full reload, it would need its own HX-Target.
 }
 
-// ClearTerminalTransactionHandler handles clearing any pending terminal transactions
-func ClearTerminalTransactionHandler(w http.ResponseWriter, r *http.Request) {
+// ClearTerminalTransactionHandler recovers a stuck reader: it cancels the reader's action and
+// the payments waiting on it, and tells their payment modals they were cancelled. The cart and
+// payments on other readers or QR codes are left alone; ClearCartHandler starts over instead.
+func (a *App) ClearTerminalTransactionHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	selectedReaderID := services.AppState.SelectedReaderID
+	selectedReaderID := selectedReader(w, r).ReaderID
 	if selectedReaderID == "" {
-		w.Header().Set("HX-Trigger", `{"showToast": "No terminal reader selected"}`)
+		setToast(w, "warning", "toast.no_reader_selected")
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 
-	// Cancel any pending payment intents on the terminal reader
-	// This attempts to cancel any ongoing transaction
-	_, err := reader.CancelAction(selectedReaderID, nil)
-	if err != nil {
+	if _, err := a.Stripe.CancelReaderAction(selectedReaderID); err != nil {
+		// Usually the reader had no action to cancel; its payments are still checked below
 		utils.Warn("pos", "Error canceling terminal action during clear", "reader_id", selectedReaderID, "error", err)
-		// Even if there's an error (e.g., no action to cancel), we'll still clear our internal state
 	}
 
-	// Clear any pending payment intent or transaction state using unified state manager
-	// This clears all payment states and the cart
-	GlobalPaymentStateManager.ClearAllAndClearCart()
+	cancelled, failed := a.cancelReaderPayments(selectedReaderID)
+
+	// A cancelled tender of a split sale goes back to the split form's balance
+	if cancelled > 0 && services.SplitPaymentInProgress(a.cart(r)) {
+		a.cart(r).SetSplitPending(0)
+	}
+
+	utils.Info("pos", "Terminal reader cleared", "reader_id", selectedReaderID, "cancelled", cancelled, "failed", failed, "user", currentUsername(r))
+
+	switch {
+	case failed > 0:
+		setToast(w, "warning", "toast.reader_cancel_failed", failed)
+	case cancelled > 0:
+		setToast(w, "success", "toast.reader_cleared", cancelled)
+	default:
+		setToast(w, "success", "toast.reader_nothing_pending")
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// cancelReaderPayments cancels the PaymentIntents of the payments waiting on a reader and sends
+// their payment modals the cancellation. A payment whose intent can't be cancelled may have gone
+// through, so it stays tracked for the status checks to settle. Returns how many were cancelled
+// and how many could not be.
+func (a *App) cancelReaderPayments(readerID string) (cancelled, failed int) {
+	for _, state := range a.Payments.TerminalPaymentsForReader(readerID) {
+		intentID := state.PaymentIntentID
+		if _, err := a.Stripe.CancelPaymentIntent(intentID); err != nil {
+			utils.Error("pos", "Error cancelling PaymentIntent while clearing reader", "payment_intent_id", intentID, "reader_id", readerID, "error", err)
+			failed++
+			continue
+		}
+
+		if a.Payments.Conclude(intentID) {
+			_ = a.Events.LogPaymentEventFromState(state, PaymentEventCancelled, "")
+		}
+		a.Payments.RemovePayment(intentID)
+		a.SSE.BroadcastModalUpdate(intentID, checkout.TerminalInteractionResultModal(
+			i18n.T("status.cancelled_title"),
+			i18n.T("status.cancelled_message"),
+			intentID,
+			true, // hasCloseButton
+			"",   // no additional message
+		))
+		a.SSE.RemoveConnection(intentID)
+		cancelled++
+	}
+	return cancelled, failed
+}
+
+// ClearCartHandler starts the sale over: the selected reader's action is cancelled and every
+// payment state and the cart are dropped
+func (a *App) ClearCartHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// A split sale with captured tenders keeps its cart so those payments aren't lost track of
+	if services.SplitPaymentInProgress(a.cart(r)) {
+		setToast(w, "warning", "toast.clear_cart_split_open")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if readerID := selectedReader(w, r).ReaderID; readerID != "" {
+		if _, err := a.Stripe.CancelReaderAction(readerID); err != nil {
+			utils.Warn("pos", "Error canceling terminal action during cart clear", "reader_id", readerID, "error", err)
+		}
+	}
 
-	utils.Info("pos", "Terminal transaction cleared", "reader_id", selectedReaderID)
+	a.Payments.ClearCartAndPayments(a.cart(r))
+	utils.Info("audit", "Cart cleared", "user", currentUsername(r))
 
-	w.Header().Set("HX-Trigger", `{"showToast": "Terminal transaction cleared successfully"}`)
+	setToast(w, "success", "toast.cart_cleared")
+	htmx.Trigger(w, "cartUpdated")
 	w.WriteHeader(http.StatusOK)
 }
 
 // CustomProductFormHandler renders the custom product form modal
-func CustomProductFormHandler(w http.ResponseWriter, r *http.Request) {
+func (a *App) CustomProductFormHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	component := pos.CustomProductModal()
+	component := pos.CustomProductModal(services.RecentCustomProducts(time.Now()))
 
 	// Set the trigger to show the modal
-	w.Header().Set("HX-Trigger", "showModal")
+	htmx.ShowModal(w)
 
 	if err := component.Render(r.Context(), w); err != nil {
 		utils.Error("pos", "Error rendering custom product modal", "error", err)
@@ -0,0 +1,189 @@
+package handlers
+
+import (
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stripe/stripe-go/v74"
+
+	"checkout/config"
+	"checkout/services"
+	"checkout/templates"
+)
+
+// useCatalog replaces the product catalog for the test
+func useCatalog(t *testing.T, products ...templates.Product) {
+	t.Helper()
+	saved := services.Catalog.Products()
+	services.Catalog.Set(products)
+	t.Cleanup(func() { services.Catalog.Set(saved) })
+}
+
+func TestSessionsRingUpTheirOwnCarts(t *testing.T) {
+	app, _, _ := newTestApp(t)
+	useCatalog(t,
+		templates.Product{ID: "coffee", Name: "Coffee", Price: 4.50},
+		templates.Product{ID: "bagel", Name: "Bagel", Price: 3.25},
+	)
+
+	// Each register adds two items and removes one per round, all at the same time
+	sessions := []string{"register-1", "register-2", "register-3"}
+	var wg sync.WaitGroup
+	for i, session := range sessions {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 10 * (i + 1) {
+				postFormAs(session, app.AddToCartHandler, "/add-to-cart", url.Values{"id": {"coffee"}})
+				postFormAs(session, app.AddToCartHandler, "/add-to-cart", url.Values{"id": {"bagel"}})
+				postFormAs(session, app.RemoveFromCartHandler, "/remove-from-cart", url.Values{"index": {"0"}})
+			}
+		}()
+	}
+	wg.Wait()
+
+	for i, session := range sessions {
+		if got, want := app.Carts.Get(sessionCartKey(session)).Len(), 10*(i+1); got != want {
+			t.Errorf("%s cart has %d items, want %d", session, got, want)
+		}
+	}
+}
+
+func TestPaymentClearsOnlyItsSessionsCart(t *testing.T) {
+	app, fake, _ := newTestApp(t)
+	addToCart(app, "Coffee", 4.50)
+	other := app.Carts.Get(sessionCartKey("register-2"))
+	other.Add(templates.Product{ID: "bagel", Name: "Bagel", Price: 3.25})
+	intentID := startTerminalPayment(t, app)
+
+	// The other register doesn't see the payment as its own
+	if _, ok := app.resumablePayment(other); ok {
+		t.Errorf("register-2 was offered register-1's payment to resume")
+	}
+	postFormAs("register-2", app.ClearCartHandler, "/clear-cart", url.Values{})
+	if _, tracked := app.Payments.GetPayment(intentID); !tracked {
+		t.Fatalf("clearing register-2's cart dropped register-1's payment")
+	}
+	other.Add(templates.Product{ID: "juice", Name: "Juice", Price: 5.00})
+
+	fake.SetIntentStatus(intentID, stripe.PaymentIntentStatusSucceeded)
+	if result := app.checkTerminalPaymentStatus(intentID); !result.ShouldStop {
+		t.Fatalf("terminal payment didn't complete")
+	}
+	if sessionCart(app).Len() != 0 {
+		t.Errorf("paid cart has %d items, want 0", sessionCart(app).Len())
+	}
+	if items := other.Items(); len(items) != 1 || items[0].Name != "Juice" {
+		t.Errorf("register-2 cart = %v, want its juice untouched", items)
+	}
+}
+
+func TestIdleSweepHoldsOnlyCartsBeingPaid(t *testing.T) {
+	app, _, clock := newTestApp(t)
+	config.Config.CartIdleTimeoutMinutes = 5
+	addToCart(app, "Coffee", 4.50)
+	startTerminalPayment(t, app)
+	idle := app.Carts.Get(sessionCartKey("register-2"))
+	idle.Add(templates.Product{ID: "bagel", Name: "Bagel", Price: 3.25})
+
+	now := clock.Now()
+	for _, cart := range app.Carts.All() {
+		app.sweepIdleCart(cart, now)
+	}
+	later := now.Add(config.GetCartIdleTimeout() + time.Second)
+	for _, cart := range app.Carts.All() {
+		app.sweepIdleCart(cart, later)
+	}
+
+	if sessionCart(app).Len() != 1 {
+		t.Errorf("cart being paid for was swept")
+	}
+	if idle.Len() != 0 {
+		t.Errorf("idle cart of register-2 still has %d items", idle.Len())
+	}
+	if !idle.TakeIdleNotice() || sessionCart(app).TakeIdleNotice() {
+		t.Errorf("idle notice left for the wrong register")
+	}
+}
+
+func TestLogoutDropsSessionCart(t *testing.T) {
+	app, _, _ := newTestApp(t)
+	addToCart(app, "Coffee", 4.50)
+	before := sessionCart(app)
+
+	postForm(app.LogoutHandler, "/logout", url.Values{})
+
+	if after := sessionCart(app); after == before || after.Len() != 0 {
+		t.Errorf("session's cart outlived its logout")
+	}
+	if got := len(app.Carts.All()); got != 1 {
+		t.Errorf("carts = %d, want only the one just asked for", got)
+	}
+}
+
+// A price override that isn't a positive number of cents leaves the line as it was
+func TestEditCartPriceRejectsInvalidPrices(t *testing.T) {
+	tests := []struct {
+		name      string
+		price     string
+		wantPrice float64
+	}{
+		{"NaN", "NaN", 4.50},
+		{"infinity", "+Inf", 4.50},
+		{"fraction of a cent", "3.999", 4.50},
+		{"zero", "0", 4.50},
+		{"negative", "-1", 4.50},
+		{"not a number", "abc", 4.50},
+		{"valid", "3.99", 3.99},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, _, _ := newTestApp(t)
+			app.Config.AllowPriceOverrides = true
+			addToCart(app, "Coffee", 4.50)
+
+			rec := postForm(app.EditCartPriceHandler, "/edit-cart-price", url.Values{
+				"index": {"0"}, "price": {tt.price}, "reason": {"Manager approved"},
+			})
+
+			item, _ := sessionCart(app).Item(0)
+			if item.Price != tt.wantPrice {
+				t.Errorf("price = %v, want %v", item.Price, tt.wantPrice)
+			}
+			if tt.wantPrice == 4.50 && toastMessage(rec) == "" {
+				t.Errorf("no warning for price %q", tt.price)
+			}
+		})
+	}
+}
+
+// Two cashiers browse the catalog at once without moving each other's product list
+func TestSessionsNavigateCategoriesSeparately(t *testing.T) {
+	app, _, _ := newTestApp(t)
+	useCatalog(t,
+		templates.Product{ID: "latte", Name: "Latte", Price: 4.50, Category: "Drinks"},
+		templates.Product{ID: "bagel", Name: "Bagel", Price: 3.25, Category: "Food"},
+	)
+
+	postFormAs("register-1", app.NavigateCategoryHandler, "/navigate-category", url.Values{"path": {"Drinks"}})
+	postFormAs("register-2", app.NavigateCategoryHandler, "/navigate-category", url.Values{"path": {"Food"}})
+
+	tests := []struct {
+		session string
+		want    string
+		notWant string
+	}{
+		{"register-1", "Latte", "Bagel"},
+		{"register-2", "Bagel", "Latte"},
+		{"register-3", "Drinks", "Latte"},
+	}
+	for _, tt := range tests {
+		body := postFormAs(tt.session, app.ProductsHandler, "/products", url.Values{}).Body.String()
+		if !strings.Contains(body, tt.want) || strings.Contains(body, tt.notWant) {
+			t.Errorf("%s shows %s, want %s and not %s", tt.session, body, tt.want, tt.notWant)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"checkout/config"
+	"checkout/services"
+	"checkout/templates/reports"
+	"checkout/utils"
+)
+
+// defaultProductReportDays is the range shown when the report is opened without dates
+const defaultProductReportDays = 7
+
+// ProductReportHandler shows sales by product and by category between the from and to dates
+// (YYYY-MM-DD, default the last week), sorted by the sort parameter. format=csv downloads the
+// report and format=json returns it with a per-day breakdown.
+func (a *App) ProductReportHandler(w http.ResponseWriter, r *http.Request) {
+	location := config.GetBusinessLocation()
+	query := r.URL.Query()
+
+	to := config.BusinessDay(time.Now())
+	if date := query.Get("to"); date != "" {
+		parsed, err := time.ParseInLocation("2006-01-02", date, location)
+		if err != nil {
+			http.Error(w, "Invalid to date, expected YYYY-MM-DD", http.StatusBadRequest)
+			return
+		}
+		to = parsed
+	}
+	from := to.AddDate(0, 0, -(defaultProductReportDays - 1))
+	if date := query.Get("from"); date != "" {
+		parsed, err := time.ParseInLocation("2006-01-02", date, location)
+		if err != nil {
+			http.Error(w, "Invalid from date, expected YYYY-MM-DD", http.StatusBadRequest)
+			return
+		}
+		from = parsed
+	}
+
+	report, err := services.BuildProductReport(from, to)
+	if err != nil {
+		utils.Warn("report", "Product report failed", "from", from.Format("2006-01-02"), "to", to.Format("2006-01-02"), "error", err)
+		errorMessage := fmt.Sprintf("Could not build the report: %s", err.Error())
+		if query.Get("format") != "" {
+			http.Error(w, errorMessage, http.StatusBadRequest)
+			return
+		}
+		report.From, report.To = from.Format("2006-01-02"), to.Format("2006-01-02")
+		if err := reports.ProductReportPage(report, "", errorMessage).Render(r.Context(), w); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	sortBy := query.Get("sort")
+	if sortBy == "" {
+		sortBy = "gross"
+	}
+	services.SortProductReport(&report, sortBy)
+
+	switch query.Get("format") {
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="product-sales-%s-to-%s.csv"`, report.From, report.To))
+		if err := services.WriteProductReportCSV(w, report); err != nil {
+			utils.Error("report", "Error exporting product report", "error", err)
+		}
+	case "json":
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(report); err != nil {
+			utils.Error("report", "Error encoding product report", "error", err)
+		}
+	default:
+		if err := reports.ProductReportPage(report, sortBy, "").Render(r.Context(), w); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}
+}
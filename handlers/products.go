@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"checkout/handlers/htmx"
+	"checkout/i18n"
+	"checkout/services"
+	"checkout/services/validation"
+	"checkout/templates"
+	"checkout/templates/pos"
+	"checkout/utils"
+)
+
+// maxProductImportSize limits the size of an uploaded catalog CSV
+const maxProductImportSize = 5 << 20
+
+// pendingProductImport is the uploaded catalog shown in the preview, applied when confirmed
+type pendingProductImport struct {
+	plan  *services.ProductImport
+	mutex sync.Mutex
+}
+
+// ProductExportHandler downloads the product catalog as CSV
+func (a *App) ProductExportHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="products-%s.csv"`, time.Now().Format("2006-01-02")))
+	if err := services.WriteProductsCSV(w, services.Catalog.Products()); err != nil {
+		utils.Error("products", "Error exporting products", "error", err)
+	}
+}
+
+// ProductImportHandler shows the catalog import form (GET), checks an uploaded CSV and
+// previews its changes (POST with a file), or applies the previewed import (POST with confirm)
+func (a *App) ProductImportHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		if err := renderInfoModal(w, r, pos.ProductCatalogModal()); err != nil {
+			utils.Error("products", "Error rendering product catalog modal", "error", err)
+		}
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxProductImportSize)
+	if err := r.ParseMultipartForm(maxProductImportSize); err != nil && err != http.ErrNotMultipart {
+		http.Error(w, "Invalid upload", http.StatusBadRequest)
+		return
+	}
+
+	a.productImport.mutex.Lock()
+	defer a.productImport.mutex.Unlock()
+
+	if r.FormValue("confirm") == "true" {
+		plan := a.productImport.plan
+		if plan == nil {
+			setToast(w, "warning", "toast.upload_again")
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		if err := services.ApplyProductImport(plan); err != nil {
+			utils.Error("products", "Product import failed", "error", err)
+			setToast(w, "error", "toast.import_failed", err.Error())
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		a.productImport.plan = nil
+
+		toast := i18n.T("toast.products_imported", plan.Count(services.ProductCreate), plan.Count(services.ProductUpdate))
+		setToastText(w, "success", toast)
+		htmx.Trigger(w, "categoryChanged", "closeModal")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		setToast(w, "warning", "toast.choose_csv")
+		w.WriteHeader(http.StatusNoContent) // Keep the upload form open
+		return
+	}
+	defer file.Close()
+
+	plan, err := services.PreviewProductImport(file)
+	if err != nil {
+		utils.Warn("products", "Rejected product import", "error", err)
+		setToastText(w, "error", err.Error())
+		w.WriteHeader(http.StatusNoContent) // Keep the upload form open
+		return
+	}
+	a.productImport.plan = plan
+
+	if err := pos.ProductImportPreview(plan).Render(r.Context(), w); err != nil {
+		utils.Error("products", "Error rendering product import preview", "error", err)
+	}
+}
+
+// SalePricesHandler shows the scheduled sale prices (GET), or schedules a sale price or removes
+// one (POST with action "add" or "remove") and shows the updated list
+func (a *App) SalePricesHandler(w http.ResponseWriter, r *http.Request) {
+	var events []string
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "Error parsing form", http.StatusBadRequest)
+			return
+		}
+		productID := r.FormValue("product_id")
+
+		if r.FormValue("action") == "remove" {
+			if err := services.RemovePriceSchedule(productID, r.FormValue("schedule_id")); err != nil {
+				utils.Error("products", "Error removing sale price", "product_id", productID, "error", err)
+				setToast(w, "error", "toast.sale_price_failed", err.Error())
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			utils.Info("audit", "Sale price removed", "product_id", productID, "schedule_id", r.FormValue("schedule_id"), "user", currentUsername(r))
+			events = append(events, "categoryChanged") // Tiles go back to the catalog price
+			break
+		}
+
+		schedule, err := addPriceSchedule(r)
+		if err != nil {
+			utils.Warn("products", "Rejected sale price", "product_id", productID, "error", err)
+			setToastText(w, "warning", err.Error())
+			w.WriteHeader(http.StatusNoContent) // Keep the form as entered
+			return
+		}
+		utils.Info("audit", "Sale price scheduled", "product_id", productID, "schedule_id", schedule.ID, "price", schedule.Price, "user", currentUsername(r))
+		setToast(w, "success", "toast.sale_price_scheduled")
+		events = append(events, "categoryChanged") // Tiles show a sale that starts now
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := renderModal(w, r, pos.SalePricesModal(services.Catalog.Products(), time.Now()), events...); err != nil {
+		utils.Error("products", "Error rendering sale prices", "error", err)
+	}
+}
+
+// addPriceSchedule reads the schedule editor's form and schedules the sale price
+func addPriceSchedule(r *http.Request) (templates.PriceSchedule, error) {
+	start, err := services.ParsePriceScheduleTime(r.FormValue("start"))
+	if err != nil {
+		return templates.PriceSchedule{}, err
+	}
+	end, err := services.ParsePriceScheduleTime(r.FormValue("end"))
+	if err != nil {
+		return templates.PriceSchedule{}, err
+	}
+	price, err := validation.Price(r.FormValue("price"), 0)
+	if err != nil {
+		return templates.PriceSchedule{}, err
+	}
+	return services.AddPriceSchedule(r.FormValue("product_id"), start, end, price, time.Now())
+}
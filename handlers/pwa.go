@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+
+	"checkout/static"
+	"checkout/templates"
+	"checkout/utils"
+)
+
+// OfflinePath is the page the service worker shows when the server can't be reached
+const OfflinePath = "/offline"
+
+// precacheExtensions are the static asset types cached by the service worker.
+// Large images are left out so installing the app stays fast on venue Wi-Fi.
+var precacheExtensions = map[string]bool{".css": true, ".js": true, ".ico": true, ".png": true}
+
+const precacheMaxFileSize = 256 * 1024
+
+// precacheURLs lists the offline page and the static assets the service worker caches on install
+func precacheURLs() []string {
+	urls := []string{OfflinePath}
+	for _, name := range static.Files() {
+		if !precacheExtensions[path.Ext(name)] {
+			continue
+		}
+		if info, err := fs.Stat(static.FS(), name); err != nil || info.Size() > precacheMaxFileSize {
+			continue
+		}
+		urls = append(urls, static.URL(name))
+	}
+	return urls
+}
+
+// ManifestHandler serves the web app manifest so the POS can be installed on tablets
+func (a *App) ManifestHandler(w http.ResponseWriter, r *http.Request) {
+	name := a.Config.BusinessName
+	if name == "" {
+		name = a.Config.WebsiteName
+	}
+	if name == "" {
+		name = "Checkout"
+	}
+
+	manifest := map[string]interface{}{
+		"name":             name,
+		"short_name":       name,
+		"start_url":        "/",
+		"scope":            "/",
+		"display":          "standalone",
+		"theme_color":      "#ffffff",
+		"background_color": "#ffffff",
+		"icons": []map[string]string{
+			{"src": static.URL("images/favicon/android-chrome-192x192.png"), "sizes": "192x192", "type": "image/png"},
+			{"src": static.URL("images/favicon/android-chrome-512x512.png"), "sizes": "512x512", "type": "image/png"},
+		},
+	}
+
+	w.Header().Set("Content-Type", "application/manifest+json")
+	if err := json.NewEncoder(w).Encode(manifest); err != nil {
+		utils.Error("pwa", "Error writing manifest", "error", err)
+	}
+}
+
+// ServiceWorkerHandler serves the service worker script with the current asset version
+func (a *App) ServiceWorkerHandler(w http.ResponseWriter, r *http.Request) {
+	precacheJSON, err := json.Marshal(precacheURLs())
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	script := strings.NewReplacer(
+		"__CACHE_VERSION__", static.Version(),
+		"__PRECACHE_URLS__", string(precacheJSON),
+		"__OFFLINE_PATH__", OfflinePath,
+	).Replace(serviceWorkerScript)
+
+	w.Header().Set("Content-Type", "application/javascript")
+	// Browsers must always revalidate the worker so a new version is picked up on deploy
+	w.Header().Set("Cache-Control", "no-cache")
+	if _, err := io.WriteString(w, script); err != nil {
+		utils.Error("pwa", "Error writing service worker", "error", err)
+	}
+}
+
+// OfflineHandler serves the branded offline page cached by the service worker
+func (a *App) OfflineHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := templates.OfflinePage(a.Config.BusinessName).Render(r.Context(), w); err != nil {
+		utils.Error("pwa", "Error rendering offline page", "error", err)
+	}
+}
+
+// serviceWorkerScript caches static assets (cache-first) and falls back to the offline page for navigations.
+// Everything else - authenticated pages, HTMX fragments, payment status and SSE - is network-only and never cached.
+const serviceWorkerScript = `const CACHE_NAME = 'checkout-__CACHE_VERSION__';
+const PRECACHE_URLS = __PRECACHE_URLS__;
+const OFFLINE_PATH = '__OFFLINE_PATH__';
+
+self.addEventListener('install', function(event) {
+	event.waitUntil(
+		caches.open(CACHE_NAME)
+			.then(function(cache) { return cache.addAll(PRECACHE_URLS); })
+			.then(function() { return self.skipWaiting(); })
+	);
+});
+
+self.addEventListener('activate', function(event) {
+	// Drop caches from previous deploys
+	event.waitUntil(
+		caches.keys().then(function(names) {
+			return Promise.all(names.filter(function(name) {
+				return name.startsWith('checkout-') && name !== CACHE_NAME;
+			}).map(function(name) { return caches.delete(name); }));
+		}).then(function() { return self.clients.claim(); })
+	);
+});
+
+self.addEventListener('fetch', function(event) {
+	const request = event.request;
+	const url = new URL(request.url);
+	if (request.method !== 'GET' || url.origin !== self.location.origin) {
+		return;
+	}
+
+	// Static assets: cache first
+	if (url.pathname.startsWith('/static/')) {
+		event.respondWith(
+			caches.match(request).then(function(cached) { return cached || fetch(request); })
+		);
+		return;
+	}
+
+	// Page loads: network only, offline page when the server is unreachable
+	if (request.mode === 'navigate') {
+		event.respondWith(
+			fetch(request).catch(function() { return caches.match(OFFLINE_PATH); })
+		);
+	}
+	// Anything else (HTMX fragments, payment status, SSE) goes straight to the network
+});
+`
@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"checkout/services"
+	"checkout/templates/checkout"
+	"checkout/utils"
+)
+
+// PaymentCardDetailsHandler renders the card details of a logged transaction for the success modal.
+// Transactions without card details (or not logged yet) render an empty fragment.
+func (a *App) PaymentCardDetailsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	transactionID := r.URL.Query().Get("id")
+	transaction, err := services.LoadTransactionByID(transactionID)
+	if err != nil {
+		utils.Debug("receipt", "No logged transaction for card details", "transaction_id", transactionID, "error", err)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if err := checkout.PaymentCardDetails(transaction).Render(r.Context(), w); err != nil {
+		utils.Error("receipt", "Error rendering card details", "transaction_id", transactionID, "error", err)
+	}
+}
+
+// ReceiptHandler serves printable receipts for completed transactions.
+// /receipt/{transactionID} renders the print view (print=1 prints it as it opens), /receipt/{transactionID}.pdf the PDF.
+func (a *App) ReceiptHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	transactionID := strings.TrimPrefix(r.URL.Path, "/receipt/")
+	transactionID, isPDF := strings.CutSuffix(transactionID, ".pdf")
+	if transactionID == "" || strings.Contains(transactionID, "/") {
+		http.NotFound(w, r)
+		return
+	}
+
+	transaction, err := services.LoadTransactionByID(transactionID)
+	if err != nil {
+		utils.Warn("receipt", "Receipt requested for unknown transaction", "transaction_id", transactionID, "error", err)
+		http.NotFound(w, r)
+		return
+	}
+
+	if isPDF {
+		pdf, err := services.GenerateReceiptPDF(transaction)
+		if err != nil {
+			utils.Error("receipt", "Error generating receipt PDF", "transaction_id", transactionID, "error", err)
+			http.Error(w, "Error generating receipt", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/pdf")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`inline; filename="receipt-%s.pdf"`, transactionID))
+		if _, err := w.Write(pdf); err != nil {
+			utils.Error("receipt", "Error writing receipt PDF", "transaction_id", transactionID, "error", err)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	autoPrint := r.URL.Query().Get("print") == "1"
+	if err := checkout.ReceiptPrintPage(transaction, autoPrint).Render(r.Context(), w); err != nil {
+		utils.Error("receipt", "Error rendering receipt page", "transaction_id", transactionID, "error", err)
+	}
+}
+
+// TicketHandler serves fulfillment tickets listing a sale's items without prices.
+// /ticket/{transactionID} renders the print view (print=1 prints it as it opens), and
+// /ticket/{transactionID}.txt plain text for an ESC/POS printer relay.
+func (a *App) TicketHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	transactionID := strings.TrimPrefix(r.URL.Path, "/ticket/")
+	transactionID, isText := strings.CutSuffix(transactionID, ".txt")
+	if transactionID == "" || strings.Contains(transactionID, "/") {
+		http.NotFound(w, r)
+		return
+	}
+
+	transaction, err := services.LoadTransactionByID(transactionID)
+	if err != nil {
+		utils.Warn("receipt", "Ticket requested for unknown transaction", "transaction_id", transactionID, "error", err)
+		http.NotFound(w, r)
+		return
+	}
+
+	if isText {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		if _, err := w.Write([]byte(services.FormatTicketText(transaction))); err != nil {
+			utils.Error("receipt", "Error writing ticket text", "transaction_id", transactionID, "error", err)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	autoPrint := r.URL.Query().Get("print") == "1"
+	if err := checkout.TicketPrintPage(transaction, services.TicketLines(transaction), autoPrint).Render(r.Context(), w); err != nil {
+		utils.Error("receipt", "Error rendering ticket page", "transaction_id", transactionID, "error", err)
+	}
+}
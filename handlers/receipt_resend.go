@@ -0,0 +1,226 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"checkout/config"
+	"checkout/handlers/htmx"
+	"checkout/services"
+	"checkout/templates"
+	"checkout/templates/pos"
+	"checkout/utils"
+)
+
+// Receipts of one sale can be resent this many times per window, so a double tap or an
+// impatient customer doesn't flood their inbox
+const (
+	receiptResendLimit  = 3
+	receiptResendWindow = time.Hour
+)
+
+// receiptResends remembers when each sale's receipt was last resent
+type receiptResends struct {
+	byCode map[string][]time.Time // Confirmation code -> resends within the window
+	mutex  sync.Mutex
+}
+
+// allow records a resend of a sale's receipt unless the limit for the window is reached
+func (rr *receiptResends) allow(confirmationCode string, now time.Time) bool {
+	rr.mutex.Lock()
+	defer rr.mutex.Unlock()
+
+	if rr.byCode == nil {
+		rr.byCode = make(map[string][]time.Time)
+	}
+
+	// Forget resends that have left the window, across all sales, so the map stays small
+	for code, times := range rr.byCode {
+		recent := times[:0]
+		for _, sent := range times {
+			if now.Sub(sent) < receiptResendWindow {
+				recent = append(recent, sent)
+			}
+		}
+		if len(recent) == 0 {
+			delete(rr.byCode, code)
+		} else {
+			rr.byCode[code] = recent
+		}
+	}
+
+	if len(rr.byCode[confirmationCode]) >= receiptResendLimit {
+		return false
+	}
+	rr.byCode[confirmationCode] = append(rr.byCode[confirmationCode], now)
+	return true
+}
+
+// ResendReceiptHandler finds a past sale to resend its receipt.
+// GET asks for the confirmation code; POST looks the sale up in the transaction logs of any
+// day and shows it with the resend form.
+func (a *App) ResendReceiptHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		if err := renderInfoModal(w, r, pos.ReceiptLookupModal()); err != nil {
+			utils.Error("receipt", "Error rendering receipt lookup", "error", err)
+		}
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Error parsing form", http.StatusBadRequest)
+		return
+	}
+
+	confirmationCode := strings.TrimSpace(r.FormValue("confirmation_code"))
+	sale, err := services.LoadTransactionByID(confirmationCode)
+	if err != nil {
+		utils.Info("receipt", "Receipt lookup found no sale", "confirmation_code", confirmationCode, "error", err)
+		setToast(w, "warning", "toast.sale_not_found")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if err := renderModal(w, r, pos.ReceiptResendModal(sale, saleRefunded(sale))); err != nil {
+		utils.Error("receipt", "Error rendering receipt resend", "confirmation_code", confirmationCode, "error", err)
+	}
+}
+
+// SendReceiptAgainHandler resends the receipt of a past sale through the receipt pipeline.
+// A new receipt record is logged next to the original one, plus a payment update noting the resend.
+func (a *App) SendReceiptAgainHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Error parsing form", http.StatusBadRequest)
+		return
+	}
+
+	confirmationCode := strings.TrimSpace(r.FormValue("confirmation_code"))
+	email := strings.TrimSpace(r.FormValue("receipt_email"))
+	phone := strings.TrimSpace(r.FormValue("receipt_phone"))
+	if !config.IsSMSEnabled() {
+		phone = ""
+	}
+
+	sale, err := services.LoadTransactionByID(confirmationCode)
+	if err != nil {
+		setToast(w, "warning", "toast.sale_not_found")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if email == "" && phone == "" {
+		setToast(w, "warning", "toast.receipt_contact_required")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	// A receipt for money that was given back could be mistaken for proof of payment
+	refunded := saleRefunded(sale)
+	override := r.FormValue("override") == "true"
+	if (sale.Voided || refunded) && !override {
+		setToast(w, "warning", "toast.receipt_resend_needs_override")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if !a.receiptResends.allow(sale.ID, time.Now()) {
+		utils.Warn("receipt", "Receipt resend limit reached", "confirmation_code", sale.ID, "user", currentUsername(r))
+		setToast(w, "warning", "toast.receipt_resend_limit", receiptResendLimit)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	email, phone, err = validateReceiptContact(email, phone)
+	if err != nil {
+		setToastText(w, "warning", err.Error())
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	sentMethod, err := sendReceipt(a.Stripe, sale.ID, email, phone, "receipt_resend")
+	if err != nil {
+		setToast(w, "error", "toast.receipt_resend_failed")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	logReceiptResend(sale, email, phone, sentMethod, currentUsername(r), refunded, override)
+
+	setToast(w, "success", "toast.receipt_sent", sentMethod)
+	htmx.CloseModal(w)
+	w.WriteHeader(http.StatusOK)
+}
+
+// ResendReceipt emails the receipt of a past sale again from the command line, through the same
+// pipeline and logs as the resend form. Like the form, it refuses a voided or refunded sale
+// unless override is set. It returns how the receipt was sent.
+func ResendReceipt(stripeClient services.StripeClient, confirmationCode, email string, override bool) (string, error) {
+	sale, err := services.LoadTransactionByID(confirmationCode)
+	if err != nil {
+		return "", fmt.Errorf("sale %s not found: %w", confirmationCode, err)
+	}
+
+	refunded := saleRefunded(sale)
+	if (sale.Voided || refunded) && !override {
+		return "", fmt.Errorf("sale %s was voided or refunded; resend with -override to send its receipt anyway", sale.ID)
+	}
+
+	email, _, err = validateReceiptContact(email, "")
+	if err != nil {
+		return "", err
+	}
+	if email == "" {
+		return "", errors.New("an email address is required")
+	}
+
+	sentMethod, err := sendReceipt(stripeClient, sale.ID, email, "", "receipt_resend")
+	if err != nil {
+		return "", err
+	}
+	logReceiptResend(sale, email, "", sentMethod, "command line", refunded, override)
+	return sentMethod, nil
+}
+
+// logReceiptResend records a resent receipt in the payment update log and the audit log
+func logReceiptResend(sale *templates.Transaction, email, phone, sentMethod, by string, refunded, override bool) {
+	notes := "Receipt resent by " + by
+	if override {
+		notes += " (voided or refunded sale, override ticked)"
+	}
+	contact := strings.Trim(email+" "+phone, " ")
+	if err := services.SavePaymentUpdateRecord(services.CreatePaymentUpdateRecord(
+		sale.ID, "receipt_resend", "", contact, "receipt_contact", "receipt_resend", notes,
+	)); err != nil {
+		utils.Error("receipt", "Error logging receipt resend", "confirmation_code", sale.ID, "error", err)
+	}
+	utils.Info("audit", "Receipt resent", "confirmation_code", sale.ID, "method", sentMethod, "voided", sale.Voided, "refunded", refunded, "user", by)
+}
+
+// saleRefunded reports whether any item of a sale has been returned
+func saleRefunded(sale *templates.Transaction) bool {
+	returned, err := services.ReturnedQuantities(sale.ID)
+	if err != nil {
+		utils.Error("receipt", "Error counting returns", "confirmation_code", sale.ID, "error", err)
+		return false
+	}
+	for _, count := range returned {
+		if count > 0 {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"checkout/config"
+	"checkout/services"
+	"checkout/templates/reports"
+	"checkout/utils"
+)
+
+// ReconciliationHandler compares a day of the transaction log with Stripe.
+// The day comes from the date parameter (YYYY-MM-DD) and defaults to today.
+func (a *App) ReconciliationHandler(w http.ResponseWriter, r *http.Request) {
+	location := config.GetBusinessLocation()
+	day := config.BusinessDay(time.Now())
+	if date := r.URL.Query().Get("date"); date != "" {
+		parsed, err := time.ParseInLocation("2006-01-02", date, location)
+		if err != nil {
+			http.Error(w, "Invalid date, expected YYYY-MM-DD", http.StatusBadRequest)
+			return
+		}
+		day = parsed
+	}
+
+	report, err := services.ReconcileDay(a.Stripe, day)
+	errorMessage := ""
+	if err != nil {
+		utils.Error("reconciliation", "Reconciliation failed", "date", day.Format("2006-01-02"), "error", err)
+		errorMessage = fmt.Sprintf("Could not reconcile with Stripe: %s", err.Error())
+	}
+
+	component := reports.ReconciliationPage(day.Format("2006-01-02"), report, errorMessage, location)
+	if err := component.Render(r.Context(), w); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// ReconciliationImportHandler writes a Stripe payment that is missing from the transaction log
+func (a *App) ReconciliationImportHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid form data", http.StatusBadRequest)
+		return
+	}
+
+	paymentID := r.FormValue("payment_id")
+	if _, err := services.ImportStripePayment(a.Stripe, paymentID); err != nil {
+		utils.Error("reconciliation", "Import of Stripe payment failed", "payment_id", paymentID, "error", err)
+		setToast(w, "error", "toast.payment_not_imported", err.Error())
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	setToast(w, "success", "toast.payment_recorded")
+	if err := reports.ReconciliationImportButton(paymentID, true).Render(r.Context(), w); err != nil {
+		utils.Error("reconciliation", "Error rendering import result", "error", err)
+	}
+}
+
+// StaleLinksHandler deactivates the QR payment links left active, except those still being paid
+func (a *App) StaleLinksHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	cleanup, err := services.CleanStalePaymentLinks(a.Stripe, time.Now(), func(paymentLinkID string) bool {
+		_, exists := a.Payments.GetPayment(paymentLinkID)
+		return exists
+	})
+	errorMessage := ""
+	if err != nil {
+		utils.Error("payment", "Stale payment link cleanup failed", "error", err)
+		errorMessage = fmt.Sprintf("Could not clean up payment links: %s", err.Error())
+	}
+	utils.Info("audit", "Stale payment links cleaned up", "deactivated", len(cleanup.Deactivated),
+		"imported", len(cleanup.Imported), "user", currentUsername(r))
+
+	if err := reports.StaleLinkCleanupResult(cleanup, errorMessage).Render(r.Context(), w); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// ReconciliationEmailHandler emails the reconciliation report for a day to the reconciliation recipients
+func (a *App) ReconciliationEmailHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid form data", http.StatusBadRequest)
+		return
+	}
+
+	day, err := time.ParseInLocation("2006-01-02", r.FormValue("date"), config.GetBusinessLocation())
+	if err != nil {
+		http.Error(w, "Invalid date, expected YYYY-MM-DD", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := services.SendReconciliationReport(a.Stripe, day); err != nil {
+		utils.Error("reconciliation", "Manual reconciliation report failed", "date", day.Format("2006-01-02"), "error", err)
+		setToast(w, "error", "toast.reconciliation_not_sent", err.Error())
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	setToast(w, "success", "toast.reconciliation_sent")
+	w.WriteHeader(http.StatusOK)
+}
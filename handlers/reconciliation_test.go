@@ -0,0 +1,25 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestReconciliationUsesAppClient(t *testing.T) {
+	app, fake, _ := newTestApp(t)
+	global := useGlobalStripe(t)
+
+	rec := httptest.NewRecorder()
+	app.ReconciliationHandler(rec, httptest.NewRequest(http.MethodGet, "/reconciliation?date=2026-03-14", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if got := fake.Calls("ListCheckoutSessions") + fake.Calls("ListPaymentIntents"); got != 2 {
+		t.Errorf("App client list calls = %d, want 2", got)
+	}
+	if got := global.Calls("ListCheckoutSessions") + global.Calls("ListPaymentIntents"); got != 0 {
+		t.Errorf("package-level client was called %d times", got)
+	}
+}
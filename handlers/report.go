@@ -0,0 +1,30 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"checkout/config"
+	"checkout/services"
+	"checkout/utils"
+)
+
+// SendDailyReportHandler emails today's daily report immediately.
+// Manual sends don't count as the scheduled send, so the close-of-business report still goes out.
+func (a *App) SendDailyReportHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	today := config.BusinessDay(time.Now())
+	if err := services.SendDailyReport(today); err != nil {
+		utils.Error("report", "Manual daily report failed", "date", today.Format("2006-01-02"), "error", err)
+		setToast(w, "error", "toast.daily_report_not_sent", err.Error())
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	setToast(w, "success", "toast.daily_report_sent")
+	w.WriteHeader(http.StatusOK)
+}
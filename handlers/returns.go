@@ -0,0 +1,188 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"checkout/config"
+	"checkout/handlers/htmx"
+	"checkout/i18n"
+	"checkout/services"
+	"checkout/templates"
+	"checkout/templates/checkout"
+	"checkout/templates/pos"
+	"checkout/utils"
+)
+
+// ReturnItemsHandler starts a return or exchange.
+// GET asks for the original sale's confirmation code; POST looks the sale up and lists its items.
+func (a *App) ReturnItemsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		if err := renderInfoModal(w, r, pos.ReturnLookupModal()); err != nil {
+			utils.Error("payment", "Error rendering return lookup", "error", err)
+		}
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Error parsing form", http.StatusBadRequest)
+		return
+	}
+
+	confirmationCode := strings.TrimSpace(r.FormValue("confirmation_code"))
+	sale, err := services.LoadTransactionByID(confirmationCode)
+	if err != nil {
+		utils.Info("payment", "Return lookup found no sale", "confirmation_code", confirmationCode, "error", err)
+		setToast(w, "warning", "toast.sale_not_found")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if sale.Voided {
+		setToast(w, "warning", "toast.sale_voided")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	a.renderReturnItems(w, r, sale)
+}
+
+// AddReturnHandler adds one unit of an item from the original sale to the cart as a return line
+func (a *App) AddReturnHandler(w http.ResponseWriter, r *http.Request) {
+	cart := a.cart(r)
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Error parsing form", http.StatusBadRequest)
+		return
+	}
+
+	// Changing the cart could leave a split sale with more captured than it costs
+	if services.SplitPaymentInProgress(cart) {
+		setToast(w, "warning", "toast.split_blocks_returns")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	originalID := r.FormValue("original_id")
+	index, err := strconv.Atoi(r.FormValue("index"))
+	if err != nil {
+		http.Error(w, "Invalid item index", http.StatusBadRequest)
+		return
+	}
+	amount, _ := strconv.ParseFloat(strings.TrimSpace(r.FormValue("amount")), 64)
+
+	line, err := services.AddReturnToCart(cart, originalID, index, amount)
+	if err != nil {
+		setToastText(w, "warning", err.Error())
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	cart.Touch()
+	utils.Info("audit", "Return added to cart", "original_id", originalID, "item", line.Name, "refund", -line.Price, "user", currentUsername(r))
+
+	sale, err := services.LoadTransactionByID(originalID)
+	if err != nil {
+		htmx.Trigger(w, "closeModal", "cartUpdated")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	a.renderReturnItems(w, r, sale, "cartUpdated")
+}
+
+// CompleteReturnHandler finishes an exchange whose returns cover the cart.
+// Any excess is refunded to the original sale's payment before the exchange is logged;
+// carts where the customer owes money go through the regular payment methods instead.
+func (a *App) CompleteReturnHandler(w http.ResponseWriter, r *http.Request) {
+	cart := a.cart(r)
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	originalID := services.CartReturnOriginalID(cart)
+	if originalID == "" {
+		setToast(w, "warning", "toast.no_returned_items")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	summary := services.CalculateCartSummary(cart)
+	if summary.Total > 0.005 {
+		setToast(w, "warning", "toast.customer_owes", i18n.Money(summary.Total))
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	refund := "no refund due"
+	if excess := -summary.Total; excess > 0.005 {
+		var err error
+		refund, err = services.RefundReturn(a.Stripe, originalID, excess)
+		if err != nil {
+			utils.Error("payment", "Error refunding return", "original_id", originalID, "amount", excess, "refunded", refund, "error", err)
+			if refund != "" {
+				setToast(w, "error", "toast.refund_partial", refund)
+			} else {
+				setToast(w, "error", "toast.refund_error")
+			}
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+	}
+
+	paymentID := services.NewPaymentID()
+	_ = a.Events.LogPaymentEvent(
+		cart,
+		paymentID,
+		PaymentEventSuccess,
+		services.ReturnPaymentMethod,
+		cart.Items(),
+		summary,
+		"",
+	)
+	utils.Info("audit", "Return completed", "payment_id", paymentID, "original_id", originalID, "total", summary.Total, "refund", refund, "user", currentUsername(r))
+
+	cart.Clear()
+
+	setToast(w, "success", "toast.return_completed", refund)
+	if err := renderModal(w, r, checkout.PaymentSuccess(paymentID, config.GetCheckoutFlow()), "cartUpdated"); err != nil {
+		utils.Error("payment", "Error rendering return success modal", "payment_id", paymentID, "error", err)
+	}
+}
+
+// rejectNonPositiveTotal stops card payments for carts whose returns cover the total;
+// those are finished with Complete Return. Returns true if the request was answered.
+func rejectNonPositiveTotal(w http.ResponseWriter, cart *services.CartStore) bool {
+	if services.CalculateCartSummary(cart).Total > 0.005 {
+		return false
+	}
+	setToast(w, "warning", "toast.nothing_to_charge")
+	w.WriteHeader(http.StatusOK)
+	return true
+}
+
+// renderReturnItems shows a sale's returnable items with how many of each are already returned
+func (a *App) renderReturnItems(w http.ResponseWriter, r *http.Request, sale *templates.Transaction, events ...string) {
+	returned, err := services.ReturnedQuantities(sale.ID)
+	if err != nil {
+		utils.Error("payment", "Error counting earlier returns", "original_id", sale.ID, "error", err)
+		returned = make(map[string]int)
+	}
+	for _, product := range a.cart(r).Items() {
+		if product.ReturnOf == sale.ID {
+			returned[product.Name]++
+		}
+	}
+
+	if err := renderModal(w, r, pos.ReturnItemsModal(sale, returned), events...); err != nil {
+		utils.Error("payment", "Error rendering return items", "original_id", sale.ID, "error", err)
+	}
+}
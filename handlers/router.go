@@ -0,0 +1,200 @@
+package handlers
+
+import (
+	"net/http"
+
+	"checkout/handlers/htmx"
+	"checkout/static"
+)
+
+// NewRouter registers every route on a new mux, served by the given App
+func NewRouter(app *App) http.Handler {
+	rootMux := http.NewServeMux()
+
+	// Static files: Publicly accessible
+	rootMux.Handle("/static/", static.Handler())
+
+	// PWA: manifest, service worker and offline page must load without a session
+	rootMux.HandleFunc("/manifest.json", app.ManifestHandler)
+	rootMux.HandleFunc("/service-worker.js", app.ServiceWorkerHandler)
+	rootMux.HandleFunc(OfflinePath, app.OfflineHandler)
+
+	// Auth routes: Publicly accessible for login/logout. Logging out drops the session's cart,
+	// so another site can't do it with the login cookie.
+	rootMux.HandleFunc("/login", app.LoginHandler)
+	rootMux.Handle("/logout", app.CSRFMiddleware(http.HandlerFunc(app.LogoutHandler)))
+
+	// Stripe webhook handler: Public, but typically has its own signature verification, not session auth
+	rootMux.HandleFunc("/stripe-webhook", app.StripeWebhookHandler)
+
+	// Payment events endpoint - SSE for real-time payment updates
+	rootMux.HandleFunc("/payment-events", app.PaymentSSEHandler)
+
+	// Customer display: Public, but checks its own device token instead of a session
+	rootMux.HandleFunc("/customer-display", app.CustomerDisplayHandler)
+	rootMux.HandleFunc("/customer-display/events", app.CustomerDisplayEventsHandler)
+
+	// Health check: Public so load balancers and uptime monitors can probe it
+	rootMux.HandleFunc("/healthz", app.HealthHandler)
+
+	// Version: Public so deployment scripts can confirm which build is running
+	rootMux.HandleFunc("/version", app.VersionHandler)
+
+	// JSON API for integrations: authenticated by API key rather than the login session
+	apiMux := http.NewServeMux()
+	apiMux.HandleFunc("GET /api/v1/products", app.APIProductsHandler)
+	apiMux.HandleFunc("GET /api/v1/cart", app.APIGetCartHandler)
+	apiMux.HandleFunc("POST /api/v1/cart", app.APICreateCartHandler)
+	apiMux.HandleFunc("DELETE /api/v1/cart", app.APIClearCartHandler)
+	apiMux.HandleFunc("POST /api/v1/cart/items", app.APIAddCartItemHandler)
+	apiMux.HandleFunc("DELETE /api/v1/cart/items/{index}", app.APIRemoveCartItemHandler)
+	apiMux.HandleFunc("POST /api/v1/payments", app.APICreatePaymentHandler)
+	apiMux.HandleFunc("GET /api/v1/payments/{id}", app.APIGetPaymentHandler)
+	apiMux.HandleFunc("GET /api/v1/transactions", app.APITransactionsHandler)
+	apiMux.HandleFunc("GET /api/v1/transactions/{id}", app.APITransactionHandler)
+	apiMux.HandleFunc("/api/v1/", app.APINotFoundHandler)
+	rootMux.Handle("/api/v1/", app.APIMiddleware(apiMux))
+
+	// Application-specific routes that require authentication will go into appMux.
+	// Cashiers get the POS, cart, payments and receipts; routes wrapped in AdminOnly (settings,
+	// the product catalog, refunds and reports) are for admins. Routes wrapped in Fragment return
+	// part of the POS page and send browsers that open them directly to that part of the page.
+	appMux := http.NewServeMux()
+
+	// API routes (protected)
+	appMux.HandleFunc("/products", app.Fragment("products", app.ProductsHandler))
+	appMux.HandleFunc("/navigate-category", app.Fragment("products", app.NavigateCategoryHandler))
+	appMux.HandleFunc("/cart-items", app.Fragment("cart", app.CartItemsHandler))
+	appMux.HandleFunc("/cart-summary", app.Fragment("checkout", app.CartSummaryHandler))
+	appMux.HandleFunc("/add-to-cart", app.Fragment("cart", app.AddToCartHandler))
+	appMux.HandleFunc("/favorites/toggle", app.Fragment("products", app.ToggleFavoriteHandler))
+	appMux.HandleFunc("/favorites/reorder", app.Fragment("products", app.ReorderFavoritesHandler))
+	appMux.HandleFunc("/add-custom-product", app.Fragment("cart", app.AddCustomProductHandler))
+	appMux.HandleFunc("/scan", app.Fragment("cart", app.ScanHandler))
+	appMux.HandleFunc("/quick-charge", app.Fragment("cart", app.QuickChargeHandler))
+	appMux.HandleFunc("/create-product", app.AdminOnly(app.CreateProductHandler))
+	appMux.HandleFunc("/save-custom-product", app.Fragment("cart", app.AdminOnly(app.SaveCustomProductHandler)))
+	appMux.HandleFunc("/custom-product-form", app.Fragment("cart", app.CustomProductFormHandler))
+	appMux.HandleFunc("/products/export", app.AdminOnly(app.ProductExportHandler))
+	appMux.HandleFunc("/products/import", app.AdminOnly(app.ProductImportHandler))
+	appMux.HandleFunc("/products/sale-prices", app.AdminOnly(app.SalePricesHandler))
+	appMux.HandleFunc("/remove-from-cart", app.Fragment("cart", app.RemoveFromCartHandler))
+	appMux.HandleFunc("/edit-cart-price", app.Fragment("cart", app.EditCartPriceHandler))
+	appMux.HandleFunc("/edit-cart-description", app.Fragment("cart", app.EditCartDescriptionHandler))
+	appMux.HandleFunc("/checkout-form", app.Fragment("checkout", app.CheckoutFormHandler))
+	appMux.HandleFunc("/process-payment", app.Fragment("checkout", app.ProcessPaymentHandler))
+	appMux.HandleFunc("/generate-qr-code", app.Fragment("checkout", app.GenerateQRCodeHandler))
+	appMux.HandleFunc("/send-payment-link", app.Fragment("checkout", app.SendPaymentLinkHandler))
+	appMux.HandleFunc("/sent-links", app.SentLinksHandler)
+	appMux.HandleFunc("/sent-links/cancel", app.CancelSentLinkHandler)
+	appMux.HandleFunc("/manual-card-form", app.Fragment("checkout", app.ManualCardFormHandler))
+	appMux.HandleFunc("/confirm-manual-payment", app.Fragment("checkout", app.ConfirmManualPaymentHandler))
+	appMux.HandleFunc("/get-payment-status", app.Fragment("checkout", app.GetPaymentStatusHandler))
+	appMux.HandleFunc("/cancel-or-refresh-payment", app.Fragment("checkout", app.CancelOrRefreshPaymentHandler))
+	appMux.HandleFunc("/cancel-transaction", app.Fragment("cart", app.CancelTransactionHandler))
+	appMux.HandleFunc("/update-receipt-info", app.ReceiptInfoHandler)
+	appMux.HandleFunc("/trigger-cart-update", app.Fragment("cart", app.TriggerCartUpdateHandler))
+	appMux.HandleFunc("/receipt/", app.ReceiptHandler) // Print view and .pdf variant
+	appMux.HandleFunc("/ticket/", app.TicketHandler)   // Fulfillment ticket print view and .txt variant
+	appMux.HandleFunc("/resend-receipt", app.ResendReceiptHandler)
+	appMux.HandleFunc("/resend-receipt/send", app.SendReceiptAgainHandler)
+	appMux.HandleFunc("/terminal-email", app.TerminalEmailHandler)
+	appMux.HandleFunc("/terminal-email/cancel", app.TerminalEmailCancelHandler)
+	appMux.HandleFunc("/void-payment", app.AdminOnly(app.VoidPaymentHandler))
+	appMux.HandleFunc("/split-payment", app.Fragment("checkout", app.SplitPaymentHandler))
+	appMux.HandleFunc("/cancel-split-payment", app.Fragment("checkout", app.CancelSplitPaymentHandler))
+	appMux.HandleFunc("/return-items", app.AdminOnly(app.ReturnItemsHandler))
+	appMux.HandleFunc("/add-return", app.AdminOnly(app.AddReturnHandler))
+	appMux.HandleFunc("/complete-return", app.AdminOnly(app.CompleteReturnHandler))
+	appMux.HandleFunc("/sell-gift-card", app.SellGiftCardHandler)
+	appMux.HandleFunc("/redeem-gift-card", app.RedeemGiftCardHandler)
+	appMux.HandleFunc("/gift-cards", app.GiftCardsHandler)
+	appMux.HandleFunc("/select-tip", app.Fragment("checkout", app.SelectTipHandler))
+	appMux.HandleFunc("/sale-note", app.Fragment("checkout", app.SaleNoteHandler))
+	appMux.HandleFunc("/payment-method", app.Fragment("checkout", app.PaymentMethodHandler))
+	appMux.HandleFunc("/update-sale-note", app.Fragment("checkout", app.UpdateSaleNoteHandler))
+	appMux.HandleFunc("/payment-alerts", app.Fragment("checkout", app.PaymentAlertsHandler))
+	appMux.HandleFunc("/resume-payment", app.Fragment("checkout", app.ResumePaymentHandler))
+	appMux.HandleFunc("/idle-cart-check", app.IdleCartCheckHandler)
+	appMux.HandleFunc("/app-events", app.AppEventsHandler)
+	appMux.HandleFunc("/activity", app.Fragment("activity", app.ActivityHandler))
+	appMux.HandleFunc("/refund-duplicate-payment", app.AdminOnly(app.RefundDuplicatePaymentHandler))
+	appMux.HandleFunc("/dismiss-webhook-config", app.AdminOnly(app.DismissWebhookConfigHandler))
+	appMux.HandleFunc("/payment-card-details", app.Fragment("checkout", app.PaymentCardDetailsHandler))
+	appMux.HandleFunc("/send-daily-report", app.AdminOnly(app.SendDailyReportHandler))
+	appMux.HandleFunc("/reports/reconciliation", app.AdminOnly(app.ReconciliationHandler))
+	appMux.HandleFunc("/reports/products", app.AdminOnly(app.ProductReportHandler))
+	appMux.HandleFunc("/reports/reconciliation/import", app.AdminOnly(app.ReconciliationImportHandler))
+	appMux.HandleFunc("/reports/reconciliation/email", app.AdminOnly(app.ReconciliationEmailHandler))
+	appMux.HandleFunc("/reports/reconciliation/stale-links", app.AdminOnly(app.StaleLinksHandler))
+	appMux.HandleFunc("/close-day", app.AdminOnly(app.CloseDayHandler))
+	appMux.HandleFunc("/reports/archive", app.AdminOnly(app.ArchiveHandler))
+	appMux.HandleFunc("/reports/customer-data", app.AdminOnly(app.CustomerDataHandler))
+	appMux.HandleFunc("/tax-check", app.AdminOnly(app.TaxCheckHandler))
+	appMux.HandleFunc("/tax-check/mode", app.AdminOnly(app.TaxModeHandler))
+	appMux.HandleFunc("/stripe/purge-prices", app.AdminOnly(app.PurgePricesHandler))
+	appMux.HandleFunc("/diagnostics/readers", app.AdminOnly(app.ReaderDiagnosticsHandler))
+	appMux.HandleFunc("/diagnostics/readers/test", app.AdminOnly(app.ReaderConnectivityTestHandler))
+	appMux.HandleFunc("/diagnostics/readers/logs", app.AdminOnly(app.ReaderLogsHandler))
+
+	// Metrics: behind login unless a separate internal listener is configured
+	if app.Config.MetricsAddress == "" {
+		appMux.HandleFunc("/metrics", app.AdminOnly(app.MetricsHandler))
+	}
+
+	// Settings routes
+	appMux.HandleFunc("/settings", app.Fragment("settings", app.AdminOnly(app.SettingsHandler)))
+	appMux.HandleFunc("/api/settings/search", app.Fragment("settings", app.AdminOnly(app.SettingsSearchHandler)))
+	appMux.HandleFunc("/api/settings/update", app.Fragment("settings", app.AdminOnly(app.SettingsUpdateHandler)))
+	appMux.HandleFunc("/settings/webhook-test", app.Fragment("settings", app.AdminOnly(app.WebhookTestHandler)))
+	appMux.HandleFunc("/settings/webhook-config", app.Fragment("settings", app.AdminOnly(app.WebhookConfigHandler)))
+	appMux.HandleFunc("/settings/user-pin", app.Fragment("settings", app.AdminOnly(app.UserPINHandler)))
+	appMux.HandleFunc("/settings/locations", app.Fragment("settings", app.AdminOnly(app.TerminalLocationsHandler)))
+	appMux.HandleFunc("/settings/order-webhooks", app.Fragment("settings", app.AdminOnly(app.OrderWebhooksHandler)))
+	appMux.HandleFunc("/receipt-logo", app.AdminOnlyChanges(app.ReceiptLogoHandler)) // Receipts show the logo
+
+	// Terminal Payment Endpoints
+	appMux.HandleFunc("/clear-terminal-transaction", app.Fragment("checkout", app.ClearTerminalTransactionHandler))
+	appMux.HandleFunc("/clear-cart", app.Fragment("cart", app.ClearCartHandler))
+
+	// Demo mode: whether the simulated reader approves or declines
+	appMux.HandleFunc("/demo/outcome", app.DemoOutcomeHandler)
+
+	// POS Page specific handlers
+	appMux.HandleFunc("/set-selected-reader", app.SetSelectedReaderHandler)
+	appMux.HandleFunc("/set-location", app.SetLocationHandler)
+
+	// Modal closing endpoint (assuming it's part of the authenticated UI)
+	// If it can be public, it could also be on rootMux.
+	appMux.HandleFunc("/close-modal", func(w http.ResponseWriter, r *http.Request) {
+		// Send HX-Trigger header to close the modal
+		htmx.CloseModal(w)
+		w.WriteHeader(http.StatusOK)
+	})
+
+	// Screen lock: the lock screen, locking now, and unlocking with the password
+	appMux.HandleFunc("/lock", app.LockHandler)
+	appMux.HandleFunc("/unlock", app.UnlockHandler)
+
+	// Quick user switching with a PIN, keeping the cart and payments in progress
+	appMux.HandleFunc("/switch-user", app.Fragment("", app.SwitchUserHandler))
+
+	// Setup page: shown instead of the POS until the startup checks pass
+	appMux.HandleFunc("/setup", app.SetupHandler)
+	appMux.HandleFunc("/setup/locations", app.SetupLocationsHandler)
+	appMux.HandleFunc("/setup/location", app.AdminOnly(app.SetupLocationHandler))
+	appMux.HandleFunc("/setup/location/create", app.AdminOnly(app.SetupLocationCreateHandler))
+	appMux.HandleFunc("/setup/retry", app.SetupRetryHandler)
+
+	// Main application route (POS): Requires authentication
+	// This will handle requests to "/" after authentication.
+	appMux.HandleFunc("/", app.POSHandler)
+
+	// Apply auth middleware only to appMux routes.
+	// rootMux.Handle("/", ...) will catch all requests not already handled by rootMux
+	// (like /static/, /login, etc.) and pass them to the authedAppHandler.
+	authedAppHandler := app.AuthMiddleware(app.CSRFMiddleware(app.ScreenLockMiddleware(app.SetupMiddleware(appMux))))
+	rootMux.Handle("/", authedAppHandler)
+
+	return rootMux
+}
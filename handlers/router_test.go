@@ -0,0 +1,297 @@
+package handlers
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"checkout/config"
+	"checkout/templates"
+)
+
+// appRouteWrappers is every route behind the login, with the wrappers around its handler from
+// the outside in. Fragment(anchor) sends a browser that loads the route itself to the POS page
+// at anchor; AdminOnly refuses cashiers; AdminOnlyChanges refuses cashiers only changes.
+var appRouteWrappers = map[string]string{
+	"/products":                           "Fragment(products)",
+	"/navigate-category":                  "Fragment(products)",
+	"/cart-items":                         "Fragment(cart)",
+	"/cart-summary":                       "Fragment(checkout)",
+	"/add-to-cart":                        "Fragment(cart)",
+	"/favorites/toggle":                   "Fragment(products)",
+	"/favorites/reorder":                  "Fragment(products)",
+	"/add-custom-product":                 "Fragment(cart)",
+	"/scan":                               "Fragment(cart)",
+	"/quick-charge":                       "Fragment(cart)",
+	"/create-product":                     "AdminOnly",
+	"/save-custom-product":                "Fragment(cart) AdminOnly",
+	"/custom-product-form":                "Fragment(cart)",
+	"/products/export":                    "AdminOnly",
+	"/products/import":                    "AdminOnly",
+	"/products/sale-prices":               "AdminOnly",
+	"/remove-from-cart":                   "Fragment(cart)",
+	"/edit-cart-price":                    "Fragment(cart)",
+	"/edit-cart-description":              "Fragment(cart)",
+	"/checkout-form":                      "Fragment(checkout)",
+	"/process-payment":                    "Fragment(checkout)",
+	"/generate-qr-code":                   "Fragment(checkout)",
+	"/send-payment-link":                  "Fragment(checkout)",
+	"/sent-links":                         "",
+	"/sent-links/cancel":                  "",
+	"/manual-card-form":                   "Fragment(checkout)",
+	"/confirm-manual-payment":             "Fragment(checkout)",
+	"/get-payment-status":                 "Fragment(checkout)",
+	"/cancel-or-refresh-payment":          "Fragment(checkout)",
+	"/cancel-transaction":                 "Fragment(cart)",
+	"/update-receipt-info":                "",
+	"/trigger-cart-update":                "Fragment(cart)",
+	"/receipt/":                           "",
+	"/ticket/":                            "",
+	"/resend-receipt":                     "",
+	"/resend-receipt/send":                "",
+	"/terminal-email":                     "",
+	"/terminal-email/cancel":              "",
+	"/void-payment":                       "AdminOnly",
+	"/split-payment":                      "Fragment(checkout)",
+	"/cancel-split-payment":               "Fragment(checkout)",
+	"/return-items":                       "AdminOnly",
+	"/add-return":                         "AdminOnly",
+	"/complete-return":                    "AdminOnly",
+	"/sell-gift-card":                     "",
+	"/redeem-gift-card":                   "",
+	"/gift-cards":                         "",
+	"/select-tip":                         "Fragment(checkout)",
+	"/sale-note":                          "Fragment(checkout)",
+	"/payment-method":                     "Fragment(checkout)",
+	"/update-sale-note":                   "Fragment(checkout)",
+	"/payment-alerts":                     "Fragment(checkout)",
+	"/resume-payment":                     "Fragment(checkout)",
+	"/idle-cart-check":                    "",
+	"/app-events":                         "",
+	"/activity":                           "Fragment(activity)",
+	"/refund-duplicate-payment":           "AdminOnly",
+	"/dismiss-webhook-config":             "AdminOnly",
+	"/payment-card-details":               "Fragment(checkout)",
+	"/send-daily-report":                  "AdminOnly",
+	"/reports/reconciliation":             "AdminOnly",
+	"/reports/products":                   "AdminOnly",
+	"/reports/reconciliation/import":      "AdminOnly",
+	"/reports/reconciliation/email":       "AdminOnly",
+	"/reports/reconciliation/stale-links": "AdminOnly",
+	"/close-day":                          "AdminOnly",
+	"/reports/archive":                    "AdminOnly",
+	"/reports/customer-data":              "AdminOnly",
+	"/tax-check":                          "AdminOnly",
+	"/tax-check/mode":                     "AdminOnly",
+	"/stripe/purge-prices":                "AdminOnly",
+	"/diagnostics/readers":                "AdminOnly",
+	"/diagnostics/readers/test":           "AdminOnly",
+	"/diagnostics/readers/logs":           "AdminOnly",
+	"/metrics":                            "AdminOnly",
+	"/settings":                           "Fragment(settings) AdminOnly",
+	"/api/settings/search":                "Fragment(settings) AdminOnly",
+	"/api/settings/update":                "Fragment(settings) AdminOnly",
+	"/settings/webhook-test":              "Fragment(settings) AdminOnly",
+	"/settings/webhook-config":            "Fragment(settings) AdminOnly",
+	"/settings/user-pin":                  "Fragment(settings) AdminOnly",
+	"/settings/locations":                 "Fragment(settings) AdminOnly",
+	"/settings/order-webhooks":            "Fragment(settings) AdminOnly",
+	"/receipt-logo":                       "AdminOnlyChanges",
+	"/clear-terminal-transaction":         "Fragment(checkout)",
+	"/clear-cart":                         "Fragment(cart)",
+	"/demo/outcome":                       "",
+	"/set-selected-reader":                "",
+	"/set-location":                       "",
+	"/close-modal":                        "",
+	"/lock":                               "",
+	"/unlock":                             "",
+	"/switch-user":                        "Fragment()",
+	"/setup":                              "",
+	"/setup/locations":                    "",
+	"/setup/location":                     "AdminOnly",
+	"/setup/location/create":              "AdminOnly",
+	"/setup/retry":                        "",
+	"/":                                   "",
+}
+
+// appMiddleware is the chain every route behind the login goes through, from the outside in
+var appMiddleware = []string{"AuthMiddleware", "CSRFMiddleware", "ScreenLockMiddleware", "SetupMiddleware"}
+
+// TestRouterWrappers reads NewRouter to check that every route is registered with the wrappers
+// listed in appRouteWrappers, and that the routes behind the login go through appMiddleware
+func TestRouterWrappers(t *testing.T) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "router.go", nil, parser.SkipObjectResolution)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	registered := make(map[string]string)
+	var middleware []string
+	ast.Inspect(file, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.CallExpr:
+			if receiver, method := selector(n.Fun); receiver == "appMux" && method == "HandleFunc" {
+				path, err := strconv.Unquote(n.Args[0].(*ast.BasicLit).Value)
+				if err != nil {
+					t.Fatal(err)
+				}
+				registered[path] = strings.Join(handlerWrappers(n.Args[1]), " ")
+			}
+		case *ast.AssignStmt:
+			if ident, ok := n.Lhs[0].(*ast.Ident); ok && ident.Name == "authedAppHandler" {
+				middleware = handlerWrappers(n.Rhs[0])
+			}
+		}
+		return true
+	})
+
+	for path, want := range appRouteWrappers {
+		got, ok := registered[path]
+		switch {
+		case !ok:
+			t.Errorf("%s isn't registered", path)
+		case got != want:
+			t.Errorf("%s is wrapped in %q, want %q", path, got, want)
+		}
+	}
+	for path := range registered {
+		if _, ok := appRouteWrappers[path]; !ok {
+			t.Errorf("%s is registered but missing from appRouteWrappers", path)
+		}
+	}
+	if strings.Join(middleware, " ") != strings.Join(appMiddleware, " ") {
+		t.Errorf("routes behind the login go through %v, want %v", middleware, appMiddleware)
+	}
+}
+
+// selector returns the receiver and name of a call such as app.AdminOnly
+func selector(expr ast.Expr) (string, string) {
+	sel, ok := expr.(*ast.SelectorExpr)
+	if !ok {
+		return "", ""
+	}
+	receiver, _ := sel.X.(*ast.Ident)
+	if receiver == nil {
+		return "", sel.Sel.Name
+	}
+	return receiver.Name, sel.Sel.Name
+}
+
+// handlerWrappers lists the App methods a handler expression is wrapped in, from the outside
+// in, stopping at the handler itself. Fragment includes its anchor.
+func handlerWrappers(expr ast.Expr) []string {
+	var wrappers []string
+	for {
+		call, ok := expr.(*ast.CallExpr)
+		if !ok {
+			return wrappers
+		}
+		receiver, method := selector(call.Fun)
+		if receiver != "app" {
+			return wrappers
+		}
+		if method == "Fragment" {
+			anchor, _ := strconv.Unquote(call.Args[0].(*ast.BasicLit).Value)
+			method = "Fragment(" + anchor + ")"
+		}
+		wrappers = append(wrappers, method)
+		expr = call.Args[len(call.Args)-1]
+	}
+}
+
+// routeRequest sends a request through the router as a browser would, signed in with session
+// when it isn't empty, with the CSRF token when csrf is set and as HTMX when hx is set
+func routeRequest(router http.Handler, method, path, session string, csrf, hx bool) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	if session != "" {
+		req.AddCookie(&http.Cookie{Name: sessionCookieName, Value: session})
+	}
+	if csrf {
+		req.AddCookie(&http.Cookie{Name: csrfCookieName, Value: "route-test-token"})
+		req.Header.Set(templates.CSRFHeaderName, "route-test-token")
+	}
+	if hx {
+		req.Header.Set("HX-Request", "true")
+	}
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec
+}
+
+// TestRouterGuardsEveryRoute walks the routes behind the login through NewRouter, checking
+// that the middleware runs in order and each route's wrappers refuse or redirect as listed.
+// Only requests a guard stops are sent, so no handler runs.
+func TestRouterGuardsEveryRoute(t *testing.T) {
+	app, _, _ := newTestApp(t)
+	config.Config.Users = []templates.User{
+		{Username: "alice", Role: templates.RoleAdmin},
+		{Username: "bob", Role: templates.RoleCashier},
+	}
+	config.Config.MetricsAddress = ""
+	router := NewRouter(app)
+	admin := app.startSession("alice")
+	cashier := app.startSession("bob")
+	locked := app.startSession("bob")
+	app.setSessionLocked(locked, true)
+
+	paths := make([]string, 0, len(appRouteWrappers))
+	for path := range appRouteWrappers {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		wrappers := strings.Fields(appRouteWrappers[path])
+		t.Run(path, func(t *testing.T) {
+			// AuthMiddleware comes first, so a request without a session is sent to log in
+			// before the missing CSRF token is noticed
+			if rec := routeRequest(router, http.MethodGet, path, "", false, false); rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "/login" {
+				t.Errorf("GET without a session = %d to %q, want 303 to /login", rec.Code, rec.Header().Get("Location"))
+			}
+			if rec := routeRequest(router, http.MethodPost, path, "", false, true); rec.Header().Get("HX-Redirect") != "/login" {
+				t.Errorf("HTMX POST without a session = %d, want HX-Redirect to /login", rec.Code)
+			}
+
+			// CSRFMiddleware comes before ScreenLockMiddleware, so a forged change is refused
+			// even while the screen is locked; a real one is sent to the lock screen
+			if rec := routeRequest(router, http.MethodPost, path, locked, false, true); rec.Code != http.StatusForbidden || !strings.Contains(rec.Body.String(), "Invalid CSRF token") {
+				t.Errorf("POST without a CSRF token = %d %q, want 403", rec.Code, strings.TrimSpace(rec.Body.String()))
+			}
+			if path != lockPath && path != unlockPath {
+				if rec := routeRequest(router, http.MethodPost, path, locked, true, false); rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != lockPath {
+					t.Errorf("POST while locked = %d to %q, want 303 to %s", rec.Code, rec.Header().Get("Location"), lockPath)
+				}
+			}
+
+			for _, wrapper := range wrappers {
+				switch {
+				case strings.HasPrefix(wrapper, "Fragment("):
+					page := "/"
+					if anchor := strings.TrimSuffix(strings.TrimPrefix(wrapper, "Fragment("), ")"); anchor != "" {
+						page += "#" + anchor
+					}
+					if rec := routeRequest(router, http.MethodGet, path, admin, false, false); rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != page {
+						t.Errorf("browser GET = %d to %q, want 303 to %s", rec.Code, rec.Header().Get("Location"), page)
+					}
+				case wrapper == "AdminOnly":
+					if rec := routeRequest(router, http.MethodGet, path, cashier, false, true); rec.Code != http.StatusForbidden || !strings.Contains(rec.Body.String(), "Only an admin") {
+						t.Errorf("cashier GET = %d, want 403", rec.Code)
+					}
+				case wrapper == "AdminOnlyChanges":
+					if rec := routeRequest(router, http.MethodPost, path, cashier, true, true); rec.Code != http.StatusForbidden || !strings.Contains(rec.Body.String(), "Only an admin") {
+						t.Errorf("cashier POST = %d, want 403", rec.Code)
+					}
+				default:
+					t.Errorf("unknown wrapper %s", wrapper)
+				}
+			}
+		})
+	}
+}
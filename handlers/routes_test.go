@@ -0,0 +1,183 @@
+package handlers
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// route is one HandleFunc or Handle call of NewRouter or main.go
+type route struct {
+	mux     string // File and variable the route is registered on, e.g. "router.go appMux"
+	pattern string
+	handler string // Handler function or App method serving it; "" for other handlers
+	pos     token.Position
+}
+
+// routeFiles are the files registering routes: NewRouter, and main.go's metrics listener
+var routeFiles = []string{"router.go", filepath.Join("..", "main.go")}
+
+// readRoutes reads the routes the route files register
+func readRoutes(t *testing.T) []route {
+	t.Helper()
+	fset := token.NewFileSet()
+	var routes []route
+	for _, path := range routeFiles {
+		file, err := parser.ParseFile(fset, path, nil, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		routes = append(routes, fileRoutes(fset, file, filepath.Base(path))...)
+	}
+	return routes
+}
+
+// fileRoutes reads the routes registered in one file
+func fileRoutes(fset *token.FileSet, file *ast.File, name string) []route {
+	var routes []route
+	ast.Inspect(file, func(n ast.Node) bool {
+		call, ok := n.(*ast.CallExpr)
+		if !ok || len(call.Args) != 2 {
+			return true
+		}
+		method, ok := call.Fun.(*ast.SelectorExpr)
+		if !ok || (method.Sel.Name != "HandleFunc" && method.Sel.Name != "Handle") {
+			return true
+		}
+		mux, ok := method.X.(*ast.Ident)
+		if !ok {
+			return true
+		}
+
+		r := route{mux: name + " " + mux.Name, pattern: routePattern(call.Args[0]), handler: routeHandler(call.Args[1]), pos: fset.Position(call.Pos())}
+		routes = append(routes, r)
+		return true
+	})
+	return routes
+}
+
+// routeHandler returns the handler function or App method a route is served by, looking inside
+// any wrapper around it (app.X or handlers.X), or "" for other handlers
+func routeHandler(expr ast.Expr) string {
+	handler := ""
+	ast.Inspect(expr, func(n ast.Node) bool {
+		sel, ok := n.(*ast.SelectorExpr)
+		if !ok || handler != "" {
+			return handler == ""
+		}
+		if x, ok := sel.X.(*ast.Ident); ok && (x.Name == "app" || x.Name == "handlers") && strings.HasSuffix(sel.Sel.Name, "Handler") {
+			handler = sel.Sel.Name
+		}
+		return true
+	})
+	return handler
+}
+
+// routePattern returns a route's pattern: a string literal, or the name of the constant holding it
+func routePattern(expr ast.Expr) string {
+	switch e := expr.(type) {
+	case *ast.BasicLit:
+		if pattern, err := strconv.Unquote(e.Value); err == nil {
+			return pattern
+		}
+	case *ast.Ident:
+		return e.Name
+	case *ast.SelectorExpr:
+		if pkg, ok := e.X.(*ast.Ident); ok {
+			return pkg.Name + "." + e.Sel.Name
+		}
+	}
+	return ""
+}
+
+// handlerFuncs counts the declarations of each function and App method of the handlers package,
+// and lists the exported ones shaped like a route handler
+func handlerFuncs(t *testing.T) (map[string]int, []string) {
+	t.Helper()
+	files, err := filepath.Glob("*.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	declared := make(map[string]int)
+	var handlers []string
+	fset := token.NewFileSet()
+	for _, path := range files {
+		if strings.HasSuffix(path, "_test.go") {
+			continue
+		}
+		file, err := parser.ParseFile(fset, path, nil, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, decl := range file.Decls {
+			fn, ok := decl.(*ast.FuncDecl)
+			if !ok || (fn.Recv != nil && !isAppMethod(fn)) {
+				continue
+			}
+			declared[fn.Name.Name]++
+			if fn.Name.IsExported() && strings.HasSuffix(fn.Name.Name, "Handler") && len(fn.Type.Params.List) == 2 {
+				handlers = append(handlers, fn.Name.Name)
+			}
+		}
+	}
+	return declared, handlers
+}
+
+// isAppMethod reports whether a function is a method of *App
+func isAppMethod(fn *ast.FuncDecl) bool {
+	if fn.Recv == nil || len(fn.Recv.List) != 1 {
+		return false
+	}
+	star, ok := fn.Recv.List[0].Type.(*ast.StarExpr)
+	if !ok {
+		return false
+	}
+	ident, ok := star.X.(*ast.Ident)
+	return ok && ident.Name == "App"
+}
+
+// Each endpoint must resolve to exactly one handler function: a pattern registered twice on a mux
+// panics at startup, and a handler left behind after a rewrite rots unnoticed
+func TestRoutesResolveToOneHandler(t *testing.T) {
+	routes := readRoutes(t)
+	if len(routes) == 0 {
+		t.Fatal("no routes found")
+	}
+	declared, handlers := handlerFuncs(t)
+	if len(handlers) == 0 {
+		t.Fatal("no handlers found")
+	}
+
+	registered := make(map[string]token.Position)
+	routed := make(map[string]bool)
+	for _, r := range routes {
+		if r.pattern == "" {
+			t.Errorf("%s: route pattern isn't a string or a constant", r.pos)
+			continue
+		}
+		key := r.mux + " " + r.pattern
+		if first, dup := registered[key]; dup {
+			t.Errorf("%s: %s registered on %s again (first at %s)", r.pos, r.pattern, r.mux, first)
+		}
+		registered[key] = r.pos
+
+		if r.handler == "" {
+			continue
+		}
+		routed[r.handler] = true
+		if n := declared[r.handler]; n != 1 {
+			t.Errorf("%s: %s is served by %s, declared %d times", r.pos, r.pattern, r.handler, n)
+		}
+	}
+
+	for _, name := range handlers {
+		if !routed[name] {
+			t.Errorf("%s isn't registered on any route", name)
+		}
+	}
+}
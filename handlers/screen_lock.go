@@ -0,0 +1,186 @@
+package handlers
+
+import (
+	"html"
+	"net/http"
+	"strings"
+	"time"
+
+	"checkout/config"
+	"checkout/i18n"
+	"checkout/templates"
+	"checkout/utils"
+)
+
+// lockPath shows the lock screen (GET) and locks the screen now (POST); unlockPath takes the password or PIN
+const (
+	lockPath   = "/lock"
+	unlockPath = "/unlock"
+)
+
+// lockExemptPaths keep answering reads while the screen is locked: the status checks of a
+// payment the customer may still be completing. The payment SSE stream and the customer
+// display aren't behind the login, so they are unaffected.
+var lockExemptPaths = map[string]bool{
+	"/get-payment-status": true,
+	"/terminal-email":     true,
+}
+
+// ScreenLockMiddleware records each session's last change and locks its screen after the
+// configured inactivity. A locked session keeps its cart and payments in progress, but every
+// request other than the lock screen's is sent there, and changes are refused, until the
+// user's password or PIN is entered again.
+func (a *App) ScreenLockMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == lockPath || r.URL.Path == unlockPath || (isSafeMethod(r.Method) && lockExemptPaths[r.URL.Path]) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		token := sessionToken(r)
+		if a.sessionLocked(token, config.GetAutoLockTimeout(), time.Now()) {
+			status := http.StatusOK
+			if !isSafeMethod(r.Method) {
+				utils.Warn("auth", "Request refused while the screen is locked", "user", currentUsername(r), "method", r.Method, "path", r.URL.Path)
+				status = http.StatusLocked
+			}
+			if isHTMX(r) {
+				w.Header().Set("HX-Redirect", lockPath)
+				w.WriteHeader(status)
+				return
+			}
+			http.Redirect(w, r, lockPath, http.StatusSeeOther)
+			return
+		}
+
+		// Polls and page loads don't count as activity; only a change does
+		if !isSafeMethod(r.Method) {
+			a.touchSession(token, time.Now())
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// LockHandler shows the lock screen of a locked session (GET) or locks the screen now (POST)
+func (a *App) LockHandler(w http.ResponseWriter, r *http.Request) {
+	token := sessionToken(r)
+	switch r.Method {
+	case http.MethodGet:
+		if !a.sessionLocked(token, config.GetAutoLockTimeout(), time.Now()) {
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+			return
+		}
+		if err := templates.LockPage(currentUsername(r)).Render(r.Context(), w); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+
+	case http.MethodPost:
+		a.setSessionLocked(token, true)
+		utils.Info("auth", "Screen locked", "user", currentUsername(r))
+		w.Header().Set("HX-Redirect", lockPath)
+		w.WriteHeader(http.StatusOK)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// UnlockHandler unlocks the screen when the signed-in user's password or PIN is entered. After too
+// many wrong attempts the session is signed out, so the register needs a full password login.
+func (a *App) UnlockHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Error parsing form", http.StatusBadRequest)
+		return
+	}
+
+	username := currentUsername(r)
+	token := sessionToken(r)
+	secret := r.FormValue("password")
+	_, ok := config.AuthenticateUser(username, secret)
+	if !ok {
+		_, ok = config.AuthenticatePIN(username, strings.TrimSpace(secret))
+	}
+	if !ok {
+		attempts := a.recordWrongUnlock(token)
+		remaining := config.GetPINMaxAttempts() - attempts
+		utils.Warn("auth", "Failed unlock", "user", username, "attempts", attempts)
+		if remaining <= 0 {
+			utils.Warn("audit", "Lock screen locked out after too many wrong attempts, signing out", "user", username)
+			a.LogoutHandler(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		if _, err := w.Write([]byte(`<div class="error-message">` + html.EscapeString(i18n.T("lock.invalid", remaining)) + `</div>`)); err != nil {
+			utils.Error("auth", "Error writing error message to response", "error", err)
+		}
+		return
+	}
+
+	a.setSessionLocked(token, false)
+	a.touchSession(token, time.Now())
+	utils.Info("auth", "Screen unlocked", "user", username)
+	w.Header().Set("HX-Redirect", "/")
+	w.WriteHeader(http.StatusOK)
+}
+
+// sessionToken returns the request's session token, or "" without one
+func sessionToken(r *http.Request) string {
+	cookie, err := r.Cookie(sessionCookieName)
+	if err != nil {
+		return ""
+	}
+	return cookie.Value
+}
+
+// sessionLocked reports whether a session's screen is locked, locking it once it has gone
+// timeout without a change (timeout 0 = only when locked by hand)
+func (a *App) sessionLocked(token string, timeout time.Duration, now time.Time) bool {
+	a.sessions.mutex.Lock()
+	defer a.sessions.mutex.Unlock()
+
+	session, ok := a.sessions.byToken[token]
+	if !ok {
+		return false
+	}
+	if !session.locked && timeout > 0 && now.Sub(session.lastActivity) > timeout {
+		session.locked = true
+		utils.Info("auth", "Screen locked after inactivity", "user", session.username, "idle", now.Sub(session.lastActivity).Round(time.Second).String())
+	}
+	return session.locked
+}
+
+// setSessionLocked locks or unlocks a session's screen, clearing its wrong unlock attempts
+func (a *App) setSessionLocked(token string, locked bool) {
+	a.sessions.mutex.Lock()
+	defer a.sessions.mutex.Unlock()
+	if session, ok := a.sessions.byToken[token]; ok {
+		session.locked = locked
+		session.unlockAttempts = 0
+	}
+}
+
+// recordWrongUnlock counts a wrong password or PIN entered on a session's lock screen and returns
+// how many it has entered since the screen was locked
+func (a *App) recordWrongUnlock(token string) int {
+	a.sessions.mutex.Lock()
+	defer a.sessions.mutex.Unlock()
+	session, ok := a.sessions.byToken[token]
+	if !ok {
+		return 0
+	}
+	session.unlockAttempts++
+	return session.unlockAttempts
+}
+
+// touchSession records a change made by a session, restarting its inactivity timer
+func (a *App) touchSession(token string, now time.Time) {
+	a.sessions.mutex.Lock()
+	defer a.sessions.mutex.Unlock()
+	if session, ok := a.sessions.byToken[token]; ok {
+		session.lastActivity = now
+	}
+}
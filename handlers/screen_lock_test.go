@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"checkout/config"
+	"checkout/templates"
+)
+
+// useLockUsers signs alice in on a locked screen; alice has the password "secret" and the PIN
+// 1234, and bob the PIN 5678
+func useLockUsers(t *testing.T, app *App) string {
+	t.Helper()
+	hash := func(secret string) string {
+		hashed, err := bcrypt.GenerateFromPassword([]byte(secret), bcrypt.MinCost)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(hashed)
+	}
+	config.Config.Users = []templates.User{
+		{Username: "alice", PasswordHash: hash("secret"), PINHash: hash("1234"), Role: templates.RoleCashier},
+		{Username: "bob", PasswordHash: hash("other"), PINHash: hash("5678"), Role: templates.RoleCashier},
+	}
+	config.Config.PINMaxAttempts = 3
+	session := app.startSession("alice")
+	app.setSessionLocked(session, true)
+	return session
+}
+
+// unlock posts a password or PIN to the lock screen of alice's session
+func unlock(app *App, session, secret string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, unlockPath, strings.NewReader(url.Values{"password": {secret}}.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("HX-Request", "true")
+	req.AddCookie(&http.Cookie{Name: sessionCookieName, Value: session})
+	req = req.WithContext(templates.WithUser(req.Context(), templates.User{Username: "alice", Role: templates.RoleCashier}))
+	rec := httptest.NewRecorder()
+	app.UnlockHandler(rec, req)
+	return rec
+}
+
+// signedIn reports whether a session is still signed in
+func signedIn(app *App, session string) bool {
+	app.sessions.mutex.Lock()
+	defer app.sessions.mutex.Unlock()
+	_, found := app.sessions.byToken[session]
+	return found
+}
+
+func TestUnlockWithPasswordOrPIN(t *testing.T) {
+	tests := []struct {
+		name     string
+		secret   string
+		unlocked bool
+	}{
+		{"password", "secret", true},
+		{"PIN", "1234", true},
+		{"PIN with spaces", " 1234 ", true},
+		{"wrong password", "wrong", false},
+		{"another user's PIN", "5678", false},
+		{"another user's password", "other", false},
+		{"empty", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, _, _ := newTestApp(t)
+			session := useLockUsers(t, app)
+
+			rec := unlock(app, session, tt.secret)
+
+			if locked := app.sessionLocked(session, 0, app.Clock.Now()); locked == tt.unlocked {
+				t.Errorf("locked = %v, want %v", locked, !tt.unlocked)
+			}
+			if redirect := rec.Header().Get("HX-Redirect"); (redirect == "/") != tt.unlocked {
+				t.Errorf("HX-Redirect = %q, unlocked %v", redirect, tt.unlocked)
+			}
+			if !tt.unlocked && !strings.Contains(rec.Body.String(), "2 attempts left") {
+				t.Errorf("response = %q, want the attempts left", rec.Body.String())
+			}
+		})
+	}
+}
+
+// Guessing at the lock screen is limited like guessing PINs at the switch user pad
+func TestWrongUnlocksSignOut(t *testing.T) {
+	app, _, _ := newTestApp(t)
+	session := useLockUsers(t, app)
+	cart := app.Carts.Get(sessionCartKey(session))
+	cart.Add(templates.Product{ID: "coffee", Name: "Coffee", Price: 4.50})
+
+	unlock(app, session, "wrong")
+	unlock(app, session, "4321")
+	if !signedIn(app, session) || cart.Len() != 1 {
+		t.Fatalf("session signed out or cart cleared before the last attempt")
+	}
+
+	// An unlock starts the count again
+	unlock(app, session, "1234")
+	app.setSessionLocked(session, true)
+	unlock(app, session, "wrong")
+	unlock(app, session, "wrong")
+	if !signedIn(app, session) {
+		t.Fatalf("session signed out with attempts left after an unlock")
+	}
+
+	rec := unlock(app, session, "wrong")
+	if signedIn(app, session) {
+		t.Errorf("session still signed in after %d wrong attempts", config.GetPINMaxAttempts())
+	}
+	if redirect := rec.Header().Get("HX-Redirect"); redirect != "/login" {
+		t.Errorf("HX-Redirect = %q, want /login", redirect)
+	}
+}
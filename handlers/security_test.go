@@ -0,0 +1,155 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"checkout/config"
+	"checkout/templates"
+)
+
+func TestCSRFMiddleware(t *testing.T) {
+	const token = "session-token"
+	tests := []struct {
+		name       string
+		method     string
+		cookie     string
+		header     string
+		field      string
+		wantStatus int
+		wantIssued bool
+	}{
+		{"POST without a token", http.MethodPost, "", "", "", http.StatusForbidden, false},
+		{"POST with a token but no cookie", http.MethodPost, "", token, "", http.StatusForbidden, false},
+		{"POST without sending the token", http.MethodPost, token, "", "", http.StatusForbidden, false},
+		{"POST with another session's token", http.MethodPost, token, "other-token", "", http.StatusForbidden, false},
+		{"POST with the header", http.MethodPost, token, token, "", http.StatusOK, false},
+		{"POST with the form field", http.MethodPost, token, "", token, http.StatusOK, false},
+		{"PUT without a token", http.MethodPut, token, "", "", http.StatusForbidden, false},
+		{"PATCH without a token", http.MethodPatch, token, "", "", http.StatusForbidden, false},
+		{"DELETE without a token", http.MethodDelete, token, "", "", http.StatusForbidden, false},
+		{"GET without a token", http.MethodGet, token, "", "", http.StatusOK, false},
+		{"HEAD without a token", http.MethodHead, token, "", "", http.StatusOK, false},
+		{"first GET of a session issues a token", http.MethodGet, "", "", "", http.StatusOK, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, _, _ := newTestApp(t)
+			var seen string
+			called := false
+			handler := app.CSRFMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				called = true
+				seen = templates.CSRFToken(r.Context())
+			}))
+
+			form := url.Values{}
+			if tt.field != "" {
+				form.Set(templates.CSRFFieldName, tt.field)
+			}
+			req := httptest.NewRequest(tt.method, "/add-to-cart", strings.NewReader(form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			if tt.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: csrfCookieName, Value: tt.cookie})
+			}
+			if tt.header != "" {
+				req.Header.Set(templates.CSRFHeaderName, tt.header)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if called != (tt.wantStatus == http.StatusOK) {
+				t.Errorf("handler called = %v on a %d", called, rec.Code)
+			}
+			var issued *http.Cookie
+			for _, cookie := range rec.Result().Cookies() {
+				if cookie.Name == csrfCookieName {
+					issued = cookie
+				}
+			}
+			if (issued != nil) != tt.wantIssued {
+				t.Fatalf("issued a token = %v, want %v", issued != nil, tt.wantIssued)
+			}
+			switch {
+			case issued != nil:
+				if seen != issued.Value || !issued.HttpOnly || issued.SameSite != http.SameSiteStrictMode {
+					t.Errorf("issued %+v, pages got %q", issued, seen)
+				}
+			case called && seen != tt.cookie:
+				t.Errorf("pages got token %q, want the session's", seen)
+			}
+		})
+	}
+}
+
+// Through the router, every change a signed-in register makes needs the token, except logging in
+// and Stripe's webhook, which don't have it
+func TestCSRFRequiredOnMutatingRoutes(t *testing.T) {
+	app, _, _ := newTestApp(t)
+	config.Config.Users = []templates.User{{Username: "alice", Role: templates.RoleAdmin}}
+	config.Config.MetricsAddress = ""
+	router := NewRouter(app)
+	session := app.startSession("alice")
+	cart := app.Carts.Get(sessionCartKey(session))
+	cart.Add(templates.Product{ID: "coffee", Name: "Coffee", Price: 4.50})
+
+	for _, path := range []string{"/add-to-cart", "/remove-from-cart", "/clear-cart", "/process-payment", "/generate-qr-code", "/api/settings/update", "/logout"} {
+		rec := routeRequest(router, http.MethodPost, path, session, false, true)
+		if rec.Code != http.StatusForbidden || !strings.Contains(rec.Body.String(), "Invalid CSRF token") {
+			t.Errorf("POST %s without a token = %d %q, want 403", path, rec.Code, strings.TrimSpace(rec.Body.String()))
+		}
+	}
+	// A link to /logout is just as forged
+	if rec := routeRequest(router, http.MethodGet, "/logout", session, false, false); rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET /logout = %d, want 405", rec.Code)
+	}
+	signedIn := func() bool {
+		app.sessions.mutex.Lock()
+		defer app.sessions.mutex.Unlock()
+		_, found := app.sessions.byToken[session]
+		return found
+	}
+	if cart.Len() != 1 || !signedIn() {
+		t.Fatalf("forged requests changed the cart to %d items or ended the session", cart.Len())
+	}
+
+	if rec := routeRequest(router, http.MethodPost, "/clear-cart", session, true, true); rec.Code == http.StatusForbidden || cart.Len() != 0 {
+		t.Errorf("POST /clear-cart with the token = %d, cart has %d items", rec.Code, cart.Len())
+	}
+	if rec := routeRequest(router, http.MethodPost, "/logout", session, true, true); rec.Code == http.StatusForbidden || signedIn() {
+		t.Errorf("POST /logout with the token = %d, want signed out", rec.Code)
+	}
+
+	for _, path := range []string{"/login", "/stripe-webhook"} {
+		if rec := routeRequest(router, http.MethodPost, path, "", false, false); rec.Code == http.StatusForbidden {
+			t.Errorf("POST %s without a token = 403, want it exempt", path)
+		}
+	}
+}
+
+func TestSecurityHeaders(t *testing.T) {
+	app, _, _ := newTestApp(t)
+	config.Config.MetricsAddress = ""
+	router := app.SecurityHeadersMiddleware(NewRouter(app)) // As main serves it
+
+	for _, path := range []string{"/login", "/", "/stripe-webhook"} {
+		rec := routeRequest(router, http.MethodGet, path, "", false, false)
+		header := rec.Header()
+		csp := header.Get("Content-Security-Policy")
+		for _, directive := range []string{"default-src 'self'", "https://js.stripe.com", "frame-ancestors 'none'"} {
+			if !strings.Contains(csp, directive) {
+				t.Errorf("%s: Content-Security-Policy %q is missing %s", path, csp, directive)
+			}
+		}
+		for name, want := range map[string]string{"X-Frame-Options": "DENY", "X-Content-Type-Options": "nosniff", "Referrer-Policy": "same-origin"} {
+			if got := header.Get(name); got != want {
+				t.Errorf("%s: %s = %q, want %q", path, name, got, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,236 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"checkout/config"
+	"checkout/i18n"
+	"checkout/services"
+	"checkout/services/validation"
+	"checkout/templates"
+	"checkout/templates/checkout"
+	"checkout/templates/pos"
+	"checkout/utils"
+)
+
+// sentLinkCheckInterval is how often outstanding sent links are checked with Stripe, in case a
+// webhook was missed, and expired
+const sentLinkCheckInterval = 5 * time.Minute
+
+// SendPaymentLinkHandler sends the sale as a payment link for the customer to pay later.
+// GET asks for the customer's email; POST creates the link, emails it if an address was given,
+// shows its URL to copy, and clears the register. The sale is logged once the link is paid.
+func (a *App) SendPaymentLinkHandler(w http.ResponseWriter, r *http.Request) {
+	cart := a.cart(r)
+	if cart.Len() == 0 {
+		setToast(w, "warning", "toast.cart_empty")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if rejectNonPositiveTotal(w, cart) {
+		return
+	}
+	if rejectMixedVendors(w, cart) {
+		return
+	}
+	// Split tenders and exchanges are settled at the register
+	if cart.Split() != nil || services.CartReturnOriginalID(cart) != "" {
+		setToast(w, "warning", "toast.sent_link_unavailable")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	cart.SetPaymentMethod("qr")
+	if r.Method == http.MethodGet {
+		amount := services.CalculateCartSummary(cart).Total
+		if err := renderInfoModal(w, r, checkout.SendLinkModal(amount, config.IsEmailEnabled())); err != nil {
+			utils.Error("payment", "Error rendering send link form", "error", err)
+		}
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Error parsing form", http.StatusBadRequest)
+		return
+	}
+
+	email := strings.TrimSpace(r.FormValue("email"))
+	if email != "" {
+		var err error
+		if email, err = validation.Email(email, config.GetEmailTypoDomains()); err != nil {
+			setToastText(w, "warning", err.Error())
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+	}
+
+	if !a.quoteStripeTax(w, cart) {
+		return
+	}
+	// The customer pays away from the register, so no tip is offered
+	cart.SetTip(0)
+
+	items, summary := cart.Items(), services.CalculateCartSummary(cart)
+	paymentLink, err := services.CreatePaymentLink(a.Stripe, cart, summary.Total, "", services.PaymentLinkKindSent)
+	if err != nil {
+		utils.Error("payment", "Error creating payment link to send", "amount", summary.Total, "error", err)
+		setToast(w, "error", "toast.payment_link_error", err.Error())
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	now := time.Now()
+	link := templates.SentLink{
+		PaymentLinkID: paymentLink.ID,
+		URL:           paymentLink.URL,
+		Email:         email,
+		Amount:        summary.Total,
+		Cart:          items,
+		Summary:       summary,
+		Note:          cart.Note(),
+		SentBy:        currentUsername(r),
+		SentAt:        now,
+	}
+	if expiry := config.GetSentLinkExpiry(); expiry > 0 {
+		link.ExpiresAt = now.Add(expiry)
+	}
+	if err := services.RecordSentLink(link); err != nil {
+		// Without the record the sale couldn't be completed when the link is paid
+		utils.Error("payment", "Error recording sent payment link", "payment_link_id", paymentLink.ID, "error", err)
+		if _, err := a.Stripe.DeactivatePaymentLink(paymentLink.ID); err != nil {
+			utils.Error("payment", "Error deactivating unrecorded payment link", "payment_link_id", paymentLink.ID, "error", err)
+		}
+		setToast(w, "error", "toast.payment_link_error", err.Error())
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	utils.Info("audit", "Payment link sent", "payment_link_id", paymentLink.ID, "amount", summary.Total,
+		"emailed", email != "", "expires_at", link.ExpiresAt, "user", currentUsername(r))
+
+	emailed := false
+	if email != "" {
+		if err := services.EmailSentLink(link); err != nil {
+			utils.Error("payment", "Error emailing payment link", "payment_link_id", paymentLink.ID, "error", err)
+		} else {
+			emailed = true
+		}
+	}
+
+	// The sale now waits on the customer, so the register is free for the next one
+	cart.Clear()
+	cart.SetNote("")
+
+	if err := renderModal(w, r, checkout.SentLinkResult(link, emailed), "cartUpdated"); err != nil {
+		utils.Error("payment", "Error rendering sent payment link", "payment_link_id", paymentLink.ID, "error", err)
+	}
+}
+
+// SentLinksHandler lists the payment links sent to customers that are still waiting to be paid
+func (a *App) SentLinksHandler(w http.ResponseWriter, r *http.Request) {
+	if err := renderInfoModal(w, r, pos.SentLinksModal(services.OutstandingSentLinks())); err != nil {
+		utils.Error("payment", "Error rendering sent payment links", "error", err)
+	}
+}
+
+// CancelSentLinkHandler deactivates a sent payment link so the customer can no longer pay it
+func (a *App) CancelSentLinkHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Error parsing form", http.StatusBadRequest)
+		return
+	}
+
+	paymentLinkID := r.FormValue("payment_link_id")
+	link, err := services.CancelSentLink(a.Stripe, paymentLinkID, currentUsername(r))
+	if errors.Is(err, services.ErrSentLinkPaid) {
+		// Complete it now rather than waiting for the next check
+		a.checkSentLinks(time.Now())
+		setToast(w, "warning", "toast.sent_link_already_paid")
+	} else if err != nil {
+		utils.Error("payment", "Error cancelling sent payment link", "payment_link_id", paymentLinkID, "error", err)
+		setToastText(w, "error", err.Error())
+	} else {
+		setToast(w, "success", "toast.sent_link_cancelled", i18n.Money(link.Amount))
+	}
+
+	if err := renderModal(w, r, pos.SentLinksModal(services.OutstandingSentLinks())); err != nil {
+		utils.Error("payment", "Error rendering sent payment links", "error", err)
+	}
+}
+
+// startSentLinkCheck periodically completes sent links that were paid and expires overdue ones
+func (a *App) startSentLinkCheck() {
+	go func() {
+		ticker := time.NewTicker(sentLinkCheckInterval)
+		defer ticker.Stop()
+
+		for now := range ticker.C {
+			a.checkSentLinks(now)
+		}
+	}()
+}
+
+// checkSentLinks asks Stripe about each outstanding sent link. Paid links are completed here when
+// their webhook was missed; unpaid links past their expiry are deactivated.
+func (a *App) checkSentLinks(now time.Time) {
+	for _, link := range services.OutstandingSentLinks() {
+		status, err := services.CheckPaymentLinkStatus(a.Stripe, link.PaymentLinkID, link.SentAt)
+		if err != nil {
+			utils.Warn("payment", "Could not check sent payment link", "payment_link_id", link.PaymentLinkID, "error", err)
+			continue
+		}
+
+		switch {
+		case status.Completed:
+			a.completeSentLink(link.PaymentLinkID, status)
+		case !link.ExpiresAt.IsZero() && now.After(link.ExpiresAt):
+			if err := services.ExpireSentLink(a.Stripe, link.PaymentLinkID); err != nil {
+				utils.Error("payment", "Error expiring sent payment link", "payment_link_id", link.PaymentLinkID, "error", err)
+			}
+		}
+	}
+}
+
+// completeSentLink logs the sale of a sent payment link the customer has paid and sends the
+// receipt to the address Stripe collected, or the one the link was sent to
+func (a *App) completeSentLink(paymentLinkID string, status services.PaymentLinkStatus) {
+	link, ok := services.ConcludeSentLink(paymentLinkID, services.SentLinkPaid)
+	if !ok {
+		return
+	}
+	utils.Info("payment", "Sent payment link paid", "payment_link_id", paymentLinkID, "session_id", status.SessionID, "amount", link.Amount)
+
+	summary := link.Summary
+	if status.AutomaticTax && status.SessionID != "" {
+		taxed, err := services.ApplySessionTax(a.Stripe, status.SessionID, link.Cart, summary)
+		if err != nil {
+			utils.Error("tax", "Error reading Stripe Tax from checkout session, recording the estimate", "payment_link_id", paymentLinkID, "error", err)
+		} else {
+			summary = taxed
+		}
+	}
+
+	if err := a.Events.LogSentLinkPayment(link, summary, status.CustomerEmail); err != nil {
+		utils.Error("payment", "Error logging sent payment link sale", "payment_link_id", paymentLinkID, "error", err)
+	}
+
+	email := status.CustomerEmail
+	if email == "" {
+		email = link.Email
+	}
+	if email != "" {
+		if _, err := sendReceipt(a.Stripe, paymentLinkID, email, "", "sent_link"); err != nil {
+			utils.Error("receipt", "Error sending receipt for sent payment link", "payment_link_id", paymentLinkID, "error", err)
+		}
+	}
+}
@@ -1,41 +1,232 @@
 package handlers
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
+	"slices"
 	"strings"
+	"time"
 
 	"checkout/config"
+	"checkout/handlers/htmx"
+	"checkout/services"
+	"checkout/templates"
 	"checkout/templates/settings"
 	"checkout/utils"
 )
 
 // SettingsHandler handles the settings page
-func SettingsHandler(w http.ResponseWriter, r *http.Request) {
+func (a *App) SettingsHandler(w http.ResponseWriter, r *http.Request) {
 	// Send HX-Trigger header to show the modal
-	w.Header().Set("HX-Trigger", "showModal")
-	component := settings.SettingsPage()
+	htmx.ShowModal(w)
+	component := settings.SettingsPage(services.StripeAccountStatus(), services.WebhookHealthReport())
 	component.Render(r.Context(), w)
 }
 
-// SettingsSearchHandler handles searching settings
-func SettingsSearchHandler(w http.ResponseWriter, r *http.Request) {
-	query := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("q")))
-	utils.Debug("settings", "Search request received", "query", query, "url", r.URL.String())
+// webhookTestTimeout is how long the webhook test waits for Stripe's event
+const webhookTestTimeout = 10 * time.Second
 
-	// If no query, show all settings
-	if query == "" {
-		component := settings.SettingsSections()
-		component.Render(r.Context(), w)
+// WebhookTestHandler sends a test event through the webhook endpoint and reports whether it arrived
+func (a *App) WebhookTestHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var message string
+	passed := false
+	if elapsed, err := services.TestWebhookDelivery(webhookTestTimeout); err != nil {
+		message = "Failed: " + err.Error()
+	} else {
+		passed = true
+		message = fmt.Sprintf("Event arrived in %d ms", elapsed.Milliseconds())
+	}
+	utils.Info("audit", "Webhook delivery tested", "passed", passed, "user", currentUsername(r))
+
+	if err := settings.WebhookTestResult(message, passed).Render(r.Context(), w); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// WebhookConfigHandler renders the webhook configuration panel of the settings page. GET shows
+// the last check; POST registers an endpoint for the website name (the fix registration button).
+func (a *App) WebhookConfigHandler(w http.ResponseWriter, r *http.Request) {
+	message, failed := "", false
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var err error
+		if message, err = services.FixWebhookRegistration(currentUsername(r)); err != nil {
+			utils.Warn("communication", "Webhook registration fix failed", "error", err, "user", currentUsername(r))
+			message, failed = "Failed: "+err.Error(), true
+		}
+		htmx.Trigger(w, "paymentAlertsChanged")
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := settings.WebhookConfigPanel(services.WebhookConfigStatus(), message, failed).Render(r.Context(), w); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// DismissWebhookConfigHandler hides the POS banner about the webhook configuration until it has
+// other problems
+func (a *App) DismissWebhookConfigHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	services.DismissWebhookConfigAlert()
+	utils.Info("audit", "Webhook configuration banner dismissed", "user", currentUsername(r))
+	htmx.Trigger(w, "paymentAlertsChanged")
+	w.WriteHeader(http.StatusOK)
+}
+
+// webhookConfigFields are the settings that decide how payment events arrive; changing one
+// checks the webhook configuration again
+var webhookConfigFields = []string{"WebsiteName", "StripeWebhookSecret", "ForceWebhookStrategy", "StripeSecretKey"}
+
+// UserPINHandler sets or removes the PIN a user switches in with at the register
+func (a *App) UserPINHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Error parsing form", http.StatusBadRequest)
+		return
+	}
+
+	username := r.FormValue("username")
+	remove := r.FormValue("action") == "remove"
+	pin := strings.TrimSpace(r.FormValue("pin"))
+
+	message, failed := "PIN set for "+username, false
+	if remove {
+		message, pin = "PIN removed for "+username, ""
+	}
+	var err error
+	if pin == "" && !remove {
+		// An empty PIN would remove it, which only the Remove button should do
+		err = config.ValidatePIN(pin)
+	} else {
+		err = config.SetUserPIN(username, pin)
+	}
+	if err != nil {
+		message, failed = "Failed: "+err.Error(), true
+	} else {
+		utils.Info("audit", "User PIN changed", "username", username, "removed", remove, "user", currentUsername(r))
+	}
+
+	if err := settings.UserPINPanel(config.Config.Users, message, failed).Render(r.Context(), w); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// TerminalLocationsHandler creates a Terminal Location (action=create), changes one's name and
+// address (action=update) or deletes one no reader is registered to (action=delete), and shows
+// the location list again. Problems Stripe finds with a field are shown on its form.
+func (a *App) TerminalLocationsHandler(w http.ResponseWriter, r *http.Request) {
+	message, failed := "", false
+	form := templates.StripeLocation{}
+	var fieldErrors services.LocationFieldErrors
+
+	if r.Method == http.MethodPost {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "Error parsing form", http.StatusBadRequest)
+			return
+		}
+
+		form = locationFromForm(r)
+		action := r.FormValue("action")
+		location, err := form, error(nil)
+		switch action {
+		case "create":
+			if location, err = services.CreateStripeLocation(form); err == nil {
+				message = "Created " + location.DisplayName + " (" + location.ID + ")"
+			}
+		case "update":
+			if location, err = services.UpdateStripeLocation(form); err == nil {
+				message = "Saved " + location.DisplayName
+			}
+		case "delete":
+			if err = services.DeleteStripeLocation(form.ID); err == nil {
+				message = "Deleted " + form.ID
+			}
+		default:
+			http.Error(w, "Unknown action", http.StatusBadRequest)
+			return
+		}
+
+		switch {
+		case errors.As(err, &fieldErrors):
+			message, failed = "Not saved: check the fields marked below", true
+		case err != nil:
+			message, failed = "Failed: "+err.Error(), true
+		default:
+			utils.Info("audit", "Terminal Location changed", "action", action, "location_id", location.ID, "name", location.DisplayName, "user", currentUsername(r))
+		}
+	}
+
+	component := settings.TerminalLocationsPanel(services.Terminal.Locations(), services.Terminal.SelectedLocation().ID, form, fieldErrors, message, failed)
+	if err := component.Render(r.Context(), w); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// OrderWebhooksHandler shows the order webhook delivery log again (GET) or posts a test event to
+// every order webhook URL and shows how each answered (POST)
+func (a *App) OrderWebhooksHandler(w http.ResponseWriter, r *http.Request) {
+	message, failed := "", false
+
+	switch r.Method {
+	case http.MethodGet:
+
+	case http.MethodPost:
+		attempts, err := services.SendTestOrderWebhook()
+		if err != nil {
+			message, failed = "Failed: "+err.Error(), true
+		}
+		var results []string
+		for _, attempt := range attempts {
+			if attempt.Delivered() {
+				results = append(results, fmt.Sprintf("%s answered %d", attempt.URL, attempt.Status))
+			} else {
+				results = append(results, attempt.URL+": "+attempt.Error)
+				failed = true
+			}
+		}
+		if len(results) > 0 {
+			message = "Test event sent. " + strings.Join(results, "; ")
+		}
+		utils.Info("audit", "Order webhook tested", "urls", len(attempts), "passed", !failed, "user", currentUsername(r))
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	// Filter settings based on search query
-	component := settings.FilteredSettingsSections(query)
+	component := settings.OrderWebhooksPanel(services.RecentOrderWebhookAttempts(services.OrderWebhookAttemptsShown), message, failed)
+	if err := component.Render(r.Context(), w); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// SettingsSearchHandler renders the settings whose label or help text matches the query, grouped
+// by section; an empty query shows every setting
+func (a *App) SettingsSearchHandler(w http.ResponseWriter, r *http.Request) {
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	utils.Debug("settings", "Search request received", "query", query)
+
+	component := settings.SettingsSections(config.SearchSettings(query))
 	component.Render(r.Context(), w)
 }
 
 // SettingsUpdateHandler handles updating settings
-func SettingsUpdateHandler(w http.ResponseWriter, r *http.Request) {
+func (a *App) SettingsUpdateHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPut {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
@@ -51,12 +242,43 @@ func SettingsUpdateHandler(w http.ResponseWriter, r *http.Request) {
 	fieldName := r.Form.Get("name")
 	fieldValue := r.Form.Get("value")
 
+	// Switching the terminal location also reloads its readers
+	if fieldName == "StripeTerminalLocationID" && fieldValue != "" {
+		if _, err := services.SelectStripeLocation(fieldValue); err != nil {
+			utils.Error("settings", "Error selecting terminal location", "location_id", fieldValue, "error", err)
+			http.Error(w, "Unknown terminal location", http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
 	// Update config field using reflection
 	if err := config.UpdateConfigField(fieldName, fieldValue); err != nil {
+		// Rejected values are reported to the user; the saved value is unchanged
+		var invalid *config.InvalidSettingError
+		if errors.As(err, &invalid) {
+			utils.Warn("settings", "Rejected setting value", "field", fieldName, "error", err)
+			setToastText(w, "error", invalid.Err.Error())
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			return
+		}
 		utils.Error("settings", "Error updating setting", "field", fieldName, "error", err)
 		http.Error(w, "Error updating setting", http.StatusInternalServerError)
 		return
 	}
+	utils.Info("audit", "Setting changed", "field", fieldName, "user", currentUsername(r))
+
+	if slices.Contains(webhookConfigFields, fieldName) {
+		services.CheckWebhookConfig()
+		htmx.Trigger(w, "webhookConfigChanged")
+	}
+
+	// Switching demo mode swaps Stripe for the simulated client; reload so the banner shows
+	if fieldName == "DemoMode" {
+		a.applyDemoMode()
+		w.Header().Set("HX-Refresh", "true")
+	}
 
 	w.WriteHeader(http.StatusOK)
 }
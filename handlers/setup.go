@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"checkout/config"
+	"checkout/handlers/htmx"
+	"checkout/services"
+	"checkout/templates"
+	"checkout/templates/settings"
+	"checkout/utils"
+)
+
+// SetupMiddleware sends every POS route to the setup page while the startup checks are failing.
+// The setup page and the settings it edits stay reachable so the operator can fix the problem.
+func (a *App) SetupMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if services.SetupProblem() == "" || r.URL.Path == "/setup" || strings.HasPrefix(r.URL.Path, "/setup/") ||
+			r.URL.Path == "/api/settings/update" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// HTMX requests need a client-side redirect so the page itself navigates
+		if isHTMX(r) {
+			w.Header().Set("HX-Redirect", "/setup")
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		http.Redirect(w, r, "/setup", http.StatusSeeOther)
+	})
+}
+
+// SetupHandler shows why the POS can't start and the settings needed to fix it
+func (a *App) SetupHandler(w http.ResponseWriter, r *http.Request) {
+	problem := services.SetupProblem()
+	if problem == "" {
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	if err := settings.SetupPage(problem).Render(r.Context(), w); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// SetupLocationsHandler lists the terminal locations in the Stripe account, fetched live
+// so a location created in the Dashboard shows up without restarting
+func (a *App) SetupLocationsHandler(w http.ResponseWriter, r *http.Request) {
+	locations, err := services.ListStripeLocations()
+	errorMessage := ""
+	if err != nil {
+		utils.Warn("setup", "Could not list terminal locations", "error", err)
+		errorMessage = err.Error()
+	}
+
+	component := settings.SetupLocations(locations, a.Config.StripeTerminalLocationID, errorMessage)
+	if err := component.Render(r.Context(), w); err != nil {
+		utils.Error("setup", "Error rendering terminal locations", "error", err)
+	}
+}
+
+// SetupLocationHandler saves the chosen terminal location and runs the startup checks again
+func (a *App) SetupLocationHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Error parsing form", http.StatusBadRequest)
+		return
+	}
+
+	locationID := r.FormValue("location_id")
+	if err := config.UpdateConfigField("StripeTerminalLocationID", locationID); err != nil {
+		utils.Error("setup", "Error saving terminal location", "location_id", locationID, "error", err)
+		http.Error(w, "Error saving terminal location", http.StatusInternalServerError)
+		return
+	}
+	utils.Info("setup", "Terminal location chosen on setup page", "location_id", locationID)
+
+	renderSetupResult(w, r)
+}
+
+// SetupLocationCreateHandler creates the Terminal Location of a new venue from the setup page,
+// makes it the register's location and runs the startup checks again. Problems Stripe finds with
+// a field are shown on the form.
+func (a *App) SetupLocationCreateHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Error parsing form", http.StatusBadRequest)
+		return
+	}
+
+	form := locationFromForm(r)
+	location, err := services.CreateStripeLocation(form)
+	var fieldErrors services.LocationFieldErrors
+	if errors.As(err, &fieldErrors) {
+		w.Header().Set("HX-Retarget", "#setup-location-form")
+		w.Header().Set("HX-Reswap", "outerHTML")
+		if err := settings.SetupLocationForm(form, fieldErrors).Render(r.Context(), w); err != nil {
+			utils.Error("setup", "Error rendering location form", "error", err)
+		}
+		return
+	}
+	if err != nil && location.ID == "" {
+		utils.Error("setup", "Error creating terminal location", "error", err)
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	if err != nil {
+		utils.Error("setup", "Error saving the new terminal location", "location_id", location.ID, "error", err)
+	}
+	utils.Info("audit", "Terminal Location created", "location_id", location.ID, "name", location.DisplayName, "user", currentUsername(r))
+
+	renderSetupResult(w, r)
+}
+
+// locationFromForm reads a Terminal Location's ID, name and address from a location form
+func locationFromForm(r *http.Request) templates.StripeLocation {
+	return templates.StripeLocation{
+		ID:          r.FormValue("location_id"),
+		DisplayName: strings.TrimSpace(r.FormValue("display_name")),
+		Address: templates.StripeAddress{
+			Line1:      strings.TrimSpace(r.FormValue("line1")),
+			City:       strings.TrimSpace(r.FormValue("city")),
+			State:      strings.TrimSpace(r.FormValue("state")),
+			PostalCode: strings.TrimSpace(r.FormValue("postal_code")),
+			Country:    strings.ToUpper(strings.TrimSpace(r.FormValue("country"))),
+		},
+	}
+}
+
+// SetupRetryHandler runs the startup checks again with the settings as they are now
+func (a *App) SetupRetryHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	renderSetupResult(w, r)
+}
+
+// renderSetupResult runs the startup checks and opens the POS if they pass,
+// otherwise shows the remaining problem and refreshes the location list
+func renderSetupResult(w http.ResponseWriter, r *http.Request) {
+	if err := services.RunStartupChecks(); err == nil {
+		w.Header().Set("HX-Redirect", "/")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	htmx.Trigger(w, "setupRetried")
+	if err := settings.SetupStatus(services.SetupProblem()).Render(r.Context(), w); err != nil {
+		utils.Error("setup", "Error rendering setup status", "error", err)
+	}
+}
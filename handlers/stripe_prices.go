@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"checkout/handlers/htmx"
+	"checkout/services"
+	"checkout/templates/pos"
+	"checkout/utils"
+)
+
+// defaultPurgePriceDays is how old a temporary price must be before the purge form archives it
+const defaultPurgePriceDays = 30
+
+// PurgePricesHandler archives the temporary Stripe prices made for payment links.
+// GET asks how old they must be; POST archives them.
+func (a *App) PurgePricesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		if err := renderInfoModal(w, r, pos.PurgePricesModal(defaultPurgePriceDays)); err != nil {
+			utils.Error("stripe", "Error rendering purge prices form", "error", err)
+		}
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Error parsing form", http.StatusBadRequest)
+		return
+	}
+
+	days, err := strconv.Atoi(strings.TrimSpace(r.FormValue("days")))
+	if err != nil || days < 1 {
+		setToast(w, "warning", "toast.purge_prices_days")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	archived, err := services.PurgeTemporaryPrices(days)
+	utils.Info("audit", "Temporary prices purged", "older_than_days", days, "archived", archived, "user", currentUsername(r))
+	if err != nil {
+		setToast(w, "error", "toast.purge_prices_error", archived, err.Error())
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	setToast(w, "success", "toast.purge_prices_done", archived)
+	htmx.CloseModal(w)
+	w.WriteHeader(http.StatusOK)
+}
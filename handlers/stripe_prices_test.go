@@ -0,0 +1,165 @@
+package handlers
+
+import (
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stripe/stripe-go/v74"
+
+	"checkout/i18n"
+	"checkout/services"
+	"checkout/services/stripetest"
+	"checkout/templates"
+)
+
+// startQRPaymentAs shows a QR code for a register's cart and returns its payment link's ID
+func startQRPaymentAs(t *testing.T, app *App, session string, items ...templates.Product) string {
+	t.Helper()
+	app.Carts.Get(sessionCartKey(session)).Add(items...)
+	before := len(app.Payments.GetStatesByType("qr"))
+	postFormAs(session, app.GenerateQRCodeHandler, "/generate-qr-code", url.Values{})
+	states := app.Payments.GetStatesByType("qr")
+	if len(states) != before+1 {
+		t.Fatalf("QR payments = %d, want %d", len(states), before+1)
+	}
+	newest, _ := app.Payments.NewestFor(app.Carts.Get(sessionCartKey(session)))
+	return newest.GetID()
+}
+
+func TestPaymentLinkPricesReused(t *testing.T) {
+	app, fake, _ := newTestApp(t)
+	coffee := templates.Product{ID: "coffee", Name: "Coffee", Price: 4.50}
+	bagel := templates.Product{ID: "bagel", Name: "Bagel", Price: 3.25}
+
+	first := startQRPaymentAs(t, app, "register-1", coffee, coffee, bagel)
+	if got := fake.Calls("CreatePrice"); got != 2 {
+		t.Errorf("prices created for coffee, coffee and bagel = %d, want one each", got)
+	}
+	second := startQRPaymentAs(t, app, "register-2", coffee)
+	if got := fake.Calls("CreatePrice"); got != 2 {
+		t.Errorf("prices created after a second coffee link = %d, want still 2", got)
+	}
+	third := startQRPaymentAs(t, app, "register-3", templates.Product{ID: "coffee", Name: "Coffee", Price: 5.00})
+	if got := fake.Calls("CreatePrice"); got != 3 {
+		t.Errorf("prices created after a coffee at another price = %d, want 3", got)
+	}
+
+	firstPrices := fake.LinkPrices(first)
+	if len(firstPrices) != 3 || firstPrices[0] != firstPrices[1] || firstPrices[0] == firstPrices[2] {
+		t.Errorf("first link prices = %v, want both coffees on one price and the bagel on another", firstPrices)
+	}
+	if secondPrices := fake.LinkPrices(second); len(secondPrices) != 1 || secondPrices[0] != firstPrices[0] {
+		t.Errorf("second link prices = %v, want the coffee price %s", secondPrices, firstPrices[0])
+	}
+	if thirdPrices := fake.LinkPrices(third); len(thirdPrices) != 1 || thirdPrices[0] == firstPrices[0] {
+		t.Errorf("third link prices = %v, want a new price", thirdPrices)
+	}
+	for _, priceID := range append(firstPrices, fake.LinkPrices(third)...) {
+		if price := fake.Price(priceID); price.Metadata[services.TemporaryPriceMetadataKey] != "true" {
+			t.Errorf("price %s metadata = %v, want it tagged temporary", priceID, price.Metadata)
+		}
+	}
+}
+
+// usePricesClient points the purge, which uses the package-level client, at the fake
+func usePricesClient(t *testing.T, fake *stripetest.Client) {
+	t.Helper()
+	saved := services.Stripe
+	services.Stripe = fake
+	t.Cleanup(func() { services.Stripe = saved })
+}
+
+func TestPurgeTemporaryPrices(t *testing.T) {
+	app, fake, _ := newTestApp(t)
+	usePricesClient(t, fake)
+	old := time.Now().AddDate(0, 0, -45).Unix()
+	recent := time.Now().AddDate(0, 0, -3).Unix()
+	tagged := map[string]string{services.TemporaryPriceMetadataKey: "true"}
+	prices := []struct {
+		price        stripe.Price
+		wantArchived bool
+	}{
+		{stripe.Price{ID: "price_old_temporary", Active: true, Created: old, Metadata: tagged}, true},
+		{stripe.Price{ID: "price_old_untagged", Active: true, Created: old, Nickname: "Payment Link Coffee"}, true},
+		{stripe.Price{ID: "price_recent_temporary", Active: true, Created: recent, Metadata: tagged}, false},
+		{stripe.Price{ID: "price_old_catalog", Active: true, Created: old, Nickname: "Coffee"}, false},
+		{stripe.Price{ID: "price_old_archived", Active: false, Created: old, Metadata: tagged}, false},
+	}
+	for _, p := range prices {
+		fake.AddPrice(p.price)
+	}
+
+	rec := postForm(app.PurgePricesHandler, "/stripe/purge-prices", url.Values{"days": {"30"}})
+
+	if got, want := toastMessage(rec), i18n.T("toast.purge_prices_done", 2); got != want {
+		t.Errorf("toast = %q, want %q", got, want)
+	}
+	if got := fake.Calls("UpdatePrice"); got != 2 {
+		t.Errorf("prices updated = %d, want only the 2 archived", got)
+	}
+	for _, p := range prices {
+		if archived := p.price.Active && !fake.Price(p.price.ID).Active; archived != p.wantArchived {
+			t.Errorf("%s archived = %v, want %v", p.price.ID, archived, p.wantArchived)
+		}
+	}
+}
+
+func TestPurgeTemporaryPricesRefused(t *testing.T) {
+	tests := []struct {
+		name      string
+		days      string
+		fail      error
+		wantToast string
+		wantCalls int
+	}{
+		{"no age", "", nil, i18n.T("toast.purge_prices_days"), 0},
+		{"zero days", "0", nil, i18n.T("toast.purge_prices_days"), 0},
+		{"archive fails", "30", errors.New("rate limited"), i18n.T("toast.purge_prices_error", 0, "1 temporary prices could not be archived; see the logs"), 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, fake, _ := newTestApp(t)
+			usePricesClient(t, fake)
+			fake.AddPrice(stripe.Price{ID: "price_old_temporary", Active: true, Created: time.Now().AddDate(0, 0, -45).Unix(), Metadata: map[string]string{services.TemporaryPriceMetadataKey: "true"}})
+			if tt.fail != nil {
+				fake.FailNext("UpdatePrice", tt.fail)
+			}
+
+			rec := postForm(app.PurgePricesHandler, "/stripe/purge-prices", url.Values{"days": {tt.days}})
+
+			if got := toastMessage(rec); got != tt.wantToast {
+				t.Errorf("toast = %q, want %q", got, tt.wantToast)
+			}
+			if got := fake.Calls("UpdatePrice"); got != tt.wantCalls {
+				t.Errorf("prices updated = %d, want %d", got, tt.wantCalls)
+			}
+			if !fake.Price("price_old_temporary").Active {
+				t.Errorf("price archived")
+			}
+		})
+	}
+}
+
+// A purged price can't be put on a new link, so the next identical line gets a fresh one
+func TestPurgedPriceNotReused(t *testing.T) {
+	app, fake, _ := newTestApp(t)
+	usePricesClient(t, fake)
+	coffee := templates.Product{ID: "coffee", Name: "Coffee", Price: 4.50}
+	first := startQRPaymentAs(t, app, "register-1", coffee)
+	priceID := fake.LinkPrices(first)[0]
+
+	// The price was made long enough ago to be purged
+	aged := *fake.Price(priceID)
+	aged.Created = time.Now().AddDate(0, 0, -45).Unix()
+	fake.AddPrice(aged)
+	if archived, err := services.PurgeTemporaryPrices(30); err != nil || archived != 1 {
+		t.Fatalf("purge = %d, %v, want the link's price archived", archived, err)
+	}
+
+	second := startQRPaymentAs(t, app, "register-2", coffee)
+	if secondPrice := fake.LinkPrices(second)[0]; secondPrice == priceID {
+		t.Errorf("new link reused the archived price %s", priceID)
+	}
+}
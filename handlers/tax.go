@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+
+	"checkout/config"
+	"checkout/services"
+	"checkout/templates/pos"
+	"checkout/utils"
+)
+
+// quoteStripeTax gets the Stripe Tax quote for the cart as a payment starts, when the selected
+// location uses Stripe Tax. It reports false, with a toast, when the payment can't go ahead
+// because Stripe Tax could not work out the tax.
+func (a *App) quoteStripeTax(w http.ResponseWriter, cart *services.CartStore) bool {
+	if err := services.QuoteStripeTax(a.Stripe, cart); err != nil {
+		utils.Error("tax", "Error calculating Stripe Tax", "location_id", services.Terminal.SelectedLocation().ID, "error", err)
+		setToast(w, "error", "toast.stripe_tax_error", err.Error())
+		w.WriteHeader(http.StatusOK)
+		return false
+	}
+	return true
+}
+
+// TaxCheckHandler compares the local tax rates with Stripe Tax at the selected location.
+// GET asks for a past sale's confirmation code; POST compares that sale's items, or the
+// cart's when no code is given. Nothing is charged or recorded.
+func (a *App) TaxCheckHandler(w http.ResponseWriter, r *http.Request) {
+	location := services.Terminal.SelectedLocation()
+	mode := config.GetTaxMode(location.ID)
+
+	if r.Method == http.MethodGet {
+		if err := renderInfoModal(w, r, pos.TaxCheckModal(location, mode)); err != nil {
+			utils.Error("tax", "Error rendering tax check", "error", err)
+		}
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Error parsing form", http.StatusBadRequest)
+		return
+	}
+
+	items := a.cart(r).Items()
+	confirmationCode := strings.TrimSpace(r.FormValue("confirmation_code"))
+	if confirmationCode != "" {
+		sale, err := services.LoadTransactionByID(confirmationCode)
+		if err != nil {
+			utils.Info("tax", "Tax check found no sale", "confirmation_code", confirmationCode, "error", err)
+			setToast(w, "warning", "toast.sale_not_found")
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		items = sale.Products
+	}
+
+	comparisons, err := services.CompareStripeTax(a.Stripe, items, location)
+	if err != nil {
+		utils.Warn("tax", "Tax check failed", "confirmation_code", confirmationCode, "location_id", location.ID, "error", err)
+		setToast(w, "error", "toast.stripe_tax_error", err.Error())
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if err := renderModal(w, r, pos.TaxCheckResult(location, mode, confirmationCode, comparisons)); err != nil {
+		utils.Error("tax", "Error rendering tax check result", "error", err)
+	}
+}
+
+// TaxModeHandler switches the selected location between the local tax rates and Stripe Tax
+func (a *App) TaxModeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Error parsing form", http.StatusBadRequest)
+		return
+	}
+
+	location := services.Terminal.SelectedLocation()
+	if location.ID == "" {
+		setToast(w, "warning", "toast.tax_mode_no_location")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	mode := r.FormValue("tax_mode")
+	if err := config.SetTaxModeLocationOverride(location.ID, mode); err != nil {
+		utils.Error("tax", "Error saving tax mode", "location_id", location.ID, "tax_mode", mode, "error", err)
+		setToast(w, "error", "toast.tax_mode_error", err.Error())
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	utils.Info("audit", "Tax mode changed", "location_id", location.ID, "tax_mode", config.GetTaxMode(location.ID), "user", currentUsername(r))
+
+	if err := renderModal(w, r, pos.TaxCheckModal(location, config.GetTaxMode(location.ID))); err != nil {
+		utils.Error("tax", "Error rendering tax check", "error", err)
+	}
+}
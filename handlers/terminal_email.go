@@ -0,0 +1,187 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/a-h/templ"
+
+	"checkout/config"
+	"checkout/services"
+	"checkout/templates/checkout"
+	"checkout/utils"
+)
+
+// terminalEmailCollections tracks readers asking customers for a receipt email after a payment
+type terminalEmailCollections struct {
+	byCode map[string]*terminalEmailCollection // Confirmation code -> collection
+	mutex  sync.Mutex
+}
+
+// terminalEmailCollection is one reader's email request and the result reported by webhook, if any
+type terminalEmailCollection struct {
+	readerID  string
+	startTime time.Time
+	status    string
+	email     string
+}
+
+// paymentSuccessComponent returns the success modal for a terminal payment. When email collection
+// on the reader is enabled and the reader supports it, the customer is asked for their email there
+// and the modal waits for it instead of showing the receipt form.
+func (a *App) paymentSuccessComponent(confirmationCode, readerID string) templ.Component {
+	if !config.Config.TerminalCollectEmail || readerID == "" || !services.ReaderSupportsCollectInputs(readerID) {
+		return checkout.PaymentSuccess(confirmationCode, config.GetCheckoutFlow())
+	}
+	if err := services.StartReaderEmailCollection(readerID); err != nil {
+		utils.Warn("receipt", "Showing receipt form instead of collecting email on reader", "confirmation_code", confirmationCode, "error", err)
+		return checkout.PaymentSuccess(confirmationCode, config.GetCheckoutFlow())
+	}
+
+	a.terminalEmail.mutex.Lock()
+	if a.terminalEmail.byCode == nil {
+		a.terminalEmail.byCode = make(map[string]*terminalEmailCollection)
+	}
+	a.terminalEmail.byCode[confirmationCode] = &terminalEmailCollection{
+		readerID:  readerID,
+		startTime: time.Now(),
+		status:    services.ReaderEmailPending,
+	}
+	a.terminalEmail.mutex.Unlock()
+
+	return checkout.PaymentSuccessWithReceipt(confirmationCode, checkout.TerminalEmailCollection(confirmationCode), config.GetCheckoutFlow())
+}
+
+// TerminalEmailHandler checks on the customer entering a receipt email on the reader. The
+// receipt is sent once an email arrives; if the customer skipped or the reader failed, the
+// on-screen receipt form is shown instead.
+func (a *App) TerminalEmailHandler(w http.ResponseWriter, r *http.Request) {
+	confirmationCode := r.URL.Query().Get("id")
+
+	a.terminalEmail.mutex.Lock()
+	collection, found := a.terminalEmail.byCode[confirmationCode]
+	var status, email string
+	if found {
+		status, email = collection.status, collection.email
+	}
+	a.terminalEmail.mutex.Unlock()
+
+	if !found {
+		renderTerminalEmailResult(w, r, checkout.ReceiptForm(confirmationCode))
+		return
+	}
+
+	// In webhook mode the result arrives by webhook; the reader is only queried if it is overdue
+	if status == services.ReaderEmailPending &&
+		(config.GetCommunicationStrategy() == "polling" || time.Since(collection.startTime) >= config.GetWebhookFallbackDelay()) {
+		var err error
+		status, email, err = services.GetReaderEmailCollection(collection.readerID)
+		if err != nil {
+			utils.Warn("receipt", "Error checking reader email collection", "reader_id", collection.readerID, "error", err)
+		}
+	}
+
+	switch status {
+	case services.ReaderEmailPending:
+		if time.Since(collection.startTime) < config.PaymentTimeout {
+			renderTerminalEmailResult(w, r, checkout.TerminalEmailCollection(confirmationCode))
+			return
+		}
+		utils.Info("receipt", "Customer did not enter an email on the reader in time", "confirmation_code", confirmationCode)
+		a.cancelTerminalEmailCollection(confirmationCode)
+		renderTerminalEmailResult(w, r, checkout.ReceiptForm(confirmationCode))
+
+	case services.ReaderEmailCollected:
+		a.forgetTerminalEmailCollection(confirmationCode)
+		if _, err := sendReceipt(a.Stripe, confirmationCode, email, "", "terminal_collect_inputs"); err != nil {
+			// Let the cashier retry from the form with the address the customer entered
+			setToast(w, "error", "toast.receipt_failed_email")
+			renderTerminalEmailResult(w, r, checkout.ReceiptForm(confirmationCode))
+			return
+		}
+		renderTerminalEmailResult(w, r, checkout.TerminalEmailReceiptSent(email))
+
+	default:
+		utils.Info("receipt", "No email entered on the reader, showing receipt form", "confirmation_code", confirmationCode, "status", status)
+		a.forgetTerminalEmailCollection(confirmationCode)
+		renderTerminalEmailResult(w, r, checkout.ReceiptForm(confirmationCode))
+	}
+}
+
+// TerminalEmailCancelHandler stops asking for an email on the reader and shows the receipt form
+func (a *App) TerminalEmailCancelHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	confirmationCode := r.URL.Query().Get("id")
+	a.cancelTerminalEmailCollection(confirmationCode)
+	renderTerminalEmailResult(w, r, checkout.ReceiptForm(confirmationCode))
+}
+
+// cancelTerminalEmailCollection clears the email prompt from the reader and forgets the collection
+func (a *App) cancelTerminalEmailCollection(confirmationCode string) {
+	a.terminalEmail.mutex.Lock()
+	collection, found := a.terminalEmail.byCode[confirmationCode]
+	a.terminalEmail.mutex.Unlock()
+	if !found {
+		return
+	}
+	if _, err := a.Stripe.CancelReaderAction(collection.readerID); err != nil {
+		utils.Warn("receipt", "Error clearing email prompt from reader", "reader_id", collection.readerID, "error", err)
+	}
+	a.forgetTerminalEmailCollection(confirmationCode)
+}
+
+// clearReaderEmailCollections cancels email prompts still waiting on a reader, such as one left
+// behind when the success modal was closed, so the reader is free for the next payment
+func (a *App) clearReaderEmailCollections(readerID string) {
+	a.terminalEmail.mutex.Lock()
+	var codes []string
+	for code, collection := range a.terminalEmail.byCode {
+		if collection.readerID == readerID {
+			codes = append(codes, code)
+		}
+	}
+	a.terminalEmail.mutex.Unlock()
+
+	for _, code := range codes {
+		a.cancelTerminalEmailCollection(code)
+	}
+}
+
+func (a *App) forgetTerminalEmailCollection(confirmationCode string) {
+	a.terminalEmail.mutex.Lock()
+	delete(a.terminalEmail.byCode, confirmationCode)
+	a.terminalEmail.mutex.Unlock()
+}
+
+// renderTerminalEmailResult renders the receipt step of the success modal in place of the collection
+func renderTerminalEmailResult(w http.ResponseWriter, r *http.Request, component templ.Component) {
+	if err := component.Render(r.Context(), w); err != nil {
+		utils.Error("receipt", "Error rendering terminal email collection", "error", err)
+	}
+}
+
+// handleTerminalCollectInputs records the result of a reader's collect_inputs action reported by
+// webhook. It returns false for other reader actions.
+func (a *App) handleTerminalCollectInputs(raw json.RawMessage) bool {
+	var terminalReader services.TerminalReaderInputs
+	if err := json.Unmarshal(raw, &terminalReader); err != nil || terminalReader.Action == nil ||
+		terminalReader.Action.Type != "collect_inputs" {
+		return false
+	}
+	status, email := services.ReaderEmailResult(&terminalReader)
+
+	a.terminalEmail.mutex.Lock()
+	defer a.terminalEmail.mutex.Unlock()
+	for _, collection := range a.terminalEmail.byCode {
+		if collection.readerID == terminalReader.ID && collection.status == services.ReaderEmailPending {
+			collection.status, collection.email = status, email
+		}
+	}
+	utils.Debug("webhook", "Reader collect inputs finished", "reader_id", terminalReader.ID, "status", status)
+	return true
+}
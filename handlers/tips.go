@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+
+	"checkout/i18n"
+	"checkout/services"
+	"checkout/templates/checkout"
+	"checkout/utils"
+)
+
+// offerTip shows the tip selection before a QR or manual card payment when tipping applies
+// to the sale. Returns true if the tip form was rendered; SelectTipHandler continues the payment.
+// Split tenders never ask for a tip.
+func (a *App) offerTip(w http.ResponseWriter, r *http.Request, method string) bool {
+	cart := a.cart(r)
+	if cart.Split() != nil {
+		return false
+	}
+
+	summary := services.CalculateCartSummary(cart)
+	if !services.ShouldEnableTipping(summary.Total, cart.Items(), services.Terminal.SelectedLocation().ID) {
+		return false
+	}
+
+	component := checkout.TipSelection(method, summary.Subtotal, a.Config.TippingPresetPercentages, a.Config.TippingAllowCustomAmount)
+	if err := renderInfoModal(w, r, component); err != nil {
+		utils.Error("payment", "Error rendering tip selection", "method", method, "error", err)
+	}
+	return true
+}
+
+// SelectTipHandler records the tip chosen on screen and continues to the QR code or card form.
+// Preset tips are a percentage of the pre-tax subtotal; custom tips are a dollar amount.
+func (a *App) SelectTipHandler(w http.ResponseWriter, r *http.Request) {
+	cart := a.cart(r)
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Error parsing form", http.StatusBadRequest)
+		return
+	}
+
+	if cart.Len() == 0 {
+		setToast(w, "warning", "toast.cart_empty")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	method := r.FormValue("method")
+	if method != "qr" && method != "manual" {
+		http.Error(w, "Invalid payment method", http.StatusBadRequest)
+		return
+	}
+
+	var tip float64
+	if percentStr := r.FormValue("percent"); percentStr != "" {
+		percent, err := strconv.Atoi(percentStr)
+		if err != nil || percent < 0 {
+			http.Error(w, "Invalid tip percentage", http.StatusBadRequest)
+			return
+		}
+		tip = services.CalculateCartSummary(cart).Subtotal * float64(percent) / 100
+	} else if customStr := r.FormValue("custom_tip"); customStr != "" {
+		if !a.Config.TippingAllowCustomAmount {
+			http.Error(w, "Custom tips are not allowed", http.StatusBadRequest)
+			return
+		}
+		custom, err := strconv.ParseFloat(customStr, 64)
+		if err != nil || custom < 0 || math.IsInf(custom, 0) || math.IsNaN(custom) {
+			setToast(w, "warning", "toast.tip_not_negative", i18n.Money(0))
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		tip = custom
+	}
+
+	tip = math.Round(tip*100) / 100
+	cart.SetTip(tip)
+	utils.Info("payment", "Tip selected", "method", method, "tip", fmt.Sprintf("%.2f", tip))
+
+	if method == "qr" {
+		a.generateQRCode(w, r)
+		return
+	}
+	renderManualCardForm(w, r)
+}
@@ -0,0 +1,19 @@
+package handlers
+
+import (
+	"net/http"
+
+	"checkout/handlers/htmx"
+	"checkout/i18n"
+)
+
+// setToast shows a toast with the message for key in the configured language.
+// toastType is "success", "warning" or "error".
+func setToast(w http.ResponseWriter, toastType, key string, args ...any) {
+	setToastText(w, toastType, i18n.T(key, args...))
+}
+
+// setToastText shows a toast with text that is already final, such as an error from a service
+func setToastText(w http.ResponseWriter, toastType, text string) {
+	htmx.TriggerToast(w, toastType, text)
+}
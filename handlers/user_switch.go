@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"html"
+	"net/http"
+	"strings"
+	"time"
+
+	"checkout/config"
+	"checkout/handlers/htmx"
+	"checkout/i18n"
+	"checkout/templates"
+	"checkout/templates/pos"
+	"checkout/utils"
+)
+
+// SwitchUserHandler shows the PIN pad (GET) or switches the register to another user with their
+// PIN (POST). Only the session's user changes: the cart and any payment in progress stay as they
+// are, and later payments are credited to the new user. After too many wrong PINs the session is
+// signed out, so the register needs a full password login.
+func (a *App) SwitchUserHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		var users []templates.User
+		for _, user := range config.PINUsers() {
+			if !strings.EqualFold(user.Username, currentUsername(r)) {
+				users = append(users, user)
+			}
+		}
+		htmx.ShowModal(w)
+		if err := pos.SwitchUserModal(users).Render(r.Context(), w); err != nil {
+			utils.Error("auth", "Error rendering switch user modal", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
+
+	case http.MethodPost:
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "Error parsing form", http.StatusBadRequest)
+			return
+		}
+
+		from := currentUsername(r)
+		token := sessionToken(r)
+		user, ok := config.AuthenticatePIN(r.FormValue("username"), strings.TrimSpace(r.FormValue("pin")))
+		if !ok {
+			attempts := a.recordWrongPIN(token)
+			remaining := config.GetPINMaxAttempts() - attempts
+			utils.Warn("audit", "Wrong PIN entered to switch user", "user", from, "switch_to", r.FormValue("username"), "attempts", attempts)
+			if remaining <= 0 {
+				utils.Warn("audit", "PIN pad locked after too many wrong PINs, signing out", "user", from)
+				a.LogoutHandler(w, r)
+				return
+			}
+			w.Header().Set("Content-Type", "text/html")
+			if _, err := w.Write([]byte(`<div class="error-message">` + html.EscapeString(i18n.T("switch_user.invalid", remaining)) + `</div>`)); err != nil {
+				utils.Error("auth", "Error writing error message to response", "error", err)
+			}
+			return
+		}
+
+		a.switchSessionUser(token, user.Username, time.Now())
+		a.cart(r).SetCashier(user.Username)
+		utils.Info("audit", "User switched", "from", from, "user", user.Username, "role", user.Role)
+
+		// The POS is reloaded for the new user's role; the cart is kept on the server
+		w.Header().Set("HX-Redirect", "/")
+		w.WriteHeader(http.StatusOK)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// recordWrongPIN counts a wrong PIN entered by a session and returns how many it has entered
+// since its last switch
+func (a *App) recordWrongPIN(token string) int {
+	a.sessions.mutex.Lock()
+	defer a.sessions.mutex.Unlock()
+	session, ok := a.sessions.byToken[token]
+	if !ok {
+		return 0
+	}
+	session.pinAttempts++
+	return session.pinAttempts
+}
+
+// switchSessionUser makes username the session's user, restarting its inactivity timer
+func (a *App) switchSessionUser(token, username string, now time.Time) {
+	a.sessions.mutex.Lock()
+	defer a.sessions.mutex.Unlock()
+	if session, ok := a.sessions.byToken[token]; ok {
+		session.username = username
+		session.pinAttempts = 0
+		session.lastActivity = now
+	}
+}
@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -12,6 +13,7 @@ import (
 	"github.com/stripe/stripe-go/v74/webhook"
 
 	"checkout/config"
+	"checkout/i18n"
 	"checkout/services"
 	"checkout/utils"
 )
@@ -27,38 +29,47 @@ type WebhookPaymentState struct {
 	Metadata         map[string]string      `json:"metadata"`
 	LastPaymentError string                 `json:"last_payment_error,omitempty"` // Store as string for simplicity
 	AdditionalData   map[string]interface{} `json:"additional_data,omitempty"`
+	EventCreated     int64                  `json:"event_created"` // Unix timestamp of the webhook event that produced this state
+	Livemode         bool                   `json:"livemode"`      // Whether the event came from live mode rather than test mode
+	Consumed         bool                   `json:"consumed"`      // A final status the polling/SSE path has acted on
 }
 
 // WebhookStateCache manages cached payment states from webhooks
+// Terminal reader action events are cached under the PaymentIntent they processed,
+// so lookups by intent ID see both payment_intent.* and terminal.reader.action_* events
 type WebhookStateCache struct {
 	ByPaymentIntent map[string]*WebhookPaymentState `json:"by_payment_intent"`
 	ByPaymentLink   map[string]*WebhookPaymentState `json:"by_payment_link"`
-	ByReader        map[string]*WebhookPaymentState `json:"by_reader"`
+	Fallbacks       map[string]time.Time            `json:"-"` // Payments checked with the Stripe API for lack of webhooks
 	Mutex           sync.RWMutex                    `json:"-"`
+
+	clock Clock // Time states are stamped and expire by
 }
 
-// Global payment state cache
-var webhookCache = &WebhookStateCache{
-	ByPaymentIntent: make(map[string]*WebhookPaymentState),
-	ByPaymentLink:   make(map[string]*WebhookPaymentState),
-	ByReader:        make(map[string]*WebhookPaymentState),
+// NewWebhookStateCache creates an empty webhook state cache whose states expire by the given clock
+func NewWebhookStateCache(clock Clock) *WebhookStateCache {
+	return &WebhookStateCache{
+		ByPaymentIntent: make(map[string]*WebhookPaymentState),
+		ByPaymentLink:   make(map[string]*WebhookPaymentState),
+		Fallbacks:       make(map[string]time.Time),
+		clock:           clock,
+	}
 }
 
-// GetCachedPaymentState retrieves cached payment state by ID and type
-func GetCachedPaymentState(id, paymentType string) (*WebhookPaymentState, bool) {
-	webhookCache.Mutex.RLock()
-	defer webhookCache.Mutex.RUnlock()
+// GetCachedPaymentState retrieves cached payment state by ID and type.
+// States older than the cache TTL are treated as missing; cleanupExpiredStates removes them.
+func (a *App) GetCachedPaymentState(id, paymentType string) (*WebhookPaymentState, bool) {
+	a.Webhooks.Mutex.RLock()
+	defer a.Webhooks.Mutex.RUnlock()
 
 	var state *WebhookPaymentState
 	var exists bool
 
 	switch paymentType {
 	case "payment_intent":
-		state, exists = webhookCache.ByPaymentIntent[id]
+		state, exists = a.Webhooks.ByPaymentIntent[id]
 	case "payment_link":
-		state, exists = webhookCache.ByPaymentLink[id]
-	case "terminal":
-		state, exists = webhookCache.ByReader[id]
+		state, exists = a.Webhooks.ByPaymentLink[id]
 	default:
 		return nil, false
 	}
@@ -67,94 +78,209 @@ func GetCachedPaymentState(id, paymentType string) (*WebhookPaymentState, bool)
 		return nil, false
 	}
 
-	// Check if state has expired (120 seconds as per config)
-	if time.Since(state.LastUpdated) > config.PaymentTimeout {
-		// State expired, remove from cache
-		go func() {
-			webhookCache.Mutex.Lock()
-			defer webhookCache.Mutex.Unlock()
-
-			switch paymentType {
-			case "payment_intent":
-				delete(webhookCache.ByPaymentIntent, id)
-			case "payment_link":
-				delete(webhookCache.ByPaymentLink, id)
-			case "terminal":
-				delete(webhookCache.ByReader, id)
-			}
-		}()
+	// Mismatched events are rejected on arrival; never let one answer for a payment in the other mode
+	if state.Livemode != isLiveMode() {
+		return nil, false
+	}
+
+	if a.Webhooks.clock.Now().Sub(state.LastUpdated) > config.GetWebhookCacheTTL() {
 		return nil, false
 	}
 
 	return state, true
 }
 
-// setCachedPaymentState stores payment state in cache
-func setCachedPaymentState(id, paymentType string, state *WebhookPaymentState) {
-	webhookCache.Mutex.Lock()
-	defer webhookCache.Mutex.Unlock()
+// consumeCachedPaymentState marks a cached final status as acted on, so the next cleanup can drop it
+func (a *App) consumeCachedPaymentState(id, paymentType string) {
+	a.Webhooks.Mutex.Lock()
+	defer a.Webhooks.Mutex.Unlock()
+
+	var state *WebhookPaymentState
+	switch paymentType {
+	case "payment_intent":
+		state = a.Webhooks.ByPaymentIntent[id]
+	case "payment_link":
+		state = a.Webhooks.ByPaymentLink[id]
+	}
+	if state != nil && isFinalStatus(state.Status) {
+		state.Consumed = true
+	}
+}
+
+// resetCachedPaymentState drops the final status cached for a PaymentIntent retried after a
+// decline. The event time is kept, so late events of the declined attempt are still ignored.
+func (a *App) resetCachedPaymentState(intentID string) {
+	a.Webhooks.Mutex.Lock()
+	defer a.Webhooks.Mutex.Unlock()
+
+	state := a.Webhooks.ByPaymentIntent[intentID]
+	if state == nil || !isFinalStatus(state.Status) {
+		return
+	}
+	retry := *state
+	retry.Status = string(stripe.PaymentIntentStatusRequiresPaymentMethod)
+	retry.LastPaymentError = ""
+	retry.Consumed = false
+	retry.LastUpdated = a.Webhooks.clock.Now()
+	a.Webhooks.ByPaymentIntent[intentID] = &retry
+}
 
-	state.LastUpdated = time.Now()
+// setCachedPaymentState stores payment state in cache.
+// It returns false when the state was ignored because it would downgrade a final status
+// or came from an event older than the cached one.
+func (a *App) setCachedPaymentState(id, paymentType string, state *WebhookPaymentState) bool {
+	a.Webhooks.Mutex.Lock()
+	defer a.Webhooks.Mutex.Unlock()
 
+	var cache map[string]*WebhookPaymentState
 	switch paymentType {
 	case "payment_intent":
-		webhookCache.ByPaymentIntent[id] = state
+		cache = a.Webhooks.ByPaymentIntent
 	case "payment_link":
-		webhookCache.ByPaymentLink[id] = state
-	case "terminal":
-		webhookCache.ByReader[id] = state
+		cache = a.Webhooks.ByPaymentLink
+	default:
+		return false
 	}
 
+	if existing, exists := cache[id]; exists && existing != nil {
+		if state.EventCreated < existing.EventCreated {
+			utils.Debug("webhook", "Ignoring out-of-order event", "type", paymentType, "id", id,
+				"status", state.Status, "cached_status", existing.Status)
+			return false
+		}
+		if !canReplaceCachedStatus(existing.Status, state.Status) {
+			utils.Debug("webhook", "Ignoring status downgrade", "type", paymentType, "id", id,
+				"status", state.Status, "cached_status", existing.Status)
+			return false
+		}
+	}
+
+	state.LastUpdated = a.Webhooks.clock.Now()
+	cache[id] = state
+
 	utils.Debug("webhook", "Cached payment state", "type", paymentType, "id", id, "status", state.Status)
+	return true
+}
+
+// isSuccessStatus reports whether a cached status means the payment went through
+func isSuccessStatus(status string) bool {
+	switch status {
+	case "succeeded", "charge_succeeded", "action_succeeded", "completed":
+		return true
+	}
+	return false
+}
+
+// isFinalStatus reports whether a cached status is final (succeeded, canceled or failed)
+func isFinalStatus(status string) bool {
+	switch status {
+	case "canceled", "failed", "charge_failed", "action_failed", "inactive":
+		return true
+	}
+	return isSuccessStatus(status)
+}
+
+// canReplaceCachedStatus reports whether an incoming status may overwrite a cached one.
+// Final statuses are never downgraded; a failure may still be followed by a success
+// when the customer retries the same PaymentIntent with another card.
+func canReplaceCachedStatus(cached, incoming string) bool {
+	if !isFinalStatus(cached) {
+		return true
+	}
+	if isSuccessStatus(cached) {
+		return isSuccessStatus(incoming)
+	}
+	if cached == "canceled" || cached == "inactive" {
+		return false
+	}
+	return isFinalStatus(incoming)
 }
 
-// cleanupExpiredStates removes expired states from cache (called periodically)
-func cleanupExpiredStates() {
-	webhookCache.Mutex.Lock()
-	defer webhookCache.Mutex.Unlock()
+// cleanupExpiredStates removes states older than the cache TTL, and final states the polling/SSE
+// path has consumed (called periodically). This is the only place states are evicted, so a
+// payment that completes late in a long QR session is still seen by the next poll.
+func (a *App) cleanupExpiredStates() {
+	a.Webhooks.Mutex.Lock()
+	defer a.Webhooks.Mutex.Unlock()
 
-	now := time.Now()
-	expiry := config.PaymentTimeout
+	now := a.Webhooks.clock.Now()
+	ttl := config.GetWebhookCacheTTL()
 
 	// Cleanup payment intents
-	for id, state := range webhookCache.ByPaymentIntent {
-		if now.Sub(state.LastUpdated) > expiry {
-			delete(webhookCache.ByPaymentIntent, id)
-			utils.Debug("webhook", "Expired payment_intent state", "id", id)
+	for id, state := range a.Webhooks.ByPaymentIntent {
+		if state.Consumed || now.Sub(state.LastUpdated) > ttl {
+			delete(a.Webhooks.ByPaymentIntent, id)
+			utils.Debug("webhook", "Evicted payment_intent state", "id", id, "consumed", state.Consumed)
 		}
 	}
 
 	// Cleanup payment links
-	for id, state := range webhookCache.ByPaymentLink {
-		if now.Sub(state.LastUpdated) > expiry {
-			delete(webhookCache.ByPaymentLink, id)
-			utils.Debug("webhook", "Expired payment_link state", "id", id)
+	for id, state := range a.Webhooks.ByPaymentLink {
+		if state.Consumed || now.Sub(state.LastUpdated) > ttl {
+			delete(a.Webhooks.ByPaymentLink, id)
+			utils.Debug("webhook", "Evicted payment_link state", "id", id, "consumed", state.Consumed)
 		}
 	}
 
-	// Cleanup terminal readers
-	for id, state := range webhookCache.ByReader {
-		if now.Sub(state.LastUpdated) > expiry {
-			delete(webhookCache.ByReader, id)
-			utils.Debug("webhook", "Expired terminal state", "id", id)
+	// Payments outlive their fallback record by at most the payment timeout
+	for id, since := range a.Webhooks.Fallbacks {
+		if now.Sub(since) > config.PaymentTimeout {
+			delete(a.Webhooks.Fallbacks, id)
 		}
 	}
 }
 
-// Start periodic cleanup of expired states
-func init() {
+// shouldQueryStripe decides whether a payment status check may call the Stripe API.
+// Polling mode always does. In webhook mode the webhook cache is trusted until the payment has
+// gone the fallback delay without a webhook update, which covers webhooks that never arrive;
+// the first fallback for each payment is logged so webhook delivery problems show up.
+// Every check is counted by where its status came from.
+func (a *App) shouldQueryStripe(paymentID, cacheType string, startTime time.Time) bool {
+	strategy := config.GetCommunicationStrategy()
+	if strategy == "polling" {
+		services.RecordStatusCheck(strategy, "stripe_api")
+		return true
+	}
+
+	lastUpdate := startTime
+	if cached, found := a.GetCachedPaymentState(paymentID, cacheType); found && cached.LastUpdated.After(lastUpdate) {
+		lastUpdate = cached.LastUpdated
+	}
+	silence := a.Webhooks.clock.Now().Sub(lastUpdate)
+	if silence < config.GetWebhookFallbackDelay() {
+		services.RecordStatusCheck(strategy, "webhook_cache")
+		return false
+	}
+
+	a.Webhooks.Mutex.Lock()
+	_, logged := a.Webhooks.Fallbacks[paymentID]
+	if !logged {
+		a.Webhooks.Fallbacks[paymentID] = a.Webhooks.clock.Now()
+	}
+	a.Webhooks.Mutex.Unlock()
+	if !logged {
+		utils.Warn("webhook", "No webhook update for payment, checking Stripe directly",
+			"payment_id", paymentID, "seconds_without_update", int(silence.Seconds()))
+	}
+
+	services.RecordStatusCheck(strategy, "stripe_api")
+	return true
+}
+
+// startWebhookCacheCleanup periodically removes expired states from the webhook cache
+func (a *App) startWebhookCacheCleanup() {
 	go func() {
 		ticker := time.NewTicker(30 * time.Second) // Cleanup every 30 seconds
 		defer ticker.Stop()
 
 		for range ticker.C {
-			cleanupExpiredStates()
+			a.cleanupExpiredStates()
 		}
 	}()
 }
 
 // StripeWebhookHandler processes Stripe webhook events
-func StripeWebhookHandler(w http.ResponseWriter, r *http.Request) {
+func (a *App) StripeWebhookHandler(w http.ResponseWriter, r *http.Request) {
 	// Read request body
 	payload, err := io.ReadAll(r.Body)
 	if err != nil {
@@ -165,67 +291,106 @@ func StripeWebhookHandler(w http.ResponseWriter, r *http.Request) {
 
 	// Get Stripe signature from header
 	sigHeader := r.Header.Get("Stripe-Signature")
-	webhookSecret := config.GetStripeWebhookSecret()
+	webhookSecrets := config.GetStripeWebhookSecrets()
 
-	if webhookSecret == "" {
+	if len(webhookSecrets) == 0 {
 		utils.Warn("webhook", "Stripe webhook secret not configured")
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
 	// Verify signature
-	event, err := webhook.ConstructEvent(payload, sigHeader, webhookSecret)
+	event, err := constructWebhookEvent(payload, sigHeader, webhookSecrets)
 	if err != nil {
-		utils.Error("webhook", "Signature verification failed", "error", err)
+		// A clock that drifted makes every signature look expired, which is otherwise hard to tell
+		// from a wrong signing secret
+		if clock := services.ClockSkewStatus(); clock.Excessive() {
+			utils.Error("webhook", fmt.Sprintf("Signature verification failed, likely clock skew of %ds", clock.Seconds()),
+				"skew_seconds", clock.Seconds(), "error", err)
+		} else if errors.Is(err, webhook.ErrTooOld) {
+			utils.Error("webhook", "Signature verification failed: timestamp outside the tolerance, check the system clock", "error", err)
+		} else {
+			utils.Error("webhook", "Signature verification failed", "error", err)
+		}
+		services.RecordWebhookSignatureFailure(a.Clock.Now())
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 
 	utils.Info("webhook", "Received event", "type", event.Type, "id", event.ID)
+	objectID, _ := event.Data.Object["id"].(string)
+	services.RecordWebhookDelivery(string(event.Type), objectID, a.Clock.Now())
+
+	// A test-mode endpoint pointed at a live POS (or the reverse) must not complete real payments.
+	// Acknowledge the event so Stripe stops retrying it.
+	if event.Livemode != isLiveMode() {
+		utils.Warn("webhook", "Rejected event from the wrong Stripe mode; check the webhook endpoint and signing secret",
+			"type", event.Type, "id", event.ID, "event_livemode", event.Livemode, "pos_livemode", isLiveMode())
+		services.RecordWebhookLivemodeMismatch(event.Livemode)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	services.RecordWebhookEvent(string(event.Type))
+
+	// Stripe may deliver the same event more than once; acknowledge duplicates without reprocessing
+	if !services.MarkWebhookEventProcessed(event.ID) {
+		utils.Debug("webhook", "Ignoring duplicate event", "type", event.Type, "id", event.ID)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
 
 	// Handle different event types
 	switch event.Type {
 	case "payment_intent.created":
-		handlePaymentIntentCreated(event.Data.Raw)
+		a.handlePaymentIntentCreated(event.Data.Raw, event.Created)
 
 	case "payment_intent.succeeded":
-		handlePaymentIntentSucceeded(event.Data.Raw)
-		sendSSEUpdateFromWebhook(event)
+		if a.handlePaymentIntentSucceeded(event.Data.Raw, event.Created) {
+			a.sendSSEUpdateFromWebhook(event)
+		}
 
 	case "payment_intent.payment_failed":
-		handlePaymentIntentFailed(event.Data.Raw)
-		sendSSEUpdateFromWebhook(event)
+		if a.handlePaymentIntentFailed(event.Data.Raw, event.Created) {
+			a.sendSSEUpdateFromWebhook(event)
+		}
 
 	case "payment_intent.canceled":
-		handlePaymentIntentCanceled(event.Data.Raw)
-		sendSSEUpdateFromWebhook(event)
+		if a.handlePaymentIntentCanceled(event.Data.Raw, event.Created) {
+			a.sendSSEUpdateFromWebhook(event)
+		}
 
 	case "payment_intent.requires_action":
-		handlePaymentIntentRequiresAction(event.Data.Raw)
-		sendSSEUpdateFromWebhook(event)
+		if a.handlePaymentIntentRequiresAction(event.Data.Raw, event.Created) {
+			a.sendSSEUpdateFromWebhook(event)
+		}
 
-	case "payment_link.completed":
-		handlePaymentLinkCompleted(event.Data.Raw)
-		sendSSEUpdateFromWebhook(event)
+	case "checkout.session.completed":
+		if a.handleCheckoutSessionCompleted(event.Data.Raw, event.Created) {
+			a.sendSSEUpdateFromWebhook(event)
+		}
 
 	case "payment_link.updated":
-		handlePaymentLinkUpdated(event.Data.Raw)
+		a.handlePaymentLinkUpdated(event.Data.Raw, event.Created)
 
 	case "terminal.reader.action_succeeded":
-		handleTerminalActionSucceeded(event.Data.Raw)
-		sendSSEUpdateFromWebhook(event)
+		if a.handleTerminalActionSucceeded(event.Data.Raw, event.Created) {
+			a.sendSSEUpdateFromWebhook(event)
+		}
 
 	case "terminal.reader.action_failed":
-		handleTerminalActionFailed(event.Data.Raw)
-		sendSSEUpdateFromWebhook(event)
+		if a.handleTerminalActionFailed(event.Data.Raw, event.Created) {
+			a.sendSSEUpdateFromWebhook(event)
+		}
 
 	case "charge.succeeded":
-		handleChargeSucceeded(event.Data.Raw)
-		sendSSEUpdateFromWebhook(event)
+		if a.handleChargeSucceeded(event.Data.Raw, event.Created) {
+			a.sendSSEUpdateFromWebhook(event)
+		}
 
 	case "charge.failed":
-		handleChargeFailed(event.Data.Raw)
-		sendSSEUpdateFromWebhook(event)
+		if a.handleChargeFailed(event.Data.Raw, event.Created) {
+			a.sendSSEUpdateFromWebhook(event)
+		}
 
 	default:
 		utils.Error("webhook", "Unhandled event type", "type", event.Type)
@@ -235,53 +400,87 @@ func StripeWebhookHandler(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
+// constructWebhookEvent verifies an event's signature against each configured secret in turn,
+// so a new signing secret can be added before the old one is removed while rotating
+func constructWebhookEvent(payload []byte, sigHeader string, secrets []string) (stripe.Event, error) {
+	var err error
+	for i, secret := range secrets {
+		var event stripe.Event
+		event, err = webhook.ConstructEventWithOptions(payload, sigHeader, secret, webhook.ConstructEventOptions{
+			Tolerance: config.GetWebhookTolerance(),
+		})
+		if err == nil {
+			if i > 0 {
+				utils.Debug("webhook", "Event verified with an alternate signing secret", "id", event.ID, "secret_index", i)
+			}
+			return event, nil
+		}
+	}
+	return stripe.Event{}, err
+}
+
+// isLiveMode reports whether the POS runs with a live Stripe key
+func isLiveMode() bool {
+	return !services.UI.Layout().IsTestMode
+}
+
 // Helper functions for webhook event handling
 
-func handlePaymentIntentCreated(raw json.RawMessage) {
+func (a *App) handlePaymentIntentCreated(raw json.RawMessage, created int64) bool {
 	var intent stripe.PaymentIntent
 	if err := json.Unmarshal(raw, &intent); err != nil {
 		utils.Error("webhook", "Error parsing payment_intent.created", "error", err)
-		return
+		return false
 	}
 
 	state := &WebhookPaymentState{
-		ID:          intent.ID,
-		Status:      string(intent.Status),
-		PaymentType: "payment_intent",
-		Amount:      intent.Amount,
-		Currency:    string(intent.Currency),
-		Metadata:    intent.Metadata,
+		ID:           intent.ID,
+		Status:       string(intent.Status),
+		PaymentType:  "payment_intent",
+		Amount:       intent.Amount,
+		Currency:     string(intent.Currency),
+		Metadata:     intent.Metadata,
+		EventCreated: created,
+		Livemode:     intent.Livemode,
 	}
 
-	setCachedPaymentState(intent.ID, "payment_intent", state)
+	if !a.setCachedPaymentState(intent.ID, "payment_intent", state) {
+		return false
+	}
 	utils.Debug("webhook", "Payment intent created", "id", intent.ID, "amount", intent.Amount, "currency", intent.Currency)
+	return true
 }
 
-func handlePaymentIntentSucceeded(raw json.RawMessage) {
+func (a *App) handlePaymentIntentSucceeded(raw json.RawMessage, created int64) bool {
 	var intent stripe.PaymentIntent
 	if err := json.Unmarshal(raw, &intent); err != nil {
 		utils.Error("webhook", "Error parsing payment_intent.succeeded", "error", err)
-		return
+		return false
 	}
 
 	state := &WebhookPaymentState{
-		ID:          intent.ID,
-		Status:      "succeeded",
-		PaymentType: "payment_intent",
-		Amount:      intent.Amount,
-		Currency:    string(intent.Currency),
-		Metadata:    intent.Metadata,
+		ID:           intent.ID,
+		Status:       "succeeded",
+		PaymentType:  "payment_intent",
+		Amount:       intent.Amount,
+		Currency:     string(intent.Currency),
+		Metadata:     intent.Metadata,
+		EventCreated: created,
+		Livemode:     intent.Livemode,
 	}
 
-	setCachedPaymentState(intent.ID, "payment_intent", state)
+	if !a.setCachedPaymentState(intent.ID, "payment_intent", state) {
+		return false
+	}
 	utils.Info("webhook", "Payment intent succeeded", "id", intent.ID, "amount", intent.Amount)
+	return true
 }
 
-func handlePaymentIntentFailed(raw json.RawMessage) {
+func (a *App) handlePaymentIntentFailed(raw json.RawMessage, created int64) bool {
 	var intent stripe.PaymentIntent
 	if err := json.Unmarshal(raw, &intent); err != nil {
 		utils.Error("webhook", "Error parsing payment_intent.payment_failed", "error", err)
-		return
+		return false
 	}
 
 	errorMessage := "unknown error"
@@ -297,165 +496,290 @@ func handlePaymentIntentFailed(raw json.RawMessage) {
 		Currency:         string(intent.Currency),
 		Metadata:         intent.Metadata,
 		LastPaymentError: errorMessage,
+		EventCreated:     created,
+		Livemode:         intent.Livemode,
 	}
 
-	setCachedPaymentState(intent.ID, "payment_intent", state)
+	if !a.setCachedPaymentState(intent.ID, "payment_intent", state) {
+		return false
+	}
 	utils.Error("webhook", "Payment intent failed", "id", intent.ID, "reason", errorMessage)
+	return true
 }
 
-func handlePaymentIntentCanceled(raw json.RawMessage) {
+func (a *App) handlePaymentIntentCanceled(raw json.RawMessage, created int64) bool {
 	var intent stripe.PaymentIntent
 	if err := json.Unmarshal(raw, &intent); err != nil {
 		utils.Error("webhook", "Error parsing payment_intent.canceled", "error", err)
-		return
+		return false
 	}
 
 	state := &WebhookPaymentState{
-		ID:          intent.ID,
-		Status:      "canceled",
-		PaymentType: "payment_intent",
-		Amount:      intent.Amount,
-		Currency:    string(intent.Currency),
-		Metadata:    intent.Metadata,
+		ID:           intent.ID,
+		Status:       "canceled",
+		PaymentType:  "payment_intent",
+		Amount:       intent.Amount,
+		Currency:     string(intent.Currency),
+		Metadata:     intent.Metadata,
+		EventCreated: created,
+		Livemode:     intent.Livemode,
 	}
 
-	setCachedPaymentState(intent.ID, "payment_intent", state)
+	if !a.setCachedPaymentState(intent.ID, "payment_intent", state) {
+		return false
+	}
 	utils.Info("webhook", "Payment intent canceled", "id", intent.ID)
+	return true
 }
 
-func handlePaymentIntentRequiresAction(raw json.RawMessage) {
+func (a *App) handlePaymentIntentRequiresAction(raw json.RawMessage, created int64) bool {
 	var intent stripe.PaymentIntent
 	if err := json.Unmarshal(raw, &intent); err != nil {
 		utils.Error("webhook", "Error parsing payment_intent.requires_action", "error", err)
-		return
+		return false
 	}
 
 	state := &WebhookPaymentState{
-		ID:          intent.ID,
-		Status:      "requires_action",
-		PaymentType: "payment_intent",
-		Amount:      intent.Amount,
-		Currency:    string(intent.Currency),
-		Metadata:    intent.Metadata,
+		ID:           intent.ID,
+		Status:       "requires_action",
+		PaymentType:  "payment_intent",
+		Amount:       intent.Amount,
+		Currency:     string(intent.Currency),
+		Metadata:     intent.Metadata,
+		EventCreated: created,
+		Livemode:     intent.Livemode,
 	}
 
-	setCachedPaymentState(intent.ID, "payment_intent", state)
+	if !a.setCachedPaymentState(intent.ID, "payment_intent", state) {
+		return false
+	}
 	utils.Debug("webhook", "Payment intent requires action", "id", intent.ID)
+	return true
 }
 
-func handlePaymentLinkCompleted(raw json.RawMessage) {
-	var paymentLink stripe.PaymentLink
-	if err := json.Unmarshal(raw, &paymentLink); err != nil {
-		utils.Error("webhook", "Error parsing payment_link.completed", "error", err)
-		return
+// handleCheckoutSessionCompleted caches payment link completion.
+// Stripe has no payment_link.completed event; a paid link surfaces as a checkout session referencing it.
+func (a *App) handleCheckoutSessionCompleted(raw json.RawMessage, created int64) bool {
+	session, paymentLinkID := parseCheckoutSessionPaymentLink(raw)
+	if paymentLinkID == "" {
+		return false
+	}
+
+	// A link is single use: deactivate it on the first payment and flag any payment after that
+	if _, err := a.Stripe.DeactivatePaymentLink(paymentLinkID); err != nil {
+		utils.Error("webhook", "Error deactivating paid payment link", "payment_link_id", paymentLinkID, "error", err)
+	}
+	if duplicates, err := services.FlagDuplicateLinkPayments(a.Stripe, paymentLinkID); err != nil {
+		utils.Error("webhook", "Error checking payment link for duplicate payments", "payment_link_id", paymentLinkID, "error", err)
+	} else if len(duplicates) > 0 {
+		utils.Warn("webhook", "Payment link paid more than once", "payment_link_id", paymentLinkID, "duplicates", len(duplicates))
+	}
+
+	// A link sent to the customer completes its sale here; no payment modal is waiting on it
+	if services.IsOutstandingSentLink(paymentLinkID) {
+		status := services.PaymentLinkStatus{Completed: true, SessionID: session.ID, AutomaticTax: session.AutomaticTax != nil && session.AutomaticTax.Enabled}
+		if session.CustomerDetails != nil {
+			status.CustomerEmail = session.CustomerDetails.Email
+		}
+		a.completeSentLink(paymentLinkID, status)
+		return false
+	}
+
+	metadata := make(map[string]string)
+	for key, value := range session.Metadata {
+		metadata[key] = value
+	}
+	if session.CustomerDetails != nil && session.CustomerDetails.Email != "" {
+		metadata["customer_email"] = session.CustomerDetails.Email
 	}
 
 	state := &WebhookPaymentState{
-		ID:          paymentLink.ID,
-		Status:      "completed",
-		PaymentType: "payment_link",
-		Metadata:    paymentLink.Metadata,
+		ID:           paymentLinkID,
+		Status:       "completed",
+		PaymentType:  "payment_link",
+		Amount:       session.AmountTotal,
+		Currency:     string(session.Currency),
+		Metadata:     metadata,
+		EventCreated: created,
+		Livemode:     session.Livemode,
+		AdditionalData: map[string]interface{}{
+			"checkout_session_id": session.ID,
+		},
+	}
+
+	if !a.setCachedPaymentState(paymentLinkID, "payment_link", state) {
+		return false
+	}
+	utils.Info("webhook", "Payment link completed", "id", paymentLinkID, "session_id", session.ID)
+	return true
+}
+
+// parseCheckoutSessionPaymentLink parses a checkout.session.completed payload and returns the session
+// along with the ID of the payment link it was created from.
+// The link ID is empty for sessions not created from a payment link or malformed payloads.
+func parseCheckoutSessionPaymentLink(raw json.RawMessage) (*stripe.CheckoutSession, string) {
+	var session stripe.CheckoutSession
+	if err := json.Unmarshal(raw, &session); err != nil {
+		utils.Error("webhook", "Error parsing checkout.session.completed", "error", err)
+		return nil, ""
 	}
 
-	setCachedPaymentState(paymentLink.ID, "payment_link", state)
-	utils.Info("webhook", "Payment link completed", "id", paymentLink.ID)
+	if session.PaymentLink == nil || session.PaymentLink.ID == "" {
+		utils.Debug("webhook", "Checkout session without payment link, ignoring", "session_id", session.ID)
+		return nil, ""
+	}
+
+	return &session, session.PaymentLink.ID
 }
 
-func handlePaymentLinkUpdated(raw json.RawMessage) {
+func (a *App) handlePaymentLinkUpdated(raw json.RawMessage, created int64) bool {
 	var paymentLink stripe.PaymentLink
 	if err := json.Unmarshal(raw, &paymentLink); err != nil {
 		utils.Error("webhook", "Error parsing payment_link.updated", "error", err)
-		return
+		return false
 	}
 
 	// Only cache if status changed to something meaningful
 	if !paymentLink.Active {
 		state := &WebhookPaymentState{
-			ID:          paymentLink.ID,
-			Status:      "inactive",
-			PaymentType: "payment_link",
-			Metadata:    paymentLink.Metadata,
+			ID:           paymentLink.ID,
+			Status:       "inactive",
+			PaymentType:  "payment_link",
+			Metadata:     paymentLink.Metadata,
+			EventCreated: created,
+			Livemode:     paymentLink.Livemode,
 		}
 
-		setCachedPaymentState(paymentLink.ID, "payment_link", state)
+		if !a.setCachedPaymentState(paymentLink.ID, "payment_link", state) {
+			return false
+		}
 		utils.Debug("webhook", "Payment link updated to inactive", "id", paymentLink.ID)
 	}
+	return true
 }
 
-func handleTerminalActionSucceeded(raw json.RawMessage) {
-	// Terminal events have a different structure, may need adjustment
-	var event map[string]interface{}
-	if err := json.Unmarshal(raw, &event); err != nil {
-		utils.Error("webhook", "Error parsing terminal.reader.action_succeeded", "error", err)
-		return
+func (a *App) handleTerminalActionSucceeded(raw json.RawMessage, created int64) bool {
+	if a.handleTerminalCollectInputs(raw) {
+		return false // Read by the receipt step of the success modal, not a payment update
+	}
+	terminalReader, intentID := parseTerminalReaderAction(raw, "terminal.reader.action_succeeded")
+	if intentID == "" {
+		return false
 	}
 
-	// Extract reader ID and relevant info
-	if reader, ok := event["object"].(map[string]interface{}); ok {
-		if readerID, ok := reader["id"].(string); ok {
-			state := &WebhookPaymentState{
-				ID:             readerID,
-				Status:         "action_succeeded",
-				PaymentType:    "terminal",
-				AdditionalData: event,
-			}
+	state := &WebhookPaymentState{
+		ID:          intentID,
+		Status:      "action_succeeded",
+		PaymentType: "terminal",
+		Metadata:    terminalActionIntentMetadata(terminalReader),
+		AdditionalData: map[string]interface{}{
+			"reader_id": terminalReader.ID,
+		},
+		EventCreated: created,
+		Livemode:     terminalReader.Livemode,
+	}
+
+	if !a.setCachedPaymentState(intentID, "payment_intent", state) {
+		return false
+	}
+	utils.Debug("webhook", "Terminal action succeeded", "reader_id", terminalReader.ID, "intent_id", intentID)
+	return true
+}
 
-			setCachedPaymentState(readerID, "terminal", state)
-			utils.Debug("webhook", "Terminal action succeeded", "reader_id", readerID)
-		}
+func (a *App) handleTerminalActionFailed(raw json.RawMessage, created int64) bool {
+	if a.handleTerminalCollectInputs(raw) {
+		return false // Read by the receipt step of the success modal, not a payment update
+	}
+	terminalReader, intentID := parseTerminalReaderAction(raw, "terminal.reader.action_failed")
+	if intentID == "" {
+		return false
 	}
-}
 
-func handleTerminalActionFailed(raw json.RawMessage) {
-	var event map[string]interface{}
-	if err := json.Unmarshal(raw, &event); err != nil {
-		utils.Error("webhook", "Error parsing terminal.reader.action_failed", "error", err)
-		return
+	errorMessage := terminalReader.Action.FailureMessage
+	if errorMessage == "" {
+		errorMessage = terminalReader.Action.FailureCode
 	}
 
-	if reader, ok := event["object"].(map[string]interface{}); ok {
-		if readerID, ok := reader["id"].(string); ok {
-			state := &WebhookPaymentState{
-				ID:             readerID,
-				Status:         "action_failed",
-				PaymentType:    "terminal",
-				AdditionalData: event,
-			}
+	state := &WebhookPaymentState{
+		ID:               intentID,
+		Status:           "action_failed",
+		PaymentType:      "terminal",
+		Metadata:         terminalActionIntentMetadata(terminalReader),
+		LastPaymentError: errorMessage,
+		AdditionalData: map[string]interface{}{
+			"reader_id":    terminalReader.ID,
+			"failure_code": terminalReader.Action.FailureCode,
+		},
+		EventCreated: created,
+		Livemode:     terminalReader.Livemode,
+	}
 
-			setCachedPaymentState(readerID, "terminal", state)
-			utils.Error("webhook", "Terminal action failed", "reader_id", readerID)
-		}
+	if !a.setCachedPaymentState(intentID, "payment_intent", state) {
+		return false
+	}
+	utils.Error("webhook", "Terminal action failed", "reader_id", terminalReader.ID, "intent_id", intentID, "reason", errorMessage)
+	return true
+}
+
+// parseTerminalReaderAction parses a terminal.reader.action_* payload and returns the reader
+// along with the ID of the PaymentIntent its process_payment_intent action was working on.
+// The intent ID is empty for other action types or malformed payloads.
+func parseTerminalReaderAction(raw json.RawMessage, eventType string) (*stripe.TerminalReader, string) {
+	var terminalReader stripe.TerminalReader
+	if err := json.Unmarshal(raw, &terminalReader); err != nil {
+		utils.Error("webhook", "Error parsing "+eventType, "error", err)
+		return nil, ""
+	}
+
+	if terminalReader.Action == nil || terminalReader.Action.ProcessPaymentIntent == nil ||
+		terminalReader.Action.ProcessPaymentIntent.PaymentIntent == nil ||
+		terminalReader.Action.ProcessPaymentIntent.PaymentIntent.ID == "" {
+		utils.Debug("webhook", "Terminal action without payment intent, ignoring", "type", eventType, "reader_id", terminalReader.ID)
+		return nil, ""
 	}
+
+	return &terminalReader, terminalReader.Action.ProcessPaymentIntent.PaymentIntent.ID
+}
+
+// terminalActionIntentMetadata returns the POS metadata of the PaymentIntent embedded in a reader action,
+// which is only present when Stripe expanded the intent in the payload
+func terminalActionIntentMetadata(terminalReader *stripe.TerminalReader) map[string]string {
+	return terminalReader.Action.ProcessPaymentIntent.PaymentIntent.Metadata
 }
 
-func handleChargeSucceeded(raw json.RawMessage) {
+func (a *App) handleChargeSucceeded(raw json.RawMessage, created int64) bool {
 	var charge stripe.Charge
 	if err := json.Unmarshal(raw, &charge); err != nil {
 		utils.Error("webhook", "Error parsing charge.succeeded", "error", err)
-		return
+		return false
 	}
 
 	// Cache charge success as backup confirmation
 	if charge.PaymentIntent != nil {
 		state := &WebhookPaymentState{
-			ID:          charge.PaymentIntent.ID,
-			Status:      "charge_succeeded",
-			PaymentType: "payment_intent",
-			Amount:      charge.Amount,
-			Currency:    string(charge.Currency),
-			Metadata:    charge.Metadata,
+			ID:           charge.PaymentIntent.ID,
+			Status:       "charge_succeeded",
+			PaymentType:  "payment_intent",
+			Amount:       charge.Amount,
+			Currency:     string(charge.Currency),
+			Metadata:     charge.Metadata,
+			EventCreated: created,
+			Livemode:     charge.Livemode,
 		}
 
-		setCachedPaymentState(charge.PaymentIntent.ID, "payment_intent", state)
+		if !a.setCachedPaymentState(charge.PaymentIntent.ID, "payment_intent", state) {
+			return false
+		}
 		utils.Info("webhook", "Charge succeeded", "payment_intent_id", charge.PaymentIntent.ID, "amount", charge.Amount)
 	}
+	return true
 }
 
-func handleChargeFailed(raw json.RawMessage) {
+func (a *App) handleChargeFailed(raw json.RawMessage, created int64) bool {
 	var charge stripe.Charge
 	if err := json.Unmarshal(raw, &charge); err != nil {
 		utils.Error("webhook", "Error parsing charge.failed", "error", err)
-		return
+		return false
 	}
 
 	errorMessage := "unknown error"
@@ -472,42 +796,49 @@ func handleChargeFailed(raw json.RawMessage) {
 			Currency:         string(charge.Currency),
 			Metadata:         charge.Metadata,
 			LastPaymentError: errorMessage,
+			EventCreated:     created,
+			Livemode:         charge.Livemode,
 		}
 
-		setCachedPaymentState(charge.PaymentIntent.ID, "payment_intent", state)
+		if !a.setCachedPaymentState(charge.PaymentIntent.ID, "payment_intent", state) {
+			return false
+		}
 		utils.Error("webhook", "Charge failed", "payment_intent_id", charge.PaymentIntent.ID, "reason", errorMessage)
 	}
+	return true
 }
 
-// sendSSEUpdateFromWebhook sends SSE updates based on webhook events
-func sendSSEUpdateFromWebhook(event stripe.Event) {
+// sendSSEUpdateFromWebhook settles a tracked payment from a webhook event. A final result is
+// recorded here whether or not a browser is still connected, since the cashier may have closed the
+// tab; the update for the payment modal is queued until it reconnects. PaymentStateManager.Conclude
+// keeps the status checks from recording the same result again.
+func (a *App) sendSSEUpdateFromWebhook(event stripe.Event) {
 	switch event.Type {
 	case "payment_intent.succeeded", "payment_intent.payment_failed", "payment_intent.canceled":
 		if paymentIntent := extractPaymentIntentFromEvent(event); paymentIntent != nil {
-			sendTerminalSSEUpdate(paymentIntent.ID, paymentIntent)
+			a.sendTerminalSSEUpdate(paymentIntent.ID, paymentIntent)
 		}
-	case "payment_link.completed":
-		if paymentLinkID := extractPaymentLinkIDFromEvent(event); paymentLinkID != "" {
-			sendQRSSEUpdate(paymentLinkID, "completed")
+	case "checkout.session.completed":
+		if _, paymentLinkID := parseCheckoutSessionPaymentLink(event.Data.Raw); paymentLinkID != "" {
+			a.sendQRSSEUpdate(paymentLinkID, "completed")
 		}
 	case "terminal.reader.action_succeeded", "terminal.reader.action_failed":
-		actionData := extractTerminalActionFromEvent(event)
-		if rawData, ok := actionData.(json.RawMessage); ok && len(rawData) > 0 {
-			sendTerminalActionSSEUpdate(actionData)
+		if terminalReader, intentID := parseTerminalReaderAction(event.Data.Raw, string(event.Type)); intentID != "" {
+			a.sendTerminalActionSSEUpdate(intentID, terminalReader.Action)
 		}
 	case "charge.succeeded", "charge.failed":
 		if charge := extractChargeFromEvent(event); charge != nil {
 			// Try to find associated payment intent
 			if charge.PaymentIntent != nil {
-				sendTerminalSSEUpdate(charge.PaymentIntent.ID, charge.PaymentIntent)
+				a.sendTerminalSSEUpdate(charge.PaymentIntent.ID, charge.PaymentIntent)
 			}
 		}
 	}
 }
 
 // sendTerminalSSEUpdate sends SSE update for terminal payments
-func sendTerminalSSEUpdate(intentID string, intent *stripe.PaymentIntent) {
-	state, exists := GlobalPaymentStateManager.GetPayment(intentID)
+func (a *App) sendTerminalSSEUpdate(intentID string, intent *stripe.PaymentIntent) {
+	state, exists := a.Payments.GetPayment(intentID)
 	if !exists {
 		return
 	}
@@ -521,12 +852,16 @@ func sendTerminalSSEUpdate(intentID string, intent *stripe.PaymentIntent) {
 
 	switch intent.Status {
 	case stripe.PaymentIntentStatusSucceeded:
-		result = handleTerminalPaymentSuccess(intentID, terminalState, intent)
+		watched := a.SSE.Connected(intentID)
+		result = a.handleTerminalPaymentSuccess(intentID, terminalState, intent)
+		if result.Component != nil && !watched {
+			publishPaymentRecovered(intentID, "terminal")
+		}
 	case stripe.PaymentIntentStatusCanceled, stripe.PaymentIntentStatusRequiresPaymentMethod:
-		result = handleTerminalPaymentFailure(intentID, intent)
+		result = a.handleTerminalPaymentFailure(intentID, intent)
 	default:
 		// Continue with progress update
-		progress := calculateProgressInfo(state.GetStartTime(), config.PaymentTimeout)
+		progress := calculateProgressInfo(state.GetStartTime(), a.Clock.Now())
 		options := PaymentProgressOptions{
 			PaymentID:     intentID,
 			PaymentType:   "terminal",
@@ -541,18 +876,20 @@ func sendTerminalSSEUpdate(intentID string, intent *stripe.PaymentIntent) {
 		}
 	}
 
-	if result.Component != nil {
-		GlobalSSEBroadcaster.BroadcastPaymentUpdate(intentID, result.Component)
-	}
-
 	if result.ShouldStop {
-		GlobalSSEBroadcaster.RemoveConnection(intentID)
+		// Final results replace the entire modal, matching the polling loop
+		if result.Component != nil {
+			a.SSE.BroadcastModalUpdate(intentID, result.Component)
+		}
+		a.SSE.RemoveConnection(intentID)
+	} else if result.Component != nil {
+		a.SSE.BroadcastPaymentUpdate(intentID, result.Component)
 	}
 }
 
 // sendQRSSEUpdate sends SSE update for QR payments
-func sendQRSSEUpdate(paymentLinkID, status string) {
-	state, exists := GlobalPaymentStateManager.GetPayment(paymentLinkID)
+func (a *App) sendQRSSEUpdate(paymentLinkID, status string) {
+	state, exists := a.Payments.GetPayment(paymentLinkID)
 	if !exists {
 		return
 	}
@@ -568,36 +905,61 @@ func sendQRSSEUpdate(paymentLinkID, status string) {
 		}
 
 		// Try to get customer email from cached state
-		if cachedState, found := GetCachedPaymentState(paymentLinkID, "payment_link"); found {
+		if cachedState, found := a.GetCachedPaymentState(paymentLinkID, "payment_link"); found {
 			if email, exists := cachedState.Metadata["customer_email"]; exists {
 				paymentLinkStatus.CustomerEmail = email
 			}
+			paymentLinkStatus.SessionID = cachedCheckoutSessionID(cachedState)
 		}
 
-		result = handleQRPaymentSuccess(paymentLinkID, paymentLinkStatus)
+		watched := a.SSE.Connected(paymentLinkID)
+		result = a.handleQRPaymentSuccess(paymentLinkID, paymentLinkStatus)
+		a.consumeCachedPaymentState(paymentLinkID, "payment_link")
+		if result.Component != nil && !watched {
+			publishPaymentRecovered(paymentLinkID, "qr")
+		}
 	default:
 		// Continue with progress update
-		progress := calculateProgressInfo(state.GetStartTime(), config.PaymentTimeout)
+		progress := calculateProgressInfo(state.GetStartTime(), a.Clock.Now())
 		result = PaymentStatusResult{
 			Component:  createPaymentProgressComponent(paymentLinkID, progress, "qr"),
 			ShouldStop: false,
 		}
 	}
 
-	if result.Component != nil {
-		GlobalSSEBroadcaster.BroadcastPaymentUpdate(paymentLinkID, result.Component)
-	}
-
 	if result.ShouldStop {
-		GlobalSSEBroadcaster.RemoveConnection(paymentLinkID)
+		// Final results replace the entire modal, matching the polling loop
+		if result.Component != nil {
+			a.SSE.BroadcastModalUpdate(paymentLinkID, result.Component)
+		}
+		a.SSE.RemoveConnection(paymentLinkID)
+	} else if result.Component != nil {
+		a.SSE.BroadcastPaymentUpdate(paymentLinkID, result.Component)
 	}
 }
 
 // sendTerminalActionSSEUpdate sends SSE update for terminal reader actions
-func sendTerminalActionSSEUpdate(actionData interface{}) {
-	// This would handle terminal reader action events
-	// Implementation depends on the specific action data structure
-	utils.Debug("sse", "Terminal action update", "action_data", actionData)
+// The reader action outcome is translated into the equivalent PaymentIntent status
+func (a *App) sendTerminalActionSSEUpdate(intentID string, action *stripe.TerminalReaderAction) {
+	intent := &stripe.PaymentIntent{ID: intentID}
+
+	switch action.Status {
+	case stripe.TerminalReaderActionStatusSucceeded:
+		// Terminal intents use automatic capture, so a succeeded action means a succeeded payment
+		intent.Status = stripe.PaymentIntentStatusSucceeded
+	case stripe.TerminalReaderActionStatusFailed:
+		intent.Status = stripe.PaymentIntentStatusRequiresPaymentMethod
+		if action.FailureMessage != "" {
+			intent.LastPaymentError = &stripe.Error{
+				Msg: i18n.T("terminal.failed_detail", action.FailureMessage),
+			}
+		}
+	default:
+		utils.Debug("sse", "Ignoring terminal action update", "intent_id", intentID, "status", action.Status)
+		return
+	}
+
+	a.sendTerminalSSEUpdate(intentID, intent)
 }
 
 // Helper functions to extract data from webhook events
@@ -610,15 +972,6 @@ func extractPaymentIntentFromEvent(event stripe.Event) *stripe.PaymentIntent {
 	return &paymentIntent
 }
 
-func extractPaymentLinkIDFromEvent(event stripe.Event) string {
-	var paymentLink stripe.PaymentLink
-	if err := json.Unmarshal(event.Data.Raw, &paymentLink); err != nil {
-		utils.Error("webhook", "Error parsing payment link from webhook", "error", err)
-		return ""
-	}
-	return paymentLink.ID
-}
-
 func extractChargeFromEvent(event stripe.Event) *stripe.Charge {
 	var charge stripe.Charge
 	if err := json.Unmarshal(event.Data.Raw, &charge); err != nil {
@@ -627,8 +980,3 @@ func extractChargeFromEvent(event stripe.Event) *stripe.Charge {
 	}
 	return &charge
 }
-
-func extractTerminalActionFromEvent(event stripe.Event) interface{} {
-	// This would extract terminal reader action data
-	return event.Data.Raw
-}
@@ -0,0 +1,182 @@
+package handlers
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"checkout/config"
+)
+
+// cacheState stores a state for a payment as a webhook would, as of the fake clock
+func cacheState(app *App, id, paymentType, status string) {
+	app.setCachedPaymentState(id, paymentType, &WebhookPaymentState{
+		ID:          id,
+		Status:      status,
+		PaymentType: paymentType,
+		Livemode:    isLiveMode(),
+	})
+}
+
+func TestGetCachedPaymentStateExpiry(t *testing.T) {
+	tests := []struct {
+		name       string
+		ttlMinutes int
+		age        time.Duration
+		want       bool
+	}{
+		{"fresh", 0, 0, true},
+		{"older than the payment timeout", 0, config.PaymentTimeout + time.Minute, true},
+		{"at the default TTL", 0, config.DefaultWebhookCacheTTLMinutes * time.Minute, true},
+		{"past the default TTL", 0, config.DefaultWebhookCacheTTLMinutes*time.Minute + time.Nanosecond, false},
+		{"at a configured TTL", 3, 3 * time.Minute, true},
+		{"past a configured TTL", 3, 3*time.Minute + time.Nanosecond, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, _, clock := newTestApp(t)
+			config.Config.WebhookCacheTTLMinutes = tt.ttlMinutes
+			cacheState(app, "plink_paid", "payment_link", "completed")
+
+			clock.Advance(tt.age)
+			_, found := app.GetCachedPaymentState("plink_paid", "payment_link")
+
+			if found != tt.want {
+				t.Errorf("found = %v after %v, want %v", found, tt.age, tt.want)
+			}
+			// Reads never evict; the state stays until the cleanup loop runs
+			if _, kept := app.Webhooks.ByPaymentLink["plink_paid"]; !kept {
+				t.Errorf("lookup removed the state")
+			}
+		})
+	}
+}
+
+// A customer who pays 90 seconds into a QR payment is still seen as paid by the check
+// that runs after the payment's own timeout
+func TestLateWebhookOutlivesPaymentTimeout(t *testing.T) {
+	app, _, clock := newTestApp(t)
+
+	clock.Advance(90 * time.Second)
+	cacheState(app, "plink_late", "payment_link", "completed")
+	clock.Advance(config.PaymentTimeout)
+	app.cleanupExpiredStates()
+
+	if state, found := app.GetCachedPaymentState("plink_late", "payment_link"); !found || state.Status != "completed" {
+		t.Errorf("completed payment evicted %v after the webhook", config.PaymentTimeout)
+	}
+}
+
+func TestGetCachedPaymentStateLookups(t *testing.T) {
+	app, _, _ := newTestApp(t)
+	cacheState(app, "pi_card", "payment_intent", "succeeded")
+	app.Webhooks.ByPaymentIntent["pi_other_mode"] = &WebhookPaymentState{ID: "pi_other_mode", Status: "succeeded", Livemode: !isLiveMode(), LastUpdated: app.Clock.Now()}
+
+	tests := []struct {
+		name, id, paymentType string
+		want                  bool
+	}{
+		{"by type", "pi_card", "payment_intent", true},
+		{"other type", "pi_card", "payment_link", false},
+		{"unknown type", "pi_card", "terminal", false},
+		{"unknown ID", "pi_missing", "payment_intent", false},
+		{"other Stripe mode", "pi_other_mode", "payment_intent", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, found := app.GetCachedPaymentState(tt.id, tt.paymentType); found != tt.want {
+				t.Errorf("found = %v, want %v", found, tt.want)
+			}
+		})
+	}
+}
+
+func TestCleanupExpiredStates(t *testing.T) {
+	app, _, clock := newTestApp(t)
+	config.Config.WebhookCacheTTLMinutes = 10
+	cacheState(app, "pi_old", "payment_intent", "requires_action")
+	cacheState(app, "plink_old", "payment_link", "completed")
+	clock.Advance(5 * time.Minute)
+	cacheState(app, "pi_waiting", "payment_intent", "requires_action")
+	cacheState(app, "pi_paid", "payment_intent", "succeeded")
+	cacheState(app, "pi_acted_on", "payment_intent", "succeeded")
+	cacheState(app, "plink_acted_on", "payment_link", "completed")
+	app.consumeCachedPaymentState("pi_acted_on", "payment_intent")
+	app.consumeCachedPaymentState("plink_acted_on", "payment_link")
+	app.consumeCachedPaymentState("pi_waiting", "payment_intent") // Only final states are consumed
+	clock.Advance(5*time.Minute + time.Second)
+
+	app.cleanupExpiredStates()
+
+	for id, want := range map[string]bool{"pi_old": false, "pi_waiting": true, "pi_paid": true, "pi_acted_on": false} {
+		if _, kept := app.Webhooks.ByPaymentIntent[id]; kept != want {
+			t.Errorf("%s kept = %v, want %v", id, kept, want)
+		}
+	}
+	for id, want := range map[string]bool{"plink_old": false, "plink_acted_on": false} {
+		if _, kept := app.Webhooks.ByPaymentLink[id]; kept != want {
+			t.Errorf("%s kept = %v, want %v", id, kept, want)
+		}
+	}
+}
+
+func TestCleanupExpiredStatesDropsOldFallbacks(t *testing.T) {
+	app, _, clock := newTestApp(t)
+	app.Webhooks.Fallbacks["pi_fallback"] = clock.Now()
+
+	clock.Advance(config.PaymentTimeout)
+	app.cleanupExpiredStates()
+	if _, kept := app.Webhooks.Fallbacks["pi_fallback"]; !kept {
+		t.Fatalf("fallback record dropped while its payment could still be running")
+	}
+
+	clock.Advance(time.Second)
+	app.cleanupExpiredStates()
+	if _, kept := app.Webhooks.Fallbacks["pi_fallback"]; kept {
+		t.Errorf("fallback record kept past the payment timeout")
+	}
+}
+
+// Webhooks, status checks and the cleanup loop all use the cache at once; run with -race
+func TestWebhookCacheConcurrentAccess(t *testing.T) {
+	app, _, clock := newTestApp(t)
+	config.Config.WebhookCacheTTLMinutes = 1
+	const payments = 20
+
+	var wg sync.WaitGroup
+	for i := range payments {
+		id := fmt.Sprintf("pi_%d", i)
+		wg.Add(3)
+		go func() { // Webhooks
+			defer wg.Done()
+			for _, status := range []string{"requires_action", "failed", "succeeded", "requires_action"} {
+				cacheState(app, id, "payment_intent", status)
+			}
+		}()
+		go func() { // Status checks
+			defer wg.Done()
+			for range 50 {
+				if state, found := app.GetCachedPaymentState(id, "payment_intent"); found && isFinalStatus(state.Status) {
+					app.consumeCachedPaymentState(id, "payment_intent")
+				}
+			}
+		}()
+		go func() { // Retries after a decline, and the cleanup loop
+			defer wg.Done()
+			for range 10 {
+				app.resetCachedPaymentState(id)
+				app.cleanupExpiredStates()
+				clock.Advance(time.Second)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Whatever the interleaving, nothing outlives the TTL
+	clock.Advance(config.GetWebhookCacheTTL() + time.Second)
+	app.cleanupExpiredStates()
+	if n := len(app.Webhooks.ByPaymentIntent); n != 0 {
+		t.Errorf("%d states left after the TTL", n)
+	}
+}
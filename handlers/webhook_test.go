@@ -0,0 +1,490 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stripe/stripe-go/v74"
+
+	"checkout/config"
+	"checkout/services/stripetest"
+)
+
+// readerAction is a terminal.reader.action_* payload for a reader working on a PaymentIntent,
+// with the intent unexpanded as Stripe sends it
+func readerAction(readerID, intentID, status, failureCode, failureMessage string) map[string]any {
+	return map[string]any{
+		"id":       readerID,
+		"object":   "terminal.reader",
+		"livemode": isLiveMode(),
+		"action": map[string]any{
+			"type":            "process_payment_intent",
+			"status":          status,
+			"failure_code":    failureCode,
+			"failure_message": failureMessage,
+			"process_payment_intent": map[string]any{
+				"payment_intent": intentID,
+			},
+		},
+	}
+}
+
+func TestReaderActionEventsCachedByIntent(t *testing.T) {
+	tests := []struct {
+		name        string
+		eventType   string
+		action      map[string]any
+		wantStatus  string
+		wantError   string
+		wantFailure string
+	}{
+		{
+			name:       "succeeded",
+			eventType:  "terminal.reader.action_succeeded",
+			action:     readerAction("tmr_counter", "pi_paid", "succeeded", "", ""),
+			wantStatus: "action_succeeded",
+		},
+		{
+			name:        "failed",
+			eventType:   "terminal.reader.action_failed",
+			action:      readerAction("tmr_counter", "pi_paid", "failed", "card_declined", "Your card was declined."),
+			wantStatus:  "action_failed",
+			wantError:   "Your card was declined.",
+			wantFailure: "card_declined",
+		},
+		{
+			name:        "failed without a message",
+			eventType:   "terminal.reader.action_failed",
+			action:      readerAction("tmr_counter", "pi_paid", "failed", "card_declined", ""),
+			wantStatus:  "action_failed",
+			wantError:   "card_declined",
+			wantFailure: "card_declined",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, _, _ := newTestApp(t)
+
+			if rec := postWebhook(t, app, tt.eventType, tt.action); rec.Code != http.StatusOK {
+				t.Fatalf("webhook = %d, want 200", rec.Code)
+			}
+
+			state, found := app.GetCachedPaymentState("pi_paid", "payment_intent")
+			if !found {
+				t.Fatalf("reader action not cached under its PaymentIntent")
+			}
+			if state.Status != tt.wantStatus || state.LastPaymentError != tt.wantError {
+				t.Errorf("cached %s %q, want %s %q", state.Status, state.LastPaymentError, tt.wantStatus, tt.wantError)
+			}
+			if state.AdditionalData["reader_id"] != "tmr_counter" {
+				t.Errorf("cached reader = %v, want tmr_counter", state.AdditionalData["reader_id"])
+			}
+			if tt.wantFailure != "" && state.AdditionalData["failure_code"] != tt.wantFailure {
+				t.Errorf("cached failure code = %v, want %s", state.AdditionalData["failure_code"], tt.wantFailure)
+			}
+			if _, found := app.GetCachedPaymentState("tmr_counter", "payment_intent"); found {
+				t.Errorf("reader action also cached under the reader")
+			}
+		})
+	}
+}
+
+// A reader moves on to the next sale's intent; each intent keeps its own outcome
+func TestReaderActionEventsKeepEachIntent(t *testing.T) {
+	app, _, _ := newTestApp(t)
+
+	postWebhook(t, app, "terminal.reader.action_failed", readerAction("tmr_counter", "pi_first", "failed", "card_declined", "Declined"))
+	postWebhook(t, app, "terminal.reader.action_succeeded", readerAction("tmr_counter", "pi_second", "succeeded", "", ""))
+
+	if state, _ := app.GetCachedPaymentState("pi_first", "payment_intent"); state == nil || state.Status != "action_failed" {
+		t.Errorf("first intent = %+v, want its decline kept", state)
+	}
+	if state, _ := app.GetCachedPaymentState("pi_second", "payment_intent"); state == nil || state.Status != "action_succeeded" {
+		t.Errorf("second intent = %+v, want action_succeeded", state)
+	}
+}
+
+func TestReaderActionWithoutIntentIgnored(t *testing.T) {
+	app, _, _ := newTestApp(t)
+	action := map[string]any{
+		"id":       "tmr_counter",
+		"object":   "terminal.reader",
+		"livemode": isLiveMode(),
+		"action":   map[string]any{"type": "set_reader_display", "status": "succeeded"},
+	}
+
+	if rec := postWebhook(t, app, "terminal.reader.action_succeeded", action); rec.Code != http.StatusOK {
+		t.Fatalf("webhook = %d, want 200", rec.Code)
+	}
+	if n := len(app.Webhooks.ByPaymentIntent); n != 0 {
+		t.Errorf("cached %d states for an action without a PaymentIntent", n)
+	}
+}
+
+// paymentIntent is a payment_intent.* payload
+func paymentIntent(intentID, status string) map[string]any {
+	return map[string]any{
+		"id":       intentID,
+		"object":   "payment_intent",
+		"status":   status,
+		"amount":   450,
+		"currency": "usd",
+		"livemode": isLiveMode(),
+	}
+}
+
+func TestWebhookEventsOutOfOrder(t *testing.T) {
+	type delivery struct {
+		eventType string
+		second    int // When the event was created, in seconds after the first
+	}
+	tests := []struct {
+		name       string
+		deliveries []delivery
+		wantStatus string
+	}{
+		{"in order", []delivery{{"payment_intent.created", 0}, {"payment_intent.requires_action", 1}, {"payment_intent.succeeded", 2}}, "succeeded"},
+		{"older progress after the success", []delivery{{"payment_intent.succeeded", 2}, {"payment_intent.requires_action", 1}, {"payment_intent.created", 0}}, "succeeded"},
+		{"older decline after the success", []delivery{{"payment_intent.succeeded", 2}, {"payment_intent.payment_failed", 1}}, "succeeded"},
+		{"newer decline never undoes a success", []delivery{{"payment_intent.succeeded", 1}, {"payment_intent.payment_failed", 2}}, "succeeded"},
+		{"retry with another card succeeds", []delivery{{"payment_intent.payment_failed", 1}, {"payment_intent.succeeded", 2}}, "succeeded"},
+		{"older created after the decline", []delivery{{"payment_intent.payment_failed", 1}, {"payment_intent.created", 0}}, "failed"},
+		{"canceled is final", []delivery{{"payment_intent.canceled", 1}, {"payment_intent.succeeded", 2}}, "canceled"},
+		{"reader success then older intent progress", []delivery{{"terminal.reader.action_succeeded", 2}, {"payment_intent.requires_action", 1}}, "action_succeeded"},
+	}
+	statuses := map[string]string{
+		"payment_intent.created":         "requires_payment_method",
+		"payment_intent.requires_action": "requires_action",
+		"payment_intent.succeeded":       "succeeded",
+		"payment_intent.payment_failed":  "requires_payment_method",
+		"payment_intent.canceled":        "canceled",
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, _, _ := newTestApp(t)
+			start := time.Now().Add(-time.Minute)
+
+			for _, d := range tt.deliveries {
+				var object map[string]any
+				if d.eventType == "terminal.reader.action_succeeded" {
+					object = readerAction("tmr_counter", "pi_sale", "succeeded", "", "")
+				} else {
+					object = paymentIntent("pi_sale", statuses[d.eventType])
+				}
+				event := webhookEvent{Type: d.eventType, Created: start.Add(time.Duration(d.second) * time.Second), Object: object}
+				if rec := postEvent(t, app, event); rec.Code != http.StatusOK {
+					t.Fatalf("%s = %d, want 200", d.eventType, rec.Code)
+				}
+			}
+
+			state, found := app.GetCachedPaymentState("pi_sale", "payment_intent")
+			if !found || state.Status != tt.wantStatus {
+				t.Errorf("cached status = %+v, want %s", state, tt.wantStatus)
+			}
+		})
+	}
+}
+
+// Stripe delivers an event again when it didn't see the acknowledgement in time
+func TestWebhookDuplicateEventsIgnored(t *testing.T) {
+	t.Run("success already acted on", func(t *testing.T) {
+		app, _, clock := newTestApp(t)
+		succeeded := webhookEvent{ID: "evt_dup_success", Type: "payment_intent.succeeded", Object: paymentIntent("pi_dup_success", "succeeded")}
+		postEvent(t, app, succeeded)
+		app.consumeCachedPaymentState("pi_dup_success", "payment_intent")
+		before, _ := app.GetCachedPaymentState("pi_dup_success", "payment_intent")
+		updated := before.LastUpdated
+
+		clock.Advance(time.Second)
+		if rec := postEvent(t, app, succeeded); rec.Code != http.StatusOK {
+			t.Fatalf("duplicate = %d, want 200 so Stripe stops retrying", rec.Code)
+		}
+
+		state, _ := app.GetCachedPaymentState("pi_dup_success", "payment_intent")
+		if state != before || !state.Consumed || !state.LastUpdated.Equal(updated) {
+			t.Errorf("duplicate replaced the consumed success: %+v", state)
+		}
+	})
+
+	t.Run("decline of an attempt already retried", func(t *testing.T) {
+		app, _, _ := newTestApp(t)
+		failed := webhookEvent{ID: "evt_dup_decline", Type: "payment_intent.payment_failed", Object: paymentIntent("pi_dup_decline", "requires_payment_method")}
+		postEvent(t, app, failed)
+		app.resetCachedPaymentState("pi_dup_decline") // The cashier retries with another card
+
+		postEvent(t, app, failed)
+
+		if state, _ := app.GetCachedPaymentState("pi_dup_decline", "payment_intent"); state == nil || state.Status != "requires_payment_method" {
+			t.Errorf("cached status = %+v, want the retry left waiting for a card", state)
+		}
+	})
+}
+
+// checkoutSessionFixture is the checkout.session Stripe sends with checkout.session.completed when
+// a customer pays a payment link, pointed at the given link
+func checkoutSessionFixture(t *testing.T, paymentLinkID any) map[string]any {
+	t.Helper()
+	data, err := os.ReadFile("testdata/checkout_session_completed.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var session map[string]any
+	if err := json.Unmarshal(data, &session); err != nil {
+		t.Fatal(err)
+	}
+	session["payment_link"] = paymentLinkID
+	session["livemode"] = isLiveMode()
+	return session
+}
+
+func TestCheckoutSessionCompletedBroadcastsQRSuccess(t *testing.T) {
+	app, _, _ := newTestApp(t)
+	config.Config.WebsiteName = "pos.example.com" // Webhooks, rather than status checks, complete payments
+	addToCart(app, "Coffee", 4.50)
+	postForm(app.GenerateQRCodeHandler, "/generate-qr-code", url.Values{})
+	states := app.Payments.GetStatesByType("qr")
+	if len(states) != 1 {
+		t.Fatalf("QR payments = %d, want 1", len(states))
+	}
+	linkID := states[0].GetID()
+
+	// The QR modal is open and listening for the result
+	stream := httptest.NewRecorder()
+	if app.SSE.AddConnection(linkID, "qr", stream) == nil {
+		t.Fatal("update stream not opened")
+	}
+
+	if rec := postWebhook(t, app, "checkout.session.completed", checkoutSessionFixture(t, linkID)); rec.Code != http.StatusOK {
+		t.Fatalf("webhook = %d, want 200", rec.Code)
+	}
+
+	body := stream.Body.String()
+	for _, marker := range []string{"event: modal-update", `class="payment-success"`, `data-confirmation-code="` + linkID + `"`} {
+		if !strings.Contains(body, marker) {
+			t.Errorf("broadcast is missing %s:\n%s", marker, body)
+		}
+	}
+	cached, found := app.GetCachedPaymentState(linkID, "payment_link")
+	if !found || cached.Status != "completed" || cached.Metadata["customer_email"] != "customer@example.com" {
+		t.Errorf("cached %+v, want the link completed with the customer's email", cached)
+	}
+	if _, tracked := app.Payments.GetPayment(linkID); tracked {
+		t.Errorf("QR payment still in progress after its checkout completed")
+	}
+}
+
+// Checkout sessions not started from a payment link, such as another integration on the same
+// account, aren't QR payments
+func TestCheckoutSessionWithoutPaymentLinkIgnored(t *testing.T) {
+	app, _, _ := newTestApp(t)
+
+	if rec := postWebhook(t, app, "checkout.session.completed", checkoutSessionFixture(t, nil)); rec.Code != http.StatusOK {
+		t.Fatalf("webhook = %d, want 200", rec.Code)
+	}
+	if n := len(app.Webhooks.ByPaymentLink); n != 0 {
+		t.Errorf("cached %d states for a session without a payment link", n)
+	}
+}
+
+// transactionRows counts the rows of the transaction logs that name a payment
+func transactionRows(t *testing.T, paymentID string) int {
+	t.Helper()
+	return transactionRowsIn(t, config.Config.TransactionsDir, paymentID)
+}
+
+// transactionRowsIn counts the rows of a payment in the transaction logs of dir
+func transactionRowsIn(t *testing.T, dir, paymentID string) int {
+	t.Helper()
+	files, err := filepath.Glob(filepath.Join(dir, "*.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows := 0
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			if strings.Contains(line, paymentID) {
+				rows++
+			}
+		}
+	}
+	return rows
+}
+
+// Once Stripe has the payment, the webhook and the status checks both learn of it and either may
+// be first, or both at once; the sale is written once whichever way it goes
+func TestPaymentOutcomeRecordedOnce(t *testing.T) {
+	payments := []struct {
+		name    string
+		start   func(t *testing.T, app *App) string
+		pay     func(fake *stripetest.Client, id string)
+		webhook func(t *testing.T, app *App, id string)
+		check   func(app *App, id string) PaymentStatusResult
+	}{
+		{
+			name:  "qr",
+			start: pendingPayments[0].start,
+			pay:   pendingPayments[0].succeed,
+			webhook: func(t *testing.T, app *App, id string) {
+				postWebhook(t, app, "checkout.session.completed", checkoutSessionFixture(t, id))
+			},
+			check: (*App).checkQRPaymentStatus,
+		},
+		{
+			name:  "terminal",
+			start: startTerminalPayment,
+			pay:   pendingPayments[1].succeed,
+			webhook: func(t *testing.T, app *App, id string) {
+				postWebhook(t, app, "payment_intent.succeeded", paymentIntent(id, string(stripe.PaymentIntentStatusSucceeded)))
+			},
+			check: (*App).checkTerminalPaymentStatus,
+		},
+	}
+	orders := []struct {
+		name    string
+		deliver func(t *testing.T, webhook, poll func())
+	}{
+		{"webhook first", func(t *testing.T, webhook, poll func()) { webhook(); poll() }},
+		{"poll first", func(t *testing.T, webhook, poll func()) { poll(); webhook() }},
+		{"both at once", func(t *testing.T, webhook, poll func()) {
+			var wg sync.WaitGroup
+			wg.Add(2)
+			go func() { defer wg.Done(); webhook() }()
+			go func() { defer wg.Done(); poll() }()
+			wg.Wait()
+		}},
+	}
+	for _, payment := range payments {
+		for _, order := range orders {
+			t.Run(payment.name+"/"+order.name, func(t *testing.T) {
+				app, fake, _ := newTestApp(t)
+				addToCart(app, "Coffee", 4.50)
+				id := payment.start(t, app)
+				payment.pay(fake, id)
+
+				order.deliver(t,
+					func() { payment.webhook(t, app, id) },
+					func() { payment.check(app, id) },
+				)
+
+				if rows := transactionRows(t, id); rows != 1 {
+					t.Errorf("sale written %d times, want once", rows)
+				}
+				if _, tracked := app.Payments.GetPayment(id); tracked {
+					t.Errorf("payment still tracked after it was recorded")
+				}
+				if result := payment.check(app, id); !result.ShouldStop {
+					t.Errorf("status check after the sale was recorded kept polling")
+				}
+				if rows := transactionRows(t, id); rows != 1 {
+					t.Errorf("sale written %d times after a later check, want once", rows)
+				}
+			})
+		}
+	}
+}
+
+// The cashier may have closed the tab before the customer paid; the webhook alone records the sale
+func TestWebhookRecordsSaleWithoutBrowser(t *testing.T) {
+	app, fake, _ := newTestApp(t)
+	addToCart(app, "Coffee", 4.50)
+	intentID := startTerminalPayment(t, app)
+	fake.SetIntentStatus(intentID, stripe.PaymentIntentStatusSucceeded)
+
+	postWebhook(t, app, "payment_intent.succeeded", paymentIntent(intentID, string(stripe.PaymentIntentStatusSucceeded)))
+
+	if rows := transactionRows(t, intentID); rows != 1 {
+		t.Errorf("sale written %d times, want once", rows)
+	}
+	if sessionCart(app).Len() != 0 {
+		t.Errorf("cart has %d items after the sale, want 0", sessionCart(app).Len())
+	}
+}
+
+// With stripe listen forwarding to a POS on localhost, a signed event goes through the same
+// route, cache and SSE updates as on a deployed POS
+func TestWebhookDevForwardingMatchesProduction(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(t *testing.T)
+	}{
+		{"production", func(t *testing.T) {
+			config.Config.WebsiteName = "pos.example.com"
+			config.Config.StripeWebhookSecret = testWebhookSecret
+		}},
+		{"dev forwarding", func(t *testing.T) {
+			config.Config.WebsiteName = "localhost"
+			config.Config.StripeWebhookSecret = "whsec_from_the_dashboard" // stripe listen signs with its own
+			t.Setenv("FORCE_WEBHOOK_STRATEGY", "true")
+			t.Setenv("STRIPE_WEBHOOK_SECRET", testWebhookSecret)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, fake, _ := newTestApp(t)
+			config.Config.MetricsAddress = ""
+			tt.setup(t)
+			if strategy := config.GetCommunicationStrategy(); strategy != "webhooks" {
+				t.Fatalf("strategy = %s, want webhooks", strategy)
+			}
+			router := NewRouter(app)
+			send := func(payload []byte, signature string) int {
+				req := httptest.NewRequest(http.MethodPost, "/stripe-webhook", strings.NewReader(string(payload)))
+				req.Header.Set("Stripe-Signature", signature)
+				rec := httptest.NewRecorder()
+				router.ServeHTTP(rec, req)
+				return rec.Code
+			}
+
+			addToCart(app, "Coffee", 4.50)
+			postForm(app.GenerateQRCodeHandler, "/generate-qr-code", url.Values{})
+			linkID := app.Payments.GetStatesByType("qr")[0].GetID()
+			stream := httptest.NewRecorder()
+			if app.SSE.AddConnection(linkID, "qr", stream) == nil {
+				t.Fatal("update stream not opened")
+			}
+
+			// Status checks wait for the webhook rather than asking Stripe
+			started := stripeReads(fake)
+			if result := app.checkQRPaymentStatus(linkID); result.ShouldStop {
+				t.Fatalf("payment concluded before the webhook")
+			}
+			if got := stripeReads(fake) - started; got != 0 {
+				t.Errorf("%d Stripe calls while waiting for the webhook, want none", got)
+			}
+
+			payload, signature := signedEvent(t, webhookEvent{Type: "checkout.session.completed", Object: checkoutSessionFixture(t, linkID)})
+			if code := send(payload, strings.Replace(signature, "v1=", "v1=0", 1)); code != http.StatusBadRequest {
+				t.Errorf("badly signed event = %d, want 400", code)
+			}
+			if code := send(payload, signature); code != http.StatusOK {
+				t.Fatalf("signed event = %d, want 200", code)
+			}
+
+			cached, found := app.GetCachedPaymentState(linkID, "payment_link")
+			if !found || cached.Status != "completed" || cached.Metadata["customer_email"] != "customer@example.com" {
+				t.Errorf("cached %+v, want the link completed with the customer's email", cached)
+			}
+			body := stream.Body.String()
+			for _, marker := range []string{"event: modal-update", `class="payment-success"`} {
+				if !strings.Contains(body, marker) {
+					t.Errorf("broadcast is missing %s:\n%s", marker, body)
+				}
+			}
+			if rows := transactionRows(t, linkID); rows != 1 {
+				t.Errorf("sale written %d times, want once", rows)
+			}
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"checkout/config"
+	"checkout/services"
+	"checkout/templates/reports"
+	"checkout/utils"
+)
+
+// CloseDayHandler shows a day's Z-report, or its running totals while it is still open (GET),
+// and closes the day, saving its numbered Z-report (POST)
+func (a *App) CloseDayHandler(w http.ResponseWriter, r *http.Request) {
+	location := config.GetBusinessLocation()
+	day := config.BusinessDay(time.Now())
+	errorMessage := ""
+
+	switch r.Method {
+	case http.MethodGet:
+		if date := r.URL.Query().Get("date"); date != "" {
+			parsed, err := time.ParseInLocation("2006-01-02", date, location)
+			if err != nil {
+				http.Error(w, "Invalid date, expected YYYY-MM-DD", http.StatusBadRequest)
+				return
+			}
+			day = parsed
+		}
+
+	case http.MethodPost:
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "Invalid form data", http.StatusBadRequest)
+			return
+		}
+		parsed, err := time.ParseInLocation("2006-01-02", r.FormValue("date"), location)
+		if err != nil {
+			http.Error(w, "Invalid date, expected YYYY-MM-DD", http.StatusBadRequest)
+			return
+		}
+		day = parsed
+
+		if _, err := services.CloseDay(day); err != nil {
+			utils.Error("report", "Day close failed", "date", day.Format("2006-01-02"), "error", err)
+			errorMessage = "Day not closed: " + err.Error()
+			break
+		}
+		http.Redirect(w, r, "/close-day?date="+day.Format("2006-01-02"), http.StatusSeeOther)
+		return
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// A closed day is always shown from its snapshot, never recomputed
+	var summary services.DailySummary
+	report, err := services.LoadZReport(day)
+	if err == nil {
+		summary = report.DailySummary
+	} else {
+		report = nil
+		summary, err = services.BuildDailySummary(day)
+		if err != nil {
+			utils.Error("report", "Error building day totals", "date", day.Format("2006-01-02"), "error", err)
+			summary.Date = day.Format("2006-01-02")
+			if errorMessage == "" {
+				errorMessage = "Could not read the day's transactions: " + err.Error()
+			}
+		}
+	}
+
+	history, err := services.ListZReports()
+	if err != nil {
+		utils.Error("report", "Error listing Z-reports", "error", err)
+	}
+
+	component := reports.CloseDayPage(summary, report, history, errorMessage, location)
+	if err := component.Render(r.Context(), w); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,179 @@
+// Package i18n translates the text shown to staff and customers and formats numbers and dates
+// for the configured locale. Message catalogs are embedded JSON files, one per locale.
+package i18n
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"math"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"checkout/utils"
+)
+
+// DefaultLocale is used when no locale is configured; its catalog is the fallback for
+// messages missing from another locale
+const DefaultLocale = "en"
+
+//go:embed locales/*.json
+var localeFiles embed.FS
+
+// catalog is one locale's messages and formats
+type catalog struct {
+	Name     string            `json:"name"` // Name of the language in that language (e.g. "Español")
+	Format   format            `json:"format"`
+	Messages map[string]string `json:"messages"`
+}
+
+// format holds how numbers and dates are written in a locale
+type format struct {
+	Decimal   string `json:"decimal"`   // Decimal separator
+	Thousands string `json:"thousands"` // Digit group separator
+	Currency  string `json:"currency"`  // Amount layout, %s is the formatted number (e.g. "$%s")
+	Date      string `json:"date"`      // Go time layout for dates
+	DateTime  string `json:"dateTime"`  // Go time layout for a date with time
+}
+
+var (
+	catalogs = loadCatalogs()
+
+	current      = DefaultLocale
+	currentMutex sync.RWMutex
+)
+
+// loadCatalogs parses the embedded catalogs. They ship with the binary, so a broken one is a
+// build mistake and stops the program.
+func loadCatalogs() map[string]catalog {
+	entries, err := localeFiles.ReadDir("locales")
+	if err != nil {
+		panic(fmt.Sprintf("i18n: reading locales: %v", err))
+	}
+
+	loaded := make(map[string]catalog)
+	for _, entry := range entries {
+		data, err := localeFiles.ReadFile(path.Join("locales", entry.Name()))
+		if err != nil {
+			panic(fmt.Sprintf("i18n: reading %s: %v", entry.Name(), err))
+		}
+		var c catalog
+		if err := json.Unmarshal(data, &c); err != nil {
+			panic(fmt.Sprintf("i18n: parsing %s: %v", entry.Name(), err))
+		}
+		loaded[strings.TrimSuffix(entry.Name(), ".json")] = c
+	}
+	if _, ok := loaded[DefaultLocale]; !ok {
+		panic("i18n: no catalog for the default locale " + DefaultLocale)
+	}
+	return loaded
+}
+
+// Locales returns the codes of the available locales, sorted
+func Locales() []string {
+	codes := make([]string, 0, len(catalogs))
+	for code := range catalogs {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	return codes
+}
+
+// IsSupported reports whether a locale has a catalog
+func IsSupported(locale string) bool {
+	_, ok := catalogs[locale]
+	return ok
+}
+
+// SetLocale switches the language of everything rendered from now on. An empty or unknown
+// locale falls back to DefaultLocale.
+func SetLocale(locale string) {
+	locale = strings.ToLower(strings.TrimSpace(locale))
+	if locale == "" {
+		locale = DefaultLocale
+	}
+	if !IsSupported(locale) {
+		utils.Warn("i18n", "Unknown locale, using the default", "locale", locale, "default", DefaultLocale)
+		locale = DefaultLocale
+	}
+
+	currentMutex.Lock()
+	current = locale
+	currentMutex.Unlock()
+}
+
+// Locale returns the locale in use
+func Locale() string {
+	currentMutex.RLock()
+	defer currentMutex.RUnlock()
+	return current
+}
+
+// active returns the catalog of the locale in use
+func active() catalog {
+	return catalogs[Locale()]
+}
+
+// T returns the message for key in the current locale. With args the message is a format
+// string for fmt.Sprintf. A message missing from the locale comes from DefaultLocale, and a
+// key missing from both is returned as is so the gap is visible.
+func T(key string, args ...any) string {
+	message, ok := active().Messages[key]
+	if !ok {
+		if message, ok = catalogs[DefaultLocale].Messages[key]; !ok {
+			utils.Debug("i18n", "Missing message", "key", key, "locale", Locale())
+			message = key
+		}
+	}
+	if len(args) > 0 {
+		return fmt.Sprintf(message, args...)
+	}
+	return message
+}
+
+// Number formats an amount with two decimals and the locale's separators (e.g. "1,234.50")
+func Number(amount float64) string {
+	f := active().Format
+
+	negative := amount < 0
+	cents := int64(math.Round(math.Abs(amount) * 100))
+	whole := fmt.Sprintf("%d", cents/100)
+
+	// Group the whole part in threes from the right
+	var grouped strings.Builder
+	for i, digit := range whole {
+		if i > 0 && (len(whole)-i)%3 == 0 {
+			grouped.WriteString(f.Thousands)
+		}
+		grouped.WriteRune(digit)
+	}
+
+	number := fmt.Sprintf("%s%s%02d", grouped.String(), f.Decimal, cents%100)
+	if negative && cents != 0 {
+		number = "-" + number
+	}
+	return number
+}
+
+// Money formats an amount of money for display (e.g. "$1,234.50", "-$5.00")
+func Money(amount float64) string {
+	f := active().Format
+	number := Number(amount)
+	if strings.HasPrefix(number, "-") {
+		return "-" + fmt.Sprintf(f.Currency, strings.TrimPrefix(number, "-"))
+	}
+	return fmt.Sprintf(f.Currency, number)
+}
+
+// Date formats a date for display
+func Date(t time.Time) string {
+	return t.Format(active().Format.Date)
+}
+
+// DateTime formats a date and time for display
+func DateTime(t time.Time) string {
+	return t.Format(active().Format.DateTime)
+}
@@ -1,14 +1,14 @@
 package main
 
 import (
+	"bufio"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/tls"
 	"crypto/x509"
 	"crypto/x509/pkix"
-	"flag"
+	"fmt"
 	"log"
-	"log/slog"
 	"math/big"
 	"net"
 	"net/http"
@@ -19,11 +19,9 @@ import (
 	"checkout/config"
 	"checkout/handlers"
 	"checkout/services"
+	"checkout/static"
 	"checkout/utils"
-
-	"github.com/stripe/stripe-go/v74"
-	"github.com/stripe/stripe-go/v74/balance"
-	"github.com/stripe/stripe-go/v74/webhookendpoint"
+	"checkout/version"
 )
 
 // Configuration
@@ -33,77 +31,6 @@ const (
 	TRANSACTIONS_DIR = "./data/transactions"
 )
 
-// Initialize the application
-func init() {
-	// Load configuration
-	if err := config.Load(); err != nil {
-		log.Fatal(err)
-	}
-
-	// Check if password is strong enough
-	if len(config.Config.Password) < 8 {
-		log.Fatal("Password must be at least 8 characters long. Please update your configuration.")
-	}
-
-	// Create data directories if they don't exist
-	// Use directories from config or fallback to constants
-	dataDir := config.Config.DataDir
-	if dataDir == "" {
-		dataDir = DATA_DIR
-	}
-
-	transactionsDir := config.Config.TransactionsDir
-	if transactionsDir == "" {
-		transactionsDir = TRANSACTIONS_DIR
-	}
-
-	// Create data directories
-	if err := os.MkdirAll(dataDir, 0755); err != nil {
-		log.Fatalf("Failed to create data directory: %v", err)
-	}
-	if err := os.MkdirAll(transactionsDir, 0755); err != nil {
-		log.Fatalf("Failed to create transactions directory: %v", err)
-	}
-
-	// Initialize Stripe with API key from config or environment variable
-	stripe.Key = config.GetStripeKey()
-	if stripe.Key == "" {
-		log.Fatal("Missing Stripe Secret Key in config or environment. Please set STRIPE_SECRET_KEY environment variable or configure it in the config file.")
-	}
-
-	// Test the Stripe key by making a simple API call
-	_, err := balance.Get(&stripe.BalanceParams{})
-	if err != nil {
-		log.Fatalf("Invalid Stripe Secret Key - API test failed: %v", err)
-	}
-	utils.Info("startup", "Stripe API key validated successfully")
-
-	// Detect test mode from Stripe key and set in application state
-	services.AppState.LayoutContext.IsTestMode = strings.HasPrefix(stripe.Key, "sk_test_")
-	if services.AppState.LayoutContext.IsTestMode {
-		utils.Info("startup", "Running in Stripe test mode")
-	} else {
-		utils.Info("startup", "Running in Stripe live mode")
-	}
-
-	// Load services
-	if err := services.LoadProducts(); err != nil {
-		utils.Error("startup", "Error loading services", "error", err)
-		return
-	}
-
-	// Load Stripe Terminal Locations and select one
-	services.LoadStripeLocationsAndSelect()
-
-	// If a location was selected, load readers for that location
-	if services.AppState.SelectedStripeLocation.ID != "" {
-		services.LoadStripeReadersForLocation(services.AppState.SelectedStripeLocation.ID)
-	}
-
-	// Set up webhook endpoint registration
-	registerWebhookEndpoint()
-}
-
 // generateSelfSignedCert creates a self-signed certificate for localhost
 func generateSelfSignedCert() (tls.Certificate, error) {
 	// Generate a private key
@@ -155,137 +82,169 @@ func shouldUseHTTPS() bool {
 	return websiteName == "" || websiteName == "localhost"
 }
 
-// registerWebhookEndpoint registers webhook endpoint with Stripe if using webhooks strategy
-func registerWebhookEndpoint() {
-	strategy := config.GetCommunicationStrategy()
-	if strategy != "webhooks" {
-		utils.Info("communication", "Using polling strategy", "reason", "localhost/no domain")
-		return
+// startMetricsServer serves /metrics and /healthz on an internal address for Prometheus scrapes
+func startMetricsServer(app *handlers.App, address string) {
+	metricsMux := http.NewServeMux()
+	metricsMux.HandleFunc("/metrics", app.MetricsHandler)
+	metricsMux.HandleFunc("/healthz", app.HealthHandler)
+
+	utils.Info("server", "Starting metrics server", "address", address)
+	go func() {
+		if err := http.ListenAndServe(address, metricsMux); err != nil {
+			utils.Error("server", "Metrics server stopped", "address", address, "error", err)
+		}
+	}()
+}
+
+func main() {
+	os.Exit(run(os.Args[1:]))
+}
+
+// serve runs the POS web server; it is the default command. Its maintenance flags do their
+// job and exit without starting the server.
+func serve(args []string) int {
+	flags, logging := newFlagSet("serve", "")
+	staticDir := flags.String("static-dir", "", "Serve static assets from this directory instead of the embedded copy (development)")
+	migrate := flags.Bool("migrate-transactions", false, "Upgrade all transaction CSV logs to the current column layout and exit")
+	addUser := flags.String("add-user", "", "Add a user (or reset an existing user's password and role), prompting for the password, and exit")
+	role := flags.String("role", "cashier", "Role for -add-user: admin or cashier")
+	checkData := flags.Bool("check-data", false, "Check the data and transactions directories for missing or damaged files and exit")
+	moveData := flags.String("migrate-data", "", "Copy the data directories to this new directory, verify the copies, switch the configuration to it and exit (stop the POS first)")
+	if code, ok := parseFlags(flags, args); !ok {
+		return code
+	}
+	if err := setup(logging, os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitFailure
 	}
+	info := version.Get()
+	utils.Info("startup", "Starting checkout", "version", info.Version, "commit", info.Commit, "build_date", info.BuildDate)
 
-	// Check if webhook secret is configured
-	webhookSecret := config.GetStripeWebhookSecret()
-	if webhookSecret == "" {
-		utils.Warn("communication", "Webhook strategy selected but no webhook secret configured")
-		return
+	// One-off maintenance: add a login without starting the server
+	if *addUser != "" {
+		fmt.Printf("Password for %s: ", *addUser)
+		password, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil {
+			log.Fatalf("Error reading password: %v", err)
+		}
+		if err := config.AddUser(*addUser, strings.TrimSpace(password), *role); err != nil {
+			log.Fatalf("Error adding user: %v", err)
+		}
+		utils.Info("startup", "User saved", "username", *addUser, "role", *role)
+		return exitOK
 	}
 
-	// TODO: Consider persisting webhook registration to survive server restarts
-	// For now, we'll register on each startup which is acceptable for development
-
-	websiteName := config.Config.WebsiteName
-	webhookURL := "https://" + websiteName + "/stripe-webhook"
-
-	// Events we need for our POS system
-	enabledEvents := []string{
-		"payment_intent.succeeded",
-		"payment_intent.payment_failed",
-		"payment_intent.canceled",
-		"payment_intent.requires_action",
-		"payment_link.completed",
-		"payment_link.updated",
-		"terminal.reader.action_succeeded",
-		"terminal.reader.action_failed",
-		"charge.succeeded",
-		"charge.failed",
+	// One-off maintenance: check or move the data directories without starting the server
+	if *checkData {
+		problems := services.CheckData()
+		for _, problem := range problems {
+			fmt.Printf("%s: %s\n", problem.Path, problem.Problem)
+		}
+		if len(problems) > 0 {
+			log.Fatalf("Data check found %d problems", len(problems))
+		}
+		dataDir, transactionsDir := services.DataDirs()
+		utils.Info("startup", "Data check found no problems", "data_dir", dataDir, "transactions_dir", transactionsDir)
+		return exitOK
+	}
+	if *moveData != "" {
+		copied, err := services.MigrateData(*moveData)
+		if err != nil {
+			log.Fatalf("Data migration failed after copying %d files: %v", copied, err)
+		}
+		utils.Info("startup", "Data migrated; the old directories were left in place", "files", copied, "data_dir", config.Config.DataDir, "transactions_dir", config.Config.TransactionsDir)
+		return exitOK
 	}
 
-	params := &stripe.WebhookEndpointParams{
-		URL:           stripe.String(webhookURL),
-		EnabledEvents: stripe.StringSlice(enabledEvents),
+	// Someone has to be able to log in
+	if len(config.Config.Users) == 0 {
+		log.Fatal("No users configured. Add an admin with -add-user <name> -role admin.")
 	}
 
-	result, err := webhookendpoint.New(params)
-	if err != nil {
-		utils.Error("communication", "Failed to register webhook endpoint", "error", err)
-		utils.Info("communication", "Falling back to polling mode")
-		return
+	// Create data directories if they don't exist
+	// Use directories from config or fallback to constants
+	dataDir := config.Config.DataDir
+	if dataDir == "" {
+		dataDir = DATA_DIR
 	}
 
-	utils.Info("communication", "Using webhook strategy")
-	utils.Debug("webhook", "Registered endpoint", "url", webhookURL, "id", result.ID, "events", enabledEvents)
-}
+	transactionsDir := config.Config.TransactionsDir
+	if transactionsDir == "" {
+		transactionsDir = TRANSACTIONS_DIR
+	}
 
-func main() {
-	// Parse command line flags
-	debugFlag := flag.Bool("debug", false, "Enable debug logging")
-	flag.Parse()
-
-	// Configure slog based on debug flag
-	if *debugFlag {
-		slog.SetLogLoggerLevel(slog.LevelDebug)
-		slog.Info("Debug logging enabled")
-	} else {
-		slog.SetLogLoggerLevel(slog.LevelInfo)
+	// Create data directories
+	if err := os.MkdirAll(dataDir, 0755); err != nil {
+		log.Fatalf("Failed to create data directory: %v", err)
+	}
+	if err := os.MkdirAll(transactionsDir, 0755); err != nil {
+		log.Fatalf("Failed to create transactions directory: %v", err)
+	}
+
+	// One-off maintenance: upgrade historical logs without starting the server
+	if *migrate {
+		upgraded, err := services.MigrateTransactionCSVs()
+		if err != nil {
+			log.Fatalf("Transaction log migration failed: %v", err)
+		}
+		utils.Info("startup", "Transaction log migration complete", "upgraded", upgraded)
+		return exitOK
+	}
+
+	configureStripe()
+
+	// Validate Stripe, load products and select a terminal location. On failure the server
+	// still starts and sends the operator to the setup page to fix it.
+	_ = services.RunStartupChecks()
+
+	// Deactivate QR payment links a crash or closed browser left payable
+	services.StartStalePaymentLinkCleanup()
+
+	// Email the end-of-day report at the configured time
+	services.StartDailyReportScheduler()
+
+	// Compare the previous day's transactions with Stripe at the configured time
+	services.StartReconciliationScheduler()
+
+	// Check during business hours that the selected reader is still reachable
+	services.StartReaderKeepAlive()
+
+	// Compress the transaction files of old months into monthly archives
+	services.StartArchiveScheduler()
+
+	// Check daily that the Stripe account can still take card payments and receive payouts
+	services.StartAccountCheckScheduler()
+
+	// Look for a newer release once a day, if a releases URL is configured
+	services.StartUpdateCheck()
+
+	// Compare the local clock with Stripe's, since a drifting clock gets webhooks rejected
+	services.StartClockSkewCheck()
+
+	// Remove sale price schedules that have ended from the catalog
+	services.StartPriceSchedulePruning()
+
+	// Record the Stripe fee of each card payment once Stripe has settled it
+	services.StartStripeFeeLookups()
+
+	// Post finished sales, refunds and voids to the order webhook URLs
+	services.StartOrderWebhooks()
+
+	// Static assets are embedded in the binary; -static-dir serves them from disk for live CSS edits
+	if *staticDir != "" {
+		static.UseDirectory(*staticDir)
+		utils.Info("startup", "Serving static assets from disk", "dir", *staticDir)
 	}
 
-	rootMux := http.NewServeMux()
-
-	// Static files: Publicly accessible
-	// Ensure the path to static files is correct, e.g., "./static"
-	// If your static directory is in the root of your project, http.Dir("./static") is correct.
-	rootMux.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir("./static"))))
-
-	// Auth routes: Publicly accessible for login/logout
-	rootMux.HandleFunc("/login", handlers.LoginHandler)
-	rootMux.HandleFunc("/logout", handlers.LogoutHandler)
-
-	// Stripe webhook handler: Public, but typically has its own signature verification, not session auth
-	rootMux.HandleFunc("/stripe-webhook", handlers.StripeWebhookHandler)
-
-	// Payment events endpoint - SSE for real-time payment updates
-	rootMux.HandleFunc("/payment-events", handlers.PaymentSSEHandler)
-
-	// Application-specific routes that require authentication will go into appMux
-	appMux := http.NewServeMux()
-
-	// API routes (protected)
-	appMux.HandleFunc("/products", handlers.ProductsHandler)
-	appMux.HandleFunc("/navigate-category", handlers.NavigateCategoryHandler)
-	appMux.HandleFunc("/cart-items", handlers.CartItemsHandler)
-	appMux.HandleFunc("/cart-summary", handlers.CartSummaryHandler)
-	appMux.HandleFunc("/add-to-cart", handlers.AddToCartHandler)
-	appMux.HandleFunc("/add-custom-product", handlers.AddCustomProductHandler)
-	appMux.HandleFunc("/custom-product-form", handlers.CustomProductFormHandler)
-	appMux.HandleFunc("/remove-from-cart", handlers.RemoveFromCartHandler)
-	appMux.HandleFunc("/checkout-form", handlers.CheckoutFormHandler)
-	appMux.HandleFunc("/process-payment", handlers.ProcessPaymentHandler)
-	appMux.HandleFunc("/generate-qr-code", handlers.GenerateQRCodeHandler)
-	appMux.HandleFunc("/manual-card-form", handlers.ManualCardFormHandler)
-	appMux.HandleFunc("/get-payment-status", handlers.GetPaymentStatusHandler)
-	appMux.HandleFunc("/cancel-or-refresh-payment", handlers.CancelOrRefreshPaymentHandler)
-	appMux.HandleFunc("/cancel-transaction", handlers.CancelTransactionHandler)
-	appMux.HandleFunc("/update-receipt-info", handlers.ReceiptInfoHandler)
-	appMux.HandleFunc("/trigger-cart-update", handlers.TriggerCartUpdateHandler)
-
-	// Settings routes
-	appMux.HandleFunc("/settings", handlers.SettingsHandler)
-	appMux.HandleFunc("/api/settings/search", handlers.SettingsSearchHandler)
-	appMux.HandleFunc("/api/settings/update", handlers.SettingsUpdateHandler)
-
-	// Terminal Payment Endpoints
-	appMux.HandleFunc("/clear-terminal-transaction", handlers.ClearTerminalTransactionHandler)
-
-	// POS Page specific handlers
-	appMux.HandleFunc("/set-selected-reader", handlers.SetSelectedReaderHandler)
-
-	// Modal closing endpoint (assuming it's part of the authenticated UI)
-	// If it can be public, it could also be on rootMux.
-	appMux.HandleFunc("/close-modal", func(w http.ResponseWriter, r *http.Request) {
-		// Send HX-Trigger header to close the modal
-		w.Header().Set("HX-Trigger", "closeModal")
-		w.WriteHeader(http.StatusOK)
-	})
-
-	// Main application route (POS): Requires authentication
-	// This will handle requests to "/" after authentication.
-	appMux.HandleFunc("/", handlers.POSHandler)
-
-	// Apply auth middleware only to appMux routes.
-	// rootMux.Handle("/", ...) will catch all requests not already handled by rootMux
-	// (like /static/, /login, etc.) and pass them to the authedAppHandler.
-	authedAppHandler := handlers.AuthMiddleware(appMux)
-	rootMux.Handle("/", authedAppHandler)
+	// The app owns the in-flight payment state; its routes are registered by the handlers package
+	app := handlers.NewApp(&config.Config, services.Stripe)
+
+	// Metrics: behind login unless a separate internal listener is configured
+	if config.Config.MetricsAddress != "" {
+		startMetricsServer(app, config.Config.MetricsAddress)
+	}
+	// Security headers go on every response, including static files and the login page
+	rootMux := app.SecurityHeadersMiddleware(handlers.NewRouter(app))
 
 	// Start server using port from config or default
 	port := config.Config.Port
@@ -300,15 +259,17 @@ func main() {
 	}
 
 	// Determine protocol and start appropriate server
+	var err error
 	if shouldUseHTTPS() {
 		utils.Info("server", "Starting HTTPS server for local testing", "port", port, "website", config.Config.WebsiteName)
 		utils.Info("server", "⚠️  You will need to accept the security warning in your browser for the self-signed certificate")
 		utils.Info("server", "🔗 Access your application", "url", "https://"+serverAddress+":"+port)
 
 		// Generate self-signed certificate
-		cert, err := generateSelfSignedCert()
-		if err != nil {
-			log.Fatalf("Failed to generate self-signed certificate: %v", err)
+		cert, certErr := generateSelfSignedCert()
+		if certErr != nil {
+			utils.Error("server", "Failed to generate self-signed certificate", "error", certErr)
+			return exitFailure
 		}
 
 		// Create HTTPS server
@@ -320,12 +281,14 @@ func main() {
 			},
 		}
 
-		log.Fatal(server.ListenAndServeTLS("", ""))
+		err = server.ListenAndServeTLS("", "")
 	} else {
 		utils.Info("server", "Starting HTTP server for cloudflared", "port", port, "website", config.Config.WebsiteName)
 		utils.Info("server", "🔗 Expected to be accessed via cloudflared tunnel or reverse proxy")
 		utils.Info("server", "🔗 Local HTTP access", "url", "http://"+serverAddress+":"+port)
 
-		log.Fatal(http.ListenAndServe(serverAddress+":"+port, rootMux))
+		err = http.ListenAndServe(serverAddress+":"+port, rootMux)
 	}
+	utils.Error("server", "Server stopped", "error", err)
+	return exitFailure
 }
@@ -0,0 +1,104 @@
+package services
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"checkout/utils"
+)
+
+// Kinds of entry in the activity feed
+const (
+	ActivitySale           = "sale"            // A payment succeeded
+	ActivityDeclined       = "declined"        // A card payment was declined
+	ActivityReceiptSent    = "receipt_sent"    // A receipt was emailed or texted
+	ActivityReceiptFailed  = "receipt_failed"  // A receipt couldn't be sent
+	ActivityReaderDegraded = "reader_degraded" // A reader stopped answering the keep-alive checks
+	ActivityReaderOnline   = "reader_online"   // A degraded reader answered again
+)
+
+// activityBufferSize caps the entries kept in memory; older ones are overwritten
+const activityBufferSize = 100
+
+// ActivityEntry is something that just happened at the registers, shown in the POS activity feed.
+// Entries hold no customer contact details in the clear.
+type ActivityEntry struct {
+	At        time.Time
+	Kind      string
+	Method    string  // Payment method of a payment
+	Amount    float64 // Amount of a payment (0 when unknown)
+	PaymentID string  // Sale the entry opens, if any
+	Detail    string  // Masked receipt contact, or the reader's label
+}
+
+// activity is the ring buffer of recent entries, kept for the life of the process
+var activity = struct {
+	entries [activityBufferSize]ActivityEntry
+	next    int // Slot the next entry is written to
+	count   int
+	mutex   sync.Mutex
+}{}
+
+// RecordActivity adds an entry to the activity feed and tells the POS screens to show it
+func RecordActivity(entry ActivityEntry) {
+	if entry.At.IsZero() {
+		entry.At = time.Now()
+	}
+
+	activity.mutex.Lock()
+	activity.entries[activity.next] = entry
+	activity.next = (activity.next + 1) % activityBufferSize
+	activity.count = min(activity.count+1, activityBufferSize)
+	activity.mutex.Unlock()
+
+	PublishAppEvent(AppEvent{Name: EventActivityUpdated})
+}
+
+// RecordPaymentActivity adds a successful or declined payment to the activity feed
+func RecordPaymentActivity(kind, paymentID, method string, amount float64) {
+	RecordActivity(ActivityEntry{Kind: kind, Method: method, Amount: amount, PaymentID: paymentID})
+}
+
+// RecordReceiptActivity adds a receipt to the activity feed, with its email and phone masked
+func RecordReceiptActivity(paymentID, email, phone string, sent bool) {
+	var contacts []string
+	if email != "" {
+		contacts = append(contacts, utils.MaskEmails(email))
+	}
+	if phone != "" {
+		contacts = append(contacts, utils.MaskPhone(phone))
+	}
+	kind := ActivityReceiptSent
+	if !sent {
+		kind = ActivityReceiptFailed
+	}
+	RecordActivity(ActivityEntry{Kind: kind, PaymentID: paymentID, Detail: strings.Join(contacts, ", ")})
+}
+
+// recordReaderActivity adds a reader that stopped or started answering to the activity feed
+func recordReaderActivity(readerID string, degraded bool) {
+	label := readerID
+	for _, reader := range Terminal.Readers() {
+		if reader.ID == readerID && reader.Label != "" {
+			label = reader.Label
+		}
+	}
+	kind := ActivityReaderOnline
+	if degraded {
+		kind = ActivityReaderDegraded
+	}
+	RecordActivity(ActivityEntry{Kind: kind, Detail: label})
+}
+
+// RecentActivity returns up to limit entries of the activity feed, newest first
+func RecentActivity(limit int) []ActivityEntry {
+	activity.mutex.Lock()
+	defer activity.mutex.Unlock()
+
+	entries := make([]ActivityEntry, 0, min(limit, activity.count))
+	for i := 1; i <= activity.count && len(entries) < limit; i++ {
+		entries = append(entries, activity.entries[(activity.next-i+activityBufferSize)%activityBufferSize])
+	}
+	return entries
+}
@@ -0,0 +1,114 @@
+package services
+
+import (
+	"sync"
+
+	"checkout/utils"
+)
+
+// Names of the events pushed to the POS screens. They match the HX-Trigger events the page
+// already listens for, so a pushed event refreshes the same parts of the page.
+const (
+	EventCartUpdated         = "cartUpdated"
+	EventShowToast           = "showToast"
+	EventReaderStatusChanged = "readerStatusChanged"
+	EventPaymentRecovered    = "paymentRecovered"
+	EventActivityUpdated     = "activityUpdated"
+)
+
+// appEventBuffer caps the events waiting for one screen. A screen that falls further behind
+// loses its oldest events; the newest say the most about the current state.
+const appEventBuffer = 32
+
+// AppEvent is a UI update pushed to the POS screens by a handler or a background job
+type AppEvent struct {
+	Name   string
+	Detail any // Sent as the event's JSON data; nil sends true
+}
+
+// AppEventClient is one open POS screen subscribed to the events of its login session
+type AppEventClient struct {
+	Session string
+	Events  chan AppEvent
+}
+
+// appEvents holds the subscribed screens by session token
+var appEvents = struct {
+	bySession map[string]map[*AppEventClient]struct{}
+	mutex     sync.Mutex
+}{bySession: make(map[string]map[*AppEventClient]struct{})}
+
+// SubscribeAppEvents subscribes a screen of a login session to the pushed events
+func SubscribeAppEvents(session string) *AppEventClient {
+	client := &AppEventClient{Session: session, Events: make(chan AppEvent, appEventBuffer)}
+
+	appEvents.mutex.Lock()
+	defer appEvents.mutex.Unlock()
+	if appEvents.bySession[session] == nil {
+		appEvents.bySession[session] = make(map[*AppEventClient]struct{})
+	}
+	appEvents.bySession[session][client] = struct{}{}
+	return client
+}
+
+// UnsubscribeAppEvents stops the events of a screen from SubscribeAppEvents
+func UnsubscribeAppEvents(client *AppEventClient) {
+	appEvents.mutex.Lock()
+	defer appEvents.mutex.Unlock()
+	delete(appEvents.bySession[client.Session], client)
+	if len(appEvents.bySession[client.Session]) == 0 {
+		delete(appEvents.bySession, client.Session)
+	}
+}
+
+// PublishAppEvent pushes an event to every subscribed screen and returns how many it reached.
+// It never blocks.
+func PublishAppEvent(event AppEvent) int {
+	appEvents.mutex.Lock()
+	defer appEvents.mutex.Unlock()
+	sent := 0
+	for _, clients := range appEvents.bySession {
+		for client := range clients {
+			client.deliver(event)
+			sent++
+		}
+	}
+	return sent
+}
+
+// PublishAppEventTo pushes an event to the screens of one login session and returns how many it
+// reached. It never blocks.
+func PublishAppEventTo(session string, event AppEvent) int {
+	appEvents.mutex.Lock()
+	defer appEvents.mutex.Unlock()
+	for client := range appEvents.bySession[session] {
+		client.deliver(event)
+	}
+	return len(appEvents.bySession[session])
+}
+
+// PublishToast shows a toast on every subscribed screen, with the level of setToast ("success",
+// "warning", "error"), and returns how many screens it reached
+func PublishToast(level, message string) int {
+	return PublishAppEvent(AppEvent{Name: EventShowToast, Detail: map[string]string{"message": message, "type": level}})
+}
+
+// deliver queues an event for the client, dropping its oldest event when the buffer is full.
+// Must be called with the mutex held, which keeps publishers from racing for the buffer.
+func (c *AppEventClient) deliver(event AppEvent) {
+	select {
+	case c.Events <- event:
+		return
+	default:
+	}
+
+	select {
+	case dropped := <-c.Events:
+		utils.Debug("sse", "Screen is behind, dropped its oldest event", "event", dropped.Name)
+	default:
+	}
+	select {
+	case c.Events <- event:
+	default:
+	}
+}
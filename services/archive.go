@@ -0,0 +1,514 @@
+package services
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"checkout/config"
+	"checkout/utils"
+)
+
+// archiveCheckInterval is how often the archive scheduler looks for months to archive. A month
+// is archived at the first check after it passes the threshold.
+const archiveCheckInterval = 6 * time.Hour
+
+// archiveFilePatterns are the transaction files archived by month, relative to the transactions
+// directory: the daily CSV logs and the receipt and payment update logs
+var archiveFilePatterns = []string{"*.csv", "receipts/receipts-*.json", "updates/payment-updates-*.json"}
+
+// archiveFileDate finds the day a transaction file belongs to in its name
+var archiveFileDate = regexp.MustCompile(`(\d{4}-\d{2})-\d{2}\.(csv|json)$`)
+
+// MonthUsage is the disk used by one month of transaction files
+type MonthUsage struct {
+	Month         string // YYYY-MM
+	RawFiles      int    // Files not yet archived
+	RawBytes      int64
+	ArchivedFiles int // Files in the month's archive
+	ArchiveBytes  int64
+	Deletable     bool // Archived and past the retention window
+}
+
+// Archived reports whether the month has an archive
+func (m MonthUsage) Archived() bool {
+	return m.ArchivedFiles > 0
+}
+
+// archiveContents caches the most recently read archive, so a search through a month's logs
+// decompresses the archive once rather than once per file
+var archiveContents = struct {
+	path    string
+	modTime time.Time
+	files   map[string][]byte
+	mutex   sync.Mutex
+}{}
+
+// StartArchiveScheduler starts the job that bundles the transaction files of months past the
+// archive threshold into one compressed archive per month. Nothing is ever deleted by it.
+func StartArchiveScheduler() {
+	go func() {
+		ticker := time.NewTicker(archiveCheckInterval)
+		defer ticker.Stop()
+
+		for {
+			if _, err := ArchiveOldMonths(time.Now()); err != nil {
+				utils.Error("archive", "Error archiving transaction files", "error", err)
+			}
+			<-ticker.C
+		}
+	}()
+
+	utils.Info("archive", "Archive scheduler started", "archive_after_months", config.Config.ArchiveAfterMonths)
+}
+
+// ArchiveOldMonths archives every month of transaction files older than the configured number
+// of months and returns the months archived. The raw files are removed only after the archive
+// is written and read back intact.
+func ArchiveOldMonths(now time.Time) ([]string, error) {
+	if config.Config.ArchiveAfterMonths <= 0 {
+		return nil, nil
+	}
+	cutoff := archiveCutoff(now, config.Config.ArchiveAfterMonths)
+
+	// Nothing may upgrade or append to a log while it is being archived
+	transactionLogMutex.Lock()
+	defer transactionLogMutex.Unlock()
+
+	byMonth, err := rawFilesByMonth()
+	if err != nil {
+		return nil, err
+	}
+
+	var archived []string
+	for _, month := range sortedKeys(byMonth) {
+		if month >= cutoff {
+			continue
+		}
+		if err := archiveMonth(month, byMonth[month]); err != nil {
+			return archived, fmt.Errorf("error archiving %s: %w", month, err)
+		}
+		archived = append(archived, month)
+	}
+	return archived, nil
+}
+
+// archiveCutoff returns the first month (YYYY-MM) that is kept as raw files
+func archiveCutoff(now time.Time, months int) string {
+	local := now.In(config.GetBusinessLocation())
+	return time.Date(local.Year(), local.Month()-time.Month(months), 1, 0, 0, 0, 0, local.Location()).Format("2006-01")
+}
+
+// archiveMonth adds a month's raw files to its archive, verifies the archive and removes the
+// raw files. Callers must hold transactionLogMutex.
+func archiveMonth(month string, names []string) error {
+	archivePath := getArchivePath(month)
+	files, err := readArchive(archivePath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if files == nil {
+		files = make(map[string][]byte)
+	}
+
+	transactionsDir := getTransactionsDir()
+	var added []string
+	for _, name := range names {
+		if _, exists := files[name]; exists {
+			// Written after the month was archived; merging two versions of a log is left to an operator
+			utils.Warn("archive", "File is already in the month's archive, leaving it in place", "file", name, "archive", archivePath)
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(transactionsDir, filepath.FromSlash(name)))
+		if err != nil {
+			return err
+		}
+		files[name] = data
+		added = append(added, name)
+	}
+	if len(added) == 0 {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(archivePath), 0755); err != nil {
+		return fmt.Errorf("error creating archive directory: %w", err)
+	}
+	tmpPath := archivePath + ".tmp"
+	if err := writeArchive(tmpPath, files); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	// Financial records are only removed once the archive is known to hold them byte for byte
+	written, err := readArchive(tmpPath)
+	if err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("error verifying archive: %w", err)
+	}
+	for name, data := range files {
+		if sha256.Sum256(written[name]) != sha256.Sum256(data) {
+			os.Remove(tmpPath)
+			return fmt.Errorf("archive verification failed for %s", name)
+		}
+	}
+	if err := os.Rename(tmpPath, archivePath); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("error saving archive: %w", err)
+	}
+	forgetArchiveContents(archivePath)
+
+	for _, name := range added {
+		if err := os.Remove(filepath.Join(transactionsDir, filepath.FromSlash(name))); err != nil {
+			utils.Warn("archive", "Could not remove archived file", "file", name, "error", err)
+		}
+	}
+	utils.Info("archive", "Transaction files archived", "month", month, "files", len(added), "archive", archivePath)
+	return nil
+}
+
+// writeArchive writes files to a gzip-compressed tar archive, in name order
+func writeArchive(filename string, files map[string][]byte) error {
+	out, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("error creating archive: %w", err)
+	}
+	gz := gzip.NewWriter(out)
+	tw := tar.NewWriter(gz)
+
+	now := time.Now()
+	for _, name := range sortedKeys(files) {
+		header := &tar.Header{Name: name, Mode: 0644, Size: int64(len(files[name])), ModTime: now}
+		if err := tw.WriteHeader(header); err != nil {
+			out.Close()
+			return fmt.Errorf("error writing archive: %w", err)
+		}
+		if _, err := tw.Write(files[name]); err != nil {
+			out.Close()
+			return fmt.Errorf("error writing archive: %w", err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		out.Close()
+		return fmt.Errorf("error writing archive: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		out.Close()
+		return fmt.Errorf("error writing archive: %w", err)
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return fmt.Errorf("error writing archive: %w", err)
+	}
+	return out.Close()
+}
+
+// readArchive returns every file in an archive, keyed by its name
+func readArchive(filename string) (map[string][]byte, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		return nil, fmt.Errorf("error reading archive %s: %w", filepath.Base(filename), err)
+	}
+	defer gz.Close()
+
+	files := make(map[string][]byte)
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return files, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading archive %s: %w", filepath.Base(filename), err)
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, fmt.Errorf("error reading archive %s: %w", filepath.Base(filename), err)
+		}
+		files[header.Name] = data
+	}
+}
+
+// cachedArchive returns the files of an archive, reading it only if it isn't the one cached
+func cachedArchive(filename string) (map[string][]byte, error) {
+	info, err := os.Stat(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	archiveContents.mutex.Lock()
+	defer archiveContents.mutex.Unlock()
+	if archiveContents.path == filename && archiveContents.modTime.Equal(info.ModTime()) {
+		return archiveContents.files, nil
+	}
+
+	files, err := readArchive(filename)
+	if err != nil {
+		return nil, err
+	}
+	archiveContents.path, archiveContents.modTime, archiveContents.files = filename, info.ModTime(), files
+	return files, nil
+}
+
+// forgetArchiveContents drops an archive from the cache after it is replaced or deleted
+func forgetArchiveContents(filename string) {
+	archiveContents.mutex.Lock()
+	defer archiveContents.mutex.Unlock()
+	if archiveContents.path == filename {
+		archiveContents.path, archiveContents.files = "", nil
+	}
+}
+
+// openTransactionFile opens a transaction log, or reads it from its month's archive once it has
+// been archived. A file in neither place returns the error of opening it, so os.IsNotExist works.
+func openTransactionFile(filename string) (io.ReadCloser, error) {
+	file, err := os.Open(filename)
+	if err == nil {
+		return file, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	name, month, ok := archiveEntryName(filename)
+	if !ok {
+		return nil, err
+	}
+	files, archiveErr := cachedArchive(getArchivePath(month))
+	if os.IsNotExist(archiveErr) {
+		return nil, err
+	} else if archiveErr != nil {
+		return nil, archiveErr
+	}
+	data, archived := files[name]
+	if !archived {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+// globTransactionFiles lists the transaction files matching a pattern relative to the
+// transactions directory, whether raw or archived. Archived files are listed by the path they
+// had before archiving, which openTransactionFile accepts.
+func globTransactionFiles(pattern string) ([]string, error) {
+	transactionsDir := getTransactionsDir()
+	files, err := filepath.Glob(filepath.Join(transactionsDir, pattern))
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool, len(files))
+	for _, filename := range files {
+		seen[filename] = true
+	}
+	archives, err := filepath.Glob(filepath.Join(getArchiveDir(), "transactions-*.tar.gz"))
+	if err != nil {
+		return nil, err
+	}
+	for _, archivePath := range archives {
+		names, err := archiveIndex(archivePath)
+		if err != nil {
+			utils.Error("archive", "Error listing archive", "archive", archivePath, "error", err)
+			continue
+		}
+		for _, name := range names {
+			filename := filepath.Join(transactionsDir, filepath.FromSlash(name))
+			if matched, _ := path.Match(filepath.ToSlash(pattern), name); matched && !seen[filename] {
+				seen[filename] = true
+				files = append(files, filename)
+			}
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// archiveIndexes caches the file names in each archive, since listing them means decompressing it
+var archiveIndexes = struct {
+	entries map[string]archiveIndexEntry
+	mutex   sync.Mutex
+}{entries: make(map[string]archiveIndexEntry)}
+
+type archiveIndexEntry struct {
+	modTime time.Time
+	names   []string
+}
+
+// archiveIndex returns the names of the files in an archive
+func archiveIndex(filename string) ([]string, error) {
+	info, err := os.Stat(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	archiveIndexes.mutex.Lock()
+	defer archiveIndexes.mutex.Unlock()
+	if entry, ok := archiveIndexes.entries[filename]; ok && entry.modTime.Equal(info.ModTime()) {
+		return entry.names, nil
+	}
+
+	files, err := readArchive(filename)
+	if err != nil {
+		return nil, err
+	}
+	names := sortedKeys(files)
+	archiveIndexes.entries[filename] = archiveIndexEntry{modTime: info.ModTime(), names: names}
+	return names, nil
+}
+
+// archiveEntryName returns the name a transaction file has in an archive and the month it is
+// archived under
+func archiveEntryName(filename string) (string, string, bool) {
+	relative, err := filepath.Rel(getTransactionsDir(), filename)
+	if err != nil || strings.HasPrefix(relative, "..") {
+		return "", "", false
+	}
+	match := archiveFileDate.FindStringSubmatch(filepath.Base(filename))
+	if match == nil {
+		return "", "", false
+	}
+	return filepath.ToSlash(relative), match[1], true
+}
+
+// rawFilesByMonth lists the raw transaction files by the month they belong to, by archive name
+func rawFilesByMonth() (map[string][]string, error) {
+	byMonth := make(map[string][]string)
+	for _, pattern := range archiveFilePatterns {
+		files, err := filepath.Glob(filepath.Join(getTransactionsDir(), pattern))
+		if err != nil {
+			return nil, err
+		}
+		for _, filename := range files {
+			if name, month, ok := archiveEntryName(filename); ok {
+				byMonth[month] = append(byMonth[month], name)
+			}
+		}
+	}
+	return byMonth, nil
+}
+
+// DataUsage summarizes the disk used by each month of transaction files, newest month first
+func DataUsage(now time.Time) ([]MonthUsage, error) {
+	byMonth := make(map[string]*MonthUsage)
+	usage := func(month string) *MonthUsage {
+		if byMonth[month] == nil {
+			byMonth[month] = &MonthUsage{Month: month}
+		}
+		return byMonth[month]
+	}
+
+	raw, err := rawFilesByMonth()
+	if err != nil {
+		return nil, err
+	}
+	for month, names := range raw {
+		for _, name := range names {
+			info, err := os.Stat(filepath.Join(getTransactionsDir(), filepath.FromSlash(name)))
+			if err != nil {
+				continue
+			}
+			usage(month).RawFiles++
+			usage(month).RawBytes += info.Size()
+		}
+	}
+
+	archives, err := filepath.Glob(filepath.Join(getArchiveDir(), "transactions-*.tar.gz"))
+	if err != nil {
+		return nil, err
+	}
+	for _, archivePath := range archives {
+		month := archiveMonthOf(archivePath)
+		info, err := os.Stat(archivePath)
+		if err != nil {
+			continue
+		}
+		names, err := archiveIndex(archivePath)
+		if err != nil {
+			return nil, err
+		}
+		usage(month).ArchivedFiles = len(names)
+		usage(month).ArchiveBytes = info.Size()
+	}
+
+	retention := config.Config.RetentionMonths
+	months := make([]MonthUsage, 0, len(byMonth))
+	for _, month := range byMonth {
+		month.Deletable = retention > 0 && month.Archived() && month.Month < archiveCutoff(now, retention)
+		months = append(months, *month)
+	}
+	sort.Slice(months, func(i, j int) bool { return months[i].Month > months[j].Month })
+	return months, nil
+}
+
+// DeleteArchivedMonth permanently deletes an archived month past the retention window. The
+// confirmation must be "DELETE <month>", typed by the admin; deletion is never automatic.
+func DeleteArchivedMonth(month, confirmation, username string, now time.Time) error {
+	retention := config.Config.RetentionMonths
+	if retention <= 0 {
+		return fmt.Errorf("deleting records is disabled until a retention period is set")
+	}
+	if _, err := time.Parse("2006-01", month); err != nil {
+		return fmt.Errorf("invalid month %q, expected YYYY-MM", month)
+	}
+	if confirmation != "DELETE "+month {
+		return fmt.Errorf("type DELETE %s to confirm", month)
+	}
+	if month >= archiveCutoff(now, retention) {
+		return fmt.Errorf("%s is within the %d month retention period", month, retention)
+	}
+
+	transactionLogMutex.Lock()
+	defer transactionLogMutex.Unlock()
+
+	archivePath := getArchivePath(month)
+	if _, err := os.Stat(archivePath); err != nil {
+		return fmt.Errorf("%s has no archive; only archived months can be deleted", month)
+	}
+	if err := os.Remove(archivePath); err != nil {
+		return fmt.Errorf("error deleting archive: %w", err)
+	}
+	forgetArchiveContents(archivePath)
+
+	utils.Info("audit", "Archived transaction month deleted", "month", month, "archive", archivePath, "user", username)
+	return nil
+}
+
+// archiveMonthOf returns the month of an archive from its file name
+func archiveMonthOf(archivePath string) string {
+	name := filepath.Base(archivePath)
+	return name[len("transactions-") : len(name)-len(".tar.gz")]
+}
+
+// getArchivePath returns the archive of a month (YYYY-MM)
+func getArchivePath(month string) string {
+	return filepath.Join(getArchiveDir(), "transactions-"+month+".tar.gz")
+}
+
+// getArchiveDir returns where monthly archives are kept; demo sales are archived apart
+func getArchiveDir() string {
+	if config.Config.DemoMode {
+		return filepath.Join(demoDataDir(), "archive")
+	}
+	dataDir := config.Config.DataDir
+	if dataDir == "" {
+		dataDir = config.DefaultDataDir
+	}
+	return filepath.Join(dataDir, "archive")
+}
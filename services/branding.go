@@ -0,0 +1,142 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/jpeg" // Register the JPEG and PNG decoders for receipt logos
+	_ "image/png"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"checkout/config"
+	"checkout/utils"
+)
+
+// Receipt logo limits
+const (
+	MaxReceiptLogoSize      = 1 << 20 // Bytes
+	maxReceiptLogoDimension = 2000    // Pixels, either side
+)
+
+// receiptLogoExtensions maps the accepted logo content types to the file extension they are saved with
+var receiptLogoExtensions = map[string]string{
+	"image/png":  ".png",
+	"image/jpeg": ".jpg",
+}
+
+// SaveReceiptLogo checks an uploaded logo and stores it in the data directory, replacing any previous logo
+func SaveReceiptLogo(data []byte) error {
+	if len(data) == 0 {
+		return errors.New("the logo file is empty")
+	}
+	if len(data) > MaxReceiptLogoSize {
+		return fmt.Errorf("the logo must be %d KB or smaller", MaxReceiptLogoSize>>10)
+	}
+
+	extension, ok := receiptLogoExtensions[http.DetectContentType(data)]
+	if !ok {
+		return errors.New("the logo must be a PNG or JPEG image")
+	}
+	imageConfig, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("the logo image can't be read: %w", err)
+	}
+	if imageConfig.Width > maxReceiptLogoDimension || imageConfig.Height > maxReceiptLogoDimension {
+		return fmt.Errorf("the logo must be at most %d pixels wide and high (it is %dx%d)",
+			maxReceiptLogoDimension, imageConfig.Width, imageConfig.Height)
+	}
+
+	filename := "receipt-logo" + extension
+	if err := os.WriteFile(filepath.Join(getBrandingDir(), filename), data, 0644); err != nil {
+		return fmt.Errorf("error saving logo: %w", err)
+	}
+
+	// A PNG replacing a JPEG (or the reverse) leaves the old file behind
+	previous := config.Config.ReceiptLogo
+	if err := config.SetReceiptLogo(filename); err != nil {
+		return fmt.Errorf("error saving config: %w", err)
+	}
+	if previous != "" && previous != filename {
+		removeReceiptLogoFile(previous)
+	}
+
+	utils.Info("branding", "Receipt logo updated", "file", filename, "width", imageConfig.Width, "height", imageConfig.Height)
+	return nil
+}
+
+// RemoveReceiptLogo deletes the receipt logo so receipts are printed without one
+func RemoveReceiptLogo() error {
+	previous := config.Config.ReceiptLogo
+	if previous == "" {
+		return nil
+	}
+	if err := config.SetReceiptLogo(""); err != nil {
+		return fmt.Errorf("error saving config: %w", err)
+	}
+	removeReceiptLogoFile(previous)
+
+	utils.Info("branding", "Receipt logo removed")
+	return nil
+}
+
+// ReceiptLogoPath returns the path of the uploaded receipt logo, or "" if there is none
+func ReceiptLogoPath() string {
+	filename := config.Config.ReceiptLogo
+	if filename == "" {
+		return ""
+	}
+	// The config only ever holds a name written by SaveReceiptLogo, but don't follow paths out of the data directory
+	path := filepath.Join(getBrandingDir(), filepath.Base(filename))
+	if _, err := os.Stat(path); err != nil {
+		return ""
+	}
+	return path
+}
+
+// ReceiptLogoURL returns the address of the receipt logo for img tags, or "" if there is none.
+// The file's modification time is added so browsers fetch a replaced logo.
+func ReceiptLogoURL() string {
+	path := ReceiptLogoPath()
+	if path == "" {
+		return ""
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf("/receipt-logo?v=%d", info.ModTime().Unix())
+}
+
+// loadReceiptLogo decodes the receipt logo for the PDF receipt
+func loadReceiptLogo() (image.Image, error) {
+	path := ReceiptLogoPath()
+	if path == "" {
+		return nil, errors.New("no receipt logo")
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	logo, _, err := image.Decode(file)
+	return logo, err
+}
+
+func removeReceiptLogoFile(filename string) {
+	path := filepath.Join(getBrandingDir(), filepath.Base(filename))
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		utils.Warn("branding", "Error removing old receipt logo", "file", path, "error", err)
+	}
+}
+
+func getBrandingDir() string {
+	dataDir := config.Config.DataDir
+	if dataDir == "" {
+		dataDir = config.DefaultDataDir
+	}
+	return dataDir
+}
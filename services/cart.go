@@ -0,0 +1,331 @@
+package services
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"checkout/templates"
+	"checkout/utils"
+)
+
+// CartStore is a sale in progress: the cart's items, the split payment paying for them, and the
+// tip and note entered for it. Every read returns a copy, so callers can't change the sale
+// behind the lock.
+type CartStore struct {
+	items  []templates.Product
+	split  *SplitPayment // Split sale in progress, nil when the cart is paid with a single tender
+	tip    float64       // Tip chosen on screen for the next QR or manual card payment
+	note   string        // Note or order reference entered on the checkout form
+	method string        // Payment method picked for the sale, which decides the service fee
+	// User who last made a change at the register, credited with its payments; kept across sales
+	cashier string
+	// Category the register's product list is showing (e.g. ["cat1", "cat2"]); kept across sales
+	categoryPath []string
+	// Declined card payments of the sale, oldest first; a retry reuses the last one's intent
+	attempts []PaymentAttempt
+	// When the cashier last changed the cart, and whether it was since cleared for inactivity
+	// without the POS being told (see cart_activity.go)
+	lastChange  time.Time
+	idleCleared bool
+	mutex       sync.RWMutex
+
+	listeners []func() // Called after the items or payment method change (see OnChange)
+}
+
+// NewCartStore creates an empty cart
+func NewCartStore() *CartStore {
+	return &CartStore{items: []templates.Product{}}
+}
+
+// OnChange registers fn to be called after every change to the cart's items or payment method,
+// once the change is complete. fn must not block.
+func (c *CartStore) OnChange(fn func()) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.listeners = append(c.listeners, fn)
+}
+
+// changed calls the OnChange listeners; mutators defer it before taking the lock so it runs
+// after the lock is released
+func (c *CartStore) changed() {
+	c.mutex.RLock()
+	listeners := c.listeners
+	c.mutex.RUnlock()
+	for _, fn := range listeners {
+		fn()
+	}
+}
+
+// Items returns a copy of the cart's items, in the order they were added
+func (c *CartStore) Items() []templates.Product {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return append([]templates.Product{}, c.items...)
+}
+
+// Len returns the number of items in the cart
+func (c *CartStore) Len() int {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return len(c.items)
+}
+
+// Item returns the item at index
+func (c *CartStore) Item(index int) (templates.Product, bool) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	if index < 0 || index >= len(c.items) {
+		return templates.Product{}, false
+	}
+	return c.items[index], true
+}
+
+// Hash fingerprints the cart's items (see CartHash)
+func (c *CartStore) Hash() string {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return CartHash(c.items)
+}
+
+// Add appends items to the cart
+func (c *CartStore) Add(items ...templates.Product) {
+	defer c.changed()
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.items = append(c.items, items...)
+}
+
+// Replace swaps the cart's items for items
+func (c *CartStore) Replace(items []templates.Product) {
+	defer c.changed()
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.items = append([]templates.Product{}, items...)
+}
+
+// Remove takes the item at index out of the cart and returns it
+func (c *CartStore) Remove(index int) (templates.Product, bool) {
+	defer c.changed()
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if index < 0 || index >= len(c.items) {
+		return templates.Product{}, false
+	}
+	removed := c.items[index]
+	c.items = append(c.items[:index:index], c.items[index+1:]...)
+	return removed, true
+}
+
+// Update changes the item at index in place and returns the changed item
+func (c *CartStore) Update(index int, change func(item *templates.Product)) (templates.Product, bool) {
+	defer c.changed()
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if index < 0 || index >= len(c.items) {
+		return templates.Product{}, false
+	}
+	change(&c.items[index])
+	return c.items[index], true
+}
+
+// Clear empties the cart's items and forgets its declined payments, keeping the tip, note and
+// split payment
+func (c *CartStore) Clear() {
+	defer c.changed()
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.items = []templates.Product{}
+	c.attempts = nil
+}
+
+// ClearIfHash empties the cart only while its items still hash to hash, so a payment
+// finishing late never clears a cart the cashier started after it. Reports whether it cleared.
+func (c *CartStore) ClearIfHash(hash string) bool {
+	defer c.changed()
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if CartHash(c.items) != hash {
+		return false
+	}
+	c.items = []templates.Product{}
+	c.attempts = nil
+	return true
+}
+
+// Reset empties the cart and forgets the tip, note and payment method entered for it
+func (c *CartStore) Reset() {
+	defer c.changed()
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.items = []templates.Product{}
+	c.tip = 0
+	c.note = ""
+	c.method = ""
+	c.attempts = nil
+}
+
+// RecordDeclinedAttempt adds a declined card payment to the sale, so a retry reuses its intent
+// and the paid transaction records what was tried before
+func (c *CartStore) RecordDeclinedAttempt(attempt PaymentAttempt) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.attempts = append(c.attempts, attempt)
+}
+
+// LastDeclinedAttempt returns the sale's most recent declined card payment
+func (c *CartStore) LastDeclinedAttempt() (PaymentAttempt, bool) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	if len(c.attempts) == 0 {
+		return PaymentAttempt{}, false
+	}
+	return c.attempts[len(c.attempts)-1], true
+}
+
+// PaymentAttempts returns the sale's declined card payments, oldest first
+func (c *CartStore) PaymentAttempts() []PaymentAttempt {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return append([]PaymentAttempt(nil), c.attempts...)
+}
+
+// PaymentMethod returns the payment method picked for the sale, or "" if none was picked yet
+func (c *CartStore) PaymentMethod() string {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.method
+}
+
+// SetPaymentMethod picks the payment method for the sale
+func (c *CartStore) SetPaymentMethod(method string) {
+	defer c.changed()
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.method = method
+}
+
+// Note returns the note or order reference entered for the sale
+func (c *CartStore) Note() string {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.note
+}
+
+// SetNote sets the sale's note
+func (c *CartStore) SetNote(note string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.note = note
+}
+
+// Cashier returns the user working the register, the one its payments are credited to
+func (c *CartStore) Cashier() string {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.cashier
+}
+
+// SetCashier records the user working the register
+func (c *CartStore) SetCashier(username string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.cashier = username
+}
+
+// CategoryPath returns the category the register has navigated to, empty at the root
+func (c *CartStore) CategoryPath() []string {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return append([]string{}, c.categoryPath...)
+}
+
+// SetCategoryPath navigates the register to a category
+func (c *CartStore) SetCategoryPath(path []string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.categoryPath = append([]string{}, path...)
+}
+
+// Tip returns the tip chosen on screen for the next payment
+func (c *CartStore) Tip() float64 {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.tip
+}
+
+// SetTip sets the tip for the next payment
+func (c *CartStore) SetTip(tip float64) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.tip = tip
+}
+
+// TakeTip returns the tip and clears it, so it is recorded with one payment only
+func (c *CartStore) TakeTip() float64 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	tip := c.tip
+	c.tip = 0
+	return tip
+}
+
+// Split returns a copy of the split sale in progress, or nil if there is none
+func (c *CartStore) Split() *SplitPayment {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.split.clone()
+}
+
+// StartSplit begins a split sale for the cart, or returns the one in progress
+func (c *CartStore) StartSplit() *SplitPayment {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.split == nil {
+		c.split = &SplitPayment{
+			ConfirmationCode: NewPaymentID(),
+			StartTime:        time.Now(),
+		}
+		utils.Info("payment", "Split payment started", "confirmation_code", c.split.ConfirmationCode, "cart_items", len(c.items))
+	}
+	return c.split.clone()
+}
+
+// SetSplitPending sets the amount of the split tender being charged (0 = none).
+// It does nothing when no split sale is in progress.
+func (c *CartStore) SetSplitPending(amount float64) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.split != nil {
+		c.split.PendingAmount = amount
+	}
+}
+
+// AddSplitTender adds a captured tender to the split sale and returns the updated split
+func (c *CartStore) AddSplitTender(tender templates.Tender) (*SplitPayment, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.split == nil {
+		return nil, fmt.Errorf("no split payment in progress")
+	}
+	c.split.Tenders = append(c.split.Tenders, tender)
+	c.split.PendingAmount = 0
+	return c.split.clone(), nil
+}
+
+// SetSplitTenders replaces the split sale's captured tenders, e.g. with the ones a
+// cancellation could not refund
+func (c *CartStore) SetSplitTenders(tenders []templates.Tender) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.split != nil {
+		c.split.Tenders = append([]templates.Tender{}, tenders...)
+	}
+}
+
+// ClearSplit ends the split sale in progress
+func (c *CartStore) ClearSplit() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.split = nil
+}
@@ -0,0 +1,66 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"checkout/templates"
+	"checkout/utils"
+)
+
+// Touch records that the cashier just changed the cart
+func (c *CartStore) Touch() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.lastChange = time.Now()
+}
+
+// CartHash fingerprints the contents of a cart, so a payment can tell whether the cart it was
+// started for is still the one on screen. An empty cart hashes to "".
+func CartHash(cart []templates.Product) string {
+	if len(cart) == 0 {
+		return ""
+	}
+	data, err := json.Marshal(cart)
+	if err != nil {
+		utils.Error("cart", "Error hashing cart", "error", err)
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// IdleExpired reports whether the cart has gone unchanged for at least timeout as of now.
+// A cart nobody has touched yet (e.g. one restored at startup) starts its idle clock here.
+func (c *CartStore) IdleExpired(now time.Time, timeout time.Duration) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.lastChange.IsZero() {
+		c.lastChange = now
+		return false
+	}
+	return now.Sub(c.lastChange) >= timeout
+}
+
+// ClearIdle empties an abandoned cart along with the tip and note entered for it,
+// and leaves a notice for the POS to show the next time it checks in
+func (c *CartStore) ClearIdle() {
+	utils.Info("cart", "Cleared idle cart", "items", c.Len())
+	c.Reset()
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.lastChange = time.Time{}
+	c.idleCleared = true
+}
+
+// TakeIdleNotice reports whether the cart was cleared for inactivity since the last call
+func (c *CartStore) TakeIdleNotice() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	cleared := c.idleCleared
+	c.idleCleared = false
+	return cleared
+}
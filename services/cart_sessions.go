@@ -0,0 +1,100 @@
+package services
+
+import "sync"
+
+// CartSessions keeps a cart for each session at the registers, so cashiers signed in on
+// different browsers each ring up their own sale. A session's cart is created the first time
+// it is asked for.
+type CartSessions struct {
+	carts     map[string]*CartStore
+	latest    *CartStore // Cart changed most recently, nil until one is created
+	listeners []func()   // Registered on every cart, including ones created later (see OnChange)
+	mutex     sync.Mutex
+}
+
+// NewCartSessions creates a store with no carts
+func NewCartSessions() *CartSessions {
+	return &CartSessions{carts: make(map[string]*CartStore)}
+}
+
+// Get returns the cart of a session, creating an empty one the first time
+func (s *CartSessions) Get(session string) *CartStore {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if cart, ok := s.carts[session]; ok {
+		return cart
+	}
+	cart := NewCartStore()
+	cart.OnChange(func() { s.setLatest(cart) })
+	for _, fn := range s.listeners {
+		cart.OnChange(fn)
+	}
+	s.carts[session] = cart
+	if s.latest == nil {
+		s.latest = cart
+	}
+	return cart
+}
+
+// Drop forgets the cart of a session that has ended. Payments still in flight for it keep
+// their own reference, so they can finish.
+func (s *CartSessions) Drop(session string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if cart, ok := s.carts[session]; ok && cart == s.latest {
+		s.latest = nil
+	}
+	delete(s.carts, session)
+}
+
+// All returns the carts of every session, in no particular order
+func (s *CartSessions) All() []*CartStore {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	carts := make([]*CartStore, 0, len(s.carts))
+	for _, cart := range s.carts {
+		carts = append(carts, cart)
+	}
+	return carts
+}
+
+// Latest returns the cart changed most recently, which is the one the customer display shows.
+// Before any session has a cart it returns an empty one.
+func (s *CartSessions) Latest() *CartStore {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.latest == nil {
+		return NewCartStore()
+	}
+	return s.latest
+}
+
+// OnChange registers fn to be called after every change to any session's cart (see
+// CartStore.OnChange). fn must not block.
+func (s *CartSessions) OnChange(fn func()) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.listeners = append(s.listeners, fn)
+	for _, cart := range s.carts {
+		cart.OnChange(fn)
+	}
+}
+
+// setLatest records that a cart was just changed
+func (s *CartSessions) setLatest(cart *CartStore) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// A dropped session's cart can still change while its last payment finishes
+	for _, c := range s.carts {
+		if c == cart {
+			s.latest = cart
+			return
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package services
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"checkout/templates"
+)
+
+func TestCartSessionsAreSeparate(t *testing.T) {
+	carts := NewCartSessions()
+	var notified atomic.Int32
+	carts.OnChange(func() { notified.Add(1) })
+
+	first := carts.Get("register-1")
+	if carts.Get("register-1") != first {
+		t.Fatalf("a session got a different cart on its second request")
+	}
+	second := carts.Get("register-2")
+	first.Add(templates.Product{Name: "Coffee", Price: 4.50})
+
+	if second.Len() != 0 {
+		t.Errorf("register-2 cart has %d items, want 0", second.Len())
+	}
+	if carts.Latest() != first {
+		t.Errorf("latest cart isn't the one just changed")
+	}
+	second.Add(templates.Product{Name: "Bagel", Price: 3.25})
+	if carts.Latest() != second {
+		t.Errorf("latest cart didn't follow the change at register-2")
+	}
+	if got := notified.Load(); got != 2 {
+		t.Errorf("OnChange called %d times, want once per change in either cart", got)
+	}
+
+	carts.Drop("register-2")
+	if carts.Latest() == second {
+		t.Errorf("dropped cart is still the latest")
+	}
+	if carts.Get("register-2") == second {
+		t.Errorf("dropped session got its old cart back")
+	}
+}
+
+func TestCartSessionsConcurrentAddRemove(t *testing.T) {
+	carts := NewCartSessions()
+	carts.OnChange(func() {})
+	const registers, rounds = 4, 200
+
+	// Each register adds two items and removes one per round on its own cart, and all of them
+	// add and remove on a cart they share, while the display reads the latest cart
+	shared := carts.Get("shared")
+	var wg sync.WaitGroup
+	var sharedRemoved atomic.Int32
+	for i := range registers {
+		session := fmt.Sprintf("register-%d", i)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := range rounds {
+				cart := carts.Get(session)
+				cart.Add(templates.Product{Name: "Coffee", Price: 4.50}, templates.Product{Name: "Bagel", Price: 3.25})
+				cart.Remove(0)
+				shared.Add(templates.Product{Name: fmt.Sprintf("%s-%d", session, n), Price: 1})
+				if _, removed := shared.Remove(0); removed {
+					sharedRemoved.Add(1)
+				}
+				_ = CalculateCartSummary(carts.Latest())
+			}
+		}()
+	}
+	wg.Wait()
+
+	for i := range registers {
+		if got := carts.Get(fmt.Sprintf("register-%d", i)).Len(); got != rounds {
+			t.Errorf("register-%d cart has %d items, want %d", i, got, rounds)
+		}
+	}
+	if got, want := shared.Len(), registers*rounds-int(sharedRemoved.Load()); got != want {
+		t.Errorf("shared cart has %d items, want %d", got, want)
+	}
+}
@@ -0,0 +1,80 @@
+package services
+
+import (
+	"strings"
+	"sync"
+
+	"checkout/templates"
+)
+
+// ProductCatalog holds the products sold at the register with the category tree and SKU lookup
+// built from them. It is read on every screen and replaced as a whole when the catalog changes.
+type ProductCatalog struct {
+	products   []templates.Product
+	categories CategoryData
+	bySKU      map[string]templates.Product // Product lookup by SKU/barcode
+	mutex      sync.RWMutex
+}
+
+// NewProductCatalog creates an empty catalog
+func NewProductCatalog() *ProductCatalog {
+	return &ProductCatalog{
+		products:   []templates.Product{},
+		categories: BuildCategoryData(nil),
+		bySKU:      make(map[string]templates.Product),
+	}
+}
+
+// Set replaces the products and rebuilds the lookups derived from them
+func (c *ProductCatalog) Set(products []templates.Product) {
+	products = append([]templates.Product{}, products...)
+	categories := BuildCategoryData(products)
+	bySKU := BuildSKUIndex(products)
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.products = products
+	c.categories = categories
+	c.bySKU = bySKU
+}
+
+// Products returns a copy of every product, in catalog order
+func (c *ProductCatalog) Products() []templates.Product {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return append([]templates.Product{}, c.products...)
+}
+
+// FindByID looks up a product by its catalog ID
+func (c *ProductCatalog) FindByID(id string) (templates.Product, bool) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	for _, product := range c.products {
+		if product.ID == id {
+			return product, true
+		}
+	}
+	return templates.Product{}, false
+}
+
+// FindBySKU looks up a product by scanned SKU/barcode
+func (c *ProductCatalog) FindBySKU(code string) (templates.Product, bool) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	product, exists := c.bySKU[NormalizeSKU(code)]
+	return product, exists
+}
+
+// Subcategories returns the categories directly below a category path (nil = top level)
+func (c *ProductCatalog) Subcategories(path []string) []string {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return append([]string(nil), c.categories.Subcategories[strings.Join(path, "/")]...)
+}
+
+// ProductsIn returns the products directly in a category path (nil = uncategorized)
+func (c *ProductCatalog) ProductsIn(path []string) []templates.Product {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return append([]templates.Product(nil), c.categories.DirectProducts[strings.Join(path, "/")]...)
+}
@@ -0,0 +1,139 @@
+package services
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"checkout/config"
+	"checkout/utils"
+)
+
+// How often the local clock is compared with Stripe's, and how long a comparison may take
+const (
+	clockCheckInterval = 6 * time.Hour
+	clockCheckTimeout  = 10 * time.Second
+)
+
+// stripeClockURL answers every request with a Date header, even without an API key
+const stripeClockURL = "https://api.stripe.com/v1"
+
+// ClockSkew is how far the local clock was from Stripe's at the last check
+type ClockSkew struct {
+	Skew      time.Duration // Local time minus Stripe's; positive when the local clock is ahead
+	CheckedAt time.Time     // Zero before the first check
+	Error     string        // Why the last check failed
+}
+
+// Known reports whether the skew was measured
+func (c ClockSkew) Known() bool {
+	return !c.CheckedAt.IsZero() && c.Error == ""
+}
+
+// Excessive reports whether the skew is large enough for webhook signatures to be rejected
+func (c ClockSkew) Excessive() bool {
+	skew := c.Skew
+	if skew < 0 {
+		skew = -skew
+	}
+	return c.Known() && skew > config.GetWebhookTolerance()
+}
+
+// Seconds is the skew in whole seconds, e.g. for "likely clock skew of 412s"
+func (c ClockSkew) Seconds() int64 {
+	return int64(c.Skew.Round(time.Second) / time.Second)
+}
+
+// clockSkew holds the last clock check
+var clockSkew = struct {
+	last  ClockSkew
+	mutex sync.RWMutex
+}{}
+
+// ClockSkewStatus returns the last clock check; CheckedAt is zero before the first one
+func ClockSkewStatus() ClockSkew {
+	clockSkew.mutex.RLock()
+	defer clockSkew.mutex.RUnlock()
+	return clockSkew.last
+}
+
+// StartClockSkewCheck compares the local clock with Stripe's now and every clockCheckInterval, in
+// the background so startup doesn't wait on the network. A clock off by more than the webhook
+// tolerance makes Stripe's webhook signatures look expired. Demo mode doesn't talk to Stripe.
+func StartClockSkewCheck() {
+	if config.Config.DemoMode {
+		utils.Info("clock", "Clock check skipped in demo mode")
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(clockCheckInterval)
+		defer ticker.Stop()
+
+		for {
+			CheckClockSkew()
+			<-ticker.C
+		}
+	}()
+
+	utils.Info("clock", "Clock check started", "interval", clockCheckInterval.String())
+}
+
+// CheckClockSkew measures the local clock against the Date header of a Stripe API response. The
+// header has whole seconds, so the skew is accurate to about a second, which is plenty next to
+// the webhook tolerance of minutes.
+func CheckClockSkew() ClockSkew {
+	check := ClockSkew{CheckedAt: time.Now()}
+	skew, err := measureClockSkew(stripeClockURL)
+	if err != nil {
+		utils.Warn("clock", "Error comparing the local clock with Stripe's", "error", err)
+		check.Error = err.Error()
+	} else {
+		check.Skew = skew
+	}
+
+	clockSkew.mutex.Lock()
+	clockSkew.last = check
+	clockSkew.mutex.Unlock()
+
+	if check.Excessive() {
+		utils.Error("clock", "SYSTEM CLOCK IS OFF: webhook signatures will be rejected until the clock is corrected",
+			"skew_seconds", check.Seconds(), "tolerance_seconds", int64(config.GetWebhookTolerance()/time.Second))
+	} else if check.Known() {
+		utils.Info("clock", "Local clock checked against Stripe", "skew_seconds", check.Seconds())
+	}
+	return check
+}
+
+// measureClockSkew returns the local time minus the time in the Date header of url's response,
+// taking the local time halfway through the request
+func measureClockSkew(url string) (time.Duration, error) {
+	client := &http.Client{Timeout: clockCheckTimeout}
+	sent := time.Now()
+	response, err := client.Head(url)
+	if err != nil {
+		return 0, err
+	}
+	received := time.Now()
+	response.Body.Close()
+
+	date, err := http.ParseTime(response.Header.Get("Date"))
+	if err != nil {
+		return 0, fmt.Errorf("response has no valid Date header: %w", err)
+	}
+	local := sent.Add(received.Sub(sent) / 2)
+	return local.Sub(date), nil
+}
+
+// CheckClockHealth reports the last clock check. A clock off by more than the webhook tolerance
+// is a warning: payments still complete by polling, only later than they should.
+func CheckClockHealth() HealthCheck {
+	status := ClockSkewStatus()
+	check := HealthCheck{OK: true, CheckedAt: status.CheckedAt}
+	if status.Excessive() {
+		check.Warning = fmt.Sprintf("local clock is %ds off Stripe's, beyond the %ds webhook tolerance",
+			status.Seconds(), int64(config.GetWebhookTolerance()/time.Second))
+	}
+	return check
+}
@@ -0,0 +1,246 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"checkout/config"
+	"checkout/services/validation"
+	"checkout/templates"
+	"checkout/utils"
+)
+
+// MaxItemNameLength is the longest custom item name accepted, in characters
+const MaxItemNameLength = 100
+
+// maxRecentCustomProducts is how many of the day's custom items the custom item form offers
+const maxRecentCustomProducts = 8
+
+// customProductIDPrefix starts the cart ID of a custom item, which isn't in the catalog
+const customProductIDPrefix = "custom-"
+
+// RecentCustomProduct is a custom item rung up during the business day
+type RecentCustomProduct struct {
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	Price       float64   `json:"price"`
+	Uses        int       `json:"uses"`     // Times it was added to a cart today
+	LastUsed    time.Time `json:"lastUsed"` // Last time it was added
+}
+
+// recentCustomProducts is the day's custom items and the business day they were rung up on
+type recentCustomProducts struct {
+	Day      string                `json:"day"`
+	Products []RecentCustomProduct `json:"products"`
+}
+
+// recentCustomProductsMutex serializes changes to the day's custom items
+var recentCustomProductsMutex sync.Mutex
+
+// IsCustomProduct reports whether a cart line is a custom item rather than a catalog product
+func IsCustomProduct(product templates.Product) bool {
+	return strings.HasPrefix(product.ID, customProductIDPrefix)
+}
+
+// NewCustomProduct checks a custom item typed at the register and returns it ready for the cart.
+// Control characters are removed and whitespace collapsed, as in a sale note; the name is
+// required, and a name, description or price out of bounds is rejected rather than cut, so the
+// cashier can correct it.
+func NewCustomProduct(name, description, priceText string) (templates.Product, error) {
+	name = sanitizeCashierText(name, MaxItemNameLength+1)
+	if name == "" {
+		return templates.Product{}, &validation.Error{Key: "validation.item_name_required"}
+	}
+	if len([]rune(name)) > MaxItemNameLength {
+		return templates.Product{}, &validation.Error{Key: "validation.item_name_too_long", Args: []interface{}{MaxItemNameLength}}
+	}
+
+	description = sanitizeCashierText(description, MaxLineDescriptionLength+1)
+	if len([]rune(description)) > MaxLineDescriptionLength {
+		return templates.Product{}, &validation.Error{Key: "validation.item_description_too_long", Args: []interface{}{MaxLineDescriptionLength}}
+	}
+
+	price, err := validation.Price(priceText, config.Config.CustomItemMaxPrice)
+	if err != nil {
+		return templates.Product{}, err
+	}
+
+	return templates.Product{
+		ID:          fmt.Sprintf("%s%d", customProductIDPrefix, time.Now().UnixNano()),
+		Name:        name,
+		Description: description,
+		Price:       price,
+	}, nil
+}
+
+// RecordCustomProduct counts a custom item among the business day's custom items, matched by name
+// and price. The list is kept in the data directory and starts again each business day.
+func RecordCustomProduct(product templates.Product, now time.Time) error {
+	recentCustomProductsMutex.Lock()
+	defer recentCustomProductsMutex.Unlock()
+
+	recent, err := loadRecentCustomProducts(now)
+	if err != nil {
+		return err
+	}
+
+	found := false
+	for i := range recent.Products {
+		if sameCustomProduct(recent.Products[i], product.Name, product.Price) {
+			recent.Products[i].Description = product.Description
+			recent.Products[i].Uses++
+			recent.Products[i].LastUsed = now
+			found = true
+			break
+		}
+	}
+	if !found {
+		recent.Products = append(recent.Products, RecentCustomProduct{
+			Name:        product.Name,
+			Description: product.Description,
+			Price:       product.Price,
+			Uses:        1,
+			LastUsed:    now,
+		})
+	}
+
+	if err := writeJSONFile(getRecentCustomProductsPath(), recent); err != nil {
+		return fmt.Errorf("error saving recent custom items: %w", err)
+	}
+	return nil
+}
+
+// RecentCustomProducts returns the business day's custom items, most used first
+func RecentCustomProducts(now time.Time) []RecentCustomProduct {
+	recentCustomProductsMutex.Lock()
+	defer recentCustomProductsMutex.Unlock()
+
+	recent, err := loadRecentCustomProducts(now)
+	if err != nil {
+		utils.Error("cart", "Error loading recent custom items", "error", err)
+		return nil
+	}
+
+	products := recent.Products
+	sort.SliceStable(products, func(i, j int) bool {
+		if products[i].Uses != products[j].Uses {
+			return products[i].Uses > products[j].Uses
+		}
+		return products[i].LastUsed.After(products[j].LastUsed)
+	})
+	if len(products) > maxRecentCustomProducts {
+		products = products[:maxRecentCustomProducts]
+	}
+	return products
+}
+
+// PriceText is the price as typed into the custom item form, e.g. "12.50"
+func (p RecentCustomProduct) PriceText() string {
+	return strconv.FormatFloat(p.Price, 'f', 2, 64)
+}
+
+// SaveCustomProduct adds the custom item on a cart line to the catalog, with its Stripe product
+// and price, and makes the line the new catalog product. The price before any override and the
+// description before any edit are the ones saved.
+func SaveCustomProduct(cart *CartStore, index int) (templates.Product, error) {
+	item, ok := cart.Item(index)
+	if !ok {
+		return templates.Product{}, fmt.Errorf("no cart item at index %d", index)
+	}
+	if !IsCustomProduct(item) {
+		return templates.Product{}, fmt.Errorf("%s is already in the catalog", item.Name)
+	}
+
+	product := templates.Product{Name: item.Name, Description: item.Description, Price: item.Price}
+	if item.OverrideReason != "" {
+		product.Price = item.OriginalPrice
+	}
+	if item.DescriptionEdited {
+		product.Description = item.OriginalDescription
+	}
+	for _, existing := range Catalog.Products() {
+		if strings.EqualFold(existing.Name, product.Name) && existing.Price == product.Price {
+			return templates.Product{}, fmt.Errorf("%s at this price is already in the catalog", existing.Name)
+		}
+	}
+
+	saved, err := AddProduct(product)
+	if err != nil {
+		return templates.Product{}, err
+	}
+
+	cart.Update(index, func(line *templates.Product) {
+		line.ID = saved.ID
+		line.StripeProductID = saved.StripeProductID
+		line.PriceID = saved.PriceID
+	})
+	forgetCustomProduct(saved.Name, saved.Price)
+	return saved, nil
+}
+
+// forgetCustomProduct drops a custom item saved to the catalog from the day's custom items
+func forgetCustomProduct(name string, price float64) {
+	recentCustomProductsMutex.Lock()
+	defer recentCustomProductsMutex.Unlock()
+
+	recent, err := loadRecentCustomProducts(time.Now())
+	if err != nil {
+		utils.Error("cart", "Error loading recent custom items", "error", err)
+		return
+	}
+	kept := recent.Products[:0]
+	for _, product := range recent.Products {
+		if !sameCustomProduct(product, name, price) {
+			kept = append(kept, product)
+		}
+	}
+	recent.Products = kept
+	if err := writeJSONFile(getRecentCustomProductsPath(), recent); err != nil {
+		utils.Error("cart", "Error saving recent custom items", "error", err)
+	}
+}
+
+// sameCustomProduct reports whether a recent custom item has the name (ignoring case) and price
+func sameCustomProduct(product RecentCustomProduct, name string, price float64) bool {
+	return strings.EqualFold(product.Name, name) && product.Price == price
+}
+
+// loadRecentCustomProducts reads the day's custom items; a list from an earlier business day is
+// discarded. Callers must hold recentCustomProductsMutex.
+func loadRecentCustomProducts(now time.Time) (recentCustomProducts, error) {
+	today := config.BusinessDay(now).Format("2006-01-02")
+
+	var recent recentCustomProducts
+	data, err := os.ReadFile(getRecentCustomProductsPath())
+	if err == nil {
+		if err := json.Unmarshal(data, &recent); err != nil {
+			return recentCustomProducts{}, fmt.Errorf("error parsing recent custom items: %w", err)
+		}
+	} else if !os.IsNotExist(err) {
+		return recentCustomProducts{}, fmt.Errorf("error reading recent custom items: %w", err)
+	}
+
+	if recent.Day != today {
+		recent = recentCustomProducts{Day: today}
+	}
+	return recent, nil
+}
+
+func getRecentCustomProductsPath() string {
+	// Practice sales in demo mode must not show up at the real register
+	if config.Config.DemoMode {
+		return filepath.Join(demoDataDir(), "recent-custom-items.json")
+	}
+	dataDir := config.Config.DataDir
+	if dataDir == "" {
+		dataDir = config.DefaultDataDir
+	}
+	return filepath.Join(dataDir, "recent-custom-items.json")
+}
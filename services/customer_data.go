@@ -0,0 +1,479 @@
+package services
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"checkout/config"
+	"checkout/services/validation"
+	"checkout/templates"
+	"checkout/utils"
+)
+
+// ContactRedactedUpdateType is the payment update recording that a customer's email or phone was
+// replaced by its hash in the receipt and payment update logs. NewValue holds the hash.
+const ContactRedactedUpdateType = "contact_redacted"
+
+// redactedContactPrefix starts the hash that replaces a redacted email or phone
+const redactedContactPrefix = "redacted:"
+
+// customerLogPatterns are the logs holding customer contact details, relative to the
+// transactions directory
+var customerLogPatterns = []string{"receipts/receipts-*.json", "updates/payment-updates-*.json"}
+
+// customerLogMutex serializes appends to the receipt and payment update logs with redaction,
+// which rewrites them
+var customerLogMutex sync.Mutex
+
+// CustomerContact is an email address or phone number a customer gave for receipts
+type CustomerContact struct {
+	Value   string // Email address lowercased, or phone number in E.164
+	IsPhone bool
+	pattern *regexp.Regexp // Finds the contact inside a longer text
+}
+
+// ParseCustomerContact reads an email address or a phone number, which is normalized the way
+// the receipt form normalizes it
+func ParseCustomerContact(text string) (CustomerContact, error) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return CustomerContact{}, fmt.Errorf("enter an email address or phone number")
+	}
+
+	if strings.Contains(text, "@") {
+		email := strings.ToLower(text)
+		return CustomerContact{
+			Value:   email,
+			pattern: regexp.MustCompile(`(?i)(^|[^a-z0-9._%+-])` + regexp.QuoteMeta(email) + `($|[^a-z0-9.-])`),
+		}, nil
+	}
+
+	phone, err := validation.Phone(text, config.Config.PhoneCountry)
+	if err != nil {
+		return CustomerContact{}, fmt.Errorf("%q is neither an email address nor a phone number", text)
+	}
+	return CustomerContact{
+		Value:   phone,
+		IsPhone: true,
+		pattern: regexp.MustCompile(`(^|[^0-9+])` + regexp.QuoteMeta(phone) + `($|[^0-9])`),
+	}, nil
+}
+
+// Redacted returns the hash that replaces the contact in the logs. The same contact always has
+// the same hash, so the records of one customer stay linked after redaction.
+func (c CustomerContact) Redacted() string {
+	sum := sha256.Sum256([]byte(c.Value))
+	return redactedContactPrefix + hex.EncodeToString(sum[:8])
+}
+
+// IsRedactedContact reports whether a logged email or phone was replaced by its hash
+func IsRedactedContact(value string) bool {
+	return strings.HasPrefix(value, redactedContactPrefix)
+}
+
+// ReceiptContact returns a logged email or phone for prefilling a receipt form, or "" if it
+// was redacted
+func ReceiptContact(value string) string {
+	if IsRedactedContact(value) {
+		return ""
+	}
+	return value
+}
+
+// isContact reports whether a whole logged value is the contact, in whatever form it was typed
+func (c CustomerContact) isContact(value string) bool {
+	value = strings.TrimSpace(value)
+	if !c.IsPhone {
+		return strings.EqualFold(value, c.Value)
+	}
+	if value == "" || strings.Contains(value, "@") || IsRedactedContact(value) {
+		return false
+	}
+	phone, err := validation.Phone(value, config.Config.PhoneCountry)
+	return err == nil && phone == c.Value
+}
+
+// mentionedIn reports whether a log line holds the contact, either inside a value or as a whole
+// value typed in another form (a phone number with spaces, say)
+func (c CustomerContact) mentionedIn(line []byte, fields map[string]any) bool {
+	if c.pattern.Match(line) {
+		return true
+	}
+	for _, value := range fields {
+		if text, ok := value.(string); ok && c.isContact(text) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactLine replaces the contact in a log line with its hash. The line is edited as text, so
+// fields the record types don't know about and the order of the fields are kept.
+func (c CustomerContact) redactLine(line []byte, fields map[string]any) []byte {
+	redacted := c.Redacted()
+	for _, value := range fields {
+		text, ok := value.(string)
+		if !ok || text == c.Value || !c.isContact(text) {
+			continue
+		}
+		quoted, err := json.Marshal(text)
+		if err == nil {
+			line = bytes.ReplaceAll(line, quoted, []byte(`"`+redacted+`"`))
+		}
+	}
+	return c.pattern.ReplaceAll(line, []byte("${1}"+redacted+"${2}"))
+}
+
+// customerLogLine is one line of a receipt or payment update log, parsed loosely since the
+// records have changed shape over time
+type customerLogLine struct {
+	log    string // Log name relative to the transactions directory
+	data   []byte
+	fields map[string]any // nil for a line that isn't a JSON object
+}
+
+// paymentID returns the payment the line is about: "id" in a receipt record, "paymentId" in a
+// payment update
+func (l customerLogLine) paymentID() string {
+	for _, key := range []string{"paymentId", "id"} {
+		if id, ok := l.fields[key].(string); ok && id != "" {
+			return id
+		}
+	}
+	return ""
+}
+
+// parseCustomerLogLine parses a log line, keeping the line as text whether or not it parses
+func parseCustomerLogLine(log string, data []byte) customerLogLine {
+	line := customerLogLine{log: log, data: data}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var fields map[string]any
+	if err := decoder.Decode(&fields); err == nil {
+		line.fields = fields
+	}
+	return line
+}
+
+// splitLogLines returns the non-empty lines of a log
+func splitLogLines(data []byte) [][]byte {
+	var lines [][]byte
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		if len(bytes.TrimSpace(line)) > 0 {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// readCustomerLogs returns every line of the receipt and payment update logs, raw or archived,
+// oldest log first
+func readCustomerLogs() ([]customerLogLine, error) {
+	var lines []customerLogLine
+	for _, pattern := range customerLogPatterns {
+		files, err := globTransactionFiles(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("error listing %s: %w", pattern, err)
+		}
+		for _, filename := range files {
+			file, err := openTransactionFile(filename)
+			if err != nil {
+				return nil, fmt.Errorf("error opening %s: %w", filepath.Base(filename), err)
+			}
+			var data bytes.Buffer
+			_, err = data.ReadFrom(file)
+			file.Close()
+			if err != nil {
+				return nil, fmt.Errorf("error reading %s: %w", filepath.Base(filename), err)
+			}
+			log, _, _ := archiveEntryName(filename)
+			for _, line := range splitLogLines(data.Bytes()) {
+				lines = append(lines, parseCustomerLogLine(log, line))
+			}
+		}
+	}
+	return lines, nil
+}
+
+// CustomerRecord is a receipt or payment update record about a customer, as it was logged
+type CustomerRecord struct {
+	Log    string          `json:"log"` // e.g. "receipts/receipts-2024-05-01.json"
+	Record json.RawMessage `json:"record"`
+}
+
+// CustomerDataExport is everything the receipt and payment update logs hold about a customer
+type CustomerDataExport struct {
+	Customer   string           `json:"customer"`
+	ExportedAt time.Time        `json:"exportedAt"`
+	PaymentIDs []string         `json:"paymentIds"`
+	Receipts   []CustomerRecord `json:"receipts"`
+	Updates    []CustomerRecord `json:"updates"`
+}
+
+// ExportCustomerData gathers the receipt and payment update records of a customer across every
+// day, archived months included: the records holding their email or phone, and the other records
+// of the payments those belong to, such as receipt delivery results.
+func ExportCustomerData(contact CustomerContact, now time.Time) (CustomerDataExport, error) {
+	export := CustomerDataExport{Customer: contact.Value, ExportedAt: now}
+
+	lines, err := readCustomerLogs()
+	if err != nil {
+		return export, err
+	}
+
+	paymentIDs := make(map[string]bool)
+	for _, line := range lines {
+		if contact.mentionedIn(line.data, line.fields) {
+			if id := line.paymentID(); id != "" {
+				paymentIDs[id] = true
+			}
+		}
+	}
+
+	for _, line := range lines {
+		id := line.paymentID()
+		if !paymentIDs[id] && !contact.mentionedIn(line.data, line.fields) {
+			continue
+		}
+		record := CustomerRecord{Log: line.log, Record: json.RawMessage(line.data)}
+		if line.fields == nil {
+			// A partially written line is exported as text so the export stays valid JSON
+			quoted, _ := json.Marshal(string(line.data))
+			record.Record = quoted
+		}
+		if strings.HasPrefix(line.log, "receipts/") {
+			export.Receipts = append(export.Receipts, record)
+		} else {
+			export.Updates = append(export.Updates, record)
+		}
+	}
+
+	export.PaymentIDs = sortedKeys(paymentIDs)
+	utils.Debug("services", "Customer records gathered", "contact", contact.Redacted(),
+		"receipts", len(export.Receipts), "updates", len(export.Updates))
+	return export, nil
+}
+
+// RedactionResult is what a redaction changed
+type RedactionResult struct {
+	Hash       string   // What the contact was replaced with
+	Records    int      // Log lines changed
+	Files      int      // Logs and monthly archives rewritten
+	PaymentIDs []string // Payments whose records held the contact
+}
+
+// RedactCustomerData replaces a customer's email or phone with its hash in every receipt and
+// payment update log, archived months included, and records a contact_redacted update for each
+// payment whose records held it. The transaction CSV logs are financial records and are left as
+// they are; loaded transactions show the hash in place of a redacted contact instead.
+func RedactCustomerData(contact CustomerContact, username string) (RedactionResult, error) {
+	result, err := redactCustomerLogs(contact)
+	if err != nil || result.Records == 0 {
+		return result, err
+	}
+
+	notes := "Redacted by " + username
+	for _, paymentID := range result.PaymentIDs {
+		record := CreatePaymentUpdateRecord(paymentID, ContactRedactedUpdateType, "", result.Hash, "contact", "admin_redaction", notes)
+		if err := SavePaymentUpdateRecord(record); err != nil {
+			utils.Error("services", "Error recording contact redaction", "payment_id", paymentID, "error", err)
+		}
+	}
+	utils.Info("audit", "Customer contact redacted", "contact", result.Hash, "records", result.Records,
+		"files", result.Files, "payments", len(result.PaymentIDs), "user", username)
+	return result, nil
+}
+
+// redactCustomerLogs rewrites the logs and archives that hold the contact
+func redactCustomerLogs(contact CustomerContact) (RedactionResult, error) {
+	result := RedactionResult{Hash: contact.Redacted()}
+	paymentIDs := make(map[string]bool)
+
+	// Archives are rewritten as a whole, so archiving must not run at the same time
+	transactionLogMutex.Lock()
+	defer transactionLogMutex.Unlock()
+	customerLogMutex.Lock()
+	defer customerLogMutex.Unlock()
+
+	transactionsDir := getTransactionsDir()
+	for _, pattern := range customerLogPatterns {
+		files, err := filepath.Glob(filepath.Join(transactionsDir, pattern))
+		if err != nil {
+			return result, err
+		}
+		for _, filename := range files {
+			data, err := os.ReadFile(filename)
+			if err != nil {
+				return result, fmt.Errorf("error reading %s: %w", filepath.Base(filename), err)
+			}
+			redacted, changed := redactLog(contact, data, paymentIDs)
+			if changed == 0 {
+				continue
+			}
+			if err := replaceFile(filename, redacted); err != nil {
+				return result, fmt.Errorf("error rewriting %s: %w", filepath.Base(filename), err)
+			}
+			result.Records += changed
+			result.Files++
+		}
+	}
+
+	archives, err := filepath.Glob(filepath.Join(getArchiveDir(), "transactions-*.tar.gz"))
+	if err != nil {
+		return result, err
+	}
+	for _, archivePath := range archives {
+		changed, err := redactArchive(contact, archivePath, paymentIDs)
+		if err != nil {
+			return result, fmt.Errorf("error redacting %s: %w", filepath.Base(archivePath), err)
+		}
+		if changed > 0 {
+			result.Records += changed
+			result.Files++
+		}
+	}
+
+	result.PaymentIDs = sortedKeys(paymentIDs)
+	return result, nil
+}
+
+// redactLog replaces the contact in the lines of a log, adding the payments of the changed
+// lines to paymentIDs, and returns the log and how many lines changed
+func redactLog(contact CustomerContact, data []byte, paymentIDs map[string]bool) ([]byte, int) {
+	changed := 0
+	lines := bytes.SplitAfter(data, []byte("\n"))
+	for i, text := range lines {
+		line := parseCustomerLogLine("", bytes.TrimRight(text, "\n"))
+		if !contact.mentionedIn(line.data, line.fields) {
+			continue
+		}
+		redacted := contact.redactLine(line.data, line.fields)
+		if bytes.Equal(redacted, line.data) {
+			continue
+		}
+		if line.fields != nil && !json.Valid(redacted) {
+			utils.Warn("services", "Redacting a log line would break it, leaving it as it is", "payment_id", line.paymentID())
+			continue
+		}
+		lines[i] = append(redacted, text[len(line.data):]...)
+		if id := line.paymentID(); id != "" {
+			paymentIDs[id] = true
+		}
+		changed++
+	}
+	return bytes.Join(lines, nil), changed
+}
+
+// redactArchive redacts the receipt and payment update logs inside a monthly archive and
+// returns how many lines changed. The archive is replaced only after it is read back intact.
+func redactArchive(contact CustomerContact, archivePath string, paymentIDs map[string]bool) (int, error) {
+	files, err := readArchive(archivePath)
+	if err != nil {
+		return 0, err
+	}
+
+	changed := 0
+	for name, data := range files {
+		if !isCustomerLog(name) {
+			continue
+		}
+		redacted, lines := redactLog(contact, data, paymentIDs)
+		if lines > 0 {
+			files[name] = redacted
+			changed += lines
+		}
+	}
+	if changed == 0 {
+		return 0, nil
+	}
+
+	tmpPath := archivePath + ".tmp"
+	if err := writeArchive(tmpPath, files); err != nil {
+		os.Remove(tmpPath)
+		return 0, err
+	}
+	written, err := readArchive(tmpPath)
+	if err != nil {
+		os.Remove(tmpPath)
+		return 0, fmt.Errorf("error verifying archive: %w", err)
+	}
+	for name, data := range files {
+		if !bytes.Equal(written[name], data) {
+			os.Remove(tmpPath)
+			return 0, fmt.Errorf("archive verification failed for %s", name)
+		}
+	}
+	if err := os.Rename(tmpPath, archivePath); err != nil {
+		os.Remove(tmpPath)
+		return 0, fmt.Errorf("error saving archive: %w", err)
+	}
+	forgetArchiveContents(archivePath)
+	return changed, nil
+}
+
+// isCustomerLog reports whether an archived file is a receipt or payment update log
+func isCustomerLog(name string) bool {
+	for _, pattern := range customerLogPatterns {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// replaceFile replaces a file's contents through a temporary file, so a crash leaves the old
+// contents or the new ones
+func replaceFile(filename string, data []byte) error {
+	tmpPath := filename + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Rename(tmpPath, filename); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
+
+// redactedContacts returns the hashes of the contacts redacted so far
+func redactedContacts(updates []templates.PaymentUpdateRecord) map[string]bool {
+	hashes := make(map[string]bool)
+	for _, record := range updates {
+		if record.UpdateType == ContactRedactedUpdateType {
+			hashes[record.NewValue] = true
+		}
+	}
+	return hashes
+}
+
+// applyContactRedactions shows the hash in place of a redacted email or phone that the
+// transaction log still holds
+func applyContactRedactions(updates []templates.PaymentUpdateRecord, transactions ...*templates.Transaction) {
+	hashes := redactedContacts(updates)
+	if len(hashes) == 0 {
+		return
+	}
+	redact := func(value *string) {
+		if *value == "" || IsRedactedContact(*value) {
+			return
+		}
+		if contact, err := ParseCustomerContact(*value); err == nil && hashes[contact.Redacted()] {
+			*value = contact.Redacted()
+		}
+	}
+	for _, transaction := range transactions {
+		redact(&transaction.StripeCustomerEmail)
+		redact(&transaction.CustomerPhone)
+	}
+}
@@ -0,0 +1,256 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"checkout/config"
+	"checkout/templates"
+)
+
+// customerLogs is a few days of receipt and payment update logs in the shapes they have been
+// written over time, plus an archived month. Bob paid pi_1 and cs_2, Alice pi_3.
+var customerLogs = map[string]string{
+	"receipts/receipts-2026-03-12.json": `{"id":"pi_1","date":"2026-03-12","time":"10:00:00","receiptEmail":"Bob@Example.com","deliveryMethod":"email","deliveryStatus":"sent","retryCount":0}
+{"id":"pi_3","date":"2026-03-12","time":"11:00:00","receiptEmail":"alice@example.com","deliveryMethod":"email","deliveryStatus":"sent","retryCount":0}
+`,
+	// An older shape without delivery fields, a phone typed with punctuation, and a line cut off
+	// when the disk filled up
+	"receipts/receipts-2026-03-13.json": `{"id":"cs_2","date":"2026-03-13","time":"09:30:00","receiptPhone":"(555) 123-4567","deliveryMethod":"sms"}
+{"id":"pi_3","date":"2026-03-13","time":"12:00:00","receiptPhone":"+15559876543","deliveryMethod":"sms","deliveryStatus":"failed","errorMessage":"carrier rejected","retryCount":2}
+{"id":"pi_1","date":"2026-03-13","time":"12:05:00","receiptEmail":"bob@example.com","deliv
+`,
+	"updates/payment-updates-2026-03-13.json": `{"paymentId":"pi_1","updateDate":"2026-03-13","updateTime":"10:01:00","updateType":"receipt_delivery","newValue":"sent","source":"manual_receipt"}
+{"paymentId":"cs_2","updateDate":"2026-03-13","updateTime":"09:31:00","updateType":"customer_info","oldValue":"","newValue":"bob@example.com","fieldName":"email","source":"stripe_webhook","notes":"Collected by Stripe Checkout from bob@example.com"}
+{"paymentId":"pi_3","updateDate":"2026-03-13","updateTime":"12:01:00","updateType":"receipt_delivery","newValue":"failed","source":"manual_receipt","notes":"bounced for alice@example.com"}
+`,
+}
+
+// exportedAt is when the tests export customer data
+var exportedAt = time.Date(2026, time.March, 20, 9, 0, 0, 0, time.UTC)
+
+// archivedCustomerLogs is February, already archived
+var archivedCustomerLogs = map[string]string{
+	"receipts/receipts-2026-02-20.json": `{"id":"pi_old","date":"2026-02-20","time":"15:00:00","receiptEmail":"bob@example.com","deliveryMethod":"email","deliveryStatus":"sent","retryCount":0}
+`,
+	"updates/payment-updates-2026-02-20.json": `{"paymentId":"pi_old","updateDate":"2026-02-20","updateTime":"15:00:01","updateType":"receipt_delivery","newValue":"sent","source":"manual_receipt"}
+`,
+}
+
+func writeCustomerLogs(t *testing.T) {
+	t.Helper()
+	useTempDataDir(t)
+	for name, content := range customerLogs {
+		filename := filepath.Join(config.Config.TransactionsDir, name)
+		if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filename, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.MkdirAll(getArchiveDir(), 0755); err != nil {
+		t.Fatal(err)
+	}
+	archived := make(map[string][]byte)
+	for name, content := range archivedCustomerLogs {
+		archived[name] = []byte(content)
+	}
+	if err := writeArchive(getArchivePath("2026-02"), archived); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// recordIDs returns the payment of each exported record, in order
+func recordIDs(t *testing.T, records []CustomerRecord) []string {
+	t.Helper()
+	var ids []string
+	for _, record := range records {
+		var fields map[string]any
+		if err := json.Unmarshal(record.Record, &fields); err != nil {
+			ids = append(ids, "partial") // A cut-off line, exported as text
+			continue
+		}
+		id, _ := fields["id"].(string)
+		if paymentID, ok := fields["paymentId"].(string); ok {
+			id = paymentID
+		}
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func TestExportCustomerData(t *testing.T) {
+	tests := []struct {
+		name         string
+		contact      string
+		wantPayments []string
+		wantReceipts []string
+		wantUpdates  []string
+	}{
+		{"email in any case", "BOB@example.com", []string{"cs_2", "pi_1", "pi_old"}, []string{"cs_2", "partial", "pi_1", "pi_old"}, []string{"cs_2", "pi_1", "pi_old"}},
+		{"phone typed another way", "555.123.4567", []string{"cs_2"}, []string{"cs_2"}, []string{"cs_2"}},
+		{"email only mentioned in a note", "alice@example.com", []string{"pi_3"}, []string{"pi_3", "pi_3"}, []string{"pi_3"}},
+		{"unknown customer", "carol@example.com", nil, nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			writeCustomerLogs(t)
+			contact, err := ParseCustomerContact(tt.contact)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			export, err := ExportCustomerData(contact, exportedAt)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := strings.Join(export.PaymentIDs, ","); got != strings.Join(tt.wantPayments, ",") {
+				t.Errorf("payments = %v, want %v", export.PaymentIDs, tt.wantPayments)
+			}
+			if got := recordIDs(t, export.Receipts); strings.Join(got, ",") != strings.Join(tt.wantReceipts, ",") {
+				t.Errorf("receipts = %v, want %v", got, tt.wantReceipts)
+			}
+			if got := recordIDs(t, export.Updates); strings.Join(got, ",") != strings.Join(tt.wantUpdates, ",") {
+				t.Errorf("updates = %v, want %v", got, tt.wantUpdates)
+			}
+			if _, err := json.Marshal(export); err != nil {
+				t.Errorf("export isn't valid JSON: %v", err)
+			}
+		})
+	}
+}
+
+// readAllCustomerLogs returns the text of every receipt and payment update log, archived or not
+func readAllCustomerLogs(t *testing.T) string {
+	t.Helper()
+	lines, err := readCustomerLogs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var all bytes.Buffer
+	for _, line := range lines {
+		all.Write(line.data)
+		all.WriteByte('\n')
+	}
+	return all.String()
+}
+
+func TestRedactCustomerData(t *testing.T) {
+	writeCustomerLogs(t)
+	bob, _ := ParseCustomerContact("bob@example.com")
+
+	result, err := RedactCustomerData(bob, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Hash != bob.Redacted() || !IsRedactedContact(result.Hash) {
+		t.Errorf("hash = %q, want %q", result.Hash, bob.Redacted())
+	}
+	if got := strings.Join(result.PaymentIDs, ","); got != "cs_2,pi_1,pi_old" {
+		t.Errorf("payments = %s, want cs_2,pi_1,pi_old", got)
+	}
+	// pi_1's receipts, cs_2's update and the archived receipt
+	if result.Records != 4 || result.Files != 4 {
+		t.Errorf("changed %d records in %d files, want 4 in 3", result.Records, result.Files)
+	}
+
+	logs := readAllCustomerLogs(t)
+	if strings.Contains(strings.ToLower(logs), "bob@example.com") {
+		t.Errorf("bob's email is still logged:\n%s", logs)
+	}
+	if strings.Count(logs, bob.Redacted()) < 4 {
+		t.Errorf("hash logged %d times, want it in place of each email:\n%s", strings.Count(logs, bob.Redacted()), logs)
+	}
+	for _, kept := range []string{"alice@example.com", "+15559876543", "(555) 123-4567"} {
+		if !strings.Contains(logs, kept) {
+			t.Errorf("redaction changed %s, which isn't bob's email", kept)
+		}
+	}
+	// The cut-off line stays the only one that isn't JSON
+	var broken []string
+	for _, line := range strings.Split(strings.TrimSpace(logs), "\n") {
+		if !json.Valid([]byte(line)) {
+			broken = append(broken, line)
+		}
+	}
+	if len(broken) != 1 || !strings.HasSuffix(broken[0], `"deliv`) {
+		t.Errorf("lines that aren't JSON after redaction = %q, want the cut-off receipt", broken)
+	}
+
+	// The payment update reader takes the rewritten logs and the audit records
+	updates, err := loadPaymentUpdates()
+	if err != nil {
+		t.Fatal(err)
+	}
+	audited := make(map[string]bool)
+	for _, update := range updates {
+		if update.UpdateType == ContactRedactedUpdateType {
+			audited[update.PaymentID] = update.NewValue == bob.Redacted() && update.Notes == "Redacted by alice"
+		}
+	}
+	for _, id := range []string{"cs_2", "pi_1", "pi_old"} {
+		if !audited[id] {
+			t.Errorf("no redaction record for %s in %v", id, audited)
+		}
+	}
+
+	// Redacting again finds nothing left to change
+	again, err := RedactCustomerData(bob, "alice")
+	if err != nil || again.Records != 0 {
+		t.Errorf("second redaction = %+v, %v, want nothing changed", again, err)
+	}
+}
+
+func TestRedactedContactShownInTransactions(t *testing.T) {
+	useTempDataDir(t)
+	transaction := templates.Transaction{
+		ID:                  "plink_1",
+		Date:                "2026-03-13",
+		Time:                "09:00:00",
+		Products:            []templates.Product{{Name: "Coffee", Price: 4.50}},
+		Subtotal:            4.50,
+		Total:               4.50,
+		PaymentType:         "qr",
+		StripeCustomerEmail: "bob@example.com",
+	}
+	day := time.Date(2026, time.March, 13, 0, 0, 0, 0, time.Local)
+	if err := saveTransactionToLog(getTransactionsDir(), day, transaction); err != nil {
+		t.Fatal(err)
+	}
+	if err := SaveReceiptRecord(templates.ReceiptRecord{ID: "plink_1", ReceiptEmail: "bob@example.com", DeliveryMethod: "email"}); err != nil {
+		t.Fatal(err)
+	}
+	bob, _ := ParseCustomerContact("bob@example.com")
+
+	if _, err := RedactCustomerData(bob, "alice"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The CSV log keeps the email; loading it shows the hash
+	byID, err := LoadTransactionByID("plink_1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	forDay, err := LoadTransactionsForDay(day)
+	if err != nil || len(forDay) != 1 {
+		t.Fatalf("transactions for the day = %v, %v, want plink_1", forDay, err)
+	}
+	for name, loaded := range map[string]*templates.Transaction{"by id": byID, "for the day": &forDay[0]} {
+		if loaded.StripeCustomerEmail != bob.Redacted() {
+			t.Errorf("%s: email = %q, want the hash %q", name, loaded.StripeCustomerEmail, bob.Redacted())
+		}
+		if got := ReceiptContact(loaded.StripeCustomerEmail); got != "" {
+			t.Errorf("%s: receipt form prefilled with %q, want blank", name, got)
+		}
+	}
+}
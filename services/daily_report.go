@@ -0,0 +1,178 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"checkout/config"
+	"checkout/utils"
+)
+
+// Daily report scheduling
+const (
+	dailyReportCheckInterval = time.Minute
+	dailyReportRetryDelay    = 5 * time.Minute
+	dailyReportMaxAttempts   = 3
+)
+
+// dailyReportState tracks scheduled sends. LastSentDate is persisted so a restart
+// around the scheduled time doesn't send the same day's report twice.
+var dailyReportState = struct {
+	LastSentDate string    `json:"lastSentDate"`
+	attemptDate  string    // Day the failed attempts below belong to
+	attempts     int       // Failed scheduled attempts for attemptDate
+	nextAttempt  time.Time // Earliest time to retry after a failure
+	mutex        sync.Mutex
+}{}
+
+// StartDailyReportScheduler starts the background job that emails the daily report
+// at the configured time in the business timezone.
+func StartDailyReportScheduler() {
+	dailyReportState.mutex.Lock()
+	if err := loadDailyReportState(); err != nil {
+		utils.Error("report", "Error loading daily report state", "error", err)
+	}
+	dailyReportState.mutex.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(dailyReportCheckInterval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			checkDailyReportSchedule(time.Now())
+		}
+	}()
+
+	utils.Info("report", "Daily report scheduler started", "send_time", config.Config.DailyReportTime, "timezone", config.GetBusinessLocation().String())
+}
+
+// checkDailyReportSchedule sends today's report once the scheduled time has passed
+func checkDailyReportSchedule(now time.Time) {
+	if config.Config.DailyReportTime == "" || len(config.GetDailyReportRecipients()) == 0 {
+		return
+	}
+
+	scheduled, err := time.Parse("15:04", config.Config.DailyReportTime)
+	if err != nil {
+		utils.Warn("report", "Invalid daily report time, expected HH:MM", "value", config.Config.DailyReportTime)
+		return
+	}
+
+	local := now.In(config.GetBusinessLocation())
+	sendAt := time.Date(local.Year(), local.Month(), local.Day(), scheduled.Hour(), scheduled.Minute(), 0, 0, local.Location())
+	if local.Before(sendAt) {
+		return
+	}
+	today := local.Format("2006-01-02")
+
+	dailyReportState.mutex.Lock()
+	defer dailyReportState.mutex.Unlock()
+
+	if dailyReportState.LastSentDate == today {
+		return
+	}
+	if dailyReportState.attemptDate != today {
+		dailyReportState.attemptDate = today
+		dailyReportState.attempts = 0
+		dailyReportState.nextAttempt = time.Time{}
+	}
+	if dailyReportState.attempts >= dailyReportMaxAttempts || now.Before(dailyReportState.nextAttempt) {
+		return
+	}
+
+	// The report covers the business day open at the send time, which is still the day before
+	// when the report goes out after midnight but before the business day ends
+	if err := SendDailyReport(config.BusinessDay(sendAt)); err != nil {
+		dailyReportState.attempts++
+		dailyReportState.nextAttempt = now.Add(dailyReportRetryDelay)
+		if dailyReportState.attempts >= dailyReportMaxAttempts {
+			utils.Error("report", "Daily report failed, giving up for today", "date", today, "attempts", dailyReportState.attempts, "error", err)
+		} else {
+			utils.Error("report", "Daily report failed, will retry", "date", today, "attempt", dailyReportState.attempts, "retry_at", dailyReportState.nextAttempt, "error", err)
+		}
+		return
+	}
+
+	dailyReportState.LastSentDate = today
+	if err := saveDailyReportState(); err != nil {
+		utils.Error("report", "Error saving daily report state", "date", today, "error", err)
+	}
+}
+
+// SendDailyReport emails the summary and transaction log for the given day to the report recipients
+func SendDailyReport(day time.Time) error {
+	recipients := config.GetDailyReportRecipients()
+	if len(recipients) == 0 {
+		return fmt.Errorf("no daily report recipients configured")
+	}
+
+	summary, err := BuildDailySummary(day)
+	if err != nil {
+		return err
+	}
+
+	var attachments []EmailAttachment
+	logPath := TransactionLogPath(day)
+	if data, err := os.ReadFile(logPath); err == nil {
+		attachments = append(attachments, EmailAttachment{
+			Filename:    filepath.Base(logPath),
+			ContentType: "text/csv",
+			Data:        data,
+		})
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("error reading transaction log: %w", err)
+	}
+
+	subject := fmt.Sprintf("%s daily report - %s", config.Config.BusinessName, summary.Date)
+	if err := SendEmail(recipients, subject, FormatDailySummary(summary), attachments); err != nil {
+		return err
+	}
+
+	utils.Info("report", "Daily report sent", "date", summary.Date, "recipients", len(recipients), "total", summary.Total)
+	return nil
+}
+
+// loadDailyReportState reads the last sent date from the data directory
+func loadDailyReportState() error {
+	data, err := os.ReadFile(getDailyReportStateFilePath())
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("error reading daily report state file: %w", err)
+	}
+
+	if err := json.Unmarshal(data, &dailyReportState); err != nil {
+		return fmt.Errorf("error parsing daily report state file: %w", err)
+	}
+	return nil
+}
+
+// saveDailyReportState writes the last sent date to the data directory
+func saveDailyReportState() error {
+	jsonData, err := json.Marshal(map[string]string{"lastSentDate": dailyReportState.LastSentDate})
+	if err != nil {
+		return fmt.Errorf("error marshaling daily report state: %w", err)
+	}
+
+	path := getDailyReportStateFilePath()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("error creating data directory: %w", err)
+	}
+
+	if err := os.WriteFile(path, jsonData, 0644); err != nil {
+		return fmt.Errorf("error writing daily report state file: %w", err)
+	}
+	return nil
+}
+
+func getDailyReportStateFilePath() string {
+	dataDir := config.Config.DataDir
+	if dataDir == "" {
+		dataDir = config.DefaultDataDir
+	}
+	return filepath.Join(dataDir, "daily-report.json")
+}
@@ -0,0 +1,355 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"checkout/config"
+	"checkout/templates"
+	"checkout/utils"
+)
+
+// maxReportedRows caps how many bad rows or lines are listed for one file
+const maxReportedRows = 10
+
+// DataProblem is a missing or damaged file found by CheckData
+type DataProblem struct {
+	Path    string
+	Problem string
+}
+
+// DataDirs returns the data directory and the transactions directory in use, outside demo mode
+func DataDirs() (string, string) {
+	dataDir := config.Config.DataDir
+	if dataDir == "" {
+		dataDir = config.DefaultDataDir
+	}
+	transactionsDir := config.Config.TransactionsDir
+	if transactionsDir == "" {
+		transactionsDir = config.DefaultTransactionsDir
+	}
+	return dataDir, transactionsDir
+}
+
+// CheckData checks the data and transactions directories: that they exist, that products.json
+// and the other JSON files parse, and that every row of the CSV logs has as many columns as
+// its header. Files the POS creates on first use may be missing.
+func CheckData() []DataProblem {
+	dataDir, transactionsDir := DataDirs()
+	return checkDataDirs(dataDir, transactionsDir)
+}
+
+func checkDataDirs(dataDir, transactionsDir string) []DataProblem {
+	var problems []DataProblem
+	add := func(path, format string, args ...any) {
+		problems = append(problems, DataProblem{Path: path, Problem: fmt.Sprintf(format, args...)})
+	}
+
+	for _, dir := range []string{dataDir, transactionsDir} {
+		if info, err := os.Stat(dir); err != nil {
+			add(dir, "directory is missing or unreadable: %v", err)
+		} else if !info.IsDir() {
+			add(dir, "is not a directory")
+		}
+	}
+	if len(problems) > 0 {
+		return problems
+	}
+
+	productsPath := filepath.Join(dataDir, "products.json")
+	if data, err := os.ReadFile(productsPath); errors.Is(err, fs.ErrNotExist) {
+		add(productsPath, "missing; the sample catalog will be written at startup")
+	} else if err != nil {
+		add(productsPath, "unreadable: %v", err)
+	} else {
+		var products []templates.Product
+		if err := json.Unmarshal(data, &products); err != nil {
+			add(productsPath, "not a valid product list: %v", err)
+		} else if err := ValidateProductSKUs(products); err != nil {
+			add(productsPath, "%v", err)
+		}
+	}
+
+	// Whole-file JSON documents
+	documents := []string{
+		filepath.Join(dataDir, "gift-cards.json"),
+		filepath.Join(dataDir, "duplicate-payments.json"),
+		filepath.Join(dataDir, "sent-links.json"),
+		filepath.Join(dataDir, "order-number.json"),
+		filepath.Join(dataDir, "webhook-events.json"),
+		filepath.Join(dataDir, "daily-report.json"),
+	}
+	reports, _ := filepath.Glob(filepath.Join(dataDir, "reports", "*.json"))
+	for _, path := range append(documents, reports...) {
+		data, err := os.ReadFile(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			add(path, "unreadable: %v", err)
+		} else if !json.Valid(data) {
+			add(path, "not valid JSON")
+		}
+	}
+
+	// Append-only logs with one JSON record per line
+	receipts, _ := filepath.Glob(filepath.Join(transactionsDir, "receipts", "*.json"))
+	updates, _ := filepath.Glob(filepath.Join(transactionsDir, "updates", "*.json"))
+	for _, path := range append(receipts, updates...) {
+		if problem := checkJSONLines(path); problem != "" {
+			add(path, "%s", problem)
+		}
+	}
+
+	logs, _ := filepath.Glob(filepath.Join(transactionsDir, "*.csv"))
+	for _, path := range logs {
+		header, problem := checkCSVColumns(path)
+		if problem != "" {
+			add(path, "%s", problem)
+		} else if header != nil && !transactionHeaderIsCurrent(header) {
+			add(path, "older column layout; run with -migrate-transactions to upgrade it")
+		}
+	}
+	archives, _ := filepath.Glob(filepath.Join(dataDir, "archive", "*.tar.gz"))
+	for _, path := range archives {
+		if _, err := readArchive(path); err != nil {
+			add(path, "archive can't be read: %v", err)
+		}
+	}
+	ledgerPath := filepath.Join(dataDir, "gift-card-ledger.csv")
+	if _, err := os.Stat(ledgerPath); err == nil {
+		if _, problem := checkCSVColumns(ledgerPath); problem != "" {
+			add(ledgerPath, "%s", problem)
+		}
+	}
+
+	return problems
+}
+
+// checkCSVColumns returns a CSV file's header, and a problem when it can't be parsed or a row
+// has a different number of columns than the header
+func checkCSVColumns(path string) ([]string, string) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Sprintf("unreadable: %v", err)
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Sprintf("not valid CSV: %v", err)
+	}
+	if len(records) == 0 {
+		return nil, ""
+	}
+
+	header := records[0]
+	var bad []string
+	for i, record := range records[1:] {
+		if len(record) != len(header) {
+			bad = append(bad, fmt.Sprintf("row %d has %d", i+2, len(record)))
+		}
+	}
+	if len(bad) == 0 {
+		return header, ""
+	}
+	return header, fmt.Sprintf("%d rows have the wrong number of columns (header has %d): %s", len(bad), len(header), listFirst(bad))
+}
+
+// checkJSONLines returns a problem when a line of a JSON-lines log isn't valid JSON
+func checkJSONLines(path string) string {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Sprintf("unreadable: %v", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	var bad []string
+	for line := 1; scanner.Scan(); line++ {
+		text := bytes.TrimSpace(scanner.Bytes())
+		if len(text) > 0 && !json.Valid(text) {
+			bad = append(bad, fmt.Sprintf("line %d", line))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Sprintf("unreadable: %v", err)
+	}
+	if len(bad) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%d lines are not valid JSON: %s", len(bad), listFirst(bad))
+}
+
+// listFirst joins the first few entries of a list, noting how many more there are
+func listFirst(entries []string) string {
+	if len(entries) <= maxReportedRows {
+		return strings.Join(entries, ", ")
+	}
+	return fmt.Sprintf("%s and %d more", strings.Join(entries[:maxReportedRows], ", "), len(entries)-maxReportedRows)
+}
+
+// MigrateData copies the data directory, and the transactions directory when it is elsewhere, to
+// newDataDir, checks each copy against the original's SHA-256 checksum, and only then points the
+// configuration at the copies. The old directories are left untouched. The POS must be stopped
+// while it runs, so nothing is written halfway through. Returns how many files were copied.
+func MigrateData(newDataDir string) (int, error) {
+	dataDir, transactionsDir := DataDirs()
+	newDataDir = filepath.Clean(strings.TrimSpace(newDataDir))
+	if newDataDir == "." || newDataDir == "" {
+		return 0, errors.New("a new data directory is required")
+	}
+
+	for _, dir := range []string{dataDir, transactionsDir} {
+		if _, inside := subdirectory(dir, newDataDir); inside {
+			return 0, fmt.Errorf("%s is the current directory %s or inside it", newDataDir, dir)
+		}
+	}
+	if entries, err := os.ReadDir(newDataDir); err == nil && len(entries) > 0 {
+		return 0, fmt.Errorf("%s is not empty", newDataDir)
+	} else if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return 0, fmt.Errorf("error reading %s: %w", newDataDir, err)
+	}
+
+	// Transactions kept under the data directory keep their place under the new one
+	newTransactionsDir := filepath.Join(newDataDir, "transactions")
+	rel, transactionsInside := subdirectory(dataDir, transactionsDir)
+	if transactionsInside {
+		newTransactionsDir = filepath.Join(newDataDir, rel)
+	}
+
+	copied, err := copyVerifiedTree(dataDir, newDataDir)
+	if err != nil {
+		return copied, err
+	}
+	if !transactionsInside {
+		n, err := copyVerifiedTree(transactionsDir, newTransactionsDir)
+		copied += n
+		if err != nil {
+			return copied, err
+		}
+	}
+	if err := os.MkdirAll(newTransactionsDir, 0755); err != nil {
+		return copied, fmt.Errorf("error creating %s: %w", newTransactionsDir, err)
+	}
+
+	if err := config.SetDataDirs(newDataDir, newTransactionsDir); err != nil {
+		return copied, fmt.Errorf("data copied and verified, but the configuration could not be updated: %w", err)
+	}
+	utils.Info("services", "Data directories migrated", "from", dataDir, "to", newDataDir, "transactions_dir", newTransactionsDir, "files", copied)
+	return copied, nil
+}
+
+// subdirectory returns path relative to dir when path is dir itself or inside it
+func subdirectory(dir, path string) (string, bool) {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", false
+	}
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return "", false
+	}
+	rel, err := filepath.Rel(absDir, absPath)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return rel, true
+}
+
+// copyVerifiedTree copies every regular file under src to the same place under dst. The
+// configuration file is skipped: it is always read from the default data directory.
+func copyVerifiedTree(src, dst string) (int, error) {
+	copied := 0
+	err := filepath.WalkDir(src, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+
+		if entry.IsDir() {
+			info, err := entry.Info()
+			if err != nil {
+				return err
+			}
+			return os.MkdirAll(target, info.Mode().Perm())
+		}
+		if rel == "config.json" || rel == "config.json.tmp" {
+			return nil
+		}
+		if !entry.Type().IsRegular() {
+			utils.Warn("services", "Skipping file that isn't a regular file", "path", path)
+			return nil
+		}
+		if err := copyVerifiedFile(path, target); err != nil {
+			return err
+		}
+		copied++
+		return nil
+	})
+	if err != nil {
+		return copied, fmt.Errorf("error copying %s to %s: %w", src, dst, err)
+	}
+	return copied, nil
+}
+
+// copyVerifiedFile copies a file, then reads the copy back and compares SHA-256 checksums
+func copyVerifiedFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	source := sha256.New()
+	if _, err := io.Copy(out, io.TeeReader(in, source)); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+
+	check, err := os.Open(dst)
+	if err != nil {
+		return err
+	}
+	defer check.Close()
+	written := sha256.New()
+	if _, err := io.Copy(written, check); err != nil {
+		return err
+	}
+	if !bytes.Equal(source.Sum(nil), written.Sum(nil)) {
+		return fmt.Errorf("checksum of %s does not match %s", dst, src)
+	}
+	return nil
+}
@@ -0,0 +1,1134 @@
+package services
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/stripe/stripe-go/v74"
+
+	"checkout/config"
+	"checkout/templates"
+	"checkout/utils"
+)
+
+// Demo mode simulates Stripe so staff can practise the whole checkout flow without charging a
+// card. Payments still go through the payment state manager, polling and SSE; only the Stripe
+// calls are answered in memory.
+const (
+	DemoReaderDelay  = 4 * time.Second  // How long the simulated reader takes to approve or decline
+	DemoQRDelay      = 10 * time.Second // How long a simulated QR code takes to be "paid"
+	DemoApproveCard  = "4242424242424242"
+	DemoDeclineCard  = "4000000000000002"
+	demoReaderID     = "tmr_demo_reader"
+	demoLocationID   = "tml_demo_location"
+	demoDeclineMsg   = "Your card was declined."
+	demoApprovePMID  = "pm_demo_visa"
+	demoDeclinePMID  = "pm_demo_declined"
+	demoPaymentLink  = "https://example.com/demo-payment/"
+	demoDirName      = "demo"
+	demoCardLastFour = "4242"
+)
+
+// IsDemoID reports whether a Stripe ID was made up by demo mode
+func IsDemoID(id string) bool {
+	return strings.Contains(id, "_demo_")
+}
+
+// SetDemoDecline chooses whether the simulated reader declines the payments it is sent
+func SetDemoDecline(decline bool) {
+	demoStripe.mutex.Lock()
+	demoStripe.decline = decline
+	demoStripe.mutex.Unlock()
+	UI.SetDemoDeclines(decline)
+	utils.Info("demo", "Simulated reader outcome changed", "decline", decline)
+}
+
+// DemoPaymentMethod returns the simulated payment method for a card number typed into the
+// manual entry form in demo mode. Only the two demo card numbers are accepted.
+func DemoPaymentMethod(cardNumber string) (string, bool) {
+	switch strings.NewReplacer(" ", "", "-", "").Replace(cardNumber) {
+	case DemoApproveCard:
+		return demoApprovePMID, true
+	case DemoDeclineCard:
+		return demoDeclinePMID, true
+	default:
+		return "", false
+	}
+}
+
+// ResetDemoStripe forgets every simulated payment, link and refund
+func ResetDemoStripe() {
+	demoStripe.mutex.Lock()
+	defer demoStripe.mutex.Unlock()
+	demoStripe.intents = make(map[string]*demoIntent)
+	demoStripe.links = make(map[string]*demoLink)
+	demoStripe.prices = make(map[string]*stripe.Price)
+	demoStripe.untaxed = make(map[string]bool)
+	demoStripe.reader = ""
+	demoStripe.sessions = nil
+	demoStripe.sessionLines = make(map[string][]*stripe.LineItem)
+	demoStripe.calculations = make(map[string][]*stripe.TaxCalculationLineItem)
+	demoStripe.refunds = make(map[string]*stripe.Refund)
+	demoStripe.balances = make(map[string]*stripe.BalanceTransaction)
+	demoStripe.locations = demoLocations()
+}
+
+// demoDataDir holds the transaction logs and reports written in demo mode, so practice sales
+// never reach the real books
+func demoDataDir() string {
+	return demoDataDirOf(&config.Config)
+}
+
+// demoDataDirOf returns the demo data directory under the given configuration
+func demoDataDirOf(cfg *templates.AppConfig) string {
+	dataDir := cfg.DataDir
+	if dataDir == "" {
+		dataDir = config.DefaultDataDir
+	}
+	return filepath.Join(dataDir, demoDirName)
+}
+
+// prepareDemoDataDir creates the demo transactions directory
+func prepareDemoDataDir() error {
+	if err := os.MkdirAll(getTransactionsDir(), 0755); err != nil {
+		return fmt.Errorf("error creating demo transactions directory: %w", err)
+	}
+	return nil
+}
+
+// demoStripe answers Stripe calls while demo mode is on
+var demoStripe = newDemoStripeClient()
+
+// demoModeClient sends Stripe calls to the live client, or to the demo client while demo mode is on
+type demoModeClient struct {
+	live StripeClient
+	demo *demoStripeClient
+}
+
+func (c demoModeClient) current() StripeClient {
+	if config.Config.DemoMode {
+		return c.demo
+	}
+	return c.live
+}
+
+func (c demoModeClient) CreatePaymentIntent(params *stripe.PaymentIntentParams) (*stripe.PaymentIntent, error) {
+	return c.current().CreatePaymentIntent(params)
+}
+
+func (c demoModeClient) ConfirmPaymentIntent(intentID string, params *stripe.PaymentIntentConfirmParams) (*stripe.PaymentIntent, error) {
+	return c.current().ConfirmPaymentIntent(intentID, params)
+}
+
+func (c demoModeClient) GetPaymentIntent(intentID string) (*stripe.PaymentIntent, error) {
+	return c.current().GetPaymentIntent(intentID)
+}
+
+func (c demoModeClient) UpdatePaymentIntent(intentID string, params *stripe.PaymentIntentParams) (*stripe.PaymentIntent, error) {
+	return c.current().UpdatePaymentIntent(intentID, params)
+}
+
+func (c demoModeClient) CancelPaymentIntent(intentID string) (*stripe.PaymentIntent, error) {
+	return c.current().CancelPaymentIntent(intentID)
+}
+
+func (c demoModeClient) ListPaymentIntents(params *stripe.PaymentIntentListParams) ([]*stripe.PaymentIntent, error) {
+	return c.current().ListPaymentIntents(params)
+}
+
+func (c demoModeClient) CreateRefund(params *stripe.RefundParams) (*stripe.Refund, error) {
+	return c.current().CreateRefund(params)
+}
+
+func (c demoModeClient) GetRefund(refundID string) (*stripe.Refund, error) {
+	return c.current().GetRefund(refundID)
+}
+
+func (c demoModeClient) GetCharge(chargeID string) (*stripe.Charge, error) {
+	return c.current().GetCharge(chargeID)
+}
+
+func (c demoModeClient) ProcessReaderPayment(readerID string, params *stripe.TerminalReaderProcessPaymentIntentParams) (*stripe.TerminalReader, error) {
+	return c.current().ProcessReaderPayment(readerID, params)
+}
+
+func (c demoModeClient) GetReader(readerID string) (*stripe.TerminalReader, error) {
+	return c.current().GetReader(readerID)
+}
+
+func (c demoModeClient) CancelReaderAction(readerID string) (*stripe.TerminalReader, error) {
+	return c.current().CancelReaderAction(readerID)
+}
+
+func (c demoModeClient) SetReaderDisplay(readerID string, params *stripe.TerminalReaderSetReaderDisplayParams) (*stripe.TerminalReader, error) {
+	return c.current().SetReaderDisplay(readerID, params)
+}
+
+func (c demoModeClient) ListReaders(params *stripe.TerminalReaderListParams) ([]*stripe.TerminalReader, error) {
+	return c.current().ListReaders(params)
+}
+
+func (c demoModeClient) ListLocations(params *stripe.TerminalLocationListParams) ([]*stripe.TerminalLocation, error) {
+	return c.current().ListLocations(params)
+}
+
+func (c demoModeClient) CreateLocation(params *stripe.TerminalLocationParams) (*stripe.TerminalLocation, error) {
+	return c.current().CreateLocation(params)
+}
+
+func (c demoModeClient) UpdateLocation(locationID string, params *stripe.TerminalLocationParams) (*stripe.TerminalLocation, error) {
+	return c.current().UpdateLocation(locationID, params)
+}
+
+func (c demoModeClient) DeleteLocation(locationID string) (*stripe.TerminalLocation, error) {
+	return c.current().DeleteLocation(locationID)
+}
+
+func (c demoModeClient) CollectReaderInputs(readerID string, params *stripe.Params) (*TerminalReaderInputs, error) {
+	return c.current().CollectReaderInputs(readerID, params)
+}
+
+func (c demoModeClient) GetReaderInputs(readerID string) (*TerminalReaderInputs, error) {
+	return c.current().GetReaderInputs(readerID)
+}
+
+func (c demoModeClient) CreatePaymentLink(params *stripe.PaymentLinkParams) (*stripe.PaymentLink, error) {
+	return c.current().CreatePaymentLink(params)
+}
+
+func (c demoModeClient) GetPaymentLink(paymentLinkID string) (*stripe.PaymentLink, error) {
+	return c.current().GetPaymentLink(paymentLinkID)
+}
+
+func (c demoModeClient) DeactivatePaymentLink(paymentLinkID string) (*stripe.PaymentLink, error) {
+	return c.current().DeactivatePaymentLink(paymentLinkID)
+}
+
+func (c demoModeClient) ListPaymentLinks(params *stripe.PaymentLinkListParams) ([]*stripe.PaymentLink, error) {
+	return c.current().ListPaymentLinks(params)
+}
+
+func (c demoModeClient) ListCheckoutSessions(params *stripe.CheckoutSessionListParams) ([]*stripe.CheckoutSession, error) {
+	return c.current().ListCheckoutSessions(params)
+}
+
+func (c demoModeClient) ListCheckoutSessionLineItems(sessionID string) ([]*stripe.LineItem, error) {
+	return c.current().ListCheckoutSessionLineItems(sessionID)
+}
+
+func (c demoModeClient) CreateTaxCalculation(params *stripe.TaxCalculationParams) (*stripe.TaxCalculation, error) {
+	return c.current().CreateTaxCalculation(params)
+}
+
+func (c demoModeClient) ListTaxCalculationLineItems(calculationID string) ([]*stripe.TaxCalculationLineItem, error) {
+	return c.current().ListTaxCalculationLineItems(calculationID)
+}
+
+func (c demoModeClient) CreateTaxTransaction(params *stripe.TaxTransactionCreateFromCalculationParams) (*stripe.TaxTransaction, error) {
+	return c.current().CreateTaxTransaction(params)
+}
+
+func (c demoModeClient) UpdateCustomer(customerID string, params *stripe.CustomerParams) (*stripe.Customer, error) {
+	return c.current().UpdateCustomer(customerID, params)
+}
+
+func (c demoModeClient) GetProduct(productID string) (*stripe.Product, error) {
+	return c.current().GetProduct(productID)
+}
+
+func (c demoModeClient) CreateProduct(params *stripe.ProductParams) (*stripe.Product, error) {
+	return c.current().CreateProduct(params)
+}
+
+func (c demoModeClient) GetPrice(priceID string) (*stripe.Price, error) {
+	return c.current().GetPrice(priceID)
+}
+
+func (c demoModeClient) CreatePrice(params *stripe.PriceParams) (*stripe.Price, error) {
+	return c.current().CreatePrice(params)
+}
+
+func (c demoModeClient) UpdatePrice(priceID string, params *stripe.PriceParams) (*stripe.Price, error) {
+	return c.current().UpdatePrice(priceID, params)
+}
+
+func (c demoModeClient) ListPrices(params *stripe.PriceListParams) ([]*stripe.Price, error) {
+	return c.current().ListPrices(params)
+}
+
+func (c demoModeClient) GetBalance() (*stripe.Balance, error) {
+	return c.current().GetBalance()
+}
+
+func (c demoModeClient) GetBalanceTransaction(transactionID string) (*stripe.BalanceTransaction, error) {
+	return c.current().GetBalanceTransaction(transactionID)
+}
+
+func (c demoModeClient) GetAccount() (*stripe.Account, error) {
+	return c.current().GetAccount()
+}
+
+// demoStripeClient implements StripeClient in memory. The reader approves (or, when told to,
+// declines) a payment DemoReaderDelay after it is sent, and a payment link is paid DemoQRDelay
+// after it is created.
+type demoStripeClient struct {
+	mutex    sync.Mutex
+	nextID   int
+	decline  bool                     // The reader declines the payments it is sent
+	intents  map[string]*demoIntent   // By PaymentIntent ID
+	links    map[string]*demoLink     // By payment link ID
+	prices   map[string]*stripe.Price // By price ID
+	untaxed  map[string]bool          // Prices of products with the non-taxable tax code
+	reader   string                   // PaymentIntent on the reader, if any
+	sessions []*stripe.CheckoutSession
+	refunds  map[string]*stripe.Refund             // By refund ID
+	balances map[string]*stripe.BalanceTransaction // Fees of charges and refunds by balance transaction ID
+
+	locations map[string]*stripe.TerminalLocation // By location ID, starting with the demo store
+
+	sessionLines map[string][]*stripe.LineItem               // Line items by checkout session ID
+	calculations map[string][]*stripe.TaxCalculationLineItem // Line items by tax calculation ID
+}
+
+// demoIntent is a simulated PaymentIntent and, while on the reader, its outcome
+type demoIntent struct {
+	intent    stripe.PaymentIntent
+	sentAt    time.Time // When the intent was sent to the reader; zero otherwise
+	declines  bool
+	cardInput string // card_present or card, for the charge's card details
+}
+
+// demoLink is a simulated payment link
+type demoLink struct {
+	link   stripe.PaymentLink
+	amount int64
+	lines  []*stripe.LineItem
+}
+
+func newDemoStripeClient() *demoStripeClient {
+	return &demoStripeClient{
+		intents:      make(map[string]*demoIntent),
+		links:        make(map[string]*demoLink),
+		prices:       make(map[string]*stripe.Price),
+		untaxed:      make(map[string]bool),
+		sessionLines: make(map[string][]*stripe.LineItem),
+		calculations: make(map[string][]*stripe.TaxCalculationLineItem),
+		refunds:      make(map[string]*stripe.Refund),
+		balances:     make(map[string]*stripe.BalanceTransaction),
+		locations:    demoLocations(),
+	}
+}
+
+// demoLocations returns the demo account's Terminal Locations: the demo store the reader is in
+func demoLocations() map[string]*stripe.TerminalLocation {
+	return map[string]*stripe.TerminalLocation{demoLocationID: {
+		ID:          demoLocationID,
+		DisplayName: "Demo Store",
+		Address:     &stripe.Address{Line1: "1 Demo Street", City: "Springfield", State: "IL", PostalCode: "62701", Country: "US"},
+	}}
+}
+
+// demoFee is the processing fee the demo charges on an amount in cents: Stripe's US list
+// price for in-person payments on a reader, and for online card payments otherwise
+func demoFee(amount int64, cardInput string) int64 {
+	if cardInput == "card_present" {
+		return int64(math.Round(float64(amount)*0.027)) + 5
+	}
+	return int64(math.Round(float64(amount)*0.029)) + 30
+}
+
+// newBalanceTransaction records the balance transaction of a charge or refund. Callers hold the mutex.
+func (c *demoStripeClient) newBalanceTransaction(kind stripe.BalanceTransactionType, amount, fee int64) *stripe.BalanceTransaction {
+	txn := &stripe.BalanceTransaction{
+		ID:       c.newID("txn"),
+		Amount:   amount,
+		Fee:      fee,
+		Net:      amount - fee,
+		Currency: stripe.CurrencyUSD,
+		Type:     kind,
+	}
+	c.balances[txn.ID] = txn
+	return txn
+}
+
+// demoTax is the tax the demo's Stripe Tax charges on an amount in cents: the default tax rate
+func demoTax(amount int64) int64 {
+	return int64(math.Round(float64(amount) * config.Config.DefaultTaxRate))
+}
+
+// newID returns a made-up Stripe ID recognised by IsDemoID. Callers hold the mutex.
+func (c *demoStripeClient) newID(prefix string) string {
+	c.nextID++
+	return fmt.Sprintf("%s_demo_%d%d", prefix, time.Now().Unix(), c.nextID)
+}
+
+func demoNotFound(kind, id string) error {
+	return &stripe.Error{
+		Type:           stripe.ErrorTypeInvalidRequest,
+		Code:           stripe.ErrorCodeResourceMissing,
+		HTTPStatusCode: 404,
+		Msg:            fmt.Sprintf("No such %s: '%s'", kind, id),
+	}
+}
+
+func demoDeclineError() *stripe.Error {
+	return &stripe.Error{
+		Type:           stripe.ErrorTypeCard,
+		Code:           stripe.ErrorCodeCardDeclined,
+		DeclineCode:    stripe.DeclineCodeGenericDecline,
+		HTTPStatusCode: 402,
+		Msg:            demoDeclineMsg,
+	}
+}
+
+// settle finishes a payment on the reader once DemoReaderDelay has passed. Callers hold the mutex.
+func (c *demoStripeClient) settle(di *demoIntent) {
+	if di.sentAt.IsZero() || time.Since(di.sentAt) < DemoReaderDelay {
+		return
+	}
+	di.sentAt = time.Time{}
+	if di.declines {
+		di.intent.LastPaymentError = demoDeclineError()
+		return
+	}
+	c.succeed(di)
+}
+
+// succeed marks a simulated payment as paid. Callers hold the mutex.
+func (c *demoStripeClient) succeed(di *demoIntent) {
+	di.intent.Status = stripe.PaymentIntentStatusSucceeded
+	di.intent.AmountReceived = di.intent.Amount
+	di.intent.LastPaymentError = nil
+	fee := demoFee(di.intent.Amount, di.cardInput)
+	di.intent.LatestCharge = &stripe.Charge{
+		ID:                 c.newID("ch"),
+		BalanceTransaction: &stripe.BalanceTransaction{ID: c.newBalanceTransaction(stripe.BalanceTransactionTypeCharge, di.intent.Amount, fee).ID},
+	}
+}
+
+func (c *demoStripeClient) CreatePaymentIntent(params *stripe.PaymentIntentParams) (*stripe.PaymentIntent, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	di := &demoIntent{intent: stripe.PaymentIntent{
+		ID:       c.newID("pi"),
+		Object:   "payment_intent",
+		Created:  time.Now().Unix(),
+		Currency: stripe.CurrencyUSD,
+		Status:   stripe.PaymentIntentStatusRequiresPaymentMethod,
+		Metadata: map[string]string{},
+	}}
+	if params.Amount != nil {
+		di.intent.Amount = *params.Amount
+	}
+	if params.Description != nil {
+		di.intent.Description = *params.Description
+	}
+	for key, value := range params.Metadata {
+		di.intent.Metadata[key] = value
+	}
+	c.intents[di.intent.ID] = di
+	intent := di.intent
+	return &intent, nil
+}
+
+func (c *demoStripeClient) ConfirmPaymentIntent(intentID string, params *stripe.PaymentIntentConfirmParams) (*stripe.PaymentIntent, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	di, ok := c.intents[intentID]
+	if !ok {
+		return nil, demoNotFound("payment_intent", intentID)
+	}
+	if params.PaymentMethod == nil || *params.PaymentMethod != demoApprovePMID {
+		di.intent.LastPaymentError = demoDeclineError()
+		return nil, demoDeclineError()
+	}
+	di.cardInput = "card"
+	c.succeed(di)
+	intent := di.intent
+	return &intent, nil
+}
+
+func (c *demoStripeClient) GetPaymentIntent(intentID string) (*stripe.PaymentIntent, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	di, ok := c.intents[intentID]
+	if !ok {
+		return nil, demoNotFound("payment_intent", intentID)
+	}
+	c.settle(di)
+	intent := di.intent
+	return &intent, nil
+}
+
+func (c *demoStripeClient) UpdatePaymentIntent(intentID string, params *stripe.PaymentIntentParams) (*stripe.PaymentIntent, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	di, ok := c.intents[intentID]
+	if !ok {
+		return nil, demoNotFound("payment_intent", intentID)
+	}
+	if params.Amount != nil {
+		di.intent.Amount = *params.Amount
+	}
+	if params.PaymentMethodTypes != nil {
+		di.intent.PaymentMethodTypes = nil
+		for _, methodType := range params.PaymentMethodTypes {
+			di.intent.PaymentMethodTypes = append(di.intent.PaymentMethodTypes, *methodType)
+		}
+	}
+	if params.ReceiptEmail != nil {
+		di.intent.ReceiptEmail = *params.ReceiptEmail
+	}
+	if params.Description != nil {
+		di.intent.Description = *params.Description
+	}
+	for key, value := range params.Metadata {
+		di.intent.Metadata[key] = value
+	}
+	intent := di.intent
+	return &intent, nil
+}
+
+func (c *demoStripeClient) CancelPaymentIntent(intentID string) (*stripe.PaymentIntent, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	di, ok := c.intents[intentID]
+	if !ok {
+		return nil, demoNotFound("payment_intent", intentID)
+	}
+	if di.intent.Status == stripe.PaymentIntentStatusSucceeded {
+		return nil, &stripe.Error{Type: stripe.ErrorTypeInvalidRequest, Code: stripe.ErrorCodePaymentIntentUnexpectedState,
+			HTTPStatusCode: 400, Msg: "You cannot cancel this PaymentIntent because it has a status of succeeded."}
+	}
+	di.intent.Status = stripe.PaymentIntentStatusCanceled
+	di.sentAt = time.Time{}
+	intent := di.intent
+	return &intent, nil
+}
+
+func (c *demoStripeClient) ListPaymentIntents(params *stripe.PaymentIntentListParams) ([]*stripe.PaymentIntent, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	var intents []*stripe.PaymentIntent
+	for _, di := range c.intents {
+		c.settle(di)
+		if r := params.CreatedRange; r != nil {
+			if (r.GreaterThanOrEqual != 0 && di.intent.Created < r.GreaterThanOrEqual) ||
+				(r.LesserThan != 0 && di.intent.Created >= r.LesserThan) {
+				continue
+			}
+		}
+		intent := di.intent
+		intents = append(intents, &intent)
+	}
+	sort.Slice(intents, func(i, j int) bool { return intents[i].Created > intents[j].Created })
+	return intents, nil
+}
+
+func (c *demoStripeClient) CreateRefund(params *stripe.RefundParams) (*stripe.Refund, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	intentID := stripe.StringValue(params.PaymentIntent)
+	di, ok := c.intents[intentID]
+	if !ok {
+		return nil, demoNotFound("payment_intent", intentID)
+	}
+	amount := di.intent.AmountReceived
+	if params.Amount != nil {
+		amount = *params.Amount
+	}
+	// Stripe keeps the processing fee of a refunded payment
+	balance := c.newBalanceTransaction(stripe.BalanceTransactionTypeRefund, -amount, 0)
+	r := &stripe.Refund{
+		ID:                 c.newID("re"),
+		Amount:             amount,
+		Currency:           stripe.CurrencyUSD,
+		Status:             stripe.RefundStatusSucceeded,
+		PaymentIntent:      &stripe.PaymentIntent{ID: intentID},
+		Metadata:           params.Metadata,
+		BalanceTransaction: &stripe.BalanceTransaction{ID: balance.ID},
+	}
+	c.refunds[r.ID] = r
+	refund := *r
+	return &refund, nil
+}
+
+func (c *demoStripeClient) GetRefund(refundID string) (*stripe.Refund, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	r, ok := c.refunds[refundID]
+	if !ok {
+		return nil, demoNotFound("refund", refundID)
+	}
+	refund := *r
+	return &refund, nil
+}
+
+func (c *demoStripeClient) GetCharge(chargeID string) (*stripe.Charge, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for _, di := range c.intents {
+		if di.intent.LatestCharge == nil || di.intent.LatestCharge.ID != chargeID {
+			continue
+		}
+		details := &stripe.ChargePaymentMethodDetails{}
+		if di.cardInput == "card_present" {
+			details.CardPresent = &stripe.ChargePaymentMethodDetailsCardPresent{Brand: stripe.PaymentMethodCardBrandVisa, Last4: demoCardLastFour}
+		} else {
+			details.Card = &stripe.ChargePaymentMethodDetailsCard{Brand: stripe.PaymentMethodCardBrandVisa, Last4: demoCardLastFour}
+		}
+		return &stripe.Charge{
+			ID:                   chargeID,
+			Amount:               di.intent.AmountReceived,
+			Paid:                 true,
+			Status:               stripe.ChargeStatusSucceeded,
+			PaymentIntent:        &stripe.PaymentIntent{ID: di.intent.ID},
+			PaymentMethodDetails: details,
+			BalanceTransaction:   di.intent.LatestCharge.BalanceTransaction,
+		}, nil
+	}
+	return nil, demoNotFound("charge", chargeID)
+}
+
+// demoReader returns the simulated reader with its current action. Callers hold the mutex.
+func (c *demoStripeClient) demoReader() *stripe.TerminalReader {
+	terminalReader := &stripe.TerminalReader{
+		ID:              demoReaderID,
+		Label:           "Demo Reader",
+		Status:          "online",
+		DeviceType:      "demo_reader",
+		DeviceSwVersion: "demo",
+		SerialNumber:    "DEMO-0001",
+		Location:        &stripe.TerminalLocation{ID: demoLocationID},
+	}
+
+	di, ok := c.intents[c.reader]
+	if !ok {
+		return terminalReader
+	}
+	c.settle(di)
+
+	intent := di.intent
+	action := &stripe.TerminalReaderAction{
+		Type:                 stripe.TerminalReaderActionTypeProcessPaymentIntent,
+		Status:               stripe.TerminalReaderActionStatusInProgress,
+		ProcessPaymentIntent: &stripe.TerminalReaderActionProcessPaymentIntent{PaymentIntent: &intent},
+	}
+	switch {
+	case intent.Status == stripe.PaymentIntentStatusSucceeded:
+		action.Status = stripe.TerminalReaderActionStatusSucceeded
+	case intent.LastPaymentError != nil:
+		action.Status = stripe.TerminalReaderActionStatusFailed
+		action.FailureCode = string(intent.LastPaymentError.Code)
+		action.FailureMessage = intent.LastPaymentError.Msg
+	case intent.Status == stripe.PaymentIntentStatusCanceled:
+		return terminalReader
+	}
+	terminalReader.Action = action
+	return terminalReader
+}
+
+func (c *demoStripeClient) ProcessReaderPayment(readerID string, params *stripe.TerminalReaderProcessPaymentIntentParams) (*stripe.TerminalReader, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if readerID != demoReaderID {
+		return nil, demoNotFound("terminal.reader", readerID)
+	}
+	intentID := stripe.StringValue(params.PaymentIntent)
+	di, ok := c.intents[intentID]
+	if !ok {
+		return nil, demoNotFound("payment_intent", intentID)
+	}
+	di.sentAt = time.Now()
+	di.declines = c.decline
+	di.cardInput = "card_present"
+	di.intent.LastPaymentError = nil
+	c.reader = intentID
+	return c.demoReader(), nil
+}
+
+func (c *demoStripeClient) GetReader(readerID string) (*stripe.TerminalReader, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if readerID != demoReaderID {
+		return nil, demoNotFound("terminal.reader", readerID)
+	}
+	return c.demoReader(), nil
+}
+
+func (c *demoStripeClient) CancelReaderAction(readerID string) (*stripe.TerminalReader, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if readerID != demoReaderID {
+		return nil, demoNotFound("terminal.reader", readerID)
+	}
+	if di, ok := c.intents[c.reader]; ok {
+		di.sentAt = time.Time{}
+	}
+	c.reader = ""
+	return c.demoReader(), nil
+}
+
+// The demo reader has no screen; showing a cart succeeds while it isn't taking a payment
+func (c *demoStripeClient) SetReaderDisplay(readerID string, _ *stripe.TerminalReaderSetReaderDisplayParams) (*stripe.TerminalReader, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if readerID != demoReaderID {
+		return nil, demoNotFound("terminal.reader", readerID)
+	}
+	terminalReader := c.demoReader()
+	if terminalReader.Action != nil && terminalReader.Action.Status == stripe.TerminalReaderActionStatusInProgress {
+		return nil, &stripe.Error{Type: stripe.ErrorTypeInvalidRequest, Code: stripe.ErrorCodeTerminalReaderBusy,
+			HTTPStatusCode: 400, Msg: "Reader is currently busy processing another request."}
+	}
+	terminalReader.Action = &stripe.TerminalReaderAction{
+		Type:   stripe.TerminalReaderActionTypeSetReaderDisplay,
+		Status: stripe.TerminalReaderActionStatusSucceeded,
+	}
+	return terminalReader, nil
+}
+
+func (c *demoStripeClient) ListReaders(_ *stripe.TerminalReaderListParams) ([]*stripe.TerminalReader, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return []*stripe.TerminalReader{c.demoReader()}, nil
+}
+
+func (c *demoStripeClient) ListLocations(_ *stripe.TerminalLocationListParams) ([]*stripe.TerminalLocation, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	locations := make([]*stripe.TerminalLocation, 0, len(c.locations))
+	for _, id := range sortedKeys(c.locations) {
+		location := *c.locations[id]
+		locations = append(locations, &location)
+	}
+	return locations, nil
+}
+
+func (c *demoStripeClient) CreateLocation(params *stripe.TerminalLocationParams) (*stripe.TerminalLocation, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	location := &stripe.TerminalLocation{ID: c.newID("tml")}
+	if err := applyDemoLocationParams(location, params); err != nil {
+		return nil, err
+	}
+	c.locations[location.ID] = location
+	created := *location
+	return &created, nil
+}
+
+func (c *demoStripeClient) UpdateLocation(locationID string, params *stripe.TerminalLocationParams) (*stripe.TerminalLocation, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	existing, ok := c.locations[locationID]
+	if !ok {
+		return nil, demoNotFound("terminal.location", locationID)
+	}
+	location := *existing
+	if err := applyDemoLocationParams(&location, params); err != nil {
+		return nil, err
+	}
+	c.locations[locationID] = &location
+	updated := location
+	return &updated, nil
+}
+
+func (c *demoStripeClient) DeleteLocation(locationID string) (*stripe.TerminalLocation, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	location, ok := c.locations[locationID]
+	if !ok {
+		return nil, demoNotFound("terminal.location", locationID)
+	}
+	if locationID == demoLocationID {
+		return nil, &stripe.Error{Type: stripe.ErrorTypeInvalidRequest, HTTPStatusCode: 400,
+			Msg: "This location has readers registered to it"}
+	}
+	delete(c.locations, locationID)
+	deleted := *location
+	deleted.Deleted = true
+	return &deleted, nil
+}
+
+// applyDemoLocationParams sets a location's name and address, checking them the way Stripe
+// does for the fields the form sends
+func applyDemoLocationParams(location *stripe.TerminalLocation, params *stripe.TerminalLocationParams) error {
+	if params.DisplayName != nil {
+		location.DisplayName = *params.DisplayName
+	}
+	if params.Address != nil {
+		address := params.Address
+		location.Address = &stripe.Address{
+			Line1:      stripe.StringValue(address.Line1),
+			City:       stripe.StringValue(address.City),
+			State:      stripe.StringValue(address.State),
+			PostalCode: stripe.StringValue(address.PostalCode),
+			Country:    stripe.StringValue(address.Country),
+		}
+	}
+	invalid := func(param, msg string) error {
+		return &stripe.Error{Type: stripe.ErrorTypeInvalidRequest, HTTPStatusCode: 400, Param: param, Msg: msg}
+	}
+	switch {
+	case location.DisplayName == "":
+		return invalid("display_name", "Missing required param: display_name.")
+	case location.Address == nil || location.Address.Country == "":
+		return invalid("address[country]", "Missing required param: address[country].")
+	case location.Address.Country == "US" && len(location.Address.PostalCode) != 5:
+		return invalid("address[postal_code]", "Invalid US postal code.")
+	}
+	return nil
+}
+
+// The demo reader can't ask the customer for input, so receipt emails use the form instead
+func (c *demoStripeClient) CollectReaderInputs(readerID string, _ *stripe.Params) (*TerminalReaderInputs, error) {
+	return nil, &stripe.Error{Type: stripe.ErrorTypeInvalidRequest, HTTPStatusCode: 400, Msg: "The demo reader can't collect inputs"}
+}
+
+func (c *demoStripeClient) GetReaderInputs(readerID string) (*TerminalReaderInputs, error) {
+	return nil, demoNotFound("terminal.reader", readerID)
+}
+
+func (c *demoStripeClient) CreatePaymentLink(params *stripe.PaymentLinkParams) (*stripe.PaymentLink, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	dl := &demoLink{link: stripe.PaymentLink{
+		ID:       c.newID("plink"),
+		Object:   "payment_link",
+		Active:   true,
+		Currency: stripe.CurrencyUSD,
+		Metadata: map[string]string{},
+	}}
+	dl.link.URL = demoPaymentLink + dl.link.ID
+	for key, value := range params.Metadata {
+		dl.link.Metadata[key] = value
+	}
+	automaticTax := params.AutomaticTax != nil && stripe.BoolValue(params.AutomaticTax.Enabled)
+	dl.link.AutomaticTax = &stripe.PaymentLinkAutomaticTax{Enabled: automaticTax}
+	for _, item := range params.LineItems {
+		priceID := stripe.StringValue(item.Price)
+		var amount int64
+		if price, ok := c.prices[priceID]; ok {
+			amount = price.UnitAmount * stripe.Int64Value(item.Quantity)
+		}
+		var tax int64
+		if automaticTax && !c.untaxed[priceID] {
+			tax = demoTax(amount)
+		}
+		dl.amount += amount + tax
+		dl.lines = append(dl.lines, &stripe.LineItem{
+			ID:             c.newID("li"),
+			Price:          &stripe.Price{ID: priceID},
+			Quantity:       stripe.Int64Value(item.Quantity),
+			AmountSubtotal: amount,
+			AmountTax:      tax,
+			AmountTotal:    amount + tax,
+		})
+	}
+	c.links[dl.link.ID] = dl
+
+	// The customer "pays" after the countdown
+	created := time.Now()
+	time.AfterFunc(DemoQRDelay, func() { c.payLink(dl.link.ID, created) })
+
+	link := dl.link
+	return &link, nil
+}
+
+// payLink completes a checkout session for a demo payment link that is still active
+func (c *demoStripeClient) payLink(paymentLinkID string, created time.Time) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	dl, ok := c.links[paymentLinkID]
+	if !ok || !dl.link.Active {
+		return
+	}
+
+	di := &demoIntent{cardInput: "card", intent: stripe.PaymentIntent{
+		ID:       c.newID("pi"),
+		Object:   "payment_intent",
+		Created:  created.Unix(),
+		Amount:   dl.amount,
+		Currency: stripe.CurrencyUSD,
+		Metadata: dl.link.Metadata,
+	}}
+	c.succeed(di)
+	c.intents[di.intent.ID] = di
+
+	sessionID := c.newID("cs")
+	c.sessionLines[sessionID] = dl.lines
+	c.sessions = append(c.sessions, &stripe.CheckoutSession{
+		ID:            sessionID,
+		Object:        "checkout.session",
+		Created:       time.Now().Unix(),
+		Status:        stripe.CheckoutSessionStatusComplete,
+		PaymentStatus: stripe.CheckoutSessionPaymentStatusPaid,
+		AmountTotal:   dl.amount,
+		PaymentLink:   &stripe.PaymentLink{ID: paymentLinkID},
+		PaymentIntent: &stripe.PaymentIntent{ID: di.intent.ID},
+		Metadata:      dl.link.Metadata,
+	})
+
+	// Single use, like the real links
+	dl.link.Active = false
+	utils.Info("demo", "Simulated QR payment completed", "payment_link_id", paymentLinkID, "amount", float64(dl.amount)/100)
+}
+
+func (c *demoStripeClient) GetPaymentLink(paymentLinkID string) (*stripe.PaymentLink, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	dl, ok := c.links[paymentLinkID]
+	if !ok {
+		return nil, demoNotFound("payment_link", paymentLinkID)
+	}
+	link := dl.link
+	return &link, nil
+}
+
+func (c *demoStripeClient) DeactivatePaymentLink(paymentLinkID string) (*stripe.PaymentLink, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	dl, ok := c.links[paymentLinkID]
+	if !ok {
+		return nil, demoNotFound("payment_link", paymentLinkID)
+	}
+	dl.link.Active = false
+	link := dl.link
+	return &link, nil
+}
+
+func (c *demoStripeClient) ListPaymentLinks(params *stripe.PaymentLinkListParams) ([]*stripe.PaymentLink, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	var links []*stripe.PaymentLink
+	for _, dl := range c.links {
+		if params.Active != nil && dl.link.Active != *params.Active {
+			continue
+		}
+		link := dl.link
+		links = append(links, &link)
+	}
+	return links, nil
+}
+
+func (c *demoStripeClient) ListCheckoutSessions(params *stripe.CheckoutSessionListParams) ([]*stripe.CheckoutSession, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	// Newest first, like Stripe
+	var sessions []*stripe.CheckoutSession
+	for i := len(c.sessions) - 1; i >= 0; i-- {
+		s := c.sessions[i]
+		if params.PaymentLink != nil && s.PaymentLink.ID != *params.PaymentLink {
+			continue
+		}
+		session := *s
+		sessions = append(sessions, &session)
+	}
+	return sessions, nil
+}
+
+func (c *demoStripeClient) ListCheckoutSessionLineItems(sessionID string) ([]*stripe.LineItem, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	lines, ok := c.sessionLines[sessionID]
+	if !ok {
+		return nil, demoNotFound("checkout.session", sessionID)
+	}
+	return lines, nil
+}
+
+// CreateTaxCalculation charges the default tax rate on every line without the non-taxable code
+func (c *demoStripeClient) CreateTaxCalculation(params *stripe.TaxCalculationParams) (*stripe.TaxCalculation, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	calc := &stripe.TaxCalculation{
+		ID:        c.newID("taxcalc"),
+		Object:    "tax.calculation",
+		Currency:  stripe.CurrencyUSD,
+		ExpiresAt: time.Now().Add(90 * 24 * time.Hour).Unix(),
+	}
+	var lines []*stripe.TaxCalculationLineItem
+	for _, item := range params.LineItems {
+		amount := stripe.Int64Value(item.Amount)
+		var tax int64
+		if stripe.StringValue(item.TaxCode) != NonTaxableTaxCode {
+			tax = demoTax(amount)
+		}
+		lines = append(lines, &stripe.TaxCalculationLineItem{
+			ID:        c.newID("tax_li"),
+			Object:    "tax.calculation_line_item",
+			Amount:    amount,
+			AmountTax: tax,
+			Quantity:  stripe.Int64Value(item.Quantity),
+			Reference: stripe.StringValue(item.Reference),
+		})
+		calc.AmountTotal += amount + tax
+		calc.TaxAmountExclusive += tax
+	}
+	c.calculations[calc.ID] = lines
+	return calc, nil
+}
+
+func (c *demoStripeClient) ListTaxCalculationLineItems(calculationID string) ([]*stripe.TaxCalculationLineItem, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	lines, ok := c.calculations[calculationID]
+	if !ok {
+		return nil, demoNotFound("tax.calculation", calculationID)
+	}
+	return lines, nil
+}
+
+func (c *demoStripeClient) CreateTaxTransaction(params *stripe.TaxTransactionCreateFromCalculationParams) (*stripe.TaxTransaction, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return &stripe.TaxTransaction{ID: c.newID("tax_txn"), Object: "tax.transaction", Reference: stripe.StringValue(params.Reference)}, nil
+}
+
+func (c *demoStripeClient) UpdateCustomer(customerID string, params *stripe.CustomerParams) (*stripe.Customer, error) {
+	return &stripe.Customer{ID: customerID, Email: stripe.StringValue(params.Email)}, nil
+}
+
+// Catalog products keep their real Stripe IDs in demo mode; any ID is treated as active
+func (c *demoStripeClient) GetProduct(productID string) (*stripe.Product, error) {
+	return &stripe.Product{ID: productID, Active: true}, nil
+}
+
+func (c *demoStripeClient) CreateProduct(params *stripe.ProductParams) (*stripe.Product, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return &stripe.Product{ID: c.newID("prod"), Name: stripe.StringValue(params.Name), Active: true}, nil
+}
+
+func (c *demoStripeClient) GetPrice(priceID string) (*stripe.Price, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if price, ok := c.prices[priceID]; ok {
+		copied := *price
+		return &copied, nil
+	}
+	// Catalog prices keep their real Stripe IDs in demo mode
+	return &stripe.Price{ID: priceID, Active: true, Currency: stripe.CurrencyUSD}, nil
+}
+
+func (c *demoStripeClient) CreatePrice(params *stripe.PriceParams) (*stripe.Price, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	price := &stripe.Price{
+		ID:         c.newID("price"),
+		Active:     true,
+		Currency:   stripe.CurrencyUSD,
+		UnitAmount: stripe.Int64Value(params.UnitAmount),
+		Nickname:   stripe.StringValue(params.Nickname),
+		Metadata:   map[string]string{},
+		Created:    time.Now().Unix(),
+	}
+	if params.Product != nil {
+		price.Product = &stripe.Product{ID: *params.Product}
+	}
+	for key, value := range params.Metadata {
+		price.Metadata[key] = value
+	}
+	c.prices[price.ID] = price
+	if params.ProductData != nil && stripe.StringValue(params.ProductData.TaxCode) == NonTaxableTaxCode {
+		c.untaxed[price.ID] = true
+	}
+	copied := *price
+	return &copied, nil
+}
+
+func (c *demoStripeClient) UpdatePrice(priceID string, params *stripe.PriceParams) (*stripe.Price, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	price, ok := c.prices[priceID]
+	if !ok {
+		return nil, demoNotFound("price", priceID)
+	}
+	if params.Active != nil {
+		price.Active = *params.Active
+	}
+	for key, value := range params.Metadata {
+		price.Metadata[key] = value
+	}
+	copied := *price
+	return &copied, nil
+}
+
+// ListPrices lists the prices made in demo mode, filtered by active and created before
+func (c *demoStripeClient) ListPrices(params *stripe.PriceListParams) ([]*stripe.Price, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	var prices []*stripe.Price
+	for _, price := range c.prices {
+		if params.Active != nil && price.Active != *params.Active {
+			continue
+		}
+		if params.CreatedRange != nil && params.CreatedRange.LesserThan > 0 && price.Created >= params.CreatedRange.LesserThan {
+			continue
+		}
+		copied := *price
+		prices = append(prices, &copied)
+	}
+	return prices, nil
+}
+
+func (c *demoStripeClient) GetBalance() (*stripe.Balance, error) {
+	return &stripe.Balance{}, nil
+}
+
+func (c *demoStripeClient) GetBalanceTransaction(transactionID string) (*stripe.BalanceTransaction, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	txn, ok := c.balances[transactionID]
+	if !ok {
+		return nil, demoNotFound("balance_transaction", transactionID)
+	}
+	balance := *txn
+	return &balance, nil
+}
+
+// GetAccount returns an account with nothing restricted
+func (c *demoStripeClient) GetAccount() (*stripe.Account, error) {
+	return &stripe.Account{
+		ID:             "acct_demo",
+		ChargesEnabled: true,
+		PayoutsEnabled: true,
+		Capabilities: &stripe.AccountCapabilities{
+			CardPayments: stripe.AccountCapabilityStatusActive,
+			Transfers:    stripe.AccountCapabilityStatusActive,
+		},
+		Requirements: &stripe.AccountRequirements{},
+	}, nil
+}
@@ -0,0 +1,269 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/stripe/stripe-go/v74"
+
+	"checkout/config"
+	"checkout/templates"
+	"checkout/utils"
+)
+
+// Payment Link Status markers on the transaction rows for duplicate payments
+const (
+	DuplicatePaymentLinkStatus  = "duplicate_payment"
+	DuplicateRefundedLinkStatus = "duplicate_refunded"
+)
+
+// Duplicate payment statuses
+const (
+	DuplicateNeedsRefund = "needs_refund"
+	DuplicateRefunded    = "refunded"
+)
+
+// duplicatePayments holds every duplicate payment found, keyed by checkout session ID,
+// persisted so an unrefunded one keeps alerting the POS across restarts
+var duplicatePayments = struct {
+	payments map[string]*templates.DuplicatePayment
+	loaded   bool
+	mutex    sync.Mutex
+}{payments: make(map[string]*templates.DuplicatePayment)}
+
+// FlagDuplicateLinkPayments lists the checkout sessions of a payment link and flags every
+// completed session after the first as a duplicate payment. Returns the newly flagged payments.
+func FlagDuplicateLinkPayments(client StripeClient, paymentLinkID string) ([]templates.DuplicatePayment, error) {
+	params := &stripe.CheckoutSessionListParams{}
+	params.PaymentLink = stripe.String(paymentLinkID)
+	sessions, err := client.ListCheckoutSessions(params)
+	if err != nil {
+		return nil, fmt.Errorf("error listing checkout sessions: %w", err)
+	}
+	return flagDuplicateSessions(paymentLinkID, sessions)
+}
+
+// flagDuplicateSessions records the completed sessions beyond the first (by creation time).
+// Each is logged as its own transaction row marked duplicate_payment; sessions already
+// flagged are skipped, so this is safe to call on every status check.
+func flagDuplicateSessions(paymentLinkID string, sessions []*stripe.CheckoutSession) ([]templates.DuplicatePayment, error) {
+	var completed []*stripe.CheckoutSession
+	for _, s := range sessions {
+		if s.Status == stripe.CheckoutSessionStatusComplete {
+			completed = append(completed, s)
+		}
+	}
+	if len(completed) <= 1 {
+		return nil, nil
+	}
+	sort.Slice(completed, func(i, j int) bool { return completed[i].Created < completed[j].Created })
+
+	duplicatePayments.mutex.Lock()
+	defer duplicatePayments.mutex.Unlock()
+
+	if err := ensureDuplicatePaymentsLoaded(); err != nil {
+		return nil, err
+	}
+
+	var flagged []templates.DuplicatePayment
+	for _, session := range completed[1:] {
+		if _, exists := duplicatePayments.payments[session.ID]; exists {
+			continue
+		}
+
+		now := time.Now()
+		payment := templates.DuplicatePayment{
+			SessionID:     session.ID,
+			PaymentLinkID: paymentLinkID,
+			Amount:        float64(session.AmountTotal) / 100,
+			Date:          now.Format("01/02/2006"),
+			Time:          now.Format("15:04:05"),
+			Status:        DuplicateNeedsRefund,
+		}
+		if session.PaymentIntent != nil {
+			payment.PaymentIntentID = session.PaymentIntent.ID
+		}
+		if session.CustomerDetails != nil {
+			payment.CustomerEmail = session.CustomerDetails.Email
+		}
+
+		if err := logDuplicatePayment(payment, DuplicatePaymentLinkStatus, payment.Amount, "paid again after the link was used; needs refund"); err != nil {
+			return flagged, err
+		}
+		duplicatePayments.payments[session.ID] = &payment
+		flagged = append(flagged, payment)
+		utils.Warn("stripe", "Duplicate payment on payment link", "payment_link_id", paymentLinkID,
+			"session_id", session.ID, "intent_id", payment.PaymentIntentID, "amount", payment.Amount)
+	}
+
+	if len(flagged) > 0 {
+		if err := saveDuplicatePayments(); err != nil {
+			return flagged, err
+		}
+	}
+	return flagged, nil
+}
+
+// IsDuplicateSession reports whether a checkout session has been flagged as a duplicate payment
+func IsDuplicateSession(sessionID string) bool {
+	duplicatePayments.mutex.Lock()
+	defer duplicatePayments.mutex.Unlock()
+
+	if err := ensureDuplicatePaymentsLoaded(); err != nil {
+		utils.Error("stripe", "Error loading duplicate payments", "error", err)
+		return false
+	}
+	_, exists := duplicatePayments.payments[sessionID]
+	return exists
+}
+
+// PendingDuplicatePayments returns the duplicate payments not yet refunded, oldest first
+func PendingDuplicatePayments() []templates.DuplicatePayment {
+	duplicatePayments.mutex.Lock()
+	defer duplicatePayments.mutex.Unlock()
+
+	if err := ensureDuplicatePaymentsLoaded(); err != nil {
+		utils.Error("stripe", "Error loading duplicate payments", "error", err)
+		return nil
+	}
+
+	var pending []templates.DuplicatePayment
+	for _, payment := range duplicatePayments.payments {
+		if payment.Status == DuplicateNeedsRefund {
+			pending = append(pending, *payment)
+		}
+	}
+	sort.Slice(pending, func(i, j int) bool { return flaggedAt(pending[i]).Before(flaggedAt(pending[j])) })
+	return pending
+}
+
+// RefundDuplicatePayment refunds a duplicate payment in full and logs the refund and who made it
+func RefundDuplicatePayment(client StripeClient, sessionID, username string) (templates.DuplicatePayment, error) {
+	duplicatePayments.mutex.Lock()
+	defer duplicatePayments.mutex.Unlock()
+
+	if err := ensureDuplicatePaymentsLoaded(); err != nil {
+		return templates.DuplicatePayment{}, err
+	}
+
+	payment, exists := duplicatePayments.payments[sessionID]
+	if !exists {
+		return templates.DuplicatePayment{}, fmt.Errorf("duplicate payment %s not found", sessionID)
+	}
+	if payment.Status == DuplicateRefunded {
+		return *payment, fmt.Errorf("duplicate payment %s was already refunded", sessionID)
+	}
+	if payment.PaymentIntentID == "" {
+		return *payment, fmt.Errorf("duplicate payment %s has no payment intent to refund", sessionID)
+	}
+
+	params := &stripe.RefundParams{
+		PaymentIntent: stripe.String(payment.PaymentIntentID),
+		Reason:        stripe.String(string(stripe.RefundReasonDuplicate)),
+	}
+	params.AddMetadata(MetadataPaymentID, payment.PaymentLinkID)
+	if VendorsEnabled() {
+		intent, err := client.GetPaymentIntent(payment.PaymentIntentID)
+		if err != nil {
+			return *payment, fmt.Errorf("error retrieving duplicate payment: %w", err)
+		}
+		reverseVendorTransfer(params, intent)
+	}
+	if _, err := client.CreateRefund(params); err != nil {
+		return *payment, fmt.Errorf("error refunding duplicate payment: %w", err)
+	}
+
+	payment.Status = DuplicateRefunded
+	if err := saveDuplicatePayments(); err != nil {
+		return *payment, err
+	}
+	if err := logDuplicatePayment(*payment, DuplicateRefundedLinkStatus, -payment.Amount, ""); err != nil {
+		return *payment, err
+	}
+	utils.Info("audit", "Duplicate payment refunded", "session_id", sessionID, "intent_id", payment.PaymentIntentID, "amount", payment.Amount, "user", username)
+	return *payment, nil
+}
+
+// flaggedAt returns when a duplicate payment was found
+func flaggedAt(payment templates.DuplicatePayment) time.Time {
+	t, _ := time.ParseInLocation("01/02/2006 15:04:05", payment.Date+" "+payment.Time, time.Local)
+	return t
+}
+
+// logDuplicatePayment writes a duplicate payment event as its own transaction row.
+// The row has no items, so reports don't count it as a sale.
+func logDuplicatePayment(payment templates.DuplicatePayment, status string, total float64, reason string) error {
+	now := time.Now()
+	return SaveTransactionToCSV(templates.Transaction{
+		ID:                  payment.SessionID,
+		Date:                now.Format("01/02/2006"),
+		Time:                now.Format("15:04:05"),
+		Total:               total,
+		PaymentType:         "qr",
+		StripeCustomerEmail: payment.CustomerEmail,
+		PaymentLinkID:       payment.PaymentLinkID,
+		PaymentLinkStatus:   status,
+		ConfirmationCode:    payment.PaymentIntentID,
+		FailureReason:       reason,
+	})
+}
+
+// ensureDuplicatePaymentsLoaded reads the duplicate payments file once. Callers must hold duplicatePayments.mutex.
+func ensureDuplicatePaymentsLoaded() error {
+	if duplicatePayments.loaded {
+		return nil
+	}
+
+	data, err := os.ReadFile(getDuplicatePaymentsFilePath())
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("error reading duplicate payments file: %w", err)
+	}
+	if err == nil {
+		var payments []templates.DuplicatePayment
+		if err := json.Unmarshal(data, &payments); err != nil {
+			return fmt.Errorf("error parsing duplicate payments file: %w", err)
+		}
+		for i := range payments {
+			duplicatePayments.payments[payments[i].SessionID] = &payments[i]
+		}
+	}
+
+	duplicatePayments.loaded = true
+	return nil
+}
+
+// saveDuplicatePayments writes every duplicate payment to the data directory. Callers must hold duplicatePayments.mutex.
+func saveDuplicatePayments() error {
+	payments := make([]templates.DuplicatePayment, 0, len(duplicatePayments.payments))
+	for _, payment := range duplicatePayments.payments {
+		payments = append(payments, *payment)
+	}
+
+	jsonData, err := json.MarshalIndent(payments, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error marshaling duplicate payments: %w", err)
+	}
+
+	path := getDuplicatePaymentsFilePath()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("error creating data directory: %w", err)
+	}
+
+	if err := os.WriteFile(path, jsonData, 0644); err != nil {
+		return fmt.Errorf("error writing duplicate payments file: %w", err)
+	}
+	return nil
+}
+
+func getDuplicatePaymentsFilePath() string {
+	dataDir := config.Config.DataDir
+	if dataDir == "" {
+		dataDir = config.DefaultDataDir
+	}
+	return filepath.Join(dataDir, "duplicate-payments.json")
+}
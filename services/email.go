@@ -0,0 +1,112 @@
+package services
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+	"time"
+
+	"checkout/config"
+	"checkout/utils"
+)
+
+// defaultSMTPPort is used when no SMTP port is configured (submission with STARTTLS)
+const defaultSMTPPort = "587"
+
+// EmailAttachment is a file attached to an outgoing email
+type EmailAttachment struct {
+	Filename    string
+	ContentType string
+	Data        []byte
+}
+
+// SendEmail sends a plain-text email with optional attachments through the configured SMTP server
+func SendEmail(to []string, subject, body string, attachments []EmailAttachment) error {
+	if !config.IsEmailEnabled() {
+		return fmt.Errorf("email is not configured (SMTP host and from address are required)")
+	}
+	if len(to) == 0 {
+		return fmt.Errorf("no email recipients")
+	}
+
+	message, err := buildEmailMessage(config.Config.EmailFrom, to, subject, body, attachments)
+	if err != nil {
+		return fmt.Errorf("error building email: %w", err)
+	}
+
+	port := config.Config.SMTPPort
+	if port == "" {
+		port = defaultSMTPPort
+	}
+
+	var auth smtp.Auth
+	if config.Config.SMTPUsername != "" {
+		auth = smtp.PlainAuth("", config.Config.SMTPUsername, config.Config.SMTPPassword, config.Config.SMTPHost)
+	}
+
+	// smtp.SendMail upgrades to TLS with STARTTLS when the server supports it
+	if err := smtp.SendMail(net.JoinHostPort(config.Config.SMTPHost, port), auth, config.Config.EmailFrom, to, message); err != nil {
+		return fmt.Errorf("error sending email: %w", err)
+	}
+
+	utils.Info("email", "Email sent", "subject", subject, "recipients", len(to), "attachments", len(attachments))
+	return nil
+}
+
+// buildEmailMessage builds a MIME message with a text body followed by base64-encoded attachments
+func buildEmailMessage(from string, to []string, subject, body string, attachments []EmailAttachment) ([]byte, error) {
+	var buf bytes.Buffer
+	writer := multipart.NewWriter(&buf)
+
+	fmt.Fprintf(&buf, "From: %s\r\n", from)
+	fmt.Fprintf(&buf, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&buf, "Content-Type: multipart/mixed; boundary=%s\r\n\r\n", writer.Boundary())
+
+	textPart, err := writer.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {"text/plain; charset=utf-8"},
+		"Content-Transfer-Encoding": {"8bit"},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := textPart.Write([]byte(strings.ReplaceAll(body, "\n", "\r\n"))); err != nil {
+		return nil, err
+	}
+
+	for _, attachment := range attachments {
+		part, err := writer.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {attachment.ContentType},
+			"Content-Transfer-Encoding": {"base64"},
+			"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": attachment.Filename})},
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		// Wrap base64 lines at 76 characters as required by RFC 2045
+		encoded := base64.StdEncoding.EncodeToString(attachment.Data)
+		for len(encoded) > 76 {
+			if _, err := part.Write([]byte(encoded[:76] + "\r\n")); err != nil {
+				return nil, err
+			}
+			encoded = encoded[76:]
+		}
+		if _, err := part.Write([]byte(encoded + "\r\n")); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,179 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+
+	"checkout/config"
+	"checkout/templates"
+	"checkout/utils"
+)
+
+// ErrFavoritesFull is returned when starring a product on a device that has the maximum number
+var ErrFavoritesFull = errors.New("favorites are full")
+
+// favorites holds each device's favorite product IDs, in the order they are shown. They are
+// persisted so a register keeps its quick-access row across restarts.
+var favorites = struct {
+	devices map[string][]string
+	loaded  bool
+	mutex   sync.Mutex
+}{devices: make(map[string][]string)}
+
+// Favorites returns a device's favorite products in their order, up to the configured maximum.
+// Products no longer in the catalog are left out.
+func Favorites(device string) []templates.Product {
+	favorites.mutex.Lock()
+	defer favorites.mutex.Unlock()
+
+	if err := ensureFavoritesLoaded(); err != nil {
+		utils.Error("favorites", "Error loading favorites", "error", err)
+		return nil
+	}
+
+	var products []templates.Product
+	for _, id := range favorites.devices[device] {
+		if len(products) >= config.Config.MaxFavorites {
+			break
+		}
+		if product, ok := Catalog.FindByID(id); ok {
+			products = append(products, product)
+		}
+	}
+	return products
+}
+
+// FavoriteIDs returns the IDs of a device's favorite products that are shown, for marking their tiles
+func FavoriteIDs(device string) map[string]bool {
+	ids := make(map[string]bool)
+	for _, product := range Favorites(device) {
+		ids[product.ID] = true
+	}
+	return ids
+}
+
+// ToggleFavorite stars a product on a device, or unstars it if it already was, and reports
+// whether it is now a favorite. Products that left the catalog are dropped from the device's
+// list while it is being changed.
+func ToggleFavorite(device, productID string) (bool, error) {
+	favorites.mutex.Lock()
+	defer favorites.mutex.Unlock()
+
+	if err := ensureFavoritesLoaded(); err != nil {
+		return false, err
+	}
+	if _, ok := Catalog.FindByID(productID); !ok {
+		return false, fmt.Errorf("product %s not found", productID)
+	}
+
+	ids := currentFavoriteIDs(device)
+	starred := !slices.Contains(ids, productID)
+	if starred {
+		if len(ids) >= config.Config.MaxFavorites {
+			return false, ErrFavoritesFull
+		}
+		ids = append(ids, productID)
+	} else {
+		ids = slices.DeleteFunc(ids, func(id string) bool { return id == productID })
+	}
+
+	favorites.devices[device] = ids
+	if err := saveFavorites(); err != nil {
+		return false, err
+	}
+	utils.Debug("favorites", "Favorite toggled", "device", device, "product_id", productID, "starred", starred)
+	return starred, nil
+}
+
+// ReorderFavorites puts a device's favorites in the given order. IDs that aren't favorites are
+// ignored, and favorites missing from the order keep their place after the ones given.
+func ReorderFavorites(device string, order []string) error {
+	favorites.mutex.Lock()
+	defer favorites.mutex.Unlock()
+
+	if err := ensureFavoritesLoaded(); err != nil {
+		return err
+	}
+
+	ids := currentFavoriteIDs(device)
+	reordered := make([]string, 0, len(ids))
+	for _, id := range order {
+		if slices.Contains(ids, id) && !slices.Contains(reordered, id) {
+			reordered = append(reordered, id)
+		}
+	}
+	for _, id := range ids {
+		if !slices.Contains(reordered, id) {
+			reordered = append(reordered, id)
+		}
+	}
+
+	favorites.devices[device] = reordered
+	return saveFavorites()
+}
+
+// currentFavoriteIDs returns a device's favorite IDs that are still in the catalog. Callers must
+// hold favorites.mutex.
+func currentFavoriteIDs(device string) []string {
+	var ids []string
+	for _, id := range favorites.devices[device] {
+		if _, ok := Catalog.FindByID(id); ok {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// ensureFavoritesLoaded reads the favorites file once. Callers must hold favorites.mutex.
+func ensureFavoritesLoaded() error {
+	if favorites.loaded {
+		return nil
+	}
+
+	data, err := os.ReadFile(getFavoritesFilePath())
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("error reading favorites file: %w", err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &favorites.devices); err != nil {
+			return fmt.Errorf("error parsing favorites file: %w", err)
+		}
+		if favorites.devices == nil {
+			favorites.devices = make(map[string][]string)
+		}
+	}
+
+	favorites.loaded = true
+	return nil
+}
+
+// saveFavorites writes every device's favorites to the data directory. Callers must hold favorites.mutex.
+func saveFavorites() error {
+	jsonData, err := json.MarshalIndent(favorites.devices, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error marshaling favorites: %w", err)
+	}
+
+	path := getFavoritesFilePath()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("error creating data directory: %w", err)
+	}
+
+	if err := os.WriteFile(path, jsonData, 0644); err != nil {
+		return fmt.Errorf("error writing favorites file: %w", err)
+	}
+	return nil
+}
+
+func getFavoritesFilePath() string {
+	dataDir := config.Config.DataDir
+	if dataDir == "" {
+		dataDir = config.DefaultDataDir
+	}
+	return filepath.Join(dataDir, "favorites.json")
+}
@@ -0,0 +1,96 @@
+package services
+
+import (
+	"math"
+	"slices"
+
+	"checkout/config"
+	"checkout/i18n"
+	"checkout/templates"
+)
+
+// DefaultPaymentMethod is assumed for the service fee until the cashier picks a payment method
+const DefaultPaymentMethod = "terminal"
+
+// ServiceFeeEnabled reports whether a service fee is configured
+func ServiceFeeEnabled() bool {
+	return config.Config.ServiceFeeMode != "" && config.Config.ServiceFeeAmount > 0
+}
+
+// ServiceFeeApplies reports whether payments with method are charged the service fee
+func ServiceFeeApplies(method string) bool {
+	if method == "" {
+		method = DefaultPaymentMethod
+	}
+	return ServiceFeeEnabled() && slices.Contains(config.GetServiceFeeMethods(), method)
+}
+
+// ServiceFeeFor returns the service fee for paying amount (tax included) with method:
+// a percentage of it or a fixed amount, never more than the configured cap allows.
+// Methods without the fee and amounts of zero or less pay no fee.
+func ServiceFeeFor(amount float64, method string) float64 {
+	if amount <= 0 || !ServiceFeeApplies(method) {
+		return 0
+	}
+
+	fee := config.Config.ServiceFeeAmount
+	if config.Config.ServiceFeeMode == config.ServiceFeePercentage {
+		fee = amount * fee / 100
+	}
+	if maxPercent := config.Config.ServiceFeeMaxPercent; maxPercent > 0 {
+		fee = math.Min(fee, amount*maxPercent/100)
+	}
+	return roundCents(fee)
+}
+
+// ServiceFeeLabel returns the name of the service fee on screens and receipts
+func ServiceFeeLabel() string {
+	if label := config.Config.ServiceFeeLabel; label != "" {
+		return label
+	}
+	return i18n.T("fee.default_label")
+}
+
+// applyServiceFee adds the service fee to a cart summary. A cart the customer pays for is
+// charged the fee of method on its total. A cart that refunds money gives back the fees of its
+// return lines in proportion to the amount refunded, so an exchange for a cheaper item keeps
+// the fee on what the customer still has.
+func applyServiceFee(summary *templates.CartSummary, cart []templates.Product, method string, itemTaxes []float64) {
+	amount := summary.Subtotal + summary.Tax
+	if amount > 0 {
+		summary.ServiceFee = ServiceFeeFor(amount, method)
+	} else if amount < 0 {
+		var returned, returnedFees float64
+		for i, product := range cart {
+			if product.ReturnOf == "" {
+				continue
+			}
+			returned += product.Price
+			if i < len(itemTaxes) {
+				returned += itemTaxes[i]
+			}
+			returnedFees += product.ReturnServiceFee
+		}
+		if returned < 0 {
+			summary.ServiceFee = roundCents(returnedFees * math.Min(amount/returned, 1))
+		}
+	}
+	summary.Total += summary.ServiceFee
+}
+
+// returnServiceFee returns the share of a sale's service fee refunded with a return line
+// worth refund (tax included, negative)
+func returnServiceFee(original *templates.Transaction, refund float64) float64 {
+	paid := original.Subtotal + original.Tax
+	if original.ServiceFee == 0 || paid <= 0 {
+		return 0
+	}
+	return roundCents(original.ServiceFee * refund / paid)
+}
+
+// CartTotalWithoutFee returns the cart's total before any service fee. Split sales charge the
+// fee on each tender as it is paid, so their balance is kept without it.
+func CartTotalWithoutFee(cart *CartStore) float64 {
+	summary := CalculateCartSummary(cart)
+	return roundCents(summary.Total - summary.ServiceFee)
+}
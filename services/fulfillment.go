@@ -0,0 +1,149 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"checkout/config"
+	"checkout/i18n"
+	"checkout/templates"
+)
+
+// ticketTextWidth is the number of characters per line of a text ticket, the width of an 80 mm
+// ESC/POS printer in its default font
+const ticketTextWidth = 42
+
+// orderNumberSequence is the last order number issued and the business day it was issued on
+type orderNumberSequence struct {
+	Day        string `json:"day"`
+	LastNumber int    `json:"lastNumber"`
+}
+
+// orderNumberMutex serializes order numbers, so two sales finishing at once don't share one
+var orderNumberMutex sync.Mutex
+
+// NextOrderNumber issues the next order number of the current business day. Numbers start again
+// at 1 each business day and are kept in the data directory, so a restart doesn't repeat one.
+func NextOrderNumber() (int, error) {
+	orderNumberMutex.Lock()
+	defer orderNumberMutex.Unlock()
+
+	path := getOrderNumberPath()
+	var sequence orderNumberSequence
+	data, err := os.ReadFile(path)
+	if err == nil {
+		if err := json.Unmarshal(data, &sequence); err != nil {
+			return 0, fmt.Errorf("error parsing order number sequence: %w", err)
+		}
+	} else if !os.IsNotExist(err) {
+		return 0, fmt.Errorf("error reading order number sequence: %w", err)
+	}
+
+	today := config.BusinessDay(time.Now()).Format("2006-01-02")
+	if sequence.Day != today {
+		sequence = orderNumberSequence{Day: today}
+	}
+	sequence.LastNumber++
+	if err := writeJSONFile(path, sequence); err != nil {
+		return 0, fmt.Errorf("error saving order number sequence: %w", err)
+	}
+	return sequence.LastNumber, nil
+}
+
+// TicketLine is one line of a fulfillment ticket: identical items are counted together
+type TicketLine struct {
+	Name     string
+	Note     string // Description the cashier wrote for the item ("" if none)
+	Quantity int
+	Measure  string // Quantity and unit of an item sold by weight or length, e.g. "1.25 lb" ("" for whole items)
+}
+
+// QuantityText is the ticket's quantity column, e.g. " 2 x" or "1.25 lb"
+func (l TicketLine) QuantityText() string {
+	if l.Measure != "" {
+		return l.Measure
+	}
+	return fmt.Sprintf("%2d x", l.Quantity)
+}
+
+// TicketLines lists the items of a sale to prepare, in the order they were rung up. Identical
+// items with the same note are counted on one line, measured items each get their own; returned
+// items are left out.
+func TicketLines(transaction *templates.Transaction) []TicketLine {
+	var lines []TicketLine
+	for _, product := range transaction.Products {
+		if product.ReturnOf != "" || product.GiftCard {
+			continue
+		}
+		note := ""
+		if product.DescriptionEdited {
+			note = product.Description
+		}
+
+		if IsMeasuredLine(product) {
+			lines = append(lines, TicketLine{Name: product.Name, Note: note, Quantity: 1,
+				Measure: FormatQuantity(product.Quantity) + " " + product.UnitType})
+			continue
+		}
+
+		counted := false
+		for i := range lines {
+			if lines[i].Name == product.Name && lines[i].Note == note {
+				lines[i].Quantity++
+				counted = true
+				break
+			}
+		}
+		if !counted {
+			lines = append(lines, TicketLine{Name: product.Name, Note: note, Quantity: 1})
+		}
+	}
+	return lines
+}
+
+// FormatTicketText renders a fulfillment ticket as plain text for a receipt printer relay
+func FormatTicketText(transaction *templates.Transaction) string {
+	var b strings.Builder
+	divider := strings.Repeat("-", ticketTextWidth)
+
+	if transaction.OrderNumber > 0 {
+		fmt.Fprintln(&b, i18n.T("ticket.order_number", transaction.OrderNumber))
+	}
+	fmt.Fprintln(&b, ReceiptDateTime(transaction))
+	fmt.Fprintln(&b, divider)
+	for _, line := range TicketLines(transaction) {
+		for _, text := range wrapText(line.QuantityText()+" "+line.Name, ticketTextWidth) {
+			fmt.Fprintln(&b, text)
+		}
+		if line.Note != "" {
+			for _, text := range wrapText(line.Note, ticketTextWidth-5) {
+				fmt.Fprintln(&b, "     "+text)
+			}
+		}
+	}
+	if transaction.Note != "" {
+		fmt.Fprintln(&b, divider)
+		for _, text := range wrapText(i18n.T("receipt.note", transaction.Note), ticketTextWidth) {
+			fmt.Fprintln(&b, text)
+		}
+	}
+	fmt.Fprintln(&b, divider)
+	return b.String()
+}
+
+func getOrderNumberPath() string {
+	// Practice sales in demo mode must not use up the day's real order numbers
+	if config.Config.DemoMode {
+		return filepath.Join(demoDataDir(), "order-number.json")
+	}
+	dataDir := config.Config.DataDir
+	if dataDir == "" {
+		dataDir = config.DefaultDataDir
+	}
+	return filepath.Join(dataDir, "order-number.json")
+}
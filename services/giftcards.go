@@ -0,0 +1,537 @@
+package services
+
+import (
+	"crypto/rand"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"checkout/config"
+	"checkout/templates"
+	"checkout/utils"
+)
+
+// GiftCardPaymentMethod is the tender type for store credit redeemed toward a sale
+const GiftCardPaymentMethod = "gift_card"
+
+// Gift card statuses
+const (
+	GiftCardActive    = "active"
+	GiftCardCancelled = "cancelled" // The sale that issued the card was voided
+)
+
+// Gift card ledger entry types
+const (
+	GiftCardIssue  = "issue"  // Balance loaded by a sale
+	GiftCardRedeem = "redeem" // Balance applied to a sale
+	GiftCardCredit = "credit" // Redemption given back (voided tender or refunded return)
+	GiftCardVoid   = "void"   // Load taken back because its sale was voided
+)
+
+// giftCardCodeAlphabet leaves out characters that are easy to misread (0/O, 1/I)
+const giftCardCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+var giftCardLedgerHeader = []string{"Date", "Time", "Code", "Type", "Amount", "Balance", "Reference"}
+
+// giftCards holds card balances (code -> card). Every balance change takes the mutex,
+// so two registers can't redeem the same card at once.
+var giftCards = struct {
+	cards  map[string]*templates.GiftCard
+	loaded bool
+	mutex  sync.Mutex
+}{
+	cards: make(map[string]*templates.GiftCard),
+}
+
+// NewGiftCardCode returns an unused code in the form XXXX-XXXX-XXXX-XXXX
+func NewGiftCardCode() (string, error) {
+	giftCards.mutex.Lock()
+	defer giftCards.mutex.Unlock()
+	if err := ensureGiftCardsLoaded(); err != nil {
+		return "", err
+	}
+
+	for {
+		var raw strings.Builder
+		for i := 0; i < 16; i++ {
+			n, err := rand.Int(rand.Reader, big.NewInt(int64(len(giftCardCodeAlphabet))))
+			if err != nil {
+				return "", fmt.Errorf("error generating gift card code: %w", err)
+			}
+			raw.WriteByte(giftCardCodeAlphabet[n.Int64()])
+		}
+		code := NormalizeGiftCardCode(raw.String())
+		if _, exists := giftCards.cards[code]; !exists {
+			return code, nil
+		}
+	}
+}
+
+// NormalizeGiftCardCode uppercases a typed or scanned code and regroups it with dashes
+func NormalizeGiftCardCode(code string) string {
+	var raw strings.Builder
+	for _, c := range strings.ToUpper(code) {
+		if (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') {
+			raw.WriteRune(c)
+		}
+	}
+
+	var grouped strings.Builder
+	for i, c := range raw.String() {
+		if i > 0 && i%4 == 0 {
+			grouped.WriteByte('-')
+		}
+		grouped.WriteRune(c)
+	}
+	return grouped.String()
+}
+
+// GetGiftCard looks up a card by code
+func GetGiftCard(code string) (templates.GiftCard, error) {
+	giftCards.mutex.Lock()
+	defer giftCards.mutex.Unlock()
+	if err := ensureGiftCardsLoaded(); err != nil {
+		return templates.GiftCard{}, err
+	}
+
+	card, exists := giftCards.cards[NormalizeGiftCardCode(code)]
+	if !exists {
+		return templates.GiftCard{}, fmt.Errorf("gift card %s not found", code)
+	}
+	return *card, nil
+}
+
+// GiftCardHistory returns a card's ledger entries, oldest first
+func GiftCardHistory(code string) ([]templates.GiftCardEntry, error) {
+	giftCards.mutex.Lock()
+	defer giftCards.mutex.Unlock()
+	return readGiftCardLedger(NormalizeGiftCardCode(code), "")
+}
+
+// IssueGiftCards loads the gift cards sold in a successful sale. Cards are created on their
+// first load. Each card is loaded at most once per sale, so logging a sale twice is harmless.
+func IssueGiftCards(reference string, cart []templates.Product) error {
+	giftCards.mutex.Lock()
+	defer giftCards.mutex.Unlock()
+	if err := ensureGiftCardsLoaded(); err != nil {
+		return err
+	}
+
+	// The same card can be in the cart more than once
+	var codes []string
+	amounts := make(map[string]float64)
+	for _, product := range cart {
+		if product.GiftCardCode == "" || product.Price <= 0 {
+			continue
+		}
+		code := NormalizeGiftCardCode(product.GiftCardCode)
+		if _, exists := amounts[code]; !exists {
+			codes = append(codes, code)
+		}
+		amounts[code] += product.Price
+	}
+
+	var errs []string
+	for _, code := range codes {
+		amount := roundCents(amounts[code])
+
+		earlier, err := readGiftCardLedger(code, reference)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		if len(earlier) > 0 {
+			utils.Debug("giftcard", "Gift card already loaded for sale", "code", GiftCardLabel(code), "reference", reference)
+			continue
+		}
+
+		card, exists := giftCards.cards[code]
+		if !exists {
+			card = &templates.GiftCard{
+				Code:       code,
+				IssuedDate: time.Now().Format("01/02/2006"),
+				Status:     GiftCardActive,
+			}
+			giftCards.cards[code] = card
+		} else if card.Status != GiftCardActive {
+			errs = append(errs, fmt.Sprintf("gift card %s is %s", GiftCardLabel(code), card.Status))
+			continue
+		}
+
+		if err := changeGiftCardBalance(card, GiftCardIssue, amount, reference); err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		utils.Info("audit", "Gift card loaded", "code", GiftCardLabel(code), "amount", amount, "balance", card.Balance, "reference", reference)
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("error loading gift cards: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// NewGiftCardLine prepares a gift card product for the cart. A blank code issues a new card;
+// otherwise the sale reloads the existing card.
+func NewGiftCardLine(product templates.Product, code string) (templates.Product, error) {
+	if code == "" {
+		var err error
+		if code, err = NewGiftCardCode(); err != nil {
+			return templates.Product{}, err
+		}
+	} else {
+		card, err := GetGiftCard(code)
+		if err != nil {
+			return templates.Product{}, err
+		}
+		if card.Status != GiftCardActive {
+			return templates.Product{}, fmt.Errorf("gift card %s is %s", GiftCardLabel(card.Code), card.Status)
+		}
+		code = card.Code
+	}
+
+	product.GiftCardCode = code
+	product.Description = "Gift card " + GiftCardLabel(code)
+	return product, nil
+}
+
+// RedeemGiftCard applies up to maxAmount of a card's balance to a sale and returns the amount applied.
+// reference identifies the tender so the redemption can be given back later.
+func RedeemGiftCard(code string, maxAmount float64, reference string) (float64, error) {
+	giftCards.mutex.Lock()
+	defer giftCards.mutex.Unlock()
+	if err := ensureGiftCardsLoaded(); err != nil {
+		return 0, err
+	}
+
+	code = NormalizeGiftCardCode(code)
+	card, exists := giftCards.cards[code]
+	if !exists {
+		return 0, fmt.Errorf("gift card %s not found", code)
+	}
+	if card.Status != GiftCardActive {
+		return 0, fmt.Errorf("gift card %s is %s", GiftCardLabel(code), card.Status)
+	}
+	if card.Balance < 0.005 {
+		return 0, fmt.Errorf("gift card %s has no balance left", GiftCardLabel(code))
+	}
+
+	amount := roundCents(math.Min(card.Balance, maxAmount))
+	if amount <= 0 {
+		return 0, fmt.Errorf("nothing to charge to gift card %s", code)
+	}
+	if err := changeGiftCardBalance(card, GiftCardRedeem, -amount, reference); err != nil {
+		return 0, err
+	}
+
+	utils.Info("audit", "Gift card redeemed", "code", GiftCardLabel(code), "amount", amount, "balance", card.Balance, "reference", reference)
+	return amount, nil
+}
+
+// CreditGiftCardRedemption gives back up to amount of a redemption to its card, never more
+// than was redeemed under reference. Returns a short description of the credit for the transaction log.
+func CreditGiftCardRedemption(reference string, amount float64) (string, error) {
+	giftCards.mutex.Lock()
+	defer giftCards.mutex.Unlock()
+	if err := ensureGiftCardsLoaded(); err != nil {
+		return "", err
+	}
+
+	entries, err := readGiftCardLedger("", reference)
+	if err != nil {
+		return "", err
+	}
+	var code string
+	redeemed := 0.0
+	for _, entry := range entries {
+		switch entry.Type {
+		case GiftCardRedeem:
+			code = entry.Code
+			redeemed -= entry.Amount
+		case GiftCardCredit:
+			redeemed -= entry.Amount
+		}
+	}
+	if code == "" {
+		return "", fmt.Errorf("no gift card redemption found for %s", reference)
+	}
+
+	card, exists := giftCards.cards[code]
+	if !exists {
+		return "", fmt.Errorf("gift card %s not found", GiftCardLabel(code))
+	}
+	amount = roundCents(math.Min(amount, redeemed))
+	if amount <= 0 {
+		return "", fmt.Errorf("gift card redemption %s was already credited back", reference)
+	}
+	if err := changeGiftCardBalance(card, GiftCardCredit, amount, reference); err != nil {
+		return "", err
+	}
+
+	utils.Info("audit", "Gift card credited", "code", GiftCardLabel(code), "amount", amount, "balance", card.Balance, "reference", reference)
+	return fmt.Sprintf("credited $%.2f to gift card %s", amount, GiftCardLabel(code)), nil
+}
+
+// CheckGiftCardLoadsReversible reports an error if a sale loaded gift cards whose balance
+// has since been spent, since voiding the sale would take those cards below zero
+func CheckGiftCardLoadsReversible(reference string) error {
+	giftCards.mutex.Lock()
+	defer giftCards.mutex.Unlock()
+	if err := ensureGiftCardsLoaded(); err != nil {
+		return err
+	}
+
+	loads, err := giftCardLoads(reference)
+	if err != nil {
+		return err
+	}
+	for code, amount := range loads {
+		if card, exists := giftCards.cards[code]; exists && card.Balance+0.005 < amount {
+			return fmt.Errorf("gift card %s from this sale has already been used", GiftCardLabel(code))
+		}
+	}
+	return nil
+}
+
+// ReverseGiftCardLoads takes back the gift card loads of a voided sale. Cards first issued by
+// the sale are cancelled; reloaded cards keep their earlier balance.
+func ReverseGiftCardLoads(reference string) error {
+	giftCards.mutex.Lock()
+	defer giftCards.mutex.Unlock()
+	if err := ensureGiftCardsLoaded(); err != nil {
+		return err
+	}
+
+	loads, err := giftCardLoads(reference)
+	if err != nil {
+		return err
+	}
+
+	var errs []string
+	for code, amount := range loads {
+		card, exists := giftCards.cards[code]
+		if !exists {
+			continue
+		}
+		// Never take a card below zero, even if it was spent after the void was checked
+		amount = roundCents(math.Min(amount, card.Balance))
+		if err := changeGiftCardBalance(card, GiftCardVoid, -amount, reference); err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+
+		history, err := readGiftCardLedger(code, "")
+		if err == nil && len(history) > 0 && history[0].Reference == reference {
+			card.Status = GiftCardCancelled
+			if err := saveGiftCards(); err != nil {
+				errs = append(errs, err.Error())
+			}
+		}
+		utils.Info("audit", "Gift card load voided", "code", GiftCardLabel(code), "amount", amount, "balance", card.Balance, "status", card.Status, "reference", reference)
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("error reversing gift card loads: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// giftCardLoads totals the amount each card was loaded with by a sale (code -> amount)
+func giftCardLoads(reference string) (map[string]float64, error) {
+	entries, err := readGiftCardLedger("", reference)
+	if err != nil {
+		return nil, err
+	}
+	loads := make(map[string]float64)
+	for _, entry := range entries {
+		if entry.Type == GiftCardIssue || entry.Type == GiftCardVoid {
+			loads[entry.Code] = roundCents(loads[entry.Code] + entry.Amount)
+		}
+	}
+	for code, amount := range loads {
+		if amount <= 0 {
+			delete(loads, code)
+		}
+	}
+	return loads, nil
+}
+
+// changeGiftCardBalance applies a balance change, appends it to the ledger and saves the cards.
+// Callers must hold giftCards.mutex.
+func changeGiftCardBalance(card *templates.GiftCard, entryType string, amount float64, reference string) error {
+	balance := roundCents(card.Balance + amount)
+	if balance < 0 {
+		return fmt.Errorf("gift card %s balance can't go below zero", GiftCardLabel(card.Code))
+	}
+
+	now := time.Now()
+	entry := templates.GiftCardEntry{
+		Date:      now.Format("01/02/2006"),
+		Time:      now.Format("15:04:05"),
+		Code:      card.Code,
+		Type:      entryType,
+		Amount:    roundCents(amount),
+		Balance:   balance,
+		Reference: reference,
+	}
+	// Log first so every balance on disk can be traced to a ledger entry
+	if err := appendGiftCardLedger(entry); err != nil {
+		return err
+	}
+
+	card.Balance = balance
+	return saveGiftCards()
+}
+
+// ensureGiftCardsLoaded reads the cards on first use (config is not loaded yet at package init).
+// Callers must hold giftCards.mutex.
+func ensureGiftCardsLoaded() error {
+	if giftCards.loaded {
+		return nil
+	}
+
+	data, err := os.ReadFile(getGiftCardsFilePath())
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("error reading gift cards file: %w", err)
+	}
+	if err == nil {
+		var cards []templates.GiftCard
+		if err := json.Unmarshal(data, &cards); err != nil {
+			return fmt.Errorf("error parsing gift cards file: %w", err)
+		}
+		for i := range cards {
+			giftCards.cards[cards[i].Code] = &cards[i]
+		}
+	}
+
+	giftCards.loaded = true
+	return nil
+}
+
+// saveGiftCards writes every card to the data directory. Callers must hold giftCards.mutex.
+func saveGiftCards() error {
+	cards := make([]templates.GiftCard, 0, len(giftCards.cards))
+	for _, card := range giftCards.cards {
+		cards = append(cards, *card)
+	}
+
+	jsonData, err := json.MarshalIndent(cards, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error marshaling gift cards: %w", err)
+	}
+
+	path := getGiftCardsFilePath()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("error creating data directory: %w", err)
+	}
+
+	if err := os.WriteFile(path, jsonData, 0644); err != nil {
+		return fmt.Errorf("error writing gift cards file: %w", err)
+	}
+	return nil
+}
+
+// appendGiftCardLedger appends an entry to the gift card ledger, writing the header for a new file
+func appendGiftCardLedger(entry templates.GiftCardEntry) error {
+	path := getGiftCardLedgerPath()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("error creating data directory: %w", err)
+	}
+
+	_, statErr := os.Stat(path)
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("error opening gift card ledger: %w", err)
+	}
+	defer file.Close()
+
+	writer := csv.NewWriter(file)
+	if os.IsNotExist(statErr) {
+		if err := writer.Write(giftCardLedgerHeader); err != nil {
+			return fmt.Errorf("error writing gift card ledger header: %w", err)
+		}
+	}
+	if err := writer.Write([]string{
+		entry.Date,
+		entry.Time,
+		entry.Code,
+		entry.Type,
+		fmt.Sprintf("%.2f", entry.Amount),
+		fmt.Sprintf("%.2f", entry.Balance),
+		entry.Reference,
+	}); err != nil {
+		return fmt.Errorf("error writing gift card ledger: %w", err)
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// readGiftCardLedger returns ledger entries matching a code and/or reference ("" matches any)
+func readGiftCardLedger(code, reference string) ([]templates.GiftCardEntry, error) {
+	file, err := os.Open(getGiftCardLedgerPath())
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("error opening gift card ledger: %w", err)
+	}
+	defer file.Close()
+
+	records, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("error reading gift card ledger: %w", err)
+	}
+
+	var entries []templates.GiftCardEntry
+	for i, record := range records {
+		if i == 0 || len(record) < len(giftCardLedgerHeader) {
+			continue // Header or malformed row
+		}
+		if (code != "" && record[2] != code) || (reference != "" && record[6] != reference) {
+			continue
+		}
+		amount, _ := strconv.ParseFloat(record[4], 64)
+		balance, _ := strconv.ParseFloat(record[5], 64)
+		entries = append(entries, templates.GiftCardEntry{
+			Date:      record[0],
+			Time:      record[1],
+			Code:      record[2],
+			Type:      record[3],
+			Amount:    amount,
+			Balance:   balance,
+			Reference: record[6],
+		})
+	}
+	return entries, nil
+}
+
+// GiftCardLabel masks a code down to its last group for logs and receipts (e.g. "****-7KQ2")
+func GiftCardLabel(code string) string {
+	if len(code) <= 4 {
+		return code
+	}
+	return "****-" + code[len(code)-4:]
+}
+
+func getGiftCardsFilePath() string {
+	dataDir := config.Config.DataDir
+	if dataDir == "" {
+		dataDir = config.DefaultDataDir
+	}
+	return filepath.Join(dataDir, "gift-cards.json")
+}
+
+func getGiftCardLedgerPath() string {
+	dataDir := config.Config.DataDir
+	if dataDir == "" {
+		dataDir = config.DefaultDataDir
+	}
+	return filepath.Join(dataDir, "gift-card-ledger.csv")
+}
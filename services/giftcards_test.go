@@ -0,0 +1,94 @@
+package services
+
+import (
+	"strings"
+	"testing"
+
+	"checkout/templates"
+)
+
+// useFreshGiftCards starts the test with no cards loaded, in an empty data directory
+func useFreshGiftCards(t *testing.T) {
+	t.Helper()
+	useTempDataDir(t)
+	giftCards.mutex.Lock()
+	giftCards.cards, giftCards.loaded = make(map[string]*templates.GiftCard), false
+	giftCards.mutex.Unlock()
+}
+
+func TestGiftCardLabel(t *testing.T) {
+	tests := []struct{ code, want string }{
+		{"ABCD-EFGH-JKLM-7KQ2", "****-7KQ2"},
+		{"7KQ2", "7KQ2"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := GiftCardLabel(tt.code); got != tt.want {
+			t.Errorf("GiftCardLabel(%q) = %q, want %q", tt.code, got, tt.want)
+		}
+	}
+}
+
+// The full code spends the card, so only its last group may reach logs and line descriptions
+func TestGiftCardCodeIsMaskedInLogsAndDescriptions(t *testing.T) {
+	useFreshGiftCards(t)
+	logs := captureLogs(t)
+
+	line, err := NewGiftCardLine(templates.Product{ID: "gift", Name: "Gift Card", Price: 50}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	code := line.GiftCardCode
+	if line.Description != "Gift card "+GiftCardLabel(code) {
+		t.Errorf("description = %q, want the masked code", line.Description)
+	}
+
+	if err := IssueGiftCards("pi_sale", []templates.Product{line}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := RedeemGiftCard(code, 20, "pi_redeem"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := CreditGiftCardRedemption("pi_redeem", 20); err != nil {
+		t.Fatal(err)
+	}
+	if err := ReverseGiftCardLoads("pi_sale"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, message := range []string{"Gift card loaded", "Gift card redeemed", "Gift card credited", "Gift card load voided"} {
+		if !strings.Contains(logs.String(), message) {
+			t.Errorf("no %q audit line", message)
+		}
+	}
+	if strings.Contains(logs.String(), code) {
+		t.Errorf("full gift card code %s written to the log:\n%s", code, logs)
+	}
+	if !strings.Contains(logs.String(), GiftCardLabel(code)) {
+		t.Errorf("audit lines don't name the card by its masked code")
+	}
+}
+
+func TestRedeemGiftCardNeverGoesNegative(t *testing.T) {
+	useFreshGiftCards(t)
+
+	line, err := NewGiftCardLine(templates.Product{ID: "gift", Name: "Gift Card", Price: 25}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := IssueGiftCards("pi_sale", []templates.Product{line}); err != nil {
+		t.Fatal(err)
+	}
+
+	applied, err := RedeemGiftCard(line.GiftCardCode, 40, "pi_first")
+	if err != nil || applied != 25 {
+		t.Fatalf("RedeemGiftCard() = %v, %v, want the whole 25 balance", applied, err)
+	}
+	if _, err := RedeemGiftCard(line.GiftCardCode, 10, "pi_second"); err == nil {
+		t.Error("redeemed an empty card")
+	}
+	card, err := GetGiftCard(line.GiftCardCode)
+	if err != nil || card.Balance != 0 {
+		t.Errorf("balance = %v, %v, want 0", card.Balance, err)
+	}
+}
@@ -0,0 +1,84 @@
+package services
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"checkout/utils"
+)
+
+// How long a Stripe connectivity check is reused before Stripe is asked again
+const stripeHealthCacheTTL = 60 * time.Second
+
+// HealthCheck is the result of one dependency check. A check with a warning is still OK: the
+// POS works, but needs attention soon.
+type HealthCheck struct {
+	OK        bool      `json:"ok"`
+	Error     string    `json:"error,omitempty"`
+	Warning   string    `json:"warning,omitempty"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+// stripeHealth caches the last Stripe connectivity check so health probes don't hit the API
+var stripeHealth = struct {
+	last  HealthCheck
+	mutex sync.Mutex
+}{}
+
+// CheckStripeHealth reports whether the Stripe API is reachable with the configured key.
+// The result is cached for stripeHealthCacheTTL.
+func CheckStripeHealth() HealthCheck {
+	stripeHealth.mutex.Lock()
+	defer stripeHealth.mutex.Unlock()
+
+	if time.Since(stripeHealth.last.CheckedAt) < stripeHealthCacheTTL {
+		return stripeHealth.last
+	}
+
+	check := HealthCheck{OK: true, CheckedAt: time.Now()}
+	if _, err := Stripe.GetBalance(); err != nil {
+		utils.Warn("health", "Stripe health check failed", "error", err)
+		check.OK = false
+		check.Error = err.Error()
+	}
+	stripeHealth.last = check
+	return check
+}
+
+// CheckStripeAccountHealth reports the last daily check of the Stripe account. A restriction is a
+// warning, not a failure: payments may still go through until Stripe enforces it.
+func CheckStripeAccountHealth() HealthCheck {
+	status := StripeAccountStatus()
+	check := HealthCheck{OK: true, CheckedAt: status.CheckedAt}
+	switch {
+	case status.Error != "":
+		check.Warning = "account not checked: " + status.Error
+	case status.Restricted():
+		check.Warning = "account restricted: " + strings.Join(status.Problems, ", ")
+	}
+	return check
+}
+
+// CheckTransactionsDirHealth reports whether transaction logs can be written
+func CheckTransactionsDirHealth() HealthCheck {
+	check := HealthCheck{OK: true, CheckedAt: time.Now()}
+
+	file, err := os.CreateTemp(getTransactionsDir(), ".healthcheck-*")
+	if err == nil {
+		name := file.Name()
+		_, err = file.WriteString("ok")
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		_ = os.Remove(name)
+	}
+	if err != nil {
+		utils.Warn("health", "Transactions directory is not writable", "dir", getTransactionsDir(), "error", err)
+		check.OK = false
+		check.Error = fmt.Sprintf("transactions directory not writable: %v", err)
+	}
+	return check
+}
@@ -0,0 +1,37 @@
+package services
+
+import (
+	"bytes"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"checkout/config"
+	"checkout/utils"
+)
+
+// useTempDataDir points the data and transaction directories at a directory removed after the test
+func useTempDataDir(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	saved := config.Config
+	config.Config.DataDir = dir
+	config.Config.TransactionsDir = filepath.Join(dir, "transactions")
+	if err := os.MkdirAll(config.Config.TransactionsDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { config.Config = saved })
+	return dir
+}
+
+// captureLogs sends log lines at every level to the returned buffer until the test ends
+func captureLogs(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := utils.ConfigureLogging(utils.LogOptions{Level: slog.LevelDebug, Format: "json", Console: &buf}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { utils.ConfigureLogging(utils.LogOptions{}) })
+	return &buf
+}
@@ -0,0 +1,113 @@
+package services
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"checkout/config"
+	"checkout/i18n"
+	"checkout/templates"
+)
+
+// UnitEach sells a product as whole items; a product with any other unit type is measured
+const UnitEach = "each"
+
+// MaxMeasuredQuantity is the largest quantity a measured line accepts, catching a barcode or a
+// price typed into the quantity field
+const MaxMeasuredQuantity = 10000
+
+// SoldByMeasure reports whether a product is sold by weight or length, with its price per unit
+func SoldByMeasure(product templates.Product) bool {
+	unit := strings.TrimSpace(product.UnitType)
+	return unit != "" && !strings.EqualFold(unit, UnitEach)
+}
+
+// IsMeasuredLine reports whether a cart line or logged item carries a measured quantity
+func IsMeasuredLine(product templates.Product) bool {
+	return SoldByMeasure(product) && product.Quantity > 0
+}
+
+// MeasuredLine prepares a measured product for the cart. The catalog price is the price per
+// unit; the line's price is that times the quantity, rounded to cents, so tax and totals need
+// nothing else.
+func MeasuredLine(product templates.Product, quantity float64) templates.Product {
+	product.UnitPrice = product.Price
+	product.Quantity = quantity
+	product.Price = roundCents(product.UnitPrice * quantity)
+	return product
+}
+
+// SetMeasuredUnitPrice changes the price per unit of a measured line and reprices the line
+func SetMeasuredUnitPrice(line *templates.Product, unitPrice float64) {
+	line.UnitPrice = unitPrice
+	line.Price = roundCents(unitPrice * line.Quantity)
+}
+
+// QuantityDecimals returns the decimal places of a measured quantity
+func QuantityDecimals() int {
+	return min(max(config.Config.QuantityDecimals, 0), 4)
+}
+
+// QuantityStep is the step of the quantity input, e.g. "0.01" for two decimals
+func QuantityStep() string {
+	decimals := QuantityDecimals()
+	if decimals == 0 {
+		return "1"
+	}
+	return "0." + strings.Repeat("0", decimals-1) + "1"
+}
+
+// FormatQuantity formats a measured quantity with the configured decimals, e.g. "1.25"
+func FormatQuantity(quantity float64) string {
+	return strconv.FormatFloat(quantity, 'f', QuantityDecimals(), 64)
+}
+
+// CatalogPrice is a product's price as shown on its tile, e.g. "$3.99/lb" for a measured
+// product. A product on sale shows its sale price.
+func CatalogPrice(product templates.Product) string {
+	return formatCatalogPrice(product, EffectivePrice(product, time.Now()))
+}
+
+// formatCatalogPrice formats a price of a product, per unit for a measured product
+func formatCatalogPrice(product templates.Product, price float64) string {
+	if SoldByMeasure(product) {
+		return i18n.T("products.price_per_unit", i18n.Money(price), product.UnitType)
+	}
+	return i18n.Money(price)
+}
+
+// MeasuredDetail describes a measured line for the cart and receipts, e.g. "1.25 lb @ $3.99/lb"
+func MeasuredDetail(product templates.Product) string {
+	return i18n.T("cart.measured_detail", FormatQuantity(product.Quantity), product.UnitType, i18n.Money(product.UnitPrice))
+}
+
+// measuredLogValues returns the transaction log's quantity and description of a measured line:
+// the signed quantity, and the description with the quantity and unit appended, e.g. "(1.25 lb)"
+func measuredLogValues(product templates.Product) (string, string) {
+	quantity := FormatQuantity(product.Quantity)
+	description := strings.TrimSpace(product.Description + " (" + quantity + " " + product.UnitType + ")")
+	if product.Price < 0 {
+		quantity = "-" + quantity
+	}
+	return quantity, description
+}
+
+// restoreMeasuredLine reads a logged measured line back: a row whose description ends with its
+// quantity and unit (see measuredLogValues) gets its unit, quantity and price per unit back.
+// Whole items are logged with quantity 1, so a description like "Tea (1 box)" is left alone.
+func restoreMeasuredLine(product *templates.Product, quantity string, unitPrice float64) {
+	quantity = strings.TrimPrefix(quantity, "-")
+	marker := "(" + quantity + " "
+	open := strings.LastIndex(product.Description, marker)
+	if quantity == "1" || open < 0 || !strings.HasSuffix(product.Description, ")") {
+		return
+	}
+	value, err := strconv.ParseFloat(quantity, 64)
+	unit := product.Description[open+len(marker) : len(product.Description)-1]
+	if err != nil || value <= 0 || unit == "" || strings.ContainsAny(unit, "()") {
+		return
+	}
+	product.Description = strings.TrimSpace(product.Description[:open])
+	product.UnitType, product.Quantity, product.UnitPrice = unit, value, unitPrice
+}
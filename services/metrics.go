@@ -0,0 +1,259 @@
+package services
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Payment duration histogram buckets in seconds (payments time out after 120s)
+var paymentDurationBuckets = []float64{1, 2, 5, 10, 15, 30, 60, 90, 120, 180}
+
+// durationHistogram counts observations per bucket; buckets are made cumulative when written
+type durationHistogram struct {
+	buckets []uint64
+	count   uint64
+	sum     float64
+}
+
+// metrics holds the counters and gauges served on /metrics in the Prometheus text format
+var metrics = struct {
+	paymentsStarted   map[string]uint64    // method
+	paymentsCompleted map[[2]string]uint64 // method, outcome
+	paymentDurations  map[[2]string]*durationHistogram
+	webhookEvents     map[string]uint64    // event type
+	webhookMismatches map[string]uint64    // event livemode
+	statusChecks      map[[2]string]uint64 // strategy, source
+	stripeErrors      map[[2]string]uint64 // endpoint, status
+	activePayments    map[string]int       // payment type
+	activeSSE         int
+	mutex             sync.Mutex
+}{
+	paymentsStarted:   make(map[string]uint64),
+	paymentsCompleted: make(map[[2]string]uint64),
+	paymentDurations:  make(map[[2]string]*durationHistogram),
+	webhookEvents:     make(map[string]uint64),
+	webhookMismatches: make(map[string]uint64),
+	statusChecks:      make(map[[2]string]uint64),
+	stripeErrors:      make(map[[2]string]uint64),
+	activePayments:    make(map[string]int),
+}
+
+// RecordPaymentStarted counts a payment attempt by method
+func RecordPaymentStarted(method string) {
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+	metrics.paymentsStarted[method]++
+}
+
+// RecordPaymentCompleted counts a payment reaching an outcome (success, failed, cancelled, expired).
+// If the payment's start time is known its duration is added to the histogram.
+func RecordPaymentCompleted(method, outcome string, startTime time.Time) {
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+
+	key := [2]string{method, outcome}
+	metrics.paymentsCompleted[key]++
+
+	if startTime.IsZero() {
+		return
+	}
+	histogram, exists := metrics.paymentDurations[key]
+	if !exists {
+		histogram = &durationHistogram{buckets: make([]uint64, len(paymentDurationBuckets))}
+		metrics.paymentDurations[key] = histogram
+	}
+	seconds := time.Since(startTime).Seconds()
+	for i, bound := range paymentDurationBuckets {
+		if seconds <= bound {
+			histogram.buckets[i]++
+			break
+		}
+	}
+	histogram.count++
+	histogram.sum += seconds
+}
+
+// RecordWebhookEvent counts a verified Stripe webhook event by type
+func RecordWebhookEvent(eventType string) {
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+	metrics.webhookEvents[eventType]++
+}
+
+// RecordWebhookLivemodeMismatch counts a webhook event rejected because it came from the other
+// Stripe mode (a test event on a live POS, or a live event on a test POS)
+func RecordWebhookLivemodeMismatch(eventLivemode bool) {
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+	metrics.webhookMismatches[strconv.FormatBool(eventLivemode)]++
+}
+
+// RecordStatusCheck counts a payment status check by communication strategy and where the
+// status came from: "webhook_cache" when the webhook cache answered, "stripe_api" when Stripe was called
+func RecordStatusCheck(strategy, source string) {
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+	metrics.statusChecks[[2]string{strategy, source}]++
+}
+
+// SetActivePayments sets the number of payments of a type waiting on the customer
+func SetActivePayments(paymentType string, count int) {
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+	metrics.activePayments[paymentType] = count
+}
+
+// SetActiveSSEConnections sets the number of open payment event streams
+func SetActiveSSEConnections(count int) {
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+	metrics.activeSSE = count
+}
+
+// recordStripeAPIError counts a failed Stripe API request by endpoint and HTTP status
+func recordStripeAPIError(endpoint, status string) {
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+	metrics.stripeErrors[[2]string{endpoint, status}]++
+}
+
+// WriteMetrics writes all metrics in the Prometheus text exposition format
+func WriteMetrics(w io.Writer) error {
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+
+	var b strings.Builder
+
+	writeMetricHeader(&b, "checkout_payments_started_total", "Payments started, by payment method.", "counter")
+	for _, method := range sortedKeys(metrics.paymentsStarted) {
+		fmt.Fprintf(&b, "checkout_payments_started_total{method=%q} %d\n", method, metrics.paymentsStarted[method])
+	}
+
+	writeMetricHeader(&b, "checkout_payments_completed_total", "Payments that reached an outcome, by payment method and outcome.", "counter")
+	for _, key := range sortedPairKeys(metrics.paymentsCompleted) {
+		fmt.Fprintf(&b, "checkout_payments_completed_total{method=%q,outcome=%q} %d\n", key[0], key[1], metrics.paymentsCompleted[key])
+	}
+
+	writeMetricHeader(&b, "checkout_payment_duration_seconds", "Time from payment start to outcome.", "histogram")
+	for _, key := range sortedPairKeys(metrics.paymentDurations) {
+		histogram := metrics.paymentDurations[key]
+		labels := fmt.Sprintf("method=%q,outcome=%q", key[0], key[1])
+		var cumulative uint64
+		for i, bound := range paymentDurationBuckets {
+			cumulative += histogram.buckets[i]
+			fmt.Fprintf(&b, "checkout_payment_duration_seconds_bucket{%s,le=%q} %d\n", labels, strconv.FormatFloat(bound, 'f', -1, 64), cumulative)
+		}
+		fmt.Fprintf(&b, "checkout_payment_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", labels, histogram.count)
+		fmt.Fprintf(&b, "checkout_payment_duration_seconds_sum{%s} %g\n", labels, histogram.sum)
+		fmt.Fprintf(&b, "checkout_payment_duration_seconds_count{%s} %d\n", labels, histogram.count)
+	}
+
+	writeMetricHeader(&b, "checkout_active_payments", "Payments waiting on the customer, by payment type.", "gauge")
+	for _, paymentType := range sortedKeys(metrics.activePayments) {
+		fmt.Fprintf(&b, "checkout_active_payments{type=%q} %d\n", paymentType, metrics.activePayments[paymentType])
+	}
+
+	writeMetricHeader(&b, "checkout_sse_connections", "Open payment event streams.", "gauge")
+	fmt.Fprintf(&b, "checkout_sse_connections %d\n", metrics.activeSSE)
+
+	writeMetricHeader(&b, "checkout_webhook_events_total", "Verified Stripe webhook events received, by event type.", "counter")
+	for _, eventType := range sortedKeys(metrics.webhookEvents) {
+		fmt.Fprintf(&b, "checkout_webhook_events_total{type=%q} %d\n", eventType, metrics.webhookEvents[eventType])
+	}
+
+	writeMetricHeader(&b, "checkout_webhook_livemode_mismatches_total", "Webhook events rejected for coming from the other Stripe mode, by the event's livemode.", "counter")
+	for _, livemode := range sortedKeys(metrics.webhookMismatches) {
+		fmt.Fprintf(&b, "checkout_webhook_livemode_mismatches_total{livemode=%q} %d\n", livemode, metrics.webhookMismatches[livemode])
+	}
+
+	writeMetricHeader(&b, "checkout_payment_status_checks_total", "Payment status checks, by communication strategy and source (webhook_cache or stripe_api).", "counter")
+	for _, key := range sortedPairKeys(metrics.statusChecks) {
+		fmt.Fprintf(&b, "checkout_payment_status_checks_total{strategy=%q,source=%q} %d\n", key[0], key[1], metrics.statusChecks[key])
+	}
+
+	writeMetricHeader(&b, "checkout_stripe_api_errors_total", "Failed Stripe API requests, by endpoint and HTTP status (network for transport errors).", "counter")
+	for _, key := range sortedPairKeys(metrics.stripeErrors) {
+		fmt.Fprintf(&b, "checkout_stripe_api_errors_total{endpoint=%q,status=%q} %d\n", key[0], key[1], metrics.stripeErrors[key])
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// writeMetricHeader writes the HELP and TYPE lines for a metric
+func writeMetricHeader(b *strings.Builder, name, help, metricType string) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, metricType)
+}
+
+// sortedKeys returns a map's keys in order so scrapes are stable
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// sortedPairKeys returns a map's two-label keys in order
+func sortedPairKeys[V any](m map[[2]string]V) [][2]string {
+	keys := make([][2]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i][0] != keys[j][0] {
+			return keys[i][0] < keys[j][0]
+		}
+		return keys[i][1] < keys[j][1]
+	})
+	return keys
+}
+
+// stripeObjectID matches path segments that are object IDs (pi_3Mx..., tmr_FD...)
+var stripeObjectID = regexp.MustCompile(`^[a-z]+_[A-Za-z0-9]*[A-Z0-9][A-Za-z0-9]*$`)
+
+// StripeHTTPClient returns the HTTP client for the Stripe backend, instrumented to count API errors
+func StripeHTTPClient() *http.Client {
+	return &http.Client{
+		Timeout:   80 * time.Second, // stripe-go's default
+		Transport: stripeMetricsTransport{next: http.DefaultTransport},
+	}
+}
+
+// stripeMetricsTransport counts Stripe requests that fail or return an error status.
+// Every Stripe call goes through it, so new API calls are counted without extra hooks.
+type stripeMetricsTransport struct {
+	next http.RoundTripper
+}
+
+func (t stripeMetricsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		recordStripeAPIError(stripeEndpoint(req), "network")
+		return resp, err
+	}
+	if resp.StatusCode >= 400 {
+		recordStripeAPIError(stripeEndpoint(req), strconv.Itoa(resp.StatusCode))
+	}
+	return resp, nil
+}
+
+// stripeEndpoint returns the request path with object IDs replaced,
+// e.g. "POST /v1/terminal/readers/:id/process_payment_intent"
+func stripeEndpoint(req *http.Request) string {
+	segments := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+	for i, segment := range segments {
+		if stripeObjectID.MatchString(segment) {
+			segments[i] = ":id"
+		}
+	}
+	return req.Method + " /" + strings.Join(segments, "/")
+}
@@ -0,0 +1,173 @@
+package services
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/stripe/stripe-go/v74"
+
+	"checkout/templates"
+	"checkout/utils"
+)
+
+// MaxNoteLength is the longest sale note kept, in characters
+const MaxNoteLength = 200
+
+// MaxLineDescriptionLength is the longest cart line description kept, in characters (Stripe's limit for descriptions)
+const MaxLineDescriptionLength = 1000
+
+// NoteUpdateType marks payment update records that change a sale's note after payment
+const NoteUpdateType = "sale_note"
+
+// SanitizeNote cleans a cashier-entered sale note: control characters (including line breaks)
+// become spaces, runs of whitespace are collapsed, and the note is cut to MaxNoteLength.
+// Quotes and commas are left alone; the CSV writer quotes them.
+func SanitizeNote(note string) string {
+	return sanitizeCashierText(note, MaxNoteLength)
+}
+
+// SanitizeLineDescription cleans a cashier-entered cart line description the same way as a note,
+// cut to MaxLineDescriptionLength
+func SanitizeLineDescription(description string) string {
+	return sanitizeCashierText(description, MaxLineDescriptionLength)
+}
+
+// TruncateText shortens text to at most max characters, ending it with "…" when it is cut
+func TruncateText(text string, max int) string {
+	runes := []rune(text)
+	if len(runes) <= max {
+		return text
+	}
+	if max <= 1 {
+		return string(runes[:max])
+	}
+	return strings.TrimSpace(string(runes[:max-1])) + "…"
+}
+
+func sanitizeCashierText(text string, max int) string {
+	text = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return ' '
+		}
+		return r
+	}, text)
+	text = strings.Join(strings.Fields(text), " ")
+
+	if runes := []rune(text); len(runes) > max {
+		text = strings.TrimSpace(string(runes[:max]))
+	}
+	return text
+}
+
+// UpdateSaleNote changes the note of a logged sale. The original CSV row is left as it was;
+// the change is recorded as a payment update, and the Stripe payment metadata is updated
+// so the dashboard shows the same note. Returns the sanitized note.
+func UpdateSaleNote(client StripeClient, confirmationCode, note string) (string, error) {
+	note = SanitizeNote(note)
+
+	transaction, err := LoadTransactionByID(confirmationCode)
+	if err != nil {
+		return "", fmt.Errorf("sale %s not found: %w", confirmationCode, err)
+	}
+	if transaction.Note == note {
+		return note, nil
+	}
+
+	if err := SavePaymentUpdateRecord(CreatePaymentUpdateRecord(
+		confirmationCode, NoteUpdateType, transaction.Note, note, "notes", "pos_note_edit", "",
+	)); err != nil {
+		return "", fmt.Errorf("error recording note update: %w", err)
+	}
+
+	// The local record is what matters; a Stripe failure only leaves the dashboard behind
+	if strings.HasPrefix(transaction.ID, "pi_") || strings.HasPrefix(transaction.ID, "plink_") {
+		if err := updateStripeNote(client, transaction.ID, note); err != nil {
+			utils.Warn("payment", "Could not update note on Stripe payment", "confirmation_code", confirmationCode, "error", err)
+		}
+	}
+
+	utils.Info("payment", "Sale note updated", "confirmation_code", confirmationCode)
+	return note, nil
+}
+
+// updateStripeNote sets the note metadata of the PaymentIntent behind a payment (an empty note removes it)
+func updateStripeNote(client StripeClient, paymentID, note string) error {
+	intentID, err := resolvePaymentIntentID(client, paymentID)
+	if err != nil {
+		return err
+	}
+
+	params := &stripe.PaymentIntentParams{}
+	params.AddMetadata(MetadataNote, note)
+	_, err = withStripeRetry("paymentintent.Update", func() (*stripe.PaymentIntent, error) {
+		return client.UpdatePaymentIntent(intentID, params)
+	})
+	if err != nil {
+		return fmt.Errorf("error updating payment intent %s: %w", intentID, err)
+	}
+	return nil
+}
+
+// loadPaymentUpdates returns the records of every payment update log, oldest first
+func loadPaymentUpdates() ([]templates.PaymentUpdateRecord, error) {
+	files, err := globTransactionFiles("updates/payment-updates-*.json")
+	if err != nil {
+		return nil, fmt.Errorf("error listing payment update logs: %w", err)
+	}
+
+	// Files are named by date and appended in order, so later records win
+	sort.Strings(files)
+
+	var records []templates.PaymentUpdateRecord
+	for _, filename := range files {
+		if records, err = readPaymentUpdates(filename, records); err != nil {
+			return nil, fmt.Errorf("error reading %s: %w", filepath.Base(filename), err)
+		}
+	}
+	return records, nil
+}
+
+// readPaymentUpdates appends the records in one payment update log to records
+func readPaymentUpdates(filename string, records []templates.PaymentUpdateRecord) ([]templates.PaymentUpdateRecord, error) {
+	file, err := openTransactionFile(filename)
+	if err != nil {
+		return records, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var record templates.PaymentUpdateRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			continue // Skip a partially written line
+		}
+		records = append(records, record)
+	}
+	return records, scanner.Err()
+}
+
+// noteEdits returns the latest edited note of every sale whose note was changed after payment
+func noteEdits(updates []templates.PaymentUpdateRecord) map[string]string {
+	notes := make(map[string]string)
+	for _, record := range updates {
+		if record.UpdateType == NoteUpdateType {
+			notes[record.PaymentID] = record.NewValue
+		}
+	}
+	return notes
+}
+
+// applyNoteEdits replaces the logged notes of transactions with their latest edits
+func applyNoteEdits(updates []templates.PaymentUpdateRecord, transactions ...*templates.Transaction) {
+	notes := noteEdits(updates)
+	for _, transaction := range transactions {
+		if note, edited := notes[transaction.ID]; edited {
+			transaction.Note = note
+		}
+	}
+}
@@ -0,0 +1,517 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"checkout/config"
+	"checkout/templates"
+	"checkout/utils"
+	"checkout/version"
+)
+
+// Order webhooks tell external systems, such as a booking system, about each finished sale, refund
+// and void. Events are queued on disk under the data directory and posted by a background worker,
+// so a slow or unreachable receiver never holds up the register and a restart doesn't lose an
+// event. A failed delivery is retried with doubling waits and moved to the dead-letter log after
+// config.GetOrderWebhookMaxAttempts attempts. Delivery is at least once: an event can arrive again
+// after a restart, so receivers should ignore an event ID they have already seen.
+//
+// With a signing secret set, each request is signed over its X-Timestamp and body, so a captured
+// request can't be replayed once the timestamp is older than OrderWebhookTolerance.
+
+// Order event types
+const (
+	OrderEventSale   = "sale.completed"
+	OrderEventRefund = "sale.refunded"
+	OrderEventVoid   = "sale.voided"
+	OrderEventTest   = "webhook.test" // Sent with the settings page button; carries no transaction
+)
+
+// Order webhook delivery timing
+const (
+	orderWebhookInterval   = 5 * time.Second  // How often deliveries that are due are sent
+	orderWebhookTimeout    = 10 * time.Second // How long a receiver has to answer
+	orderWebhookFirstRetry = 30 * time.Second // Wait before the second attempt, doubled for each one after
+	orderWebhookMaxRetry   = time.Hour        // Longest wait between attempts
+	orderWebhookLogSize    = 200              // Attempts kept in the delivery log
+)
+
+// OrderWebhookAttemptsShown is how many delivery attempts the settings page lists
+const OrderWebhookAttemptsShown = 20
+
+// OrderWebhookTolerance is how far from the receiver's clock a signed request's X-Timestamp may be.
+// Each attempt is signed when it is sent, so retries of an old event still arrive within it.
+const OrderWebhookTolerance = 5 * time.Minute
+
+// OrderEvent is the JSON body posted to the order webhook URLs
+type OrderEvent struct {
+	ID          string                 `json:"id"` // Unique per event and kept on redelivery
+	Type        string                 `json:"type"`
+	CreatedAt   time.Time              `json:"createdAt"`
+	Transaction *OrderEventTransaction `json:"transaction,omitempty"`
+}
+
+// OrderEventTransaction is the sale an order event is about. The amounts of refunds and voids are negative.
+type OrderEventTransaction struct {
+	ID               string           `json:"id"` // Payment ID (PaymentIntent, payment link, or POS ID for cash)
+	ConfirmationCode string           `json:"confirmationCode,omitempty"`
+	OrderNumber      int              `json:"orderNumber,omitempty"`
+	Timestamp        time.Time        `json:"timestamp"`
+	PaymentMethod    string           `json:"paymentMethod"` // terminal, manual, qr, cash, gift_card, split or return
+	Items            []OrderEventItem `json:"items"`
+	Subtotal         float64          `json:"subtotal"`
+	Tax              float64          `json:"tax"`
+	ServiceFee       float64          `json:"serviceFee"`
+	Tip              float64          `json:"tip"`
+	Total            float64          `json:"total"` // Subtotal, tax and service fee; the tip is on top
+	CustomerEmail    string           `json:"customerEmail,omitempty"`
+	Note             string           `json:"note,omitempty"`
+	LocationID       string           `json:"locationId,omitempty"`
+}
+
+// OrderEventItem is a line of an order event's sale
+type OrderEventItem struct {
+	ProductID string  `json:"productId,omitempty"`
+	SKU       string  `json:"sku,omitempty"`
+	Name      string  `json:"name"`
+	Quantity  float64 `json:"quantity"`           // 1 for an item sold whole
+	UnitType  string  `json:"unitType,omitempty"` // e.g. "lb" for an item sold by weight
+	UnitPrice float64 `json:"unitPrice"`
+	Price     float64 `json:"price"` // Line price before tax
+	Tax       float64 `json:"tax"`
+	ReturnOf  string  `json:"returnOf,omitempty"` // Sale a returned item came from
+}
+
+// OrderWebhookAttempt is one attempt at posting an event to a URL, as shown in the delivery log
+type OrderWebhookAttempt struct {
+	At         time.Time `json:"at"`
+	EventID    string    `json:"eventId"`
+	EventType  string    `json:"eventType"`
+	URL        string    `json:"url"`
+	Attempt    int       `json:"attempt"`
+	Status     int       `json:"status,omitempty"` // HTTP status of the answer; 0 when none arrived
+	Error      string    `json:"error,omitempty"`
+	DeadLetter bool      `json:"deadLetter,omitempty"` // This was the last attempt and the event was dead-lettered
+}
+
+// Delivered reports whether the receiver accepted the event
+func (a OrderWebhookAttempt) Delivered() bool {
+	return a.Error == ""
+}
+
+// orderWebhookDelivery is an event waiting to be posted to one URL
+type orderWebhookDelivery struct {
+	EventID   string    `json:"eventId"`
+	EventType string    `json:"eventType"`
+	URL       string    `json:"url"`
+	Body      string    `json:"body"` // Posted unchanged on every attempt
+	Attempts  int       `json:"attempts"`
+	Due       time.Time `json:"due"`
+	LastError string    `json:"lastError,omitempty"`
+}
+
+// orderWebhooks holds the delivery queue and log, read from disk on first use
+var orderWebhooks = struct {
+	queue  []*orderWebhookDelivery
+	log    []OrderWebhookAttempt // Oldest first
+	loaded bool
+	mutex  sync.Mutex
+}{}
+
+// QueueOrderEvent queues the order event of a logged transaction for every order webhook URL.
+// Rows that aren't a finished sale, refund or void (failed payments, sent links) are ignored, and
+// nothing is sent for practice sales in demo mode.
+func QueueOrderEvent(transaction templates.Transaction) {
+	urls := config.GetOrderWebhookURLs()
+	eventType := orderEventType(transaction)
+	if len(urls) == 0 || eventType == "" || config.Config.DemoMode {
+		return
+	}
+
+	event := newOrderEvent(eventType)
+	event.Transaction = orderEventTransaction(transaction)
+	body, err := json.Marshal(event)
+	if err != nil {
+		utils.Error("webhook", "Error encoding order event", "transaction_id", transaction.ID, "error", err)
+		return
+	}
+
+	orderWebhooks.mutex.Lock()
+	defer orderWebhooks.mutex.Unlock()
+	ensureOrderWebhooksLoaded()
+	for _, url := range urls {
+		orderWebhooks.queue = append(orderWebhooks.queue, &orderWebhookDelivery{
+			EventID:   event.ID,
+			EventType: eventType,
+			URL:       url,
+			Body:      string(body),
+			Due:       event.CreatedAt,
+		})
+	}
+	saveOrderWebhookQueue()
+	utils.Info("webhook", "Order event queued", "event_id", event.ID, "type", eventType, "transaction_id", transaction.ID, "urls", len(urls))
+}
+
+// orderEventType returns the event a logged transaction is, or "" when it isn't one
+func orderEventType(transaction templates.Transaction) string {
+	switch {
+	case len(transaction.Products) == 0:
+		return ""
+	case strings.HasSuffix(transaction.PaymentType, VoidedPaymentSuffix):
+		return OrderEventVoid
+	case !isSuccessfulPaymentType(transaction.PaymentType):
+		return ""
+	case transaction.Total < 0:
+		return OrderEventRefund
+	default:
+		return OrderEventSale
+	}
+}
+
+func newOrderEvent(eventType string) OrderEvent {
+	now := time.Now()
+	return OrderEvent{
+		ID:        fmt.Sprintf("evt_%d", now.UnixNano()),
+		Type:      eventType,
+		CreatedAt: now.UTC().Truncate(time.Second),
+	}
+}
+
+// orderEventTransaction converts a logged transaction for an order event
+func orderEventTransaction(transaction templates.Transaction) *OrderEventTransaction {
+	timestamp, err := time.ParseInLocation("01/02/2006 15:04:05", transaction.Date+" "+transaction.Time, time.Local)
+	if err != nil {
+		timestamp = time.Now()
+	}
+	event := &OrderEventTransaction{
+		ID:               transaction.ID,
+		ConfirmationCode: transaction.ConfirmationCode,
+		OrderNumber:      transaction.OrderNumber,
+		Timestamp:        timestamp.UTC(),
+		PaymentMethod:    strings.TrimSuffix(transaction.PaymentType, VoidedPaymentSuffix),
+		Items:            []OrderEventItem{},
+		Subtotal:         transaction.Subtotal,
+		Tax:              transaction.Tax,
+		ServiceFee:       transaction.ServiceFee,
+		Tip:              transaction.TipAmount,
+		Total:            transaction.Total,
+		CustomerEmail:    transaction.StripeCustomerEmail,
+		Note:             transaction.Note,
+		LocationID:       transaction.LocationID,
+	}
+	for i, product := range transaction.Products {
+		item := OrderEventItem{
+			ProductID: product.ID,
+			SKU:       product.SKU,
+			Name:      product.Name,
+			Quantity:  1,
+			UnitPrice: product.Price,
+			Price:     product.Price,
+			ReturnOf:  product.ReturnOf,
+		}
+		if product.Quantity > 0 {
+			item.Quantity = product.Quantity
+			item.UnitType = product.UnitType
+			item.UnitPrice = product.UnitPrice
+		}
+		if i < len(transaction.ProductTaxes) {
+			item.Tax = transaction.ProductTaxes[i]
+		}
+		event.Items = append(event.Items, item)
+	}
+	return event
+}
+
+// StartOrderWebhooks posts the queued order events every orderWebhookInterval. It runs whether or
+// not URLs are configured, so webhooks turned on in the settings take effect without a restart.
+func StartOrderWebhooks() {
+	go func() {
+		ticker := time.NewTicker(orderWebhookInterval)
+		defer ticker.Stop()
+
+		for {
+			runDueOrderWebhooks(time.Now())
+			<-ticker.C
+		}
+	}()
+
+	utils.Info("webhook", "Order webhooks started", "urls", len(config.GetOrderWebhookURLs()), "pending", PendingOrderWebhooks())
+}
+
+// runDueOrderWebhooks posts the deliveries that are due, one at a time. A failed delivery is
+// rescheduled, or dead-lettered once it has used its attempts; a delivery to a URL that was
+// removed from the settings is dropped.
+func runDueOrderWebhooks(now time.Time) {
+	urls := config.GetOrderWebhookURLs()
+
+	orderWebhooks.mutex.Lock()
+	ensureOrderWebhooksLoaded()
+	var due, dropped []*orderWebhookDelivery
+	for _, delivery := range orderWebhooks.queue {
+		if !slices.Contains(urls, delivery.URL) {
+			dropped = append(dropped, delivery)
+		} else if !now.Before(delivery.Due) {
+			due = append(due, delivery)
+		}
+	}
+	for _, delivery := range dropped {
+		utils.Warn("webhook", "Order event dropped, its URL is no longer configured", "event_id", delivery.EventID, "url", delivery.URL)
+		removeOrderWebhookDelivery(delivery)
+	}
+	if len(dropped) > 0 {
+		saveOrderWebhookQueue()
+	}
+	orderWebhooks.mutex.Unlock()
+
+	for _, delivery := range due {
+		attempt := postOrderEvent(delivery.URL, delivery.EventID, delivery.EventType, []byte(delivery.Body))
+
+		orderWebhooks.mutex.Lock()
+		delivery.Attempts++
+		attempt.Attempt = delivery.Attempts
+		switch {
+		case attempt.Delivered():
+			removeOrderWebhookDelivery(delivery)
+			utils.Info("webhook", "Order event delivered", "event_id", delivery.EventID, "url", delivery.URL, "attempts", delivery.Attempts)
+		case delivery.Attempts >= config.GetOrderWebhookMaxAttempts():
+			attempt.DeadLetter = true
+			delivery.LastError = attempt.Error
+			removeOrderWebhookDelivery(delivery)
+			if err := appendOrderWebhookDeadLetter(delivery); err != nil {
+				utils.Error("webhook", "Error writing order event to the dead-letter log", "event_id", delivery.EventID, "error", err)
+			}
+			utils.Error("webhook", "Order event not delivered, moved to the dead-letter log", "event_id", delivery.EventID, "url", delivery.URL, "attempts", delivery.Attempts, "error", attempt.Error)
+		default:
+			delivery.LastError = attempt.Error
+			delivery.Due = time.Now().Add(orderWebhookRetryDelay(delivery.Attempts))
+			utils.Warn("webhook", "Order event delivery failed, will retry", "event_id", delivery.EventID, "url", delivery.URL, "attempt", delivery.Attempts, "retry_at", delivery.Due.Format(time.RFC3339), "error", attempt.Error)
+		}
+		saveOrderWebhookQueue()
+		recordOrderWebhookAttempt(attempt)
+		orderWebhooks.mutex.Unlock()
+	}
+}
+
+// orderWebhookRetryDelay returns the wait after a delivery's attempts-th failed attempt
+func orderWebhookRetryDelay(attempts int) time.Duration {
+	delay := orderWebhookFirstRetry
+	for i := 1; i < attempts && delay < orderWebhookMaxRetry; i++ {
+		delay *= 2
+	}
+	return min(delay, orderWebhookMaxRetry)
+}
+
+// SendTestOrderWebhook posts a test event to every order webhook URL right away, outside the
+// queue, and returns each attempt; the attempts are also added to the delivery log
+func SendTestOrderWebhook() ([]OrderWebhookAttempt, error) {
+	urls := config.GetOrderWebhookURLs()
+	if len(urls) == 0 {
+		return nil, errors.New("no order webhook URLs are configured")
+	}
+
+	event := newOrderEvent(OrderEventTest)
+	body, err := json.Marshal(event)
+	if err != nil {
+		return nil, err
+	}
+
+	var attempts []OrderWebhookAttempt
+	for _, url := range urls {
+		attempt := postOrderEvent(url, event.ID, event.Type, body)
+		attempt.Attempt = 1
+		attempts = append(attempts, attempt)
+	}
+
+	orderWebhooks.mutex.Lock()
+	defer orderWebhooks.mutex.Unlock()
+	ensureOrderWebhooksLoaded()
+	for _, attempt := range attempts {
+		recordOrderWebhookAttempt(attempt)
+	}
+	return attempts, nil
+}
+
+// postOrderEvent posts an event's body to a URL. Only a 2xx answer counts as delivered; a redirect
+// is not followed, since it would turn the POST into a GET.
+func postOrderEvent(url, eventID, eventType string, body []byte) OrderWebhookAttempt {
+	attempt := OrderWebhookAttempt{At: time.Now(), EventID: eventID, EventType: eventType, URL: url}
+
+	request, err := http.NewRequest(http.MethodPost, url, strings.NewReader(string(body)))
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("User-Agent", "checkout/"+version.Version)
+	request.Header.Set("X-Event-ID", eventID)
+	request.Header.Set("X-Event-Type", eventType)
+	if secret := config.Config.OrderWebhookSecret; secret != "" {
+		timestamp := attempt.At.Unix()
+		request.Header.Set("X-Timestamp", strconv.FormatInt(timestamp, 10))
+		request.Header.Set("X-Signature", SignOrderWebhook(timestamp, body, secret))
+	}
+
+	client := &http.Client{
+		Timeout: orderWebhookTimeout,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+	defer response.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(response.Body, 1<<16))
+
+	attempt.Status = response.StatusCode
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		attempt.Error = "receiver answered " + response.Status
+	}
+	return attempt
+}
+
+// SignOrderWebhook returns the X-Signature header of a request: "sha256=" followed by the hex
+// HMAC-SHA256, with the shared secret, of the X-Timestamp value, a dot and the raw body.
+func SignOrderWebhook(timestamp int64, body []byte, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10) + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyOrderWebhook checks a signed request the way a receiver should: the signature must match
+// and the timestamp must be within OrderWebhookTolerance of now
+func VerifyOrderWebhook(timestampHeader, signatureHeader string, body []byte, secret string, now time.Time) error {
+	timestamp, err := strconv.ParseInt(timestampHeader, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid X-Timestamp %q", timestampHeader)
+	}
+	if !hmac.Equal([]byte(signatureHeader), []byte(SignOrderWebhook(timestamp, body, secret))) {
+		return errors.New("signature doesn't match")
+	}
+	if age := now.Sub(time.Unix(timestamp, 0)); age > OrderWebhookTolerance || age < -OrderWebhookTolerance {
+		return fmt.Errorf("timestamp is %s away from now, more than the %s tolerance", age.Round(time.Second), OrderWebhookTolerance)
+	}
+	return nil
+}
+
+// RecentOrderWebhookAttempts returns up to limit delivery attempts, newest first
+func RecentOrderWebhookAttempts(limit int) []OrderWebhookAttempt {
+	orderWebhooks.mutex.Lock()
+	defer orderWebhooks.mutex.Unlock()
+	ensureOrderWebhooksLoaded()
+
+	var attempts []OrderWebhookAttempt
+	for i := len(orderWebhooks.log) - 1; i >= 0 && len(attempts) < limit; i-- {
+		attempts = append(attempts, orderWebhooks.log[i])
+	}
+	return attempts
+}
+
+// PendingOrderWebhooks returns how many deliveries are waiting to be sent or retried
+func PendingOrderWebhooks() int {
+	orderWebhooks.mutex.Lock()
+	defer orderWebhooks.mutex.Unlock()
+	ensureOrderWebhooksLoaded()
+	return len(orderWebhooks.queue)
+}
+
+// OrderWebhookDeadLetterPath returns the file events that were never delivered are written to
+func OrderWebhookDeadLetterPath() string {
+	return filepath.Join(getOrderWebhooksDir(), "dead-letter.jsonl")
+}
+
+// recordOrderWebhookAttempt adds an attempt to the delivery log. Callers must hold orderWebhooks.mutex.
+func recordOrderWebhookAttempt(attempt OrderWebhookAttempt) {
+	orderWebhooks.log = append(orderWebhooks.log, attempt)
+	if len(orderWebhooks.log) > orderWebhookLogSize {
+		orderWebhooks.log = orderWebhooks.log[len(orderWebhooks.log)-orderWebhookLogSize:]
+	}
+	if err := writeJSONFile(filepath.Join(getOrderWebhooksDir(), "deliveries.json"), orderWebhooks.log); err != nil {
+		utils.Error("webhook", "Error saving the order webhook delivery log", "error", err)
+	}
+}
+
+// removeOrderWebhookDelivery takes a delivery off the queue. Callers must hold orderWebhooks.mutex.
+func removeOrderWebhookDelivery(delivery *orderWebhookDelivery) {
+	orderWebhooks.queue = slices.DeleteFunc(orderWebhooks.queue, func(queued *orderWebhookDelivery) bool {
+		return queued == delivery
+	})
+}
+
+// saveOrderWebhookQueue writes the queue to disk. Callers must hold orderWebhooks.mutex.
+func saveOrderWebhookQueue() {
+	queue := orderWebhooks.queue
+	if queue == nil {
+		queue = []*orderWebhookDelivery{}
+	}
+	if err := writeJSONFile(filepath.Join(getOrderWebhooksDir(), "queue.json"), queue); err != nil {
+		utils.Error("webhook", "Error saving the order webhook queue", "pending", len(queue), "error", err)
+	}
+}
+
+// appendOrderWebhookDeadLetter writes an undelivered event, with the URL and its last error, as a
+// line of the dead-letter log
+func appendOrderWebhookDeadLetter(delivery *orderWebhookDelivery) error {
+	line, err := json.Marshal(delivery)
+	if err != nil {
+		return err
+	}
+	path := OrderWebhookDeadLetterPath()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = file.Write(append(line, '\n'))
+	return err
+}
+
+// ensureOrderWebhooksLoaded reads the queue and delivery log once. Callers must hold orderWebhooks.mutex.
+func ensureOrderWebhooksLoaded() {
+	if orderWebhooks.loaded {
+		return
+	}
+	orderWebhooks.loaded = true
+
+	dir := getOrderWebhooksDir()
+	if data, err := os.ReadFile(filepath.Join(dir, "queue.json")); err == nil {
+		if err := json.Unmarshal(data, &orderWebhooks.queue); err != nil {
+			utils.Error("webhook", "Error reading the order webhook queue", "error", err)
+		}
+	} else if !os.IsNotExist(err) {
+		utils.Error("webhook", "Error reading the order webhook queue", "error", err)
+	}
+	if data, err := os.ReadFile(filepath.Join(dir, "deliveries.json")); err == nil {
+		if err := json.Unmarshal(data, &orderWebhooks.log); err != nil {
+			utils.Warn("webhook", "Error reading the order webhook delivery log", "error", err)
+		}
+	}
+}
+
+func getOrderWebhooksDir() string {
+	dataDir := config.Config.DataDir
+	if dataDir == "" {
+		dataDir = config.DefaultDataDir
+	}
+	return filepath.Join(dataDir, "order-webhooks")
+}
@@ -0,0 +1,75 @@
+package services
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"checkout/config"
+)
+
+func TestPostOrderEventSignsTimestampAndBody(t *testing.T) {
+	config.Config.OrderWebhookSecret = "shh"
+	t.Cleanup(func() { config.Config.OrderWebhookSecret = "" })
+
+	var timestamp, signature string
+	var received []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		timestamp, signature = r.Header.Get("X-Timestamp"), r.Header.Get("X-Signature")
+		received, _ = io.ReadAll(r.Body)
+	}))
+	defer server.Close()
+
+	body := []byte(`{"id":"evt_1","type":"webhook.test"}`)
+	attempt := postOrderEvent(server.URL, "evt_1", OrderEventTest, body)
+	if !attempt.Delivered() {
+		t.Fatalf("delivery failed: %s", attempt.Error)
+	}
+	if timestamp != strconv.FormatInt(attempt.At.Unix(), 10) {
+		t.Errorf("X-Timestamp = %q, want the attempt time %d", timestamp, attempt.At.Unix())
+	}
+	if err := VerifyOrderWebhook(timestamp, signature, received, "shh", time.Now()); err != nil {
+		t.Errorf("signature of the request doesn't verify: %v", err)
+	}
+}
+
+func TestVerifyOrderWebhook(t *testing.T) {
+	now := time.Unix(1767345600, 0)
+	body := []byte(`{"id":"evt_1"}`)
+	sign := func(at time.Time) (string, string) {
+		return strconv.FormatInt(at.Unix(), 10), SignOrderWebhook(at.Unix(), body, "shh")
+	}
+
+	tests := []struct {
+		name      string
+		timestamp func() (string, string)
+		body      []byte
+		secret    string
+		wantErr   bool
+	}{
+		{"fresh", func() (string, string) { return sign(now) }, body, "shh", false},
+		{"within tolerance", func() (string, string) { return sign(now.Add(-OrderWebhookTolerance)) }, body, "shh", false},
+		{"clock ahead within tolerance", func() (string, string) { return sign(now.Add(time.Minute)) }, body, "shh", false},
+		{"replayed after tolerance", func() (string, string) { return sign(now.Add(-OrderWebhookTolerance - time.Second)) }, body, "shh", true},
+		{"too far in the future", func() (string, string) { return sign(now.Add(OrderWebhookTolerance + time.Second)) }, body, "shh", true},
+		{"body changed", func() (string, string) { return sign(now) }, []byte(`{"id":"evt_2"}`), "shh", true},
+		{"wrong secret", func() (string, string) { return sign(now) }, body, "other", true},
+		{"timestamp changed", func() (string, string) {
+			_, signature := sign(now.Add(-time.Hour))
+			return strconv.FormatInt(now.Unix(), 10), signature
+		}, body, "shh", true},
+		{"timestamp missing", func() (string, string) { _, signature := sign(now); return "", signature }, body, "shh", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			timestamp, signature := tt.timestamp()
+			err := VerifyOrderWebhook(timestamp, signature, tt.body, tt.secret, now)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("VerifyOrderWebhook() error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,125 @@
+package services
+
+import (
+	"strings"
+
+	"checkout/utils"
+
+	"github.com/stripe/stripe-go/v74"
+)
+
+// PaymentAttempt is a declined card payment of the sale in progress
+type PaymentAttempt struct {
+	IntentID string
+	Method   string // "terminal" or "manual"
+}
+
+// String formats an attempt for the transaction log's Retry Of column, e.g. "terminal:pi_123"
+func (a PaymentAttempt) String() string {
+	return a.Method + ":" + a.IntentID
+}
+
+// FormatPaymentAttempts joins a sale's declined attempts, oldest first, for the Retry Of column
+func FormatPaymentAttempts(attempts []PaymentAttempt) string {
+	values := make([]string, len(attempts))
+	for i, attempt := range attempts {
+		values[i] = attempt.String()
+	}
+	return strings.Join(values, " ")
+}
+
+// RetryablePaymentMethod reports whether a declined payment with the method can be retried on the
+// same PaymentIntent. Payment links create their own intents, so only card payments qualify.
+func RetryablePaymentMethod(method string) bool {
+	return method == "terminal" || method == "manual"
+}
+
+// PaymentIntentFor returns the PaymentIntent for charging amount with the payment method. After a
+// decline the sale's last intent is reused, updated to the new amount and method, so Stripe
+// shows one payment with several attempts rather than an abandoned intent per try. A new intent
+// is created when there was no decline or the declined intent can't be retried; one replacing a
+// canceled or expired intent keeps the declined payment's POS payment ID. The bool reports
+// whether the declined intent was reused. A cart of a market vendor's items is paid out to the
+// vendor (see RouteToVendor).
+func PaymentIntentFor(client StripeClient, cart *CartStore, amount float64, method string) (*stripe.PaymentIntent, bool, error) {
+	params := NewPaymentIntentParams(amount, method, cart.Note())
+	if err := RouteToVendor(params, cart.Items()); err != nil {
+		return nil, false, err
+	}
+
+	attempt, ok := cart.LastDeclinedAttempt()
+	if !ok || !RetryablePaymentMethod(method) {
+		intent, err := client.CreatePaymentIntent(params)
+		return intent, false, err
+	}
+
+	declined, err := client.GetPaymentIntent(attempt.IntentID)
+	if err != nil {
+		utils.Warn("payment", "Error retrieving declined PaymentIntent, creating a new one", "intent_id", attempt.IntentID, "error", err)
+		intent, err := client.CreatePaymentIntent(params)
+		return intent, false, err
+	}
+
+	// Stripe can't move a PaymentIntent to another connected account, so a cart changed to
+	// another vendor's items needs a new one
+	destination := ""
+	if params.TransferData != nil {
+		destination = stripe.StringValue(params.TransferData.Destination)
+	}
+	if payoutAccount(declined) != destination {
+		utils.Info("payment", "Declined PaymentIntent pays out to another vendor, creating a new one",
+			"intent_id", declined.ID, "payout_account", payoutAccount(declined), "vendor_account", destination)
+		intent, err := client.CreatePaymentIntent(params)
+		return intent, false, err
+	}
+
+	if declined.Status != stripe.PaymentIntentStatusRequiresPaymentMethod {
+		utils.Info("payment", "Declined PaymentIntent can't be retried, creating a new one",
+			"intent_id", declined.ID, "status", declined.Status)
+		// A split tender may have been paid with it since, and that payment keeps its own ID
+		if paymentID := declined.Metadata[MetadataPaymentID]; paymentID != "" && declined.Status == stripe.PaymentIntentStatusCanceled {
+			params.Metadata[MetadataPaymentID] = paymentID
+		}
+		intent, err := client.CreatePaymentIntent(params)
+		return intent, false, err
+	}
+
+	update := &stripe.PaymentIntentParams{}
+	if declined.Amount != *params.Amount {
+		update.Amount = params.Amount
+		update.ApplicationFeeAmount = params.ApplicationFeeAmount
+	}
+	if declined.Metadata[MetadataPaymentMethod] != method {
+		update.PaymentMethodTypes = params.PaymentMethodTypes
+		update.AddMetadata(MetadataPaymentMethod, method)
+	}
+	intent := declined
+	if update.Amount != nil || update.PaymentMethodTypes != nil {
+		if intent, err = client.UpdatePaymentIntent(declined.ID, update); err != nil {
+			return nil, false, err
+		}
+	}
+
+	utils.Info("payment", "Retrying declined PaymentIntent", "intent_id", intent.ID,
+		"payment_method", method, "declined_method", attempt.Method, "amount", amount)
+	return intent, true, nil
+}
+
+// CancelDeclinedPayment cancels the sale's declined PaymentIntent when the sale moves to a payment
+// link, which is paid through an intent of its own
+func CancelDeclinedPayment(client StripeClient, cart *CartStore) {
+	attempt, ok := cart.LastDeclinedAttempt()
+	if !ok {
+		return
+	}
+
+	intent, err := client.GetPaymentIntent(attempt.IntentID)
+	if err != nil || intent.Status != stripe.PaymentIntentStatusRequiresPaymentMethod {
+		return
+	}
+	if _, err := client.CancelPaymentIntent(attempt.IntentID); err != nil {
+		utils.Warn("payment", "Error canceling declined PaymentIntent", "intent_id", attempt.IntentID, "error", err)
+		return
+	}
+	utils.Info("payment", "Canceled declined PaymentIntent for a payment link", "intent_id", attempt.IntentID)
+}
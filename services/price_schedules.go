@@ -0,0 +1,216 @@
+package services
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"checkout/config"
+	"checkout/i18n"
+	"checkout/services/validation"
+	"checkout/templates"
+	"checkout/utils"
+)
+
+// priceScheduleTimeLayout is how the schedule editor's datetime-local inputs send a time, which
+// is read in the business timezone
+const priceScheduleTimeLayout = "2006-01-02T15:04"
+
+// priceSchedulePruneInterval is how often schedules that have ended are removed from the catalog
+const priceSchedulePruneInterval = time.Hour
+
+// ActivePriceSchedule returns the sale price schedule of a product in effect at now
+func ActivePriceSchedule(product templates.Product, now time.Time) (templates.PriceSchedule, bool) {
+	for _, schedule := range product.PriceSchedules {
+		if !now.Before(schedule.Start) && now.Before(schedule.End) {
+			return schedule, true
+		}
+	}
+	return templates.PriceSchedule{}, false
+}
+
+// EffectivePrice returns what a catalog product sells for at now: the sale price of the schedule
+// in effect, or its catalog price. For a measured product it is the price per unit.
+func EffectivePrice(product templates.Product, now time.Time) float64 {
+	if schedule, ok := ActivePriceSchedule(product, now); ok {
+		return schedule.Price
+	}
+	return product.Price
+}
+
+// SaleLine prepares a catalog product for the cart at its effective price. A line on sale keeps
+// the catalog price and the sale price, which the transaction log records with it. The line is
+// priced when it is added, so a sale ending while it is in the cart doesn't change it.
+func SaleLine(product templates.Product, now time.Time) templates.Product {
+	schedule, ok := ActivePriceSchedule(product, now)
+	product.PriceSchedules = nil
+	if !ok {
+		return product
+	}
+	product.ListPrice = product.Price
+	product.SalePrice = schedule.Price
+	product.Price = schedule.Price
+	return product
+}
+
+// IsSaleLine reports whether a cart line or logged item was sold at a scheduled sale price. Sale
+// prices are below the catalog price, so a line on sale always has a list price.
+func IsSaleLine(product templates.Product) bool {
+	return product.ListPrice > 0
+}
+
+// RegularPrice is the catalog price of a cart line on sale, e.g. "$3.99/lb" for a measured line
+func RegularPrice(line templates.Product) string {
+	return formatCatalogPrice(line, line.ListPrice)
+}
+
+// ParsePriceScheduleTime reads a time from the schedule editor in the business timezone
+func ParsePriceScheduleTime(text string) (time.Time, error) {
+	t, err := time.ParseInLocation(priceScheduleTimeLayout, text, config.GetBusinessLocation())
+	if err != nil {
+		return time.Time{}, &validation.Error{Key: "validation.schedule_time_invalid", Args: []interface{}{text}}
+	}
+	return t, nil
+}
+
+// FormatPriceScheduleTime formats a schedule time in the business timezone for the editor
+func FormatPriceScheduleTime(t time.Time) string {
+	return i18n.DateTime(t.In(config.GetBusinessLocation()))
+}
+
+// AddPriceSchedule schedules a sale price for a product and saves the catalog. The sale price must
+// be below the catalog price, the sale must end after it starts and after now, and it may not
+// overlap another sale of the same product.
+func AddPriceSchedule(productID string, start, end time.Time, price float64, now time.Time) (templates.PriceSchedule, error) {
+	if !end.After(start) {
+		return templates.PriceSchedule{}, &validation.Error{Key: "validation.schedule_end_before_start"}
+	}
+	if !end.After(now) {
+		return templates.PriceSchedule{}, &validation.Error{Key: "validation.schedule_ended"}
+	}
+
+	products := Catalog.Products()
+	index := productIndex(products, productID)
+	if index < 0 {
+		return templates.PriceSchedule{}, fmt.Errorf("product %s not found", productID)
+	}
+	product := products[index]
+	if price >= product.Price {
+		return templates.PriceSchedule{}, &validation.Error{Key: "validation.schedule_price_not_lower", Args: []interface{}{i18n.Money(product.Price)}}
+	}
+	for _, other := range product.PriceSchedules {
+		if start.Before(other.End) && other.Start.Before(end) {
+			return templates.PriceSchedule{}, &validation.Error{Key: "validation.schedule_overlap",
+				Args: []interface{}{FormatPriceScheduleTime(other.Start), FormatPriceScheduleTime(other.End)}}
+		}
+	}
+
+	schedule := templates.PriceSchedule{ID: nextPriceScheduleID(product.PriceSchedules), Start: start, End: end, Price: price}
+	product.PriceSchedules = append(append([]templates.PriceSchedule{}, product.PriceSchedules...), schedule)
+	products[index] = product
+	if err := SaveProducts(products); err != nil {
+		return templates.PriceSchedule{}, err
+	}
+	SetProducts(products)
+
+	utils.Info("products", "Sale price scheduled", "product_id", product.ID, "product", product.Name,
+		"schedule_id", schedule.ID, "start", start.Format(time.RFC3339), "end", end.Format(time.RFC3339), "price", price)
+	return schedule, nil
+}
+
+// RemovePriceSchedule removes a sale price schedule from a product and saves the catalog
+func RemovePriceSchedule(productID, scheduleID string) error {
+	products := Catalog.Products()
+	index := productIndex(products, productID)
+	if index < 0 {
+		return fmt.Errorf("product %s not found", productID)
+	}
+
+	product := products[index]
+	var kept []templates.PriceSchedule
+	for _, schedule := range product.PriceSchedules {
+		if schedule.ID != scheduleID {
+			kept = append(kept, schedule)
+		}
+	}
+	if len(kept) == len(product.PriceSchedules) {
+		return fmt.Errorf("sale price %s of %s not found", scheduleID, product.Name)
+	}
+	product.PriceSchedules = kept
+	products[index] = product
+	if err := SaveProducts(products); err != nil {
+		return err
+	}
+	SetProducts(products)
+
+	utils.Info("products", "Sale price schedule removed", "product_id", product.ID, "product", product.Name, "schedule_id", scheduleID)
+	return nil
+}
+
+// PruneEndedPriceSchedules removes the schedules that ended before now from the catalog, and
+// returns how many were removed
+func PruneEndedPriceSchedules(now time.Time) (int, error) {
+	products := Catalog.Products()
+	pruned := 0
+	for i, product := range products {
+		var kept []templates.PriceSchedule
+		for _, schedule := range product.PriceSchedules {
+			if schedule.End.After(now) {
+				kept = append(kept, schedule)
+			}
+		}
+		if len(kept) != len(product.PriceSchedules) {
+			pruned += len(product.PriceSchedules) - len(kept)
+			products[i].PriceSchedules = kept
+		}
+	}
+	if pruned == 0 {
+		return 0, nil
+	}
+
+	if err := SaveProducts(products); err != nil {
+		return 0, err
+	}
+	SetProducts(products)
+	utils.Info("products", "Ended sale price schedules removed", "count", pruned)
+	return pruned, nil
+}
+
+// StartPriceSchedulePruning removes ended sale price schedules now and every
+// priceSchedulePruneInterval, so the catalog doesn't collect past promotions
+func StartPriceSchedulePruning() {
+	go func() {
+		ticker := time.NewTicker(priceSchedulePruneInterval)
+		defer ticker.Stop()
+
+		for {
+			if _, err := PruneEndedPriceSchedules(time.Now()); err != nil {
+				utils.Error("products", "Error removing ended sale price schedules", "error", err)
+			}
+			<-ticker.C
+		}
+	}()
+
+	utils.Info("products", "Sale price schedule pruning started", "interval", priceSchedulePruneInterval.String())
+}
+
+// productIndex returns the index of the product with an ID, or -1
+func productIndex(products []templates.Product, productID string) int {
+	for i, product := range products {
+		if product.ID == productID {
+			return i
+		}
+	}
+	return -1
+}
+
+// nextPriceScheduleID returns the next numeric schedule ID after the highest one of a product
+func nextPriceScheduleID(schedules []templates.PriceSchedule) string {
+	highest := 0
+	for _, schedule := range schedules {
+		if id, err := strconv.Atoi(schedule.ID); err == nil && id > highest {
+			highest = id
+		}
+	}
+	return strconv.Itoa(highest + 1)
+}
@@ -0,0 +1,362 @@
+package services
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"checkout/config"
+	"checkout/templates"
+	"checkout/utils"
+)
+
+// ProductCSVHeader lists the catalog columns read and written by product import and export
+var ProductCSVHeader = []string{"ID", "Name", "Description", "Price", "Category", "Tax Category", "SKU", "Unit Type", "Vendor"}
+
+// Product import changes
+const (
+	ProductCreate    = "create"
+	ProductUpdate    = "update"
+	ProductUnchanged = "unchanged"
+)
+
+// ProductImportRow is one row of an imported catalog and what it does to the catalog
+type ProductImportRow struct {
+	Line    int
+	Change  string
+	Product templates.Product // The product as it will be saved
+	Fields  []string          // Columns that differ from the current product (updates only)
+}
+
+// ProductImport is a validated catalog upload waiting to be confirmed. Products that aren't
+// in the file are kept. If Errors is non-empty the import can't be applied.
+type ProductImport struct {
+	Rows   []ProductImportRow
+	Errors []string
+
+	base     []templates.Product // Catalog the import was checked against
+	products []templates.Product // Catalog after the import
+}
+
+// Count returns the number of rows with the given change
+func (p *ProductImport) Count(change string) int {
+	count := 0
+	for _, row := range p.Rows {
+		if row.Change == change {
+			count++
+		}
+	}
+	return count
+}
+
+// WriteProductsCSV writes the catalog as CSV in the ProductCSVHeader layout
+func WriteProductsCSV(w io.Writer, products []templates.Product) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(ProductCSVHeader); err != nil {
+		return err
+	}
+	for _, product := range products {
+		if err := writer.Write([]string{
+			product.ID,
+			product.Name,
+			product.Description,
+			strconv.FormatFloat(product.Price, 'f', 2, 64),
+			product.Category,
+			product.TaxCategory,
+			product.SKU,
+			product.UnitType,
+			product.Vendor,
+		}); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// PreviewProductImport reads a catalog CSV and checks every row against the current catalog.
+// Rows are matched to products by ID; rows without an ID create new products. Columns are
+// found by header name, so the ID, Description, Category, Tax Category, SKU, Unit Type and Vendor
+// columns are optional; a file without a Vendor column leaves the products' vendors as they are.
+// Navigation categories are created by use; tax categories and vendors must already be configured.
+func PreviewProductImport(r io.Reader) (*ProductImport, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, errors.New("the file is empty")
+	} else if err != nil {
+		return nil, fmt.Errorf("error reading CSV header: %w", err)
+	}
+
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))] = i
+	}
+	for _, required := range []string{"name", "price"} {
+		if _, exists := columns[required]; !exists {
+			return nil, fmt.Errorf("missing required column %q (expected %s)", required, strings.Join(ProductCSVHeader, ", "))
+		}
+	}
+	_, hasVendor := columns["vendor"]
+	field := func(record []string, name string) string {
+		if i, exists := columns[strings.ToLower(name)]; exists && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+
+	taxCategories := make(map[string]bool)
+	for _, category := range config.Config.TaxCategories {
+		taxCategories[category.ID] = true
+	}
+
+	current := Catalog.Products()
+	existing := make(map[string]int)
+	for i, product := range current {
+		existing[product.ID] = i
+	}
+
+	plan := &ProductImport{base: current}
+	products := append([]templates.Product{}, current...)
+	seenIDs := make(map[string]int)
+	var created []int // Indexes in plan.Rows of new products still needing an ID
+
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		line, _ := reader.FieldPos(0)
+		if err != nil {
+			return nil, fmt.Errorf("error reading CSV: %w", err)
+		}
+
+		var rowErrors []string
+		id := field(record, "ID")
+		if id != "" {
+			if previous, duplicate := seenIDs[id]; duplicate {
+				rowErrors = append(rowErrors, fmt.Sprintf("ID %s is also used on row %d", id, previous))
+			}
+			seenIDs[id] = line
+		}
+
+		name := field(record, "Name")
+		if name == "" {
+			rowErrors = append(rowErrors, "name is required")
+		}
+		price, err := strconv.ParseFloat(strings.TrimPrefix(field(record, "Price"), "$"), 64)
+		if err != nil || price < 0 {
+			rowErrors = append(rowErrors, fmt.Sprintf("invalid price %q", field(record, "Price")))
+		}
+		taxCategory := field(record, "Tax Category")
+		if taxCategory != "" && !taxCategories[taxCategory] {
+			rowErrors = append(rowErrors, fmt.Sprintf("unknown tax category %q", taxCategory))
+		}
+		vendor := field(record, "Vendor")
+		if _, found := FindVendor(vendor); vendor != "" && !found {
+			rowErrors = append(rowErrors, fmt.Sprintf("unknown vendor %q", vendor))
+		}
+		// The unit is logged in parentheses after the description, so it can't contain them
+		unitType := normalizeUnitType(field(record, "Unit Type"))
+		if strings.ContainsAny(unitType, "()") {
+			rowErrors = append(rowErrors, fmt.Sprintf("invalid unit type %q", field(record, "Unit Type")))
+		}
+
+		if len(rowErrors) > 0 {
+			for _, message := range rowErrors {
+				plan.Errors = append(plan.Errors, fmt.Sprintf("Row %d: %s", line, message))
+			}
+			continue
+		}
+
+		imported := templates.Product{
+			ID:          id,
+			Name:        name,
+			Description: field(record, "Description"),
+			Price:       price,
+			Category:    normalizeCategoryPath(field(record, "Category")),
+			TaxCategory: taxCategory,
+			SKU:         NormalizeSKU(field(record, "SKU")),
+			UnitType:    unitType,
+			Vendor:      vendor,
+		}
+
+		i, exists := existing[id]
+		if id == "" || !exists {
+			plan.Rows = append(plan.Rows, ProductImportRow{Line: line, Change: ProductCreate, Product: imported})
+			if id == "" {
+				created = append(created, len(plan.Rows)-1)
+			}
+			continue
+		}
+
+		// Keep the Stripe IDs and anything else the CSV doesn't carry
+		product := products[i]
+		if !hasVendor {
+			imported.Vendor = product.Vendor
+		}
+		fields := changedProductFields(product, imported)
+		if len(fields) == 0 {
+			plan.Rows = append(plan.Rows, ProductImportRow{Line: line, Change: ProductUnchanged, Product: product})
+			continue
+		}
+		if !sameAmount(product.Price, imported.Price) {
+			product.PriceID = "" // A new Stripe price is created for the new amount
+		}
+		product.Name, product.Description, product.Price = imported.Name, imported.Description, imported.Price
+		product.Category, product.TaxCategory, product.SKU = imported.Category, imported.TaxCategory, imported.SKU
+		product.UnitType, product.Vendor = imported.UnitType, imported.Vendor
+		products[i] = product
+		plan.Rows = append(plan.Rows, ProductImportRow{Line: line, Change: ProductUpdate, Product: product, Fields: fields})
+	}
+
+	// Number new products after every ID in use, including IDs given in the file
+	numbered := append([]templates.Product{}, products...)
+	for _, row := range plan.Rows {
+		if row.Change == ProductCreate {
+			numbered = append(numbered, row.Product)
+		}
+	}
+	for _, i := range created {
+		plan.Rows[i].Product.ID = nextProductID(numbered)
+		numbered = append(numbered, plan.Rows[i].Product)
+	}
+	for _, row := range plan.Rows {
+		if row.Change == ProductCreate {
+			products = append(products, row.Product)
+		}
+	}
+
+	// SKUs must stay unique across the whole catalog, not just the file
+	skuOwners := make(map[string]string)
+	for _, product := range products {
+		if sku := NormalizeSKU(product.SKU); sku != "" {
+			if owner, taken := skuOwners[sku]; taken && owner != product.ID {
+				// Report the conflict on the row that caused it
+				id, other := product.ID, owner
+				if plan.lineOf(id) == 0 {
+					id, other = owner, product.ID
+				}
+				plan.Errors = append(plan.Errors, fmt.Sprintf("Row %d: SKU %s is already used by %s",
+					plan.lineOf(id), sku, productName(products, other)))
+				continue
+			}
+			skuOwners[sku] = product.ID
+		}
+	}
+
+	plan.products = products
+	return plan, nil
+}
+
+// ApplyProductImport creates the Stripe products and prices for new and changed products
+// and saves the catalog. Nothing is saved unless every product succeeds.
+func ApplyProductImport(plan *ProductImport) error {
+	if len(plan.Errors) > 0 {
+		return errors.New("the import has errors")
+	}
+	if !reflect.DeepEqual(plan.base, Catalog.Products()) {
+		return errors.New("the catalog changed since the file was checked, upload it again")
+	}
+
+	products := append([]templates.Product{}, plan.products...)
+	changed := make(map[string]bool)
+	for _, row := range plan.Rows {
+		if row.Change != ProductUnchanged {
+			changed[row.Product.ID] = true
+		}
+	}
+	for i := range products {
+		if !changed[products[i].ID] {
+			continue
+		}
+		if _, err := EnsureServiceHasPriceID(&products[i]); err != nil {
+			return fmt.Errorf("error creating Stripe price for %s: %w", products[i].Name, err)
+		}
+	}
+
+	if err := SaveProducts(products); err != nil {
+		return err
+	}
+	SetProducts(products)
+
+	utils.Info("products", "Product catalog imported", "created", plan.Count(ProductCreate),
+		"updated", plan.Count(ProductUpdate), "unchanged", plan.Count(ProductUnchanged))
+	return nil
+}
+
+// changedProductFields lists the CSV columns where an imported row differs from the current product
+func changedProductFields(current, imported templates.Product) []string {
+	var fields []string
+	if strings.TrimSpace(current.Name) != imported.Name {
+		fields = append(fields, "Name")
+	}
+	if strings.TrimSpace(current.Description) != imported.Description {
+		fields = append(fields, "Description")
+	}
+	if !sameAmount(current.Price, imported.Price) {
+		fields = append(fields, "Price")
+	}
+	if normalizeCategoryPath(current.Category) != imported.Category {
+		fields = append(fields, "Category")
+	}
+	if strings.TrimSpace(current.TaxCategory) != imported.TaxCategory {
+		fields = append(fields, "Tax Category")
+	}
+	if NormalizeSKU(current.SKU) != imported.SKU {
+		fields = append(fields, "SKU")
+	}
+	if normalizeUnitType(current.UnitType) != imported.UnitType {
+		fields = append(fields, "Unit Type")
+	}
+	if current.Vendor != imported.Vendor {
+		fields = append(fields, "Vendor")
+	}
+	return fields
+}
+
+// normalizeUnitType lowercases a unit type; "each" is the same as none, selling whole items
+func normalizeUnitType(unit string) string {
+	unit = strings.ToLower(strings.TrimSpace(unit))
+	if unit == UnitEach {
+		return ""
+	}
+	return unit
+}
+
+// normalizeCategoryPath trims spaces and empty segments from a "cat1/cat2" category path
+func normalizeCategoryPath(path string) string {
+	var parts []string
+	for _, part := range strings.Split(path, "/") {
+		if part = strings.TrimSpace(part); part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+// lineOf returns the CSV line that created or updated a product
+func (p *ProductImport) lineOf(id string) int {
+	for _, row := range p.Rows {
+		if row.Product.ID == id {
+			return row.Line
+		}
+	}
+	return 0
+}
+
+// productName returns the name of the product with the given ID
+func productName(products []templates.Product, id string) string {
+	for _, product := range products {
+		if product.ID == id {
+			return product.Name
+		}
+	}
+	return id
+}
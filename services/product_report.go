@@ -0,0 +1,259 @@
+package services
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"checkout/templates"
+)
+
+// MaxProductReportDays limits how many days of transaction logs one product report reads
+const MaxProductReportDays = 366
+
+// UncategorizedLabel names the category roll-up of products sold without a category
+const UncategorizedLabel = "Uncategorized"
+
+// ProductSales is what one product sold over a product report's date range. Amounts are before
+// tax; refunds are positive and cover returned items and voided sales.
+type ProductSales struct {
+	ProductID     string  `json:"productId,omitempty"` // Catalog ID recorded with the sale ("" on older logs)
+	Name          string  `json:"name"`                // Name recorded with the most recent sale
+	Category      string  `json:"category"`            // First segment of the category path
+	Units         int     `json:"units"`               // Items sold
+	Gross         float64 `json:"gross"`               // Revenue from items sold
+	Tax           float64 `json:"tax"`                 // Tax collected on items sold
+	RefundedUnits int     `json:"refundedUnits"`       // Items returned or voided
+	Refunds       float64 `json:"refunds"`             // Amount given back for them
+	Daily         []int   `json:"daily"`               // Items sold on each day of the range
+}
+
+// Net returns the product's revenue after refunds
+func (p ProductSales) Net() float64 {
+	return p.Gross - p.Refunds
+}
+
+// CategorySales rolls up the products of a top-level category
+type CategorySales struct {
+	Category      string  `json:"category"`
+	Units         int     `json:"units"`
+	Gross         float64 `json:"gross"`
+	Tax           float64 `json:"tax"`
+	RefundedUnits int     `json:"refundedUnits"`
+	Refunds       float64 `json:"refunds"`
+	Daily         []int   `json:"daily"`
+}
+
+// Net returns the category's revenue after refunds
+func (c CategorySales) Net() float64 {
+	return c.Gross - c.Refunds
+}
+
+// ProductReport is sales by product and by category over a date range
+type ProductReport struct {
+	From       string          `json:"from"` // First day of the range (YYYY-MM-DD)
+	To         string          `json:"to"`   // Last day of the range
+	Days       []string        `json:"days"` // Each day of the range, matching the Daily columns
+	Products   []ProductSales  `json:"products"`
+	Categories []CategorySales `json:"categories"`
+}
+
+// ProductReportSorts are the columns a product report can be sorted by
+var ProductReportSorts = []string{"name", "units", "gross", "tax", "refunds", "net"}
+
+// BuildProductReport aggregates the line items of the completed sales logged from one day to
+// another, inclusive. Products are grouped by the catalog ID recorded with the sale, or by the
+// recorded name on logs written before IDs were, so renamed and deleted products still appear.
+// Rows without items, such as payment link status events, are left out.
+func BuildProductReport(from, to time.Time) (ProductReport, error) {
+	from = time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, from.Location())
+	to = time.Date(to.Year(), to.Month(), to.Day(), 0, 0, 0, 0, from.Location())
+	if to.Before(from) {
+		return ProductReport{}, fmt.Errorf("the range ends before it starts")
+	}
+
+	var days []time.Time
+	for day := from; !day.After(to); day = day.AddDate(0, 0, 1) {
+		if len(days) == MaxProductReportDays {
+			return ProductReport{}, fmt.Errorf("the range can be at most %d days", MaxProductReportDays)
+		}
+		days = append(days, day)
+	}
+
+	report := ProductReport{
+		From:       from.Format("2006-01-02"),
+		To:         to.Format("2006-01-02"),
+		Products:   []ProductSales{},
+		Categories: []CategorySales{},
+	}
+	for _, day := range days {
+		report.Days = append(report.Days, day.Format("2006-01-02"))
+	}
+
+	catalog := Catalog.Products()
+	byKey := make(map[string]*ProductSales)
+	var keys []string
+	for i, day := range days {
+		transactions, err := LoadTransactionsForDay(day)
+		if err != nil {
+			return report, fmt.Errorf("error reading transactions for %s: %w", day.Format("2006-01-02"), err)
+		}
+
+		for _, transaction := range transactions {
+			for j, product := range transaction.Products {
+				key := "id:" + product.ID
+				if product.ID == "" {
+					key = "name:" + product.Name
+				}
+				sales, ok := byKey[key]
+				if !ok {
+					sales = &ProductSales{ProductID: product.ID, Daily: make([]int, len(days))}
+					byKey[key] = sales
+					keys = append(keys, key)
+				}
+				sales.Name = product.Name
+				sales.Category = topCategory(product, catalog)
+
+				var tax float64
+				if j < len(transaction.ProductTaxes) {
+					tax = transaction.ProductTaxes[j]
+				}
+				if product.ReturnOf != "" && product.Price < 0 {
+					sales.RefundedUnits++
+					sales.Refunds += -product.Price
+					continue
+				}
+				sales.Units++
+				sales.Gross += product.Price
+				sales.Tax += tax
+				sales.Daily[i]++
+				if transaction.Voided {
+					sales.RefundedUnits++
+					sales.Refunds += product.Price
+				}
+			}
+		}
+	}
+
+	byCategory := make(map[string]*CategorySales)
+	for _, key := range keys {
+		sales := byKey[key]
+		sales.Gross = roundCents(sales.Gross)
+		sales.Tax = roundCents(sales.Tax)
+		sales.Refunds = roundCents(sales.Refunds)
+		report.Products = append(report.Products, *sales)
+
+		category, ok := byCategory[sales.Category]
+		if !ok {
+			category = &CategorySales{Category: sales.Category, Daily: make([]int, len(days))}
+			byCategory[sales.Category] = category
+		}
+		category.Units += sales.Units
+		category.Gross += sales.Gross
+		category.Tax += sales.Tax
+		category.RefundedUnits += sales.RefundedUnits
+		category.Refunds += sales.Refunds
+		for i, units := range sales.Daily {
+			category.Daily[i] += units
+		}
+	}
+	for _, category := range byCategory {
+		category.Gross = roundCents(category.Gross)
+		category.Tax = roundCents(category.Tax)
+		category.Refunds = roundCents(category.Refunds)
+		report.Categories = append(report.Categories, *category)
+	}
+
+	SortProductReport(&report, "gross")
+	return report, nil
+}
+
+// topCategory returns the first segment of the category path recorded with a sale. Sales
+// logged before categories were fall back to the catalog's current category for the product.
+func topCategory(product templates.Product, catalog []templates.Product) string {
+	category := product.Category
+	if category == "" {
+		for _, p := range catalog {
+			if (product.ID != "" && p.ID == product.ID) || (product.ID == "" && p.Name == product.Name) {
+				category = p.Category
+				break
+			}
+		}
+	}
+	category = strings.TrimSpace(strings.Split(strings.Trim(category, "/"), "/")[0])
+	if category == "" {
+		return UncategorizedLabel
+	}
+	return category
+}
+
+// SortProductReport orders the products and categories by one of ProductReportSorts: names
+// alphabetically, amounts largest first. An unknown column sorts by gross revenue.
+func SortProductReport(report *ProductReport, by string) {
+	sort.SliceStable(report.Products, func(i, j int) bool {
+		a, b := report.Products[i], report.Products[j]
+		return sortsBefore(by, a.Name, b.Name,
+			[]float64{float64(a.Units), a.Gross, a.Tax, a.Refunds, a.Net()},
+			[]float64{float64(b.Units), b.Gross, b.Tax, b.Refunds, b.Net()})
+	})
+	sort.SliceStable(report.Categories, func(i, j int) bool {
+		a, b := report.Categories[i], report.Categories[j]
+		return sortsBefore(by, a.Category, b.Category,
+			[]float64{float64(a.Units), a.Gross, a.Tax, a.Refunds, a.Net()},
+			[]float64{float64(b.Units), b.Gross, b.Tax, b.Refunds, b.Net()})
+	})
+}
+
+// sortsBefore compares two report rows by name or by one of their amounts (units, gross, tax,
+// refunds, net), breaking ties by name
+func sortsBefore(by, aName, bName string, a, b []float64) bool {
+	column := slices.Index(ProductReportSorts, by) - 1
+	if column == -2 {
+		column = 1 // gross
+	}
+	if column >= 0 && a[column] != b[column] {
+		return a[column] > b[column]
+	}
+	return strings.ToLower(aName) < strings.ToLower(bName)
+}
+
+// ProductReportCSVHeader is the column layout of an exported product report
+var ProductReportCSVHeader = []string{
+	"Type", "Product ID", "Name", "Category", "Units Sold", "Gross Revenue", "Tax Collected",
+	"Units Refunded", "Refunds", "Net Revenue",
+}
+
+// WriteProductReportCSV writes a product report as CSV: one row per product, then one per category
+func WriteProductReportCSV(w io.Writer, report ProductReport) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(ProductReportCSVHeader); err != nil {
+		return err
+	}
+	money := func(amount float64) string {
+		return strconv.FormatFloat(math.Round(amount*100)/100, 'f', 2, 64)
+	}
+	for _, p := range report.Products {
+		if err := writer.Write([]string{
+			"Product", p.ProductID, p.Name, p.Category, strconv.Itoa(p.Units), money(p.Gross), money(p.Tax),
+			strconv.Itoa(p.RefundedUnits), money(p.Refunds), money(p.Net()),
+		}); err != nil {
+			return err
+		}
+	}
+	for _, c := range report.Categories {
+		if err := writer.Write([]string{
+			"Category", "", c.Category, c.Category, strconv.Itoa(c.Units), money(c.Gross), money(c.Tax),
+			strconv.Itoa(c.RefundedUnits), money(c.Refunds), money(c.Net()),
+		}); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
@@ -0,0 +1,99 @@
+package services
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"checkout/templates"
+	"checkout/utils"
+)
+
+// SetProducts replaces the product catalog and rebuilds the lookups derived from it
+// The cashier's place in the category navigation is kept, as it lives in UI.
+func SetProducts(products []templates.Product) {
+	Catalog.Set(products)
+}
+
+// BuildSKUIndex builds the SKU -> product lookup used by barcode scanning.
+// Duplicate SKUs are logged and the first product keeps the SKU.
+func BuildSKUIndex(products []templates.Product) map[string]templates.Product {
+	index := make(map[string]templates.Product)
+	for _, product := range products {
+		sku := NormalizeSKU(product.SKU)
+		if sku == "" {
+			continue
+		}
+		if existing, exists := index[sku]; exists {
+			utils.Error("products", "Duplicate SKU, ignoring for scanning", "sku", sku, "product", product.Name, "existing_product", existing.Name)
+			continue
+		}
+		index[sku] = product
+	}
+	return index
+}
+
+// FindProductBySKU looks up a product by scanned SKU/barcode
+func FindProductBySKU(code string) (templates.Product, bool) {
+	return Catalog.FindBySKU(code)
+}
+
+// NormalizeSKU trims whitespace that keyboard-wedge scanners may add around a code
+func NormalizeSKU(sku string) string {
+	return strings.TrimSpace(sku)
+}
+
+// ValidateProductSKUs rejects product lists where two products share a SKU
+func ValidateProductSKUs(products []templates.Product) error {
+	seen := make(map[string]string)
+	for _, product := range products {
+		sku := NormalizeSKU(product.SKU)
+		if sku == "" {
+			continue
+		}
+		if existing, exists := seen[sku]; exists {
+			return fmt.Errorf("SKU %s is already used by %s", sku, existing)
+		}
+		seen[sku] = product.Name
+	}
+	return nil
+}
+
+// AddProduct adds a new product to the catalog, creating its Stripe product and price,
+// and saves the catalog to products.json
+func AddProduct(product templates.Product) (templates.Product, error) {
+	product.SKU = NormalizeSKU(product.SKU)
+	if product.SKU != "" {
+		// Check before creating Stripe objects so a rejected product leaves nothing behind
+		if existing, exists := FindProductBySKU(product.SKU); exists {
+			return templates.Product{}, fmt.Errorf("SKU %s is already used by %s", product.SKU, existing.Name)
+		}
+	}
+
+	current := Catalog.Products()
+	product.ID = nextProductID(current)
+
+	if _, err := EnsureServiceHasPriceID(&product); err != nil {
+		return templates.Product{}, fmt.Errorf("error creating Stripe product: %w", err)
+	}
+
+	products := append(current, product)
+	if err := SaveProducts(products); err != nil {
+		return templates.Product{}, err
+	}
+
+	SetProducts(products)
+	utils.Info("products", "Product added", "id", product.ID, "name", product.Name, "sku", product.SKU)
+	return product, nil
+}
+
+// nextProductID returns the next numeric product ID after the highest one in use
+func nextProductID(products []templates.Product) string {
+	highest := 0
+	for _, product := range products {
+		if id, err := strconv.Atoi(product.ID); err == nil && id > highest {
+			highest = id
+		}
+	}
+	return strconv.Itoa(highest + 1)
+}
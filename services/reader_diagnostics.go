@@ -0,0 +1,162 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"checkout/templates"
+	"checkout/utils"
+
+	"github.com/stripe/stripe-go/v74"
+)
+
+// readerLogLines is how many reader log lines the diagnostics page shows
+const readerLogLines = 20
+
+// ReaderDiagnostics is what support asks for when a reader misbehaves
+type ReaderDiagnostics struct {
+	templates.StripeReader
+	ActionType     string    // Reader's last action, e.g. "process_payment_intent" ("" = none)
+	ActionStatus   string    // "in_progress", "succeeded" or "failed"
+	FailureCode    string    // Why the last action failed
+	FailureMessage string    // Stripe's description of the failure
+	LastSeen       time.Time // Last time the reader answered the POS (zero = not since startup)
+	Degraded       bool      // The reader stopped answering the keep-alive checks
+	Error          string    // Why the reader couldn't be fetched from Stripe
+}
+
+// ReaderDiagnosticsReport fetches every reader at the selected location from Stripe, for their
+// current status and last action. A reader Stripe doesn't answer for keeps its cached details.
+func ReaderDiagnosticsReport() []ReaderDiagnostics {
+	var report []ReaderDiagnostics
+	for _, cached := range Terminal.Readers() {
+		diagnostics := ReaderDiagnostics{StripeReader: cached}
+		diagnostics.Degraded, diagnostics.LastSeen = Terminal.ReaderDegraded(cached.ID)
+
+		reader, err := Stripe.GetReader(cached.ID)
+		if err != nil {
+			utils.Warn("terminal", "Error fetching reader for diagnostics", "reader_id", cached.ID, "error", err)
+			diagnostics.Error = err.Error()
+			report = append(report, diagnostics)
+			continue
+		}
+
+		diagnostics.StripeReader = templates.StripeReader{
+			ID:              reader.ID,
+			Label:           reader.Label,
+			Livemode:        reader.Livemode,
+			Status:          reader.Status,
+			DeviceType:      string(reader.DeviceType),
+			LocationID:      cached.LocationID,
+			SerialNumber:    reader.SerialNumber,
+			IPAddress:       reader.IPAddress,
+			DeviceSwVersion: reader.DeviceSwVersion,
+		}
+		if action := reader.Action; action != nil {
+			diagnostics.ActionType = string(action.Type)
+			diagnostics.ActionStatus = string(action.Status)
+			diagnostics.FailureCode = action.FailureCode
+			diagnostics.FailureMessage = action.FailureMessage
+		}
+		report = append(report, diagnostics)
+	}
+	return report
+}
+
+// FormatReaderDiagnostics writes the diagnostics as plain text for a support ticket
+func FormatReaderDiagnostics(report []ReaderDiagnostics, location templates.StripeLocation, now time.Time) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Reader diagnostics, %s\n", now.Format(time.RFC3339))
+	fmt.Fprintf(&b, "Location: %s (%s)\n", location.DisplayName, location.ID)
+	if len(report) == 0 {
+		fmt.Fprintln(&b, "No readers at this location")
+	}
+	for _, d := range report {
+		fmt.Fprintf(&b, "\n%s (%s)\n", d.Label, d.ID)
+		fmt.Fprintf(&b, "  Status:           %s\n", d.Status)
+		fmt.Fprintf(&b, "  Device type:      %s\n", d.DeviceType)
+		fmt.Fprintf(&b, "  Serial number:    %s\n", d.SerialNumber)
+		fmt.Fprintf(&b, "  IP address:       %s\n", d.IPAddress)
+		fmt.Fprintf(&b, "  Software version: %s\n", d.DeviceSwVersion)
+		fmt.Fprintf(&b, "  Last action:      %s\n", d.LastAction())
+		fmt.Fprintf(&b, "  Last answered:    %s\n", d.LastSeenText())
+		if d.Degraded {
+			fmt.Fprintln(&b, "  Degraded:         stopped answering the keep-alive checks")
+		}
+		if d.Error != "" {
+			fmt.Fprintf(&b, "  Stripe error:     %s\n", d.Error)
+		}
+	}
+	return b.String()
+}
+
+// LastAction describes the reader's last action, e.g. "process_payment_intent failed: Card declined (card_declined)"
+func (d ReaderDiagnostics) LastAction() string {
+	if d.ActionType == "" {
+		return "none"
+	}
+	text := d.ActionType + " " + d.ActionStatus
+	if d.FailureMessage != "" {
+		text += ": " + d.FailureMessage
+	}
+	if d.FailureCode != "" {
+		text += " (" + d.FailureCode + ")"
+	}
+	return text
+}
+
+// LastSeenText is when the reader last answered the POS, or "not since startup"
+func (d ReaderDiagnostics) LastSeenText() string {
+	if d.LastSeen.IsZero() {
+		return "not since startup"
+	}
+	return d.LastSeen.Format(time.RFC3339)
+}
+
+// TestReaderConnectivity shows a test cart on a reader's display and clears it again, which
+// proves Stripe can reach the reader without taking a payment. A reader busy with a payment is
+// left alone, since clearing the display would cancel it. Returns how long the round trip took.
+func TestReaderConnectivity(readerID string) (time.Duration, error) {
+	start := time.Now()
+	reader, err := Stripe.GetReader(readerID)
+	if err != nil {
+		return 0, fmt.Errorf("error fetching reader: %w", err)
+	}
+	if reader.Action != nil && reader.Action.Status == stripe.TerminalReaderActionStatusInProgress {
+		return 0, fmt.Errorf("reader is busy with %s", reader.Action.Type)
+	}
+
+	params := &stripe.TerminalReaderSetReaderDisplayParams{
+		Type: stripe.String("cart"),
+		Cart: &stripe.TerminalReaderSetReaderDisplayCartParams{
+			Currency: stripe.String(string(stripe.CurrencyUSD)),
+			LineItems: []*stripe.TerminalReaderSetReaderDisplayCartLineItemParams{{
+				Amount:      stripe.Int64(0),
+				Description: stripe.String("Connectivity test"),
+				Quantity:    stripe.Int64(1),
+			}},
+			Total: stripe.Int64(0),
+		},
+	}
+	if _, err := Stripe.SetReaderDisplay(readerID, params); err != nil {
+		return 0, fmt.Errorf("error setting the reader display: %w", err)
+	}
+	if _, err := Stripe.CancelReaderAction(readerID); err != nil {
+		return 0, fmt.Errorf("error clearing the reader display: %w", err)
+	}
+
+	elapsed := time.Since(start)
+	Terminal.ReaderSeen(readerID, time.Now())
+	utils.Info("terminal", "Reader connectivity test passed", "reader_id", readerID, "elapsed", elapsed.String())
+	return elapsed, nil
+}
+
+// ReaderLogLines returns the last reader-related lines of the JSON log file: terminal messages
+// and any line naming a reader
+func ReaderLogLines(logFile string) ([]string, error) {
+	return utils.TailLogLines(logFile, readerLogLines, func(record map[string]any) bool {
+		_, hasReader := record["reader_id"]
+		return record["subsystem"] == "terminal" || hasReader
+	})
+}
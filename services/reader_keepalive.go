@@ -0,0 +1,132 @@
+package services
+
+import (
+	"sync"
+	"time"
+
+	"checkout/config"
+	"checkout/templates"
+	"checkout/utils"
+)
+
+// Wi-Fi readers can drop off the network while idle (overnight, say) and still show as online
+// in the cached reader list until the first payment fails. The keep-alive asks Stripe for the
+// readers the registers picked every few minutes during business hours, so the POS can warn the
+// cashier first.
+const readerKeepAliveCheckInterval = time.Minute
+
+// readerKeepAliveState tracks when the picked readers were last checked
+var readerKeepAliveState = struct {
+	lastCheck time.Time
+	mutex     sync.Mutex
+}{}
+
+// StartReaderKeepAlive starts the background job that checks the picked readers are reachable
+func StartReaderKeepAlive() {
+	go func() {
+		ticker := time.NewTicker(readerKeepAliveCheckInterval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			checkReaderKeepAlive(time.Now())
+		}
+	}()
+
+	utils.Info("terminal", "Reader keep-alive started", "interval", config.GetReaderKeepAliveInterval().String(),
+		"business_hours_start", config.Config.BusinessHoursStart, "business_hours_end", config.Config.BusinessHoursEnd)
+}
+
+// checkReaderKeepAlive pings the picked readers once the configured interval has passed
+func checkReaderKeepAlive(now time.Time) {
+	interval := config.GetReaderKeepAliveInterval()
+	readerIDs := keepAliveReaderIDs()
+	if interval == 0 || len(readerIDs) == 0 || !config.WithinBusinessHours(now) {
+		return
+	}
+
+	readerKeepAliveState.mutex.Lock()
+	if now.Sub(readerKeepAliveState.lastCheck) < interval {
+		readerKeepAliveState.mutex.Unlock()
+		return
+	}
+	readerKeepAliveState.lastCheck = now
+	readerKeepAliveState.mutex.Unlock()
+
+	for _, readerID := range readerIDs {
+		PingReader(readerID)
+	}
+}
+
+// keepAliveReaderIDs returns the readers at the location picked by a register, or picked last
+func keepAliveReaderIDs() []string {
+	picked := map[string]bool{Terminal.SelectedReaderID(): true}
+	for _, id := range RememberedReaderIDs() {
+		picked[id] = true
+	}
+
+	var ids []string
+	for _, reader := range Terminal.Readers() {
+		if picked[reader.ID] {
+			ids = append(ids, reader.ID)
+		}
+	}
+	return ids
+}
+
+// PingReader asks Stripe for a reader's status, updates the cached status, and records whether
+// it answered. A reader that hasn't answered for the configured time is marked degraded.
+// Reports whether the reader is online.
+func PingReader(readerID string) bool {
+	now := time.Now()
+	reader, err := Stripe.GetReader(readerID)
+	if err == nil {
+		Terminal.SetReaderStatus(readerID, reader.Status)
+	}
+
+	if err == nil && reader.Status == "online" {
+		if Terminal.ReaderSeen(readerID, now) {
+			utils.Info("terminal", "Reader is answering again", "reader_id", readerID)
+			publishReaderStatus(readerID, false)
+		}
+		return true
+	}
+
+	status := ""
+	if reader != nil {
+		status = reader.Status
+	}
+	utils.Debug("terminal", "Reader keep-alive check failed", "reader_id", readerID, "status", status, "error", err)
+	if Terminal.ReaderUnreachable(readerID, now, config.GetReaderDegradedAfter()) {
+		utils.Warn("terminal", "Reader has stopped answering, marked degraded", "reader_id", readerID,
+			"status", status, "error", err, "after", config.GetReaderDegradedAfter().String())
+		publishReaderStatus(readerID, true)
+	}
+	return false
+}
+
+// publishReaderStatus tells the POS screens that a reader stopped or started answering again,
+// so their banners update without waiting for the next refresh
+func publishReaderStatus(readerID string, degraded bool) {
+	PublishAppEvent(AppEvent{Name: EventReaderStatusChanged, Detail: map[string]any{"readerId": readerID, "degraded": degraded}})
+	recordReaderActivity(readerID, degraded)
+}
+
+// DegradedReader returns a register's reader when it has stopped answering the keep-alive
+// checks, with the time it last answered (zero if never since startup)
+func DegradedReader(readerID string) (templates.StripeReader, time.Time, bool) {
+	if readerID == "" {
+		return templates.StripeReader{}, time.Time{}, false
+	}
+	degraded, lastSeen := Terminal.ReaderDegraded(readerID)
+	if !degraded {
+		return templates.StripeReader{}, time.Time{}, false
+	}
+
+	reader := templates.StripeReader{ID: readerID}
+	for _, r := range Terminal.Readers() {
+		if r.ID == readerID {
+			reader = r
+		}
+	}
+	return reader, lastSeen, true
+}
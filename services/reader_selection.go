@@ -0,0 +1,217 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"checkout/config"
+	"checkout/templates"
+	"checkout/utils"
+)
+
+// Why a register is not using the reader it picked
+const (
+	ReaderFallbackUnchosen = "unchosen" // The register never picked a reader and the location has several
+	ReaderFallbackMissing  = "missing"  // The picked reader is no longer at the selected location
+	ReaderFallbackOffline  = "offline"  // The picked reader is offline and another one is online
+)
+
+// ReaderChoice is the reader a register's payments go to
+type ReaderChoice struct {
+	ReaderID   string // Reader payments from the register go to ("" = none)
+	Remembered string // Reader the register picked ("" = never picked)
+	Fallback   string // ReaderFallback* reason ReaderID isn't the remembered reader ("" = it is)
+}
+
+// readerSelectionsFile is the saved reader selections
+type readerSelectionsFile struct {
+	Devices map[string]string `json:"devices"` // Device ID -> reader ID
+	Last    string            `json:"last"`    // Reader picked most recently on any device
+}
+
+// readerSelections holds the reader each register's browser (device) picked, and the one picked
+// last on any of them. They are persisted so a restart doesn't send one register's payments to
+// another register's reader.
+var readerSelections = struct {
+	readerSelectionsFile
+	loaded bool
+	mutex  sync.Mutex
+}{readerSelectionsFile: readerSelectionsFile{Devices: make(map[string]string)}}
+
+// SelectedReaderFor returns the reader a device's payments go to: the reader it picked while that
+// reader is at the location and online. Otherwise it falls back to the first online reader (or
+// the only reader), and Fallback says why so the POS can warn the cashier. The fallback is not
+// remembered, so the picked reader is used again as soon as it is back.
+func SelectedReaderFor(device string) ReaderChoice {
+	readerSelections.mutex.Lock()
+	if err := ensureReaderSelectionsLoaded(); err != nil {
+		utils.Error("terminal", "Error loading reader selections", "error", err)
+	}
+	choice := ReaderChoice{Remembered: readerSelections.Devices[device]}
+	readerSelections.mutex.Unlock()
+
+	readers := Terminal.Readers()
+	if len(readers) == 0 {
+		return choice
+	}
+
+	fallback := readers[0].ID
+	for _, reader := range readers {
+		if reader.Status == "online" {
+			fallback = reader.ID
+			break
+		}
+	}
+
+	if choice.Remembered == "" {
+		choice.ReaderID = fallback
+		if len(readers) > 1 {
+			choice.Fallback = ReaderFallbackUnchosen
+		}
+		return choice
+	}
+
+	for _, reader := range readers {
+		if reader.ID != choice.Remembered {
+			continue
+		}
+		if reader.Status == "online" || fallback == reader.ID || !readerIsOnline(readers, fallback) {
+			// Staying on an offline reader beats sending the payment to another register's
+			// reader when no other reader is online either
+			choice.ReaderID = reader.ID
+			if reader.Status != "online" {
+				choice.Fallback = ReaderFallbackOffline
+			}
+			return choice
+		}
+		choice.ReaderID = fallback
+		choice.Fallback = ReaderFallbackOffline
+		return choice
+	}
+
+	choice.ReaderID = fallback
+	choice.Fallback = ReaderFallbackMissing
+	return choice
+}
+
+// readerIsOnline reports whether a reader of the list is online
+func readerIsOnline(readers []templates.StripeReader, readerID string) bool {
+	for _, reader := range readers {
+		if reader.ID == readerID {
+			return reader.Status == "online"
+		}
+	}
+	return false
+}
+
+// RememberReader records the reader a device picked and makes it the register's last selected
+// reader, used by the API and restored on startup
+func RememberReader(device, readerID string) error {
+	Terminal.SelectReader(readerID)
+
+	readerSelections.mutex.Lock()
+	defer readerSelections.mutex.Unlock()
+
+	if err := ensureReaderSelectionsLoaded(); err != nil {
+		return err
+	}
+	if device != "" {
+		readerSelections.Devices[device] = readerID
+	}
+	readerSelections.Last = readerID
+	return saveReaderSelections()
+}
+
+// RememberedReaderIDs returns the readers picked by any device, for the keep-alive checks
+func RememberedReaderIDs() []string {
+	readerSelections.mutex.Lock()
+	defer readerSelections.mutex.Unlock()
+
+	if err := ensureReaderSelectionsLoaded(); err != nil {
+		utils.Error("terminal", "Error loading reader selections", "error", err)
+	}
+	seen := make(map[string]bool)
+	var ids []string
+	for _, id := range readerSelections.Devices {
+		if id != "" && !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// restoreLastReader selects the reader picked last before the restart, if it is at the location.
+// A reader no longer there is left unselected rather than replaced.
+func restoreLastReader() {
+	readerSelections.mutex.Lock()
+	if err := ensureReaderSelectionsLoaded(); err != nil {
+		utils.Error("terminal", "Error loading reader selections", "error", err)
+	}
+	last := readerSelections.Last
+	readerSelections.mutex.Unlock()
+
+	if last == "" || Terminal.SelectedReaderID() != "" {
+		return
+	}
+	for _, reader := range Terminal.Readers() {
+		if reader.ID == last {
+			Terminal.SelectReader(last)
+			utils.Info("terminal", "Restored last selected reader", "reader_id", last, "status", reader.Status)
+			return
+		}
+	}
+	utils.Warn("terminal", "Last selected reader is not at this location", "reader_id", last)
+}
+
+// ensureReaderSelectionsLoaded reads the reader selections file once. Callers must hold readerSelections.mutex.
+func ensureReaderSelectionsLoaded() error {
+	if readerSelections.loaded {
+		return nil
+	}
+
+	data, err := os.ReadFile(getReaderSelectionsFilePath())
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("error reading reader selections file: %w", err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &readerSelections.readerSelectionsFile); err != nil {
+			return fmt.Errorf("error parsing reader selections file: %w", err)
+		}
+		if readerSelections.Devices == nil {
+			readerSelections.Devices = make(map[string]string)
+		}
+	}
+
+	readerSelections.loaded = true
+	return nil
+}
+
+// saveReaderSelections writes the reader selections to the data directory. Callers must hold readerSelections.mutex.
+func saveReaderSelections() error {
+	jsonData, err := json.MarshalIndent(readerSelections.readerSelectionsFile, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error marshaling reader selections: %w", err)
+	}
+
+	path := getReaderSelectionsFilePath()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("error creating data directory: %w", err)
+	}
+
+	if err := os.WriteFile(path, jsonData, 0644); err != nil {
+		return fmt.Errorf("error writing reader selections file: %w", err)
+	}
+	return nil
+}
+
+func getReaderSelectionsFilePath() string {
+	dataDir := config.Config.DataDir
+	if dataDir == "" {
+		dataDir = config.DefaultDataDir
+	}
+	return filepath.Join(dataDir, "selected_readers.json")
+}
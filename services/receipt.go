@@ -7,7 +7,6 @@ import (
 	"path/filepath"
 	"time"
 
-	"checkout/config"
 	"checkout/templates"
 	"checkout/utils"
 )
@@ -26,7 +25,9 @@ func SaveReceiptRecord(record templates.ReceiptRecord) error {
 		return fmt.Errorf("failed to create receipts directory: %v", err)
 	}
 
-	// Open file for appending
+	// Open file for appending; a redaction rewriting the log must not lose the line
+	customerLogMutex.Lock()
+	defer customerLogMutex.Unlock()
 	file, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 	if err != nil {
 		return fmt.Errorf("failed to open receipts log file: %v", err)
@@ -66,7 +67,9 @@ func SavePaymentUpdateRecord(record templates.PaymentUpdateRecord) error {
 		return fmt.Errorf("failed to create updates directory: %v", err)
 	}
 
-	// Open file for appending
+	// Open file for appending; a redaction rewriting the log must not lose the line
+	customerLogMutex.Lock()
+	defer customerLogMutex.Unlock()
 	file, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 	if err != nil {
 		return fmt.Errorf("failed to open updates log file: %v", err)
@@ -157,15 +160,9 @@ func LogStripeCustomerInfo(paymentID, stripeEmail string) error {
 // Helper functions
 
 func getReceiptsDir() string {
-	if config.Config.TransactionsDir != "" {
-		return filepath.Join(config.Config.TransactionsDir, "receipts")
-	}
-	return filepath.Join(config.DefaultTransactionsDir, "receipts")
+	return filepath.Join(getTransactionsDir(), "receipts")
 }
 
 func getUpdatesDir() string {
-	if config.Config.TransactionsDir != "" {
-		return filepath.Join(config.Config.TransactionsDir, "updates")
-	}
-	return filepath.Join(config.DefaultTransactionsDir, "updates")
+	return filepath.Join(getTransactionsDir(), "updates")
 }
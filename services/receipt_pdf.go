@@ -0,0 +1,373 @@
+package services
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"image"
+	"math"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"checkout/config"
+	"checkout/i18n"
+	"checkout/templates"
+	"checkout/utils"
+)
+
+// Receipt PDF layout (points, US Letter page, monospaced Courier text)
+const (
+	receiptPDFPageWidth   = 612
+	receiptPDFPageHeight  = 792
+	receiptPDFMargin      = 72
+	receiptPDFFontSize    = 10
+	receiptPDFLineHeight  = 14
+	receiptPDFColumnWidth = 48 // Characters per receipt line
+	receiptPDFLogoWidth   = 216
+	receiptPDFLogoHeight  = 72
+)
+
+// pdfImage is an RGB image embedded in a PDF, drawn at the given size in points
+type pdfImage struct {
+	pixelWidth, pixelHeight int
+	width, height           float64
+	data                    []byte // Zlib-compressed RGB samples
+}
+
+// GenerateReceiptPDF renders a transaction receipt as a PDF document
+func GenerateReceiptPDF(transaction *templates.Transaction) ([]byte, error) {
+	if transaction == nil {
+		return nil, fmt.Errorf("no transaction to render")
+	}
+
+	lines := receiptTextLines(transaction)
+
+	// The logo is drawn above the text on the first page, in the space of some blank lines
+	var logo *pdfImage
+	if config.Config.ReceiptLogo != "" {
+		if img, err := loadReceiptLogo(); err != nil {
+			utils.Warn("receipt", "Printing PDF receipt without logo", "error", err)
+		} else if logo, err = newPDFImage(img); err != nil {
+			utils.Warn("receipt", "Printing PDF receipt without logo", "error", err)
+		}
+	}
+	if logo != nil {
+		reserved := int(math.Ceil(logo.height/receiptPDFLineHeight)) + 1
+		lines = append(make([]string, reserved), lines...)
+	}
+
+	// Split lines into pages
+	linesPerPage := (receiptPDFPageHeight - 2*receiptPDFMargin) / receiptPDFLineHeight
+	var pages [][]string
+	for len(lines) > 0 {
+		n := min(linesPerPage, len(lines))
+		pages = append(pages, lines[:n])
+		lines = lines[n:]
+	}
+
+	return buildTextPDF(pages, logo), nil
+}
+
+// receiptTextLines lays out the receipt as fixed-width text lines
+func receiptTextLines(transaction *templates.Transaction) []string {
+	cfg := config.Config
+	separator := strings.Repeat("-", receiptPDFColumnWidth)
+
+	var lines []string
+	center := func(text string) {
+		if text == "" {
+			return
+		}
+		padding := max(0, (receiptPDFColumnWidth-utf8.RuneCountInString(text))/2)
+		lines = append(lines, strings.Repeat(" ", padding)+text)
+	}
+	row := func(label string, amount float64) {
+		value := i18n.Money(amount)
+		width := receiptPDFColumnWidth - utf8.RuneCountInString(value) - 1
+		if runes := []rune(label); len(runes) > width {
+			label = string(runes[:width])
+		}
+		padding := strings.Repeat(" ", max(0, width-utf8.RuneCountInString(label)))
+		lines = append(lines, label+padding+" "+value)
+	}
+
+	// Business header
+	center(cfg.BusinessName)
+	center(cfg.BusinessStreet)
+	cityLine := strings.Trim(fmt.Sprintf("%s, %s %s", cfg.BusinessCity, cfg.BusinessState, cfg.BusinessZIP), ", ")
+	center(cityLine)
+	if cfg.BusinessTaxID != "" {
+		center(i18n.T("receipt.tax_id", cfg.BusinessTaxID))
+	}
+	if cfg.SalesTaxNumber != "" {
+		center(i18n.T("receipt.sales_tax_number", cfg.SalesTaxNumber))
+	}
+	if cfg.VATNumber != "" {
+		center(i18n.T("receipt.vat_number", cfg.VATNumber))
+	}
+
+	lines = append(lines, "", i18n.T("receipt.date", ReceiptDateTime(transaction)))
+	if transaction.Voided {
+		center("*** " + i18n.T("receipt.voided") + " ***")
+	}
+	lines = append(lines, separator)
+
+	// Line items with their individual tax
+	for i, product := range transaction.Products {
+		row(product.Name, product.Price)
+		if IsMeasuredLine(product) {
+			lines = append(lines, "  "+MeasuredDetail(product))
+		}
+		if product.DescriptionEdited && product.Description != "" {
+			for _, line := range wrapText(product.Description, receiptPDFColumnWidth-2) {
+				lines = append(lines, "  "+line)
+			}
+		}
+		if i < len(transaction.ProductTaxes) && transaction.ProductTaxes[i] > 0 {
+			row("  "+i18n.T("receipt.tax"), transaction.ProductTaxes[i])
+		}
+	}
+
+	lines = append(lines, separator)
+	row(i18n.T("receipt.subtotal"), transaction.Subtotal)
+	row(i18n.T("receipt.tax"), transaction.Tax)
+	for _, component := range TaxBreakdown(transaction.ProductTaxComponents) {
+		row("  "+component.Name, component.Amount)
+	}
+	if transaction.ServiceFee != 0 {
+		row(ServiceFeeLabel(), transaction.ServiceFee)
+	}
+	if transaction.TipAmount > 0 {
+		row(i18n.T("receipt.tip"), transaction.TipAmount)
+	}
+	row(i18n.T("receipt.total"), transaction.AmountPaid())
+	lines = append(lines, separator,
+		i18n.T("receipt.payment_method", PaymentMethodLabel(transaction.PaymentType)),
+	)
+	if card := CardLabel(transaction); card != "" {
+		lines = append(lines, i18n.T("receipt.card", card))
+	}
+	for _, tender := range transaction.Tenders {
+		row("  "+TenderLabel(tender), tender.Amount)
+	}
+	lines = append(lines, i18n.T("receipt.confirmation_code", transaction.ConfirmationCode))
+	if transaction.OrderNumber > 0 {
+		lines = append(lines, i18n.T("ticket.order_number", transaction.OrderNumber))
+	}
+	if transaction.Note != "" {
+		lines = append(lines, wrapText(i18n.T("receipt.note", transaction.Note), receiptPDFColumnWidth)...)
+	}
+	lines = append(lines, "")
+	for _, line := range wrapText(config.GetReceiptFooter(), receiptPDFColumnWidth) {
+		center(line)
+	}
+	if policy := strings.TrimSpace(cfg.ReturnPolicy); policy != "" {
+		lines = append(lines, "", i18n.T("receipt.return_policy")+":")
+		for _, paragraph := range strings.Split(policy, "\n") {
+			lines = append(lines, wrapText(paragraph, receiptPDFColumnWidth)...)
+		}
+	}
+
+	return lines
+}
+
+// wrapText breaks text into lines of at most width characters at spaces (a longer word is split)
+func wrapText(text string, width int) []string {
+	var lines []string
+	line := ""
+	for _, word := range strings.Fields(text) {
+		for len([]rune(word)) > width {
+			if line != "" {
+				lines = append(lines, line)
+				line = ""
+			}
+			lines = append(lines, string([]rune(word)[:width]))
+			word = string([]rune(word)[width:])
+		}
+		switch {
+		case line == "":
+			line = word
+		case len([]rune(line))+1+len([]rune(word)) <= width:
+			line += " " + word
+		default:
+			lines = append(lines, line)
+			line = word
+		}
+	}
+	if line != "" {
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// PaymentMethodLabel returns a customer-facing name for a logged payment type
+func PaymentMethodLabel(paymentType string) string {
+	switch paymentType {
+	case "terminal":
+		return i18n.T("method.terminal")
+	case "qr":
+		return i18n.T("method.qr_card")
+	case "manual":
+		return i18n.T("method.manual_card")
+	case CashPaymentMethod:
+		return i18n.T("method.cash")
+	case GiftCardPaymentMethod:
+		return i18n.T("method.gift_card")
+	case SplitPaymentMethod:
+		return i18n.T("method.split")
+	case ReturnPaymentMethod:
+		return i18n.T("method.return")
+	default:
+		return paymentType
+	}
+}
+
+// ReceiptDateTime returns when a transaction happened, formatted for the configured locale
+func ReceiptDateTime(transaction *templates.Transaction) string {
+	when, err := time.Parse("01/02/2006 15:04:05", transaction.Date+" "+transaction.Time)
+	if err != nil {
+		return strings.TrimSpace(transaction.Date + " " + transaction.Time)
+	}
+	return i18n.DateTime(when)
+}
+
+// CardLabel returns the card brand and last four digits for display (e.g. "VISA **** 4242"),
+// or an empty string if the payment has no card details
+func CardLabel(transaction *templates.Transaction) string {
+	if transaction.CardLast4 == "" {
+		return strings.ToUpper(transaction.CardBrand)
+	}
+	return strings.TrimSpace(strings.ToUpper(transaction.CardBrand) + " **** " + transaction.CardLast4)
+}
+
+// TenderLabel describes one tender of a split sale (e.g. "Card (Terminal) VISA **** 4242")
+func TenderLabel(tender templates.Tender) string {
+	card := CardLabel(&templates.Transaction{CardBrand: tender.CardBrand, CardLast4: tender.CardLast4})
+	return strings.TrimSpace(PaymentMethodLabel(tender.Method) + " " + card)
+}
+
+// newPDFImage converts an image to RGB samples for a PDF, flattening transparency onto white,
+// and scales it to fit the receipt logo area
+func newPDFImage(img image.Image) (*pdfImage, error) {
+	bounds := img.Bounds()
+	if bounds.Dx() == 0 || bounds.Dy() == 0 {
+		return nil, fmt.Errorf("logo image is empty")
+	}
+
+	var samples bytes.Buffer
+	writer := zlib.NewWriter(&samples)
+	row := make([]byte, 0, 3*bounds.Dx())
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		row = row[:0]
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r, g, b, a := img.At(x, y).RGBA()
+			// Colors are alpha-premultiplied, so adding the uncovered white gives the flattened color
+			white := 0xffff - a
+			row = append(row, byte((r+white)>>8), byte((g+white)>>8), byte((b+white)>>8))
+		}
+		if _, err := writer.Write(row); err != nil {
+			return nil, err
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+
+	scale := min(1, receiptPDFLogoWidth/float64(bounds.Dx()), receiptPDFLogoHeight/float64(bounds.Dy()))
+	return &pdfImage{
+		pixelWidth:  bounds.Dx(),
+		pixelHeight: bounds.Dy(),
+		width:       float64(bounds.Dx()) * scale,
+		height:      float64(bounds.Dy()) * scale,
+		data:        samples.Bytes(),
+	}, nil
+}
+
+// buildTextPDF writes a minimal PDF with one Courier text page per entry in pages.
+// A logo, if given, is drawn centered over the text column at the top of the first page.
+func buildTextPDF(pages [][]string, logo *pdfImage) []byte {
+	var objects []string
+
+	// Object 1: catalog, object 2: page tree, object 3: font, then a page and content stream per page
+	pageCount := len(pages)
+	var kids []string
+	for i := 0; i < pageCount; i++ {
+		kids = append(kids, fmt.Sprintf("%d 0 R", 4+2*i))
+	}
+
+	objects = append(objects,
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), pageCount),
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Courier /Encoding /WinAnsiEncoding >>",
+	)
+
+	// The logo follows the pages
+	logoObject := 4 + 2*pageCount
+
+	for i, pageLines := range pages {
+		var content bytes.Buffer
+		resources := "/Font << /F1 3 0 R >>"
+		if logo != nil && i == 0 {
+			columnCenter := receiptPDFMargin + float64(receiptPDFColumnWidth*receiptPDFFontSize*3/5)/2 // Courier glyphs are 0.6em wide
+			top := float64(receiptPDFPageHeight - receiptPDFMargin + receiptPDFFontSize)
+			fmt.Fprintf(&content, "q\n%.2f 0 0 %.2f %.2f %.2f cm\n/Im1 Do\nQ\n", logo.width, logo.height, columnCenter-logo.width/2, top-logo.height)
+			resources += fmt.Sprintf(" /XObject << /Im1 %d 0 R >>", logoObject)
+		}
+		fmt.Fprintf(&content, "BT\n/F1 %d Tf\n%d TL\n%d %d Td\n", receiptPDFFontSize, receiptPDFLineHeight, receiptPDFMargin, receiptPDFPageHeight-receiptPDFMargin)
+		for _, line := range pageLines {
+			fmt.Fprintf(&content, "(%s) Tj T*\n", escapePDFText(line))
+		}
+		content.WriteString("ET")
+
+		objects = append(objects,
+			fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %d %d] /Resources << %s >> /Contents %d 0 R >>",
+				receiptPDFPageWidth, receiptPDFPageHeight, resources, 5+2*i),
+			fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", content.Len(), content.String()),
+		)
+	}
+
+	if logo != nil {
+		objects = append(objects, fmt.Sprintf("<< /Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace /DeviceRGB /BitsPerComponent 8 /Filter /FlateDecode /Length %d >>\nstream\n%s\nendstream",
+			logo.pixelWidth, logo.pixelHeight, len(logo.data), logo.data))
+	}
+
+	var pdf bytes.Buffer
+	pdf.WriteString("%PDF-1.4\n")
+
+	offsets := make([]int, len(objects))
+	for i, object := range objects {
+		offsets[i] = pdf.Len()
+		fmt.Fprintf(&pdf, "%d 0 obj\n%s\nendobj\n", i+1, object)
+	}
+
+	xrefOffset := pdf.Len()
+	fmt.Fprintf(&pdf, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&pdf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&pdf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xrefOffset)
+
+	return pdf.Bytes()
+}
+
+// escapePDFText escapes a string for use in a PDF literal string.
+// Latin-1 letters (e.g. accented Spanish text) are written as octal codes, which match the
+// font's WinAnsiEncoding; other characters are replaced since the standard fonts cannot render them.
+func escapePDFText(text string) string {
+	var escaped strings.Builder
+	for _, r := range text {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			escaped.WriteRune('\\')
+			escaped.WriteRune(r)
+		case r >= 160 && r <= 255:
+			fmt.Fprintf(&escaped, "\\%03o", r)
+		case r < 32 || r > 126:
+			escaped.WriteRune('?')
+		default:
+			escaped.WriteRune(r)
+		}
+	}
+	return escaped.String()
+}
@@ -0,0 +1,513 @@
+package services
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/stripe/stripe-go/v74"
+
+	"checkout/config"
+	"checkout/templates"
+	"checkout/utils"
+)
+
+// Discrepancy kinds found by reconciliation
+const (
+	DiscrepancyMissingLocally  = "missing_locally"   // Paid on Stripe, no local record
+	DiscrepancyMissingInStripe = "missing_in_stripe" // Recorded locally as paid, not paid on Stripe
+	DiscrepancyAmountMismatch  = "amount_mismatch"   // Paid on both, for different amounts
+)
+
+// reconciliationListLimit is the page size used when listing Stripe objects
+const reconciliationListLimit = 100
+
+// Discrepancy is one payment where the transaction log and Stripe disagree
+type Discrepancy struct {
+	Kind            string
+	PaymentID       string    // ID the POS logs the payment under (PaymentIntent or payment link)
+	PaymentIntentID string    // Stripe PaymentIntent, when one was found
+	Method          string    // terminal, manual or qr
+	LocalAmount     float64   // Amount in the transaction log (sale total plus tip, or tender amount)
+	StripeAmount    float64   // Amount Stripe received
+	StripeStatus    string    // PaymentIntent status, for payments missing in Stripe
+	Time            time.Time // When the payment was taken
+	CustomerEmail   string
+	Imported        bool // A reconstructed row has been written for a payment missing locally
+}
+
+// ReconciliationReport compares one business day of the transaction log with Stripe
+type ReconciliationReport struct {
+	Date          string // Business day covered (YYYY-MM-DD, business timezone)
+	GeneratedAt   time.Time
+	LocalCount    int // Card payments recorded locally during the day
+	StripeCount   int // Successful POS payments on Stripe during the day
+	MatchedCount  int // Payments that agree
+	Discrepancies []Discrepancy
+}
+
+// localPayment is a card payment as recorded in the transaction log
+type localPayment struct {
+	id     string
+	method string
+	amount float64
+	time   time.Time
+}
+
+// stripePayment is a successful POS payment on Stripe
+type stripePayment struct {
+	id       string // ID the POS would log it under
+	intentID string
+	method   string
+	amount   float64
+	created  time.Time
+	email    string
+}
+
+// reconciliationState remembers the latest nightly run so a restart doesn't repeat it
+var reconciliationState = struct {
+	lastRunDate string
+	mutex       sync.Mutex
+}{}
+
+// ReconcileDay compares the card payments in the transaction log with the successful POS payments
+// on Stripe for one business day, which runs from the business day close time (midnight by default)
+// in the business timezone to the same time the next day.
+func ReconcileDay(client StripeClient, day time.Time) (ReconciliationReport, error) {
+	start := config.BusinessDayStart(day)
+	end := config.BusinessDayStart(start.AddDate(0, 0, 1))
+
+	report := ReconciliationReport{
+		Date:        start.Format("2006-01-02"),
+		GeneratedAt: time.Now(),
+	}
+
+	// Payments near midnight can be created on one side of it and logged on the other,
+	// so matching looks a day either way; only the day itself is reported
+	logged, err := loadLocalPayments(start.AddDate(0, 0, -1), end.AddDate(0, 0, 1))
+	if err != nil {
+		return report, err
+	}
+	paid, err := listStripePayments(client, start, end)
+	if err != nil {
+		return report, err
+	}
+
+	matched := make(map[string]bool)
+	for _, payment := range paid {
+		report.StripeCount++
+		record, exists := logged[payment.id]
+		switch {
+		case !exists:
+			report.Discrepancies = append(report.Discrepancies, Discrepancy{
+				Kind:            DiscrepancyMissingLocally,
+				PaymentID:       payment.id,
+				PaymentIntentID: payment.intentID,
+				Method:          payment.method,
+				StripeAmount:    payment.amount,
+				Time:            payment.created,
+				CustomerEmail:   payment.email,
+			})
+		case !sameAmount(record.amount, payment.amount):
+			report.Discrepancies = append(report.Discrepancies, Discrepancy{
+				Kind:            DiscrepancyAmountMismatch,
+				PaymentID:       payment.id,
+				PaymentIntentID: payment.intentID,
+				Method:          record.method,
+				LocalAmount:     record.amount,
+				StripeAmount:    payment.amount,
+				Time:            record.time,
+				CustomerEmail:   payment.email,
+			})
+		default:
+			report.MatchedCount++
+		}
+		matched[payment.id] = true
+	}
+
+	for _, record := range logged {
+		if record.time.Before(start) || !record.time.Before(end) {
+			continue
+		}
+		report.LocalCount++
+		if matched[record.id] {
+			continue
+		}
+
+		// Not in the day's Stripe listing; look it up directly in case it was created outside the day
+		discrepancy, ok := checkLocalPayment(client, record)
+		if ok {
+			report.MatchedCount++
+			continue
+		}
+		report.Discrepancies = append(report.Discrepancies, discrepancy)
+	}
+
+	sort.Slice(report.Discrepancies, func(i, j int) bool {
+		return report.Discrepancies[i].Time.Before(report.Discrepancies[j].Time)
+	})
+
+	utils.Info("reconciliation", "Reconciled day with Stripe", "date", report.Date, "local", report.LocalCount,
+		"stripe", report.StripeCount, "matched", report.MatchedCount, "discrepancies", len(report.Discrepancies))
+	return report, nil
+}
+
+// loadLocalPayments reads the successful card payments logged between from and to, keyed by payment ID.
+// Sales are totalled with their tip; split tenders are listed under their own payment IDs.
+func loadLocalPayments(from, to time.Time) (map[string]*localPayment, error) {
+	payments := make(map[string]*localPayment)
+
+	// Payments that arrived after their day was closed are logged in the next day's log
+	last := config.BusinessDay(to).AddDate(0, 0, 1)
+	for day := config.BusinessDay(from); !day.After(last); day = day.AddDate(0, 0, 1) {
+		records, field, err := readTransactionLog(TransactionLogPath(day))
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("error reading transaction log for %s: %w", day.Format("2006-01-02"), err)
+		}
+
+		for _, record := range records {
+			id := field(record, "Transaction ID")
+			if !isStripePaymentID(id) || !isSuccessfulPaymentType(field(record, "Payment Method")) {
+				continue
+			}
+			logged, err := time.ParseInLocation("01/02/2006 15:04:05", field(record, "Date")+" "+field(record, "Time"), time.Local)
+			if err != nil || logged.Before(from) || !logged.Before(to) {
+				continue
+			}
+
+			var amount float64
+			if tender := field(record, "Tender Amount"); tender != "" {
+				amount, _ = strconv.ParseFloat(tender, 64)
+			} else {
+				total, _ := strconv.ParseFloat(field(record, "Total"), 64)
+				tip, _ := strconv.ParseFloat(field(record, "Tip Amount"), 64)
+				amount = total + tip
+			}
+
+			payment, exists := payments[id]
+			if !exists {
+				payment = &localPayment{id: id, method: field(record, "Payment Method"), time: logged}
+				payments[id] = payment
+			}
+			payment.amount += amount
+		}
+	}
+	return payments, nil
+}
+
+// listStripePayments lists the successful POS payments created during [start, end).
+// Payment link payments are keyed by link, like the transaction log; PaymentIntents
+// created by anything other than the POS are left out.
+func listStripePayments(client StripeClient, start, end time.Time) ([]stripePayment, error) {
+	// A checkout session can be opened the day before it is paid, so look back a day for sessions
+	sessionParams := &stripe.CheckoutSessionListParams{}
+	sessionParams.Limit = stripe.Int64(reconciliationListLimit)
+	sessionParams.Filters.AddFilter("created", "gte", strconv.FormatInt(start.AddDate(0, 0, -1).Unix(), 10))
+	sessionParams.Filters.AddFilter("created", "lt", strconv.FormatInt(end.Unix(), 10))
+	sessions, err := withStripeRetry("checkout.session.List", func() ([]*stripe.CheckoutSession, error) {
+		return client.ListCheckoutSessions(sessionParams)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error listing checkout sessions: %w", err)
+	}
+
+	linkByIntent := make(map[string]string)
+	for _, s := range sessions {
+		if s.Status != stripe.CheckoutSessionStatusComplete || s.PaymentLink == nil || s.PaymentIntent == nil {
+			continue
+		}
+		// Duplicate payments are logged and refunded separately
+		if IsDuplicateSession(s.ID) {
+			linkByIntent[s.PaymentIntent.ID] = ""
+			continue
+		}
+		linkByIntent[s.PaymentIntent.ID] = s.PaymentLink.ID
+	}
+
+	intentParams := &stripe.PaymentIntentListParams{
+		CreatedRange: &stripe.RangeQueryParams{
+			GreaterThanOrEqual: start.Unix(),
+			LesserThan:         end.Unix(),
+		},
+	}
+	intentParams.Limit = stripe.Int64(reconciliationListLimit)
+	intents, err := withStripeRetry("paymentintent.List", func() ([]*stripe.PaymentIntent, error) {
+		return client.ListPaymentIntents(intentParams)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error listing payment intents: %w", err)
+	}
+
+	var payments []stripePayment
+	for _, intent := range intents {
+		if intent.Status != stripe.PaymentIntentStatusSucceeded {
+			continue
+		}
+
+		payment := stripePayment{
+			id:       intent.ID,
+			intentID: intent.ID,
+			method:   intent.Metadata[MetadataPaymentMethod],
+			amount:   float64(intent.AmountReceived) / 100,
+			created:  time.Unix(intent.Created, 0),
+			email:    intent.ReceiptEmail,
+		}
+		if linkID, fromLink := linkByIntent[intent.ID]; fromLink {
+			if linkID == "" {
+				continue
+			}
+			payment.id, payment.method = linkID, "qr"
+		} else if intent.Metadata[MetadataPaymentID] == "" {
+			continue // Not created by the POS
+		}
+		payments = append(payments, payment)
+	}
+	return payments, nil
+}
+
+// checkLocalPayment looks up a logged payment that wasn't in the day's Stripe listing.
+// Returns true if Stripe has it as paid for the logged amount, otherwise the discrepancy.
+func checkLocalPayment(client StripeClient, record *localPayment) (Discrepancy, bool) {
+	discrepancy := Discrepancy{
+		Kind:        DiscrepancyMissingInStripe,
+		PaymentID:   record.id,
+		Method:      record.method,
+		LocalAmount: record.amount,
+		Time:        record.time,
+	}
+
+	intentID, err := resolvePaymentIntentID(client, record.id)
+	if err != nil {
+		discrepancy.StripeStatus = "not found"
+		return discrepancy, false
+	}
+	discrepancy.PaymentIntentID = intentID
+
+	intent, err := GetPaymentIntent(client, intentID)
+	if err != nil {
+		discrepancy.StripeStatus = "not found"
+		return discrepancy, false
+	}
+	if intent.Status != stripe.PaymentIntentStatusSucceeded {
+		discrepancy.StripeStatus = string(intent.Status)
+		return discrepancy, false
+	}
+
+	discrepancy.StripeAmount = float64(intent.AmountReceived) / 100
+	if !sameAmount(record.amount, discrepancy.StripeAmount) {
+		discrepancy.Kind = DiscrepancyAmountMismatch
+		return discrepancy, false
+	}
+	return discrepancy, true
+}
+
+// ImportStripePayment writes a transaction row for a successful Stripe payment the POS never logged.
+// paymentID is the ID reconciliation reported (a PaymentIntent, or the payment link of a QR payment).
+// The row goes into the log for the day the payment was made and is flagged as imported; item
+// details aren't known, so the sale is recorded as a single line for the amount received.
+func ImportStripePayment(client StripeClient, paymentID string) (*templates.Transaction, error) {
+	if !isStripePaymentID(paymentID) {
+		return nil, fmt.Errorf("%s is not a Stripe payment", paymentID)
+	}
+	if existing, err := LoadTransactionByID(paymentID); err == nil {
+		return existing, fmt.Errorf("payment %s is already recorded", paymentID)
+	}
+
+	intentID, err := resolvePaymentIntentID(client, paymentID)
+	if err != nil {
+		return nil, err
+	}
+	intent, err := GetPaymentIntent(client, intentID)
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving payment intent: %w", err)
+	}
+	if intent.Status != stripe.PaymentIntentStatusSucceeded {
+		return nil, fmt.Errorf("payment %s has not succeeded on Stripe (status %s)", paymentID, intent.Status)
+	}
+
+	method := intent.Metadata[MetadataPaymentMethod]
+	if strings.HasPrefix(paymentID, "plink_") {
+		method = "qr"
+	} else if method == "" {
+		method = "terminal"
+	}
+
+	amount := float64(intent.AmountReceived) / 100
+	created := time.Unix(intent.Created, 0).In(time.Local)
+	transaction := templates.Transaction{
+		ID:   paymentID,
+		Date: created.Format("01/02/2006"),
+		Time: created.Format("15:04:05"),
+		Products: []templates.Product{{
+			Name:        "Stripe payment",
+			Description: "Imported from Stripe by reconciliation",
+			Price:       amount,
+		}},
+		ProductTaxes:        []float64{0},
+		Subtotal:            amount,
+		Total:               amount,
+		PaymentType:         method,
+		StripeCustomerEmail: intent.ReceiptEmail,
+		ConfirmationCode:    paymentID,
+		Note:                intent.Metadata[MetadataNote],
+		Imported:            true,
+	}
+	if strings.HasPrefix(paymentID, "plink_") {
+		transaction.PaymentLinkID = paymentID
+		transaction.PaymentLinkStatus = "completed"
+	}
+
+	card, err := GetPaymentCardDetails(client, paymentID)
+	if err != nil {
+		utils.Warn("reconciliation", "Could not look up card details for imported payment", "payment_id", paymentID, "error", err)
+	}
+	transaction.CardBrand = card.Brand
+	transaction.CardLast4 = card.Last4
+	transaction.StripeReceiptURL = card.ReceiptURL
+	transaction.PayoutAccount = card.PayoutAccount
+
+	if err := saveTransactionToLog(getTransactionsDir(), config.BusinessDay(created), transaction); err != nil {
+		return nil, fmt.Errorf("error saving imported transaction: %w", err)
+	}
+
+	utils.Info("reconciliation", "Imported Stripe payment missing from the transaction log",
+		"payment_id", paymentID, "payment_intent_id", intentID, "amount", amount, "date", transaction.Date)
+	return &transaction, nil
+}
+
+// FormatReconciliationReport renders a reconciliation report as plain text for email
+func FormatReconciliationReport(report ReconciliationReport) string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "%s - Stripe Reconciliation for %s\n\n", config.Config.BusinessName, report.Date)
+	fmt.Fprintf(&b, "Card payments recorded locally: %d\n", report.LocalCount)
+	fmt.Fprintf(&b, "Successful payments on Stripe:  %d\n", report.StripeCount)
+	fmt.Fprintf(&b, "Matched:                        %d\n", report.MatchedCount)
+	fmt.Fprintf(&b, "Discrepancies:                  %d\n", len(report.Discrepancies))
+
+	for _, d := range report.Discrepancies {
+		fmt.Fprintf(&b, "\n%s  %s  %s\n", d.Time.In(config.GetBusinessLocation()).Format("15:04:05"), DiscrepancyLabel(d.Kind), d.PaymentID)
+		switch d.Kind {
+		case DiscrepancyMissingLocally:
+			fmt.Fprintf(&b, "  Stripe: $%.2f, not in the transaction log\n", d.StripeAmount)
+		case DiscrepancyMissingInStripe:
+			fmt.Fprintf(&b, "  Logged: $%.2f, Stripe status: %s\n", d.LocalAmount, d.StripeStatus)
+		case DiscrepancyAmountMismatch:
+			fmt.Fprintf(&b, "  Logged: $%.2f, Stripe: $%.2f\n", d.LocalAmount, d.StripeAmount)
+		}
+	}
+
+	return b.String()
+}
+
+// DiscrepancyLabel returns a readable name for a discrepancy kind
+func DiscrepancyLabel(kind string) string {
+	switch kind {
+	case DiscrepancyMissingLocally:
+		return "Missing locally"
+	case DiscrepancyMissingInStripe:
+		return "Missing in Stripe"
+	case DiscrepancyAmountMismatch:
+		return "Amount mismatch"
+	default:
+		return kind
+	}
+}
+
+// SendReconciliationReport reconciles the given day and emails the report to the reconciliation recipients
+func SendReconciliationReport(client StripeClient, day time.Time) (ReconciliationReport, error) {
+	recipients := config.GetReconciliationRecipients()
+	if len(recipients) == 0 {
+		return ReconciliationReport{}, fmt.Errorf("no reconciliation report recipients configured")
+	}
+
+	report, err := ReconcileDay(client, day)
+	if err != nil {
+		return report, err
+	}
+
+	subject := fmt.Sprintf("%s Stripe reconciliation - %s (%d discrepancies)", config.Config.BusinessName, report.Date, len(report.Discrepancies))
+	if err := SendEmail(recipients, subject, FormatReconciliationReport(report), nil); err != nil {
+		return report, err
+	}
+
+	utils.Info("reconciliation", "Reconciliation report sent", "date", report.Date, "recipients", len(recipients))
+	return report, nil
+}
+
+// StartReconciliationScheduler starts the nightly job that reconciles the previous business day
+// at the configured time, emailing the report when recipients are configured
+func StartReconciliationScheduler() {
+	go func() {
+		ticker := time.NewTicker(dailyReportCheckInterval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			checkReconciliationSchedule(Stripe, time.Now())
+		}
+	}()
+
+	utils.Info("reconciliation", "Reconciliation scheduler started", "run_time", config.Config.ReconciliationTime, "timezone", config.GetBusinessLocation().String())
+}
+
+// checkReconciliationSchedule reconciles yesterday once the scheduled time has passed today
+func checkReconciliationSchedule(client StripeClient, now time.Time) {
+	if config.Config.ReconciliationTime == "" {
+		return
+	}
+
+	scheduled, err := time.Parse("15:04", config.Config.ReconciliationTime)
+	if err != nil {
+		utils.Warn("reconciliation", "Invalid reconciliation time, expected HH:MM", "value", config.Config.ReconciliationTime)
+		return
+	}
+
+	local := now.In(config.GetBusinessLocation())
+	runAt := time.Date(local.Year(), local.Month(), local.Day(), scheduled.Hour(), scheduled.Minute(), 0, 0, local.Location())
+	if local.Before(runAt) {
+		return
+	}
+	today := local.Format("2006-01-02")
+
+	reconciliationState.mutex.Lock()
+	defer reconciliationState.mutex.Unlock()
+	if reconciliationState.lastRunDate == today {
+		return
+	}
+	// One attempt per day; failures are logged and the report can be run on demand
+	reconciliationState.lastRunDate = today
+
+	yesterday := config.BusinessDay(runAt).AddDate(0, 0, -1)
+	if len(config.GetReconciliationRecipients()) > 0 {
+		if _, err := SendReconciliationReport(client, yesterday); err != nil {
+			utils.Error("reconciliation", "Nightly reconciliation failed", "date", yesterday.Format("2006-01-02"), "error", err)
+		}
+		return
+	}
+
+	report, err := ReconcileDay(client, yesterday)
+	if err != nil {
+		utils.Error("reconciliation", "Nightly reconciliation failed", "date", yesterday.Format("2006-01-02"), "error", err)
+		return
+	}
+	if len(report.Discrepancies) > 0 {
+		utils.Warn("reconciliation", "Transaction log disagrees with Stripe", "date", report.Date, "discrepancies", len(report.Discrepancies))
+	}
+}
+
+// isStripePaymentID reports whether a logged payment ID is a Stripe PaymentIntent or payment link
+func isStripePaymentID(id string) bool {
+	return strings.HasPrefix(id, "pi_") || strings.HasPrefix(id, "plink_")
+}
+
+// sameAmount compares two dollar amounts to the cent
+func sameAmount(a, b float64) bool {
+	return math.Abs(a-b) < 0.005
+}
@@ -0,0 +1,337 @@
+package services
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"checkout/config"
+	"checkout/templates"
+	"checkout/utils"
+)
+
+// DailySummary aggregates one day of the transaction log
+type DailySummary struct {
+	Date              string             `json:"date"`              // Day covered by the summary (YYYY-MM-DD)
+	TransactionCount  int                `json:"transactionCount"`  // Completed sales
+	ItemCount         int                `json:"itemCount"`         // Line items across completed sales
+	Subtotal          float64            `json:"subtotal"`          // Completed sales before tax
+	Tax               float64            `json:"tax"`               // Tax collected on completed sales
+	TaxByCategory     map[string]float64 `json:"taxByCategory"`     // Tax per tax category ID ("" = default rate)
+	TaxByComponent    map[string]float64 `json:"taxByComponent"`    // Tax per tax component name, for categories with components
+	Total             float64            `json:"total"`             // Completed sales including tax and service fees
+	ServiceFeeTotal   float64            `json:"serviceFeeTotal"`   // Service fees on completed sales, less fees on voided sales
+	ByPaymentMethod   map[string]float64 `json:"byPaymentMethod"`   // Completed sales total per payment method
+	VoidCount         int                `json:"voidCount"`         // Sales reversed during the day
+	VoidedTotal       float64            `json:"voidedTotal"`       // Amount reversed by voids (positive)
+	ReturnCount       int                `json:"returnCount"`       // Items returned in exchanges
+	ReturnedTotal     float64            `json:"returnedTotal"`     // Amount credited for returned items (positive)
+	TipTotal          float64            `json:"tipTotal"`          // Tips on completed sales, less tips on voided sales
+	DuplicateCount    int                `json:"duplicateCount"`    // Payment link payments taken after the link was already paid
+	DuplicateTotal    float64            `json:"duplicateTotal"`    // Amount of those duplicate payments not yet refunded
+	FailedCount       int                `json:"failedCount"`       // Failed, cancelled or expired payment attempts
+	StripeFeeTotal    float64            `json:"stripeFeeTotal"`    // Stripe fees recorded for the day's card payments
+	StripeFeeReturned float64            `json:"stripeFeeReturned"` // Part of those fees Stripe returned on refunds
+	StripeFeePending  int                `json:"stripeFeePending"`  // Card payments whose Stripe fee isn't recorded yet
+	FirstSale         string             `json:"firstSale"`         // Date and time of the earliest completed sale ("" = none)
+	LastSale          string             `json:"lastSale"`          // Date and time of the latest completed sale
+
+	// Sales per market vendor ID ("" = the business's own items); empty when no item had a vendor
+	ByVendor map[string]VendorSales `json:"byVendor,omitempty"`
+}
+
+// VendorSales is a market vendor's share of a day's sales
+type VendorSales struct {
+	Total   float64 `json:"total"`   // Vendor's items with their tax, less voids and returns
+	PaidOut float64 `json:"paidOut"` // Card payments charged to the vendor's connected account (before the application fee), less refunds
+}
+
+// RefundTotal returns the amount given back during the day through voids and returns
+func (s DailySummary) RefundTotal() float64 {
+	return s.VoidedTotal + s.ReturnedTotal
+}
+
+// TaxComponents returns the day's tax per component, in the order of the configured components
+func (s DailySummary) TaxComponents() []templates.TaxAmount {
+	return TaxBreakdown([]map[string]float64{s.TaxByComponent})
+}
+
+// loggedTimeLayout is the layout of a log row's Date and Time columns joined by a space
+const loggedTimeLayout = "01/02/2006 15:04:05"
+
+// NetTotal returns the day's sales after voids
+func (s DailySummary) NetTotal() float64 {
+	return s.Total - s.VoidedTotal
+}
+
+// BuildDailySummary aggregates the transaction log for the given day.
+// A day without a log yields an empty summary.
+func BuildDailySummary(day time.Time) (DailySummary, error) {
+	summary := DailySummary{
+		Date:            day.Format("2006-01-02"),
+		TaxByCategory:   make(map[string]float64),
+		TaxByComponent:  make(map[string]float64),
+		ByPaymentMethod: make(map[string]float64),
+	}
+
+	records, field, err := readTransactionLog(TransactionLogPath(day))
+	if os.IsNotExist(err) {
+		return summary, nil
+	}
+	if err != nil {
+		return summary, fmt.Errorf("error reading transaction log: %w", err)
+	}
+
+	sales := make(map[string]bool)
+	voids := make(map[string]bool)
+	failures := make(map[string]bool)
+	tenders := make(map[string]map[string]float64) // Split sale confirmation code -> method -> amount
+	tenderPayments := make(map[string][]string)    // Split sale confirmation code -> tender payment IDs
+	byVendor := make(map[string]VendorSales)
+	var firstSale, lastSale time.Time
+	for _, record := range records {
+		transactionID := field(record, "Transaction ID")
+		paymentType := field(record, "Payment Method")
+		total, _ := strconv.ParseFloat(field(record, "Total"), 64)
+		tip, _ := strconv.ParseFloat(field(record, "Tip Amount"), 64)
+		fee, _ := strconv.ParseFloat(field(record, "Service Fee"), 64)
+
+		// Card payments to a vendor's connected account, sale rows and split tenders alike; the
+		// tip and service fee are on a sale's first row
+		if account := field(record, "Payout Account"); account != "" && (isSuccessfulPaymentType(paymentType) || strings.HasSuffix(paymentType, VoidedPaymentSuffix)) {
+			paid := total + fee + tip
+			if field(record, "Tender Amount") != "" {
+				paid = total // A tender row's total already includes its service fee
+			}
+			vendor := vendorByAccount(account)
+			sales := byVendor[vendor]
+			sales.PaidOut += paid
+			byVendor[vendor] = sales
+		}
+
+		// Split tenders only break down a sale's payment methods; the sale's line items carry the totals
+		if field(record, "Tender Amount") != "" {
+			if isSuccessfulPaymentType(paymentType) {
+				code := field(record, "Confirmation Code")
+				if tenders[code] == nil {
+					tenders[code] = make(map[string]float64)
+				}
+				tenders[code][paymentType] += total
+				tenderPayments[code] = append(tenderPayments[code], transactionID)
+			}
+			continue
+		}
+
+		switch {
+		case strings.HasSuffix(paymentType, VoidedPaymentSuffix):
+			voids[transactionID] = true
+			if field(record, "Item/Service") != "" {
+				sales := byVendor[field(record, "Vendor")]
+				sales.Total += total // Negative on reversal rows
+				byVendor[field(record, "Vendor")] = sales
+			}
+			summary.VoidedTotal += math.Abs(total + fee)
+			summary.TipTotal += tip        // Negative on reversal rows
+			summary.ServiceFeeTotal += fee // Negative on reversal rows
+
+		case isSuccessfulPaymentType(paymentType):
+			// Rows without an item name are payment link status events, not line items
+			if field(record, "Item/Service") == "" {
+				switch field(record, "Payment Link Status") {
+				case DuplicatePaymentLinkStatus:
+					summary.DuplicateCount++
+					summary.DuplicateTotal += total
+				case DuplicateRefundedLinkStatus:
+					summary.DuplicateTotal += total // Negative on refund rows
+				}
+				continue
+			}
+			price := lineItemPrice(record, field)
+			tax, _ := strconv.ParseFloat(field(record, "Tax"), 64)
+
+			sales[transactionID] = true
+			if field(record, "Return Of") != "" {
+				// Returned items reduce the sale they were exchanged in
+				summary.ReturnCount++
+				summary.ReturnedTotal += math.Abs(total)
+			} else {
+				summary.ItemCount++
+			}
+			summary.Subtotal += price
+			summary.Tax += tax
+			vendorSales := byVendor[field(record, "Vendor")]
+			vendorSales.Total += total
+			byVendor[field(record, "Vendor")] = vendorSales
+			summary.TaxByCategory[field(record, "Tax Category")] += tax
+			for name, amount := range loggedTaxComponents(record, field) {
+				summary.TaxByComponent[name] += amount
+			}
+			summary.Total += total + fee
+			summary.TipTotal += tip
+			summary.ServiceFeeTotal += fee
+			if paymentType != SplitPaymentMethod {
+				summary.ByPaymentMethod[paymentType] += total + fee
+			}
+			if logged, err := time.ParseInLocation(loggedTimeLayout, field(record, "Date")+" "+field(record, "Time"), time.Local); err == nil {
+				if firstSale.IsZero() || logged.Before(firstSale) {
+					firstSale = logged
+				}
+				if logged.After(lastSale) {
+					lastSale = logged
+				}
+			}
+
+		case strings.HasSuffix(paymentType, LinkSentPaymentSuffix):
+			// A link sent to pay later is neither a sale nor a failure until it is paid or expires
+
+		case paymentType != "":
+			failures[transactionID] = true
+		}
+	}
+
+	// Completed split sales count toward each method they were paid with
+	for code, byMethod := range tenders {
+		if !sales[code] {
+			continue
+		}
+		for method, amount := range byMethod {
+			summary.ByPaymentMethod[method] += amount
+		}
+	}
+
+	// Stripe fees are charged per card payment: the sale's own payment, or each tender of a split sale
+	var payments []string
+	for id := range sales {
+		payments = append(payments, id)
+		payments = append(payments, tenderPayments[id]...)
+	}
+	summary.addStripeFees(payments)
+
+	// Without vendors every item is the business's own, and the breakdown would repeat the totals
+	for vendor, sales := range byVendor {
+		byVendor[vendor] = VendorSales{Total: roundCents(sales.Total), PaidOut: roundCents(sales.PaidOut)}
+		if vendor != "" {
+			summary.ByVendor = byVendor
+		}
+	}
+
+	if !firstSale.IsZero() {
+		summary.FirstSale = firstSale.Format(loggedTimeLayout)
+		summary.LastSale = lastSale.Format(loggedTimeLayout)
+	}
+
+	summary.TransactionCount = len(sales)
+	summary.VoidCount = len(voids)
+	summary.FailedCount = len(failures)
+	return summary, nil
+}
+
+// addStripeFees adds the recorded Stripe fees of the day's payments to the summary, counting the
+// card payments whose fee is still being looked up
+func (s *DailySummary) addStripeFees(paymentIDs []string) {
+	updates, err := loadPaymentUpdates()
+	if err != nil {
+		utils.Error("report", "Error loading payment updates for Stripe fees", "date", s.Date, "error", err)
+		return
+	}
+	fees := stripeFees(updates)
+	for _, id := range paymentIDs {
+		if !isStripePaymentID(id) {
+			continue
+		}
+		fee, recorded := fees[id]
+		if !recorded {
+			s.StripeFeePending++
+			continue
+		}
+		s.StripeFeeTotal += fee.Fee
+		s.StripeFeeReturned += fee.Refunded
+	}
+	s.StripeFeeTotal = roundCents(s.StripeFeeTotal)
+	s.StripeFeeReturned = roundCents(s.StripeFeeReturned)
+}
+
+// writeStripeFees adds the Stripe fees of a day to a plain text report
+func writeStripeFees(b *strings.Builder, summary DailySummary) {
+	if summary.StripeFeeTotal == 0 && summary.StripeFeePending == 0 {
+		return
+	}
+	fmt.Fprintf(b, "Stripe fees:   $%.2f", summary.StripeFeeTotal)
+	if summary.StripeFeePending > 0 {
+		fmt.Fprintf(b, " (%d payments pending)", summary.StripeFeePending)
+	}
+	b.WriteString("\n")
+	if summary.StripeFeeReturned != 0 {
+		fmt.Fprintf(b, "Fees returned: $%.2f\n", summary.StripeFeeReturned)
+	}
+}
+
+// FormatDailySummary renders a daily summary as plain text for email
+func FormatDailySummary(summary DailySummary) string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "%s - Daily Summary for %s\n\n", config.Config.BusinessName, summary.Date)
+	fmt.Fprintf(&b, "Sales:         %d (%d items)\n", summary.TransactionCount, summary.ItemCount)
+	fmt.Fprintf(&b, "Subtotal:      $%.2f\n", summary.Subtotal)
+	fmt.Fprintf(&b, "Tax:           $%.2f\n", summary.Tax)
+	fmt.Fprintf(&b, "Total:         $%.2f\n", summary.Total)
+	fmt.Fprintf(&b, "Returns:       %d ($%.2f)\n", summary.ReturnCount, summary.ReturnedTotal)
+	fmt.Fprintf(&b, "Tips:          $%.2f\n", summary.TipTotal)
+	if summary.ServiceFeeTotal != 0 {
+		fmt.Fprintf(&b, "Service fees:  $%.2f\n", summary.ServiceFeeTotal)
+	}
+	writeStripeFees(&b, summary)
+	fmt.Fprintf(&b, "Voids:         %d ($%.2f)\n", summary.VoidCount, summary.VoidedTotal)
+	fmt.Fprintf(&b, "Net Total:     $%.2f\n", summary.NetTotal())
+	fmt.Fprintf(&b, "Failed/Cancelled attempts: %d\n", summary.FailedCount)
+	if summary.DuplicateCount > 0 {
+		fmt.Fprintf(&b, "Duplicate payments: %d ($%.2f not yet refunded)\n", summary.DuplicateCount, summary.DuplicateTotal)
+	}
+
+	if len(summary.ByPaymentMethod) > 0 {
+		b.WriteString("\nBy payment method:\n")
+		methods := make([]string, 0, len(summary.ByPaymentMethod))
+		for method := range summary.ByPaymentMethod {
+			methods = append(methods, method)
+		}
+		sort.Strings(methods)
+		for _, method := range methods {
+			fmt.Fprintf(&b, "  %-22s $%.2f\n", PaymentMethodLabel(method)+":", summary.ByPaymentMethod[method])
+		}
+	}
+	writeVendorSales(&b, summary.ByVendor)
+	writeTaxComponents(&b, summary.TaxComponents())
+
+	return b.String()
+}
+
+// writeVendorSales adds the sales of each market vendor to a plain text report
+func writeVendorSales(b *strings.Builder, byVendor map[string]VendorSales) {
+	if len(byVendor) == 0 {
+		return
+	}
+	b.WriteString("\nBy vendor:\n")
+	for _, vendor := range sortedKeys(byVendor) {
+		sales := byVendor[vendor]
+		fmt.Fprintf(b, "  %-22s $%.2f", VendorName(vendor)+":", sales.Total)
+		if sales.PaidOut != 0 {
+			fmt.Fprintf(b, " ($%.2f paid out by card)", sales.PaidOut)
+		}
+		b.WriteString("\n")
+	}
+}
+
+// writeTaxComponents adds the tax collected per tax component to a plain text report
+func writeTaxComponents(b *strings.Builder, components []templates.TaxAmount) {
+	if len(components) == 0 {
+		return
+	}
+	b.WriteString("\nTax by component:\n")
+	for _, component := range components {
+		fmt.Fprintf(b, "  %-22s $%.2f\n", component.Name+":", component.Amount)
+	}
+}
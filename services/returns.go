@@ -0,0 +1,184 @@
+package services
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"checkout/templates"
+	"checkout/utils"
+)
+
+// ReturnPaymentMethod is the payment type of an exchange whose returns cover the whole cart,
+// with any excess refunded to the original sale's payment
+const ReturnPaymentMethod = "return"
+
+// AddReturnToCart adds a return line to the cart for one unit of an item from an earlier sale.
+// amount is the refund for the unit before tax (0 = the price it sold for) and can't exceed that price.
+// The line is refused if the sale can't be found, was voided, or every unit of the item
+// has already been returned, counting earlier returns and return lines in the cart.
+func AddReturnToCart(cart *CartStore, originalID string, index int, amount float64) (templates.Product, error) {
+	if otherID := CartReturnOriginalID(cart); otherID != "" && otherID != originalID {
+		return templates.Product{}, fmt.Errorf("the cart already has returns from sale %s - finish that exchange first", otherID)
+	}
+
+	original, err := LoadTransactionByID(originalID)
+	if err != nil {
+		return templates.Product{}, fmt.Errorf("sale %s not found", originalID)
+	}
+	if original.Voided {
+		return templates.Product{}, fmt.Errorf("sale %s was voided", originalID)
+	}
+	if index < 0 || index >= len(original.Products) {
+		return templates.Product{}, fmt.Errorf("item not found on sale %s", originalID)
+	}
+
+	item := original.Products[index]
+	if item.Price <= 0 || item.ReturnOf != "" {
+		return templates.Product{}, fmt.Errorf("%s can't be returned", item.Name)
+	}
+
+	if amount <= 0 {
+		amount = item.Price
+	}
+	amount = roundCents(amount)
+	if amount > item.Price+0.005 {
+		return templates.Product{}, fmt.Errorf("refund for %s can't be more than the $%.2f it sold for", item.Name, item.Price)
+	}
+
+	// Never return more units of an item than the sale had
+	sold := 0
+	for _, product := range original.Products {
+		if product.Name == item.Name && product.ReturnOf == "" && product.Price > 0 {
+			sold++
+		}
+	}
+	returned, err := ReturnedQuantities(originalID)
+	if err != nil {
+		return templates.Product{}, fmt.Errorf("error checking earlier returns: %w", err)
+	}
+	inCart := 0
+	for _, product := range cart.Items() {
+		if product.ReturnOf == originalID && product.Name == item.Name {
+			inCart++
+		}
+	}
+	if returned[item.Name]+inCart >= sold {
+		return templates.Product{}, fmt.Errorf("all %d of %s from this sale have already been returned", sold, item.Name)
+	}
+
+	line := templates.Product{
+		ID:          fmt.Sprintf("return-%d", time.Now().UnixNano()),
+		Name:        item.Name,
+		Description: "Return from " + originalID,
+		Price:       -amount,
+		ReturnOf:    originalID,
+		Vendor:      item.Vendor, // The vendor who sold the item takes the return
+	}
+	// Match the catalog product so the return is taxed at the item's rate
+	for _, product := range Catalog.Products() {
+		if product.Name == item.Name {
+			if product.GiftCard {
+				return templates.Product{}, fmt.Errorf("gift cards can't be returned - the balance stays on the card")
+			}
+			line.TaxCategory = product.TaxCategory
+			break
+		}
+	}
+
+	// The return gives back its share of any service fee paid on the sale
+	refund := -amount
+	if index < len(original.ProductTaxes) {
+		refund -= original.ProductTaxes[index] * amount / item.Price
+	}
+	line.ReturnServiceFee = returnServiceFee(original, refund)
+
+	cart.Add(line)
+	utils.Info("payment", "Return added to cart", "original_id", originalID, "item", item.Name, "amount", amount)
+	return line, nil
+}
+
+// CartReturnOriginalID returns the sale the cart's return lines refer to, or "" if it has none
+func CartReturnOriginalID(cart *CartStore) string {
+	for _, product := range cart.Items() {
+		if product.ReturnOf != "" {
+			return product.ReturnOf
+		}
+	}
+	return ""
+}
+
+// ReturnedQuantities counts the units of each item already returned from a sale across all logs.
+// Voided exchanges put their returns back, so they don't count.
+func ReturnedQuantities(originalID string) (map[string]int, error) {
+	files, err := globTransactionFiles("*.csv")
+	if err != nil {
+		return nil, fmt.Errorf("error listing transaction logs: %w", err)
+	}
+
+	returned := make(map[string]int)
+	for _, filename := range files {
+		records, field, err := readTransactionLog(filename)
+		if err != nil {
+			utils.Error("services", "Error reading transaction log", "file", filename, "error", err)
+			continue
+		}
+		for _, record := range records {
+			if field(record, "Return Of") != originalID {
+				continue
+			}
+			paymentType := field(record, "Payment Method")
+			switch {
+			case strings.HasSuffix(paymentType, VoidedPaymentSuffix):
+				returned[field(record, "Item/Service")]--
+			case isSuccessfulPaymentType(paymentType):
+				returned[field(record, "Item/Service")]++
+			}
+		}
+	}
+	return returned, nil
+}
+
+// RefundReturn refunds the excess of an exchange to the original sale's payment.
+// Split sales are refunded tender by tender in the order they were paid; cash tenders
+// are returned by the cashier. Returns a short description of the refund for the log.
+func RefundReturn(client StripeClient, originalID string, amount float64) (string, error) {
+	original, err := LoadTransactionByID(originalID)
+	if err != nil {
+		return "", fmt.Errorf("sale %s not found", originalID)
+	}
+	if amount > original.Total+0.005 {
+		return "", fmt.Errorf("refund of $%.2f is more than sale %s was paid ($%.2f)", amount, originalID, original.Total)
+	}
+
+	if len(original.Tenders) == 0 {
+		return RefundPaymentAmount(client, original.ID, amount)
+	}
+
+	var refunds []string
+	remaining := roundCents(amount)
+	for _, tender := range original.Tenders {
+		if remaining <= 0 {
+			break
+		}
+		portion := math.Min(remaining, tender.Amount)
+		if tender.Method == CashPaymentMethod {
+			refunds = append(refunds, fmt.Sprintf("return $%.2f cash", portion))
+		} else if tender.Method == GiftCardPaymentMethod {
+			refund, err := CreditGiftCardRedemption(tender.PaymentID, portion)
+			if err != nil {
+				return strings.Join(refunds, "; "), err
+			}
+			refunds = append(refunds, refund)
+		} else {
+			refund, err := RefundPaymentAmount(client, tender.PaymentID, portion)
+			if err != nil {
+				return strings.Join(refunds, "; "), err
+			}
+			refunds = append(refunds, refund)
+		}
+		remaining = roundCents(remaining - portion)
+	}
+	return strings.Join(refunds, "; "), nil
+}
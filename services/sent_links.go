@@ -0,0 +1,241 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"checkout/config"
+	"checkout/i18n"
+	"checkout/templates"
+	"checkout/utils"
+)
+
+// Sent link statuses
+const (
+	SentLinkOutstanding = "outstanding"
+	SentLinkPaid        = "paid"
+	SentLinkCancelled   = "cancelled"
+	SentLinkExpired     = "expired"
+)
+
+// LinkSentPaymentSuffix marks the payment type of the row logged when a payment link is sent
+// (e.g. "qr_link_sent"); the sale itself is logged once the link is paid
+const LinkSentPaymentSuffix = "_link_sent"
+
+// Payment Link Status markers on the transaction rows for sent links
+const (
+	LinkSentLinkStatus      = "link_sent"
+	LinkCancelledLinkStatus = "link_cancelled"
+	LinkExpiredLinkStatus   = "link_expired"
+)
+
+// ErrSentLinkPaid is returned when a sent link can't be cancelled because the customer has paid it
+var ErrSentLinkPaid = errors.New("the customer has already paid this link")
+
+// sentLinks holds the payment links sent to customers, keyed by payment link ID. They are
+// persisted because, unlike the QR code payments, they are meant to outlive a restart.
+var sentLinks = struct {
+	links  map[string]*templates.SentLink
+	loaded bool
+	mutex  sync.Mutex
+}{links: make(map[string]*templates.SentLink)}
+
+// RecordSentLink saves a payment link sent to a customer and logs it as a link_sent row
+func RecordSentLink(link templates.SentLink) error {
+	sentLinks.mutex.Lock()
+	defer sentLinks.mutex.Unlock()
+
+	if err := ensureSentLinksLoaded(); err != nil {
+		return err
+	}
+
+	link.Status = SentLinkOutstanding
+	sentLinks.links[link.PaymentLinkID] = &link
+	if err := saveSentLinks(); err != nil {
+		return err
+	}
+	return logSentLinkEvent(link, "qr"+LinkSentPaymentSuffix, LinkSentLinkStatus, "")
+}
+
+// EmailSentLink emails a sent link's URL to the customer
+func EmailSentLink(link templates.SentLink) error {
+	subject := i18n.T("sent_link.email_subject", config.Config.BusinessName)
+	body := i18n.T("sent_link.email_body", config.Config.BusinessName, i18n.Money(link.Amount), link.URL)
+	if !link.ExpiresAt.IsZero() {
+		body += "\n\n" + i18n.T("sent_link.email_expires", i18n.DateTime(link.ExpiresAt))
+	}
+	return SendEmail([]string{link.Email}, subject, body, nil)
+}
+
+// OutstandingSentLinks returns the sent links not yet paid, cancelled or expired, oldest first
+func OutstandingSentLinks() []templates.SentLink {
+	sentLinks.mutex.Lock()
+	defer sentLinks.mutex.Unlock()
+
+	if err := ensureSentLinksLoaded(); err != nil {
+		utils.Error("payment", "Error loading sent payment links", "error", err)
+		return nil
+	}
+
+	var outstanding []templates.SentLink
+	for _, link := range sentLinks.links {
+		if link.Status == SentLinkOutstanding {
+			outstanding = append(outstanding, *link)
+		}
+	}
+	sort.Slice(outstanding, func(i, j int) bool { return outstanding[i].SentAt.Before(outstanding[j].SentAt) })
+	return outstanding
+}
+
+// IsOutstandingSentLink reports whether a payment link was sent to a customer and is still unpaid
+func IsOutstandingSentLink(paymentLinkID string) bool {
+	sentLinks.mutex.Lock()
+	defer sentLinks.mutex.Unlock()
+
+	if err := ensureSentLinksLoaded(); err != nil {
+		utils.Error("payment", "Error loading sent payment links", "error", err)
+		return false
+	}
+	link, exists := sentLinks.links[paymentLinkID]
+	return exists && link.Status == SentLinkOutstanding
+}
+
+// ConcludeSentLink moves an outstanding sent link to its final status and returns it. Only the
+// first caller succeeds, so a link seen paid by both the webhook and the status check is logged once.
+func ConcludeSentLink(paymentLinkID, status string) (templates.SentLink, bool) {
+	sentLinks.mutex.Lock()
+	defer sentLinks.mutex.Unlock()
+
+	if err := ensureSentLinksLoaded(); err != nil {
+		utils.Error("payment", "Error loading sent payment links", "error", err)
+		return templates.SentLink{}, false
+	}
+	link, exists := sentLinks.links[paymentLinkID]
+	if !exists || link.Status != SentLinkOutstanding {
+		return templates.SentLink{}, false
+	}
+
+	link.Status = status
+	if err := saveSentLinks(); err != nil {
+		utils.Error("payment", "Error saving sent payment links", "payment_link_id", paymentLinkID, "error", err)
+	}
+	return *link, true
+}
+
+// CancelSentLink deactivates an outstanding sent link so it can no longer be paid, and logs the
+// cancellation and who made it. A link the customer paid before it was deactivated is left for
+// the status check to complete, and ErrSentLinkPaid is returned.
+func CancelSentLink(client StripeClient, paymentLinkID, username string) (templates.SentLink, error) {
+	if !IsOutstandingSentLink(paymentLinkID) {
+		return templates.SentLink{}, fmt.Errorf("payment link %s is not outstanding", paymentLinkID)
+	}
+
+	if _, err := client.DeactivatePaymentLink(paymentLinkID); err != nil {
+		return templates.SentLink{}, fmt.Errorf("error deactivating payment link: %w", err)
+	}
+	if status, err := CheckPaymentLinkStatus(client, paymentLinkID, time.Time{}); err == nil && status.Completed {
+		return templates.SentLink{}, ErrSentLinkPaid
+	}
+
+	link, ok := ConcludeSentLink(paymentLinkID, SentLinkCancelled)
+	if !ok {
+		return templates.SentLink{}, fmt.Errorf("payment link %s is not outstanding", paymentLinkID)
+	}
+	if err := logSentLinkEvent(link, "qr_cancelled", LinkCancelledLinkStatus, "cancelled by "+username); err != nil {
+		return link, err
+	}
+	utils.Info("audit", "Sent payment link cancelled", "payment_link_id", paymentLinkID, "amount", link.Amount, "user", username)
+	return link, nil
+}
+
+// ExpireSentLink deactivates an outstanding sent link past its expiry and logs the expiry
+func ExpireSentLink(client StripeClient, paymentLinkID string) error {
+	if _, err := client.DeactivatePaymentLink(paymentLinkID); err != nil {
+		return fmt.Errorf("error deactivating payment link: %w", err)
+	}
+	link, ok := ConcludeSentLink(paymentLinkID, SentLinkExpired)
+	if !ok {
+		return nil
+	}
+	utils.Info("payment", "Sent payment link expired", "payment_link_id", paymentLinkID, "amount", link.Amount, "sent_at", link.SentAt)
+	return logSentLinkEvent(link, "qr_expired", LinkExpiredLinkStatus, "not paid before it expired")
+}
+
+// logSentLinkEvent writes a sent link event as its own transaction row under the link's ID.
+// The row has no items, so reports don't count it as a sale.
+func logSentLinkEvent(link templates.SentLink, paymentType, status, reason string) error {
+	now := time.Now()
+	return SaveTransactionToCSV(templates.Transaction{
+		ID:                link.PaymentLinkID,
+		Date:              now.Format("01/02/2006"),
+		Time:              now.Format("15:04:05"),
+		Total:             link.Amount,
+		PaymentType:       paymentType,
+		PaymentLinkID:     link.PaymentLinkID,
+		PaymentLinkStatus: status,
+		FailureReason:     reason,
+		Note:              link.Note,
+	})
+}
+
+// ensureSentLinksLoaded reads the sent links file once. Callers must hold sentLinks.mutex.
+func ensureSentLinksLoaded() error {
+	if sentLinks.loaded {
+		return nil
+	}
+
+	data, err := os.ReadFile(getSentLinksFilePath())
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("error reading sent links file: %w", err)
+	}
+	if err == nil {
+		var links []templates.SentLink
+		if err := json.Unmarshal(data, &links); err != nil {
+			return fmt.Errorf("error parsing sent links file: %w", err)
+		}
+		for i := range links {
+			sentLinks.links[links[i].PaymentLinkID] = &links[i]
+		}
+	}
+
+	sentLinks.loaded = true
+	return nil
+}
+
+// saveSentLinks writes every sent link to the data directory. Callers must hold sentLinks.mutex.
+func saveSentLinks() error {
+	links := make([]templates.SentLink, 0, len(sentLinks.links))
+	for _, link := range sentLinks.links {
+		links = append(links, *link)
+	}
+	sort.Slice(links, func(i, j int) bool { return links[i].SentAt.Before(links[j].SentAt) })
+
+	jsonData, err := json.MarshalIndent(links, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error marshaling sent links: %w", err)
+	}
+
+	path := getSentLinksFilePath()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("error creating data directory: %w", err)
+	}
+
+	if err := os.WriteFile(path, jsonData, 0644); err != nil {
+		return fmt.Errorf("error writing sent links file: %w", err)
+	}
+	return nil
+}
+
+func getSentLinksFilePath() string {
+	dataDir := config.Config.DataDir
+	if dataDir == "" {
+		dataDir = config.DefaultDataDir
+	}
+	return filepath.Join(dataDir, "sent-links.json")
+}
@@ -0,0 +1,215 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/stripe/stripe-go/v74"
+	"github.com/stripe/stripe-go/v74/webhookendpoint"
+
+	"checkout/config"
+	"checkout/utils"
+)
+
+// setupState records why the POS can't take payments yet. While problem is set,
+// every POS route redirects to the setup page until the startup checks pass.
+var setupState = struct {
+	problem           string
+	webhookRegistered bool
+	mutex             sync.RWMutex
+}{}
+
+// SetupProblem returns why setup is required, or "" once the startup checks have passed
+func SetupProblem() string {
+	setupState.mutex.RLock()
+	defer setupState.mutex.RUnlock()
+	return setupState.problem
+}
+
+// RunStartupChecks validates the Stripe key, loads the product catalog and selects a
+// terminal location. A failure puts the app in setup-required mode rather than exiting,
+// so the operator can correct the settings on the setup page and run the checks again.
+func RunStartupChecks() error {
+	setupState.mutex.Lock()
+	defer setupState.mutex.Unlock()
+
+	if err := runStartupChecks(); err != nil {
+		setupState.problem = err.Error()
+		utils.Error("startup", "Startup checks failed, setup required", "error", err)
+		go CheckWebhookConfig()
+		return err
+	}
+
+	setupState.problem = ""
+	utils.Info("startup", "Startup checks passed")
+
+	// A restricted account still passes the key check, so look at its capabilities too
+	go CheckStripeAccount()
+
+	// Register once; a retry after a later failure reuses the endpoint already created.
+	// Demo mode has no webhooks, so registration waits until it is switched off.
+	if !setupState.webhookRegistered && !config.Config.DemoMode {
+		registerWebhookEndpoint()
+		setupState.webhookRegistered = true
+	}
+
+	// Catch a website name, signing secret and endpoint that don't agree on how events arrive
+	go CheckWebhookConfig()
+	return nil
+}
+
+// runStartupChecks performs the checks for RunStartupChecks. Callers must hold setupState.mutex.
+func runStartupChecks() error {
+	// Pick up a key entered on the setup page since the last attempt
+	stripe.Key = config.GetStripeKey()
+	UI.SetDemoMode(config.Config.DemoMode)
+
+	if config.Config.DemoMode {
+		// Demo mode never contacts Stripe, so no key is needed
+		UI.SetTestMode(false)
+		if err := prepareDemoDataDir(); err != nil {
+			return err
+		}
+		utils.Warn("startup", "Running in demo mode - payments are simulated", "transactions_dir", getTransactionsDir())
+	} else {
+		if stripe.Key == "" {
+			return errors.New("missing Stripe secret key: set the STRIPE_SECRET_KEY environment variable or enter it below")
+		}
+
+		// Test the Stripe key by making a simple API call
+		if _, err := Stripe.GetBalance(); err != nil {
+			if os.Getenv("STRIPE_SECRET_KEY") != "" {
+				return fmt.Errorf("invalid Stripe secret key from the STRIPE_SECRET_KEY environment variable (it overrides the key entered here): %w", err)
+			}
+			return fmt.Errorf("invalid Stripe secret key - API test failed: %w", err)
+		}
+		utils.Info("startup", "Stripe API key validated successfully")
+
+		// Detect test mode from Stripe key and set in application state
+		testMode := strings.HasPrefix(stripe.Key, "sk_test_")
+		UI.SetTestMode(testMode)
+		if testMode {
+			utils.Info("startup", "Running in Stripe test mode")
+		} else {
+			utils.Info("startup", "Running in Stripe live mode")
+		}
+	}
+
+	// Settings the account can't accept would otherwise only show up as declines at sale time
+	if err := validateTerminalChargeSettings(); err != nil {
+		utils.Warn("startup", "Terminal payments will fail with the current charge settings",
+			"payment_method_types", strings.Join(config.GetTerminalPaymentMethodTypes(), ","),
+			"statement_descriptor_suffix", config.Config.StatementDescriptorSuffix, "error", err)
+	}
+
+	// Load services
+	if err := LoadProducts(); err != nil {
+		return fmt.Errorf("error loading products: %w", err)
+	}
+
+	// Load Stripe Terminal Locations and select one
+	if err := LoadStripeLocationsAndSelect(); err != nil {
+		return err
+	}
+
+	// If a location was selected, load readers for that location
+	if locationID := Terminal.SelectedLocation().ID; locationID != "" {
+		LoadStripeReadersForLocation(locationID)
+	}
+	return nil
+}
+
+// validateTerminalChargeSettings checks non-default terminal charge settings by creating a PaymentIntent
+// with them and cancelling it straight away, since Stripe only validates them on a real intent
+func validateTerminalChargeSettings() error {
+	if len(config.Config.TerminalPaymentMethodTypes) == 0 && config.Config.StatementDescriptorSuffix == "" {
+		return nil
+	}
+
+	params := NewPaymentIntentParams(1, "terminal", "")
+	params.Description = stripe.String("POS startup check (cancelled)")
+	intent, err := Stripe.CreatePaymentIntent(params)
+	if err != nil {
+		return err
+	}
+	if _, err := Stripe.CancelPaymentIntent(intent.ID); err != nil {
+		utils.Warn("startup", "Error cancelling startup check PaymentIntent", "intent_id", intent.ID, "error", err)
+	}
+	utils.Info("startup", "Terminal charge settings validated", "payment_method_types", strings.Join(config.GetTerminalPaymentMethodTypes(), ","))
+	return nil
+}
+
+// registerWebhookEndpoint registers webhook endpoint with Stripe if using webhooks strategy
+func registerWebhookEndpoint() {
+	strategy := config.GetCommunicationStrategy()
+	if strategy != "webhooks" {
+		utils.Info("communication", "Using polling strategy", "reason", "localhost/no domain")
+		return
+	}
+
+	// Check if webhook secret is configured
+	if len(config.GetStripeWebhookSecrets()) == 0 {
+		if config.WebhookDevForwarding() {
+			utils.Warn("communication", "Webhook mode (dev forwarding) needs the signing secret stripe listen prints, in STRIPE_WEBHOOK_SECRET")
+			return
+		}
+		utils.Warn("communication", "Webhook strategy selected but no webhook secret configured")
+		return
+	}
+
+	// stripe listen forwards the events and signs them with a secret of its own
+	if config.WebhookDevForwarding() {
+		port := config.Config.Port
+		if port == "" {
+			port = "3000"
+		}
+		utils.Info("communication", "Using webhook mode (dev forwarding); no endpoint registered",
+			"forward_with", "stripe listen --skip-verify --forward-to https://localhost:"+port+"/stripe-webhook")
+		return
+	}
+
+	// TODO: Consider persisting webhook registration to survive server restarts
+	// For now, we'll register on each startup which is acceptable for development
+
+	webhookURL := webhookEndpointURL()
+	result, err := createWebhookEndpoint(webhookURL)
+	if err != nil {
+		utils.Error("communication", "Failed to register webhook endpoint", "error", err)
+		utils.Info("communication", "Falling back to polling mode")
+		return
+	}
+
+	RecordWebhookEndpoint(result.ID, webhookURL)
+	utils.Info("communication", "Using webhook strategy")
+	utils.Debug("webhook", "Registered endpoint", "url", webhookURL, "id", result.ID, "events", webhookEvents)
+}
+
+// webhookEvents are the events the POS needs from Stripe
+var webhookEvents = []string{
+	"payment_intent.succeeded",
+	"payment_intent.payment_failed",
+	"payment_intent.canceled",
+	"payment_intent.requires_action",
+	"checkout.session.completed", // Payment link completion
+	"payment_link.updated",
+	"terminal.reader.action_succeeded",
+	"terminal.reader.action_failed",
+	"charge.succeeded",
+	"charge.failed",
+}
+
+// webhookEndpointURL returns the URL Stripe sends events to for the configured website name
+func webhookEndpointURL() string {
+	return "https://" + strings.TrimSpace(config.Config.WebsiteName) + webhookPath
+}
+
+// createWebhookEndpoint registers a webhook endpoint with Stripe for the events the POS needs
+func createWebhookEndpoint(url string) (*stripe.WebhookEndpoint, error) {
+	return webhookendpoint.New(&stripe.WebhookEndpointParams{
+		URL:           stripe.String(url),
+		EnabledEvents: stripe.StringSlice(webhookEvents),
+	})
+}
@@ -0,0 +1,202 @@
+package services
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"checkout/config"
+	"checkout/templates"
+	"checkout/utils"
+)
+
+// Payment types used by split sales
+const (
+	SplitPaymentMethod = "split" // Line items of a sale paid with several tenders
+	CashPaymentMethod  = "cash"  // Cash tender, recorded without Stripe
+)
+
+// SplitPayment tracks a cart being paid with more than one tender.
+// Every tender is logged under ConfirmationCode as soon as it is captured;
+// the cart's line items are logged under it once the balance reaches zero.
+type SplitPayment struct {
+	ConfirmationCode string
+	Tenders          []templates.Tender // Captured tenders, in order
+	PendingAmount    float64            // Amount of the tender currently being charged
+	StartTime        time.Time
+}
+
+// Paid returns the total of the captured tenders
+func (s *SplitPayment) Paid() float64 {
+	paid := 0.0
+	for _, tender := range s.Tenders {
+		paid += tender.Amount
+	}
+	return roundCents(paid)
+}
+
+// ServiceFees returns the service fees charged on the captured tenders
+func (s *SplitPayment) ServiceFees() float64 {
+	fees := 0.0
+	for _, tender := range s.Tenders {
+		fees += tender.ServiceFee
+	}
+	return roundCents(fees)
+}
+
+// Remaining returns the balance still owed on a cart total
+func (s *SplitPayment) Remaining(total float64) float64 {
+	return math.Max(0, roundCents(total-s.Paid()))
+}
+
+// AmountDue returns what the next payment should charge: the pending tender amount
+// if one was entered, otherwise the rest of the balance
+func (s *SplitPayment) AmountDue(total float64) float64 {
+	remaining := s.Remaining(total)
+	if s.PendingAmount > 0 && s.PendingAmount <= remaining {
+		return s.PendingAmount
+	}
+	return remaining
+}
+
+// clone returns a copy of the split that shares nothing with it
+func (s *SplitPayment) clone() *SplitPayment {
+	if s == nil {
+		return nil
+	}
+	copied := *s
+	copied.Tenders = append([]templates.Tender(nil), s.Tenders...)
+	return &copied
+}
+
+// StartSplitPayment begins a split sale for the cart, or returns the one in progress
+func StartSplitPayment(cart *CartStore) *SplitPayment {
+	return cart.StartSplit()
+}
+
+// SplitPaymentInProgress reports whether a split sale has captured any tenders
+func SplitPaymentInProgress(cart *CartStore) bool {
+	split := cart.Split()
+	return split != nil && len(split.Tenders) > 0
+}
+
+// ChargeAmount returns the amount the next payment for the cart should charge.
+// During a split sale that is the current tender plus its service fee, otherwise the cart
+// total plus any on-screen tip.
+func ChargeAmount(cart *CartStore, summary templates.CartSummary) float64 {
+	if split := cart.Split(); split != nil {
+		due := split.AmountDue(summary.Total)
+		return roundCents(due + ServiceFeeFor(due, cart.PaymentMethod()))
+	}
+	return roundCents(summary.Total + cart.Tip())
+}
+
+// RecordSplitTender adds a captured tender to the cart's split sale and logs it
+func RecordSplitTender(cart *CartStore, tender templates.Tender) error {
+	split, err := cart.AddSplitTender(tender)
+	if err != nil {
+		return err
+	}
+
+	utils.Info("payment", "Split tender captured", "confirmation_code", split.ConfirmationCode,
+		"payment_id", tender.PaymentID, "method", tender.Method, "amount", tender.Amount, "tenders", len(split.Tenders))
+
+	if err := saveTenderRecord(split.ConfirmationCode, tender, "", cart.Cashier()); err != nil {
+		return err
+	}
+	QueueStripeFeeLookup(tender.PaymentID)
+	return nil
+}
+
+// VoidTender reverses a single tender: card tenders are refunded in Stripe,
+// cash tenders are returned to the customer by the cashier and gift card tenders are credited back to the card.
+// Returns a short description of the reversal for the transaction log.
+func VoidTender(client StripeClient, tender templates.Tender) (string, error) {
+	if tender.Method == CashPaymentMethod {
+		return fmt.Sprintf("return $%.2f cash", tender.Amount+tender.ServiceFee), nil
+	}
+	if tender.Method == GiftCardPaymentMethod {
+		return CreditGiftCardRedemption(tender.PaymentID, tender.Amount)
+	}
+	return VoidPayment(client, tender.PaymentID)
+}
+
+// VoidSplitTenders reverses every tender of a split sale and logs each reversal.
+// Tenders that could not be reversed are returned so the cashier can deal with them.
+func VoidSplitTenders(client StripeClient, confirmationCode string, tenders []templates.Tender, reason, cashier string) (string, []templates.Tender) {
+	var reversals []string
+	var failed []templates.Tender
+	for _, tender := range tenders {
+		reversal, err := VoidTender(client, tender)
+		if err != nil {
+			utils.Error("payment", "Error voiding split tender", "confirmation_code", confirmationCode, "payment_id", tender.PaymentID, "method", tender.Method, "error", err)
+			failed = append(failed, tender)
+			continue
+		}
+		reversals = append(reversals, fmt.Sprintf("%s %s", PaymentMethodLabel(tender.Method), reversal))
+
+		voided := tender
+		voided.Method = tender.Method + VoidedPaymentSuffix
+		voided.Amount = -tender.Amount
+		voided.ServiceFee = -tender.ServiceFee
+		if err := saveTenderRecord(confirmationCode, voided, "Void: "+reason, cashier); err != nil {
+			utils.Error("payment", "Error saving split tender void", "confirmation_code", confirmationCode, "payment_id", tender.PaymentID, "error", err)
+		}
+	}
+	return strings.Join(reversals, "; "), failed
+}
+
+// saveTenderRecord appends a tender row to the current business day's transaction log. Tender rows carry no line item;
+// they share the sale's confirmation code and record the amount in the Tender Amount column.
+func saveTenderRecord(confirmationCode string, tender templates.Tender, failureReason, cashier string) error {
+	now := time.Now()
+	record := []string{
+		now.Format("01/02/2006"),
+		now.Format("15:04:05"),
+		tender.PaymentID,
+		"", // Item/Service
+		"Split tender: " + PaymentMethodLabel(strings.TrimSuffix(tender.Method, VoidedPaymentSuffix)), // Description
+		"", // Quantity
+		"", // Unit Price
+		"", // Tax
+		fmt.Sprintf("%.2f", tender.Amount+tender.ServiceFee),
+		tender.Method,
+		"", // Stripe Customer Email
+		"", // Payment Link ID
+		"", // Payment Link Status
+		confirmationCode,
+		failureReason,
+		Terminal.SelectedLocation().ID,
+		"", // Override Reason
+		tender.CardBrand,
+		tender.CardLast4,
+		tender.ReceiptURL,
+		fmt.Sprintf("%.2f", tender.Amount),
+		"", // Return Of
+		"", // Tip Amount
+		"", // Notes
+		"", // Imported
+		"", // Tax Category
+		"", // Late For Day
+		"", // Description Edited
+		feeValue(tender.ServiceFee),
+		"", // Product ID
+		"", // Category
+		"", // Order Number
+		"", // Retry Of
+		cashier,
+		"", // List Price
+		"", // Sale Price
+		"", // Stripe Fee
+		"", // Net Amount
+		"", // Vendor
+		tender.PayoutAccount,
+	}
+	return appendTransactionRecords(getTransactionsDir(), config.BusinessDay(now), [][]string{record}, nil)
+}
+
+// roundCents rounds an amount to whole cents
+func roundCents(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
@@ -0,0 +1,128 @@
+package services
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"checkout/config"
+	"checkout/templates"
+	"checkout/utils"
+
+	"github.com/stripe/stripe-go/v74"
+)
+
+// StaleLinkExpiredStatus is the Payment Link Status of the row logged for a deactivated stale link
+const StaleLinkExpiredStatus = "expired"
+
+// StaleLinkCleanup is the outcome of a stale payment link cleanup
+type StaleLinkCleanup struct {
+	Checked     int      // Active QR payment links created by the POS
+	Deactivated []string // Unpaid links deactivated and logged as expired
+	Imported    []string // Paid links the transaction log was missing, imported by reconciliation
+	Errors      []string // Links that couldn't be cleaned up, with why
+}
+
+// CleanStalePaymentLinks deactivates the QR payment links the POS left active: a link is stale once
+// it is older than the payment timeout, which happens when the server stopped or the browser was
+// closed before the timeout was handled. An unpaid link is deactivated and logged as a qr_expired
+// row. A paid link is a sale the POS may never have recorded, so it goes through reconciliation's
+// import instead of being dropped. Links sent to customers, links from before the POS marked its
+// links, and links tracked reports as still in progress are left alone.
+func CleanStalePaymentLinks(client StripeClient, now time.Time, tracked func(paymentLinkID string) bool) (StaleLinkCleanup, error) {
+	var cleanup StaleLinkCleanup
+
+	params := &stripe.PaymentLinkListParams{Active: stripe.Bool(true)}
+	params.Limit = stripe.Int64(reconciliationListLimit)
+	links, err := client.ListPaymentLinks(params)
+	if err != nil {
+		return cleanup, fmt.Errorf("error listing payment links: %w", err)
+	}
+
+	for _, link := range links {
+		if link.Metadata[MetadataLinkKind] != PaymentLinkKindQR {
+			continue
+		}
+		cleanup.Checked++
+
+		createdUnix, err := strconv.ParseInt(link.Metadata[MetadataLinkCreated], 10, 64)
+		if err != nil || now.Sub(time.Unix(createdUnix, 0)) <= config.PaymentTimeout {
+			continue
+		}
+		if tracked != nil && tracked(link.ID) {
+			continue
+		}
+
+		imported, err := cleanStalePaymentLink(client, link.ID, time.Unix(createdUnix, 0), now)
+		switch {
+		case err != nil:
+			utils.Error("payment", "Error cleaning up stale payment link", "payment_link_id", link.ID, "error", err)
+			cleanup.Errors = append(cleanup.Errors, link.ID+": "+err.Error())
+		case imported:
+			cleanup.Imported = append(cleanup.Imported, link.ID)
+		default:
+			cleanup.Deactivated = append(cleanup.Deactivated, link.ID)
+		}
+	}
+
+	utils.Info("payment", "Stale payment link cleanup finished", "checked", cleanup.Checked,
+		"deactivated", len(cleanup.Deactivated), "imported", len(cleanup.Imported), "errors", len(cleanup.Errors))
+	return cleanup, nil
+}
+
+// cleanStalePaymentLink deactivates one stale link. It reports whether the link had been paid and
+// its sale was imported into the transaction log.
+func cleanStalePaymentLink(client StripeClient, paymentLinkID string, created, now time.Time) (bool, error) {
+	status, err := CheckPaymentLinkStatus(client, paymentLinkID, created)
+	if err != nil {
+		return false, err
+	}
+
+	if status.Completed {
+		// Deactivate first, so nobody else pays it while the sale is imported
+		if _, err := client.DeactivatePaymentLink(paymentLinkID); err != nil {
+			return false, fmt.Errorf("error deactivating paid payment link: %w", err)
+		}
+		if _, err := LoadTransactionByID(paymentLinkID); err == nil {
+			utils.Info("payment", "Stale payment link was paid and recorded; deactivated", "payment_link_id", paymentLinkID)
+			return false, nil
+		}
+		if _, err := ImportStripePayment(client, paymentLinkID); err != nil {
+			return false, fmt.Errorf("paid, but not imported: %w", err)
+		}
+		utils.Warn("payment", "Stale payment link was paid but never recorded; imported", "payment_link_id", paymentLinkID, "session_id", status.SessionID)
+		return true, nil
+	}
+
+	if _, err := client.DeactivatePaymentLink(paymentLinkID); err != nil {
+		return false, fmt.Errorf("error deactivating payment link: %w", err)
+	}
+
+	transaction := templates.Transaction{
+		ID:                paymentLinkID,
+		Date:              now.Format("01/02/2006"),
+		Time:              now.Format("15:04:05"),
+		PaymentType:       "qr_expired",
+		PaymentLinkID:     paymentLinkID,
+		PaymentLinkStatus: StaleLinkExpiredStatus,
+		FailureReason:     "Deactivated by the stale payment link cleanup",
+	}
+	if err := SaveTransactionToCSV(transaction); err != nil {
+		return false, fmt.Errorf("deactivated, but not logged: %w", err)
+	}
+	utils.Info("payment", "Deactivated stale payment link", "payment_link_id", paymentLinkID, "created", created.Format(time.RFC3339))
+	return false, nil
+}
+
+// StartStalePaymentLinkCleanup cleans up the payment links left active before the server started,
+// in the background, once the startup checks have passed
+func StartStalePaymentLinkCleanup() {
+	if SetupProblem() != "" {
+		return
+	}
+	go func() {
+		if _, err := CleanStalePaymentLinks(Stripe, time.Now(), nil); err != nil {
+			utils.Error("payment", "Stale payment link cleanup failed", "error", err)
+		}
+	}()
+}
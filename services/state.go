@@ -1,15 +1,16 @@
 package services
 
 import (
-	"checkout/templates"
+	"fmt"
 	"strings"
+	"time"
+
+	"checkout/templates"
+	"checkout/utils"
 )
 
 // CategoryData holds the parsed category navigation structure
 type CategoryData struct {
-	// Current navigation path (e.g., ["cat1", "cat2"])
-	CurrentPath []string
-
 	// Quick lookup: path -> direct subcategories
 	// "cat1" -> ["cat2", "cat3"]
 	// "" -> ["cat1", "cat4"] (root level)
@@ -21,31 +22,16 @@ type CategoryData struct {
 	DirectProducts map[string][]templates.Product
 }
 
-// State holds application state
-type State struct {
-	Products    []templates.Product
-	CurrentCart []templates.Product
-
-	// Category navigation state
-	CategoryData CategoryData
-
-	// Stripe Terminal state
-	AvailableStripeLocations []templates.StripeLocation
-	SelectedStripeLocation   templates.StripeLocation
-	SiteStripeReaders        []templates.StripeReader
-	SelectedReaderID         string // ID of the reader selected by the user
-
-	// Layout context for shared UI state
-	LayoutContext templates.LayoutContext
-}
-
-// AppState is the global application state instance
-var AppState State
+// The register's state, split by owner. Each store does its own locking.
+var (
+	Catalog  = NewProductCatalog() // Products and the lookups built from them
+	Terminal = NewTerminalState()  // Stripe Terminal locations and readers
+	UI       = NewUIContext()      // Test and demo mode banners of the POS screen
+)
 
 // BuildCategoryData builds the category navigation structure from products
 func BuildCategoryData(products []templates.Product) CategoryData {
 	data := CategoryData{
-		CurrentPath:    []string{},
 		Subcategories:  make(map[string][]string),
 		DirectProducts: make(map[string][]templates.Product),
 	}
@@ -86,14 +72,39 @@ func BuildCategoryData(products []templates.Product) CategoryData {
 	return data
 }
 
-// GetCurrentSubcategories returns subcategories for the current path
-func GetCurrentSubcategories() []string {
-	currentPath := strings.Join(AppState.CategoryData.CurrentPath, "/")
-	return AppState.CategoryData.Subcategories[currentPath]
+// GetCurrentSubcategories returns subcategories for the category a register's cart is at
+func GetCurrentSubcategories(cart *CartStore) []string {
+	return Catalog.Subcategories(cart.CategoryPath())
+}
+
+// GetCurrentProducts returns products for the category a register's cart is at
+func GetCurrentProducts(cart *CartStore) []templates.Product {
+	return Catalog.ProductsIn(cart.CategoryPath())
 }
 
-// GetCurrentProducts returns products for the current path
-func GetCurrentProducts() []templates.Product {
-	currentPath := strings.Join(AppState.CategoryData.CurrentPath, "/")
-	return AppState.CategoryData.DirectProducts[currentPath]
+// RestoreCartFromTransaction adds a transaction's items back into the cart.
+// Logged items only keep name, description and price, so catalog products are matched
+// by name to recover their Stripe and tax category IDs; anything else is restored as a custom product.
+func RestoreCartFromTransaction(cart *CartStore, transaction *templates.Transaction) {
+	products := Catalog.Products()
+	for i, item := range transaction.Products {
+		restored := item
+		restored.ID = fmt.Sprintf("custom-%d-%d", time.Now().UnixNano(), i)
+		for _, product := range products {
+			if product.Name == item.Name && product.Price == item.Price {
+				restored = product
+				break
+			}
+		}
+		// The voided sale's card is cancelled, so selling it again issues a new one
+		if restored.GiftCard {
+			if line, err := NewGiftCardLine(restored, ""); err == nil {
+				restored = line
+			} else {
+				utils.Error("giftcard", "Error issuing gift card code for restored item", "item", item.Name, "error", err)
+			}
+		}
+		cart.Add(restored)
+	}
+	cart.Touch()
 }
@@ -0,0 +1,226 @@
+package services
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"checkout/templates"
+)
+
+// Run with -race: each store is read and written from many goroutines at once
+
+// menu returns a catalog whose products are all in one category and carry its name
+func menu(category string, size int) []templates.Product {
+	products := make([]templates.Product, size)
+	for i := range products {
+		products[i] = templates.Product{
+			ID:       fmt.Sprintf("%s-%d", category, i),
+			Name:     fmt.Sprintf("%s %d", category, i),
+			Price:    float64(i + 1),
+			Category: category,
+			SKU:      fmt.Sprintf("%s-sku-%d", category, i),
+		}
+	}
+	return products
+}
+
+func TestProductCatalogConcurrentUse(t *testing.T) {
+	catalog := NewProductCatalog()
+	drinks, food := menu("drinks", 3), menu("food", 5)
+	catalog.Set(drinks)
+	const workers, rounds = 4, 200
+
+	// A reader sees one menu or the other, never a mix of the two
+	var wg sync.WaitGroup
+	errs := make(chan string, workers*rounds)
+	for i := range workers {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for round := range rounds {
+				if (i+round)%2 == 0 {
+					catalog.Set(food)
+				} else {
+					catalog.Set(drinks)
+				}
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for range rounds {
+				products := catalog.Products()
+				if !slices.Equal(productIDs(products), productIDs(drinks)) && !slices.Equal(productIDs(products), productIDs(food)) {
+					errs <- fmt.Sprintf("products = %v, a mix of two menus", productIDs(products))
+				}
+				top := catalog.Subcategories(nil)
+				if len(top) != 1 || (top[0] != "drinks" && top[0] != "food") {
+					errs <- fmt.Sprintf("top categories = %v, want one menu's", top)
+				}
+				if in := catalog.ProductsIn([]string{top[0]}); len(in) != 3 && len(in) != 5 {
+					errs <- fmt.Sprintf("%d products in %s, want a whole menu", len(in), top[0])
+				}
+				if product, found := catalog.FindBySKU("food-sku-4"); found && product.ID != "food-4" {
+					errs <- fmt.Sprintf("SKU food-sku-4 found %s", product.ID)
+				}
+				if product, found := catalog.FindByID("drinks-0"); found && product.SKU != "drinks-sku-0" {
+					errs <- fmt.Sprintf("drinks-0 has SKU %s", product.SKU)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	// Callers get copies, so changing one doesn't change the catalog
+	catalog.Set(drinks)
+	drinks[0].Price = 99
+	products := catalog.Products()
+	products[1].Price = 99
+	if got := catalog.Products(); got[0].Price != 1 || got[1].Price != 2 {
+		t.Errorf("catalog prices = %v, %v, want them unchanged", got[0].Price, got[1].Price)
+	}
+}
+
+func productIDs(products []templates.Product) []string {
+	ids := make([]string, len(products))
+	for i, product := range products {
+		ids[i] = product.ID
+	}
+	return ids
+}
+
+func TestTerminalStateConcurrentUse(t *testing.T) {
+	terminal := NewTerminalState()
+	readers := []templates.StripeReader{{ID: "tmr_1", Status: "online"}, {ID: "tmr_2", Status: "online"}}
+	terminal.SetReaders(readers)
+	start := time.Date(2026, time.March, 13, 9, 0, 0, 0, time.UTC)
+	const workers, rounds = 4, 200
+
+	// Keep-alive checks, reader picks and location changes from every register at once
+	var wg sync.WaitGroup
+	errs := make(chan string, workers*rounds)
+	for i := range workers {
+		readerID := readers[i%2].ID
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			for round := range rounds {
+				at := start.Add(time.Duration(round) * time.Second)
+				if round%2 == 0 {
+					terminal.SetReaderStatus(readerID, "offline")
+					terminal.ReaderUnreachable(readerID, at, time.Minute)
+				} else {
+					terminal.SetReaderStatus(readerID, "online")
+					terminal.ReaderSeen(readerID, at)
+				}
+				terminal.ReaderDegraded(readerID)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for range rounds {
+				terminal.SelectReader(readerID)
+				terminal.SelectLocation(templates.StripeLocation{ID: fmt.Sprintf("tml_%d", i)})
+				terminal.SetLocations([]templates.StripeLocation{{ID: fmt.Sprintf("tml_%d", i)}})
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for range rounds {
+				for _, reader := range terminal.Readers() {
+					if reader.Status != "online" && reader.Status != "offline" {
+						errs <- fmt.Sprintf("reader %s status %q", reader.ID, reader.Status)
+					}
+				}
+				if id := terminal.SelectedReaderID(); id != "tmr_1" && id != "tmr_2" && id != "" {
+					errs <- fmt.Sprintf("selected reader %q", id)
+				}
+				if locations := terminal.Locations(); len(locations) > 1 {
+					errs <- fmt.Sprintf("locations = %v, want one register's", locations)
+				}
+				terminal.SelectedLocation()
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	if got := terminal.Readers(); len(got) != 2 {
+		t.Errorf("readers = %v, want both kept", got)
+	}
+	terminal.Reset()
+	if len(terminal.Readers()) != 0 || terminal.SelectedReaderID() != "" || terminal.SelectedLocation().ID != "" {
+		t.Errorf("state kept after reset")
+	}
+}
+
+func TestUIContextConcurrentUse(t *testing.T) {
+	ui := NewUIContext()
+	const workers, rounds = 4, 200
+
+	var wg sync.WaitGroup
+	for i := range workers {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for round := range rounds {
+				ui.SetTestMode(round%2 == 0)
+				ui.SetDemoMode(i%2 == 0)
+				ui.SetDemoDeclines(round%3 == 0)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for range rounds {
+				ui.Layout()
+			}
+		}()
+	}
+	wg.Wait()
+
+	ui.SetTestMode(true)
+	ui.SetDemoMode(false)
+	ui.SetDemoDeclines(true)
+	if got, want := ui.Layout(), (templates.LayoutContext{IsTestMode: true, DemoDeclines: true}); got != want {
+		t.Errorf("layout = %+v, want %+v", got, want)
+	}
+}
+
+// Each register browses the catalog on its own; one navigating doesn't move another's view
+func TestCategoryPathKeptPerCart(t *testing.T) {
+	carts := NewCartSessions()
+	const registers, rounds = 4, 200
+
+	var wg sync.WaitGroup
+	for i := range registers {
+		cart := carts.Get(fmt.Sprintf("register-%d", i))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for round := range rounds {
+				cart.SetCategoryPath([]string{"drinks", fmt.Sprintf("register-%d", i), fmt.Sprint(round)})
+				cart.CategoryPath()
+			}
+		}()
+	}
+	wg.Wait()
+
+	for i := range registers {
+		want := []string{"drinks", fmt.Sprintf("register-%d", i), fmt.Sprint(rounds - 1)}
+		if got := carts.Get(fmt.Sprintf("register-%d", i)).CategoryPath(); !slices.Equal(got, want) {
+			t.Errorf("register-%d path = %v, want %v", i, got, want)
+		}
+	}
+	if got := carts.Get("register-new").CategoryPath(); len(got) != 0 {
+		t.Errorf("a new register starts at %v, want the top level", got)
+	}
+}
@@ -3,19 +3,84 @@ package services
 import (
 	"errors"
 	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/stripe/stripe-go/v74"
-	"github.com/stripe/stripe-go/v74/paymentlink"
-	"github.com/stripe/stripe-go/v74/price"
-	"github.com/stripe/stripe-go/v74/product"
 
 	"checkout/config"
 	"checkout/templates"
 	"checkout/utils"
+)
+
+// Metadata keys attached to Stripe objects created by the POS
+const (
+	MetadataPaymentID     = "pos_payment_id"
+	MetadataPaymentMethod = "pos_payment_method"
+	MetadataBusinessName  = "pos_business_name"
+	MetadataNote          = "pos_note"
+	MetadataLinkKind      = "pos_link_kind"    // PaymentLinkKind* of a payment link
+	MetadataLinkCreated   = "pos_link_created" // Unix time a payment link was created (Stripe doesn't report it)
+	MetadataListPrice     = "pos_list_price"   // Catalog price of a temporary price made for a sale price
+)
 
-	"github.com/stripe/stripe-go/v74/checkout/session"
+// What a payment link created by the POS is for
+const (
+	PaymentLinkKindQR   = "qr"   // Shown as a QR code (or started through the API) and paid while the POS waits
+	PaymentLinkKindSent = "sent" // Sent to a customer to pay later; expires with the sent link expiry
 )
 
+// NewPaymentID generates the internal payment ID used to correlate Stripe objects with POS payments
+func NewPaymentID() string {
+	return fmt.Sprintf("pos_%d", time.Now().UnixNano())
+}
+
+// PaymentMetadata builds the metadata attached to PaymentIntents and PaymentLinks created by the POS,
+// including the note entered for the sale so it shows in the Stripe dashboard
+func PaymentMetadata(paymentID, paymentMethod, note string) map[string]string {
+	metadata := map[string]string{
+		MetadataPaymentID:     paymentID,
+		MetadataPaymentMethod: paymentMethod,
+	}
+	if note != "" {
+		metadata[MetadataNote] = note
+	}
+	return metadata
+}
+
+// NewPaymentIntentParams builds the PaymentIntent for charging amount with the given payment method.
+// Terminal payments accept the configured reader payment method types; the statement descriptor
+// suffix and business name settings apply to every payment method.
+func NewPaymentIntentParams(amount float64, paymentMethod, note string) *stripe.PaymentIntentParams {
+	params := &stripe.PaymentIntentParams{
+		Amount:        stripe.Int64(int64(math.Round(amount * 100))), // Convert to cents
+		Currency:      stripe.String("usd"),
+		CaptureMethod: stripe.String("automatic"),
+	}
+	params.Metadata = PaymentMetadata(NewPaymentID(), paymentMethod, note)
+
+	// Configure payment method types based on the payment method
+	switch paymentMethod {
+	case "manual", "qr":
+		params.PaymentMethodTypes = []*string{
+			stripe.String("card"),
+		}
+	default:
+		params.PaymentMethodTypes = stripe.StringSlice(config.GetTerminalPaymentMethodTypes())
+	}
+
+	if config.Config.StatementDescriptorSuffix != "" {
+		params.StatementDescriptorSuffix = stripe.String(config.Config.StatementDescriptorSuffix)
+	}
+	if config.Config.BusinessNameOnCharges && config.Config.BusinessName != "" {
+		params.Description = stripe.String(config.Config.BusinessName)
+		params.Metadata[MetadataBusinessName] = config.Config.BusinessName
+	}
+	return params
+}
+
 // GetStripePublicKey returns the Stripe public key
 func GetStripePublicKey() string {
 	return config.GetStripePublicKey()
@@ -31,7 +96,7 @@ func EnsureServiceHasPriceID(service *templates.Product) (bool, error) {
 
 	// --- Validate or Create Stripe Product ID ---
 	if service.StripeProductID != "" {
-		p, err := product.Get(service.StripeProductID, nil)
+		p, err := Stripe.GetProduct(service.StripeProductID)
 		if err != nil {
 			if stripeErr, ok := err.(*stripe.Error); ok && stripeErr.Code == stripe.ErrorCodeResourceMissing {
 				utils.Debug("stripe", "Stripe Product ID not found, will create new one", "product_id", service.StripeProductID, "service", service.Name)
@@ -56,7 +121,7 @@ func EnsureServiceHasPriceID(service *templates.Product) (bool, error) {
 			Name:        stripe.String(service.Name),
 			Description: stripe.String(service.Description),
 		}
-		newProduct, err := product.New(productParams)
+		newProduct, err := Stripe.CreateProduct(productParams)
 		if err != nil {
 			return false, fmt.Errorf("error creating new Stripe product for service '%s': %w", service.Name, err)
 		}
@@ -71,7 +136,7 @@ func EnsureServiceHasPriceID(service *templates.Product) (bool, error) {
 			service.PriceID = "" // Cannot validate price without product
 			utils.Warn("stripe", "Cleared PriceID because StripeProductID is missing before price validation", "service", service.Name)
 		} else {
-			pr, err := price.Get(service.PriceID, nil)
+			pr, err := Stripe.GetPrice(service.PriceID)
 			if err != nil {
 				if stripeErr, ok := err.(*stripe.Error); ok && stripeErr.Code == stripe.ErrorCodeResourceMissing {
 					utils.Debug("stripe", "Stripe Price ID not found, will create new one", "price_id", service.PriceID, "service", service.Name, "product_id", service.StripeProductID)
@@ -104,7 +169,7 @@ func EnsureServiceHasPriceID(service *templates.Product) (bool, error) {
 			Product:    stripe.String(service.StripeProductID),
 			Nickname:   stripe.String(fmt.Sprintf("Default price for %s", service.Name)),
 		}
-		newPrice, err := price.New(priceParams)
+		newPrice, err := Stripe.CreatePrice(priceParams)
 		if err != nil {
 			if errors.As(err, &sErr) && sErr.Code == stripe.ErrorCode("price_missing_product") {
 				utils.Error("stripe", "Attempted to create price for non-existent product", "product_id", service.StripeProductID, "service", service.Name)
@@ -146,59 +211,182 @@ type PaymentLinkStatus struct {
 	Active        bool
 	Completed     bool
 	CustomerEmail string
+	SessionID     string                       // Checkout session that paid the link
+	Duplicates    []templates.DuplicatePayment // Sessions newly found paying the link after the first
+	AutomaticTax  bool                         // Stripe Tax added the tax at checkout (see ApplySessionTax)
 }
 
-// CreatePaymentLink creates a payment link for the current cart
-func CreatePaymentLink(totalAmount float64, email string) (*stripe.PaymentLink, error) {
+// maxPaymentLinkItemNameLength keeps an item with a register description readable on the checkout page
+const maxPaymentLinkItemNameLength = 250
+
+// CreatePaymentLink creates a payment link of the given PaymentLinkKind* for the cart
+func CreatePaymentLink(client StripeClient, cart *CartStore, totalAmount float64, email, kind string) (*stripe.PaymentLink, error) {
 	utils.Debug("stripe", "Creating payment link - cart contents", "total_amount", totalAmount, "email", email)
-	for i, cartItem := range AppState.CurrentCart {
+	items := cart.Items()
+	for i, cartItem := range items {
 		utils.Debug("stripe", "Cart item", "index", i, "name", cartItem.Name, "id", cartItem.ID, "stripe_product_id", cartItem.StripeProductID, "price_id", cartItem.PriceID)
 	}
 
 	// Create payment link params
 	params := &stripe.PaymentLinkParams{}
+	for key, value := range PaymentMetadata(NewPaymentID(), "qr", cart.Note()) {
+		params.AddMetadata(key, value)
+	}
+	// Lets the stale link cleanup find the POS's own links and tell their age
+	params.AddMetadata(MetadataLinkKind, kind)
+	params.AddMetadata(MetadataLinkCreated, strconv.FormatInt(time.Now().Unix(), 10))
+
+	// Split tenders and exchanges charge an amount that doesn't match the cart's lines
+	// (and Stripe prices can't be negative), so they are sold as a single balance-due line
+	summary, itemTaxes := CalculateCartSummaryWithItemTaxes(cart)
+	automaticTax := StripeTaxEnabled()
+	balanceDue := CartReturnOriginalID(cart) != "" || math.Abs(totalAmount-summary.Total) > 0.005
 
-	// DO NOT enable automatic tax calculation - we calculate locally
-	// params.AutomaticTax = &stripe.PaymentLinkAutomaticTaxParams{
-	//     Enabled: stripe.Bool(true),
-	// }
-
-	// Add line items by creating a new Price object for each service
-	for _, service := range AppState.CurrentCart {
-		taxRate := GetTaxRateForService(service)
-		serviceTotalWithTax := service.Price * (1 + taxRate)
-
-		// Create a temporary Price object for this service with tax included,
-		// linked to the actual Stripe Product.
-		if service.StripeProductID == "" {
-			utils.Error("stripe", "Service missing StripeProductID, cannot create payment link line item", "service", service.Name)
-			return nil, fmt.Errorf("service '%s' is missing StripeProductID", service.Name)
+	// A cart of a market vendor's items is paid out to the vendor
+	if err := routeLinkToVendor(params, items, int64(math.Round(totalAmount*100))); err != nil {
+		return nil, err
+	}
+
+	// With local tax each price includes its line's tax, adjusted by a cent where rounding the
+	// lines separately would make the link total differ from the cart total
+	var lineCents []int64
+	if !balanceDue && !automaticTax {
+		itemsTotal := int64(math.Round(summary.Total*100)) - int64(math.Round(summary.ServiceFee*100))
+		var err error
+		if lineCents, err = paymentLinkLineCents(items, itemTaxes, itemsTotal); err != nil {
+			utils.Warn("stripe", "Cart lines can't be priced to the cart total, selling it as the balance due", "total", summary.Total, "error", err)
+			balanceDue = true
 		}
+	}
 
-		priceParams := &stripe.PriceParams{
+	if balanceDue {
+		balancePriceID, err := linkPrice(client, &stripe.PriceParams{
 			Currency:    stripe.String(string(stripe.CurrencyUSD)),
-			UnitAmount:  stripe.Int64(int64(serviceTotalWithTax * 100)),          // Price in cents, includes local tax
-			Product:     stripe.String(service.StripeProductID),                  // Link to the existing Stripe Product
-			TaxBehavior: stripe.String(string(stripe.PriceTaxBehaviorInclusive)), // Indicates UnitAmount includes tax
-			// Nickname can be useful for identifying these temporary prices in Stripe logs/dashboard
-			Nickname: stripe.String(fmt.Sprintf("Payment Link item for %s (tax incl.)", service.Name)),
-		}
-		tempPrice, err := price.New(priceParams)
+			UnitAmount:  stripe.Int64(int64(math.Round(totalAmount * 100))),
+			TaxBehavior: stripe.String(string(stripe.PriceTaxBehaviorInclusive)),
+			Nickname:    stripe.String("Payment Link balance due (tax incl.)"),
+			ProductData: &stripe.PriceProductDataParams{
+				Name: stripe.String("Balance due"),
+			},
+		})
 		if err != nil {
-			utils.Error("stripe", "Error creating temporary Stripe price for payment link", "service", service.Name, "product_id", service.StripeProductID, "error", err)
-			return nil, fmt.Errorf("error creating temporary price for service %s: %w", service.Name, err)
+			utils.Error("stripe", "Error creating balance due price for payment link", "amount", totalAmount, "error", err)
+			return nil, fmt.Errorf("error creating balance due price: %w", err)
 		}
-
-		// Add line item using the ID of the temporary Price
 		params.LineItems = append(params.LineItems, &stripe.PaymentLinkLineItemParams{
-			Price:    stripe.String(tempPrice.ID),
+			Price:    stripe.String(balancePriceID),
 			Quantity: stripe.Int64(1),
 		})
+	} else {
+		// With Stripe Tax the checkout adds tax for the customer's address; otherwise the local
+		// tax is included in each price
+		if automaticTax {
+			params.AutomaticTax = &stripe.PaymentLinkAutomaticTaxParams{Enabled: stripe.Bool(true)}
+		}
+
+		// Add a line item for each service, with a temporary price reused for identical lines
+		for i, service := range items {
+			// A description written at the register is shown after the item name, and so is the
+			// measured quantity: Stripe quantities are whole numbers, so a measured line is one
+			// item priced at its total
+			itemName := service.Name
+			if IsMeasuredLine(service) {
+				itemName = TruncateText(service.Name+" - "+MeasuredDetail(service), maxPaymentLinkItemNameLength)
+			}
+			if service.DescriptionEdited && service.Description != "" {
+				itemName = TruncateText(itemName+" - "+service.Description, maxPaymentLinkItemNameLength)
+			}
+
+			// Prices made for a scheduled sale are labeled as such in the Dashboard
+			kind := "item"
+			if IsSaleLine(service) {
+				kind = "sale item"
+			}
+
+			// A temporary Price for this service with tax included,
+			// linked to the actual Stripe Product.
+			priceParams := &stripe.PriceParams{
+				Currency:    stripe.String(string(stripe.CurrencyUSD)),
+				UnitAmount:  stripe.Int64(lineCents[i]),                              // Price in cents, includes local tax
+				TaxBehavior: stripe.String(string(stripe.PriceTaxBehaviorInclusive)), // Indicates UnitAmount includes tax
+				// Nickname can be useful for identifying these temporary prices in Stripe logs/dashboard
+				Nickname: stripe.String(fmt.Sprintf("Payment Link %s for %s (tax incl.)", kind, itemName)),
+			}
+			if automaticTax {
+				priceParams.UnitAmount = stripe.Int64(int64(math.Round(service.Price * 100)))
+				priceParams.TaxBehavior = stripe.String(string(stripe.PriceTaxBehaviorExclusive))
+				priceParams.Nickname = stripe.String(fmt.Sprintf("Payment Link %s for %s (Stripe Tax)", kind, itemName))
+			}
+			if IsSaleLine(service) {
+				priceParams.AddMetadata(MetadataListPrice, fmt.Sprintf("%.2f", service.ListPrice))
+			}
+			if automaticTax && service.GiftCard {
+				// Store credit is taxed when it is spent, whatever tax code the catalog product has
+				priceParams.ProductData = &stripe.PriceProductDataParams{
+					Name:    stripe.String(itemName),
+					TaxCode: stripe.String(NonTaxableTaxCode),
+				}
+			} else if itemName != service.Name {
+				// The catalog product would show its own name and description at checkout, so the
+				// described line gets an ad-hoc product; products.json and the Stripe product are unchanged
+				priceParams.ProductData = &stripe.PriceProductDataParams{
+					Name:     stripe.String(itemName),
+					Metadata: map[string]string{"pos_product_id": service.ID},
+				}
+			} else if service.StripeProductID != "" {
+				priceParams.Product = stripe.String(service.StripeProductID) // Link to the existing Stripe Product
+			} else {
+				// Custom and quick-charge items have no catalog product, so create an ad-hoc one
+				utils.Debug("stripe", "Service has no StripeProductID, using ad-hoc product for payment link", "service", service.Name)
+				priceParams.ProductData = &stripe.PriceProductDataParams{
+					Name: stripe.String(service.Name),
+				}
+			}
+			tempPriceID, err := linkPrice(client, priceParams)
+			if err != nil {
+				utils.Error("stripe", "Error creating temporary Stripe price for payment link", "service", service.Name, "product_id", service.StripeProductID, "error", err)
+				return nil, fmt.Errorf("error creating temporary price for service %s: %w", service.Name, err)
+			}
+
+			// Add line item using the ID of the temporary Price
+			params.LineItems = append(params.LineItems, &stripe.PaymentLinkLineItemParams{
+				Price:    stripe.String(tempPriceID),
+				Quantity: stripe.Int64(1),
+			})
+		}
+
+		// The service fee is its own line so the customer sees it before paying
+		if summary.ServiceFee > 0 {
+			feeParams := &stripe.PriceParams{
+				Currency:    stripe.String(string(stripe.CurrencyUSD)),
+				UnitAmount:  stripe.Int64(int64(math.Round(summary.ServiceFee * 100))),
+				TaxBehavior: stripe.String(string(stripe.PriceTaxBehaviorInclusive)),
+				Nickname:    stripe.String("Payment Link service fee"),
+				ProductData: &stripe.PriceProductDataParams{
+					Name: stripe.String(ServiceFeeLabel()),
+				},
+			}
+			if automaticTax {
+				// The fee is worked out on the taxed total, so Stripe Tax mustn't tax it again
+				feeParams.TaxBehavior = stripe.String(string(stripe.PriceTaxBehaviorExclusive))
+				feeParams.ProductData.TaxCode = stripe.String(NonTaxableTaxCode)
+			}
+			feePriceID, err := linkPrice(client, feeParams)
+			if err != nil {
+				utils.Error("stripe", "Error creating service fee price for payment link", "fee", summary.ServiceFee, "error", err)
+				return nil, fmt.Errorf("error creating service fee price: %w", err)
+			}
+			params.LineItems = append(params.LineItems, &stripe.PaymentLinkLineItemParams{
+				Price:    stripe.String(feePriceID),
+				Quantity: stripe.Int64(1),
+			})
+		}
 	}
 
 	// Only set custom success URL in webhook mode
-	// In polling mode, let Stripe use their default success page
-	if config.GetCommunicationStrategy() == "webhooks" {
+	// In polling mode, let Stripe use their default success page, as it does when webhooks are
+	// forwarded to localhost, which the customer's browser can't reach
+	if config.GetCommunicationStrategy() == "webhooks" && !config.WebhookDevForwarding() {
 		baseURL := "https://" + config.Config.WebsiteName
 		params.AfterCompletion = &stripe.PaymentLinkAfterCompletionParams{
 			Type: stripe.String(string(stripe.PaymentLinkAfterCompletionTypeRedirect)),
@@ -212,48 +400,350 @@ func CreatePaymentLink(totalAmount float64, email string) (*stripe.PaymentLink,
 		utils.Debug("stripe", "Using default Stripe success page for polling mode")
 	}
 
-	// Create the payment link
-	return paymentlink.New(params)
+	// Single use: Stripe stops accepting payments after the first completed checkout session.
+	// stripe-go v74 has no typed field for restrictions, so they are sent as an extra parameter.
+	params.AddExtra("restrictions[completed_sessions][limit]", "1")
+
+	// Create the payment link. If Stripe refuses it, a reused price may have been archived in the
+	// Dashboard, so the next attempt makes new ones.
+	link, err := client.CreatePaymentLink(params)
+	if err != nil {
+		var priceIDs []string
+		for _, item := range params.LineItems {
+			priceIDs = append(priceIDs, stripe.StringValue(item.Price))
+		}
+		forgetLinkPrices(priceIDs)
+		return nil, err
+	}
+	return link, nil
+}
+
+// paymentLinkLineCents returns the tax-inclusive amount in cents of each cart line, given the
+// tax of each line (in cart order) and the total in cents the lines must add up to. Each line is
+// rounded to the cent and the difference left by rounding is absorbed by the last line, or by the
+// lines before it where the last can't take all of it without going below zero. A payment link
+// sells every line as one item (a measured line at its total), so the link then charges exactly
+// the cart total. An error is returned when the lines can't add up to the total, e.g. when it is
+// negative or the taxes don't match the cart.
+func paymentLinkLineCents(items []templates.Product, itemTaxes []float64, total int64) ([]int64, error) {
+	if len(items) == 0 || len(itemTaxes) != len(items) {
+		return nil, fmt.Errorf("cart has %d lines and %d line taxes", len(items), len(itemTaxes))
+	}
+
+	cents := make([]int64, len(items))
+	var sum int64
+	for i, item := range items {
+		cents[i] = int64(math.Round((item.Price + itemTaxes[i]) * 100))
+		if cents[i] < 0 {
+			return nil, fmt.Errorf("line %d (%s) has a negative amount", i+1, item.Name)
+		}
+		sum += cents[i]
+	}
+
+	delta := total - sum
+	for i := len(cents) - 1; i >= 0 && delta != 0; i-- {
+		adjustment := max(delta, -cents[i])
+		cents[i] += adjustment
+		delta -= adjustment
+	}
+	if delta != 0 {
+		return nil, fmt.Errorf("lines can't add up to %d cents", total)
+	}
+	return cents, nil
 }
 
-// CheckPaymentLinkStatus checks the status of a payment link
-func CheckPaymentLinkStatus(paymentLinkID string) (PaymentLinkStatus, error) {
+// paymentLinkSessionLimit is how many completed checkout sessions a status check asks for. One
+// pays the link; any more are duplicate payments from customers who scanned the same code.
+const paymentLinkSessionLimit = 3
+
+// paymentLinkClockSkew widens the created filter on checkout sessions, so a session isn't missed
+// because this machine's clock runs ahead of Stripe's
+const paymentLinkClockSkew = 5 * time.Minute
+
+// CheckPaymentLinkStatus checks the status of a payment link created at createdAt (zero if unknown).
+// Only completed sessions created since the link are listed, one page of a few. Sessions are
+// listed even for an inactive link, since Stripe deactivates a single-use link once it is paid;
+// callers stop checking once a link is completed or deactivated.
+func CheckPaymentLinkStatus(client StripeClient, paymentLinkID string, createdAt time.Time) (PaymentLinkStatus, error) {
 	// Retrieve the payment link from Stripe to check status
-	pl, err := paymentlink.Get(paymentLinkID, nil)
+	pl, err := withStripeRetry("paymentlink.Get", func() (*stripe.PaymentLink, error) {
+		return client.GetPaymentLink(paymentLinkID)
+	})
 	if err != nil {
 		return PaymentLinkStatus{}, fmt.Errorf("error retrieving payment link: %w", err)
 	}
 
-	// Query for checkout sessions associated with this payment link
+	// Query for completed checkout sessions associated with this payment link
 	params := &stripe.CheckoutSessionListParams{}
 	params.PaymentLink = stripe.String(paymentLinkID)
+	params.Filters.AddFilter("status", "", string(stripe.CheckoutSessionStatusComplete))
+	if !createdAt.IsZero() {
+		params.Filters.AddFilter("created", "gte", strconv.FormatInt(createdAt.Add(-paymentLinkClockSkew).Unix(), 10))
+	}
+	params.Limit = stripe.Int64(paymentLinkSessionLimit)
+	params.Single = true
 
 	// Check for completed checkout sessions and extract customer email
-	i := session.List(params)
-	hasCompletedPayment := false
-	var customerEmail string
-
-	// Check if we find any completed checkout sessions for this payment link
-	for i.Next() {
-		s := i.CheckoutSession()
-		if s.Status == "complete" {
-			hasCompletedPayment = true
-			// Extract customer email from the checkout session
-			if s.CustomerDetails != nil && s.CustomerDetails.Email != "" {
-				customerEmail = s.CustomerDetails.Email
-			}
-			break
+	sessions, err := client.ListCheckoutSessions(params)
+	if err != nil {
+		utils.Error("stripe", "Error checking checkout sessions", "error", err)
+	}
+	status := PaymentLinkStatus{Active: pl.Active, AutomaticTax: pl.AutomaticTax != nil && pl.AutomaticTax.Enabled}
+
+	// Sessions are listed newest first; the link was paid by the earliest
+	var paidAt int64
+	for _, s := range sessions {
+		if s.Status != stripe.CheckoutSessionStatusComplete || (status.Completed && s.Created >= paidAt) {
+			continue
+		}
+		status.Completed = true
+		status.SessionID = s.ID
+		paidAt = s.Created
+		// Extract customer email from the checkout session
+		status.CustomerEmail = ""
+		if s.CustomerDetails != nil {
+			status.CustomerEmail = s.CustomerDetails.Email
 		}
 	}
 
-	if err := i.Err(); err != nil {
-		utils.Error("stripe", "Error checking checkout sessions", "error", err)
+	// Customers who scanned the same code before it was used up may each have paid
+	status.Duplicates, err = flagDuplicateSessions(paymentLinkID, sessions)
+	if err != nil {
+		utils.Error("stripe", "Error recording duplicate payment link sessions", "payment_link_id", paymentLinkID, "error", err)
+	}
+
+	return status, nil
+}
+
+// VoidPayment reverses a completed payment in Stripe.
+// The PaymentIntent is canceled if Stripe still allows it, otherwise it is fully refunded.
+// Returns a short description of the reversal for the transaction log.
+func VoidPayment(client StripeClient, paymentID string) (string, error) {
+	intentID, err := resolvePaymentIntentID(client, paymentID)
+	if err != nil {
+		return "", err
+	}
+
+	intent, err := client.GetPaymentIntent(intentID)
+	if err != nil {
+		return "", fmt.Errorf("error retrieving payment intent: %w", err)
+	}
+
+	switch intent.Status {
+	case stripe.PaymentIntentStatusRequiresPaymentMethod,
+		stripe.PaymentIntentStatusRequiresConfirmation,
+		stripe.PaymentIntentStatusRequiresAction,
+		stripe.PaymentIntentStatusRequiresCapture,
+		stripe.PaymentIntentStatusProcessing:
+		if _, err := client.CancelPaymentIntent(intentID); err != nil {
+			return "", fmt.Errorf("error canceling payment intent: %w", err)
+		}
+		utils.Info("stripe", "Voided payment by canceling intent", "payment_id", paymentID, "intent_id", intentID)
+		return "canceled", nil
+
+	case stripe.PaymentIntentStatusSucceeded:
+		params := &stripe.RefundParams{
+			PaymentIntent: stripe.String(intentID),
+			Reason:        stripe.String(string(stripe.RefundReasonRequestedByCustomer)),
+		}
+		params.AddMetadata(MetadataPaymentID, paymentID)
+		reverseVendorTransfer(params, intent)
+		r, err := client.CreateRefund(params)
+		if err != nil {
+			return "", fmt.Errorf("error refunding payment intent: %w", err)
+		}
+		utils.Info("stripe", "Voided payment by refunding intent", "payment_id", paymentID, "intent_id", intentID, "refund_id", r.ID)
+		QueueStripeFeeReversalLookup(paymentID, r.ID)
+		return "refunded " + r.ID, nil
+
+	default:
+		return "", fmt.Errorf("payment intent %s cannot be voided in status %s", intentID, intent.Status)
+	}
+}
+
+// RefundPaymentAmount refunds part of a completed payment, e.g. the excess of an exchange.
+// Returns a short description of the refund for the transaction log.
+func RefundPaymentAmount(client StripeClient, paymentID string, amount float64) (string, error) {
+	intentID, err := resolvePaymentIntentID(client, paymentID)
+	if err != nil {
+		return "", err
+	}
+
+	params := &stripe.RefundParams{
+		PaymentIntent: stripe.String(intentID),
+		Amount:        stripe.Int64(int64(math.Round(amount * 100))),
+		Reason:        stripe.String(string(stripe.RefundReasonRequestedByCustomer)),
+	}
+	params.AddMetadata(MetadataPaymentID, paymentID)
+	if VendorsEnabled() {
+		intent, err := client.GetPaymentIntent(intentID)
+		if err != nil {
+			return "", fmt.Errorf("error retrieving payment intent: %w", err)
+		}
+		reverseVendorTransfer(params, intent)
+	}
+	r, err := client.CreateRefund(params)
+	if err != nil {
+		return "", fmt.Errorf("error refunding payment intent: %w", err)
+	}
+	utils.Info("stripe", "Refunded part of payment", "payment_id", paymentID, "intent_id", intentID, "amount", amount, "refund_id", r.ID)
+	QueueStripeFeeReversalLookup(paymentID, r.ID)
+	return fmt.Sprintf("refunded $%.2f %s", amount, r.ID), nil
+}
+
+// PaymentCardDetails identifies the card behind a payment for dispute handling
+type PaymentCardDetails struct {
+	Brand      string
+	Last4      string
+	ReceiptURL string
+	Tip        float64 // Tip the customer added on the terminal reader
+
+	PayoutAccount string // Market vendor's connected account the payment was transferred to
+}
+
+// GetPaymentCardDetails looks up the card brand, last four digits and Stripe receipt URL
+// from the latest charge of a payment. Payments without a charge or card return empty details.
+func GetPaymentCardDetails(client StripeClient, paymentID string) (PaymentCardDetails, error) {
+	intentID, err := resolvePaymentIntentID(client, paymentID)
+	if err != nil {
+		return PaymentCardDetails{}, err
+	}
+
+	intent, err := GetPaymentIntent(client, intentID)
+	if err != nil {
+		return PaymentCardDetails{}, fmt.Errorf("error retrieving payment intent: %w", err)
+	}
+	var tip float64
+	if intent.AmountDetails != nil && intent.AmountDetails.Tip != nil {
+		tip = float64(intent.AmountDetails.Tip.Amount) / 100
+	}
+	if intent.LatestCharge == nil || intent.LatestCharge.ID == "" {
+		return PaymentCardDetails{Tip: tip, PayoutAccount: payoutAccount(intent)}, nil
+	}
+
+	ch, err := withStripeRetry("charge.Get", func() (*stripe.Charge, error) {
+		return client.GetCharge(intent.LatestCharge.ID)
+	})
+	if err != nil {
+		return PaymentCardDetails{}, fmt.Errorf("error retrieving charge: %w", err)
+	}
+
+	details := PaymentCardDetails{ReceiptURL: ch.ReceiptURL, Tip: tip, PayoutAccount: payoutAccount(intent)}
+	if pm := ch.PaymentMethodDetails; pm != nil {
+		switch {
+		case pm.CardPresent != nil: // Terminal
+			details.Brand, details.Last4 = string(pm.CardPresent.Brand), pm.CardPresent.Last4
+		case pm.InteracPresent != nil: // Terminal (Interac)
+			details.Brand, details.Last4 = pm.InteracPresent.Brand, pm.InteracPresent.Last4
+		case pm.Card != nil: // Manual entry and QR checkout
+			details.Brand, details.Last4 = string(pm.Card.Brand), pm.Card.Last4
+		}
+	}
+	return details, nil
+}
+
+// UpdatePaymentReceiptEmail sets the receipt email on the Stripe payments behind a logged sale
+// so Stripe sends its own receipt too. QR payments also update the checkout customer's email.
+// Each Stripe change is recorded as a payment update from source; sales without Stripe payments are skipped.
+func UpdatePaymentReceiptEmail(client StripeClient, confirmationCode, email, source string) error {
+	transaction, err := LoadTransactionByID(confirmationCode)
+	if err != nil {
+		return fmt.Errorf("sale %s not found: %w", confirmationCode, err)
+	}
+
+	// Split sales have a Stripe payment per card tender
+	paymentIDs := []string{transaction.ID}
+	if len(transaction.Tenders) > 0 {
+		paymentIDs = nil
+		for _, tender := range transaction.Tenders {
+			paymentIDs = append(paymentIDs, tender.PaymentID)
+		}
+	}
+
+	var errs []string
+	for _, paymentID := range paymentIDs {
+		if !strings.HasPrefix(paymentID, "pi_") && !strings.HasPrefix(paymentID, "plink_") {
+			continue // Cash, gift card and return payments have no Stripe object
+		}
+		if err := updateStripeReceiptEmail(client, confirmationCode, paymentID, email, source); err != nil {
+			utils.Error("receipt", "Error updating Stripe receipt email", "confirmation_code", confirmationCode, "payment_id", paymentID, "error", err)
+			errs = append(errs, err.Error())
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("stripe receipt email not updated: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// updateStripeReceiptEmail sets the receipt email of one payment's PaymentIntent and, for QR
+// payments, the email of the customer Stripe created at checkout
+func updateStripeReceiptEmail(client StripeClient, confirmationCode, paymentID, email, source string) error {
+	intentID, err := resolvePaymentIntentID(client, paymentID)
+	if err != nil {
+		return err
+	}
+
+	intent, err := GetPaymentIntent(client, intentID)
+	if err != nil {
+		return fmt.Errorf("error retrieving payment intent: %w", err)
+	}
+
+	if intent.ReceiptEmail != email {
+		_, err := withStripeRetry("paymentintent.Update", func() (*stripe.PaymentIntent, error) {
+			return client.UpdatePaymentIntent(intentID, &stripe.PaymentIntentParams{ReceiptEmail: stripe.String(email)})
+		})
+		if err != nil {
+			return fmt.Errorf("error updating payment intent %s: %w", intentID, err)
+		}
+		if err := SavePaymentUpdateRecord(CreatePaymentUpdateRecord(
+			confirmationCode, "receipt_email", intent.ReceiptEmail, email, "receipt_email", source,
+			"Stripe PaymentIntent "+intentID,
+		)); err != nil {
+			utils.Error("receipt", "Error saving receipt email update", "confirmation_code", confirmationCode, "error", err)
+		}
+	}
+
+	if strings.HasPrefix(paymentID, "plink_") && intent.Customer != nil && intent.Customer.ID != "" {
+		customerID := intent.Customer.ID
+		_, err := withStripeRetry("customer.Update", func() (*stripe.Customer, error) {
+			return client.UpdateCustomer(customerID, &stripe.CustomerParams{Email: stripe.String(email)})
+		})
+		if err != nil {
+			return fmt.Errorf("error updating customer %s: %w", customerID, err)
+		}
+		if err := SavePaymentUpdateRecord(CreatePaymentUpdateRecord(
+			confirmationCode, "customer_email", intent.Customer.Email, email, "email", source,
+			"Stripe Customer "+customerID,
+		)); err != nil {
+			utils.Error("receipt", "Error saving customer email update", "confirmation_code", confirmationCode, "error", err)
+		}
+	}
+
+	utils.Info("receipt", "Stripe receipt email updated", "confirmation_code", confirmationCode, "payment_intent_id", intentID)
+	return nil
+}
+
+// resolvePaymentIntentID returns the PaymentIntent behind a payment ID.
+// QR payments are recorded by payment link ID, so the link's completed checkout session is looked up.
+func resolvePaymentIntentID(client StripeClient, paymentID string) (string, error) {
+	if !strings.HasPrefix(paymentID, "plink_") {
+		return paymentID, nil
+	}
+
+	params := &stripe.CheckoutSessionListParams{}
+	params.PaymentLink = stripe.String(paymentID)
+
+	sessions, err := client.ListCheckoutSessions(params)
+	if err != nil {
+		return "", fmt.Errorf("error listing checkout sessions: %w", err)
+	}
+	for _, s := range sessions {
+		if s.Status == "complete" && s.PaymentIntent != nil {
+			return s.PaymentIntent.ID, nil
+		}
 	}
 
-	// Return the status
-	return PaymentLinkStatus{
-		Active:        pl.Active,
-		Completed:     hasCompletedPayment,
-		CustomerEmail: customerEmail,
-	}, nil
+	return "", fmt.Errorf("no completed payment found for payment link %s", paymentID)
 }
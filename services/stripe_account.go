@@ -0,0 +1,159 @@
+package services
+
+import (
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"checkout/config"
+	"checkout/i18n"
+	"checkout/utils"
+
+	"github.com/stripe/stripe-go/v74"
+)
+
+// How often the Stripe account's capabilities and requirements are checked
+const accountCheckInterval = 24 * time.Hour
+
+// StripeAccountDashboardURL is the Stripe Dashboard page listing what the account needs
+const StripeAccountDashboardURL = "https://dashboard.stripe.com/account/status"
+
+// Stripe account problems, each shown with the text of its account.problem_* message
+const (
+	AccountChargesDisabled      = "charges_disabled"
+	AccountPayoutsDisabled      = "payouts_disabled"
+	AccountCardPaymentsInactive = "card_payments_inactive"
+	AccountTransfersInactive    = "transfers_inactive"
+	AccountRequirementsPastDue  = "requirements_past_due"
+)
+
+// AccountStatus is the result of the last check of the Stripe account
+type AccountStatus struct {
+	AccountID      string
+	Problems       []string // Account* problems, empty when the account can take payments
+	PastDue        []string // Requirements Stripe is waiting for, e.g. "individual.verification.document"
+	DisabledReason string
+	Error          string // Why the account couldn't be checked
+	CheckedAt      time.Time
+}
+
+// Restricted reports whether the account has a problem that stops or will stop payments or payouts
+func (s AccountStatus) Restricted() bool {
+	return len(s.Problems) > 0
+}
+
+// stripeAccount holds the last account check
+var stripeAccount = struct {
+	status AccountStatus
+	mutex  sync.RWMutex
+}{}
+
+// StripeAccountStatus returns the last account check; CheckedAt is zero before the first one
+func StripeAccountStatus() AccountStatus {
+	stripeAccount.mutex.RLock()
+	defer stripeAccount.mutex.RUnlock()
+	return stripeAccount.status
+}
+
+// StartAccountCheckScheduler checks the Stripe account daily. The startup checks run the first
+// check, in the background so startup doesn't wait on Stripe.
+func StartAccountCheckScheduler() {
+	go func() {
+		ticker := time.NewTicker(accountCheckInterval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			CheckStripeAccount()
+		}
+	}()
+
+	utils.Info("stripe", "Stripe account check scheduler started")
+}
+
+// CheckStripeAccount fetches the account and records the problems that stop it from taking card
+// payments or receiving payouts. Demo mode has no Stripe account, so nothing is checked.
+func CheckStripeAccount() AccountStatus {
+	status := AccountStatus{CheckedAt: time.Now()}
+	if config.Config.DemoMode {
+		status = AccountStatus{}
+	} else if account, err := Stripe.GetAccount(); err != nil {
+		utils.Warn("stripe", "Error checking the Stripe account", "error", err)
+		status.Error = err.Error()
+	} else {
+		status = accountStatus(account, status.CheckedAt)
+		if status.Restricted() {
+			utils.Warn("stripe", "Stripe account is restricted", "account_id", status.AccountID,
+				"problems", status.Problems, "past_due", status.PastDue, "disabled_reason", status.DisabledReason)
+		} else {
+			utils.Info("stripe", "Stripe account can take payments", "account_id", status.AccountID)
+		}
+	}
+
+	stripeAccount.mutex.Lock()
+	stripeAccount.status = status
+	stripeAccount.mutex.Unlock()
+	return status
+}
+
+// accountStatus summarizes the problems of a fetched account
+func accountStatus(account *stripe.Account, checkedAt time.Time) AccountStatus {
+	status := AccountStatus{AccountID: account.ID, CheckedAt: checkedAt}
+	if !account.ChargesEnabled {
+		status.Problems = append(status.Problems, AccountChargesDisabled)
+	}
+	if !account.PayoutsEnabled {
+		status.Problems = append(status.Problems, AccountPayoutsDisabled)
+	}
+	// Capabilities the account never requested are left alone; a standard account has no transfers
+	if capabilities := account.Capabilities; capabilities != nil {
+		if capabilities.CardPayments != "" && capabilities.CardPayments != stripe.AccountCapabilityStatusActive {
+			status.Problems = append(status.Problems, AccountCardPaymentsInactive)
+		}
+		if capabilities.Transfers != "" && capabilities.Transfers != stripe.AccountCapabilityStatusActive {
+			status.Problems = append(status.Problems, AccountTransfersInactive)
+		}
+	}
+	if requirements := account.Requirements; requirements != nil {
+		status.DisabledReason = string(requirements.DisabledReason)
+		if len(requirements.PastDue) > 0 {
+			status.Problems = append(status.Problems, AccountRequirementsPastDue)
+			status.PastDue = append([]string(nil), requirements.PastDue...)
+		}
+	}
+	return status
+}
+
+// AccountProblemMessage describes an account problem to the staff
+func AccountProblemMessage(problem string, status AccountStatus) string {
+	switch problem {
+	case AccountChargesDisabled:
+		return i18n.T("account.problem_charges_disabled")
+	case AccountPayoutsDisabled:
+		return i18n.T("account.problem_payouts_disabled")
+	case AccountCardPaymentsInactive:
+		return i18n.T("account.problem_card_payments_inactive")
+	case AccountTransfersInactive:
+		return i18n.T("account.problem_transfers_inactive")
+	case AccountRequirementsPastDue:
+		return i18n.T("account.problem_requirements_past_due", strings.Join(status.PastDue, ", "))
+	}
+	return problem
+}
+
+// accountErrorCodes are the Stripe error and reader failure codes caused by the account rather
+// than the customer's card
+var accountErrorCodes = []string{
+	string(stripe.ErrorCodeAccountClosed),
+	string(stripe.ErrorCodeAccountInvalid),
+	string(stripe.ErrorCodeAccountInformationMismatch),
+	string(stripe.ErrorCodeNoAccount),
+	string(stripe.ErrorCodePlatformAccountRequired),
+	string(stripe.ErrorCodeTestmodeChargesOnly),
+}
+
+// IsAccountError reports whether a Stripe failure code means a restriction on the Stripe account,
+// rather than a card decline
+func IsAccountError(code string) bool {
+	return code != "" && slices.Contains(accountErrorCodes, code)
+}
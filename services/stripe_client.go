@@ -0,0 +1,311 @@
+package services
+
+import (
+	"net/http"
+
+	"github.com/stripe/stripe-go/v74"
+	"github.com/stripe/stripe-go/v74/account"
+	"github.com/stripe/stripe-go/v74/balance"
+	"github.com/stripe/stripe-go/v74/balancetransaction"
+	"github.com/stripe/stripe-go/v74/charge"
+	"github.com/stripe/stripe-go/v74/checkout/session"
+	"github.com/stripe/stripe-go/v74/customer"
+	"github.com/stripe/stripe-go/v74/paymentintent"
+	"github.com/stripe/stripe-go/v74/paymentlink"
+	"github.com/stripe/stripe-go/v74/price"
+	"github.com/stripe/stripe-go/v74/product"
+	"github.com/stripe/stripe-go/v74/refund"
+	"github.com/stripe/stripe-go/v74/tax/calculation"
+	taxtransaction "github.com/stripe/stripe-go/v74/tax/transaction"
+	"github.com/stripe/stripe-go/v74/terminal/location"
+	"github.com/stripe/stripe-go/v74/terminal/reader"
+)
+
+// StripeClient is the set of Stripe API calls the POS makes.
+// Handlers and services go through Stripe instead of calling stripe-go directly,
+// so a fake client can stand in for the live API.
+type StripeClient interface {
+	// Payment intents
+	CreatePaymentIntent(params *stripe.PaymentIntentParams) (*stripe.PaymentIntent, error)
+	ConfirmPaymentIntent(intentID string, params *stripe.PaymentIntentConfirmParams) (*stripe.PaymentIntent, error)
+	GetPaymentIntent(intentID string) (*stripe.PaymentIntent, error)
+	UpdatePaymentIntent(intentID string, params *stripe.PaymentIntentParams) (*stripe.PaymentIntent, error)
+	CancelPaymentIntent(intentID string) (*stripe.PaymentIntent, error)
+	ListPaymentIntents(params *stripe.PaymentIntentListParams) ([]*stripe.PaymentIntent, error)
+	CreateRefund(params *stripe.RefundParams) (*stripe.Refund, error)
+	GetRefund(refundID string) (*stripe.Refund, error)
+	GetCharge(chargeID string) (*stripe.Charge, error)
+
+	// Terminal
+	ProcessReaderPayment(readerID string, params *stripe.TerminalReaderProcessPaymentIntentParams) (*stripe.TerminalReader, error)
+	GetReader(readerID string) (*stripe.TerminalReader, error)
+	CancelReaderAction(readerID string) (*stripe.TerminalReader, error)
+	SetReaderDisplay(readerID string, params *stripe.TerminalReaderSetReaderDisplayParams) (*stripe.TerminalReader, error)
+	ListReaders(params *stripe.TerminalReaderListParams) ([]*stripe.TerminalReader, error)
+	ListLocations(params *stripe.TerminalLocationListParams) ([]*stripe.TerminalLocation, error)
+	CreateLocation(params *stripe.TerminalLocationParams) (*stripe.TerminalLocation, error)
+	UpdateLocation(locationID string, params *stripe.TerminalLocationParams) (*stripe.TerminalLocation, error)
+	DeleteLocation(locationID string) (*stripe.TerminalLocation, error)
+	CollectReaderInputs(readerID string, params *stripe.Params) (*TerminalReaderInputs, error)
+	GetReaderInputs(readerID string) (*TerminalReaderInputs, error)
+
+	// Payment links and checkout sessions
+	CreatePaymentLink(params *stripe.PaymentLinkParams) (*stripe.PaymentLink, error)
+	GetPaymentLink(paymentLinkID string) (*stripe.PaymentLink, error)
+	DeactivatePaymentLink(paymentLinkID string) (*stripe.PaymentLink, error)
+	ListPaymentLinks(params *stripe.PaymentLinkListParams) ([]*stripe.PaymentLink, error)
+	ListCheckoutSessions(params *stripe.CheckoutSessionListParams) ([]*stripe.CheckoutSession, error)
+	ListCheckoutSessionLineItems(sessionID string) ([]*stripe.LineItem, error)
+
+	// Stripe Tax
+	CreateTaxCalculation(params *stripe.TaxCalculationParams) (*stripe.TaxCalculation, error)
+	ListTaxCalculationLineItems(calculationID string) ([]*stripe.TaxCalculationLineItem, error)
+	CreateTaxTransaction(params *stripe.TaxTransactionCreateFromCalculationParams) (*stripe.TaxTransaction, error)
+
+	// Customers
+	UpdateCustomer(customerID string, params *stripe.CustomerParams) (*stripe.Customer, error)
+
+	// Catalog
+	GetProduct(productID string) (*stripe.Product, error)
+	CreateProduct(params *stripe.ProductParams) (*stripe.Product, error)
+	GetPrice(priceID string) (*stripe.Price, error)
+	CreatePrice(params *stripe.PriceParams) (*stripe.Price, error)
+	UpdatePrice(priceID string, params *stripe.PriceParams) (*stripe.Price, error)
+	ListPrices(params *stripe.PriceListParams) ([]*stripe.Price, error)
+
+	// Account
+	GetBalance() (*stripe.Balance, error)
+	GetBalanceTransaction(transactionID string) (*stripe.BalanceTransaction, error)
+	GetAccount() (*stripe.Account, error)
+}
+
+// Stripe is the client of the background jobs and commands, which have no App. Request handlers
+// use their App's client, passed to the functions here that call Stripe.
+// While demo mode is on, calls are answered by the in-memory demo client instead (see demo.go).
+var Stripe StripeClient = demoModeClient{live: stripeAPIClient{}, demo: demoStripe}
+
+// TerminalReaderInputs is a terminal reader with its collect_inputs action, which stripe-go v74
+// doesn't model, so it is requested through the raw API backend
+type TerminalReaderInputs struct {
+	stripe.APIResource
+	ID         string `json:"id"`
+	DeviceType string `json:"device_type"`
+	Action     *struct {
+		Type           string `json:"type"`
+		Status         string `json:"status"` // in_progress, succeeded or failed
+		FailureCode    string `json:"failure_code"`
+		FailureMessage string `json:"failure_message"`
+		CollectInputs  *struct {
+			Inputs []struct {
+				Type    string `json:"type"`
+				Skipped bool   `json:"skipped"`
+				Email   *struct {
+					Value string `json:"value"`
+				} `json:"email"`
+			} `json:"inputs"`
+		} `json:"collect_inputs"`
+	} `json:"action"`
+}
+
+// stripeAPIClient implements StripeClient with the stripe-go package functions
+type stripeAPIClient struct{}
+
+func (stripeAPIClient) CreatePaymentIntent(params *stripe.PaymentIntentParams) (*stripe.PaymentIntent, error) {
+	return paymentintent.New(params)
+}
+
+func (stripeAPIClient) ConfirmPaymentIntent(intentID string, params *stripe.PaymentIntentConfirmParams) (*stripe.PaymentIntent, error) {
+	return paymentintent.Confirm(intentID, params)
+}
+
+func (stripeAPIClient) GetPaymentIntent(intentID string) (*stripe.PaymentIntent, error) {
+	return paymentintent.Get(intentID, nil)
+}
+
+func (stripeAPIClient) UpdatePaymentIntent(intentID string, params *stripe.PaymentIntentParams) (*stripe.PaymentIntent, error) {
+	return paymentintent.Update(intentID, params)
+}
+
+func (stripeAPIClient) CancelPaymentIntent(intentID string) (*stripe.PaymentIntent, error) {
+	return paymentintent.Cancel(intentID, nil)
+}
+
+func (stripeAPIClient) ListPaymentIntents(params *stripe.PaymentIntentListParams) ([]*stripe.PaymentIntent, error) {
+	var intents []*stripe.PaymentIntent
+	i := paymentintent.List(params)
+	for i.Next() {
+		intents = append(intents, i.PaymentIntent())
+	}
+	return intents, i.Err()
+}
+
+func (stripeAPIClient) CreateRefund(params *stripe.RefundParams) (*stripe.Refund, error) {
+	return refund.New(params)
+}
+
+func (stripeAPIClient) GetRefund(refundID string) (*stripe.Refund, error) {
+	return refund.Get(refundID, nil)
+}
+
+func (stripeAPIClient) GetCharge(chargeID string) (*stripe.Charge, error) {
+	return charge.Get(chargeID, nil)
+}
+
+func (stripeAPIClient) ProcessReaderPayment(readerID string, params *stripe.TerminalReaderProcessPaymentIntentParams) (*stripe.TerminalReader, error) {
+	return reader.ProcessPaymentIntent(readerID, params)
+}
+
+func (stripeAPIClient) GetReader(readerID string) (*stripe.TerminalReader, error) {
+	return reader.Get(readerID, nil)
+}
+
+func (stripeAPIClient) CancelReaderAction(readerID string) (*stripe.TerminalReader, error) {
+	return reader.CancelAction(readerID, &stripe.TerminalReaderCancelActionParams{})
+}
+
+func (stripeAPIClient) SetReaderDisplay(readerID string, params *stripe.TerminalReaderSetReaderDisplayParams) (*stripe.TerminalReader, error) {
+	return reader.SetReaderDisplay(readerID, params)
+}
+
+func (stripeAPIClient) CollectReaderInputs(readerID string, params *stripe.Params) (*TerminalReaderInputs, error) {
+	terminalReader := &TerminalReaderInputs{}
+	err := stripe.GetBackend(stripe.APIBackend).Call(http.MethodPost, "/v1/terminal/readers/"+readerID+"/collect_inputs", stripe.Key, params, terminalReader)
+	return terminalReader, err
+}
+
+func (stripeAPIClient) GetReaderInputs(readerID string) (*TerminalReaderInputs, error) {
+	terminalReader := &TerminalReaderInputs{}
+	err := stripe.GetBackend(stripe.APIBackend).Call(http.MethodGet, "/v1/terminal/readers/"+readerID, stripe.Key, &stripe.Params{}, terminalReader)
+	return terminalReader, err
+}
+
+func (stripeAPIClient) ListReaders(params *stripe.TerminalReaderListParams) ([]*stripe.TerminalReader, error) {
+	var readers []*stripe.TerminalReader
+	i := reader.List(params)
+	for i.Next() {
+		readers = append(readers, i.TerminalReader())
+	}
+	return readers, i.Err()
+}
+
+func (stripeAPIClient) ListLocations(params *stripe.TerminalLocationListParams) ([]*stripe.TerminalLocation, error) {
+	var locations []*stripe.TerminalLocation
+	i := location.List(params)
+	for i.Next() {
+		locations = append(locations, i.TerminalLocation())
+	}
+	return locations, i.Err()
+}
+
+func (stripeAPIClient) CreateLocation(params *stripe.TerminalLocationParams) (*stripe.TerminalLocation, error) {
+	return location.New(params)
+}
+
+func (stripeAPIClient) UpdateLocation(locationID string, params *stripe.TerminalLocationParams) (*stripe.TerminalLocation, error) {
+	return location.Update(locationID, params)
+}
+
+func (stripeAPIClient) DeleteLocation(locationID string) (*stripe.TerminalLocation, error) {
+	return location.Del(locationID, nil)
+}
+
+func (stripeAPIClient) CreatePaymentLink(params *stripe.PaymentLinkParams) (*stripe.PaymentLink, error) {
+	return paymentlink.New(params)
+}
+
+func (stripeAPIClient) GetPaymentLink(paymentLinkID string) (*stripe.PaymentLink, error) {
+	return paymentlink.Get(paymentLinkID, nil)
+}
+
+func (stripeAPIClient) DeactivatePaymentLink(paymentLinkID string) (*stripe.PaymentLink, error) {
+	return paymentlink.Update(paymentLinkID, &stripe.PaymentLinkParams{Active: stripe.Bool(false)})
+}
+
+func (stripeAPIClient) ListPaymentLinks(params *stripe.PaymentLinkListParams) ([]*stripe.PaymentLink, error) {
+	var links []*stripe.PaymentLink
+	i := paymentlink.List(params)
+	for i.Next() {
+		links = append(links, i.PaymentLink())
+	}
+	return links, i.Err()
+}
+
+func (stripeAPIClient) ListCheckoutSessions(params *stripe.CheckoutSessionListParams) ([]*stripe.CheckoutSession, error) {
+	var sessions []*stripe.CheckoutSession
+	i := session.List(params)
+	for i.Next() {
+		sessions = append(sessions, i.CheckoutSession())
+	}
+	return sessions, i.Err()
+}
+
+func (stripeAPIClient) ListCheckoutSessionLineItems(sessionID string) ([]*stripe.LineItem, error) {
+	var items []*stripe.LineItem
+	i := session.ListLineItems(&stripe.CheckoutSessionListLineItemsParams{Session: stripe.String(sessionID)})
+	for i.Next() {
+		items = append(items, i.LineItem())
+	}
+	return items, i.Err()
+}
+
+func (stripeAPIClient) CreateTaxCalculation(params *stripe.TaxCalculationParams) (*stripe.TaxCalculation, error) {
+	return calculation.New(params)
+}
+
+func (stripeAPIClient) ListTaxCalculationLineItems(calculationID string) ([]*stripe.TaxCalculationLineItem, error) {
+	var items []*stripe.TaxCalculationLineItem
+	i := calculation.ListLineItems(&stripe.TaxCalculationListLineItemsParams{Calculation: stripe.String(calculationID)})
+	for i.Next() {
+		items = append(items, i.TaxCalculationLineItem())
+	}
+	return items, i.Err()
+}
+
+func (stripeAPIClient) CreateTaxTransaction(params *stripe.TaxTransactionCreateFromCalculationParams) (*stripe.TaxTransaction, error) {
+	return taxtransaction.CreateFromCalculation(params)
+}
+
+func (stripeAPIClient) UpdateCustomer(customerID string, params *stripe.CustomerParams) (*stripe.Customer, error) {
+	return customer.Update(customerID, params)
+}
+
+func (stripeAPIClient) GetProduct(productID string) (*stripe.Product, error) {
+	return product.Get(productID, nil)
+}
+
+func (stripeAPIClient) CreateProduct(params *stripe.ProductParams) (*stripe.Product, error) {
+	return product.New(params)
+}
+
+func (stripeAPIClient) GetPrice(priceID string) (*stripe.Price, error) {
+	return price.Get(priceID, nil)
+}
+
+func (stripeAPIClient) CreatePrice(params *stripe.PriceParams) (*stripe.Price, error) {
+	return price.New(params)
+}
+
+func (stripeAPIClient) UpdatePrice(priceID string, params *stripe.PriceParams) (*stripe.Price, error) {
+	return price.Update(priceID, params)
+}
+
+func (stripeAPIClient) ListPrices(params *stripe.PriceListParams) ([]*stripe.Price, error) {
+	var prices []*stripe.Price
+	i := price.List(params)
+	for i.Next() {
+		prices = append(prices, i.Price())
+	}
+	return prices, i.Err()
+}
+
+func (stripeAPIClient) GetBalance() (*stripe.Balance, error) {
+	return balance.Get(&stripe.BalanceParams{})
+}
+
+func (stripeAPIClient) GetBalanceTransaction(transactionID string) (*stripe.BalanceTransaction, error) {
+	return balancetransaction.Get(transactionID, nil)
+}
+
+func (stripeAPIClient) GetAccount() (*stripe.Account, error) {
+	return account.Get()
+}
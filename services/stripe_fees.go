@@ -0,0 +1,355 @@
+package services
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/stripe/stripe-go/v74"
+
+	"checkout/config"
+	"checkout/templates"
+	"checkout/utils"
+)
+
+// Payment update records of the processing fees Stripe takes. Stripe creates a charge's balance
+// transaction a little after the charge succeeds, so fees are looked up in the background and
+// recorded as payment updates, keyed by the Stripe payment ID, rather than in the sale's rows.
+const (
+	StripeFeeUpdateType         = "stripe_fee"          // Fee and net amount of a payment's charge
+	StripeFeeReversalUpdateType = "stripe_fee_reversal" // Part of the fee Stripe returned on a refund
+)
+
+// Fee lookup timing
+const (
+	feeLookupInterval   = 15 * time.Second // How often lookups that are due are run
+	feeBackfillInterval = time.Hour        // How often recent card payments without a fee are queued
+	feeBackfillDays     = 3                // Business days searched for card payments without a fee
+)
+
+// feeLookupDelays are the waits before each attempt to look up a fee. A fee that still isn't
+// available after the last one is left to the next backfill after a restart.
+var feeLookupDelays = []time.Duration{10 * time.Second, time.Minute, 5 * time.Minute, 30 * time.Minute, 2 * time.Hour}
+
+// StripeFee is what Stripe took from a payment and what it deposited after the fee
+type StripeFee struct {
+	Fee      float64
+	Net      float64
+	Refunded float64 // Part of the fee returned by refunds of the payment
+}
+
+// feeLookup is a charge's fee, or a refund's fee reversal, waiting to be looked up
+type feeLookup struct {
+	paymentID string // Stripe payment the fee is recorded for (PaymentIntent or payment link)
+	refundID  string // Set for a refund's fee reversal
+	attempts  int
+	due       time.Time
+}
+
+// feeLookups holds the lookups waiting to run, by refund ID or, for a charge, payment ID
+var feeLookups = struct {
+	pending map[string]*feeLookup
+	gaveUp  map[string]bool // Lookups that ran out of attempts, so the backfill doesn't retry them forever
+	mutex   sync.Mutex
+}{pending: make(map[string]*feeLookup), gaveUp: make(map[string]bool)}
+
+// QueueStripeFeeLookup looks up the fee of a Stripe payment in the background once Stripe has
+// created the balance transaction of its charge. Payments without a Stripe charge are ignored.
+func QueueStripeFeeLookup(paymentID string) {
+	if isStripePaymentID(paymentID) {
+		queueFeeLookup(paymentID, &feeLookup{paymentID: paymentID})
+	}
+}
+
+// QueueStripeFeeReversalLookup records in the background how much of a payment's fee Stripe
+// returned when it was refunded
+func QueueStripeFeeReversalLookup(paymentID, refundID string) {
+	if isStripePaymentID(paymentID) && refundID != "" {
+		queueFeeLookup(refundID, &feeLookup{paymentID: paymentID, refundID: refundID})
+	}
+}
+
+func queueFeeLookup(key string, lookup *feeLookup) {
+	feeLookups.mutex.Lock()
+	defer feeLookups.mutex.Unlock()
+	if feeLookups.pending[key] != nil || feeLookups.gaveUp[key] {
+		return
+	}
+	lookup.due = time.Now().Add(feeLookupDelays[0])
+	feeLookups.pending[key] = lookup
+}
+
+// StartStripeFeeLookups runs the queued fee lookups every feeLookupInterval, and every
+// feeBackfillInterval queues the card payments of the last feeBackfillDays business days whose
+// fee wasn't recorded, e.g. because the POS was restarted before it arrived
+func StartStripeFeeLookups() {
+	go func() {
+		ticker := time.NewTicker(feeLookupInterval)
+		defer ticker.Stop()
+
+		var backfill time.Time // When recent payments are next searched for missing fees
+		for {
+			now := time.Now()
+			if !now.Before(backfill) {
+				queueMissingStripeFees(now)
+				backfill = now.Add(feeBackfillInterval)
+			}
+			runDueFeeLookups(Stripe, now)
+			<-ticker.C
+		}
+	}()
+
+	utils.Info("payment", "Stripe fee lookups started", "interval", feeLookupInterval.String())
+}
+
+// runDueFeeLookups runs the lookups that are due, rescheduling those whose balance transaction
+// isn't available yet
+func runDueFeeLookups(client StripeClient, now time.Time) {
+	feeLookups.mutex.Lock()
+	due := make(map[string]*feeLookup)
+	for key, lookup := range feeLookups.pending {
+		if !now.Before(lookup.due) {
+			due[key] = lookup
+		}
+	}
+	feeLookups.mutex.Unlock()
+
+	for key, lookup := range due {
+		var recorded bool
+		var err error
+		if lookup.refundID != "" {
+			recorded, err = recordStripeFeeReversal(client, lookup.paymentID, lookup.refundID)
+		} else {
+			recorded, err = recordStripeFee(client, lookup.paymentID)
+		}
+		if err != nil {
+			utils.Warn("payment", "Error looking up Stripe fee", "payment_id", lookup.paymentID, "refund_id", lookup.refundID, "attempt", lookup.attempts+1, "error", err)
+		}
+
+		feeLookups.mutex.Lock()
+		lookup.attempts++
+		switch {
+		case recorded:
+			delete(feeLookups.pending, key)
+		case lookup.attempts >= len(feeLookupDelays):
+			delete(feeLookups.pending, key)
+			feeLookups.gaveUp[key] = true
+			utils.Warn("payment", "Stripe fee still not available, giving up", "payment_id", lookup.paymentID, "refund_id", lookup.refundID, "attempts", lookup.attempts)
+		default:
+			lookup.due = time.Now().Add(feeLookupDelays[lookup.attempts])
+		}
+		feeLookups.mutex.Unlock()
+	}
+}
+
+// queueMissingStripeFees queues a lookup for each card payment of the last feeBackfillDays
+// business days that has no recorded fee
+func queueMissingStripeFees(now time.Time) {
+	updates, err := loadPaymentUpdates()
+	if err != nil {
+		utils.Error("payment", "Error loading payment updates for the Stripe fee backfill", "error", err)
+		return
+	}
+	fees := stripeFees(updates)
+
+	queued := 0
+	today := config.BusinessDay(now)
+	for days := 0; days < feeBackfillDays; days++ {
+		transactions, err := LoadTransactionsForDay(today.AddDate(0, 0, -days))
+		if err != nil {
+			utils.Error("payment", "Error loading transactions for the Stripe fee backfill", "error", err)
+			continue
+		}
+		for _, transaction := range transactions {
+			if transaction.HasStripeFee() {
+				continue
+			}
+			for _, paymentID := range stripePaymentIDs(transaction) {
+				if _, recorded := fees[paymentID]; !recorded {
+					QueueStripeFeeLookup(paymentID)
+					queued++
+				}
+			}
+		}
+	}
+	if queued > 0 {
+		utils.Info("payment", "Queued Stripe fee lookups for recent payments", "payments", queued)
+	}
+}
+
+// recordStripeFee records the fee and net amount of a payment's charge. It reports false, with no
+// error, while the charge or its balance transaction doesn't exist yet.
+func recordStripeFee(client StripeClient, paymentID string) (bool, error) {
+	intentID, err := resolvePaymentIntentID(client, paymentID)
+	if err != nil {
+		return false, err
+	}
+	intent, err := GetPaymentIntent(client, intentID)
+	if err != nil {
+		return false, fmt.Errorf("error retrieving payment intent: %w", err)
+	}
+	if intent.LatestCharge == nil || intent.LatestCharge.ID == "" {
+		return false, nil
+	}
+
+	chargeID := intent.LatestCharge.ID
+	charge, err := withStripeRetry("charge.Get", func() (*stripe.Charge, error) {
+		return client.GetCharge(chargeID)
+	})
+	if err != nil {
+		return false, fmt.Errorf("error retrieving charge %s: %w", chargeID, err)
+	}
+	balance, err := getBalanceTransaction(client, charge.BalanceTransaction)
+	if balance == nil || err != nil {
+		return false, err
+	}
+
+	fee, net := centsToDollars(balance.Fee), centsToDollars(balance.Net)
+	for _, update := range []templates.PaymentUpdateRecord{
+		CreatePaymentUpdateRecord(paymentID, StripeFeeUpdateType, "", fmt.Sprintf("%.2f", fee), "fee_amount", "stripe_balance_transaction", balance.ID),
+		CreatePaymentUpdateRecord(paymentID, StripeFeeUpdateType, "", fmt.Sprintf("%.2f", net), "net_amount", "stripe_balance_transaction", balance.ID),
+	} {
+		if err := SavePaymentUpdateRecord(update); err != nil {
+			return false, fmt.Errorf("error recording Stripe fee: %w", err)
+		}
+	}
+
+	utils.Info("payment", "Stripe fee recorded", "payment_id", paymentID, "fee", fee, "net", net)
+	return true, nil
+}
+
+// recordStripeFeeReversal records how much of a payment's fee Stripe returned with a refund.
+// It reports false, with no error, while the refund's balance transaction doesn't exist yet.
+func recordStripeFeeReversal(client StripeClient, paymentID, refundID string) (bool, error) {
+	refund, err := withStripeRetry("refund.Get", func() (*stripe.Refund, error) {
+		return client.GetRefund(refundID)
+	})
+	if err != nil {
+		return false, fmt.Errorf("error retrieving refund %s: %w", refundID, err)
+	}
+	balance, err := getBalanceTransaction(client, refund.BalanceTransaction)
+	if balance == nil || err != nil {
+		return false, err
+	}
+
+	// A refund's balance transaction has a negative fee for the part of the fee returned
+	reversed := centsToDollars(-balance.Fee)
+	if err := SavePaymentUpdateRecord(CreatePaymentUpdateRecord(
+		paymentID, StripeFeeReversalUpdateType, "", fmt.Sprintf("%.2f", reversed), "fee_amount", "stripe_balance_transaction", refundID,
+	)); err != nil {
+		return false, fmt.Errorf("error recording Stripe fee reversal: %w", err)
+	}
+
+	utils.Info("payment", "Stripe fee reversal recorded", "payment_id", paymentID, "refund_id", refundID, "fee_returned", reversed)
+	return true, nil
+}
+
+// getBalanceTransaction retrieves a balance transaction referenced by a charge or refund, or
+// returns nil when Stripe hasn't created it yet
+func getBalanceTransaction(client StripeClient, ref *stripe.BalanceTransaction) (*stripe.BalanceTransaction, error) {
+	if ref == nil || ref.ID == "" {
+		return nil, nil
+	}
+	balance, err := withStripeRetry("balancetransaction.Get", func() (*stripe.BalanceTransaction, error) {
+		return client.GetBalanceTransaction(ref.ID)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving balance transaction %s: %w", ref.ID, err)
+	}
+	return balance, nil
+}
+
+// centsToDollars converts a Stripe amount in cents to dollars
+func centsToDollars(cents int64) float64 {
+	return float64(cents) / 100
+}
+
+// stripeFees returns the recorded fees of Stripe payments by payment ID. Only payments whose
+// fee was recorded are included; a refund's fee reversal alone doesn't add a payment.
+func stripeFees(updates []templates.PaymentUpdateRecord) map[string]StripeFee {
+	fees := make(map[string]StripeFee)
+	reversals := make(map[string]map[string]float64) // Payment ID -> refund ID -> fee returned
+	for _, record := range updates {
+		amount, err := strconv.ParseFloat(record.NewValue, 64)
+		if err != nil {
+			continue
+		}
+		switch record.UpdateType {
+		case StripeFeeUpdateType:
+			fee := fees[record.PaymentID]
+			if record.FieldName == "net_amount" {
+				fee.Net = amount
+			} else {
+				fee.Fee = amount
+			}
+			fees[record.PaymentID] = fee
+		case StripeFeeReversalUpdateType:
+			if reversals[record.PaymentID] == nil {
+				reversals[record.PaymentID] = make(map[string]float64)
+			}
+			reversals[record.PaymentID][record.Notes] = amount // Notes hold the refund ID
+		}
+	}
+
+	for paymentID, refunds := range reversals {
+		fee, recorded := fees[paymentID]
+		if !recorded {
+			continue
+		}
+		for _, amount := range refunds {
+			fee.Refunded += amount
+		}
+		fees[paymentID] = fee
+	}
+	return fees
+}
+
+// stripePaymentIDs returns the Stripe payments a sale was paid with: its own payment ID, or
+// the card tenders of a split sale
+func stripePaymentIDs(transaction templates.Transaction) []string {
+	var ids []string
+	if isStripePaymentID(transaction.ID) {
+		ids = append(ids, transaction.ID)
+	}
+	for _, tender := range transaction.Tenders {
+		if isStripePaymentID(tender.PaymentID) {
+			ids = append(ids, tender.PaymentID)
+		}
+	}
+	return ids
+}
+
+// StripeFeePending reports whether a sale was paid through Stripe but its fee isn't recorded yet
+func StripeFeePending(transaction templates.Transaction) bool {
+	return !transaction.HasStripeFee() && len(stripePaymentIDs(transaction)) > 0
+}
+
+// saleStripeFee adds up the recorded fees of a sale's Stripe payments. It reports false when the
+// sale has no Stripe payment or the fee of one of them isn't recorded yet.
+func saleStripeFee(transaction templates.Transaction, fees map[string]StripeFee) (StripeFee, bool) {
+	ids := stripePaymentIDs(transaction)
+	var total StripeFee
+	for _, id := range ids {
+		fee, recorded := fees[id]
+		if !recorded {
+			return StripeFee{}, false
+		}
+		total.Fee += fee.Fee
+		total.Net += fee.Net
+		total.Refunded += fee.Refunded
+	}
+	return total, len(ids) > 0
+}
+
+// applyStripeFees fills in the Stripe fee and net amount of transactions whose fees are recorded
+func applyStripeFees(updates []templates.PaymentUpdateRecord, transactions ...*templates.Transaction) {
+	fees := stripeFees(updates)
+	for _, transaction := range transactions {
+		if fee, ok := saleStripeFee(*transaction, fees); ok {
+			transaction.FeeAmount = roundCents(fee.Fee)
+			transaction.NetAmount = roundCents(fee.Net)
+			transaction.FeeRefunded = roundCents(fee.Refunded)
+		}
+	}
+}
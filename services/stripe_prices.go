@@ -0,0 +1,135 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/stripe/stripe-go/v74"
+
+	"checkout/config"
+	"checkout/utils"
+)
+
+// Payment link line items need a Stripe Price, and the cart's prices (tax included, edited at the
+// register, custom items) rarely match the catalog's. The POS makes its own, tagged with
+// TemporaryPriceMetadataKey, and reuses one for every identical line so the account doesn't fill
+// up with one-off prices.
+const (
+	TemporaryPriceMetadataKey = "pos_temporary"
+
+	// Prices made before they were tagged are recognised by the nickname they were given
+	legacyTemporaryPricePrefix = "Payment Link "
+)
+
+// linkPriceKey is what makes two payment link lines identical
+type linkPriceKey struct {
+	client      StripeClient // Prices only exist on the account of the client that made them
+	demo        bool         // Demo prices only exist in the demo client
+	product     string
+	productName string
+	taxCode     string
+	taxBehavior string
+	currency    string
+	amount      int64
+	nickname    string
+}
+
+// linkPrices remembers the price made for each kind of line since startup
+var linkPrices = struct {
+	byKey map[linkPriceKey]string
+	mutex sync.Mutex
+}{byKey: make(map[linkPriceKey]string)}
+
+// linkPrice returns the ID of a temporary price for a payment link line, reusing the one made
+// earlier for an identical line
+func linkPrice(client StripeClient, params *stripe.PriceParams) (string, error) {
+	key := linkPriceKey{
+		client:      client,
+		demo:        config.Config.DemoMode,
+		product:     stripe.StringValue(params.Product),
+		taxBehavior: stripe.StringValue(params.TaxBehavior),
+		currency:    stripe.StringValue(params.Currency),
+		amount:      stripe.Int64Value(params.UnitAmount),
+		nickname:    stripe.StringValue(params.Nickname),
+	}
+	if params.ProductData != nil {
+		key.productName = stripe.StringValue(params.ProductData.Name)
+		key.taxCode = stripe.StringValue(params.ProductData.TaxCode)
+	}
+
+	linkPrices.mutex.Lock()
+	defer linkPrices.mutex.Unlock()
+	if priceID, ok := linkPrices.byKey[key]; ok {
+		utils.Debug("stripe", "Reusing temporary price for payment link", "price_id", priceID, "amount", key.amount)
+		return priceID, nil
+	}
+
+	params.AddMetadata(TemporaryPriceMetadataKey, "true")
+	price, err := client.CreatePrice(params)
+	if err != nil {
+		return "", err
+	}
+	linkPrices.byKey[key] = price.ID
+	return price.ID, nil
+}
+
+// forgetLinkPrices stops reusing prices, e.g. after a payment link using them was refused in case
+// one was archived in the Dashboard
+func forgetLinkPrices(priceIDs []string) {
+	linkPrices.mutex.Lock()
+	defer linkPrices.mutex.Unlock()
+	for key, priceID := range linkPrices.byKey {
+		for _, forgotten := range priceIDs {
+			if priceID == forgotten {
+				delete(linkPrices.byKey, key)
+			}
+		}
+	}
+}
+
+// isTemporaryPrice reports whether a price was made by the POS for a payment link
+func isTemporaryPrice(price *stripe.Price) bool {
+	return price.Metadata[TemporaryPriceMetadataKey] == "true" || strings.HasPrefix(price.Nickname, legacyTemporaryPricePrefix)
+}
+
+// PurgeTemporaryPrices archives the active temporary prices created more than olderThanDays days
+// ago, including ones made before they were tagged. Archived prices stay on past payments but
+// can't be used again. It returns how many were archived.
+func PurgeTemporaryPrices(olderThanDays int) (int, error) {
+	if olderThanDays < 1 {
+		return 0, errors.New("prices must be at least 1 day old to be purged, so payment links still open keep working")
+	}
+
+	cutoff := time.Now().AddDate(0, 0, -olderThanDays)
+	prices, err := Stripe.ListPrices(&stripe.PriceListParams{
+		Active:       stripe.Bool(true),
+		CreatedRange: &stripe.RangeQueryParams{LesserThan: cutoff.Unix()},
+	})
+	if err != nil {
+		return 0, fmt.Errorf("error listing prices: %w", err)
+	}
+
+	var archived []string
+	var failures int
+	for _, price := range prices {
+		if !isTemporaryPrice(price) {
+			continue
+		}
+		if _, err := Stripe.UpdatePrice(price.ID, &stripe.PriceParams{Active: stripe.Bool(false)}); err != nil {
+			utils.Error("stripe", "Error archiving temporary price", "price_id", price.ID, "error", err)
+			failures++
+			continue
+		}
+		archived = append(archived, price.ID)
+	}
+	forgetLinkPrices(archived)
+
+	utils.Info("stripe", "Purged temporary prices", "archived", len(archived), "failed", failures, "older_than_days", olderThanDays)
+	if failures > 0 {
+		return len(archived), fmt.Errorf("%d temporary prices could not be archived; see the logs", failures)
+	}
+	return len(archived), nil
+}
@@ -0,0 +1,132 @@
+package services
+
+import (
+	"errors"
+	"math/rand"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/stripe/stripe-go/v74"
+
+	"checkout/utils"
+)
+
+// Retry and circuit breaker settings for read-only Stripe calls made while polling
+const (
+	stripeRetryAttempts      = 3
+	stripeRetryBaseDelay     = 250 * time.Millisecond
+	stripeBreakerThreshold   = 5
+	stripeBreakerOpenTimeout = 30 * time.Second
+)
+
+// ErrStripeUnavailable is returned without calling Stripe while the circuit breaker is open
+var ErrStripeUnavailable = errors.New("stripe temporarily unavailable")
+
+// stripeRetrySleep and stripeBreakerNow are the wall clock, replaced in tests
+var (
+	stripeRetrySleep = time.Sleep
+	stripeBreakerNow = time.Now
+)
+
+// stripeBreaker counts consecutive transient failures across all polled Stripe reads
+var stripeBreaker = struct {
+	failures  int
+	openUntil time.Time
+	mutex     sync.Mutex
+}{}
+
+// IsTransientStripeError reports whether err is worth retrying:
+// rate limits, Stripe 5xx responses, network failures, or an open circuit breaker.
+func IsTransientStripeError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, ErrStripeUnavailable) {
+		return true
+	}
+
+	var stripeErr *stripe.Error
+	if errors.As(err, &stripeErr) {
+		return stripeErr.HTTPStatusCode == http.StatusTooManyRequests || stripeErr.HTTPStatusCode >= 500
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// withStripeRetry runs a read-only Stripe call, retrying transient failures with jittered backoff.
+// Once failures persist the breaker opens and calls fail fast with ErrStripeUnavailable,
+// so pollers fall through to their regular timeout handling instead of hammering Stripe.
+func withStripeRetry[T any](operation string, call func() (T, error)) (T, error) {
+	var zero T
+
+	stripeBreaker.mutex.Lock()
+	open := stripeBreakerNow().Before(stripeBreaker.openUntil)
+	stripeBreaker.mutex.Unlock()
+	if open {
+		utils.Debug("stripe", "Circuit breaker open, skipping Stripe call", "operation", operation)
+		return zero, ErrStripeUnavailable
+	}
+
+	var err error
+	for attempt := 1; attempt <= stripeRetryAttempts; attempt++ {
+		var result T
+		result, err = call()
+		if !IsTransientStripeError(err) {
+			// Success or a permanent error - either way Stripe is reachable
+			recordStripeCallResult(operation, true)
+			return result, err
+		}
+
+		utils.Warn("stripe", "Transient Stripe error", "operation", operation, "attempt", attempt, "error", err)
+		if attempt < stripeRetryAttempts {
+			stripeRetrySleep(stripeRetryDelay(attempt))
+		}
+	}
+
+	recordStripeCallResult(operation, false)
+	return zero, err
+}
+
+// stripeRetryDelay returns the exponential backoff for an attempt plus up to 100% jitter
+func stripeRetryDelay(attempt int) time.Duration {
+	delay := stripeRetryBaseDelay << (attempt - 1)
+	return delay + time.Duration(rand.Int63n(int64(delay)))
+}
+
+// recordStripeCallResult updates the circuit breaker after a retried call
+func recordStripeCallResult(operation string, reachable bool) {
+	stripeBreaker.mutex.Lock()
+	defer stripeBreaker.mutex.Unlock()
+
+	if reachable {
+		if stripeBreaker.failures >= stripeBreakerThreshold {
+			utils.Info("stripe", "Stripe reachable again, closing circuit breaker", "operation", operation)
+		}
+		stripeBreaker.failures = 0
+		return
+	}
+
+	stripeBreaker.failures++
+	if stripeBreaker.failures >= stripeBreakerThreshold {
+		stripeBreaker.openUntil = stripeBreakerNow().Add(stripeBreakerOpenTimeout)
+		utils.Error("stripe", "Stripe errors persisting, opening circuit breaker",
+			"operation", operation, "failures", stripeBreaker.failures, "open_for", stripeBreakerOpenTimeout)
+	}
+}
+
+// GetPaymentIntent retrieves a PaymentIntent, retrying transient Stripe failures
+func GetPaymentIntent(client StripeClient, intentID string) (*stripe.PaymentIntent, error) {
+	return withStripeRetry("paymentintent.Get", func() (*stripe.PaymentIntent, error) {
+		return client.GetPaymentIntent(intentID)
+	})
+}
+
+// GetTerminalReader retrieves a terminal reader, retrying transient Stripe failures
+func GetTerminalReader(client StripeClient, readerID string) (*stripe.TerminalReader, error) {
+	return withStripeRetry("reader.Get", func() (*stripe.TerminalReader, error) {
+		return client.GetReader(readerID)
+	})
+}
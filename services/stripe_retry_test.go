@@ -0,0 +1,182 @@
+package services
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stripe/stripe-go/v74"
+)
+
+// sequenceClient answers GetPaymentIntent with each of its errors in turn, then succeeds
+type sequenceClient struct {
+	StripeClient
+	errs  []error
+	calls int
+}
+
+func (c *sequenceClient) GetPaymentIntent(intentID string) (*stripe.PaymentIntent, error) {
+	c.calls++
+	if len(c.errs) > 0 {
+		err := c.errs[0]
+		c.errs = c.errs[1:]
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &stripe.PaymentIntent{ID: intentID, Status: stripe.PaymentIntentStatusSucceeded}, nil
+}
+
+// stubStripeRetryClock closes the circuit breaker and records retry waits instead of sleeping.
+// The breaker's clock is the returned time, which the test can move.
+func stubStripeRetryClock(t *testing.T) (*[]time.Duration, *time.Time) {
+	t.Helper()
+	var sleeps []time.Duration
+	now := time.Unix(1767345600, 0)
+	stripeRetrySleep = func(d time.Duration) { sleeps = append(sleeps, d) }
+	stripeBreakerNow = func() time.Time { return now }
+	resetStripeBreaker := func() {
+		stripeBreaker.mutex.Lock()
+		stripeBreaker.failures, stripeBreaker.openUntil = 0, time.Time{}
+		stripeBreaker.mutex.Unlock()
+	}
+	resetStripeBreaker()
+	t.Cleanup(func() {
+		stripeRetrySleep, stripeBreakerNow = time.Sleep, time.Now
+		resetStripeBreaker()
+	})
+	return &sleeps, &now
+}
+
+func stripeStatus(code int) error {
+	return &stripe.Error{HTTPStatusCode: code, Msg: http.StatusText(code)}
+}
+
+func TestIsTransientStripeError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"success", nil, false},
+		{"rate limited", stripeStatus(http.StatusTooManyRequests), true},
+		{"server error", stripeStatus(http.StatusInternalServerError), true},
+		{"bad gateway", stripeStatus(http.StatusBadGateway), true},
+		{"card declined", &stripe.Error{HTTPStatusCode: http.StatusPaymentRequired, Code: stripe.ErrorCodeCardDeclined}, false},
+		{"not found", stripeStatus(http.StatusNotFound), false},
+		{"network timeout", &net.DNSError{Err: "i/o timeout", IsTimeout: true}, true},
+		{"circuit breaker open", ErrStripeUnavailable, true},
+		{"other error", errors.New("malformed response"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsTransientStripeError(tt.err); got != tt.want {
+				t.Errorf("IsTransientStripeError(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetPaymentIntentRetries(t *testing.T) {
+	tests := []struct {
+		name      string
+		errs      []error
+		wantCalls int
+		wantErr   bool
+	}{
+		{"first call succeeds", nil, 1, false},
+		{"server error then success", []error{stripeStatus(500)}, 2, false},
+		{"rate limit and outage then success", []error{stripeStatus(429), stripeStatus(503)}, 3, false},
+		{"network error then success", []error{&net.OpError{Op: "dial", Err: &net.DNSError{IsTimeout: true}}}, 2, false},
+		{"still failing after every attempt", []error{stripeStatus(502), stripeStatus(502), stripeStatus(502), nil}, stripeRetryAttempts, true},
+		{"permanent errors aren't retried", []error{stripeStatus(404), nil}, 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sleeps, _ := stubStripeRetryClock(t)
+			client := &sequenceClient{errs: tt.errs}
+
+			intent, err := GetPaymentIntent(client, "pi_poll")
+
+			if client.calls != tt.wantCalls {
+				t.Errorf("calls = %d, want %d", client.calls, tt.wantCalls)
+			}
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil && intent.ID != "pi_poll" {
+				t.Errorf("intent = %+v, want pi_poll", intent)
+			}
+			if len(*sleeps) != tt.wantCalls-1 {
+				t.Fatalf("waited %d times between %d calls", len(*sleeps), tt.wantCalls)
+			}
+			for i, d := range *sleeps {
+				base := stripeRetryBaseDelay << i
+				if d < base || d >= 2*base {
+					t.Errorf("wait %d = %v, want backoff %v plus jitter below %v", i+1, d, base, base)
+				}
+			}
+		})
+	}
+}
+
+// A breaker that opens stops calling Stripe, so pollers fall through to their timeouts, and
+// tries Stripe again once it has been open for stripeBreakerOpenTimeout
+func TestStripeCircuitBreaker(t *testing.T) {
+	_, now := stubStripeRetryClock(t)
+	outage := func() []error {
+		errs := make([]error, stripeRetryAttempts)
+		for i := range errs {
+			errs[i] = stripeStatus(http.StatusServiceUnavailable)
+		}
+		return errs
+	}
+
+	for i := range stripeBreakerThreshold {
+		if _, err := GetPaymentIntent(&sequenceClient{errs: outage()}, "pi_poll"); !IsTransientStripeError(err) || errors.Is(err, ErrStripeUnavailable) {
+			t.Fatalf("poll %d: err = %v, want Stripe's outage", i+1, err)
+		}
+	}
+
+	client := &sequenceClient{}
+	if _, err := GetPaymentIntent(client, "pi_poll"); !errors.Is(err, ErrStripeUnavailable) {
+		t.Errorf("open breaker: err = %v, want ErrStripeUnavailable", err)
+	}
+	*now = now.Add(stripeBreakerOpenTimeout - time.Second)
+	GetPaymentIntent(client, "pi_poll")
+	if client.calls != 0 {
+		t.Fatalf("called Stripe %d times while the breaker was open", client.calls)
+	}
+
+	*now = now.Add(time.Second)
+	if _, err := GetPaymentIntent(client, "pi_poll"); err != nil || client.calls != 1 {
+		t.Fatalf("after %v: err = %v after %d calls, want Stripe called again", stripeBreakerOpenTimeout, err, client.calls)
+	}
+
+	// The success closed the breaker, so it takes a full run of failures to open it again
+	for range stripeBreakerThreshold - 1 {
+		GetPaymentIntent(&sequenceClient{errs: outage()}, "pi_poll")
+	}
+	if _, err := GetPaymentIntent(client, "pi_poll"); err != nil {
+		t.Errorf("breaker opened after %d failures following a success: %v", stripeBreakerThreshold-1, err)
+	}
+}
+
+// Failures separated by successes never open the breaker
+func TestStripeCircuitBreakerCountsConsecutiveFailures(t *testing.T) {
+	stubStripeRetryClock(t)
+
+	for i := range 3 * stripeBreakerThreshold {
+		errs := []error{stripeStatus(500), stripeStatus(500), stripeStatus(500)}
+		if i%2 == 1 {
+			errs = nil
+		}
+		GetPaymentIntent(&sequenceClient{errs: errs}, "pi_poll")
+	}
+
+	if _, err := GetPaymentIntent(&sequenceClient{}, "pi_poll"); err != nil {
+		t.Errorf("breaker opened on interleaved failures: %v", err)
+	}
+}
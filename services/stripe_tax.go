@@ -0,0 +1,295 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/stripe/stripe-go/v74"
+
+	"checkout/config"
+	"checkout/templates"
+	"checkout/utils"
+)
+
+// NonTaxableTaxCode is Stripe Tax's code for items no tax applies to, such as gift cards and fees
+const NonTaxableTaxCode = "txcd_00000000"
+
+// StripeTaxQuote is the tax Stripe Tax worked out for a cart
+type StripeTaxQuote struct {
+	CalculationID string    // Tax calculation, recorded as a tax transaction once the sale is paid; empty when the tax came from a checkout session
+	CartHash      string    // Cart the tax is for (CartHash)
+	ItemTaxes     []float64 // Tax of each cart item, in cart order
+	ExpiresAt     time.Time // Stripe keeps a calculation for a limited time
+}
+
+// Tax returns the total tax of the quote
+func (q *StripeTaxQuote) Tax() float64 {
+	var tax float64
+	for _, itemTax := range q.ItemTaxes {
+		tax += itemTax
+	}
+	return roundCents(tax)
+}
+
+// stripeTax remembers the quote for the cart being paid, so totals, the charge and the
+// transaction log all use the same tax
+var stripeTax struct {
+	quote *StripeTaxQuote
+	mutex sync.Mutex
+}
+
+// StripeTaxEnabled reports whether sales at the selected Terminal Location are taxed by Stripe Tax
+func StripeTaxEnabled() bool {
+	return config.GetTaxMode(Terminal.SelectedLocation().ID) == config.TaxModeStripeTax
+}
+
+// stripeTaxQuoteFor returns the quote kept for a cart, if there is one and it is still usable
+func stripeTaxQuoteFor(cart []templates.Product) (*StripeTaxQuote, bool) {
+	stripeTax.mutex.Lock()
+	defer stripeTax.mutex.Unlock()
+
+	quote := stripeTax.quote
+	if quote == nil || quote.CartHash != CartHash(cart) || len(quote.ItemTaxes) != len(cart) {
+		return nil, false
+	}
+	if !quote.ExpiresAt.IsZero() && time.Now().After(quote.ExpiresAt) {
+		return nil, false
+	}
+	return quote, true
+}
+
+// setStripeTaxQuote keeps a quote for the cart it was made for, replacing any other
+func setStripeTaxQuote(quote *StripeTaxQuote) {
+	stripeTax.mutex.Lock()
+	stripeTax.quote = quote
+	stripeTax.mutex.Unlock()
+}
+
+// ItemTaxes returns the tax of each item of a cart: Stripe Tax's when it was quoted for this
+// cart, otherwise the local rates'
+func ItemTaxes(cart []templates.Product) []float64 {
+	if quote, ok := stripeTaxQuoteFor(cart); ok {
+		return append([]float64(nil), quote.ItemTaxes...)
+	}
+	_, itemTaxes := SummarizeCart(cart, config.Config.DefaultTaxRate, config.Config.TaxCategories)
+	return itemTaxes
+}
+
+// QuoteStripeTax asks Stripe Tax for the tax on the cart when the selected location uses it, and
+// keeps the answer for the cart summary until the cart changes. It is called as a payment
+// starts; until then the cart shows the local estimate. Exchanges keep the local rates, since
+// their returned lines refund tax worked out when the original sale was made.
+func QuoteStripeTax(client StripeClient, cart *CartStore) error {
+	if !StripeTaxEnabled() {
+		setStripeTaxQuote(nil)
+		return nil
+	}
+
+	items := cart.Items()
+	if len(items) == 0 || CartReturnOriginalID(cart) != "" {
+		return nil
+	}
+	if _, ok := stripeTaxQuoteFor(items); ok {
+		return nil
+	}
+
+	location := Terminal.SelectedLocation()
+	quote, err := CalculateStripeTax(client, items, location)
+	if err != nil {
+		return err
+	}
+	setStripeTaxQuote(quote)
+	utils.Info("tax", "Stripe Tax calculated for cart", "calculation_id", quote.CalculationID, "tax", quote.Tax(), "location_id", location.ID)
+	return nil
+}
+
+// TaxAddress returns where a sale at a location is taxed: the Terminal Location's address,
+// or the default location in settings when the location has none
+func TaxAddress(location templates.StripeLocation) (templates.StripeAddress, error) {
+	if location.Address.PostalCode != "" && location.Address.Country != "" {
+		return location.Address, nil
+	}
+
+	address := templates.StripeAddress{
+		City:       strings.TrimSpace(config.Config.DefaultCity),
+		State:      strings.TrimSpace(config.Config.DefaultState),
+		PostalCode: strings.TrimSpace(config.Config.DefaultPostalCode),
+		Country:    strings.ToUpper(strings.TrimSpace(config.Config.DefaultCountry)),
+	}
+	if address.Country == "" {
+		address.Country = "US"
+	}
+	if address.PostalCode == "" {
+		return address, errors.New("Stripe Tax needs an address: give the Terminal Location one in the Stripe Dashboard, or set the default postal code in tax settings")
+	}
+	return address, nil
+}
+
+// CalculateStripeTax asks Stripe Tax for the tax on each item of a cart sold at a location.
+// Items linked to a Stripe product use its tax code; others use the default code from the
+// Stripe Tax settings. Gift cards are never taxed, and free or returned lines carry no tax.
+func CalculateStripeTax(client StripeClient, cart []templates.Product, location templates.StripeLocation) (*StripeTaxQuote, error) {
+	address, err := TaxAddress(location)
+	if err != nil {
+		return nil, err
+	}
+
+	params := &stripe.TaxCalculationParams{
+		Currency: stripe.String(string(stripe.CurrencyUSD)),
+		CustomerDetails: &stripe.TaxCalculationCustomerDetailsParams{
+			Address: &stripe.AddressParams{
+				Line1:      stripe.String(address.Line1),
+				City:       stripe.String(address.City),
+				State:      stripe.String(address.State),
+				PostalCode: stripe.String(address.PostalCode),
+				Country:    stripe.String(address.Country),
+			},
+			AddressSource: stripe.String(string(stripe.TaxCalculationCustomerDetailsAddressSourceBilling)),
+		},
+	}
+	for i, product := range cart {
+		amount := int64(math.Round(product.Price * 100))
+		if amount <= 0 {
+			continue
+		}
+		line := &stripe.TaxCalculationLineItemParams{
+			Amount:      stripe.Int64(amount),
+			Quantity:    stripe.Int64(1),
+			Reference:   stripe.String(taxLineReference(i)),
+			TaxBehavior: stripe.String(string(stripe.PriceTaxBehaviorExclusive)),
+		}
+		if product.GiftCard {
+			line.TaxCode = stripe.String(NonTaxableTaxCode)
+		} else if product.StripeProductID != "" && !IsDemoID(product.StripeProductID) {
+			line.Product = stripe.String(product.StripeProductID)
+		}
+		params.LineItems = append(params.LineItems, line)
+	}
+
+	quote := &StripeTaxQuote{CartHash: CartHash(cart), ItemTaxes: make([]float64, len(cart))}
+	if len(params.LineItems) == 0 {
+		return quote, nil
+	}
+
+	calculation, err := client.CreateTaxCalculation(params)
+	if err != nil {
+		return nil, fmt.Errorf("error calculating tax with Stripe Tax: %w", err)
+	}
+	lines, err := client.ListTaxCalculationLineItems(calculation.ID)
+	if err != nil {
+		return nil, fmt.Errorf("error reading Stripe Tax calculation %s: %w", calculation.ID, err)
+	}
+
+	byReference := make(map[string]int64, len(lines))
+	for _, line := range lines {
+		byReference[line.Reference] = line.AmountTax
+	}
+	for i := range cart {
+		quote.ItemTaxes[i] = float64(byReference[taxLineReference(i)]) / 100
+	}
+	quote.CalculationID = calculation.ID
+	if calculation.ExpiresAt > 0 {
+		quote.ExpiresAt = time.Unix(calculation.ExpiresAt, 0)
+	}
+	return quote, nil
+}
+
+// taxLineReference identifies a cart item in a tax calculation
+func taxLineReference(index int) string {
+	return fmt.Sprintf("item-%d", index)
+}
+
+// ApplySessionTax takes the tax Stripe Tax charged on a payment link's checkout session as the
+// cart's tax and returns the summary with it. The session's line items are in the order the
+// link was created with, so the cart's items come first.
+func ApplySessionTax(client StripeClient, sessionID string, cart []templates.Product, summary templates.CartSummary) (templates.CartSummary, error) {
+	lines, err := client.ListCheckoutSessionLineItems(sessionID)
+	if err != nil {
+		return summary, fmt.Errorf("error reading checkout session %s line items: %w", sessionID, err)
+	}
+	if len(lines) < len(cart) {
+		return summary, fmt.Errorf("checkout session %s has %d line items for %d cart items", sessionID, len(lines), len(cart))
+	}
+
+	quote := &StripeTaxQuote{CartHash: CartHash(cart), ItemTaxes: make([]float64, len(cart))}
+	for i := range cart {
+		quote.ItemTaxes[i] = float64(lines[i].AmountTax) / 100
+	}
+	setStripeTaxQuote(quote)
+
+	summary.Tax = quote.Tax()
+	summary.Total = roundCents(summary.Subtotal + summary.Tax + summary.ServiceFee)
+	return summary, nil
+}
+
+// RecordStripeTax commits the Stripe Tax calculation of a paid cart as a tax transaction, so the
+// sale shows in Stripe Tax reports. Carts taxed with local rates, or by a payment link's
+// checkout (which Stripe records itself), have nothing to commit.
+func RecordStripeTax(client StripeClient, paymentID string, cart []templates.Product) {
+	quote, ok := stripeTaxQuoteFor(cart)
+	if !ok || quote.CalculationID == "" {
+		return
+	}
+
+	transaction, err := client.CreateTaxTransaction(&stripe.TaxTransactionCreateFromCalculationParams{
+		Calculation: stripe.String(quote.CalculationID),
+		Reference:   stripe.String(paymentID),
+	})
+	if err != nil {
+		utils.Error("tax", "Error recording Stripe Tax transaction", "payment_id", paymentID, "calculation_id", quote.CalculationID, "error", err)
+		return
+	}
+	utils.Info("tax", "Stripe Tax transaction recorded", "payment_id", paymentID, "tax_transaction_id", transaction.ID)
+
+	// A calculation can only be committed once
+	setStripeTaxQuote(nil)
+}
+
+// TaxComparison is one item's tax under the local rates and under Stripe Tax
+type TaxComparison struct {
+	Name      string
+	Price     float64
+	LocalTax  float64
+	StripeTax float64
+}
+
+// Mismatch reports whether the two taxes differ by a cent or more
+func (c TaxComparison) Mismatch() bool {
+	return math.Abs(c.LocalTax-c.StripeTax) >= 0.005
+}
+
+// CompareStripeTax works out the tax on items with the local rates and with Stripe Tax at a
+// location, without charging or recording anything, so the two can be checked before a
+// location switches to Stripe Tax
+func CompareStripeTax(client StripeClient, items []templates.Product, location templates.StripeLocation) ([]TaxComparison, error) {
+	var sold []templates.Product
+	for _, item := range items {
+		if item.ReturnOf == "" {
+			sold = append(sold, item)
+		}
+	}
+	if len(sold) == 0 {
+		return nil, errors.New("there are no sold items to compare")
+	}
+
+	quote, err := CalculateStripeTax(client, sold, location)
+	if err != nil {
+		return nil, err
+	}
+	_, localTaxes := SummarizeCart(sold, config.Config.DefaultTaxRate, config.Config.TaxCategories)
+
+	comparisons := make([]TaxComparison, len(sold))
+	for i, item := range sold {
+		comparisons[i] = TaxComparison{
+			Name:      item.Name,
+			Price:     item.Price,
+			LocalTax:  roundCents(localTaxes[i]),
+			StripeTax: quote.ItemTaxes[i],
+		}
+	}
+	return comparisons, nil
+}
@@ -0,0 +1,184 @@
+package services
+
+import (
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stripe/stripe-go/v74"
+	"github.com/stripe/stripe-go/v74/form"
+
+	"checkout/templates"
+)
+
+// randomCart returns up to a dozen lines priced from nothing to a few hundred dollars, some in
+// tax categories, with a default tax rate of up to 15%
+func randomCart(rng *rand.Rand) ([]templates.Product, float64, []templates.TaxCategory) {
+	categories := []templates.TaxCategory{
+		{ID: "food", Name: "Food", TaxRate: rng.Float64() * 0.05},
+		{ID: "local", Name: "Local", Components: []templates.TaxComponent{
+			{Name: "State", TaxRate: rng.Float64() * 0.08},
+			{Name: "County", TaxRate: rng.Float64() * 0.03},
+		}},
+	}
+	cart := make([]templates.Product, 1+rng.IntN(12))
+	for i := range cart {
+		var cents int
+		switch rng.IntN(4) {
+		case 0:
+			cents = rng.IntN(10) // Pennies, where rounding the tax matters most
+		case 1:
+			cents = rng.IntN(1000)
+		default:
+			cents = rng.IntN(30000)
+		}
+		cart[i] = templates.Product{Name: fmt.Sprintf("Item %d", i), Price: float64(cents) / 100}
+		switch rng.IntN(3) {
+		case 1:
+			cart[i].TaxCategory = "food"
+		case 2:
+			cart[i].TaxCategory = "local"
+		}
+	}
+	return cart, rng.Float64() * 0.15, categories
+}
+
+func TestPaymentLinkLineCentsAddUpToTheCharge(t *testing.T) {
+	const seed = 2141
+	rng := rand.New(rand.NewPCG(seed, seed))
+
+	for run := range 5000 {
+		cart, defaultRate, categories := randomCart(rng)
+		summary, itemTaxes := SummarizeCart(cart, defaultRate, categories)
+		total := int64(math.Round(summary.Total * 100))
+
+		// Any charge the lines can be brought to without going below zero works too, such as
+		// one a cent or two off after the service fee is taken out separately
+		for _, charge := range []int64{total, max(0, total+rng.Int64N(7)-3), rng.Int64N(total + 1)} {
+			lines, err := paymentLinkLineCents(cart, itemTaxes, charge)
+			if err != nil {
+				t.Fatalf("run %d: %v for cart %v with taxes %v", run, err, cart, itemTaxes)
+			}
+			var sum int64
+			for i, cents := range lines {
+				if cents < 0 {
+					t.Errorf("run %d: line %d is %d cents", run, i, cents)
+				}
+				sum += cents
+			}
+			if sum != charge {
+				t.Fatalf("run %d: lines %v add up to %d cents, charge is %d", run, lines, sum, charge)
+			}
+		}
+	}
+}
+
+func TestPaymentLinkLineCentsRejectsImpossibleTotals(t *testing.T) {
+	cart := []templates.Product{{Name: "Coffee", Price: 4.50}, {Name: "Bagel", Price: 3.25}}
+	tests := []struct {
+		name      string
+		itemTaxes []float64
+		total     int64
+	}{
+		{"negative total", []float64{0.28, 0.20}, -1},
+		{"missing line tax", []float64{0.28}, 803},
+		{"empty cart", nil, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			items := cart
+			if tt.itemTaxes == nil {
+				items = nil
+			}
+			if lines, err := paymentLinkLineCents(items, tt.itemTaxes, tt.total); err == nil {
+				t.Errorf("got lines %v, want an error", lines)
+			}
+		})
+	}
+}
+
+// linkClient answers for one payment link and its sessions, keeping the last list query
+type linkClient struct {
+	StripeClient
+	link     stripe.PaymentLink
+	sessions []*stripe.CheckoutSession
+	query    url.Values
+}
+
+func (c *linkClient) GetPaymentLink(paymentLinkID string) (*stripe.PaymentLink, error) {
+	return &c.link, nil
+}
+
+func (c *linkClient) ListCheckoutSessions(params *stripe.CheckoutSessionListParams) ([]*stripe.CheckoutSession, error) {
+	values := &form.Values{}
+	form.AppendTo(values, params)
+	c.query = values.ToValues()
+	return c.sessions, nil
+}
+
+func TestCheckPaymentLinkStatusListsRecentCompletedSessions(t *testing.T) {
+	created := time.Unix(1767345600, 0)
+	tests := []struct {
+		name        string
+		createdAt   time.Time
+		wantCreated string
+	}{
+		{"link created at a known time", created, strconv.FormatInt(created.Add(-paymentLinkClockSkew).Unix(), 10)},
+		{"creation time unknown", time.Time{}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &linkClient{link: stripe.PaymentLink{ID: "plink_1", Active: true}}
+
+			if _, err := CheckPaymentLinkStatus(client, "plink_1", tt.createdAt); err != nil {
+				t.Fatal(err)
+			}
+
+			want := map[string]string{
+				"payment_link": "plink_1",
+				"status":       "complete",
+				"limit":        strconv.Itoa(paymentLinkSessionLimit),
+				"created[gte]": tt.wantCreated,
+			}
+			for key, value := range want {
+				if got := client.query.Get(key); got != value {
+					t.Errorf("%s = %q, want %q in %v", key, got, value, client.query)
+				}
+			}
+		})
+	}
+}
+
+// Stripe deactivates a single-use link once it is paid, so an inactive link may still be completed
+func TestCheckPaymentLinkStatusFindsFirstPayment(t *testing.T) {
+	useTempDataDir(t)
+	session := func(id string, created int64, email string) *stripe.CheckoutSession {
+		return &stripe.CheckoutSession{
+			ID:              id,
+			Status:          stripe.CheckoutSessionStatusComplete,
+			Created:         created,
+			AmountTotal:     478,
+			CustomerDetails: &stripe.CheckoutSessionCustomerDetails{Email: email},
+		}
+	}
+	client := &linkClient{
+		link:     stripe.PaymentLink{ID: "plink_1", Active: false},
+		sessions: []*stripe.CheckoutSession{session("cs_second", 200, "second@example.com"), session("cs_first", 100, "first@example.com")},
+	}
+
+	status, err := CheckPaymentLinkStatus(client, "plink_1", time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !status.Completed || status.SessionID != "cs_first" || status.CustomerEmail != "first@example.com" {
+		t.Errorf("status = %+v, want completed by cs_first", status)
+	}
+	if len(status.Duplicates) != 1 || status.Duplicates[0].SessionID != "cs_second" {
+		t.Errorf("duplicates = %+v, want cs_second", status.Duplicates)
+	}
+}
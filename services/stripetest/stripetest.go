@@ -0,0 +1,683 @@
+// Package stripetest provides an in-memory services.StripeClient for tests. Nothing happens on
+// its own: a test moves a payment along with SetIntentStatus, CompleteLink and the like, and
+// checks which calls were made with Calls.
+package stripetest
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/stripe/stripe-go/v74"
+
+	"checkout/services"
+)
+
+// DeclinePaymentMethod is the payment method ConfirmPaymentIntent declines; any other is approved
+const DeclinePaymentMethod = "pm_card_chargeDeclined"
+
+// ReaderID and LocationID name the reader every Client starts with
+const (
+	ReaderID   = "tmr_test_reader"
+	LocationID = "tml_test_location"
+)
+
+// Client answers Stripe calls from memory. Calls it doesn't implement panic, through the nil
+// embedded interface, so a test notices a Stripe call it didn't expect.
+type Client struct {
+	services.StripeClient
+
+	mutex    sync.Mutex
+	nextID   int
+	calls    map[string]int
+	failures map[string][]error
+	intents  map[string]*stripe.PaymentIntent
+	readers  map[string]*stripe.TerminalReader
+	links    map[string]*stripe.PaymentLink
+	sessions []*stripe.CheckoutSession
+	prices   map[string]*stripe.Price
+	refunds  map[string]*stripe.Refund
+}
+
+// New returns a Client with one online reader and no payments
+func New() *Client {
+	return &Client{
+		calls:    make(map[string]int),
+		failures: make(map[string][]error),
+		intents:  make(map[string]*stripe.PaymentIntent),
+		readers: map[string]*stripe.TerminalReader{
+			ReaderID: {ID: ReaderID, Label: "Test Reader", Status: "online", Location: &stripe.TerminalLocation{ID: LocationID}},
+		},
+		links:   make(map[string]*stripe.PaymentLink),
+		prices:  make(map[string]*stripe.Price),
+		refunds: make(map[string]*stripe.Refund),
+	}
+}
+
+// Calls returns how many times a method was called, including calls that failed
+func (c *Client) Calls(method string) int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.calls[method]
+}
+
+// FailNext makes the next call of a method return err; errors queue up in order
+func (c *Client) FailNext(method string, err error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.failures[method] = append(c.failures[method], err)
+}
+
+// Intent returns a copy of a PaymentIntent, or nil if there is none with that ID
+func (c *Client) Intent(intentID string) *stripe.PaymentIntent {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	intent, ok := c.intents[intentID]
+	if !ok {
+		return nil
+	}
+	copied := *intent
+	return &copied
+}
+
+// SetIntentStatus moves a PaymentIntent to a status, as a customer tapping a card would. A
+// succeeded intent gets a charge, and the reader processing it finishes its action.
+func (c *Client) SetIntentStatus(intentID string, status stripe.PaymentIntentStatus) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	intent, ok := c.intents[intentID]
+	if !ok {
+		panic("stripetest: no payment intent " + intentID)
+	}
+	intent.Status = status
+	if status == stripe.PaymentIntentStatusSucceeded {
+		c.succeed(intent)
+	}
+	for _, reader := range c.readers {
+		if reader.Action != nil && reader.Action.ProcessPaymentIntent.PaymentIntent.ID == intentID {
+			switch status {
+			case stripe.PaymentIntentStatusSucceeded:
+				reader.Action.Status = stripe.TerminalReaderActionStatusSucceeded
+			case stripe.PaymentIntentStatusCanceled:
+				reader.Action = nil
+			}
+		}
+	}
+}
+
+// DeclineOnReader fails the reader action of a PaymentIntent with a card decline
+func (c *Client) DeclineOnReader(intentID string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	intent, ok := c.intents[intentID]
+	if !ok {
+		panic("stripetest: no payment intent " + intentID)
+	}
+	intent.LastPaymentError = declineError()
+	for _, reader := range c.readers {
+		if reader.Action != nil && reader.Action.ProcessPaymentIntent.PaymentIntent.ID == intentID {
+			reader.Action.Status = stripe.TerminalReaderActionStatusFailed
+			reader.Action.FailureCode = string(stripe.ErrorCodeCardDeclined)
+			reader.Action.FailureMessage = intent.LastPaymentError.Msg
+		}
+	}
+}
+
+// CompleteLink pays a payment link as a customer checking out would: it adds a completed
+// checkout session with a succeeded PaymentIntent and deactivates the single-use link
+func (c *Client) CompleteLink(paymentLinkID, email string) *stripe.CheckoutSession {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	link, ok := c.links[paymentLinkID]
+	if !ok {
+		panic("stripetest: no payment link " + paymentLinkID)
+	}
+
+	var amount int64
+	for _, item := range link.LineItems.Data {
+		amount += item.AmountTotal
+	}
+	intent := &stripe.PaymentIntent{
+		ID:       c.newID("pi"),
+		Object:   "payment_intent",
+		Created:  time.Now().Unix(),
+		Amount:   amount,
+		Currency: stripe.CurrencyUSD,
+		Metadata: link.Metadata,
+	}
+	c.succeed(intent)
+	c.intents[intent.ID] = intent
+
+	session := &stripe.CheckoutSession{
+		ID:              c.newID("cs"),
+		Object:          "checkout.session",
+		Created:         time.Now().Unix(),
+		Status:          stripe.CheckoutSessionStatusComplete,
+		PaymentStatus:   stripe.CheckoutSessionPaymentStatusPaid,
+		AmountTotal:     amount,
+		PaymentLink:     &stripe.PaymentLink{ID: paymentLinkID},
+		PaymentIntent:   &stripe.PaymentIntent{ID: intent.ID},
+		Metadata:        link.Metadata,
+		CustomerDetails: &stripe.CheckoutSessionCustomerDetails{Email: email},
+	}
+	c.sessions = append(c.sessions, session)
+	link.Active = false
+	copied := *session
+	return &copied
+}
+
+// LinkAmount returns what a payment link charges in cents, the sum of its prices
+func (c *Client) LinkAmount(paymentLinkID string) int64 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	var amount int64
+	for _, item := range c.links[paymentLinkID].LineItems.Data {
+		amount += item.AmountTotal
+	}
+	return amount
+}
+
+// LinkPrices returns the IDs of the prices on a payment link's lines, in order
+func (c *Client) LinkPrices(paymentLinkID string) []string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	var priceIDs []string
+	for _, item := range c.links[paymentLinkID].LineItems.Data {
+		priceIDs = append(priceIDs, item.Price.ID)
+	}
+	return priceIDs
+}
+
+// call counts a call and returns the error queued for it. Callers hold the mutex.
+func (c *Client) call(method string) error {
+	c.calls[method]++
+	if queued := c.failures[method]; len(queued) > 0 {
+		c.failures[method] = queued[1:]
+		return queued[0]
+	}
+	return nil
+}
+
+// newID returns an ID with a Stripe prefix. Callers hold the mutex.
+func (c *Client) newID(prefix string) string {
+	c.nextID++
+	return fmt.Sprintf("%s_test_%d", prefix, c.nextID)
+}
+
+// succeed gives a PaymentIntent the charge of a successful payment. Callers hold the mutex.
+func (c *Client) succeed(intent *stripe.PaymentIntent) {
+	intent.Status = stripe.PaymentIntentStatusSucceeded
+	intent.AmountReceived = intent.Amount
+	intent.LastPaymentError = nil
+	if intent.LatestCharge == nil {
+		intent.LatestCharge = &stripe.Charge{ID: c.newID("ch")}
+	}
+}
+
+func notFound(object, id string) error {
+	return &stripe.Error{Type: stripe.ErrorTypeInvalidRequest, Code: stripe.ErrorCodeResourceMissing,
+		HTTPStatusCode: http.StatusNotFound, Msg: fmt.Sprintf("No such %s: '%s'", object, id)}
+}
+
+func declineError() *stripe.Error {
+	return &stripe.Error{Type: stripe.ErrorTypeCard, Code: stripe.ErrorCodeCardDeclined,
+		HTTPStatusCode: http.StatusPaymentRequired, Msg: "Your card was declined."}
+}
+
+func (c *Client) CreatePaymentIntent(params *stripe.PaymentIntentParams) (*stripe.PaymentIntent, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if err := c.call("CreatePaymentIntent"); err != nil {
+		return nil, err
+	}
+
+	intent := &stripe.PaymentIntent{
+		ID:           c.newID("pi"),
+		Object:       "payment_intent",
+		Created:      time.Now().Unix(),
+		Amount:       stripe.Int64Value(params.Amount),
+		Currency:     stripe.CurrencyUSD,
+		Description:  stripe.StringValue(params.Description),
+		ReceiptEmail: stripe.StringValue(params.ReceiptEmail),
+		Status:       stripe.PaymentIntentStatusRequiresPaymentMethod,
+		Metadata:     map[string]string{},
+	}
+	for key, value := range params.Metadata {
+		intent.Metadata[key] = value
+	}
+	c.intents[intent.ID] = intent
+	copied := *intent
+	return &copied, nil
+}
+
+func (c *Client) ConfirmPaymentIntent(intentID string, params *stripe.PaymentIntentConfirmParams) (*stripe.PaymentIntent, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if err := c.call("ConfirmPaymentIntent"); err != nil {
+		return nil, err
+	}
+
+	intent, ok := c.intents[intentID]
+	if !ok {
+		return nil, notFound("payment_intent", intentID)
+	}
+	if stripe.StringValue(params.PaymentMethod) == DeclinePaymentMethod {
+		intent.LastPaymentError = declineError()
+		return nil, declineError()
+	}
+	c.succeed(intent)
+	copied := *intent
+	return &copied, nil
+}
+
+func (c *Client) GetPaymentIntent(intentID string) (*stripe.PaymentIntent, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if err := c.call("GetPaymentIntent"); err != nil {
+		return nil, err
+	}
+
+	intent, ok := c.intents[intentID]
+	if !ok {
+		return nil, notFound("payment_intent", intentID)
+	}
+	copied := *intent
+	return &copied, nil
+}
+
+// ListPaymentIntents lists the PaymentIntents created in the params' range, newest first like Stripe
+func (c *Client) ListPaymentIntents(params *stripe.PaymentIntentListParams) ([]*stripe.PaymentIntent, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if err := c.call("ListPaymentIntents"); err != nil {
+		return nil, err
+	}
+
+	var intents []*stripe.PaymentIntent
+	for _, intent := range c.intents {
+		if created := params.CreatedRange; created != nil {
+			if intent.Created < created.GreaterThanOrEqual || (created.LesserThan != 0 && intent.Created >= created.LesserThan) {
+				continue
+			}
+		}
+		copied := *intent
+		intents = append(intents, &copied)
+	}
+	sort.Slice(intents, func(i, j int) bool { return intents[i].Created > intents[j].Created })
+	return intents, nil
+}
+
+func (c *Client) UpdatePaymentIntent(intentID string, params *stripe.PaymentIntentParams) (*stripe.PaymentIntent, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if err := c.call("UpdatePaymentIntent"); err != nil {
+		return nil, err
+	}
+
+	intent, ok := c.intents[intentID]
+	if !ok {
+		return nil, notFound("payment_intent", intentID)
+	}
+	if params.Amount != nil {
+		intent.Amount = *params.Amount
+	}
+	if params.ReceiptEmail != nil {
+		intent.ReceiptEmail = *params.ReceiptEmail
+	}
+	for key, value := range params.Metadata {
+		intent.Metadata[key] = value
+	}
+	copied := *intent
+	return &copied, nil
+}
+
+// CancelPaymentIntent refuses, as Stripe does, to cancel a payment that succeeded
+func (c *Client) CancelPaymentIntent(intentID string) (*stripe.PaymentIntent, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if err := c.call("CancelPaymentIntent"); err != nil {
+		return nil, err
+	}
+
+	intent, ok := c.intents[intentID]
+	if !ok {
+		return nil, notFound("payment_intent", intentID)
+	}
+	if intent.Status == stripe.PaymentIntentStatusSucceeded || intent.Status == stripe.PaymentIntentStatusCanceled {
+		return nil, &stripe.Error{Type: stripe.ErrorTypeInvalidRequest, Code: stripe.ErrorCodePaymentIntentUnexpectedState,
+			HTTPStatusCode: http.StatusBadRequest, Msg: fmt.Sprintf("You cannot cancel this PaymentIntent because it has a status of %s.", intent.Status)}
+	}
+	intent.Status = stripe.PaymentIntentStatusCanceled
+	copied := *intent
+	return &copied, nil
+}
+
+func (c *Client) CreateRefund(params *stripe.RefundParams) (*stripe.Refund, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if err := c.call("CreateRefund"); err != nil {
+		return nil, err
+	}
+
+	intentID := stripe.StringValue(params.PaymentIntent)
+	intent, ok := c.intents[intentID]
+	if !ok {
+		return nil, notFound("payment_intent", intentID)
+	}
+	amount := intent.AmountReceived
+	if params.Amount != nil {
+		amount = *params.Amount
+	}
+	refund := &stripe.Refund{
+		ID:            c.newID("re"),
+		Amount:        amount,
+		Status:        stripe.RefundStatusSucceeded,
+		PaymentIntent: &stripe.PaymentIntent{ID: intentID},
+	}
+	c.refunds[refund.ID] = refund
+	copied := *refund
+	return &copied, nil
+}
+
+// GetCharge returns a Visa card charge for any charge of a succeeded PaymentIntent
+func (c *Client) GetCharge(chargeID string) (*stripe.Charge, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if err := c.call("GetCharge"); err != nil {
+		return nil, err
+	}
+
+	for _, intent := range c.intents {
+		if intent.LatestCharge != nil && intent.LatestCharge.ID == chargeID {
+			return &stripe.Charge{
+				ID:            chargeID,
+				Amount:        intent.AmountReceived,
+				Paid:          true,
+				Status:        stripe.ChargeStatusSucceeded,
+				PaymentIntent: &stripe.PaymentIntent{ID: intent.ID},
+				PaymentMethodDetails: &stripe.ChargePaymentMethodDetails{
+					Card: &stripe.ChargePaymentMethodDetailsCard{Brand: stripe.PaymentMethodCardBrandVisa, Last4: "4242"},
+				},
+			}, nil
+		}
+	}
+	return nil, notFound("charge", chargeID)
+}
+
+func (c *Client) ProcessReaderPayment(readerID string, params *stripe.TerminalReaderProcessPaymentIntentParams) (*stripe.TerminalReader, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if err := c.call("ProcessReaderPayment"); err != nil {
+		return nil, err
+	}
+
+	reader, ok := c.readers[readerID]
+	if !ok {
+		return nil, notFound("terminal.reader", readerID)
+	}
+	intentID := stripe.StringValue(params.PaymentIntent)
+	if _, ok := c.intents[intentID]; !ok {
+		return nil, notFound("payment_intent", intentID)
+	}
+	reader.Action = &stripe.TerminalReaderAction{
+		Type:                 stripe.TerminalReaderActionTypeProcessPaymentIntent,
+		Status:               stripe.TerminalReaderActionStatusInProgress,
+		ProcessPaymentIntent: &stripe.TerminalReaderActionProcessPaymentIntent{PaymentIntent: &stripe.PaymentIntent{ID: intentID}},
+	}
+	copied := *reader
+	return &copied, nil
+}
+
+func (c *Client) GetReader(readerID string) (*stripe.TerminalReader, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if err := c.call("GetReader"); err != nil {
+		return nil, err
+	}
+
+	reader, ok := c.readers[readerID]
+	if !ok {
+		return nil, notFound("terminal.reader", readerID)
+	}
+	copied := *reader
+	return &copied, nil
+}
+
+func (c *Client) CancelReaderAction(readerID string) (*stripe.TerminalReader, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if err := c.call("CancelReaderAction"); err != nil {
+		return nil, err
+	}
+
+	reader, ok := c.readers[readerID]
+	if !ok {
+		return nil, notFound("terminal.reader", readerID)
+	}
+	reader.Action = nil
+	copied := *reader
+	return &copied, nil
+}
+
+func (c *Client) SetReaderDisplay(readerID string, _ *stripe.TerminalReaderSetReaderDisplayParams) (*stripe.TerminalReader, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if err := c.call("SetReaderDisplay"); err != nil {
+		return nil, err
+	}
+
+	reader, ok := c.readers[readerID]
+	if !ok {
+		return nil, notFound("terminal.reader", readerID)
+	}
+	copied := *reader
+	return &copied, nil
+}
+
+func (c *Client) ListReaders(_ *stripe.TerminalReaderListParams) ([]*stripe.TerminalReader, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if err := c.call("ListReaders"); err != nil {
+		return nil, err
+	}
+
+	var readers []*stripe.TerminalReader
+	for _, reader := range c.readers {
+		copied := *reader
+		readers = append(readers, &copied)
+	}
+	return readers, nil
+}
+
+// CreatePaymentLink makes an active link whose line items carry the amounts of their prices
+func (c *Client) CreatePaymentLink(params *stripe.PaymentLinkParams) (*stripe.PaymentLink, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if err := c.call("CreatePaymentLink"); err != nil {
+		return nil, err
+	}
+
+	link := &stripe.PaymentLink{
+		ID:        c.newID("plink"),
+		Object:    "payment_link",
+		Active:    true,
+		Currency:  stripe.CurrencyUSD,
+		Metadata:  map[string]string{},
+		LineItems: &stripe.LineItemList{},
+	}
+	link.URL = "https://buy.stripe.test/" + link.ID
+	for key, value := range params.Metadata {
+		link.Metadata[key] = value
+	}
+	link.AutomaticTax = &stripe.PaymentLinkAutomaticTax{Enabled: params.AutomaticTax != nil && stripe.BoolValue(params.AutomaticTax.Enabled)}
+	for _, item := range params.LineItems {
+		priceID := stripe.StringValue(item.Price)
+		price, ok := c.prices[priceID]
+		if !ok {
+			return nil, notFound("price", priceID)
+		}
+		amount := price.UnitAmount * stripe.Int64Value(item.Quantity)
+		link.LineItems.Data = append(link.LineItems.Data, &stripe.LineItem{
+			Price:       &stripe.Price{ID: priceID},
+			Quantity:    stripe.Int64Value(item.Quantity),
+			AmountTotal: amount,
+		})
+	}
+	c.links[link.ID] = link
+	copied := *link
+	return &copied, nil
+}
+
+func (c *Client) GetPaymentLink(paymentLinkID string) (*stripe.PaymentLink, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if err := c.call("GetPaymentLink"); err != nil {
+		return nil, err
+	}
+
+	link, ok := c.links[paymentLinkID]
+	if !ok {
+		return nil, notFound("payment_link", paymentLinkID)
+	}
+	copied := *link
+	return &copied, nil
+}
+
+func (c *Client) DeactivatePaymentLink(paymentLinkID string) (*stripe.PaymentLink, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if err := c.call("DeactivatePaymentLink"); err != nil {
+		return nil, err
+	}
+
+	link, ok := c.links[paymentLinkID]
+	if !ok {
+		return nil, notFound("payment_link", paymentLinkID)
+	}
+	link.Active = false
+	copied := *link
+	return &copied, nil
+}
+
+// ListCheckoutSessions lists the sessions of the params' payment link, newest first like Stripe
+func (c *Client) ListCheckoutSessions(params *stripe.CheckoutSessionListParams) ([]*stripe.CheckoutSession, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if err := c.call("ListCheckoutSessions"); err != nil {
+		return nil, err
+	}
+
+	var sessions []*stripe.CheckoutSession
+	for i := len(c.sessions) - 1; i >= 0; i-- {
+		session := c.sessions[i]
+		if params.PaymentLink != nil && session.PaymentLink.ID != *params.PaymentLink {
+			continue
+		}
+		copied := *session
+		sessions = append(sessions, &copied)
+	}
+	return sessions, nil
+}
+
+func (c *Client) CreatePrice(params *stripe.PriceParams) (*stripe.Price, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if err := c.call("CreatePrice"); err != nil {
+		return nil, err
+	}
+
+	price := &stripe.Price{
+		ID:          c.newID("price"),
+		Object:      "price",
+		Active:      true,
+		Created:     time.Now().Unix(),
+		Currency:    stripe.CurrencyUSD,
+		UnitAmount:  stripe.Int64Value(params.UnitAmount),
+		Nickname:    stripe.StringValue(params.Nickname),
+		TaxBehavior: stripe.PriceTaxBehavior(stripe.StringValue(params.TaxBehavior)),
+		Metadata:    map[string]string{},
+	}
+	for key, value := range params.Metadata {
+		price.Metadata[key] = value
+	}
+	c.prices[price.ID] = price
+	copied := *price
+	return &copied, nil
+}
+
+func (c *Client) GetPrice(priceID string) (*stripe.Price, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if err := c.call("GetPrice"); err != nil {
+		return nil, err
+	}
+
+	price, ok := c.prices[priceID]
+	if !ok {
+		return nil, notFound("price", priceID)
+	}
+	copied := *price
+	return &copied, nil
+}
+
+func (c *Client) UpdatePrice(priceID string, params *stripe.PriceParams) (*stripe.Price, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if err := c.call("UpdatePrice"); err != nil {
+		return nil, err
+	}
+
+	price, ok := c.prices[priceID]
+	if !ok {
+		return nil, notFound("price", priceID)
+	}
+	if params.Active != nil {
+		price.Active = *params.Active
+	}
+	copied := *price
+	return &copied, nil
+}
+
+// ListPrices lists prices oldest first, filtered by the params' active flag and creation range
+func (c *Client) ListPrices(params *stripe.PriceListParams) ([]*stripe.Price, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if err := c.call("ListPrices"); err != nil {
+		return nil, err
+	}
+
+	var prices []*stripe.Price
+	for _, price := range c.prices {
+		if params.Active != nil && price.Active != *params.Active {
+			continue
+		}
+		if created := params.CreatedRange; created != nil && created.LesserThan != 0 && price.Created >= created.LesserThan {
+			continue
+		}
+		copied := *price
+		prices = append(prices, &copied)
+	}
+	sort.Slice(prices, func(i, j int) bool { return prices[i].ID < prices[j].ID })
+	return prices, nil
+}
+
+// AddPrice stores a price made outside the POS, such as one left over from before a change
+func (c *Client) AddPrice(price stripe.Price) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.prices[price.ID] = &price
+}
+
+// Price returns a copy of a price, or nil if there is none with that ID
+func (c *Client) Price(priceID string) *stripe.Price {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	price, ok := c.prices[priceID]
+	if !ok {
+		return nil
+	}
+	copied := *price
+	return &copied
+}
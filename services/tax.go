@@ -1,26 +1,48 @@
 package services
 
 import (
+	"sort"
+
 	"checkout/config"
 	"checkout/templates"
 )
 
-// Calculate cart summary using local tax rates
-func CalculateCartSummary() templates.CartSummary {
-	summary, _ := CalculateCartSummaryWithItemTaxes()
+// Calculate cart summary using local tax rates, or Stripe Tax once it has quoted the cart (see
+// QuoteStripeTax), with the service fee of the selected payment method.
+// During a split sale the fee is charged on each tender instead (see ChargeAmount).
+func CalculateCartSummary(cart *CartStore) templates.CartSummary {
+	summary, _ := CalculateCartSummaryWithItemTaxes(cart)
 	return summary
 }
 
 // CalculateCartSummaryWithItemTaxes calculates cart summary and returns per-item tax amounts
-func CalculateCartSummaryWithItemTaxes() (templates.CartSummary, []float64) {
+func CalculateCartSummaryWithItemTaxes(cart *CartStore) (templates.CartSummary, []float64) {
+	items := cart.Items()
+	summary, itemTaxes := SummarizeCart(items, config.Config.DefaultTaxRate, config.Config.TaxCategories)
+	if quote, ok := stripeTaxQuoteFor(items); ok {
+		itemTaxes = append([]float64(nil), quote.ItemTaxes...)
+		summary.Tax = quote.Tax()
+		summary.Total = summary.Subtotal + summary.Tax
+		summary.TaxBreakdown = nil // Stripe Tax's amounts aren't split by the local components
+	}
+	if cart.Split() == nil {
+		applyServiceFee(&summary, items, cart.PaymentMethod(), itemTaxes)
+	}
+	return summary, itemTaxes
+}
+
+// SummarizeCart totals a cart with the given tax rates and returns the tax of each item
+// (in cart order). The summary tax is the sum of the item taxes, and its breakdown the sum of
+// the item taxes of categories with components.
+func SummarizeCart(cart []templates.Product, defaultRate float64, categories []templates.TaxCategory) (templates.CartSummary, []float64) {
 	var subtotal float64
 	var itemTaxes []float64
 
-	for _, product := range AppState.CurrentCart {
+	for _, product := range cart {
 		subtotal += product.Price
 
 		// Calculate tax for this specific product
-		taxRate := GetTaxRateForService(product)
+		taxRate := TaxRateFor(product, defaultRate, categories)
 		tax := product.Price * taxRate
 		itemTaxes = append(itemTaxes, tax)
 	}
@@ -34,9 +56,10 @@ func CalculateCartSummaryWithItemTaxes() (templates.CartSummary, []float64) {
 	total := subtotal + totalTax
 
 	summary := templates.CartSummary{
-		Subtotal: subtotal,
-		Tax:      totalTax,
-		Total:    total,
+		Subtotal:     subtotal,
+		Tax:          totalTax,
+		Total:        total,
+		TaxBreakdown: TaxBreakdown(ItemTaxComponentsFor(cart, categories)),
 	}
 
 	return summary, itemTaxes
@@ -44,15 +67,120 @@ func CalculateCartSummaryWithItemTaxes() (templates.CartSummary, []float64) {
 
 // GetTaxRateForService returns the applicable tax rate for a service
 func GetTaxRateForService(service templates.Product) float64 {
-	// If service has a tax category, look up the category tax rate
-	if service.TaxCategory != "" {
-		for _, category := range config.Config.TaxCategories {
-			if category.ID == service.TaxCategory {
-				return category.TaxRate
+	return TaxRateFor(service, config.Config.DefaultTaxRate, config.Config.TaxCategories)
+}
+
+// TaxRateFor returns a product's tax rate: its tax category's rate if the category is
+// one of categories, otherwise defaultRate
+func TaxRateFor(product templates.Product, defaultRate float64, categories []templates.TaxCategory) float64 {
+	// Store credit is taxed when it is spent, not when it is sold
+	if product.GiftCard {
+		return 0
+	}
+
+	// If product has a tax category, look up the category tax rate
+	if product.TaxCategory != "" {
+		for _, category := range categories {
+			if category.ID == product.TaxCategory {
+				return category.Rate()
 			}
 		}
 	}
 
 	// Fall back to default tax rate
-	return config.Config.DefaultTaxRate
+	return defaultRate
+}
+
+// taxComponentColumnPrefix starts the transaction log column of each tax component, e.g. "Tax: State"
+const taxComponentColumnPrefix = "Tax: "
+
+// TaxComponentColumn returns the transaction log column of a tax component
+func TaxComponentColumn(name string) string {
+	return taxComponentColumnPrefix + name
+}
+
+// TaxComponentNames lists the components of the configured tax categories by name, in the order
+// they first appear. Categories sharing a component name (e.g. "State") are reported together.
+func TaxComponentNames() []string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, category := range config.Config.TaxCategories {
+		for _, component := range category.Components {
+			if component.Name != "" && !seen[component.Name] {
+				seen[component.Name] = true
+				names = append(names, component.Name)
+			}
+		}
+	}
+	return names
+}
+
+// ItemTaxComponents returns the tax of each item of a cart split by tax component, or nil when
+// Stripe Tax quoted the cart, since its amounts aren't split by the local components
+func ItemTaxComponents(cart []templates.Product) []map[string]float64 {
+	if _, ok := stripeTaxQuoteFor(cart); ok {
+		return nil
+	}
+	return ItemTaxComponentsFor(cart, config.Config.TaxCategories)
+}
+
+// ItemTaxComponentsFor returns the tax of each item of a cart (in cart order) per component of its
+// tax category. Items whose category has no components get an empty map; the result is nil when
+// no item has components, so single-rate setups record nothing extra.
+func ItemTaxComponentsFor(cart []templates.Product, categories []templates.TaxCategory) []map[string]float64 {
+	itemComponents := make([]map[string]float64, len(cart))
+	found := false
+	for i, product := range cart {
+		itemComponents[i] = make(map[string]float64)
+		if product.GiftCard || product.TaxCategory == "" {
+			continue
+		}
+		for _, category := range categories {
+			if category.ID != product.TaxCategory {
+				continue
+			}
+			for _, component := range category.Components {
+				if component.Name != "" {
+					itemComponents[i][component.Name] += product.Price * component.TaxRate
+					found = true
+				}
+			}
+			break
+		}
+	}
+	if !found {
+		return nil
+	}
+	return itemComponents
+}
+
+// TaxBreakdown totals per-item component taxes by component, configured components first in
+// their configured order, then any no longer configured (e.g. on a reprinted receipt) by name
+func TaxBreakdown(itemComponents []map[string]float64) []templates.TaxAmount {
+	totals := make(map[string]float64)
+	for _, components := range itemComponents {
+		for name, amount := range components {
+			totals[name] += amount
+		}
+	}
+	if len(totals) == 0 {
+		return nil
+	}
+
+	var breakdown []templates.TaxAmount
+	for _, name := range TaxComponentNames() {
+		if amount, ok := totals[name]; ok {
+			breakdown = append(breakdown, templates.TaxAmount{Name: name, Amount: amount})
+			delete(totals, name)
+		}
+	}
+	var others []string
+	for name := range totals {
+		others = append(others, name)
+	}
+	sort.Strings(others)
+	for _, name := range others {
+		breakdown = append(breakdown, templates.TaxAmount{Name: name, Amount: totals[name]})
+	}
+	return breakdown
 }
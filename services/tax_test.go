@@ -0,0 +1,97 @@
+package services
+
+import (
+	"math"
+	"testing"
+
+	"checkout/templates"
+)
+
+func TestTaxRateFor(t *testing.T) {
+	categories := []templates.TaxCategory{
+		{ID: "food", Name: "Food", TaxRate: 0.02},
+		{ID: "alcohol", Name: "Alcohol", TaxRate: 0.99, Components: []templates.TaxComponent{
+			{Name: "State", TaxRate: 0.0625},
+			{Name: "Liquor", TaxRate: 0.09},
+		}},
+	}
+	tests := []struct {
+		name    string
+		product templates.Product
+		want    float64
+	}{
+		{"no category takes the default", templates.Product{Name: "Coffee"}, 0.0625},
+		{"category rate", templates.Product{Name: "Bagel", TaxCategory: "food"}, 0.02},
+		{"components add up, ignoring the category's own rate", templates.Product{Name: "Wine", TaxCategory: "alcohol"}, 0.1525},
+		{"unknown category takes the default", templates.Product{Name: "Mug", TaxCategory: "merch"}, 0.0625},
+		{"category IDs are case sensitive", templates.Product{Name: "Soup", TaxCategory: "Food"}, 0.0625},
+		{"gift cards are taxed when spent", templates.Product{Name: "Gift Card", GiftCard: true, TaxCategory: "food"}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := TaxRateFor(tt.product, 0.0625, categories); math.Abs(got-tt.want) > 1e-12 {
+				t.Errorf("TaxRateFor(%s) = %v, want %v", tt.product.Name, got, tt.want)
+			}
+		})
+	}
+}
+
+// Item taxes are kept to the fraction of a cent and the sale's tax is rounded once, when it is
+// charged, so a cart of small items isn't overcharged by rounding each one up
+func TestSummarizeCartRounding(t *testing.T) {
+	categories := []templates.TaxCategory{
+		{ID: "food", Name: "Food", TaxRate: 0.02},
+		{ID: "local", Name: "Local", Components: []templates.TaxComponent{
+			{Name: "State", TaxRate: 0.05},
+			{Name: "County", TaxRate: 0.0125},
+		}},
+	}
+	coffee := templates.Product{Name: "Coffee", Price: 4.50}
+	dime := templates.Product{Name: "Candy", Price: 0.10}
+	tests := []struct {
+		name                                string
+		cart                                []templates.Product
+		subtotalCents, taxCents, totalCents int64
+		wantItemTaxes                       []float64
+	}{
+		{"empty cart", nil, 0, 0, 0, nil},
+		{"single item", []templates.Product{coffee}, 450, 28, 478, []float64{0.28125}},
+		{"tax rounds on the sum, not per item", []templates.Product{dime, dime, dime}, 30, 2, 32, []float64{0.00625, 0.00625, 0.00625}},
+		{"float subtotal rounds to the cent", []templates.Product{{Name: "A", Price: 0.1, TaxCategory: "food"}, {Name: "B", Price: 0.2, TaxCategory: "food"}}, 30, 1, 31, []float64{0.002, 0.004}},
+		{"mixed rates", []templates.Product{coffee, {Name: "Bagel", Price: 3.25, TaxCategory: "food"}}, 775, 35, 810, []float64{0.28125, 0.065}},
+		{"half cent rounds up", []templates.Product{{Name: "Ticket", Price: 10, TaxCategory: "local"}}, 1000, 63, 1063, []float64{0.625}},
+		{"gift card untaxed", []templates.Product{coffee, {Name: "Gift Card", Price: 25, GiftCard: true}}, 2950, 28, 2978, []float64{0.28125, 0}},
+	}
+	cents := func(amount float64) int64 { return int64(math.Round(amount * 100)) }
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			summary, itemTaxes := SummarizeCart(tt.cart, 0.0625, categories)
+
+			if got := cents(summary.Subtotal); got != tt.subtotalCents {
+				t.Errorf("subtotal = %d cents, want %d", got, tt.subtotalCents)
+			}
+			if got := cents(summary.Tax); got != tt.taxCents {
+				t.Errorf("tax = %d cents, want %d", got, tt.taxCents)
+			}
+			if got := cents(summary.Total); got != tt.totalCents {
+				t.Errorf("total = %d cents, want %d", got, tt.totalCents)
+			}
+			if len(itemTaxes) != len(tt.wantItemTaxes) {
+				t.Fatalf("item taxes = %v, want %v", itemTaxes, tt.wantItemTaxes)
+			}
+			var sum float64
+			for i, tax := range itemTaxes {
+				if math.Abs(tax-tt.wantItemTaxes[i]) > 1e-9 {
+					t.Errorf("item %d tax = %v, want %v", i, tax, tt.wantItemTaxes[i])
+				}
+				sum += tax
+			}
+			if math.Abs(sum-summary.Tax) > 1e-9 {
+				t.Errorf("item taxes add up to %v, summary tax is %v", sum, summary.Tax)
+			}
+			if math.Abs(summary.Subtotal+summary.Tax-summary.Total) > 1e-9 {
+				t.Errorf("total %v isn't subtotal %v plus tax %v", summary.Total, summary.Subtotal, summary.Tax)
+			}
+		})
+	}
+}
@@ -2,25 +2,47 @@ package services
 
 import (
 	"fmt"
-	"log"
 	"strings"
 
 	"github.com/stripe/stripe-go/v74"
-	"github.com/stripe/stripe-go/v74/terminal/location"
-	"github.com/stripe/stripe-go/v74/terminal/reader"
 
 	"checkout/config"
 	"checkout/templates"
 	"checkout/utils"
 )
 
+// TippingRules are the tipping settings that decide whether a sale is offered a tip
+type TippingRules struct {
+	Enabled           bool
+	LocationOverrides map[string]bool // Location ID -> tipping on/off, overriding Enabled
+	MinAmount         float64         // 0 = no minimum
+	MaxAmount         float64         // 0 = no maximum
+	CategoriesOnly    []string        // Tax categories that allow tipping; empty = any
+}
+
+// CurrentTippingRules returns the tipping rules from the configuration
+func CurrentTippingRules() TippingRules {
+	return TippingRules{
+		Enabled:           config.Config.TippingEnabled,
+		LocationOverrides: config.Config.TippingLocationOverrides,
+		MinAmount:         config.Config.TippingMinAmount,
+		MaxAmount:         config.Config.TippingMaxAmount,
+		CategoriesOnly:    config.Config.TippingProductCategoriesOnly,
+	}
+}
+
 // ShouldEnableTipping determines if tipping should be enabled for a given transaction
 // based on the global configuration, location overrides, transaction amount, and cart contents
 func ShouldEnableTipping(transactionAmount float64, cart []templates.Product, locationID string) bool {
+	return CurrentTippingRules().Allow(transactionAmount, cart, locationID)
+}
+
+// Allow reports whether the rules offer a tip on a sale of transactionAmount at a location
+func (rules TippingRules) Allow(transactionAmount float64, cart []templates.Product, locationID string) bool {
 	// Check if tipping is globally disabled
-	if !config.Config.TippingEnabled {
+	if !rules.Enabled {
 		// Check for location-specific override that enables tipping
-		if locationOverride, exists := config.Config.TippingLocationOverrides[locationID]; exists {
+		if locationOverride, exists := rules.LocationOverrides[locationID]; exists {
 			if !locationOverride {
 				return false // Location specifically disables tipping
 			}
@@ -30,27 +52,27 @@ func ShouldEnableTipping(transactionAmount float64, cart []templates.Product, lo
 		}
 	} else {
 		// Global tipping is enabled, check for location-specific override that disables it
-		if locationOverride, exists := config.Config.TippingLocationOverrides[locationID]; exists && !locationOverride {
+		if locationOverride, exists := rules.LocationOverrides[locationID]; exists && !locationOverride {
 			return false // Location specifically disables tipping
 		}
 	}
 
 	// Check minimum amount threshold
-	if config.Config.TippingMinAmount > 0 && transactionAmount < config.Config.TippingMinAmount {
+	if rules.MinAmount > 0 && transactionAmount < rules.MinAmount {
 		return false
 	}
 
 	// Check maximum amount threshold (0 means no maximum)
-	if config.Config.TippingMaxAmount > 0 && transactionAmount > config.Config.TippingMaxAmount {
+	if rules.MaxAmount > 0 && transactionAmount > rules.MaxAmount {
 		return false
 	}
 
 	// Check product category restrictions
-	if len(config.Config.TippingProductCategoriesOnly) > 0 {
+	if len(rules.CategoriesOnly) > 0 {
 		// Only enable tipping if at least one item in cart matches allowed categories
 		hasAllowedCategory := false
 		for _, product := range cart {
-			for _, allowedCategory := range config.Config.TippingProductCategoriesOnly {
+			for _, allowedCategory := range rules.CategoriesOnly {
 				if product.TaxCategory == allowedCategory {
 					hasAllowedCategory = true
 					break
@@ -68,74 +90,79 @@ func ShouldEnableTipping(transactionAmount float64, cart []templates.Product, lo
 	return true
 }
 
-// LoadStripeLocationsAndSelect fetches Stripe Terminal Locations and selects one based on config.
-// This function is expected to be called during application initialization.
-// It will log.Fatal if a configured location is not found, or if no location is configured
-// and zero or multiple locations exist.
-func LoadStripeLocationsAndSelect() {
-	utils.Debug("terminal", "Fetching Stripe Terminal Locations")
+// ListStripeLocations fetches the account's Stripe Terminal Locations
+func ListStripeLocations() ([]templates.StripeLocation, error) {
 	params := &stripe.TerminalLocationListParams{}
 	params.Filters.AddFilter("limit", "", "100") // Adjust limit as needed
 
+	locations, err := Stripe.ListLocations(params)
+	if err != nil {
+		return nil, fmt.Errorf("error listing Stripe Terminal Locations: %w", err)
+	}
+
 	var allLocations []templates.StripeLocation
-	i := location.List(params)
-	for i.Next() {
-		loc := i.TerminalLocation()
-		allLocations = append(allLocations, templates.StripeLocation{
-			ID:          loc.ID,
-			DisplayName: loc.DisplayName,
-			Livemode:    loc.Livemode,
-		})
+	for _, loc := range locations {
+		allLocations = append(allLocations, stripeLocation(loc))
 	}
-	if err := i.Err(); err != nil {
-		log.Fatalf("[Terminal] Error listing Stripe Terminal Locations: %v", err)
+	return allLocations, nil
+}
+
+// LoadStripeLocationsAndSelect fetches Stripe Terminal Locations and selects one based on config.
+// This function is expected to be called during application initialization.
+// It returns an error if the locations can't be listed, a configured location is not found,
+// or no locations exist; the app then asks the operator to fix it on the setup page.
+// If multiple locations exist and none is configured, none is selected and the POS asks the user to choose.
+func LoadStripeLocationsAndSelect() error {
+	utils.Debug("terminal", "Fetching Stripe Terminal Locations")
+	allLocations, err := ListStripeLocations()
+	if err != nil {
+		return err
 	}
 
-	AppState.AvailableStripeLocations = allLocations
+	Terminal.SetLocations(allLocations)
 	utils.Debug("terminal", "Found Stripe Terminal Locations", "count", len(allLocations))
 	for _, loc := range allLocations {
 		utils.Debug("terminal", "Available location", "name", loc.DisplayName, "id", loc.ID, "livemode", loc.Livemode)
 	}
 
+	// The configured location belongs to the real account; demo mode has its own
 	configuredLocationID := config.Config.StripeTerminalLocationID
+	if config.Config.DemoMode {
+		configuredLocationID = ""
+	}
 
 	if configuredLocationID != "" {
 		utils.Debug("terminal", "Using configured location ID", "id", configuredLocationID)
-		for _, loc := range AppState.AvailableStripeLocations {
+		for _, loc := range allLocations {
 			if loc.ID == configuredLocationID {
-				AppState.SelectedStripeLocation = loc
+				Terminal.SelectLocation(loc)
 				utils.Info("terminal", "Selected Stripe Terminal Location from config", "name", loc.DisplayName, "id", loc.ID)
-				return
+				return nil
 			}
 		}
 		// Configured location ID not found
 		var availableIDs []string
-		for _, loc := range AppState.AvailableStripeLocations {
+		for _, loc := range allLocations {
 			availableIDs = append(availableIDs, fmt.Sprintf("'%s' (%s)", loc.DisplayName, loc.ID))
 		}
-		log.Fatalf(
-			"[Terminal] Error: Configured StripeTerminalLocationID '%s' not found in your Stripe account. Available locations: [%s]. Please check your config.json.",
-			configuredLocationID,
-			strings.Join(availableIDs, ", "),
-		)
+		return fmt.Errorf("configured terminal location '%s' not found in your Stripe account; available locations: [%s]",
+			configuredLocationID, strings.Join(availableIDs, ", "))
+	}
+
+	// No StripeTerminalLocationID configured
+	utils.Debug("terminal", "No location ID configured in config.json")
+	if len(allLocations) == 0 {
+		return fmt.Errorf("no Stripe Terminal Locations found in your Stripe account; create one for this venue below")
+	} else if len(allLocations) == 1 {
+		Terminal.SelectLocation(allLocations[0])
+		utils.Info("terminal", "Auto-selected single available location", "name", allLocations[0].DisplayName, "id", allLocations[0].ID)
 	} else {
-		// No StripeTerminalLocationID configured
-		utils.Debug("terminal", "No location ID configured in config.json")
-		if len(AppState.AvailableStripeLocations) == 0 {
-			log.Fatal("[Terminal] Error: No Stripe Terminal Locations found in your Stripe account. Please create a Location in the Stripe Dashboard (Terminal > Locations) and then set its ID as 'stripeTerminalLocationID' in your config.json.")
-		} else if len(AppState.AvailableStripeLocations) == 1 {
-			AppState.SelectedStripeLocation = AppState.AvailableStripeLocations[0]
-			utils.Info("terminal", "Auto-selected single available location", "name", AppState.SelectedStripeLocation.DisplayName, "id", AppState.SelectedStripeLocation.ID)
-		} else {
-			// Multiple locations found, and none configured
-			var availableIDs []string
-			for _, loc := range AppState.AvailableStripeLocations {
-				availableIDs = append(availableIDs, fmt.Sprintf("'%s' (%s)", loc.DisplayName, loc.ID))
-			}
-			log.Fatalf("[Terminal] Error: Multiple Stripe Terminal Locations found and 'stripeTerminalLocationID' is not set in config.json. Please set 'stripeTerminalLocationID' to one of the following: [%s].",
-				strings.Join(availableIDs, ", "))
-		}
+		// Multiple locations found, and none configured - leave unselected until the user picks one
+		utils.Warn("terminal", "Multiple Stripe Terminal Locations found and none configured, waiting for selection",
+			"count", len(allLocations))
+		Terminal.SelectLocation(templates.StripeLocation{})
 	}
+	return nil
 }
 
 // LoadStripeReadersForLocation fetches Stripe Terminal Readers for the given Location ID.
@@ -145,17 +172,23 @@ func LoadStripeReadersForLocation(locationID string) {
 		utils.Debug("terminal", "No location selected, skipping reader loading")
 		return
 	}
-	utils.Debug("terminal", "Fetching readers for location", "name", AppState.SelectedStripeLocation.DisplayName, "id", locationID)
+	locationName := Terminal.SelectedLocation().DisplayName
+	utils.Debug("terminal", "Fetching readers for location", "name", locationName, "id", locationID)
 
 	params := &stripe.TerminalReaderListParams{}
 	params.Location = stripe.String(locationID)
 	params.Filters.AddFilter("limit", "", "100") // Adjust limit as needed
 
-	var readersForLocation []templates.StripeReader
-	i := reader.List(params)
-	for i.Next() {
-		r := i.TerminalReader()
+	readers, err := Stripe.ListReaders(params)
+	if err != nil {
+		// Log as an error but don't make it fatal, as per requirements.
+		utils.Error("terminal", "Error listing Stripe Terminal Readers", "location_id", locationID, "error", err)
+		Terminal.SetReaders(nil) // Ensure it's empty on error
+		return
+	}
 
+	var readersForLocation []templates.StripeReader
+	for _, r := range readers {
 		readersForLocation = append(readersForLocation, templates.StripeReader{
 			ID:              r.ID,
 			Label:           r.Label,
@@ -168,21 +201,121 @@ func LoadStripeReadersForLocation(locationID string) {
 			DeviceSwVersion: r.DeviceSwVersion,
 		})
 	}
-	if err := i.Err(); err != nil {
-		// Log as an error but don't make it fatal, as per requirements.
-		utils.Error("terminal", "Error listing Stripe Terminal Readers", "location_id", locationID, "error", err)
-		AppState.SiteStripeReaders = []templates.StripeReader{} // Ensure it's empty on error
-		return
-	}
 
-	AppState.SiteStripeReaders = readersForLocation
+	Terminal.SetReaders(readersForLocation)
+	restoreLastReader()
 
-	if len(AppState.SiteStripeReaders) == 0 {
-		utils.Warn("terminal", "No readers found for location", "name", AppState.SelectedStripeLocation.DisplayName, "id", locationID)
+	if len(readersForLocation) == 0 {
+		utils.Warn("terminal", "No readers found for location", "name", locationName, "id", locationID)
 	} else {
-		utils.Info("terminal", "Found readers for location", "count", len(AppState.SiteStripeReaders), "location", AppState.SelectedStripeLocation.DisplayName)
-		for _, r := range AppState.SiteStripeReaders {
+		utils.Info("terminal", "Found readers for location", "count", len(readersForLocation), "location", locationName)
+		for _, r := range readersForLocation {
 			utils.Debug("terminal", "Available reader", "label", r.Label, "id", r.ID, "status", r.Status, "device_type", r.DeviceType, "serial", r.SerialNumber, "ip", r.IPAddress, "sw_version", r.DeviceSwVersion)
 		}
 	}
 }
+
+// SelectStripeLocation switches the active Stripe Terminal Location at runtime.
+// Readers are reloaded for the new location, the reader selection is reset,
+// and the choice is saved to config.json so it is used on the next startup.
+func SelectStripeLocation(locationID string) (templates.StripeLocation, error) {
+	for _, loc := range Terminal.Locations() {
+		if loc.ID != locationID {
+			continue
+		}
+
+		Terminal.SelectLocation(loc)
+		Terminal.SelectReader("")
+		Terminal.SetReaders(nil)
+		LoadStripeReadersForLocation(loc.ID)
+		utils.Info("terminal", "Selected Stripe Terminal Location", "name", loc.DisplayName, "id", loc.ID)
+
+		if err := config.UpdateConfigField("StripeTerminalLocationID", loc.ID); err != nil {
+			return loc, fmt.Errorf("location selected but could not be saved: %w", err)
+		}
+		return loc, nil
+	}
+
+	return templates.StripeLocation{}, fmt.Errorf("location %s not found", locationID)
+}
+
+// collectInputsDeviceTypes are the reader models that can ask the customer for input on screen
+var collectInputsDeviceTypes = map[string]bool{
+	"bbpos_wisepos_e":     true,
+	"stripe_s700":         true,
+	"simulated_wisepos_e": true,
+}
+
+// ReaderSupportsCollectInputs reports whether a reader at the selected location can collect customer input
+func ReaderSupportsCollectInputs(readerID string) bool {
+	for _, reader := range Terminal.Readers() {
+		if reader.ID == readerID {
+			return collectInputsDeviceTypes[reader.DeviceType]
+		}
+	}
+	return false
+}
+
+// Results of asking the customer for a receipt email on the reader
+const (
+	ReaderEmailPending   = "pending"
+	ReaderEmailCollected = "collected"
+	ReaderEmailSkipped   = "skipped"
+	ReaderEmailFailed    = "failed"
+)
+
+// StartReaderEmailCollection asks the customer to enter a receipt email on the reader.
+// The customer can skip; the result is read with GetReaderEmailCollection or from the
+// terminal.reader.action_* webhook.
+func StartReaderEmailCollection(readerID string) error {
+	params := &stripe.Params{}
+	params.AddExtra("inputs[0][type]", "email")
+	params.AddExtra("inputs[0][required]", "false")
+	params.AddExtra("inputs[0][custom_text][title]", "Email receipt")
+	params.AddExtra("inputs[0][custom_text][description]", "Enter your email address to get a receipt")
+	params.AddExtra("inputs[0][custom_text][skip_button]", "No thanks")
+	params.AddExtra("inputs[0][custom_text][submit_button]", "Send")
+
+	if _, err := Stripe.CollectReaderInputs(readerID, params); err != nil {
+		return fmt.Errorf("error starting email collection on reader %s: %w", readerID, err)
+	}
+	utils.Info("terminal", "Collecting receipt email on reader", "reader_id", readerID)
+	return nil
+}
+
+// GetReaderEmailCollection returns the status of the reader's email collection and the
+// address the customer entered, if any
+func GetReaderEmailCollection(readerID string) (string, string, error) {
+	terminalReader, err := withStripeRetry("reader.Get", func() (*TerminalReaderInputs, error) {
+		return Stripe.GetReaderInputs(readerID)
+	})
+	if err != nil {
+		return ReaderEmailPending, "", err
+	}
+	status, email := ReaderEmailResult(terminalReader)
+	return status, email, nil
+}
+
+// ReaderEmailResult reads the outcome of a reader's collect_inputs action. A reader whose
+// action was cleared or replaced by another action counts as failed.
+func ReaderEmailResult(terminalReader *TerminalReaderInputs) (string, string) {
+	action := terminalReader.Action
+	if action == nil || action.Type != "collect_inputs" {
+		return ReaderEmailFailed, ""
+	}
+	switch action.Status {
+	case "in_progress":
+		return ReaderEmailPending, ""
+	case "failed":
+		return ReaderEmailFailed, ""
+	}
+
+	if action.CollectInputs != nil {
+		for _, input := range action.CollectInputs.Inputs {
+			if input.Type == "email" && !input.Skipped && input.Email != nil && strings.TrimSpace(input.Email.Value) != "" {
+				return ReaderEmailCollected, strings.TrimSpace(input.Email.Value)
+			}
+		}
+	}
+	return ReaderEmailSkipped, ""
+}
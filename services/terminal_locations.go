@@ -0,0 +1,197 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/stripe/stripe-go/v74"
+
+	"checkout/config"
+	"checkout/templates"
+	"checkout/utils"
+)
+
+// LocationFieldErrors are the problems with a Terminal Location form, by form field
+// (display_name, line1, city, state, postal_code, country). The "" field holds a problem that
+// isn't about one field.
+type LocationFieldErrors map[string]string
+
+func (e LocationFieldErrors) Error() string {
+	var messages []string
+	for _, field := range sortedKeys(e) {
+		messages = append(messages, e[field])
+	}
+	return strings.Join(messages, "; ")
+}
+
+// validateLocation checks the fields Stripe requires of every Terminal Location, so a form
+// missing one is answered without a round trip
+func validateLocation(location templates.StripeLocation) error {
+	errs := LocationFieldErrors{}
+	if strings.TrimSpace(location.DisplayName) == "" {
+		errs["display_name"] = "Enter a name for the location."
+	}
+	if strings.TrimSpace(location.Address.Line1) == "" {
+		errs["line1"] = "Enter the street address."
+	}
+	if strings.TrimSpace(location.Address.City) == "" {
+		errs["city"] = "Enter the city."
+	}
+	if len(strings.TrimSpace(location.Address.Country)) != 2 {
+		errs["country"] = "Enter the two-letter country code, e.g. US."
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// locationFieldError places a Stripe error on the form field it is about, from its param
+// (e.g. address[postal_code])
+func locationFieldError(err error) error {
+	var stripeErr *stripe.Error
+	if !errors.As(err, &stripeErr) || stripeErr.Type != stripe.ErrorTypeInvalidRequest {
+		return err
+	}
+	field := strings.TrimSuffix(strings.TrimPrefix(stripeErr.Param, "address["), "]")
+	switch field {
+	case "display_name", "line1", "city", "state", "postal_code", "country":
+	default:
+		field = ""
+	}
+	return LocationFieldErrors{field: stripeErr.Msg}
+}
+
+// locationParams returns the Stripe parameters for a location's name and address
+func locationParams(location templates.StripeLocation) *stripe.TerminalLocationParams {
+	address := location.Address
+	params := &stripe.TerminalLocationParams{
+		DisplayName: stripe.String(strings.TrimSpace(location.DisplayName)),
+		Address: &stripe.AddressParams{
+			Line1:   stripe.String(strings.TrimSpace(address.Line1)),
+			City:    stripe.String(strings.TrimSpace(address.City)),
+			Country: stripe.String(strings.ToUpper(strings.TrimSpace(address.Country))),
+		},
+	}
+	if state := strings.TrimSpace(address.State); state != "" {
+		params.Address.State = stripe.String(state)
+	}
+	if postalCode := strings.TrimSpace(address.PostalCode); postalCode != "" {
+		params.Address.PostalCode = stripe.String(postalCode)
+	}
+	return params
+}
+
+// CreateStripeLocation creates a Terminal Location for a new venue and refreshes the location
+// list. When the register has no location yet, it becomes the register's location and is saved
+// to config.json. A problem with a field Stripe rejects is returned as LocationFieldErrors.
+func CreateStripeLocation(location templates.StripeLocation) (templates.StripeLocation, error) {
+	if err := validateLocation(location); err != nil {
+		return templates.StripeLocation{}, err
+	}
+
+	created, err := Stripe.CreateLocation(locationParams(location))
+	if err != nil {
+		utils.Warn("terminal", "Stripe rejected the new Terminal Location", "name", location.DisplayName, "error", err)
+		return templates.StripeLocation{}, locationFieldError(err)
+	}
+	location = stripeLocation(created)
+	utils.Info("terminal", "Stripe Terminal Location created", "name", location.DisplayName, "id", location.ID)
+
+	refreshStripeLocations()
+	if Terminal.SelectedLocation().ID == "" {
+		Terminal.SelectLocation(location)
+		LoadStripeReadersForLocation(location.ID)
+		// Demo locations only exist in demo mode, whose location is never read from config
+		if config.Config.DemoMode {
+			return location, nil
+		}
+		if err := config.UpdateConfigField("StripeTerminalLocationID", location.ID); err != nil {
+			return location, fmt.Errorf("location created but could not be saved as the register's location: %w", err)
+		}
+	}
+	return location, nil
+}
+
+// UpdateStripeLocation changes a Terminal Location's name and address and refreshes the location
+// list, and the selected location if it was this one
+func UpdateStripeLocation(location templates.StripeLocation) (templates.StripeLocation, error) {
+	if err := validateLocation(location); err != nil {
+		return location, err
+	}
+
+	updated, err := Stripe.UpdateLocation(location.ID, locationParams(location))
+	if err != nil {
+		utils.Warn("terminal", "Stripe rejected the Terminal Location change", "id", location.ID, "error", err)
+		return location, locationFieldError(err)
+	}
+	location = stripeLocation(updated)
+	utils.Info("terminal", "Stripe Terminal Location updated", "name", location.DisplayName, "id", location.ID)
+
+	refreshStripeLocations()
+	if Terminal.SelectedLocation().ID == location.ID {
+		Terminal.SelectLocation(location)
+	}
+	return location, nil
+}
+
+// DeleteStripeLocation deletes a Terminal Location that no reader is registered to and that
+// isn't the register's location
+func DeleteStripeLocation(locationID string) error {
+	if locationID == Terminal.SelectedLocation().ID || locationID == config.Config.StripeTerminalLocationID {
+		return fmt.Errorf("this is the register's location; select another location before deleting it")
+	}
+
+	params := &stripe.TerminalReaderListParams{Location: stripe.String(locationID)}
+	readers, err := Stripe.ListReaders(params)
+	if err != nil {
+		return fmt.Errorf("error checking the location's readers: %w", err)
+	}
+	registered := 0
+	for _, reader := range readers {
+		if reader.Location != nil && reader.Location.ID == locationID {
+			registered++
+		}
+	}
+	if registered > 0 {
+		return fmt.Errorf("%d readers are registered to this location; move or delete them in the Stripe Dashboard first", registered)
+	}
+
+	if _, err := Stripe.DeleteLocation(locationID); err != nil {
+		return fmt.Errorf("error deleting Terminal Location: %w", err)
+	}
+	utils.Info("terminal", "Stripe Terminal Location deleted", "id", locationID)
+	refreshStripeLocations()
+	return nil
+}
+
+// refreshStripeLocations reloads the location list after a change, keeping the old list if
+// Stripe can't be reached
+func refreshStripeLocations() {
+	locations, err := ListStripeLocations()
+	if err != nil {
+		utils.Warn("terminal", "Could not reload Terminal Locations", "error", err)
+		return
+	}
+	Terminal.SetLocations(locations)
+}
+
+// stripeLocation converts a Stripe Terminal Location
+func stripeLocation(loc *stripe.TerminalLocation) templates.StripeLocation {
+	location := templates.StripeLocation{
+		ID:          loc.ID,
+		DisplayName: loc.DisplayName,
+		Livemode:    loc.Livemode,
+	}
+	if loc.Address != nil {
+		location.Address = templates.StripeAddress{
+			Line1:      loc.Address.Line1,
+			City:       loc.Address.City,
+			State:      loc.Address.State,
+			PostalCode: loc.Address.PostalCode,
+			Country:    loc.Address.Country,
+		}
+	}
+	return location
+}
@@ -0,0 +1,163 @@
+package services
+
+import (
+	"sync"
+	"time"
+
+	"checkout/templates"
+)
+
+// TerminalState holds the Stripe Terminal locations and readers of the site, and which ones the
+// cashier picked. Refreshes replace the lists wholesale; reads return copies.
+type TerminalState struct {
+	locations        []templates.StripeLocation
+	selectedLocation templates.StripeLocation
+	readers          []templates.StripeReader
+	selectedReaderID string // ID of the reader picked most recently on any register
+	readerHealth     map[string]*readerHealth
+	mutex            sync.RWMutex
+}
+
+// readerHealth is what the keep-alive checks have learned about a reader since startup
+type readerHealth struct {
+	lastSeen         time.Time // Last time the reader answered as online
+	unreachableSince time.Time // First failed check since then; zero while it answers
+	degraded         bool      // Unreachable for longer than the configured time
+}
+
+// NewTerminalState creates a terminal state with no locations or readers
+func NewTerminalState() *TerminalState {
+	return &TerminalState{}
+}
+
+// Locations returns the Stripe locations available to the register
+func (t *TerminalState) Locations() []templates.StripeLocation {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	return append([]templates.StripeLocation{}, t.locations...)
+}
+
+// SetLocations replaces the available Stripe locations
+func (t *TerminalState) SetLocations(locations []templates.StripeLocation) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.locations = append([]templates.StripeLocation{}, locations...)
+}
+
+// SelectedLocation returns the Stripe location the register takes payments at
+func (t *TerminalState) SelectedLocation() templates.StripeLocation {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	return t.selectedLocation
+}
+
+// SelectLocation sets the Stripe location the register takes payments at
+func (t *TerminalState) SelectLocation(location templates.StripeLocation) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.selectedLocation = location
+}
+
+// Readers returns the readers registered at the selected location
+func (t *TerminalState) Readers() []templates.StripeReader {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	return append([]templates.StripeReader{}, t.readers...)
+}
+
+// SetReaders replaces the readers of the selected location
+func (t *TerminalState) SetReaders(readers []templates.StripeReader) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.readers = append([]templates.StripeReader{}, readers...)
+}
+
+// SelectedReaderID returns the ID of the reader picked most recently on any register, or "" if
+// none. Payments from the POS use the register's own reader (SelectedReaderFor); this one is for
+// callers without a register, such as the API.
+func (t *TerminalState) SelectedReaderID() string {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	return t.selectedReaderID
+}
+
+// SelectReader sets the reader picked most recently
+func (t *TerminalState) SelectReader(readerID string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.selectedReaderID = readerID
+}
+
+// SetReaderStatus updates the cached status of a reader, e.g. after asking Stripe for it
+func (t *TerminalState) SetReaderStatus(readerID, status string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	for i := range t.readers {
+		if t.readers[i].ID == readerID {
+			t.readers[i].Status = status
+		}
+	}
+}
+
+// ReaderSeen records that a reader answered, clearing any degraded mark. It reports whether
+// the reader was degraded.
+func (t *TerminalState) ReaderSeen(readerID string, at time.Time) bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	health := t.health(readerID)
+	wasDegraded := health.degraded
+	*health = readerHealth{lastSeen: at}
+	return wasDegraded
+}
+
+// ReaderUnreachable records a failed check of a reader and marks it degraded once it has gone
+// degradedAfter without answering. It reports whether the reader has just become degraded.
+func (t *TerminalState) ReaderUnreachable(readerID string, at time.Time, degradedAfter time.Duration) bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	health := t.health(readerID)
+	if health.unreachableSince.IsZero() {
+		health.unreachableSince = at
+	}
+	if health.degraded || at.Sub(health.unreachableSince) < degradedAfter {
+		return false
+	}
+	health.degraded = true
+	return true
+}
+
+// ReaderDegraded reports whether a reader has stopped answering the keep-alive checks, and
+// when it last answered (zero if never since startup)
+func (t *TerminalState) ReaderDegraded(readerID string) (bool, time.Time) {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	health, ok := t.readerHealth[readerID]
+	if !ok {
+		return false, time.Time{}
+	}
+	return health.degraded, health.lastSeen
+}
+
+// health returns a reader's health record, creating it; the caller holds the write lock
+func (t *TerminalState) health(readerID string) *readerHealth {
+	if t.readerHealth == nil {
+		t.readerHealth = make(map[string]*readerHealth)
+	}
+	health, ok := t.readerHealth[readerID]
+	if !ok {
+		health = &readerHealth{}
+		t.readerHealth[readerID] = health
+	}
+	return health
+}
+
+// Reset forgets the location, readers and selected reader, e.g. when the Stripe account changes
+func (t *TerminalState) Reset() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.locations = nil
+	t.selectedLocation = templates.StripeLocation{}
+	t.readers = nil
+	t.selectedReaderID = ""
+	t.readerHealth = nil
+}
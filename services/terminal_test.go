@@ -0,0 +1,42 @@
+package services
+
+import (
+	"testing"
+
+	"checkout/templates"
+)
+
+func TestTippingRulesAllow(t *testing.T) {
+	coffee := templates.Product{Name: "Coffee", Price: 4.50, TaxCategory: "food"}
+	mug := templates.Product{Name: "Mug", Price: 12, TaxCategory: "merch"}
+	tests := []struct {
+		name     string
+		rules    TippingRules
+		amount   float64
+		cart     []templates.Product
+		location string
+		want     bool
+	}{
+		{"enabled", TippingRules{Enabled: true}, 10, []templates.Product{coffee}, "tml_1", true},
+		{"disabled", TippingRules{}, 10, []templates.Product{coffee}, "tml_1", false},
+		{"disabled but on at the location", TippingRules{LocationOverrides: map[string]bool{"tml_1": true}}, 10, []templates.Product{coffee}, "tml_1", true},
+		{"disabled and on at another location", TippingRules{LocationOverrides: map[string]bool{"tml_2": true}}, 10, []templates.Product{coffee}, "tml_1", false},
+		{"enabled but off at the location", TippingRules{Enabled: true, LocationOverrides: map[string]bool{"tml_1": false}}, 10, []templates.Product{coffee}, "tml_1", false},
+		{"below the minimum", TippingRules{Enabled: true, MinAmount: 5}, 4.99, []templates.Product{coffee}, "tml_1", false},
+		{"at the minimum", TippingRules{Enabled: true, MinAmount: 5}, 5, []templates.Product{coffee}, "tml_1", true},
+		{"at the maximum", TippingRules{Enabled: true, MaxAmount: 100}, 100, []templates.Product{coffee}, "tml_1", true},
+		{"above the maximum", TippingRules{Enabled: true, MaxAmount: 100}, 100.01, []templates.Product{coffee}, "tml_1", false},
+		{"no maximum", TippingRules{Enabled: true}, 10000, []templates.Product{coffee}, "tml_1", true},
+		{"an item in a tipped category", TippingRules{Enabled: true, CategoriesOnly: []string{"food"}}, 16.50, []templates.Product{mug, coffee}, "tml_1", true},
+		{"no item in a tipped category", TippingRules{Enabled: true, CategoriesOnly: []string{"food"}}, 12, []templates.Product{mug}, "tml_1", false},
+		{"category limit with an empty cart", TippingRules{Enabled: true, CategoriesOnly: []string{"food"}}, 10, nil, "tml_1", false},
+		{"location override still checks the amount", TippingRules{LocationOverrides: map[string]bool{"tml_1": true}, MinAmount: 5}, 4.50, []templates.Product{coffee}, "tml_1", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.rules.Allow(tt.amount, tt.cart, tt.location); got != tt.want {
+				t.Errorf("Allow(%v, %d items, %q) = %v, want %v", tt.amount, len(tt.cart), tt.location, got, tt.want)
+			}
+		})
+	}
+}
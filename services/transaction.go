@@ -4,8 +4,13 @@ import (
 	"encoding/csv"
 	"encoding/json"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	"checkout/config"
@@ -13,49 +18,21 @@ import (
 	"checkout/utils"
 )
 
-// Save transaction to CSV in QuickBooks-friendly format
+// Save transaction to CSV in QuickBooks-friendly format, in the log of the current business day
 func SaveTransactionToCSV(transaction templates.Transaction) error {
-	// Create filename with current date (same date format as the transaction date)
-	today := time.Now().Format("2006-01-02")
-
-	// Use transactions directory from config or fallback to constant
-	transactionsDir := config.Config.TransactionsDir
-	if transactionsDir == "" {
-		transactionsDir = "./data/transactions"
-	}
-
-	filename := filepath.Join(transactionsDir, today+".csv")
-
-	// Check if file exists to determine if we need headers
-	fileExists := true
-	if _, err := os.Stat(filename); os.IsNotExist(err) {
-		fileExists = false
-	}
-
-	// Open file for appending
-	file, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	if err != nil {
-		return fmt.Errorf("failed to open log file: %v", err)
-	}
-	defer func() {
-		if err := file.Close(); err != nil {
-			utils.Error("services", "Error closing transaction log file", "error", err)
-		}
-	}()
+	return SaveTransactionTo(getTransactionsDir(), transaction)
+}
 
-	writer := csv.NewWriter(file)
-	defer writer.Flush()
+// SaveTransactionTo saves a transaction like SaveTransactionToCSV, in the logs of the given directory
+func SaveTransactionTo(dir string, transaction templates.Transaction) error {
+	return saveTransactionToLog(dir, config.BusinessDay(time.Now()), transaction)
+}
 
-	// Write headers if file is new
-	if !fileExists {
-		headers := []string{
-			"Date", "Time", "Transaction ID", "Item/Service", "Description",
-			"Quantity", "Unit Price", "Tax", "Total", "Payment Method",
-			"Stripe Customer Email", "Payment Link ID", "Payment Link Status", "Confirmation Code", "Failure Reason",
-		}
-		if err := writer.Write(headers); err != nil {
-			return err
-		}
+// saveTransactionToLog appends a transaction's rows to the given day's log in dir
+func saveTransactionToLog(dir string, day time.Time, transaction templates.Transaction) error {
+	// Record which venue took the sale for per-location reporting
+	if transaction.LocationID == "" {
+		transaction.LocationID = Terminal.SelectedLocation().ID
 	}
 
 	// For payment link events without products (like cancellations or expirations)
@@ -76,16 +53,39 @@ func SaveTransactionToCSV(transaction templates.Transaction) error {
 			transaction.PaymentLinkStatus,
 			transaction.ConfirmationCode,
 			transaction.FailureReason,
+			transaction.LocationID,
+			"", // Override Reason
+			transaction.CardBrand,
+			transaction.CardLast4,
+			transaction.StripeReceiptURL,
+			"", // Tender Amount
+			"", // Return Of
+			"", // Tip Amount
+			transaction.Note,
+			yesFlag(transaction.Imported),
+			"", // Tax Category
+			"", // Late For Day
+			"", // Description Edited
+			"", // Service Fee
+			"", // Product ID
+			"", // Category
+			"", // Order Number
+			"", // Retry Of
+			transaction.CashierID,
+			"", // List Price
+			"", // Sale Price
+			"", // Stripe Fee
+			"", // Net Amount
+			"", // Vendor
+			transaction.PayoutAccount,
 		}
 
-		if err := writer.Write(record); err != nil {
-			return err
-		}
-
-		return nil
+		return appendTransactionRecords(dir, day, [][]string{record}, nil)
 	}
 
 	// Write each product as a separate line
+	var records [][]string
+	var taxComponents []map[string]float64
 	for i, product := range transaction.Products {
 		// Use the stored tax amount for this product
 		var tax float64
@@ -99,14 +99,43 @@ func SaveTransactionToCSV(transaction templates.Transaction) error {
 
 		total := product.Price + tax
 
+		// The tip, service fee and Stripe fee belong to the sale, not an item, so they are logged once on the first row.
+		// The Stripe fee is usually looked up after the sale is logged and recorded as a payment update instead.
+		tip, fee, stripeFee, net := "", "", "", ""
+		if i == 0 && transaction.TipAmount != 0 {
+			tip = fmt.Sprintf("%.2f", transaction.TipAmount)
+		}
+		if i == 0 {
+			fee = feeValue(transaction.ServiceFee)
+		}
+		if i == 0 && transaction.HasStripeFee() {
+			stripeFee, net = fmt.Sprintf("%.2f", transaction.FeeAmount), fmt.Sprintf("%.2f", transaction.NetAmount)
+		}
+
+		// Return lines are logged as quantity -1 at the refunded unit price, and measured lines
+		// with their quantity at the price per unit, the unit appended to the description
+		// A line sold at a scheduled sale price keeps the catalog price it replaced
+		listPrice, salePrice := "", ""
+		if IsSaleLine(product) {
+			listPrice, salePrice = fmt.Sprintf("%.2f", product.ListPrice), fmt.Sprintf("%.2f", product.SalePrice)
+		}
+
+		quantity, unitPrice, description := "1", product.Price, product.Description
+		if IsMeasuredLine(product) {
+			quantity, description = measuredLogValues(product)
+			unitPrice = product.UnitPrice
+		} else if product.ReturnOf != "" && product.Price < 0 {
+			quantity, unitPrice = "-1", -product.Price
+		}
+
 		record := []string{
 			transaction.Date,
 			transaction.Time,
 			transaction.ID,
 			product.Name,
-			product.Description,
-			"1", // Quantity
-			fmt.Sprintf("%.2f", product.Price),
+			description,
+			quantity,
+			fmt.Sprintf("%.2f", unitPrice),
 			fmt.Sprintf("%.2f", tax),
 			fmt.Sprintf("%.2f", total),
 			transaction.PaymentType,
@@ -115,16 +144,520 @@ func SaveTransactionToCSV(transaction templates.Transaction) error {
 			transaction.PaymentLinkStatus,
 			transaction.ConfirmationCode,
 			transaction.FailureReason,
+			transaction.LocationID,
+			product.OverrideReason,
+			transaction.CardBrand,
+			transaction.CardLast4,
+			transaction.StripeReceiptURL,
+			"", // Tender Amount
+			product.ReturnOf,
+			tip,
+			transaction.Note,
+			yesFlag(transaction.Imported),
+			product.TaxCategory,
+			"", // Late For Day
+			yesFlag(product.DescriptionEdited),
+			fee,
+			product.ID,
+			product.Category,
+			orderNumberValue(transaction.OrderNumber),
+			transaction.RetryOf,
+			transaction.CashierID,
+			listPrice,
+			salePrice,
+			stripeFee,
+			net,
+			product.Vendor,
+			transaction.PayoutAccount,
 		}
+		records = append(records, record)
 
-		if err := writer.Write(record); err != nil {
-			return err
+		var components map[string]float64
+		if i < len(transaction.ProductTaxComponents) {
+			components = transaction.ProductTaxComponents[i]
 		}
+		taxComponents = append(taxComponents, components)
 	}
 
+	if err := appendTransactionRecords(dir, day, records, taxComponents); err != nil {
+		return err
+	}
+	if isSuccessfulPaymentType(transaction.PaymentType) && !transaction.HasStripeFee() {
+		QueueStripeFeeLookup(transaction.ID)
+	}
+	QueueOrderEvent(transaction)
 	return nil
 }
 
+// appendTransactionRecords appends rows to a day's transaction log, writing the header for a new file.
+// A log started before a schema change is upgraded first so every row matches the header.
+// Rows for a day already closed with a Z-report go to the next open day's log instead, with the
+// closed day in the Late For Day column, so a closed day's totals never change.
+// taxComponents holds each row's tax per component (nil for none), written to the component columns.
+func appendTransactionRecords(dir string, day time.Time, records [][]string, taxComponents []map[string]float64) error {
+	transactionLogMutex.Lock()
+	defer transactionLogMutex.Unlock()
+
+	filename, lateFor := openTransactionLogPath(dir, day)
+	if lateFor != "" {
+		column := slices.Index(TransactionCSVHeader, "Late For Day")
+		for i, record := range records {
+			if len(record) < len(TransactionCSVHeader) {
+				record = append(record, make([]string, len(TransactionCSVHeader)-len(record))...)
+			}
+			record[column] = lateFor
+			records[i] = record
+		}
+		utils.Warn("services", "Day already closed, logging rows in the next open day", "closed_day", lateFor, "file", filepath.Base(filename), "rows", len(records))
+	}
+
+	if _, err := upgradeTransactionLog(filename); err != nil {
+		return fmt.Errorf("failed to upgrade log file schema: %v", err)
+	}
+
+	// Check if file exists to determine if we need headers
+	header, err := readTransactionLogHeader(filename)
+	if err != nil {
+		return fmt.Errorf("failed to read log file header: %v", err)
+	}
+	fileExists := header != nil
+	if !fileExists {
+		header = transactionLogHeader()
+	}
+
+	// Pad rows to the header so logs that kept retired columns stay rectangular
+	for i, record := range records {
+		if len(record) < len(header) {
+			records[i] = append(record, make([]string, len(header)-len(record))...)
+		}
+		if i < len(taxComponents) {
+			for name, amount := range taxComponents[i] {
+				if column := slices.Index(header, TaxComponentColumn(name)); column >= 0 {
+					records[i][column] = fmt.Sprintf("%.2f", amount)
+				}
+			}
+		}
+	}
+
+	// Open file for appending
+	file, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %v", err)
+	}
+	defer func() {
+		if err := file.Close(); err != nil {
+			utils.Error("services", "Error closing transaction log file", "error", err)
+		}
+	}()
+
+	writer := csv.NewWriter(file)
+	defer writer.Flush()
+
+	// Write headers if file is new
+	if !fileExists {
+		if err := writer.Write(header); err != nil {
+			return err
+		}
+	}
+
+	return writer.WriteAll(records)
+}
+
+// feeValue returns the Service Fee column of a row, blank when there is no fee
+func feeValue(fee float64) string {
+	if fee == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%.2f", fee)
+}
+
+// orderNumberValue formats an order number for the Order Number column, blank when there is none
+func orderNumberValue(number int) string {
+	if number == 0 {
+		return ""
+	}
+	return strconv.Itoa(number)
+}
+
+// yesValue marks flag columns: Imported on rows reconstructed from Stripe by reconciliation,
+// Description Edited on lines whose description the cashier changed
+const yesValue = "yes"
+
+// yesFlag returns the value of a flag column
+func yesFlag(set bool) string {
+	if set {
+		return yesValue
+	}
+	return ""
+}
+
+// openTransactionLogPath returns the log new rows for day are written to: the day's own log,
+// or the log of the first day after it that has no Z-report. lateFor is the closed day the rows
+// belong to, or "" if the day is open. Callers must hold transactionLogMutex.
+func openTransactionLogPath(dir string, day time.Time) (string, string) {
+	lateFor := ""
+	for IsDayClosed(day) {
+		if lateFor == "" {
+			lateFor = day.Format("2006-01-02")
+		}
+		day = day.AddDate(0, 0, 1)
+	}
+	return transactionLogPathIn(dir, day), lateFor
+}
+
+// TransactionLogPath returns the CSV transaction log for the given business day
+func TransactionLogPath(day time.Time) string {
+	return transactionLogPathIn(getTransactionsDir(), day)
+}
+
+// transactionLogPathIn returns the CSV transaction log for the given business day in dir
+func transactionLogPathIn(dir string, day time.Time) string {
+	return filepath.Join(dir, day.Format("2006-01-02")+".csv")
+}
+
+// ExportTransactionLog writes the transaction log of a business day to w as it is stored, reading
+// it from its month's archive once archived. A day without a log is written as the header alone.
+func ExportTransactionLog(day time.Time, w io.Writer) error {
+	file, err := openTransactionFile(TransactionLogPath(day))
+	if os.IsNotExist(err) {
+		writer := csv.NewWriter(w)
+		if err := writer.Write(TransactionCSVHeader); err != nil {
+			return err
+		}
+		writer.Flush()
+		return writer.Error()
+	} else if err != nil {
+		return fmt.Errorf("error opening transaction log: %w", err)
+	}
+	defer file.Close()
+
+	_, err = io.Copy(w, file)
+	return err
+}
+
+// VoidedPaymentSuffix marks the payment type of reversal rows (e.g. "terminal_voided")
+const VoidedPaymentSuffix = "_voided"
+
+// SaveVoidTransaction appends reversal rows for a voided transaction.
+// The rows mirror the original line items with negated amounts and share its transaction ID
+// and confirmation code, so the original and its reversal are paired in the CSV.
+func SaveVoidTransaction(original *templates.Transaction, reason, cashier string) error {
+	now := time.Now()
+
+	reversal := templates.Transaction{
+		ID:               original.ID,
+		Date:             now.Format("01/02/2006"),
+		Time:             now.Format("15:04:05"),
+		Subtotal:         -original.Subtotal,
+		Tax:              -original.Tax,
+		ServiceFee:       -original.ServiceFee,
+		Total:            -original.Total,
+		PaymentType:      original.PaymentType + VoidedPaymentSuffix,
+		ConfirmationCode: original.ConfirmationCode,
+		FailureReason:    "Void: " + reason,
+		LocationID:       original.LocationID,
+		TipAmount:        -original.TipAmount,
+		CashierID:        cashier,
+		PayoutAccount:    original.PayoutAccount,
+	}
+	for i, product := range original.Products {
+		product.Price = -product.Price
+		reversal.Products = append(reversal.Products, product)
+		if i < len(original.ProductTaxes) {
+			reversal.ProductTaxes = append(reversal.ProductTaxes, -original.ProductTaxes[i])
+		}
+		if i < len(original.ProductTaxComponents) {
+			components := make(map[string]float64)
+			for name, amount := range original.ProductTaxComponents[i] {
+				components[name] = -amount
+			}
+			reversal.ProductTaxComponents = append(reversal.ProductTaxComponents, components)
+		}
+	}
+
+	return SaveTransactionToCSV(reversal)
+}
+
+// LoadTransactionByID finds a successful transaction in the CSV logs by its ID.
+// The ID is the payment ID used by every payment path (PaymentIntent, payment link, etc.),
+// so receipts can be produced regardless of how the sale was taken.
+func LoadTransactionByID(transactionID string) (*templates.Transaction, error) {
+	files, err := globTransactionFiles("*.csv")
+	if err != nil {
+		return nil, fmt.Errorf("error listing transaction logs: %w", err)
+	}
+
+	// Files are named by date, so search the most recent days first
+	sort.Sort(sort.Reverse(sort.StringSlice(files)))
+
+	// Reversals are logged after the original sale, so they are always seen first
+	voided := false
+	for _, filename := range files {
+		transaction, voidedInFile, err := findTransactionInCSV(filename, transactionID)
+		if err != nil {
+			utils.Error("services", "Error reading transaction log", "file", filename, "error", err)
+			continue
+		}
+		voided = voided || voidedInFile
+		if transaction != nil {
+			transaction.Voided = voided
+			applyPaymentUpdates(transaction)
+			return transaction, nil
+		}
+	}
+
+	return nil, fmt.Errorf("transaction %s not found", transactionID)
+}
+
+// LoadTransactionsForDay returns the successful transactions in a day's log, in the order they were taken
+func LoadTransactionsForDay(day time.Time) ([]templates.Transaction, error) {
+	filename := TransactionLogPath(day)
+	records, field, err := readTransactionLog(filename)
+	if os.IsNotExist(err) {
+		return []templates.Transaction{}, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("error reading transaction log: %w", err)
+	}
+
+	// Split tenders are folded into their sale, so only sale rows name a transaction
+	var ids []string
+	seen := make(map[string]bool)
+	for _, record := range records {
+		id := field(record, "Transaction ID")
+		if seen[id] || field(record, "Tender Amount") != "" || !isSuccessfulPaymentType(field(record, "Payment Method")) {
+			continue
+		}
+		seen[id] = true
+		ids = append(ids, id)
+	}
+
+	transactions := []templates.Transaction{}
+	for _, id := range ids {
+		transaction, voided, err := findTransactionInCSV(filename, id)
+		if err != nil {
+			return nil, fmt.Errorf("error reading transaction log: %w", err)
+		}
+		if transaction != nil {
+			transaction.Voided = voided
+			transactions = append(transactions, *transaction)
+		}
+	}
+
+	edited := make([]*templates.Transaction, len(transactions))
+	for i := range transactions {
+		edited[i] = &transactions[i]
+	}
+	applyPaymentUpdates(edited...)
+	return transactions, nil
+}
+
+// applyPaymentUpdates applies what was recorded about transactions after they were logged:
+// note edits, the Stripe fees looked up after payment and redacted customer contacts
+func applyPaymentUpdates(transactions ...*templates.Transaction) {
+	updates, err := loadPaymentUpdates()
+	if err != nil {
+		utils.Error("services", "Error loading payment updates", "error", err)
+		return
+	}
+	applyNoteEdits(updates, transactions...)
+	applyStripeFees(updates, transactions...)
+	applyContactRedactions(updates, transactions...)
+}
+
+// findTransactionInCSV rebuilds a successful transaction from its line-item rows in a single CSV log,
+// and reports whether the log contains a reversal of it.
+// The transaction is nil if the log has no successful rows for it.
+func findTransactionInCSV(filename, transactionID string) (*templates.Transaction, bool, error) {
+	records, field, err := readTransactionLog(filename)
+	if err != nil {
+		return nil, false, err
+	}
+
+	var transaction *templates.Transaction
+	var tenders []templates.Tender
+	voided := false
+	for _, record := range records {
+		// Tenders of a split sale are logged under their own payment IDs and the sale's confirmation code
+		if field(record, "Tender Amount") != "" && field(record, "Confirmation Code") == transactionID {
+			if isSuccessfulPaymentType(field(record, "Payment Method")) {
+				amount, _ := strconv.ParseFloat(field(record, "Tender Amount"), 64)
+				fee, _ := strconv.ParseFloat(field(record, "Service Fee"), 64)
+				tenders = append(tenders, templates.Tender{
+					PaymentID:  field(record, "Transaction ID"),
+					Method:     field(record, "Payment Method"),
+					Amount:     amount,
+					ServiceFee: fee,
+					CardBrand:  field(record, "Card Brand"),
+					CardLast4:  field(record, "Card Last4"),
+					ReceiptURL: field(record, "Stripe Receipt URL"),
+
+					PayoutAccount: field(record, "Payout Account"),
+				})
+			}
+			continue
+		}
+
+		if field(record, "Transaction ID") != transactionID {
+			continue
+		}
+		if strings.HasSuffix(field(record, "Payment Method"), VoidedPaymentSuffix) {
+			voided = true
+			continue
+		}
+		if !isSuccessfulPaymentType(field(record, "Payment Method")) {
+			continue
+		}
+
+		if transaction == nil {
+			transaction = &templates.Transaction{
+				ID:                  transactionID,
+				Date:                field(record, "Date"),
+				Time:                field(record, "Time"),
+				PaymentType:         field(record, "Payment Method"),
+				StripeCustomerEmail: field(record, "Stripe Customer Email"),
+				PaymentLinkID:       field(record, "Payment Link ID"),
+				PaymentLinkStatus:   field(record, "Payment Link Status"),
+				ConfirmationCode:    field(record, "Confirmation Code"),
+				LocationID:          field(record, "Location ID"),
+				CardBrand:           field(record, "Card Brand"),
+				CardLast4:           field(record, "Card Last4"),
+				StripeReceiptURL:    field(record, "Stripe Receipt URL"),
+				Note:                field(record, "Notes"),
+				RetryOf:             field(record, "Retry Of"),
+				CashierID:           field(record, "Cashier ID"),
+				Imported:            field(record, "Imported") == yesValue,
+				PayoutAccount:       field(record, "Payout Account"),
+			}
+			transaction.OrderNumber, _ = strconv.Atoi(field(record, "Order Number"))
+		}
+
+		// Rows without an item name are payment link status events, not line items
+		if field(record, "Item/Service") == "" {
+			continue
+		}
+
+		price := lineItemPrice(record, field)
+		tax, _ := strconv.ParseFloat(field(record, "Tax"), 64)
+		tip, _ := strconv.ParseFloat(field(record, "Tip Amount"), 64)
+		transaction.TipAmount += tip
+		fee, _ := strconv.ParseFloat(field(record, "Service Fee"), 64)
+		transaction.ServiceFee += fee
+		stripeFee, _ := strconv.ParseFloat(field(record, "Stripe Fee"), 64)
+		transaction.FeeAmount += stripeFee
+		net, _ := strconv.ParseFloat(field(record, "Net Amount"), 64)
+		transaction.NetAmount += net
+
+		product := templates.Product{
+			ID:                field(record, "Product ID"),
+			Name:              field(record, "Item/Service"),
+			Description:       field(record, "Description"),
+			Price:             price,
+			OverrideReason:    field(record, "Override Reason"),
+			ReturnOf:          field(record, "Return Of"),
+			TaxCategory:       field(record, "Tax Category"),
+			DescriptionEdited: field(record, "Description Edited") == yesValue,
+			Category:          field(record, "Category"),
+			Vendor:            field(record, "Vendor"),
+		}
+		product.ListPrice, _ = strconv.ParseFloat(field(record, "List Price"), 64)
+		product.SalePrice, _ = strconv.ParseFloat(field(record, "Sale Price"), 64)
+		unitPrice, _ := strconv.ParseFloat(field(record, "Unit Price"), 64)
+		restoreMeasuredLine(&product, field(record, "Quantity"), unitPrice)
+		transaction.Products = append(transaction.Products, product)
+		transaction.ProductTaxes = append(transaction.ProductTaxes, tax)
+		transaction.ProductTaxComponents = append(transaction.ProductTaxComponents, loggedTaxComponents(record, field))
+		transaction.Subtotal += price
+		transaction.Tax += tax
+		transaction.Total += price + tax + fee
+	}
+
+	if transaction != nil && transaction.ConfirmationCode == "" {
+		transaction.ConfirmationCode = transactionID
+	}
+	if transaction != nil {
+		transaction.Tenders = tenders
+	}
+
+	return transaction, voided, nil
+}
+
+// readTransactionLog reads the data rows of a CSV transaction log.
+// The returned lookup finds a row's value by header name, so readers survive column changes.
+// Logs of archived months are read from their archive.
+func readTransactionLog(filename string) ([][]string, func(record []string, name string) string, error) {
+	file, err := openTransactionFile(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer func() {
+		if err := file.Close(); err != nil {
+			utils.Error("services", "Error closing transaction log file", "error", err)
+		}
+	}()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	columns := make(map[string]int)
+	if len(records) > 0 {
+		for i, header := range records[0] {
+			columns[header] = i
+		}
+		records = records[1:]
+	}
+	field := func(record []string, name string) string {
+		if i, exists := columns[name]; exists && i < len(record) {
+			return record[i]
+		}
+		return ""
+	}
+
+	return records, field, nil
+}
+
+// loggedTaxComponents returns a row's tax per configured component. Logs written before a
+// component was configured have no column for it, and read as no tax for that component.
+func loggedTaxComponents(record []string, field func(record []string, name string) string) map[string]float64 {
+	components := make(map[string]float64)
+	for _, name := range TaxComponentNames() {
+		if value := field(record, TaxComponentColumn(name)); value != "" {
+			amount, _ := strconv.ParseFloat(value, 64)
+			components[name] = amount
+		}
+	}
+	return components
+}
+
+// lineItemPrice returns a line item row's signed price: the unit price times the quantity, which
+// is -1 for return lines and the measured quantity for items sold by weight or length
+func lineItemPrice(record []string, field func(record []string, name string) string) float64 {
+	price, _ := strconv.ParseFloat(field(record, "Unit Price"), 64)
+	quantity, err := strconv.ParseFloat(field(record, "Quantity"), 64)
+	if err != nil || quantity == 0 {
+		return price
+	}
+	return roundCents(price * quantity)
+}
+
+// isSuccessfulPaymentType reports whether a logged payment type is a completed sale
+// (failed, cancelled, expired and voided events and sent links are logged with a suffix, e.g. "qr_expired")
+func isSuccessfulPaymentType(paymentType string) bool {
+	if paymentType == "" {
+		return false
+	}
+	for _, suffix := range []string{"_failed", "_cancelled", "_expired", "_unknown", VoidedPaymentSuffix, LinkSentPaymentSuffix} {
+		if strings.HasSuffix(paymentType, suffix) {
+			return false
+		}
+	}
+	return true
+}
+
 // LoadProducts loads products from the JSON file
 func LoadProducts() error {
 	utils.Info("products", "Loading products")
@@ -136,11 +669,13 @@ func LoadProducts() error {
 	}
 	productsFilePath := filepath.Join(dataDir, "products.json")
 
-	// Check if products file exists
+	// Start a new install with the sample catalog instead of an empty register
 	if _, err := os.Stat(productsFilePath); os.IsNotExist(err) {
-		utils.Error("products", "No products defined", "error", "products.json file not found")
-		AppState.Products = []templates.Product{} // Initialize empty products
-		return fmt.Errorf("no products defined: products.json file not found")
+		utils.Info("products", "No products.json found, writing sample catalog", "path", productsFilePath)
+		if err := config.WriteDefaultFile("products.json", productsFilePath); err != nil {
+			SetProducts(nil) // Initialize empty products
+			return fmt.Errorf("no products defined: %w", err)
+		}
 	}
 
 	// Read existing products
@@ -156,8 +691,12 @@ func LoadProducts() error {
 
 	// Ensure each product has a Stripe Product ID and a default Price ID.
 	// Update the products.json file if any changes were made.
+	// Skipped in demo mode, which would save made-up IDs into the real catalog.
 	var actualUpdatesMade bool // Correctly named flag
 	for i := range products {
+		if config.Config.DemoMode {
+			break
+		}
 		// Assuming EnsureServiceHasPriceID is the one from services/stripe.go
 		// which now returns (bool, error)
 		updated, err := EnsureServiceHasPriceID(&products[i])
@@ -180,23 +719,26 @@ func LoadProducts() error {
 		utils.Debug("products", "Successfully saved products.json with updated Stripe IDs")
 	}
 
-	// Log the state of products before assigning to AppState
+	// Log the state of products before assigning to the catalog
 	for _, p := range products {
-		utils.Debug("products", "Before AppState assignment", "product", p.Name, "id", p.ID, "stripe_product_id", p.StripeProductID, "price_id", p.PriceID)
+		utils.Debug("products", "Before catalog assignment", "product", p.Name, "id", p.ID, "stripe_product_id", p.StripeProductID, "price_id", p.PriceID)
 	}
-	AppState.Products = products
-	// Build category navigation data
-	AppState.CategoryData = BuildCategoryData(AppState.Products)
-	utils.Debug("products", "Finished LoadServices, AppState.Products populated")
-	// Log the state of AppState.Products after assignment
-	for _, p_app := range AppState.Products {
-		utils.Debug("products", "After AppState assignment", "product", p_app.Name, "id", p_app.ID, "stripe_product_id", p_app.StripeProductID, "price_id", p_app.PriceID)
+	// Assign products and build category navigation and SKU lookup data
+	SetProducts(products)
+	utils.Debug("products", "Finished LoadServices, catalog populated")
+	// Log the state of the catalog after assignment
+	for _, p_app := range Catalog.Products() {
+		utils.Debug("products", "After catalog assignment", "product", p_app.Name, "id", p_app.ID, "stripe_product_id", p_app.StripeProductID, "price_id", p_app.PriceID)
 	}
 	return nil
 }
 
 // SaveProducts saves the products to the JSON file
 func SaveProducts(products []templates.Product) error {
+	if err := ValidateProductSKUs(products); err != nil {
+		return err
+	}
+
 	// Use data directory from config or fallback to constant
 	dataDir := config.Config.DataDir
 	if dataDir == "" {
@@ -222,3 +764,19 @@ func SaveProducts(products []templates.Product) error {
 
 	return nil
 }
+
+// getTransactionsDir returns where transaction logs are written; demo sales go to their own directory
+func getTransactionsDir() string {
+	return TransactionsDir(&config.Config)
+}
+
+// TransactionsDir returns where transaction logs are written under the given configuration
+func TransactionsDir(cfg *templates.AppConfig) string {
+	if cfg.DemoMode {
+		return filepath.Join(demoDataDirOf(cfg), "transactions")
+	}
+	if cfg.TransactionsDir != "" {
+		return cfg.TransactionsDir
+	}
+	return config.DefaultTransactionsDir
+}
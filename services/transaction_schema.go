@@ -0,0 +1,204 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"checkout/utils"
+)
+
+// TransactionCSVHeader is the current fixed column layout of the daily transaction logs, which
+// the tax component columns follow (see transactionLogHeader). New columns go at the end; logs written with an older layout are upgraded in place
+// the next time they are appended to, or all at once with MigrateTransactionCSVs.
+var TransactionCSVHeader = []string{
+	"Date", "Time", "Transaction ID", "Item/Service", "Description",
+	"Quantity", "Unit Price", "Tax", "Total", "Payment Method",
+	"Stripe Customer Email", "Payment Link ID", "Payment Link Status", "Confirmation Code", "Failure Reason",
+	"Location ID", "Override Reason", "Card Brand", "Card Last4", "Stripe Receipt URL",
+	"Tender Amount", "Return Of", "Tip Amount", "Notes", "Imported",
+	"Tax Category", "Late For Day", "Description Edited", "Service Fee", "Product ID",
+	"Category", "Order Number", "Retry Of", "Cashier ID", "List Price",
+	"Sale Price", "Stripe Fee", "Net Amount", "Vendor", "Payout Account",
+}
+
+// transactionLogHeader returns the layout new logs are written with: the fixed columns, then a
+// "Tax: <name>" column per configured tax component. Components are added to a day's log when it
+// is next appended to, and a removed component's column is kept like any retired column.
+func transactionLogHeader() []string {
+	header := append([]string{}, TransactionCSVHeader...)
+	for _, name := range TaxComponentNames() {
+		header = append(header, TaxComponentColumn(name))
+	}
+	return header
+}
+
+// transactionLogMutex serializes writes to the transaction logs, since upgrading a log replaces the file
+var transactionLogMutex sync.Mutex
+
+// TransactionSchemaVersion identifies a transaction log column layout by a short fingerprint of its header
+func TransactionSchemaVersion(header []string) string {
+	sum := sha256.Sum256([]byte(strings.Join(header, "\x1f")))
+	return hex.EncodeToString(sum[:4])
+}
+
+// MigrateTransactionCSVs upgrades every transaction log in the transactions directory to the
+// current schema and returns how many files were rewritten
+func MigrateTransactionCSVs() (int, error) {
+	transactionLogMutex.Lock()
+	defer transactionLogMutex.Unlock()
+
+	files, err := filepath.Glob(filepath.Join(getTransactionsDir(), "*.csv"))
+	if err != nil {
+		return 0, fmt.Errorf("error listing transaction logs: %w", err)
+	}
+
+	upgraded := 0
+	for _, filename := range files {
+		changed, err := upgradeTransactionLog(filename)
+		if err != nil {
+			return upgraded, fmt.Errorf("error upgrading %s: %w", filepath.Base(filename), err)
+		}
+		if changed {
+			upgraded++
+		}
+	}
+
+	utils.Info("services", "Transaction logs migrated", "files", len(files), "upgraded", upgraded, "schema", TransactionSchemaVersion(transactionLogHeader()))
+	return upgraded, nil
+}
+
+// upgradeTransactionLog rewrites a log whose header doesn't match the current schema.
+// Rows are rearranged by column name, so values never end up under the wrong header;
+// columns the current schema no longer has are kept after the current ones.
+// Returns false if the log was already current. Callers must hold transactionLogMutex.
+func upgradeTransactionLog(filename string) (bool, error) {
+	header, err := readTransactionLogHeader(filename)
+	if err != nil || header == nil {
+		return false, err
+	}
+	if transactionHeaderIsCurrent(header) {
+		return false, nil
+	}
+
+	file, err := os.Open(filename)
+	if err != nil {
+		return false, err
+	}
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	records, err := reader.ReadAll()
+	file.Close()
+	if err != nil {
+		return false, err
+	}
+
+	// Build the upgraded header: current columns first, then any the old layout had beyond them
+	newHeader := transactionLogHeader()
+	current := make(map[string]bool)
+	for _, name := range newHeader {
+		current[name] = true
+	}
+	for _, name := range header {
+		if !current[name] {
+			newHeader = append(newHeader, name)
+			current[name] = true
+		}
+	}
+
+	oldColumns := make(map[string]int)
+	for i, name := range header {
+		if _, exists := oldColumns[name]; !exists {
+			oldColumns[name] = i
+		}
+	}
+
+	// Rows appended after a mid-day deploy already use the current layout, tax component
+	// columns included, but sit under the old header; their extra values belong to the current
+	// columns in order
+	currentLayout := len(transactionLogHeader())
+	upgraded := [][]string{newHeader}
+	for _, record := range records[1:] {
+		row := make([]string, len(newHeader))
+		for i, name := range newHeader {
+			if j, exists := oldColumns[name]; exists && j < len(record) {
+				row[i] = record[j]
+			}
+		}
+		if len(record) > len(header) && len(record) <= currentLayout {
+			row = make([]string, len(newHeader))
+			copy(row, record)
+		}
+		upgraded = append(upgraded, row)
+	}
+
+	// Write a temporary file and rename it over the log so a failure never leaves it half written
+	tempFile := filename + ".tmp"
+	out, err := os.Create(tempFile)
+	if err != nil {
+		return false, err
+	}
+	writer := csv.NewWriter(out)
+	if err := writer.WriteAll(upgraded); err != nil {
+		out.Close()
+		os.Remove(tempFile)
+		return false, err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(tempFile)
+		return false, err
+	}
+	if err := os.Rename(tempFile, filename); err != nil {
+		os.Remove(tempFile)
+		return false, err
+	}
+
+	utils.Info("services", "Upgraded transaction log schema", "file", filepath.Base(filename),
+		"from", TransactionSchemaVersion(header), "to", TransactionSchemaVersion(newHeader), "rows", len(records)-1)
+	return true, nil
+}
+
+// readTransactionLogHeader returns the header row of a log, or nil if the log is missing or empty
+func readTransactionLogHeader(filename string) ([]string, error) {
+	file, err := os.Open(filename)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, nil
+	}
+	return header, err
+}
+
+// transactionHeaderIsCurrent reports whether a log header starts with the current schema's columns
+// and has a column for every configured tax component
+func transactionHeaderIsCurrent(header []string) bool {
+	if len(header) < len(TransactionCSVHeader) {
+		return false
+	}
+	for i, name := range TransactionCSVHeader {
+		if header[i] != name {
+			return false
+		}
+	}
+	for _, name := range TaxComponentNames() {
+		if !slices.Contains(header[len(TransactionCSVHeader):], TaxComponentColumn(name)) {
+			return false
+		}
+	}
+	return true
+}
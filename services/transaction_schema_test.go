@@ -0,0 +1,161 @@
+package services
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"slices"
+	"testing"
+	"time"
+
+	"checkout/config"
+	"checkout/templates"
+)
+
+// writeCSV writes a log of the given rows, header first
+func writeCSV(t *testing.T, filename string, rows ...[]string) {
+	t.Helper()
+	file, err := os.Create(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	writer := csv.NewWriter(file)
+	if err := writer.WriteAll(rows); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readCSV(t *testing.T, filename string) [][]string {
+	t.Helper()
+	file, err := os.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	rows, err := reader.ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rows
+}
+
+// rowValues returns a value for each column, named after it so a value under the wrong
+// column shows
+func rowValues(columns []string, prefix string) []string {
+	values := make([]string, len(columns))
+	for i, name := range columns {
+		values[i] = fmt.Sprintf("%s %s", prefix, name)
+	}
+	return values
+}
+
+// expectRow fails unless every column of a row holds want's value for that column name, and
+// columns want doesn't name are empty
+func expectRow(t *testing.T, header, row []string, want map[string]string) {
+	t.Helper()
+	if len(row) != len(header) {
+		t.Fatalf("row has %d columns, header %d", len(row), len(header))
+	}
+	for i, name := range header {
+		if row[i] != want[name] {
+			t.Errorf("%s = %q, want %q", name, row[i], want[name])
+		}
+	}
+}
+
+func TestAppendToLogOfPreviousSchema(t *testing.T) {
+	useTempDataDir(t)
+	day := time.Date(2026, time.March, 13, 0, 0, 0, 0, time.Local)
+	filename := TransactionLogPath(day)
+
+	// The layout before card brands, with a column since retired
+	oldHeader := append(slices.Clone(TransactionCSVHeader[:17]), "Discount")
+	oldRow := rowValues(oldHeader, "old")
+	writeCSV(t, filename, oldHeader, oldRow)
+
+	sale := templates.Transaction{
+		ID:          "pi_new",
+		Date:        "03/13/2026",
+		Time:        "10:00:00",
+		Products:    []templates.Product{{Name: "Coffee", Price: 4.50}},
+		Subtotal:    4.50,
+		Total:       4.50,
+		PaymentType: "terminal",
+		CardBrand:   "visa",
+		CardLast4:   "4242",
+	}
+	if err := saveTransactionToLog(getTransactionsDir(), day, sale); err != nil {
+		t.Fatal(err)
+	}
+
+	rows := readCSV(t, filename)
+	if len(rows) != 3 {
+		t.Fatalf("log has %d rows, want the header, the old row and the sale", len(rows))
+	}
+	wantHeader := append(transactionLogHeader(), "Discount")
+	if !slices.Equal(rows[0], wantHeader) {
+		t.Fatalf("header = %v, want %v", rows[0], wantHeader)
+	}
+
+	wantOld := make(map[string]string)
+	for i, name := range oldHeader {
+		wantOld[name] = oldRow[i]
+	}
+	expectRow(t, rows[0], rows[1], wantOld)
+
+	// The sale is logged as it would be in a new day's log, with the retired column empty
+	fresh := time.Date(2026, time.March, 14, 0, 0, 0, 0, time.Local)
+	if err := saveTransactionToLog(getTransactionsDir(), fresh, sale); err != nil {
+		t.Fatal(err)
+	}
+	freshRows := readCSV(t, TransactionLogPath(fresh))
+	wantNew := map[string]string{"Discount": ""}
+	for i, name := range freshRows[0] {
+		wantNew[name] = freshRows[1][i]
+	}
+	expectRow(t, rows[0], rows[2], wantNew)
+	if wantNew["Card Brand"] != "visa" || wantNew["Transaction ID"] != "pi_new" {
+		t.Errorf("sale logged as %v", wantNew)
+	}
+}
+
+// A row appended in the current layout under an older header keeps its tax component values
+func TestUpgradeKeepsTaxComponentColumns(t *testing.T) {
+	useTempDataDir(t)
+	config.Config.TaxCategories = []templates.TaxCategory{{ID: "local", Name: "Local", Components: []templates.TaxComponent{
+		{Name: "State", TaxRate: 0.06},
+		{Name: "County", TaxRate: 0.01},
+	}}}
+	filename := TransactionLogPath(time.Date(2026, time.March, 13, 0, 0, 0, 0, time.Local))
+
+	oldHeader := TransactionCSVHeader[:30]
+	current := transactionLogHeader()
+	if current[len(current)-1] != "Tax: County" {
+		t.Fatalf("current layout = %v, want the tax component columns last", current)
+	}
+	oldRow := rowValues(oldHeader, "old")
+	longRow := rowValues(current, "new")
+	writeCSV(t, filename, oldHeader, oldRow, longRow)
+
+	if changed, err := upgradeTransactionLog(filename); err != nil || !changed {
+		t.Fatalf("upgrade = %v, %v", changed, err)
+	}
+
+	rows := readCSV(t, filename)
+	if !slices.Equal(rows[0], current) {
+		t.Fatalf("header = %v, want %v", rows[0], current)
+	}
+	wantOld := make(map[string]string)
+	for i, name := range oldHeader {
+		wantOld[name] = oldRow[i]
+	}
+	expectRow(t, rows[0], rows[1], wantOld)
+	wantLong := make(map[string]string)
+	for i, name := range current {
+		wantLong[name] = longRow[i]
+	}
+	expectRow(t, rows[0], rows[2], wantLong)
+}
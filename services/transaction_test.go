@@ -0,0 +1,63 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"checkout/config"
+	"checkout/templates"
+)
+
+// A QR payment the customer finishes at 12:03am is logged with the night it was rung up, and the
+// first sale after the day closes starts the next day's log
+func TestSalesAfterMidnightLoggedToBusinessDay(t *testing.T) {
+	useTempDataDir(t)
+	config.Config.BusinessTimezone = "America/New_York"
+	config.Config.BusinessDayClose = "03:00"
+	newYork, err := time.LoadLocation(config.Config.BusinessTimezone)
+	if err != nil {
+		t.Skipf("timezone data unavailable: %v", err)
+	}
+
+	sales := []struct {
+		id  string
+		at  time.Time
+		day string
+	}{
+		{"pi_evening", time.Date(2026, time.March, 13, 23, 50, 0, 0, newYork), "2026-03-13"},
+		{"plink_after_midnight", time.Date(2026, time.March, 14, 0, 3, 0, 0, newYork), "2026-03-13"},
+		{"pi_before_close", time.Date(2026, time.March, 14, 2, 59, 0, 0, newYork), "2026-03-13"},
+		{"pi_after_close", time.Date(2026, time.March, 14, 3, 5, 0, 0, newYork), "2026-03-14"},
+	}
+	for _, sale := range sales {
+		transaction := templates.Transaction{
+			ID:          sale.id,
+			Date:        sale.at.Format("2006-01-02"),
+			Time:        sale.at.Format("15:04:05"),
+			Products:    []templates.Product{{Name: "Coffee", Price: 4.50}},
+			Subtotal:    4.50,
+			Total:       4.50,
+			PaymentType: "terminal",
+		}
+		if err := saveTransactionToLog(getTransactionsDir(), config.BusinessDay(sale.at), transaction); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	logged := make(map[string]string)
+	for _, day := range []string{"2026-03-13", "2026-03-14"} {
+		date, _ := time.ParseInLocation("2006-01-02", day, newYork)
+		transactions, err := LoadTransactionsForDay(date)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, transaction := range transactions {
+			logged[transaction.ID] = day
+		}
+	}
+	for _, sale := range sales {
+		if logged[sale.id] != sale.day {
+			t.Errorf("%s at %s logged on %q, want %s", sale.id, sale.at.Format("Jan 2 15:04"), logged[sale.id], sale.day)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package services
+
+import (
+	"sync"
+
+	"checkout/templates"
+)
+
+// UIContext holds the banners every POS screen shows around the sale: test and demo mode. The
+// category a register has navigated to is kept with its cart.
+type UIContext struct {
+	layout templates.LayoutContext
+	mutex  sync.RWMutex
+}
+
+// NewUIContext creates a UI context with no banners
+func NewUIContext() *UIContext {
+	return &UIContext{}
+}
+
+// Layout returns the shared state the layout templates render
+func (u *UIContext) Layout() templates.LayoutContext {
+	u.mutex.RLock()
+	defer u.mutex.RUnlock()
+	return u.layout
+}
+
+// SetTestMode sets whether the Stripe key in use is a test key
+func (u *UIContext) SetTestMode(testMode bool) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	u.layout.IsTestMode = testMode
+}
+
+// SetDemoMode sets whether payments are simulated instead of sent to Stripe
+func (u *UIContext) SetDemoMode(demoMode bool) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	u.layout.IsDemoMode = demoMode
+}
+
+// SetDemoDeclines sets whether the simulated reader declines payments
+func (u *UIContext) SetDemoDeclines(declines bool) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	u.layout.DemoDeclines = declines
+}
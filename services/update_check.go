@@ -0,0 +1,155 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"checkout/config"
+	"checkout/utils"
+	"checkout/version"
+)
+
+// How often the releases URL is checked, and how long a check may take
+const (
+	updateCheckInterval = 24 * time.Hour
+	updateCheckTimeout  = 15 * time.Second
+)
+
+// Release is a published version newer than the running one
+type Release struct {
+	Version string
+	URL     string // Release page with the changelog
+	Notes   string // First line of the changelog
+}
+
+// githubRelease is the part of a GitHub releases API entry the check reads
+type githubRelease struct {
+	TagName    string `json:"tag_name"`
+	HTMLURL    string `json:"html_url"`
+	Body       string `json:"body"`
+	Draft      bool   `json:"draft"`
+	Prerelease bool   `json:"prerelease"`
+}
+
+var (
+	availableUpdate   *Release
+	availableUpdateMu sync.RWMutex
+)
+
+// AvailableUpdate returns the newest release found by the last check, if it is newer than the
+// running version
+func AvailableUpdate() (Release, bool) {
+	availableUpdateMu.RLock()
+	defer availableUpdateMu.RUnlock()
+	if availableUpdate == nil {
+		return Release{}, false
+	}
+	return *availableUpdate, true
+}
+
+// StartUpdateCheck checks the configured releases URL for a newer version once a day. The check
+// runs in the background, so startup doesn't wait on the network, and is off when no URL is
+// configured, as on installs without internet access.
+func StartUpdateCheck() {
+	if strings.TrimSpace(config.Config.UpdateCheckURL) == "" {
+		utils.Info("update", "Update check disabled")
+		return
+	}
+	if !version.IsRelease() {
+		utils.Info("update", "Update check skipped for a development build", "version", version.Version)
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(updateCheckInterval)
+		defer ticker.Stop()
+
+		for {
+			if err := CheckForUpdate(); err != nil {
+				utils.Warn("update", "Update check failed", "url", config.Config.UpdateCheckURL, "error", err)
+			}
+			<-ticker.C
+		}
+	}()
+
+	utils.Info("update", "Update check started", "url", config.Config.UpdateCheckURL, "version", version.Version)
+}
+
+// CheckForUpdate fetches the releases URL and records the newest release if it is newer than the
+// running version. The URL may return one release (GitHub's /releases/latest) or a list of them
+// (/releases), of which drafts and pre-releases are ignored.
+func CheckForUpdate() error {
+	url := strings.TrimSpace(config.Config.UpdateCheckURL)
+	if url == "" {
+		return nil
+	}
+
+	client := &http.Client{Timeout: updateCheckTimeout}
+	request, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Accept", "application/vnd.github+json")
+	request.Header.Set("User-Agent", "checkout/"+version.Version)
+
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("releases URL returned %s", response.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(response.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+
+	var releases []githubRelease
+	if strings.HasPrefix(strings.TrimSpace(string(body)), "[") {
+		err = json.Unmarshal(body, &releases)
+	} else {
+		var release githubRelease
+		err = json.Unmarshal(body, &release)
+		releases = append(releases, release)
+	}
+	if err != nil {
+		return fmt.Errorf("error parsing releases: %w", err)
+	}
+
+	var newest *Release
+	for _, release := range releases {
+		if release.Draft || release.Prerelease || !version.Newer(release.TagName) {
+			continue
+		}
+		if newest == nil || version.Later(release.TagName, newest.Version) {
+			newest = &Release{Version: release.TagName, URL: release.HTMLURL, Notes: firstLine(release.Body)}
+		}
+	}
+
+	availableUpdateMu.Lock()
+	availableUpdate = newest
+	availableUpdateMu.Unlock()
+
+	if newest != nil {
+		utils.Info("update", "Update available", "version", version.Version, "latest", newest.Version, "url", newest.URL)
+	} else {
+		utils.Debug("update", "Running the latest version", "version", version.Version)
+	}
+	return nil
+}
+
+// firstLine returns the first non-empty line of release notes, without Markdown heading marks
+func firstLine(notes string) string {
+	for _, line := range strings.Split(notes, "\n") {
+		if line = strings.TrimSpace(strings.TrimLeft(line, "# ")); line != "" {
+			return line
+		}
+	}
+	return ""
+}
@@ -0,0 +1,110 @@
+package validation
+
+import "strings"
+
+// phoneCountry is the numbering plan of a country: its calling code, the number of digits of a
+// national number without the trunk prefix, and the trunk prefix dialed before it at home
+type phoneCountry struct {
+	code        string
+	minDigits   int
+	maxDigits   int
+	trunkPrefix string
+}
+
+// phoneCountries are the countries whose numbers can be typed without their country code
+var phoneCountries = map[string]phoneCountry{
+	"US": {"1", 10, 10, "1"},
+	"CA": {"1", 10, 10, "1"},
+	"MX": {"52", 10, 10, ""},
+	"GB": {"44", 9, 10, "0"},
+	"IE": {"353", 7, 9, "0"},
+	"FR": {"33", 9, 9, "0"},
+	"DE": {"49", 6, 13, "0"},
+	"NL": {"31", 9, 9, "0"},
+	"ES": {"34", 9, 9, ""},
+	"PT": {"351", 9, 9, ""},
+	"IT": {"39", 6, 11, ""},
+	"AU": {"61", 9, 9, "0"},
+	"NZ": {"64", 8, 10, "0"},
+	"JP": {"81", 9, 10, "0"},
+	"IN": {"91", 10, 10, "0"},
+	"BR": {"55", 10, 11, "0"},
+	"AR": {"54", 10, 11, "0"},
+	"CO": {"57", 10, 10, ""},
+	"CL": {"56", 9, 9, ""},
+}
+
+// Phone normalizes a phone number to E.164 (e.g. +15551234567). Spaces, dashes, dots and
+// parentheses are ignored. A number starting with + or 00 is international; any other is taken
+// as a national number of defaultCountry (a two-letter country code, "" = US). The number of
+// digits is checked against the country's numbering plan, or against E.164's limits for
+// countries not listed.
+func Phone(number, defaultCountry string) (string, error) {
+	number = strings.TrimSpace(number)
+	if number == "" {
+		return "", &Error{Key: "validation.phone_required"}
+	}
+	typed := number
+
+	international := false
+	switch {
+	case strings.HasPrefix(number, "+"):
+		international, number = true, number[1:]
+	case strings.HasPrefix(number, "00"):
+		international, number = true, number[2:]
+	}
+	var digits strings.Builder
+	for _, r := range number {
+		switch {
+		case r >= '0' && r <= '9':
+			digits.WriteRune(r)
+		case r == ' ' || r == '-' || r == '.' || r == '(' || r == ')':
+		default:
+			return "", &Error{Key: "validation.phone_invalid", Args: []interface{}{typed}}
+		}
+	}
+	national := digits.String()
+
+	if international {
+		return internationalPhone(national, typed)
+	}
+
+	if defaultCountry == "" {
+		defaultCountry = "US"
+	}
+	country, ok := phoneCountries[strings.ToUpper(defaultCountry)]
+	if !ok {
+		return "", &Error{Key: "validation.phone_needs_country"}
+	}
+	if country.trunkPrefix != "" && strings.HasPrefix(national, country.trunkPrefix) && len(national)-len(country.trunkPrefix) >= country.minDigits {
+		national = national[len(country.trunkPrefix):]
+	}
+	if len(national) < country.minDigits || len(national) > country.maxDigits {
+		return "", &Error{Key: "validation.phone_length", Args: []interface{}{typed}}
+	}
+	return "+" + country.code + national, nil
+}
+
+// internationalPhone checks the digits of a number given with its country code
+func internationalPhone(digits, typed string) (string, error) {
+	// E.164 numbers have at most 15 digits, country code included; the shortest in use have 7
+	if len(digits) < 7 || len(digits) > 15 || digits[0] == '0' {
+		return "", &Error{Key: "validation.phone_length", Args: []interface{}{typed}}
+	}
+
+	// Calling codes are prefix-free, so at most one of a number's 1 to 3 digit prefixes is a code
+	for length := 1; length <= 3; length++ {
+		code := digits[:length]
+		for _, country := range phoneCountries {
+			if country.code != code {
+				continue
+			}
+			national := digits[length:]
+			if len(national) < country.minDigits || len(national) > country.maxDigits {
+				return "", &Error{Key: "validation.phone_length", Args: []interface{}{typed}}
+			}
+			return "+" + digits, nil
+		}
+	}
+	return "+" + digits, nil
+}
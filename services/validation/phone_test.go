@@ -0,0 +1,56 @@
+package validation
+
+import "testing"
+
+func TestPhone(t *testing.T) {
+	tests := []struct {
+		name           string
+		number         string
+		defaultCountry string
+		want           string
+		wantKey        string
+	}{
+		{"US national", "555-123-4567", "US", "+15551234567", ""},
+		{"US formatted", "(555) 123.4567", "", "+15551234567", ""},
+		{"US with trunk prefix", "1 555 123 4567", "US", "+15551234567", ""},
+		{"default country is US", "5551234567", "", "+15551234567", ""},
+		{"country code in lower case", "5551234567", "ca", "+15551234567", ""},
+		{"UK national with trunk zero", "07700 900123", "GB", "+447700900123", ""},
+		{"UK landline", "020 7946 0018", "GB", "+442079460018", ""},
+		{"France national", "06 12 34 56 78", "FR", "+33612345678", ""},
+		{"Germany short", "030 123456", "DE", "+4930123456", ""},
+		{"Spain has no trunk prefix", "612 345 678", "ES", "+34612345678", ""},
+		{"Australia mobile", "0412 345 678", "AU", "+61412345678", ""},
+		{"Brazil mobile", "(11) 91234-5678", "BR", "+5511912345678", ""},
+		{"international with plus", "+44 7700 900123", "US", "+447700900123", ""},
+		{"international with 00", "0033 6 12 34 56 78", "US", "+33612345678", ""},
+		{"international, three-digit code", "+353 87 123 4567", "", "+353871234567", ""},
+		{"international, country not listed", "+81 90 1234 5678", "", "+819012345678", ""},
+		{"international, unlisted calling code", "+254 712 345678", "", "+254712345678", ""},
+		{"international overrides the default country", "+1 555 123 4567", "GB", "+15551234567", ""},
+		{"empty", "", "US", "", "validation.phone_required"},
+		{"blank", "  ", "US", "", "validation.phone_required"},
+		{"letters", "555-CALL-NOW", "US", "", "validation.phone_invalid"},
+		{"extension", "555-123-4567 x12", "US", "", "validation.phone_invalid"},
+		{"plus inside", "555+1234567", "US", "", "validation.phone_invalid"},
+		{"US too short", "555-12", "US", "", "validation.phone_length"},
+		{"US too long", "555 123 45678", "US", "", "validation.phone_length"},
+		{"France too short", "06 12 34 56", "FR", "", "validation.phone_length"},
+		{"international too short", "+44 123", "", "", "validation.phone_length"},
+		{"international too long", "+1 555 123 4567 8901", "", "", "validation.phone_length"},
+		{"international, wrong length for its country", "+44 7700 9001", "", "", "validation.phone_length"},
+		{"international starting with zero", "+0 555 123 4567", "", "", "validation.phone_length"},
+		{"unsupported default country", "5551234567", "ZZ", "", "validation.phone_needs_country"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Phone(tt.number, tt.defaultCountry)
+			if key := errorKey(t, err); key != tt.wantKey {
+				t.Fatalf("Phone(%q, %q) error = %v (%s), want %s", tt.number, tt.defaultCountry, err, key, tt.wantKey)
+			}
+			if got != tt.want {
+				t.Errorf("Phone(%q, %q) = %q, want %q", tt.number, tt.defaultCountry, got, tt.want)
+			}
+		})
+	}
+}
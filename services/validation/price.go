@@ -0,0 +1,34 @@
+package validation
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	"checkout/i18n"
+)
+
+// Price parses a price typed at the register, e.g. "12.50". The price must be a number of
+// whole cents, not negative, and no larger than max (0 = no limit), so a price typed into the
+// wrong field is caught before it reaches Stripe.
+func Price(text string, max float64) (float64, error) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return 0, &Error{Key: "validation.price_required"}
+	}
+
+	price, err := strconv.ParseFloat(text, 64)
+	if err != nil || math.IsNaN(price) || math.IsInf(price, 0) {
+		return 0, &Error{Key: "validation.price_invalid", Args: []interface{}{text}}
+	}
+	if price < 0 {
+		return 0, &Error{Key: "validation.price_negative"}
+	}
+	if max > 0 && price > max {
+		return 0, &Error{Key: "validation.price_too_large", Args: []interface{}{i18n.Money(max)}}
+	}
+	if _, fraction, found := strings.Cut(text, "."); found && len(strings.TrimRight(fraction, "0")) > 2 {
+		return 0, &Error{Key: "validation.price_cents"}
+	}
+	return price, nil
+}
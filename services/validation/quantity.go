@@ -0,0 +1,32 @@
+package validation
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Quantity parses the measured quantity of a product sold by weight or length, e.g. "1.25".
+// The quantity must be positive, no larger than max, and have at most decimals decimal places,
+// so the cashier is asked again rather than a mistyped scale reading being charged.
+func Quantity(text string, decimals int, max float64) (float64, error) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return 0, &Error{Key: "validation.quantity_required"}
+	}
+
+	quantity, err := strconv.ParseFloat(text, 64)
+	if err != nil || math.IsNaN(quantity) || math.IsInf(quantity, 0) {
+		return 0, &Error{Key: "validation.quantity_invalid", Args: []interface{}{text}}
+	}
+	if quantity <= 0 {
+		return 0, &Error{Key: "validation.quantity_positive"}
+	}
+	if quantity > max {
+		return 0, &Error{Key: "validation.quantity_too_large", Args: []interface{}{strconv.FormatFloat(max, 'f', -1, 64)}}
+	}
+	if _, fraction, found := strings.Cut(text, "."); found && len(strings.TrimRight(fraction, "0")) > decimals {
+		return 0, &Error{Key: "validation.quantity_decimals", Args: []interface{}{decimals}}
+	}
+	return quantity, nil
+}
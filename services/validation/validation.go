@@ -0,0 +1,109 @@
+// Package validation checks and normalizes the customer contact details typed at the register,
+// so a mistyped receipt address is caught while the customer is still there rather than when
+// the email or SMS provider rejects it.
+package validation
+
+import (
+	"net/mail"
+	"strings"
+
+	"checkout/i18n"
+)
+
+// Error is a rejected input. Its message is the translated text shown to the cashier.
+type Error struct {
+	Key  string
+	Args []interface{}
+}
+
+func (e *Error) Error() string {
+	return i18n.T(e.Key, e.Args...)
+}
+
+// typoDomains maps misspellings of common email providers to the domain meant
+var typoDomains = map[string]string{
+	"gmial.com":   "gmail.com",
+	"gmai.com":    "gmail.com",
+	"gmal.com":    "gmail.com",
+	"gamil.com":   "gmail.com",
+	"gnail.com":   "gmail.com",
+	"gmaill.com":  "gmail.com",
+	"gmail.co":    "gmail.com",
+	"gmail.con":   "gmail.com",
+	"gmail.cm":    "gmail.com",
+	"hotmial.com": "hotmail.com",
+	"hotmai.com":  "hotmail.com",
+	"hotmal.com":  "hotmail.com",
+	"hotmail.co":  "hotmail.com",
+	"hotmail.con": "hotmail.com",
+	"yaho.com":    "yahoo.com",
+	"yahooo.com":  "yahoo.com",
+	"yahoo.con":   "yahoo.com",
+	"outlok.com":  "outlook.com",
+	"outloo.com":  "outlook.com",
+	"outlook.con": "outlook.com",
+	"iclod.com":   "icloud.com",
+	"icloud.co":   "icloud.com",
+	"icloud.con":  "icloud.com",
+}
+
+// Email checks an email address and returns it trimmed, with its domain lowercased. Besides the
+// RFC 5322 syntax, the domain must have a dot and must not be a known misspelling of a common
+// provider; extraTypos adds misspellings (typo domain to intended domain) to the built-in ones.
+// The error of a misspelled domain suggests the domain meant.
+func Email(address string, extraTypos map[string]string) (string, error) {
+	address = strings.TrimSpace(address)
+	if address == "" {
+		return "", &Error{Key: "validation.email_required"}
+	}
+
+	// ParseAddress also accepts "Name <address>" forms, which are no use as a receipt address
+	parsed, err := mail.ParseAddress(address)
+	if err != nil || parsed.Address != address || parsed.Name != "" {
+		return "", &Error{Key: "validation.email_invalid", Args: []interface{}{address}}
+	}
+
+	at := strings.LastIndex(address, "@")
+	local, domain := address[:at], strings.ToLower(address[at+1:])
+	if suggestion, ok := suggestDomain(domain, extraTypos); ok {
+		return "", &Error{Key: "validation.email_typo", Args: []interface{}{address, local + "@" + suggestion}}
+	}
+	labels := strings.Split(domain, ".")
+	if len(labels) < 2 || len(labels[len(labels)-1]) < 2 {
+		return "", &Error{Key: "validation.email_no_domain", Args: []interface{}{address}}
+	}
+	for _, label := range labels {
+		if label == "" || strings.HasPrefix(label, "-") || strings.HasSuffix(label, "-") {
+			return "", &Error{Key: "validation.email_invalid", Args: []interface{}{address}}
+		}
+	}
+	return local + "@" + domain, nil
+}
+
+// suggestDomain returns the domain meant by a misspelled one. A domain missing its ending, such
+// as "gmial", is matched as if it ended in .com.
+func suggestDomain(domain string, extraTypos map[string]string) (string, bool) {
+	for _, candidate := range []string{domain, domain + ".com"} {
+		if suggestion, ok := extraTypos[candidate]; ok {
+			return suggestion, true
+		}
+		if suggestion, ok := typoDomains[candidate]; ok {
+			return suggestion, true
+		}
+		// A common provider typed without its ending
+		if candidate != domain && isProviderDomain(candidate) {
+			return candidate, true
+		}
+	}
+	return "", false
+}
+
+// isProviderDomain reports whether a domain is one of the common providers typos are corrected to
+func isProviderDomain(domain string) bool {
+	for _, intended := range typoDomains {
+		if intended == domain {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,96 @@
+package validation
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// errorKey is the translation key of a validation error, or "" for nil
+func errorKey(t *testing.T, err error) string {
+	t.Helper()
+	if err == nil {
+		return ""
+	}
+	var validationErr *Error
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("error %v isn't a validation error", err)
+	}
+	return validationErr.Key
+}
+
+func TestEmail(t *testing.T) {
+	tests := []struct {
+		name    string
+		address string
+		want    string
+		wantKey string
+	}{
+		{"plain", "bob@example.com", "bob@example.com", ""},
+		{"trimmed", "  bob@example.com\t", "bob@example.com", ""},
+		{"domain lowercased, local part kept", "Bob.Smith@Example.COM", "Bob.Smith@example.com", ""},
+		{"plus tag", "bob+receipts@example.com", "bob+receipts@example.com", ""},
+		{"subdomain", "ops@mail.shop.example.co.uk", "ops@mail.shop.example.co.uk", ""},
+		{"hyphenated domain", "bob@my-shop.example", "bob@my-shop.example", ""},
+		{"digits in the top-level label", "bob@example.a1", "bob@example.a1", ""},
+		{"empty", "", "", "validation.email_required"},
+		{"blank", "   ", "", "validation.email_required"},
+		{"no at sign", "bob.example.com", "", "validation.email_invalid"},
+		{"two at signs", "bob@@example.com", "", "validation.email_invalid"},
+		{"no local part", "@example.com", "", "validation.email_invalid"},
+		{"space inside", "bob smith@example.com", "", "validation.email_invalid"},
+		{"display name form", "Bob <bob@example.com>", "", "validation.email_invalid"},
+		{"trailing dot", "bob@example.com.", "", "validation.email_invalid"},
+		{"double dot in the domain", "bob@example..com", "", "validation.email_invalid"},
+		{"label starts with a hyphen", "bob@-example.com", "", "validation.email_invalid"},
+		{"label ends with a hyphen", "bob@example-.com", "", "validation.email_invalid"},
+		{"no dot in the domain", "bob@localhost", "", "validation.email_no_domain"},
+		{"one-letter top-level label", "bob@example.c", "", "validation.email_no_domain"},
+		{"misspelled provider", "bob@gmial.com", "", "validation.email_typo"},
+		{"misspelled ending", "bob@hotmail.con", "", "validation.email_typo"},
+		{"misspelling in capitals", "bob@GMIAL.COM", "", "validation.email_typo"},
+		{"provider without its ending", "bob@gmail", "", "validation.email_typo"},
+		{"misspelled provider without its ending", "bob@gmial", "", "validation.email_typo"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Email(tt.address, nil)
+			if key := errorKey(t, err); key != tt.wantKey {
+				t.Fatalf("Email(%q) error = %v (%s), want %s", tt.address, err, key, tt.wantKey)
+			}
+			if got != tt.want {
+				t.Errorf("Email(%q) = %q, want %q", tt.address, got, tt.want)
+			}
+		})
+	}
+}
+
+// The error for a misspelled domain suggests the address the customer meant
+func TestEmailTypoSuggestion(t *testing.T) {
+	tests := []struct {
+		name       string
+		address    string
+		extraTypos map[string]string
+		suggestion string
+	}{
+		{"built-in", "bob@gmial.com", nil, "bob@gmail.com"},
+		{"without an ending", "bob@yahoo", nil, "bob@yahoo.com"},
+		{"configured", "bob@protonmial.com", map[string]string{"protonmial.com": "protonmail.com"}, "bob@protonmail.com"},
+		{"configured overrides built-in", "bob@gmai.com", map[string]string{"gmai.com": "gmx.com"}, "bob@gmx.com"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Email(tt.address, tt.extraTypos)
+			var validationErr *Error
+			if !errors.As(err, &validationErr) || validationErr.Key != "validation.email_typo" {
+				t.Fatalf("Email(%q) error = %v, want a typo", tt.address, err)
+			}
+			if len(validationErr.Args) != 2 || validationErr.Args[1] != tt.suggestion {
+				t.Errorf("suggestion args = %v, want %s", validationErr.Args, tt.suggestion)
+			}
+			if !strings.Contains(err.Error(), tt.suggestion) {
+				t.Errorf("message %q doesn't suggest %s", err.Error(), tt.suggestion)
+			}
+		})
+	}
+}
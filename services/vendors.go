@@ -0,0 +1,154 @@
+package services
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+
+	"github.com/stripe/stripe-go/v74"
+
+	"checkout/config"
+	"checkout/templates"
+)
+
+// VendorsEnabled reports whether market vendors are configured. Without vendors every payment
+// goes to the business's own Stripe account as before.
+func VendorsEnabled() bool {
+	return len(config.Config.Vendors) > 0
+}
+
+// FindVendor returns the configured vendor with the given ID
+func FindVendor(id string) (templates.Vendor, bool) {
+	for _, vendor := range config.Config.Vendors {
+		if vendor.ID == id {
+			return vendor, true
+		}
+	}
+	return templates.Vendor{}, false
+}
+
+// VendorName returns a vendor's name for reports and messages: its configured name, the ID of a
+// vendor no longer configured, or the business name for the business's own items
+func VendorName(id string) string {
+	if id == "" {
+		if config.Config.BusinessName != "" {
+			return config.Config.BusinessName
+		}
+		return "No vendor"
+	}
+	if vendor, found := FindVendor(id); found && vendor.Name != "" {
+		return vendor.Name
+	}
+	return id
+}
+
+// vendorByAccount returns the ID of the vendor paid out to a connected account, or the account
+// itself when no configured vendor has it any more
+func vendorByAccount(accountID string) string {
+	for _, vendor := range config.Config.Vendors {
+		if vendor.AccountID == accountID {
+			return vendor.ID
+		}
+	}
+	return accountID
+}
+
+// MixedVendorsError is returned for a card payment of a cart holding items of more than one
+// vendor, or of a vendor and the business, which a single destination charge can't pay out
+type MixedVendorsError struct {
+	Vendors []string // Names of the vendors in the cart (see VendorName)
+}
+
+func (e *MixedVendorsError) Error() string {
+	return fmt.Sprintf("the cart has items from more than one vendor (%s); check out each vendor's items separately", strings.Join(e.Vendors, ", "))
+}
+
+// CartVendor returns the vendor whose connected account a card payment for the cart is paid out
+// to. It returns no vendor when vendors aren't configured or the cart holds only the business's
+// items, a *MixedVendorsError when the items belong to several, and an error when an item's
+// vendor isn't configured or has no connected account.
+func CartVendor(items []templates.Product) (templates.Vendor, error) {
+	if !VendorsEnabled() {
+		return templates.Vendor{}, nil
+	}
+
+	var ids []string
+	for _, item := range items {
+		if !slices.Contains(ids, item.Vendor) {
+			ids = append(ids, item.Vendor)
+		}
+	}
+	if len(ids) > 1 {
+		names := make([]string, len(ids))
+		for i, id := range ids {
+			names[i] = VendorName(id)
+		}
+		return templates.Vendor{}, &MixedVendorsError{Vendors: names}
+	}
+	if len(ids) == 0 || ids[0] == "" {
+		return templates.Vendor{}, nil
+	}
+
+	vendor, found := FindVendor(ids[0])
+	if !found {
+		return templates.Vendor{}, fmt.Errorf("vendor %q of the cart's items isn't configured", ids[0])
+	}
+	if !strings.HasPrefix(vendor.AccountID, "acct_") {
+		return templates.Vendor{}, fmt.Errorf("vendor %s has no Stripe connected account (acct_...)", VendorName(vendor.ID))
+	}
+	return vendor, nil
+}
+
+// vendorApplicationFee returns the application fee in cents the market keeps from a vendor
+// payment of amount cents
+func vendorApplicationFee(amount int64) int64 {
+	percent := math.Min(math.Max(config.Config.VendorApplicationFeePercent, 0), 100)
+	return int64(math.Round(float64(amount) * percent / 100))
+}
+
+// RouteToVendor makes a PaymentIntent for the cart a destination charge to the cart's vendor,
+// keeping the configured application fee. Carts without a vendor are left alone.
+func RouteToVendor(params *stripe.PaymentIntentParams, items []templates.Product) error {
+	vendor, err := CartVendor(items)
+	if err != nil || vendor.ID == "" {
+		return err
+	}
+	params.TransferData = &stripe.PaymentIntentTransferDataParams{Destination: stripe.String(vendor.AccountID)}
+	if fee := vendorApplicationFee(stripe.Int64Value(params.Amount)); fee > 0 {
+		params.ApplicationFeeAmount = stripe.Int64(fee)
+	}
+	return nil
+}
+
+// routeLinkToVendor makes a payment link for the cart pay out to the cart's vendor, keeping the
+// configured application fee of the link's total in cents
+func routeLinkToVendor(params *stripe.PaymentLinkParams, items []templates.Product, total int64) error {
+	vendor, err := CartVendor(items)
+	if err != nil || vendor.ID == "" {
+		return err
+	}
+	params.TransferData = &stripe.PaymentLinkTransferDataParams{Destination: stripe.String(vendor.AccountID)}
+	if fee := vendorApplicationFee(total); fee > 0 {
+		params.ApplicationFeeAmount = stripe.Int64(fee)
+	}
+	return nil
+}
+
+// payoutAccount returns the connected account a PaymentIntent was transferred to, if any
+func payoutAccount(intent *stripe.PaymentIntent) string {
+	if intent == nil || intent.TransferData == nil || intent.TransferData.Destination == nil {
+		return ""
+	}
+	return intent.TransferData.Destination.ID
+}
+
+// reverseVendorTransfer makes a refund of a vendor's destination charge take the money back from
+// the vendor and return the market's application fee in proportion
+func reverseVendorTransfer(params *stripe.RefundParams, intent *stripe.PaymentIntent) {
+	if payoutAccount(intent) == "" {
+		return
+	}
+	params.ReverseTransfer = stripe.Bool(true)
+	params.RefundApplicationFee = stripe.Bool(true)
+}
@@ -0,0 +1,265 @@
+package services
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/stripe/stripe-go/v74"
+	"github.com/stripe/stripe-go/v74/webhookendpoint"
+
+	"checkout/config"
+	"checkout/utils"
+)
+
+// webhookPath is the path of the POS's webhook handler, which identifies its endpoints on Stripe
+const webhookPath = "/stripe-webhook"
+
+// WebhookConfig is the consistency of the settings that decide how the POS hears the outcome of a
+// payment: the website name, the webhook signing secret and the endpoint registered with Stripe.
+// A partial setup quietly leaves payments to complete by polling, which is slower.
+type WebhookConfig struct {
+	Strategy           string    `json:"strategy"`               // "webhooks" or "polling"
+	Reasons            []string  `json:"reasons"`                // Why that strategy was chosen
+	Missing            []string  `json:"missing,omitempty"`      // What the strategy needs and doesn't have
+	Ignored            []string  `json:"ignored,omitempty"`      // Settings made that the strategy doesn't use
+	EndpointRegistered bool      `json:"endpoint_registered"`    // An enabled endpoint on Stripe has ExpectedURL
+	EndpointURL        string    `json:"endpoint_url,omitempty"` // The POS's endpoint on Stripe, matching or not
+	ExpectedURL        string    `json:"expected_url,omitempty"` // Where Stripe should send events ("" = no endpoint needed)
+	Error              string    `json:"error,omitempty"`        // Stripe's endpoints couldn't be listed
+	CheckedAt          time.Time `json:"checked_at"`
+}
+
+// Consistent reports whether the webhook settings agree with each other
+func (c WebhookConfig) Consistent() bool {
+	return len(c.Missing) == 0 && len(c.Ignored) == 0
+}
+
+// CanFixRegistration reports whether registering a new endpoint for the website name would fix
+// the configuration
+func (c WebhookConfig) CanFixRegistration() bool {
+	return c.ExpectedURL != "" && c.Error == "" && !c.EndpointRegistered
+}
+
+// Problems returns the missing and ignored pieces together, as one-line descriptions
+func (c WebhookConfig) Problems() []string {
+	problems := make([]string, 0, len(c.Missing)+len(c.Ignored))
+	for _, missing := range c.Missing {
+		problems = append(problems, "missing "+missing)
+	}
+	return append(problems, c.Ignored...)
+}
+
+// webhookConfig holds the last check, and the problems an admin dismissed from the POS banner
+var webhookConfig = struct {
+	last      WebhookConfig
+	dismissed string
+	mutex     sync.Mutex
+}{}
+
+// WebhookConfigStatus returns the last webhook configuration check, checking now if there has been none
+func WebhookConfigStatus() WebhookConfig {
+	webhookConfig.mutex.Lock()
+	last := webhookConfig.last
+	webhookConfig.mutex.Unlock()
+
+	if last.CheckedAt.IsZero() {
+		return CheckWebhookConfig()
+	}
+	return last
+}
+
+// CheckWebhookConfig checks that the website name, signing secret and registered endpoint agree
+// on a strategy, asking Stripe for its endpoints. It runs after the startup checks and whenever
+// a setting it depends on changes; the result is kept for the settings page, POS banner and /healthz.
+func CheckWebhookConfig() WebhookConfig {
+	status := checkWebhookSettings()
+	if status.ExpectedURL != "" {
+		findWebhookEndpoint(&status)
+	}
+	status.CheckedAt = time.Now()
+
+	if !status.Consistent() {
+		utils.Warn("communication", "Webhook configuration is incomplete", "strategy", status.Strategy, "problems", strings.Join(status.Problems(), "; "))
+	}
+
+	webhookConfig.mutex.Lock()
+	webhookConfig.last = status
+	webhookConfig.mutex.Unlock()
+	return status
+}
+
+// checkWebhookSettings derives the strategy and what it lacks from the settings alone
+func checkWebhookSettings() WebhookConfig {
+	status := WebhookConfig{Strategy: config.GetCommunicationStrategy()}
+	websiteName := strings.TrimSpace(config.Config.WebsiteName)
+	secretSet := len(config.GetStripeWebhookSecrets()) > 0
+	secretSource := "Stripe Webhook Secret"
+	if os.Getenv("STRIPE_WEBHOOK_SECRET") != "" {
+		secretSource = "STRIPE_WEBHOOK_SECRET"
+	}
+
+	switch {
+	case config.Config.DemoMode:
+		status.Reasons = append(status.Reasons, "Demo mode simulates payments, which never produce webhooks")
+
+	case config.WebhookDevForwarding():
+		status.Reasons = append(status.Reasons, "Force Webhook Strategy is on with a localhost website name: events are forwarded by stripe listen and no endpoint is registered")
+		if !secretSet {
+			status.Missing = append(status.Missing, "the signing secret stripe listen prints, as the Stripe Webhook Secret or in STRIPE_WEBHOOK_SECRET")
+		}
+
+	case status.Strategy == "webhooks":
+		status.Reasons = append(status.Reasons, fmt.Sprintf("Website Name %s is a public domain Stripe can send events to", websiteName))
+		status.ExpectedURL = webhookEndpointURL()
+		if !secretSet {
+			status.Missing = append(status.Missing, "a Stripe Webhook Secret (whsec_...): without it every event is rejected and payments complete only by polling")
+		}
+
+	default:
+		if websiteName == "" {
+			status.Reasons = append(status.Reasons, "No Website Name is set, so Stripe can't reach this POS")
+		} else {
+			status.Reasons = append(status.Reasons, "Website Name is localhost, so Stripe can't reach this POS")
+		}
+		if secretSet {
+			status.Ignored = append(status.Ignored, fmt.Sprintf("%s is set but not used: set Website Name to the POS's public domain to use webhooks, or turn on Force Webhook Strategy to take events forwarded by stripe listen", secretSource))
+		}
+	}
+	return status
+}
+
+// findWebhookEndpoint looks on Stripe for an enabled endpoint at the expected URL, or else for the
+// POS's endpoint at another domain, such as one registered before the website name changed.
+// When Stripe can't be asked, the endpoint registered at startup is used.
+func findWebhookEndpoint(status *WebhookConfig) {
+	if stripe.Key == "" {
+		status.Error = "no Stripe secret key"
+	} else {
+		endpoints := webhookendpoint.List(&stripe.WebhookEndpointListParams{})
+		for endpoints.Next() {
+			endpoint := endpoints.WebhookEndpoint()
+			if endpoint.Status != "enabled" || !isPOSWebhookURL(endpoint.URL) {
+				continue
+			}
+			if endpoint.URL == status.ExpectedURL {
+				status.EndpointRegistered, status.EndpointURL = true, endpoint.URL
+				break
+			}
+			status.EndpointURL = endpoint.URL
+		}
+		if err := endpoints.Err(); err != nil {
+			utils.Warn("communication", "Error listing webhook endpoints", "error", err)
+			status.Error = err.Error()
+		}
+	}
+
+	if status.Error != "" {
+		webhookHealth.mutex.Lock()
+		status.EndpointURL = webhookHealth.endpointURL
+		webhookHealth.mutex.Unlock()
+		status.EndpointRegistered = status.EndpointURL == status.ExpectedURL
+	}
+
+	switch {
+	case status.EndpointRegistered:
+	case status.EndpointURL != "":
+		status.Missing = append(status.Missing, fmt.Sprintf("a webhook endpoint for %s: the one registered, %s, doesn't match the Website Name", status.ExpectedURL, status.EndpointURL))
+	default:
+		status.Missing = append(status.Missing, fmt.Sprintf("a webhook endpoint for %s registered with Stripe", status.ExpectedURL))
+	}
+}
+
+// isPOSWebhookURL reports whether an endpoint URL points at a POS webhook handler
+func isPOSWebhookURL(endpointURL string) bool {
+	parsed, err := url.Parse(endpointURL)
+	return err == nil && parsed.Path == webhookPath
+}
+
+// FixWebhookRegistration registers a webhook endpoint for the current website name, disables the
+// POS's endpoints at other domains so Stripe stops sending events where the POS no longer is, and
+// saves the new endpoint's signing secret. It returns what was done, for the settings page.
+func FixWebhookRegistration(username string) (string, error) {
+	status := CheckWebhookConfig()
+	if !status.CanFixRegistration() {
+		if status.Error != "" {
+			return "", fmt.Errorf("can't check Stripe's webhook endpoints: %s", status.Error)
+		}
+		return "", fmt.Errorf("nothing to fix: webhook registration is only needed with a public Website Name and isn't missing")
+	}
+
+	endpoint, err := createWebhookEndpoint(status.ExpectedURL)
+	if err != nil {
+		return "", fmt.Errorf("error registering webhook endpoint: %w", err)
+	}
+	RecordWebhookEndpoint(endpoint.ID, endpoint.URL)
+	utils.Info("audit", "Webhook endpoint registered", "url", endpoint.URL, "endpoint_id", endpoint.ID, "user", username)
+
+	// Stale endpoints are disabled rather than deleted, so they can be turned back on in the Dashboard
+	disabled := 0
+	endpoints := webhookendpoint.List(&stripe.WebhookEndpointListParams{})
+	for endpoints.Next() {
+		stale := endpoints.WebhookEndpoint()
+		if stale.ID == endpoint.ID || stale.Status != "enabled" || !isPOSWebhookURL(stale.URL) || stale.URL == endpoint.URL {
+			continue
+		}
+		if _, err := webhookendpoint.Update(stale.ID, &stripe.WebhookEndpointParams{Disabled: stripe.Bool(true)}); err != nil {
+			utils.Warn("communication", "Error disabling stale webhook endpoint", "url", stale.URL, "endpoint_id", stale.ID, "error", err)
+			continue
+		}
+		disabled++
+		utils.Info("audit", "Stale webhook endpoint disabled", "url", stale.URL, "endpoint_id", stale.ID, "user", username)
+	}
+	if err := endpoints.Err(); err != nil {
+		utils.Warn("communication", "Error listing webhook endpoints", "error", err)
+	}
+
+	message := fmt.Sprintf("Registered %s", endpoint.URL)
+	if disabled > 0 {
+		message += fmt.Sprintf(" and disabled %d old endpoints", disabled)
+	}
+
+	// Events from the new endpoint are signed with its own secret
+	if os.Getenv("STRIPE_WEBHOOK_SECRET") != "" {
+		message += ". Set STRIPE_WEBHOOK_SECRET to the new endpoint's signing secret from the Stripe Dashboard and restart"
+	} else if err := config.UpdateConfigField("StripeWebhookSecret", endpoint.Secret); err != nil {
+		utils.Error("communication", "Error saving webhook signing secret", "endpoint_id", endpoint.ID, "error", err)
+		message += ". Its signing secret couldn't be saved: copy it from the Stripe Dashboard into Stripe Webhook Secret"
+	} else {
+		message += " and saved its signing secret"
+	}
+
+	CheckWebhookConfig()
+	return message, nil
+}
+
+// ShowWebhookConfigAlert reports whether to show admins the POS banner about the webhook
+// configuration: it is inconsistent and an admin hasn't dismissed these problems
+func ShowWebhookConfigAlert() bool {
+	status := WebhookConfigStatus()
+	webhookConfig.mutex.Lock()
+	defer webhookConfig.mutex.Unlock()
+	return !status.Consistent() && strings.Join(status.Problems(), "\n") != webhookConfig.dismissed
+}
+
+// DismissWebhookConfigAlert hides the POS banner until the webhook configuration has other problems
+func DismissWebhookConfigAlert() {
+	status := WebhookConfigStatus()
+	webhookConfig.mutex.Lock()
+	webhookConfig.dismissed = strings.Join(status.Problems(), "\n")
+	webhookConfig.mutex.Unlock()
+}
+
+// CheckWebhookConfigHealth warns when the webhook settings are only partly made. Payments still
+// complete by polling, so it is a warning rather than a failure.
+func CheckWebhookConfigHealth() HealthCheck {
+	status := WebhookConfigStatus()
+	check := HealthCheck{OK: true, CheckedAt: status.CheckedAt}
+	if !status.Consistent() {
+		check.Warning = strings.Join(status.Problems(), "; ")
+	}
+	return check
+}
@@ -0,0 +1,102 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"checkout/config"
+	"checkout/utils"
+)
+
+// WebhookEventTTL is how long processed webhook event IDs are remembered.
+// Stripe retries failed deliveries for up to three days.
+const WebhookEventTTL = 72 * time.Hour
+
+// processedWebhookEvents tracks recently processed webhook event IDs (event ID -> processing time)
+var processedWebhookEvents = struct {
+	seen   map[string]time.Time
+	loaded bool
+	mutex  sync.Mutex
+}{
+	seen: make(map[string]time.Time),
+}
+
+// MarkWebhookEventProcessed records a webhook event ID as processed.
+// It returns false if the event was already processed within WebhookEventTTL.
+func MarkWebhookEventProcessed(eventID string) bool {
+	processedWebhookEvents.mutex.Lock()
+	defer processedWebhookEvents.mutex.Unlock()
+
+	// Load persisted IDs on first use (config is not loaded yet at package init)
+	if !processedWebhookEvents.loaded {
+		if err := loadProcessedWebhookEvents(); err != nil {
+			utils.Error("webhook", "Error loading processed webhook events", "error", err)
+		}
+		processedWebhookEvents.loaded = true
+	}
+
+	now := time.Now()
+	if seenAt, exists := processedWebhookEvents.seen[eventID]; exists && now.Sub(seenAt) < WebhookEventTTL {
+		return false
+	}
+
+	processedWebhookEvents.seen[eventID] = now
+
+	// Drop expired IDs before persisting to keep the file small
+	for id, seenAt := range processedWebhookEvents.seen {
+		if now.Sub(seenAt) >= WebhookEventTTL {
+			delete(processedWebhookEvents.seen, id)
+		}
+	}
+
+	if err := saveProcessedWebhookEvents(); err != nil {
+		utils.Error("webhook", "Error saving processed webhook events", "event_id", eventID, "error", err)
+	}
+
+	return true
+}
+
+// loadProcessedWebhookEvents reads persisted event IDs from the data directory
+func loadProcessedWebhookEvents() error {
+	data, err := os.ReadFile(getWebhookEventsFilePath())
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("error reading webhook events file: %w", err)
+	}
+
+	if err := json.Unmarshal(data, &processedWebhookEvents.seen); err != nil {
+		return fmt.Errorf("error parsing webhook events file: %w", err)
+	}
+	return nil
+}
+
+// saveProcessedWebhookEvents writes the current event IDs to the data directory
+func saveProcessedWebhookEvents() error {
+	jsonData, err := json.Marshal(processedWebhookEvents.seen)
+	if err != nil {
+		return fmt.Errorf("error marshaling webhook events: %w", err)
+	}
+
+	path := getWebhookEventsFilePath()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("error creating data directory: %w", err)
+	}
+
+	if err := os.WriteFile(path, jsonData, 0644); err != nil {
+		return fmt.Errorf("error writing webhook events file: %w", err)
+	}
+	return nil
+}
+
+func getWebhookEventsFilePath() string {
+	dataDir := config.Config.DataDir
+	if dataDir == "" {
+		dataDir = config.DefaultDataDir
+	}
+	return filepath.Join(dataDir, "webhook-events.json")
+}
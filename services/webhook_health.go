@@ -0,0 +1,267 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"checkout/config"
+	"checkout/utils"
+
+	"github.com/stripe/stripe-go/v74"
+)
+
+// webhookTestAmount is the amount of the PaymentIntent created and canceled to test webhook delivery
+const webhookTestAmount = 0.50
+
+// WebhookHealth is the webhook delivery seen by the POS, for the settings panel
+type WebhookHealth struct {
+	Strategy             string         // Communication strategy: "webhooks" or "polling"
+	EndpointID           string         // Endpoint registered with Stripe at startup ("" = none)
+	EndpointURL          string         // URL Stripe sends events to
+	StartedAt            time.Time      // When the counters since startup began
+	LastEventAt          time.Time      // Last verified event (zero = none since startup)
+	LastEventType        string         // Type of the last verified event
+	SinceStartup         map[string]int // Verified events per type since startup
+	Today                map[string]int // Verified events per type this business day, kept across restarts
+	SignatureFailures    int            // Events rejected for a bad signature since startup
+	SignatureFailuresDay int            // Events rejected for a bad signature this business day
+	LastSignatureFailure time.Time      // Last rejected event (zero = none since startup)
+}
+
+// EventTypes returns the event types counted since startup or today, sorted
+func (h WebhookHealth) EventTypes() []string {
+	seen := make(map[string]bool)
+	var types []string
+	for _, counts := range []map[string]int{h.SinceStartup, h.Today} {
+		for eventType := range counts {
+			if !seen[eventType] {
+				seen[eventType] = true
+				types = append(types, eventType)
+			}
+		}
+	}
+	sort.Strings(types)
+	return types
+}
+
+// webhookEventTally is the business day's webhook events, kept in the data directory
+type webhookEventTally struct {
+	Day               string         `json:"day"`
+	Events            map[string]int `json:"events"`
+	SignatureFailures int            `json:"signatureFailures"`
+}
+
+// webhookEventTallyMutex serializes access to the business day's webhook tally
+var webhookEventTallyMutex sync.Mutex
+
+// webhookHealth holds the webhook delivery counters and the tests waiting for an event
+var webhookHealth = struct {
+	endpointID           string
+	endpointURL          string
+	startedAt            time.Time
+	lastEventAt          time.Time
+	lastEventType        string
+	events               map[string]int
+	signatureFailures    int
+	lastSignatureFailure time.Time
+	waiters              map[string]chan struct{} // Object ID -> test waiting for an event about it
+	mutex                sync.Mutex
+}{
+	startedAt: time.Now(),
+	events:    make(map[string]int),
+	waiters:   make(map[string]chan struct{}),
+}
+
+// RecordWebhookEndpoint records the webhook endpoint registered with Stripe at startup
+func RecordWebhookEndpoint(id, url string) {
+	webhookHealth.mutex.Lock()
+	defer webhookHealth.mutex.Unlock()
+	webhookHealth.endpointID = id
+	webhookHealth.endpointURL = url
+}
+
+// RecordWebhookDelivery records a webhook event whose signature was verified, and wakes a
+// delivery test waiting for an event about its object
+func RecordWebhookDelivery(eventType, objectID string, now time.Time) {
+	webhookHealth.mutex.Lock()
+	webhookHealth.lastEventAt = now
+	webhookHealth.lastEventType = eventType
+	webhookHealth.events[eventType]++
+	if waiter, ok := webhookHealth.waiters[objectID]; ok && objectID != "" {
+		close(waiter)
+		delete(webhookHealth.waiters, objectID)
+	}
+	webhookHealth.mutex.Unlock()
+
+	updateWebhookEventTally(now, func(tally *webhookEventTally) { tally.Events[eventType]++ })
+}
+
+// RecordWebhookSignatureFailure records a webhook request rejected for a bad signature, which is
+// what an expired or rotated signing secret looks like
+func RecordWebhookSignatureFailure(now time.Time) {
+	webhookHealth.mutex.Lock()
+	webhookHealth.signatureFailures++
+	webhookHealth.lastSignatureFailure = now
+	webhookHealth.mutex.Unlock()
+
+	updateWebhookEventTally(now, func(tally *webhookEventTally) { tally.SignatureFailures++ })
+}
+
+// WebhookHealthReport returns the webhook delivery seen since startup and today
+func WebhookHealthReport() WebhookHealth {
+	webhookEventTallyMutex.Lock()
+	tally, err := loadWebhookEventTally(time.Now())
+	webhookEventTallyMutex.Unlock()
+	if err != nil {
+		utils.Warn("webhook", "Error loading today's webhook events", "error", err)
+	}
+
+	webhookHealth.mutex.Lock()
+	defer webhookHealth.mutex.Unlock()
+
+	sinceStartup := make(map[string]int, len(webhookHealth.events))
+	for eventType, count := range webhookHealth.events {
+		sinceStartup[eventType] = count
+	}
+	return WebhookHealth{
+		Strategy:             config.GetCommunicationStrategy(),
+		EndpointID:           webhookHealth.endpointID,
+		EndpointURL:          webhookHealth.endpointURL,
+		StartedAt:            webhookHealth.startedAt,
+		LastEventAt:          webhookHealth.lastEventAt,
+		LastEventType:        webhookHealth.lastEventType,
+		SinceStartup:         sinceStartup,
+		Today:                tally.Events,
+		SignatureFailures:    webhookHealth.signatureFailures,
+		SignatureFailuresDay: tally.SignatureFailures,
+		LastSignatureFailure: webhookHealth.lastSignatureFailure,
+	}
+}
+
+// CheckWebhookHealth warns when the webhook strategy is in use and no event has arrived for the
+// configured silence limit, counting from startup when none has arrived at all. Payments still
+// complete through the status checks, so it is a warning rather than a failure.
+func CheckWebhookHealth(now time.Time) HealthCheck {
+	check := HealthCheck{OK: true, CheckedAt: now}
+	limit := config.GetWebhookSilenceLimit()
+	if limit == 0 || config.GetCommunicationStrategy() != "webhooks" {
+		return check
+	}
+
+	webhookHealth.mutex.Lock()
+	last, since := webhookHealth.lastEventAt, webhookHealth.lastEventAt
+	if since.IsZero() {
+		since = webhookHealth.startedAt
+	}
+	failures := webhookHealth.signatureFailures
+	webhookHealth.mutex.Unlock()
+
+	if now.Sub(since) > limit {
+		if last.IsZero() {
+			check.Warning = fmt.Sprintf("no webhook event since startup %s ago", now.Sub(since).Round(time.Minute))
+		} else {
+			check.Warning = fmt.Sprintf("no webhook event for %s", now.Sub(since).Round(time.Minute))
+		}
+		if failures > 0 {
+			check.Warning += fmt.Sprintf(" (%d signature failures; check the signing secret)", failures)
+		}
+	}
+	return check
+}
+
+// TestWebhookDelivery creates a small PaymentIntent and cancels it straight away, then waits for
+// Stripe's webhook about it. Nothing is charged. Returns how long the event took to arrive.
+func TestWebhookDelivery(timeout time.Duration) (time.Duration, error) {
+	if config.GetCommunicationStrategy() != "webhooks" {
+		return 0, errors.New("webhooks aren't in use; payment statuses are checked by polling")
+	}
+
+	params := NewPaymentIntentParams(webhookTestAmount, "terminal", "")
+	params.Description = stripe.String("POS webhook delivery test (cancelled)")
+	intent, err := Stripe.CreatePaymentIntent(params)
+	if err != nil {
+		return 0, fmt.Errorf("error creating test PaymentIntent: %w", err)
+	}
+
+	// Either the created or the canceled event proves delivery, so wait from before the cancel
+	start := time.Now()
+	arrived := make(chan struct{})
+	webhookHealth.mutex.Lock()
+	webhookHealth.waiters[intent.ID] = arrived
+	webhookHealth.mutex.Unlock()
+	defer func() {
+		webhookHealth.mutex.Lock()
+		delete(webhookHealth.waiters, intent.ID)
+		webhookHealth.mutex.Unlock()
+	}()
+
+	if _, err := Stripe.CancelPaymentIntent(intent.ID); err != nil {
+		return 0, fmt.Errorf("error canceling test PaymentIntent %s: %w", intent.ID, err)
+	}
+
+	select {
+	case <-arrived:
+		elapsed := time.Since(start)
+		utils.Info("webhook", "Webhook delivery test passed", "intent_id", intent.ID, "elapsed", elapsed.String())
+		return elapsed, nil
+	case <-time.After(timeout):
+		utils.Warn("webhook", "Webhook delivery test timed out", "intent_id", intent.ID, "timeout", timeout.String())
+		return 0, fmt.Errorf("no webhook event for test PaymentIntent %s within %s", intent.ID, timeout)
+	}
+}
+
+// updateWebhookEventTally changes the business day's webhook tally and saves it
+func updateWebhookEventTally(now time.Time, change func(tally *webhookEventTally)) {
+	webhookEventTallyMutex.Lock()
+	defer webhookEventTallyMutex.Unlock()
+
+	tally, err := loadWebhookEventTally(now)
+	if err != nil {
+		utils.Warn("webhook", "Error loading today's webhook events", "error", err)
+	}
+	change(&tally)
+	if err := writeJSONFile(getWebhookEventTallyPath(), tally); err != nil {
+		utils.Warn("webhook", "Error saving today's webhook events", "error", err)
+	}
+}
+
+// loadWebhookEventTally reads the business day's webhook tally; one from an earlier day is
+// discarded. It always returns a usable tally. Callers must hold webhookEventTallyMutex.
+func loadWebhookEventTally(now time.Time) (webhookEventTally, error) {
+	today := config.BusinessDay(now).Format("2006-01-02")
+	empty := webhookEventTally{Day: today, Events: make(map[string]int)}
+
+	data, err := os.ReadFile(getWebhookEventTallyPath())
+	if os.IsNotExist(err) {
+		return empty, nil
+	}
+	if err != nil {
+		return empty, fmt.Errorf("error reading webhook events: %w", err)
+	}
+
+	var tally webhookEventTally
+	if err := json.Unmarshal(data, &tally); err != nil {
+		return empty, fmt.Errorf("error parsing webhook events: %w", err)
+	}
+	if tally.Day != today {
+		return empty, nil
+	}
+	if tally.Events == nil {
+		tally.Events = make(map[string]int)
+	}
+	return tally, nil
+}
+
+func getWebhookEventTallyPath() string {
+	dataDir := config.Config.DataDir
+	if dataDir == "" {
+		dataDir = config.DefaultDataDir
+	}
+	return filepath.Join(dataDir, "webhook-events.json")
+}
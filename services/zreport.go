@@ -0,0 +1,220 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"checkout/config"
+	"checkout/utils"
+)
+
+// ZReport is the snapshot taken when a day is closed. It is saved once and read back for reprints,
+// so a closed day's numbers never change even if the transaction log or catalog does.
+type ZReport struct {
+	Number   int       `json:"number"`   // Sequence number, increasing across all closed days
+	ClosedAt time.Time `json:"closedAt"` // When the day was closed
+	DailySummary
+}
+
+// zReportSequence is the last Z-report number issued, kept in its own file next to the reports
+type zReportSequence struct {
+	LastNumber int `json:"lastNumber"`
+}
+
+// CloseDay generates and saves the Z-report for a day and closes the day's transaction log.
+// Payments completing afterwards for the day are logged in the next open day (see appendTransactionRecords).
+func CloseDay(day time.Time) (*ZReport, error) {
+	date := day.Format("2006-01-02")
+	if date > config.BusinessDay(time.Now()).Format("2006-01-02") {
+		return nil, fmt.Errorf("%s hasn't started yet", date)
+	}
+
+	// Hold the log lock so no row can be written between the summary and the day closing
+	transactionLogMutex.Lock()
+	defer transactionLogMutex.Unlock()
+
+	if existing, err := LoadZReport(day); err == nil {
+		return nil, fmt.Errorf("%s was already closed with Z-report #%d", date, existing.Number)
+	}
+
+	summary, err := BuildDailySummary(day)
+	if err != nil {
+		return nil, err
+	}
+
+	number, err := nextZReportNumber()
+	if err != nil {
+		return nil, err
+	}
+	report := &ZReport{Number: number, ClosedAt: time.Now(), DailySummary: summary}
+
+	// The number is saved first so it is never issued twice, even if writing the report fails
+	if err := writeJSONFile(filepath.Join(getZReportsDir(), "z-report-sequence.json"), zReportSequence{LastNumber: number}); err != nil {
+		return nil, fmt.Errorf("error saving Z-report number: %w", err)
+	}
+	if err := writeJSONFile(zReportPath(date), report); err != nil {
+		return nil, fmt.Errorf("error saving Z-report: %w", err)
+	}
+
+	utils.Info("report", "Day closed", "date", date, "z_report", number, "sales", summary.TransactionCount, "total", summary.Total)
+	return report, nil
+}
+
+// IsDayClosed reports whether a Z-report has been saved for the day
+func IsDayClosed(day time.Time) bool {
+	_, err := os.Stat(zReportPath(day.Format("2006-01-02")))
+	return err == nil
+}
+
+// LoadZReport reads the saved Z-report of a closed day
+func LoadZReport(day time.Time) (*ZReport, error) {
+	data, err := os.ReadFile(zReportPath(day.Format("2006-01-02")))
+	if err != nil {
+		return nil, err
+	}
+	var report ZReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		return nil, fmt.Errorf("error parsing Z-report: %w", err)
+	}
+	return &report, nil
+}
+
+// ListZReports returns the saved Z-reports, newest number first
+func ListZReports() ([]ZReport, error) {
+	files, err := filepath.Glob(filepath.Join(getZReportsDir(), "z-????-??-??.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	reports := []ZReport{}
+	for _, filename := range files {
+		data, err := os.ReadFile(filename)
+		if err != nil {
+			return nil, err
+		}
+		var report ZReport
+		if err := json.Unmarshal(data, &report); err != nil {
+			utils.Error("report", "Error parsing Z-report", "file", filename, "error", err)
+			continue
+		}
+		reports = append(reports, report)
+	}
+
+	sort.Slice(reports, func(i, j int) bool { return reports[i].Number > reports[j].Number })
+	return reports, nil
+}
+
+// FormatZReport renders a Z-report as plain text
+func FormatZReport(report ZReport, location *time.Location) string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "%s - Z-Report #%d for %s\n", config.Config.BusinessName, report.Number, report.Date)
+	fmt.Fprintf(&b, "Closed %s\n\n", report.ClosedAt.In(location).Format("01/02/2006 15:04:05"))
+	fmt.Fprintf(&b, "Transactions:  %d (%d items)\n", report.TransactionCount, report.ItemCount)
+	if report.FirstSale != "" {
+		fmt.Fprintf(&b, "First sale:    %s\n", report.FirstSale)
+		fmt.Fprintf(&b, "Last sale:     %s\n", report.LastSale)
+	}
+	fmt.Fprintf(&b, "Gross sales:   $%.2f\n", report.Total)
+	fmt.Fprintf(&b, "Tax:           $%.2f\n", report.Tax)
+	for _, category := range sortedKeys(report.TaxByCategory) {
+		fmt.Fprintf(&b, "  %-20s $%.2f\n", TaxCategoryLabel(category)+":", report.TaxByCategory[category])
+	}
+	fmt.Fprintf(&b, "Tips:          $%.2f\n", report.TipTotal)
+	if report.ServiceFeeTotal != 0 {
+		fmt.Fprintf(&b, "Service fees:  $%.2f\n", report.ServiceFeeTotal)
+	}
+	writeStripeFees(&b, report.DailySummary)
+	fmt.Fprintf(&b, "Refunds:       $%.2f (%d voids, %d returns)\n", report.RefundTotal(), report.VoidCount, report.ReturnCount)
+	fmt.Fprintf(&b, "Net total:     $%.2f\n", report.NetTotal())
+
+	if len(report.ByPaymentMethod) > 0 {
+		b.WriteString("\nBy payment method:\n")
+		for _, method := range sortedKeys(report.ByPaymentMethod) {
+			fmt.Fprintf(&b, "  %-22s $%.2f\n", PaymentMethodLabel(method)+":", report.ByPaymentMethod[method])
+		}
+	}
+	writeVendorSales(&b, report.ByVendor)
+	writeTaxComponents(&b, report.TaxComponents())
+
+	return b.String()
+}
+
+// TaxCategoryLabel returns the display name of a tax category ID logged with a sale
+func TaxCategoryLabel(id string) string {
+	if id == "" {
+		return "Default rate"
+	}
+	for _, category := range config.Config.TaxCategories {
+		if category.ID == id {
+			return category.Name
+		}
+	}
+	return id
+}
+
+// nextZReportNumber returns the number for the next Z-report. Reports already on disk are
+// checked too, so a lost or older sequence file can't make a number go backwards.
+func nextZReportNumber() (int, error) {
+	var sequence zReportSequence
+	data, err := os.ReadFile(filepath.Join(getZReportsDir(), "z-report-sequence.json"))
+	if err == nil {
+		if err := json.Unmarshal(data, &sequence); err != nil {
+			return 0, fmt.Errorf("error parsing Z-report sequence: %w", err)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return 0, fmt.Errorf("error reading Z-report sequence: %w", err)
+	}
+
+	reports, err := ListZReports()
+	if err != nil {
+		return 0, err
+	}
+	if len(reports) > 0 && reports[0].Number > sequence.LastNumber {
+		sequence.LastNumber = reports[0].Number
+	}
+	return sequence.LastNumber + 1, nil
+}
+
+// writeJSONFile writes a value as indented JSON through a temporary file, so a crash never
+// leaves a half-written file behind
+func writeJSONFile(path string, value interface{}) error {
+	data, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tempFile := path + ".tmp"
+	if err := os.WriteFile(tempFile, data, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tempFile, path); err != nil {
+		os.Remove(tempFile)
+		return err
+	}
+	return nil
+}
+
+func zReportPath(date string) string {
+	return filepath.Join(getZReportsDir(), "z-"+date+".json")
+}
+
+func getZReportsDir() string {
+	// A practice day closed in demo mode must not close the real day
+	if config.Config.DemoMode {
+		return filepath.Join(demoDataDir(), "reports")
+	}
+	dataDir := config.Config.DataDir
+	if dataDir == "" {
+		dataDir = config.DefaultDataDir
+	}
+	return filepath.Join(dataDir, "reports")
+}
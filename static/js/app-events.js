@@ -0,0 +1,41 @@
+// App events - the POS page subscribes to /app-events and raises each pushed event on the body,
+// where the page already listens for the same events from HX-Trigger headers
+(function() {
+    const names = ['cartUpdated', 'showToast', 'readerStatusChanged', 'paymentRecovered', 'activityUpdated'];
+    let lastCartUpdate = 0;
+
+    document.addEventListener('DOMContentLoaded', function() {
+        const root = document.getElementById('app-events');
+        if (!root || !window.EventSource) return;
+
+        document.body.addEventListener('cartUpdated', function() {
+            lastCartUpdate = Date.now();
+        });
+
+        const source = new EventSource(root.dataset.url);
+        names.forEach(function(name) {
+            source.addEventListener(name, function(e) {
+                let detail = true;
+                try {
+                    detail = JSON.parse(e.data);
+                } catch (err) {
+                    // Sent as is
+                }
+
+                if (name !== 'cartUpdated') {
+                    htmx.trigger(document.body, name, detail);
+                    return;
+                }
+
+                // A change made on this screen also refreshes the cart through its own response;
+                // give that a moment to arrive and skip the push when it did
+                const received = Date.now();
+                setTimeout(function() {
+                    if (lastCartUpdate < received - 1000) {
+                        htmx.trigger(document.body, name, detail);
+                    }
+                }, 300);
+            });
+        });
+    });
+})();
@@ -0,0 +1,69 @@
+// Payment success screen - the Checkout Flow settings carried on the success modal: open the
+// receipt's print dialog and the fulfillment ticket, and close the modal for the next sale after
+// a delay. The modal arrives by SSE or by response, so it is picked up whenever htmx loads it.
+(function() {
+    let closeTimer = null;
+
+    function stopAutoClose() {
+        if (closeTimer) {
+            clearInterval(closeTimer);
+            closeTimer = null;
+        }
+        document.querySelectorAll('.auto-close-note').forEach(function(note) {
+            note.remove();
+        });
+    }
+
+    function startAutoClose(container, seconds) {
+        stopAutoClose();
+        const note = container.querySelector('.auto-close-note');
+        let remaining = seconds;
+        closeTimer = setInterval(function() {
+            if (!document.body.contains(container)) {
+                stopAutoClose();
+                return;
+            }
+            // A receipt email being typed on the reader keeps the modal open until it is done
+            if (container.querySelector('[data-keep-open]')) {
+                return;
+            }
+            remaining--;
+            if (remaining <= 0) {
+                stopAutoClose();
+                htmx.ajax('POST', '/close-modal', { swap: 'none' });
+                return;
+            }
+            if (note) {
+                note.textContent = note.textContent.replace(/\d+/, remaining);
+            }
+        }, 1000);
+
+        // Working in the modal (a receipt address, the note, a void) keeps it open
+        container.addEventListener('pointerdown', stopAutoClose, { once: true });
+        container.addEventListener('focusin', stopAutoClose, { once: true });
+    }
+
+    htmx.onLoad(function(element) {
+        const container = element.matches && element.matches('.payment-success')
+            ? element
+            : element.querySelector && element.querySelector('.payment-success');
+        if (!container || container.dataset.flowStarted) return;
+        container.dataset.flowStarted = 'true';
+
+        // Wait for the sale to be logged before opening pages that read it
+        const code = encodeURIComponent(container.dataset.confirmationCode);
+        setTimeout(function() {
+            if (container.dataset.autoPrint === 'true') {
+                window.open('/receipt/' + code + '?print=1', 'receipt-print');
+            }
+            if (container.dataset.autoTicket === 'true') {
+                window.open('/ticket/' + code + '?print=1', 'fulfillment-ticket');
+            }
+        }, 500);
+
+        const seconds = parseInt(container.dataset.autoClose || '0', 10);
+        if (seconds > 0) {
+            startAutoClose(container, seconds);
+        }
+    });
+})();
@@ -0,0 +1,41 @@
+// Favorites row - drag a starred product to a new place and save the order for this device
+(function() {
+    let dragged = null;
+
+    document.addEventListener('dragstart', function(e) {
+        const item = e.target.closest && e.target.closest('.favorite-item');
+        if (!item) return;
+        dragged = item;
+        item.classList.add('dragging');
+        e.dataTransfer.effectAllowed = 'move';
+        e.dataTransfer.setData('text/plain', item.dataset.id);
+    });
+
+    document.addEventListener('dragover', function(e) {
+        if (!dragged) return;
+        const over = e.target.closest('.favorite-item');
+        if (!over || over === dragged || over.parentNode !== dragged.parentNode) return;
+        e.preventDefault();
+
+        // Drop before or after the tile depending on which half the pointer is over
+        const rect = over.getBoundingClientRect();
+        const after = e.clientX > rect.left + rect.width / 2;
+        over.parentNode.insertBefore(dragged, after ? over.nextSibling : over);
+    });
+
+    document.addEventListener('drop', function(e) {
+        if (dragged) e.preventDefault();
+    });
+
+    document.addEventListener('dragend', function() {
+        if (!dragged) return;
+        const row = dragged.parentNode;
+        dragged.classList.remove('dragging');
+        dragged = null;
+
+        const ids = Array.from(row.querySelectorAll('.favorite-item')).map(function(item) {
+            return item.dataset.id;
+        });
+        htmx.ajax('POST', '/favorites/reorder', { source: row, swap: 'none', values: { id: ids } });
+    });
+})();
@@ -0,0 +1,140 @@
+// Package static holds the CSS, JS and images served under /static/.
+// The files are embedded in the binary so a copied executable serves the full UI;
+// UseDirectory switches to serving from disk for development.
+package static
+
+import (
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+//go:embed css images js
+var embedded embed.FS
+
+// Cache lifetimes for served assets
+const (
+	versionedMaxAge   = 365 * 24 * 60 * 60 // URLs carrying the current content hash never change
+	unversionedMaxAge = 60 * 60            // Plain URLs are revalidated hourly so deploys show up
+)
+
+var (
+	assets     fs.FS = embedded
+	devMode    bool
+	fileHashes = hashFiles(embedded) // Per-file content hashes, keyed by path under static/
+	version    = combinedVersion(fileHashes)
+)
+
+// UseDirectory serves assets from dir on disk instead of the embedded copy.
+// Changes show up on reload and responses are sent with no-cache.
+func UseDirectory(dir string) {
+	assets = os.DirFS(dir)
+	devMode = true
+}
+
+// DevMode reports whether assets are served from disk
+func DevMode() bool {
+	return devMode
+}
+
+// FS returns the filesystem assets are served from
+func FS() fs.FS {
+	return assets
+}
+
+// Version returns a hash of all assets, used to bust client caches on deploy.
+// In dev mode it's recomputed from disk on each call.
+func Version() string {
+	if devMode {
+		return combinedVersion(hashFiles(assets))
+	}
+	return version
+}
+
+// Files returns the paths of all assets, relative to the static directory
+func Files() []string {
+	hashes := fileHashes
+	if devMode {
+		hashes = hashFiles(assets)
+	}
+
+	names := make([]string, 0, len(hashes))
+	for name := range hashes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// URL returns the public URL for an asset, e.g. URL("css/styles.css").
+// Embedded assets get a content hash query so they can be cached long-term.
+func URL(name string) string {
+	name = strings.TrimPrefix(name, "/")
+	if hash, ok := fileHashes[name]; ok && !devMode {
+		return "/static/" + name + "?v=" + hash
+	}
+	return "/static/" + name
+}
+
+// Handler serves assets under /static/ with cache headers suited to the mode
+func Handler() http.Handler {
+	fileServer := http.StripPrefix("/static/", http.FileServer(http.FS(assets)))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(r.URL.Path, "/static/")
+
+		switch {
+		case devMode:
+			w.Header().Set("Cache-Control", "no-cache")
+		case r.URL.Query().Get("v") != "" && r.URL.Query().Get("v") == fileHashes[name]:
+			w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d, immutable", versionedMaxAge))
+		default:
+			w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", unversionedMaxAge))
+			if hash, ok := fileHashes[name]; ok {
+				w.Header().Set("ETag", `"`+hash+`"`)
+			}
+		}
+
+		fileServer.ServeHTTP(w, r)
+	})
+}
+
+// hashFiles returns a short content hash for every file in fsys
+func hashFiles(fsys fs.FS) map[string]string {
+	hashes := make(map[string]string)
+	fs.WalkDir(fsys, ".", func(name string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() || path.Ext(name) == ".go" {
+			return nil
+		}
+		data, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return nil
+		}
+		sum := sha256.Sum256(data)
+		hashes[name] = hex.EncodeToString(sum[:])[:12]
+		return nil
+	})
+	return hashes
+}
+
+// combinedVersion folds per-file hashes into a single version string
+func combinedVersion(hashes map[string]string) string {
+	names := make([]string, 0, len(hashes))
+	for name := range hashes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	sum := sha256.New()
+	for _, name := range names {
+		fmt.Fprintf(sum, "%s:%s\n", name, hashes[name])
+	}
+	return hex.EncodeToString(sum.Sum(nil))[:12]
+}
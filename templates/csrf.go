@@ -0,0 +1,42 @@
+package templates
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/a-h/templ"
+)
+
+// CSRFFieldName is the form field and CSRFHeaderName the request header that carry the CSRF token
+const (
+	CSRFFieldName  = "csrf_token"
+	CSRFHeaderName = "X-CSRF-Token"
+)
+
+type csrfContextKey struct{}
+
+// WithCSRFToken returns a context carrying the session's CSRF token for templates to embed
+func WithCSRFToken(ctx context.Context, token string) context.Context {
+	return context.WithValue(ctx, csrfContextKey{}, token)
+}
+
+// CSRFToken returns the CSRF token of the request being rendered, or "" outside a session
+func CSRFToken(ctx context.Context) string {
+	token, _ := ctx.Value(csrfContextKey{}).(string)
+	return token
+}
+
+// CSRFHeaders returns an hx-headers value that sends the CSRF token with every HTMX request
+// made from inside the element (including htmx.ajax calls targeting it)
+func CSRFHeaders(ctx context.Context) string {
+	headers, _ := json.Marshal(map[string]string{CSRFHeaderName: CSRFToken(ctx)})
+	return string(headers)
+}
+
+// csrfAttributes puts the CSRF headers on the page body; pages rendered outside a session get none
+func csrfAttributes(ctx context.Context) templ.Attributes {
+	if CSRFToken(ctx) == "" {
+		return nil
+	}
+	return templ.Attributes{"hx-headers": CSRFHeaders(ctx)}
+}
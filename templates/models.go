@@ -1,5 +1,7 @@
 package templates
 
+import "time"
+
 // Product represents a product item that can be sold
 type Product struct {
 	ID              string  `json:"id"`
@@ -10,13 +12,62 @@ type Product struct {
 	PriceID         string  `json:"priceID,omitempty"`         // Stripe Price ID (e.g., price_xxxxxxxxxxxxxx) for the default price
 	Category        string  `json:"category,omitempty"`        // Navigation category path (e.g., "cat1/cat2")
 	TaxCategory     string  `json:"taxCategory,omitempty"`     // Tax category ID
+	SKU             string  `json:"sku,omitempty"`             // SKU or barcode for scanning, unique across products
+	GiftCard        bool    `json:"giftCard,omitempty"`        // Selling this product loads its price onto a gift card
+	Vendor          string  `json:"vendor,omitempty"`          // ID of the market vendor selling the product (empty = the business)
+
+	// Measured product: sold by weight or length (e.g. "lb", "ft") with Price per unit; "" or "each" sells whole items
+	UnitType string `json:"unitType,omitempty"`
+
+	// Sale prices scheduled for the product; the one in effect replaces Price when the product is added to the cart
+	PriceSchedules []PriceSchedule `json:"priceSchedules,omitempty"`
+
+	// Scheduled sale (cart and logged items only): the catalog price and the lower sale price that replaced it
+	ListPrice float64 `json:"listPrice,omitempty"`
+	SalePrice float64 `json:"salePrice,omitempty"`
+
+	// Register price override (cart items only, never saved to the catalog)
+	OriginalPrice  float64 `json:"originalPrice,omitempty"`  // Price before the override
+	OverrideReason string  `json:"overrideReason,omitempty"` // Why the cashier changed the price
+
+	// Register description override (cart items only, never saved to the catalog); Description holds the new text
+	OriginalDescription string `json:"originalDescription,omitempty"` // Catalog description before the change
+	DescriptionEdited   bool   `json:"descriptionEdited,omitempty"`   // The cashier wrote this line's description
+
+	// Return line (cart items only): the earlier sale the item is returned from; Price is the negative refund
+	ReturnOf         string  `json:"returnOf,omitempty"`
+	ReturnServiceFee float64 `json:"returnServiceFee,omitempty"` // Share of the earlier sale's service fee refunded with the item (negative)
+
+	// Gift card sale (cart items only): the card the price is loaded onto when the sale succeeds
+	GiftCardCode string `json:"giftCardCode,omitempty"`
+
+	// Measured line (cart items only): Price is UnitPrice times Quantity, rounded to cents
+	Quantity  float64 `json:"quantity,omitempty"`
+	UnitPrice float64 `json:"unitPrice,omitempty"`
+}
+
+// PriceSchedule is a sale price a product sells at from Start until End, e.g. a weekend promotion
+type PriceSchedule struct {
+	ID    string    `json:"id"`
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	Price float64   `json:"price"` // Per unit for a measured product
 }
 
 // CartSummary contains the cart totals
 type CartSummary struct {
-	Subtotal float64
-	Tax      float64
-	Total    float64
+	Subtotal   float64
+	Tax        float64
+	ServiceFee float64 // Service fee or card surcharge for the selected payment method (negative when refunded)
+	Total      float64 // Subtotal, tax and service fee
+
+	TaxBreakdown []TaxAmount // Tax per component of the tax categories that have components
+}
+
+// TaxAmount is the tax collected for one tax component
+type TaxAmount struct {
+	Name   string
+	Amount float64
 }
 
 // Transaction represents a completed sale
@@ -28,10 +79,13 @@ type Transaction struct {
 	ProductTaxes  []float64 `json:"productTaxes"` // Tax amount per product (same order as Products)
 	Subtotal      float64   `json:"subtotal"`
 	Tax           float64   `json:"tax"`
+	ServiceFee    float64   `json:"serviceFee,omitempty"` // Service fee or card surcharge, included in Total
 	Total         float64   `json:"total"`
 	PaymentType   string    `json:"paymentType"`
+	LocationID    string    `json:"locationID,omitempty"` // Stripe Terminal Location active when the sale was taken
 	CustomerPhone string    `json:"customerPhone,omitempty"`
 	ReceiptSent   bool      `json:"receiptSent,omitempty"`
+	Voided        bool      `json:"voided,omitempty"` // A reversal has been logged for this transaction
 
 	// Payment link tracking fields
 	PaymentLinkID     string `json:"paymentLinkID,omitempty"`
@@ -41,6 +95,117 @@ type Transaction struct {
 
 	// Stripe-collected customer information (from QR payments)
 	StripeCustomerEmail string `json:"stripeCustomerEmail,omitempty"` // Email collected by Stripe during QR payment
+
+	// Card used for the payment (blank for payments without a card)
+	CardBrand        string `json:"cardBrand,omitempty"`        // e.g. "visa", "mastercard"
+	CardLast4        string `json:"cardLast4,omitempty"`        // Last four digits of the card number
+	StripeReceiptURL string `json:"stripeReceiptURL,omitempty"` // Stripe-hosted receipt for the charge
+
+	// Individual payments of a sale split across payment methods (empty for single-tender sales)
+	Tenders []Tender `json:"tenders,omitempty"`
+
+	// Tax of each product split by tax component (same order as Products); empty maps for
+	// products whose category has no components
+	ProductTaxComponents []map[string]float64 `json:"productTaxComponents,omitempty"`
+
+	// Tip added on top of the sale total (on screen for QR and manual card, on the reader for terminal)
+	TipAmount float64 `json:"tipAmount,omitempty"`
+
+	// Cashier's note or order reference (e.g. "table 5", an invoice number); edits after payment are applied on load
+	Note string `json:"note,omitempty"`
+
+	// Declined card payments before this one, oldest first, as "method:intent ID" separated by spaces
+	// (e.g. "terminal:pi_123 manual:pi_123"); a retry reuses the declined PaymentIntent when it can
+	RetryOf string `json:"retryOf,omitempty"`
+
+	// User working the register when the payment was taken (see the PIN user switch)
+	CashierID string `json:"cashierID,omitempty"`
+
+	// Reconstructed from a Stripe payment by reconciliation because the POS never logged the sale
+	Imported bool `json:"imported,omitempty"`
+
+	// Short number of the day's order, printed on the fulfillment ticket and the receipt (0 = none)
+	OrderNumber int `json:"orderNumber,omitempty"`
+
+	// Processing fee Stripe took from the sale's card payments and what it deposited after the fee.
+	// Looked up after payment and applied on load; both are zero until every fee is known.
+	FeeAmount   float64 `json:"feeAmount,omitempty"`
+	NetAmount   float64 `json:"netAmount,omitempty"`
+	FeeRefunded float64 `json:"feeRefunded,omitempty"` // Part of the fee Stripe returned when the sale was refunded
+
+	// Stripe connected account of the market vendor the card payment was transferred to (empty = none)
+	PayoutAccount string `json:"payoutAccount,omitempty"`
+}
+
+// HasStripeFee reports whether the Stripe fee of the sale is known
+func (t Transaction) HasStripeFee() bool {
+	return t.NetAmount != 0
+}
+
+// AmountPaid returns what the customer was charged: the sale total plus any tip
+func (t *Transaction) AmountPaid() float64 {
+	return t.Total + t.TipAmount
+}
+
+// Tender is one payment toward a split sale
+type Tender struct {
+	PaymentID  string  `json:"paymentID"`            // PaymentIntent or payment link ID (POS ID for cash)
+	Method     string  `json:"method"`               // terminal, manual, qr, cash or gift_card
+	Amount     float64 `json:"amount"`               // Amount applied to the sale
+	ServiceFee float64 `json:"serviceFee,omitempty"` // Service fee charged on top of Amount
+	CardBrand  string  `json:"cardBrand,omitempty"`  // Card used, if any
+	CardLast4  string  `json:"cardLast4,omitempty"`  // Last four digits of the card
+	ReceiptURL string  `json:"receiptURL,omitempty"` // Stripe-hosted receipt for the charge
+
+	PayoutAccount string `json:"payoutAccount,omitempty"` // Vendor's connected account the payment was transferred to
+}
+
+// GiftCard is store credit sold at the register and redeemed as a payment
+type GiftCard struct {
+	Code       string  `json:"code"`
+	Balance    float64 `json:"balance"`
+	IssuedDate string  `json:"issuedDate"` // Date the card was first loaded (MM/DD/YYYY)
+	Status     string  `json:"status"`     // active or cancelled
+}
+
+// GiftCardEntry is one line of the append-only gift card ledger
+type GiftCardEntry struct {
+	Date      string  `json:"date"`
+	Time      string  `json:"time"`
+	Code      string  `json:"code"`
+	Type      string  `json:"type"`      // issue, redeem, credit or void
+	Amount    float64 `json:"amount"`    // Change to the balance (negative for redemptions and voids)
+	Balance   float64 `json:"balance"`   // Balance after the entry
+	Reference string  `json:"reference"` // Sale that loaded the card, or tender that redeemed it
+}
+
+// DuplicatePayment is an extra checkout session paid through a payment link that had
+// already been paid, so the customer was charged twice and must be refunded
+type DuplicatePayment struct {
+	SessionID       string  `json:"sessionId"`
+	PaymentLinkID   string  `json:"paymentLinkId"`
+	PaymentIntentID string  `json:"paymentIntentId"`
+	Amount          float64 `json:"amount"`
+	CustomerEmail   string  `json:"customerEmail,omitempty"`
+	Date            string  `json:"date"`
+	Time            string  `json:"time"`
+	Status          string  `json:"status"` // needs_refund or refunded
+}
+
+// SentLink is a payment link sent to a customer to pay later. The sale leaves the register when
+// the link is sent and is logged with the cart saved here once the link is paid.
+type SentLink struct {
+	PaymentLinkID string      `json:"paymentLinkId"`
+	URL           string      `json:"url"`
+	Email         string      `json:"email,omitempty"` // Address the link was emailed to ("" if it was only shown)
+	Amount        float64     `json:"amount"`
+	Cart          []Product   `json:"cart"`
+	Summary       CartSummary `json:"summary"`
+	Note          string      `json:"note,omitempty"`
+	SentBy        string      `json:"sentBy,omitempty"`
+	SentAt        time.Time   `json:"sentAt"`
+	ExpiresAt     time.Time   `json:"expiresAt,omitempty"` // Zero if it stays payable until cancelled
+	Status        string      `json:"status"`              // outstanding, paid, cancelled or expired
 }
 
 // ReceiptRecord represents a post-payment receipt delivery record
@@ -77,6 +242,50 @@ type TaxCategory struct {
 	ID      string  `json:"id"`
 	Name    string  `json:"name"`
 	TaxRate float64 `json:"tax_rate"` // Decimal rate (e.g., 0.0625 for 6.25%)
+
+	// Rates collected for different authorities (e.g. state and county), reported separately.
+	// When set, the category's rate is their sum and TaxRate is ignored.
+	Components []TaxComponent `json:"components,omitempty"`
+}
+
+// Vendor is a seller at a multi-vendor market. Card payments for a cart of the vendor's products
+// are destination charges paid out to the vendor's Stripe connected account.
+type Vendor struct {
+	ID        string `json:"id"`        // Referenced by the vendor's products
+	Name      string `json:"name"`      // Shown in reports and messages
+	AccountID string `json:"accountID"` // Stripe connected account (acct_...)
+}
+
+// TaxComponent is one of the rates making up a tax category's rate
+type TaxComponent struct {
+	Name    string  `json:"name"`     // Shown on receipts and reports, and names the component's CSV column
+	TaxRate float64 `json:"tax_rate"` // Decimal rate
+}
+
+// Rate returns the category's tax rate: the sum of its components, or TaxRate without components
+func (c TaxCategory) Rate() float64 {
+	if len(c.Components) == 0 {
+		return c.TaxRate
+	}
+	var rate float64
+	for _, component := range c.Components {
+		rate += component.TaxRate
+	}
+	return rate
+}
+
+// User roles
+const (
+	RoleAdmin   = "admin"   // Everything, including settings, the product catalog, refunds and reports
+	RoleCashier = "cashier" // The POS: cart, payments and receipts
+)
+
+// User is a named login. Passwords are stored as bcrypt hashes.
+type User struct {
+	Username     string `json:"username"`
+	PasswordHash string `json:"passwordHash"`
+	Role         string `json:"role"`              // RoleAdmin or RoleCashier
+	PINHash      string `json:"pinHash,omitempty"` // bcrypt hash of the PIN for switching users at the register ("" = none)
 }
 
 // AppConfig represents the application configuration
@@ -84,11 +293,23 @@ type AppConfig struct {
 	// Stripe configuration
 	StripeSecretKey          string `json:"stripeSecretKey" setting:"section:stripe,label:Stripe Secret Key,type:password,id:stripe-secret-key,help:Your Stripe secret key from the dashboard"`
 	StripePublicKey          string `json:"stripePublicKey" setting:"section:stripe,label:Stripe Public Key,type:text,id:stripe-public-key,help:Your Stripe publishable key from the dashboard"`
-	StripeWebhookSecret      string `json:"stripeWebhookSecret" setting:"section:stripe,label:Stripe Webhook Secret,type:password,id:stripe-webhook-secret,help:Webhook endpoint secret for Stripe events"`
+	StripeWebhookSecret      string `json:"stripeWebhookSecret" setting:"section:stripe,label:Stripe Webhook Secret,type:password,id:stripe-webhook-secret,help:Signing secret of the webhook endpoint (whsec_...); while rotating give the new and old secrets comma-separated"`
+	ForceWebhookStrategy     bool   `json:"forceWebhookStrategy,omitempty" setting:"section:stripe,label:Force Webhook Strategy,type:checkbox,id:force-webhook-strategy,help:For development: keep webhook mode on localhost for stripe listen --forward-to. No endpoint is registered; give the signing secret stripe listen prints as the webhook secret or in STRIPE_WEBHOOK_SECRET"`
 	StripeTerminalLocationID string `json:"stripeTerminalLocationID,omitempty" setting:"section:stripe,label:Terminal Location,type:text,id:stripe-terminal-location,help:ID of the Stripe Terminal Location (tml_...)"`
+	TerminalCollectEmail     bool   `json:"terminalCollectEmail,omitempty" setting:"section:stripe,label:Receipt Email on Reader,type:checkbox,id:terminal-collect-email,help:After a terminal payment, ask the customer to type a receipt email on readers that support it (WisePOS E and S700)"`
+
+	// Charge configuration for PaymentIntents created by the POS
+	TerminalPaymentMethodTypes []string `json:"terminalPaymentMethodTypes,omitempty" setting:"section:stripe,label:Terminal Payment Methods,type:text,id:terminal-payment-method-types,help:Comma-separated payment method types accepted on the reader: card_present and/or interac_present (empty = card_present)"`
+	StatementDescriptorSuffix  string   `json:"statementDescriptorSuffix,omitempty" setting:"section:stripe,label:Statement Descriptor Suffix,type:text,id:statement-descriptor-suffix,help:Added to the account's statement descriptor on card statements (up to 22 characters; empty = none)"`
+	BusinessNameOnCharges      bool     `json:"businessNameOnCharges,omitempty" setting:"section:stripe,label:Business Name on Charges,type:checkbox,id:business-name-on-charges,help:Record the business name as the description of every charge"`
+
+	// Payment links sent to customers to pay later are deactivated after this long
+	SentLinkExpiryHours int `json:"sentLinkExpiryHours" setting:"section:stripe,label:Sent Link Expiry (hours),type:number,id:sent-link-expiry,help:Hours a payment link sent to a customer stays payable before it is deactivated (0 = until cancelled; default 72),step:1,min:0"`
 
-	// Authentication (hidden from settings UI)
-	Password string `json:"password" setting:"-"`
+	// Authentication (hidden from settings UI). Password is the single shared password of older
+	// configs; it is turned into an "admin" user on load.
+	Password string `json:"password,omitempty" setting:"-"`
+	Users    []User `json:"users,omitempty" setting:"-"`
 
 	// Business information
 	BusinessName   string `json:"businessName" setting:"section:business,label:Business Name,type:text,id:business-name,help:Your business or company name"`
@@ -97,18 +318,33 @@ type AppConfig struct {
 	BusinessState  string `json:"businessState" setting:"section:business,label:State,type:text,id:business-state,help:State or province where your business is located"`
 	BusinessZIP    string `json:"businessZIP" setting:"section:business,label:ZIP Code,type:text,id:business-zip,help:ZIP or postal code for your business"`
 
+	// Business timezone (schedules run on the business clock, not the server's)
+	BusinessTimezone string `json:"businessTimezone,omitempty" setting:"section:business,label:Timezone,type:text,id:business-timezone,help:IANA timezone of the business (e.g. America/New_York; empty = server time)"`
+
+	// Sales after midnight and before this time belong to the previous business day
+	BusinessDayClose string `json:"businessDayClose,omitempty" setting:"section:business,label:Business Day Ends,type:text,id:business-day-close,help:Time the business day ends in the business timezone; sales between midnight and this time are logged and reported with the previous day (HH:MM before 12:00; empty = midnight)"`
+
+	// Language of the POS screens and receipts
+	Locale string `json:"locale,omitempty" setting:"section:business,label:Language,type:text,id:locale,help:Language and number and date format of the POS screens and receipts: en or es (empty = en)"`
+
 	// Tax information
 	BusinessTaxID  string  `json:"businessTaxID" setting:"section:tax,label:Business Tax ID,type:text,id:business-tax-id,help:Business Tax ID (EIN)"`
 	SalesTaxNumber string  `json:"salesTaxNumber" setting:"section:tax,label:Sales Tax Number,type:text,id:sales-tax-number,help:Sales tax registration number"`
 	VATNumber      string  `json:"vatNumber" setting:"section:tax,label:VAT Number,type:text,id:vat-number,help:VAT registration number (if applicable)"`
 	DefaultTaxRate float64 `json:"defaultTaxRate" setting:"section:tax,label:Default Tax Rate,type:number,id:default-tax-rate,help:Default tax rate as percentage (e.g. 8.25),step:0.0001,min:0,max:100,format:percentage"`
 
+	// Where tax is calculated; locations can override it (TaxModeLocationOverrides)
+	TaxMode                  string            `json:"taxMode,omitempty" setting:"section:tax,label:Tax Mode,type:text,id:tax-mode,help:local (the rates set here) or stripe_tax (calculated by Stripe Tax for the sale location; needs Stripe Tax set up in the Dashboard) (empty = local)"`
+	TaxModeLocationOverrides map[string]string `json:"taxModeLocationOverrides,omitempty" setting:"-"` // Per-location tax mode (locationID -> mode)
+
 	// Website information
 	WebsiteName string `json:"websiteName" setting:"section:system,label:Website Name,type:text,id:website-name,help:Name displayed in the browser title and headers"`
 
-	// Customer default location (hidden from settings UI - used internally)
-	DefaultCity  string `json:"defaultCity" setting:"-"`
-	DefaultState string `json:"defaultState" setting:"-"`
+	// Default sale location, used by Stripe Tax when the Terminal Location has no address
+	DefaultCity       string `json:"defaultCity" setting:"section:tax,label:Default City,type:text,id:default-city,help:City of the default sale location for Stripe Tax"`
+	DefaultState      string `json:"defaultState" setting:"section:tax,label:Default State,type:text,id:default-state,help:State or province code of the default sale location for Stripe Tax (e.g. CA)"`
+	DefaultPostalCode string `json:"defaultPostalCode,omitempty" setting:"section:tax,label:Default Postal Code,type:text,id:default-postal-code,help:Postal code of the default sale location for Stripe Tax"`
+	DefaultCountry    string `json:"defaultCountry,omitempty" setting:"section:tax,label:Default Country,type:text,id:default-country,help:Two-letter country code of the default sale location for Stripe Tax (empty = US)"`
 
 	// Tax configuration (complex types hidden from simple settings UI)
 	TaxCategories []TaxCategory `json:"taxCategories" setting:"-"`
@@ -116,34 +352,168 @@ type AppConfig struct {
 	// System configuration
 	Port            string `json:"port" setting:"section:system,label:Port,type:text,id:port,help:Port number for the web server"`
 	ServerAddress   string `json:"serverAddress" setting:"section:system,label:Server Address,type:text,id:server-address,help:Address to bind the server to (e.g. 127.0.0.1 or 0.0.0.0)"`
-	DataDir         string `json:"dataDir" setting:"section:system,label:Data Directory,type:text,id:data-dir,help:Directory where application data is stored"`
-	TransactionsDir string `json:"transactionsDir" setting:"section:system,label:Transactions Dir,type:text,id:transactions-dir,help:Directory where transaction records are stored"`
+	DataDir         string `json:"dataDir" setting:"section:system,label:Data Directory,type:text,id:data-dir,help:Directory where application data is stored; moved with -migrate-data"`
+	TransactionsDir string `json:"transactionsDir" setting:"section:system,label:Transactions Dir,type:text,id:transactions-dir,help:Directory where transaction records are stored; moved with -migrate-data"`
+
+	// Log file output (console only when LogFile is empty)
+	LogFile      string `json:"logFile,omitempty" setting:"section:system,label:Log File,type:text,id:log-file,help:Write JSON logs to this file with size-based rotation (empty = console only; restart to apply)"`
+	LogLevel     string `json:"logLevel,omitempty" setting:"section:system,label:Log Level,type:text,id:log-level,help:debug, info, warn or error (empty = info; restart to apply)"`
+	LogMaxSizeMB int    `json:"logMaxSizeMB,omitempty" setting:"section:system,label:Log Max Size (MB),type:number,id:log-max-size,help:Rotate the log file when it reaches this size (0 = 10 MB),step:1,min:0"`
+	LogMaxFiles  int    `json:"logMaxFiles,omitempty" setting:"section:system,label:Log Files Kept,type:number,id:log-max-files,help:Number of rotated log files to keep (0 = 5),step:1,min:0"`
+
+	// Monitoring: serve /metrics and /healthz on a separate internal listener
+	MetricsAddress string `json:"metricsAddress,omitempty" setting:"section:system,label:Metrics Address,type:text,id:metrics-address,help:Internal address for /metrics and /healthz (e.g. 127.0.0.1:9090; empty = /metrics behind login on the main port)"`
+
+	// Daily check for a newer release, shown to admins; off on installs without internet access
+	UpdateCheckURL string `json:"updateCheckURL,omitempty" setting:"section:system,label:Update Check URL,type:text,id:update-check-url,help:Releases URL checked once a day for a newer version (e.g. https://api.github.com/repos/codr1/checkout/releases/latest; empty = never check; restart to apply)"`
+
+	// Quick charge configuration
+	QuickChargeMaxAmount float64 `json:"quickChargeMaxAmount,omitempty" setting:"section:system,label:Quick Charge Max,type:number,id:quick-charge-max,help:Maximum amount for a single quick charge (0 = no limit),step:0.01,min:0"`
+
+	// Highest price of a custom item; not omitempty so an explicit 0 (no limit) survives a save
+	CustomItemMaxPrice float64 `json:"customItemMaxPrice" setting:"section:system,label:Custom Item Max Price,type:number,id:custom-item-max-price,help:Highest price a custom item can be rung up at (0 = no limit; default 10000),step:0.01,min:0"`
+
+	// Quick-access row of starred products; not omitempty so an explicit 0 (disabled) survives a save
+	MaxFavorites int `json:"maxFavorites" setting:"section:system,label:Max Favorites,type:number,id:max-favorites,help:Number of products each register can star for its quick-access row above the categories (0 = no favorites; default 8),step:1,min:0,max:24"`
+
+	// Decimal places accepted for the quantity of products sold by weight or length; not omitempty so 0 (whole units) survives a save
+	QuantityDecimals int `json:"quantityDecimals" setting:"section:system,label:Quantity Decimals,type:number,id:quantity-decimals,help:Decimal places the cashier can enter for products sold by weight or length (default 2),step:1,min:0,max:4"`
+
+	// Demo mode: payments are simulated and nothing is sent to Stripe
+	DemoMode bool `json:"demoMode,omitempty" setting:"section:system,label:Demo Mode,type:checkbox,id:demo-mode,help:Simulate payments for training and demos without contacting Stripe; sales are logged in a separate demo directory"`
+
+	// Price override configuration
+	AllowPriceOverrides bool `json:"allowPriceOverrides,omitempty" setting:"section:system,label:Allow Price Overrides,type:checkbox,id:allow-price-overrides,help:Allow cashiers to change the price of a cart item for a single sale"`
+
+	// Void configuration
+	VoidWindowMinutes int `json:"voidWindowMinutes,omitempty" setting:"section:system,label:Void Window,type:number,id:void-window,help:Minutes after a sale during which it can be voided (0 = 30 minutes),step:1,min:0"`
+
+	// How long payment states reported by Stripe webhooks are kept for the polling path
+	WebhookCacheTTLMinutes int `json:"webhookCacheTTLMinutes,omitempty" setting:"section:system,label:Webhook Cache TTL,type:number,id:webhook-cache-ttl,help:Minutes a payment status received by webhook is kept until the POS acts on it (0 = 15 minutes),step:1,min:0"`
+
+	// In webhook mode, how long a payment can go without a webhook before its status is fetched from Stripe
+	WebhookFallbackSeconds int `json:"webhookFallbackSeconds,omitempty" setting:"section:system,label:Webhook Fallback Delay,type:number,id:webhook-fallback-seconds,help:Seconds a payment can go without a webhook update before the POS checks its status with Stripe directly (0 = 30 seconds),step:1,min:0"`
+
+	// In webhook mode, how long without any webhook event before /healthz reports the webhooks as degraded
+	WebhookSilenceMinutes int `json:"webhookSilenceMinutes,omitempty" setting:"section:system,label:Webhook Silence Alert,type:number,id:webhook-silence,help:Minutes without a webhook event from Stripe before /healthz warns that webhooks may have stopped (0 = never),step:1,min:0"`
+
+	// Webhook signature timestamps older than this are rejected; a register whose clock drifts may need more
+	WebhookToleranceSeconds int `json:"webhookToleranceSeconds,omitempty" setting:"section:system,label:Webhook Signature Tolerance,type:number,id:webhook-tolerance,help:Seconds a webhook's signature timestamp may differ from this computer's clock before the event is rejected (0 = 300; raise it only while fixing the clock),step:1,min:0"`
+
+	// Idle cart reset; not omitempty so an explicit 0 (disabled) survives a save
+	CartIdleTimeoutMinutes int `json:"cartIdleTimeoutMinutes" setting:"section:system,label:Cart Idle Timeout,type:number,id:cart-idle-timeout,help:Minutes a cart can sit unchanged before it is cleared (0 = never; default 15),step:1,min:0"`
+
+	// Screen lock after inactivity; the session, cart and payments in progress are kept
+	AutoLockMinutes int `json:"autoLockMinutes,omitempty" setting:"section:system,label:Auto-Lock,type:number,id:auto-lock,help:Minutes a register can go without a change before its screen locks and asks for the password again (0 = never),step:1,min:0"`
+
+	// Quick user switching with a PIN; after too many wrong PINs the register needs a password login
+	PINMaxAttempts int `json:"pinMaxAttempts,omitempty" setting:"section:system,label:PIN Attempts,type:number,id:pin-max-attempts,help:Wrong PINs allowed when switching users or unlocking the screen before the register is signed out and needs a full password login (0 = 5),step:1,min:0"`
+
+	// Business hours, used by the reader keep-alive and the outside-hours warning
+	BusinessHoursStart       string `json:"businessHoursStart,omitempty" setting:"section:hours,label:Business Hours Start,type:text,id:business-hours-start,help:Time the business opens each day in the business timezone (HH:MM; empty = all day)"`
+	BusinessHoursEnd         string `json:"businessHoursEnd,omitempty" setting:"section:hours,label:Business Hours End,type:text,id:business-hours-end,help:Time the business closes each day in the business timezone (HH:MM; empty = all day)"`
+	WarnOutsideBusinessHours bool   `json:"warnOutsideBusinessHours,omitempty" setting:"section:hours,label:Warn Outside Business Hours,type:checkbox,id:warn-outside-hours,help:Show a warning when a sale is started outside business hours (the sale is not blocked)"`
+
+	// Reader keep-alive; not omitempty so an explicit 0 (disabled) survives a save
+	ReaderKeepAliveMinutes     int `json:"readerKeepAliveMinutes" setting:"section:hours,label:Keep-Alive Interval,type:number,id:reader-keepalive,help:Minutes between checks that the selected reader is still reachable (0 = never; default 10),step:1,min:0"`
+	ReaderDegradedAfterMinutes int `json:"readerDegradedAfterMinutes,omitempty" setting:"section:hours,label:Warn After,type:number,id:reader-degraded-after,help:Minutes the reader can go without answering before the POS shows a warning (0 = 20 minutes),step:1,min:0"`
+
+	// JSON API for external integrations, authenticated separately from the admin password
+	APIKeys string `json:"apiKeys,omitempty" setting:"section:system,label:API Keys,type:password,id:api-keys,help:Comma-separated keys accepted by the /api/v1 JSON API (empty = API disabled)"`
+
+	// Order webhooks: each finished sale, refund and void is posted as JSON to these URLs (see services/order_webhooks.go)
+	OrderWebhookURLs        string `json:"orderWebhookURLs,omitempty" setting:"section:orderwebhooks,label:Webhook URLs,type:text,id:order-webhook-urls,help:Comma-separated http or https URLs that receive a JSON POST for every completed sale, refund and void (empty = off)"`
+	OrderWebhookSecret      string `json:"orderWebhookSecret,omitempty" setting:"section:orderwebhooks,label:Signing Secret,type:password,id:order-webhook-secret,help:Shared secret the X-Signature header is computed with (sha256= followed by the hex HMAC-SHA256 of the X-Timestamp header then a dot then the body; empty = unsigned)"`
+	OrderWebhookMaxAttempts int    `json:"orderWebhookMaxAttempts,omitempty" setting:"section:orderwebhooks,label:Delivery Attempts,type:number,id:order-webhook-max-attempts,help:Attempts at delivering an event before it is moved to the dead-letter log; waits double between attempts (0 = 8),step:1,min:0"`
+
+	// Market vendors whose card sales are paid to their own Stripe connected account (edited in config.json)
+	Vendors                     []Vendor `json:"vendors,omitempty" setting:"-"`
+	VendorApplicationFeePercent float64  `json:"vendorApplicationFeePercent,omitempty" setting:"section:vendors,label:Application Fee,type:number,id:vendor-application-fee,help:Percentage of each vendor card payment the market keeps as the Stripe application fee (e.g. 5 for 5%); the rest goes to the vendor's connected account,step:0.01,min:0,max:100"`
+
+	// Customer-facing display, opened on a second screen with this token instead of a login
+	CustomerDisplayToken string `json:"customerDisplayToken,omitempty" setting:"section:system,label:Customer Display Token,type:password,id:customer-display-token,help:Token for opening /customer-display?token=... on a customer-facing screen (empty = display disabled)"`
+
+	// Email (SMTP) configuration for outgoing reports
+	SMTPHost     string `json:"smtpHost,omitempty" setting:"section:email,label:SMTP Host,type:text,id:smtp-host,help:SMTP server hostname (e.g. smtp.gmail.com)"`
+	SMTPPort     string `json:"smtpPort,omitempty" setting:"section:email,label:SMTP Port,type:text,id:smtp-port,help:SMTP server port (default 587)"`
+	SMTPUsername string `json:"smtpUsername,omitempty" setting:"section:email,label:SMTP Username,type:text,id:smtp-username,help:Username for SMTP authentication (empty = no authentication)"`
+	SMTPPassword string `json:"smtpPassword,omitempty" setting:"section:email,label:SMTP Password,type:password,id:smtp-password,help:Password for SMTP authentication"`
+	EmailFrom    string `json:"emailFrom,omitempty" setting:"section:email,label:From Address,type:text,id:email-from,help:Sender address for outgoing email"`
+
+	// Receipt addresses at these domains are rejected with a suggestion, on top of the built-in misspellings
+	EmailTypoDomains string `json:"emailTypoDomains,omitempty" setting:"section:email,label:Misspelled Domains,type:text,id:email-typo-domains,help:Comma-separated typo=domain pairs of email domains to reject with a suggestion (e.g. exmaple.com=example.com); common misspellings of gmail.com and other providers are always caught"`
+
+	// Daily report configuration
+	DailyReportRecipients string `json:"dailyReportRecipients,omitempty" setting:"section:reports,label:Report Recipients,type:text,id:daily-report-recipients,help:Comma-separated email addresses that receive the end-of-day report"`
+	DailyReportTime       string `json:"dailyReportTime,omitempty" setting:"section:reports,label:Report Send Time,type:text,id:daily-report-time,help:Time of day to send the report in the business timezone (HH:MM; empty = disabled)"`
+
+	// Stripe reconciliation configuration
+	ReconciliationTime       string `json:"reconciliationTime,omitempty" setting:"section:reports,label:Reconciliation Time,type:text,id:reconciliation-time,help:Time of day to reconcile the previous day's transactions with Stripe in the business timezone (HH:MM; empty = disabled)"`
+	ReconciliationRecipients string `json:"reconciliationRecipients,omitempty" setting:"section:reports,label:Reconciliation Recipients,type:text,id:reconciliation-recipients,help:Comma-separated email addresses that receive the reconciliation report (empty = log only)"`
+
+	// Receipt branding (the logo is uploaded through /receipt-logo, not edited as text)
+	ReceiptLogo   string `json:"receiptLogo,omitempty" setting:"section:branding,label:Receipt Logo,type:file,id:receipt-logo,help:PNG or JPEG logo printed at the top of receipts (up to 1 MB and 2000 pixels wide or high)"`
+	ReceiptFooter string `json:"receiptFooter,omitempty" setting:"section:branding,label:Footer Message,type:text,id:receipt-footer,help:Message printed at the bottom of receipts and shown after payment (empty = Thank you!)"`
+	ReturnPolicy  string `json:"returnPolicy,omitempty" setting:"section:branding,label:Return Policy,type:textarea,id:return-policy,help:Return policy printed on receipts (empty = none)"`
+
+	// What the success screen does after a sale; the defaults keep it open until the cashier closes it
+	SuccessAutoCloseSeconds int  `json:"successAutoCloseSeconds,omitempty" setting:"section:flow,label:Auto-Close Success Screen,type:number,id:success-auto-close,help:Seconds the payment success screen stays open before it closes for the next sale; touching the screen keeps it open (0 = until closed),step:1,min:0"`
+	AutoPrintReceipt        bool `json:"autoPrintReceipt,omitempty" setting:"section:flow,label:Print Receipt Automatically,type:checkbox,id:auto-print-receipt,help:Open the receipt with its print dialog as soon as a payment succeeds"`
+	TicketOpenManually      bool `json:"ticketOpenManually,omitempty" setting:"section:flow,label:Open Tickets Manually,type:checkbox,id:ticket-open-manually,help:Leave the fulfillment ticket closed after a payment; it is printed with the button on the success screen instead of opening by itself"`
+	CollapseReceiptForm     bool `json:"collapseReceiptForm,omitempty" setting:"section:flow,label:Collapse Receipt Form,type:checkbox,id:collapse-receipt-form,help:Show the email and text receipt form folded away behind a button on the success screen"`
+
+	// Fulfillment tickets for a kitchen or counter preparing orders
+	FulfillmentTickets bool `json:"fulfillmentTickets,omitempty" setting:"section:tickets,label:Fulfillment Tickets,type:checkbox,id:fulfillment-tickets,help:Number each paid order and open a ticket listing its items (no prices) to print for the kitchen; the order number is also printed on the receipt"`
+
+	// Old transaction files are compressed into monthly archives; archives are only ever deleted by an admin
+	ArchiveAfterMonths int `json:"archiveAfterMonths" setting:"section:archive,label:Archive After (months),type:number,id:archive-after-months,help:Months transaction logs are kept as raw files before they are compressed into a monthly archive under the data directory (0 = never archive; default 18),step:1,min:0"`
+	RetentionMonths    int `json:"retentionMonths,omitempty" setting:"section:archive,label:Retention Period (months),type:number,id:retention-months,help:Months of records that must be kept; older archived months can then be deleted by an admin on the Data Archive page (0 = never delete; at least 12),step:1,min:0"`
 
 	// AWS SNS Configuration (for SMS receipts)
 	AWSAccessKeyID     string `json:"awsAccessKeyId" setting:"section:sms,label:AWS Access Key,type:text,id:aws-access-key,help:AWS Access Key ID for SMS functionality"`
 	AWSSecretAccessKey string `json:"awsSecretAccessKey" setting:"section:sms,label:AWS Secret Access Key,type:password,id:aws-secret-key,help:AWS Secret Access Key for SMS functionality"`
 	AWSRegion          string `json:"awsRegion" setting:"section:sms,label:AWS Region,type:text,id:aws-region,help:AWS Region (e.g. us-east-1)"`
 
+	// Phone numbers typed without a country code are taken as numbers of this country
+	PhoneCountry string `json:"phoneCountry,omitempty" setting:"section:sms,label:Default Phone Country,type:text,id:phone-country,help:Two-letter code of the country of phone numbers typed without +country code (e.g. US, MX, GB; empty = US)"`
+
 	// Tipping Configuration
 	TippingEnabled           bool    `json:"tippingEnabled" setting:"section:tipping,label:Tipping Enabled,type:checkbox,id:tipping-enabled,help:Enable or disable tipping functionality"`
 	TippingMinAmount         float64 `json:"tippingMinAmount" setting:"section:tipping,label:Min Amount,type:number,id:tipping-min-amount,help:Minimum transaction amount to show tipping (in dollars),step:0.01,min:0"`
 	TippingMaxAmount         float64 `json:"tippingMaxAmount" setting:"section:tipping,label:Max Amount,type:number,id:tipping-max-amount,help:Maximum transaction amount to show tipping (0 = no limit),step:0.01,min:0"`
 	TippingAllowCustomAmount bool    `json:"tippingAllowCustomAmount" setting:"section:tipping,label:Allow Custom Amounts,type:checkbox,id:tipping-allow-custom,help:Allow customers to enter custom tip amounts"`
+	TippingPresetPercentages []int   `json:"tippingPresetPercentages" setting:"section:tipping,label:Preset Tip Percentages,type:text,id:tipping-presets,help:Comma-separated tip percentages offered on screen (1-100, up to 6)"`
 
 	// Complex tipping fields (hidden from simple settings UI)
 	TippingLocationOverrides     map[string]bool `json:"tippingLocationOverrides" setting:"-"`     // Per-location tipping overrides (locationID -> enabled)
-	TippingPresetPercentages     []int           `json:"tippingPresetPercentages" setting:"-"`     // Preset tip percentages (e.g., [15, 18, 20, 25])
 	TippingProductCategoriesOnly []string        `json:"tippingProductCategoriesOnly" setting:"-"` // Only show tipping for specific product categories (empty = all)
+
+	// Service fee or card surcharge added to the sale for some payment methods
+	ServiceFeeMode       string   `json:"serviceFeeMode,omitempty" setting:"section:fees,label:Fee Type,type:text,id:service-fee-mode,help:percentage or fixed (empty = no service fee)"`
+	ServiceFeeAmount     float64  `json:"serviceFeeAmount,omitempty" setting:"section:fees,label:Fee Amount,type:number,id:service-fee-amount,help:Percentage of the sale including tax (e.g. 3 for 3%) or a fixed dollar amount per sale,step:0.01,min:0"`
+	ServiceFeeLabel      string   `json:"serviceFeeLabel,omitempty" setting:"section:fees,label:Fee Label,type:text,id:service-fee-label,help:Name of the fee on the checkout screen and receipts (empty = Service fee)"`
+	ServiceFeeMethods    []string `json:"serviceFeeMethods,omitempty" setting:"section:fees,label:Charged On,type:text,id:service-fee-methods,help:Comma-separated payment methods the fee is added to: terminal, manual, qr and/or cash (empty = terminal, manual, qr)"`
+	ServiceFeeMaxPercent float64  `json:"serviceFeeMaxPercent,omitempty" setting:"section:fees,label:Fee Cap,type:number,id:service-fee-max-percent,help:Highest fee allowed in your jurisdiction as a percentage of the sale; fixed fees are lowered to it (0 = no cap),step:0.01,min:0,max:100"`
 }
 
 // StripeLocation represents a Stripe Terminal Location.
 type StripeLocation struct {
-	ID          string `json:"id"`
-	DisplayName string `json:"display_name"`
-	Livemode    bool   `json:"livemode"`
+	ID          string        `json:"id"`
+	DisplayName string        `json:"display_name"`
+	Livemode    bool          `json:"livemode"`
+	Address     StripeAddress `json:"address"` // Where the location is; Stripe Tax taxes its sales there
 	// Add other fields from stripe.TerminalLocationParams if needed
 }
 
+// StripeAddress is a postal address as Stripe stores it
+type StripeAddress struct {
+	Line1      string `json:"line1,omitempty"`
+	City       string `json:"city,omitempty"`
+	State      string `json:"state,omitempty"`
+	PostalCode string `json:"postal_code,omitempty"`
+	Country    string `json:"country,omitempty"` // Two-letter country code
+}
+
 // StripeReader represents a Stripe Terminal reader.
 type StripeReader struct {
 	ID              string `json:"id"` // Reader ID (tmr_...)
@@ -159,5 +529,7 @@ type StripeReader struct {
 
 // LayoutContext represents shared UI state for layout templates
 type LayoutContext struct {
-	IsTestMode bool `json:"isTestMode"`
+	IsTestMode   bool `json:"isTestMode"`
+	IsDemoMode   bool `json:"isDemoMode"`
+	DemoDeclines bool `json:"demoDeclines"` // The simulated reader declines payments
 }
@@ -0,0 +1,22 @@
+package templates
+
+import "context"
+
+type userContextKey struct{}
+
+// WithUser returns a context carrying the signed-in user, for handlers and templates
+func WithUser(ctx context.Context, user User) context.Context {
+	return context.WithValue(ctx, userContextKey{}, user)
+}
+
+// CurrentUser returns the signed-in user of the request being handled, if any
+func CurrentUser(ctx context.Context) (User, bool) {
+	user, ok := ctx.Value(userContextKey{}).(User)
+	return user, ok
+}
+
+// IsAdmin reports whether the signed-in user is an admin; templates use it to hide admin-only navigation
+func IsAdmin(ctx context.Context) bool {
+	user, ok := CurrentUser(ctx)
+	return ok && user.Role == RoleAdmin
+}
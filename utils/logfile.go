@@ -0,0 +1,138 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// RotatingFile is an io.Writer that appends to a log file and rotates it by size.
+// When a write would push the file past maxSize, the file is renamed to path.1
+// (shifting older files to path.2, path.3, ...) and a new file is started.
+// Only maxFiles rotated files are kept; older ones are deleted.
+type RotatingFile struct {
+	path     string
+	maxSize  int64
+	maxFiles int
+
+	file  *os.File
+	size  int64
+	mutex sync.Mutex
+}
+
+// NewRotatingFile opens (or creates) the log file at path
+func NewRotatingFile(path string, maxSize int64, maxFiles int) (*RotatingFile, error) {
+	if maxSize <= 0 {
+		return nil, fmt.Errorf("log file max size must be positive")
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("error creating log directory: %w", err)
+	}
+
+	rf := &RotatingFile{path: path, maxSize: maxSize, maxFiles: maxFiles}
+	if err := rf.open(); err != nil {
+		return nil, err
+	}
+	return rf, nil
+}
+
+// Write appends p to the log file, rotating first if p would not fit
+func (rf *RotatingFile) Write(p []byte) (int, error) {
+	rf.mutex.Lock()
+	defer rf.mutex.Unlock()
+
+	if rf.size > 0 && rf.size+int64(len(p)) > rf.maxSize {
+		if err := rf.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := rf.file.Write(p)
+	rf.size += int64(n)
+	return n, err
+}
+
+// Close closes the current log file
+func (rf *RotatingFile) Close() error {
+	rf.mutex.Lock()
+	defer rf.mutex.Unlock()
+	return rf.file.Close()
+}
+
+// open opens the log file for appending and records its current size
+func (rf *RotatingFile) open() error {
+	file, err := os.OpenFile(rf.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("error opening log file: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("error reading log file: %w", err)
+	}
+	rf.file = file
+	rf.size = info.Size()
+	return nil
+}
+
+// rotate shifts the rotated files up by one, drops the oldest and starts a new file.
+// The current file is closed before renaming so rotation also works on Windows.
+func (rf *RotatingFile) rotate() error {
+	if err := rf.file.Close(); err != nil {
+		return fmt.Errorf("error closing log file: %w", err)
+	}
+
+	if rf.maxFiles > 0 {
+		_ = os.Remove(rf.backupPath(rf.maxFiles))
+		for i := rf.maxFiles - 1; i >= 1; i-- {
+			_ = os.Rename(rf.backupPath(i), rf.backupPath(i+1))
+		}
+		if err := os.Rename(rf.path, rf.backupPath(1)); err != nil {
+			return fmt.Errorf("error rotating log file: %w", err)
+		}
+	} else if err := os.Remove(rf.path); err != nil {
+		return fmt.Errorf("error removing log file: %w", err)
+	}
+
+	return rf.open()
+}
+
+// backupPath returns the name of the nth rotated file
+func (rf *RotatingFile) backupPath(n int) string {
+	return fmt.Sprintf("%s.%d", rf.path, n)
+}
+
+// TailLogLines returns up to limit of the last lines of a JSON log file that match, oldest first.
+// The most recently rotated file is read too when the current one has too few. Lines that
+// aren't JSON objects are skipped.
+func TailLogLines(path string, limit int, match func(record map[string]any) bool) ([]string, error) {
+	var lines []string
+	for _, file := range []string{path, path + ".1"} {
+		data, err := os.ReadFile(file)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading log file: %w", err)
+		}
+
+		var matched []string
+		for _, line := range strings.Split(string(data), "\n") {
+			var record map[string]any
+			if json.Unmarshal([]byte(line), &record) == nil && match(record) {
+				matched = append(matched, line)
+			}
+		}
+		lines = append(matched, lines...)
+		if len(lines) >= limit {
+			break
+		}
+	}
+	if len(lines) > limit {
+		lines = lines[len(lines)-limit:]
+	}
+	return lines, nil
+}
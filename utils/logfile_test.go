@@ -0,0 +1,119 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// openRotatingFile opens a rotating log in a directory removed after the test
+func openRotatingFile(t *testing.T, maxSize int64, maxFiles int) (*RotatingFile, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "logs", "checkout.log")
+	rf, err := NewRotatingFile(path, maxSize, maxFiles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { rf.Close() })
+	return rf, path
+}
+
+func write(t *testing.T, rf *RotatingFile, text string) {
+	t.Helper()
+	if n, err := rf.Write([]byte(text)); err != nil || n != len(text) {
+		t.Fatalf("Write(%q) = %d, %v", text, n, err)
+	}
+}
+
+// expectFile fails unless the file holds want, or doesn't exist when want is empty
+func expectFile(t *testing.T, path, want string) {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if want == "" {
+		if !os.IsNotExist(err) {
+			t.Errorf("%s exists with %q, want it removed", filepath.Base(path), data)
+		}
+		return
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != want {
+		t.Errorf("%s = %q, want %q", filepath.Base(path), data, want)
+	}
+}
+
+func TestRotatingFileRotatesAtMaxSize(t *testing.T) {
+	rf, path := openRotatingFile(t, 10, 3)
+
+	write(t, rf, "line one\n")
+	write(t, rf, "!")
+	expectFile(t, path, "line one\n!")
+	expectFile(t, path+".1", "")
+
+	write(t, rf, "line two\n")
+	expectFile(t, path, "line two\n")
+	expectFile(t, path+".1", "line one\n!")
+}
+
+// A line longer than the limit goes into a file of its own rather than being split or dropped
+func TestRotatingFileKeepsLongLinesWhole(t *testing.T) {
+	rf, path := openRotatingFile(t, 10, 3)
+
+	write(t, rf, "a line longer than the limit\n")
+	write(t, rf, "next\n")
+
+	expectFile(t, path, "next\n")
+	expectFile(t, path+".1", "a line longer than the limit\n")
+}
+
+// A restart appends to the log and counts what is already in it toward the limit
+func TestRotatingFileCountsExistingSize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "checkout.log")
+	if err := os.WriteFile(path, []byte("earlier\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	rf, err := NewRotatingFile(path, 10, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rf.Close()
+
+	write(t, rf, "now\n")
+
+	expectFile(t, path, "now\n")
+	expectFile(t, path+".1", "earlier\n")
+}
+
+func TestRotatingFilePrunesOldFiles(t *testing.T) {
+	rf, path := openRotatingFile(t, 4, 2)
+
+	for _, line := range []string{"one\n", "two\n", "tri\n", "for\n", "fiv\n"} {
+		write(t, rf, line)
+	}
+
+	expectFile(t, path, "fiv\n")
+	expectFile(t, path+".1", "for\n")
+	expectFile(t, path+".2", "tri\n")
+	expectFile(t, path+".3", "")
+	files, _ := filepath.Glob(path + "*")
+	if len(files) != 3 {
+		t.Errorf("log files = %v, want the log and 2 rotated files", files)
+	}
+}
+
+func TestRotatingFileWithoutBackups(t *testing.T) {
+	rf, path := openRotatingFile(t, 4, 0)
+
+	write(t, rf, "one\n")
+	write(t, rf, "two\n")
+
+	expectFile(t, path, "two\n")
+	expectFile(t, path+".1", "")
+}
+
+func TestNewRotatingFileRejectsNoLimit(t *testing.T) {
+	if _, err := NewRotatingFile(filepath.Join(t.TempDir(), "checkout.log"), 0, 3); err == nil {
+		t.Error("opened a log that never rotates")
+	}
+}
@@ -1,9 +1,121 @@
 package utils
 
 import (
+	"context"
+	"fmt"
+	"io"
 	"log/slog"
+	"os"
+	"regexp"
+	"strings"
+
+	"checkout/version"
 )
 
+// LogOptions configures where and how log lines are written
+type LogOptions struct {
+	Level     slog.Level
+	Format    string    // Console format: "text" or "json"
+	File      string    // JSON log file path (empty = console only)
+	MaxSizeMB int       // Size at which the log file is rotated
+	MaxFiles  int       // Rotated files to keep
+	Console   io.Writer // Console output without a log file (nil = stdout)
+}
+
+// logger is the single logger all helpers write through; ConfigureLogging replaces it
+var logger = slog.Default()
+
+// ConfigureLogging sets up the logger used by the helpers and as the slog default.
+// Without a log file everything goes to the console (stdout unless set). With one, the file receives JSON lines
+// at the configured level and warnings and errors are mirrored to stderr.
+func ConfigureLogging(opts LogOptions) error {
+	if opts.Format != "" && opts.Format != "text" && opts.Format != "json" {
+		return fmt.Errorf("unknown log format %q (use text or json)", opts.Format)
+	}
+
+	if opts.File == "" {
+		console := opts.Console
+		if console == nil {
+			console = os.Stdout
+		}
+		logger = slog.New(consoleHandler(console, opts.Format, opts.Level))
+		slog.SetDefault(logger)
+		return nil
+	}
+
+	file, err := NewRotatingFile(opts.File, int64(opts.MaxSizeMB)*1024*1024, opts.MaxFiles)
+	if err != nil {
+		return err
+	}
+
+	logger = slog.New(fanoutHandler{
+		slog.NewJSONHandler(file, &slog.HandlerOptions{Level: opts.Level}),
+		consoleHandler(os.Stderr, opts.Format, max(opts.Level, slog.LevelWarn)),
+	})
+	slog.SetDefault(logger)
+	return nil
+}
+
+// ParseLogLevel parses a level name (debug, info, warn, error); empty means info
+func ParseLogLevel(name string) (slog.Level, error) {
+	var level slog.Level
+	if strings.TrimSpace(name) == "" {
+		return slog.LevelInfo, nil
+	}
+	if err := level.UnmarshalText([]byte(strings.TrimSpace(name))); err != nil {
+		return slog.LevelInfo, fmt.Errorf("unknown log level %q", name)
+	}
+	return level, nil
+}
+
+// consoleHandler returns a text or JSON handler for terminal output
+func consoleHandler(w io.Writer, format string, level slog.Level) slog.Handler {
+	if format == "json" {
+		return slog.NewJSONHandler(w, &slog.HandlerOptions{Level: level})
+	}
+	return slog.NewTextHandler(w, &slog.HandlerOptions{Level: level})
+}
+
+// fanoutHandler sends each record to every handler that accepts its level
+type fanoutHandler []slog.Handler
+
+func (h fanoutHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	for _, handler := range h {
+		if handler.Enabled(ctx, level) {
+			return true
+		}
+	}
+	return false
+}
+
+func (h fanoutHandler) Handle(ctx context.Context, record slog.Record) error {
+	var firstErr error
+	for _, handler := range h {
+		if handler.Enabled(ctx, record.Level) {
+			if err := handler.Handle(ctx, record.Clone()); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+func (h fanoutHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	handlers := make(fanoutHandler, len(h))
+	for i, handler := range h {
+		handlers[i] = handler.WithAttrs(attrs)
+	}
+	return handlers
+}
+
+func (h fanoutHandler) WithGroup(name string) slog.Handler {
+	handlers := make(fanoutHandler, len(h))
+	for i, handler := range h {
+		handlers[i] = handler.WithGroup(name)
+	}
+	return handlers
+}
+
 // Log provides structured logging with subsystem identification
 // Example usage:
 //
@@ -13,17 +125,67 @@ func Log(level slog.Level, subsystem string, msg string, keysAndValues ...interf
 	attrs := []slog.Attr{
 		slog.String("subsystem", subsystem),
 	}
+	// Audit records name the build that wrote them
+	if subsystem == "audit" {
+		attrs = append(attrs, slog.String("version", version.String()))
+	}
 
-	// Convert key-value pairs to slog attributes
+	// Convert key-value pairs to slog attributes, masking customer contact details
 	for i := 0; i < len(keysAndValues); i += 2 {
 		if i+1 < len(keysAndValues) {
 			key := keysAndValues[i].(string)
-			value := keysAndValues[i+1]
+			value := maskContact(key, keysAndValues[i+1])
 			attrs = append(attrs, slog.Any(key, value))
 		}
 	}
 
-	slog.LogAttrs(nil, level, msg, attrs...)
+	logger.LogAttrs(context.Background(), level, MaskEmails(msg), attrs...)
+}
+
+// loggedEmail finds email addresses in logged text, keeping the first letter and the domain
+var loggedEmail = regexp.MustCompile(`([A-Za-z0-9_%+-])[A-Za-z0-9._%+-]*@([A-Za-z0-9-]+(?:\.[A-Za-z0-9-]+)*\.[A-Za-z]{2,})`)
+
+// MaskEmails masks the email addresses in a text as b***@domain
+func MaskEmails(text string) string {
+	return loggedEmail.ReplaceAllString(text, "${1}***@${2}")
+}
+
+// MaskPhone masks a phone number but its last four digits, e.g. ***4567
+func MaskPhone(phone string) string {
+	var digits []rune
+	for _, r := range phone {
+		if r >= '0' && r <= '9' {
+			digits = append(digits, r)
+		}
+	}
+	if len(digits) <= 4 {
+		return strings.Repeat("*", len(digits))
+	}
+	return "***" + string(digits[len(digits)-4:])
+}
+
+// maskContact masks the customer emails and phone numbers in a logged value. Emails are found
+// in any text; phone numbers look like too many other things, so only values under a key
+// naming a phone are masked.
+func maskContact(key string, value interface{}) interface{} {
+	switch v := value.(type) {
+	case string:
+		if strings.Contains(strings.ToLower(key), "phone") {
+			return MaskPhone(v)
+		}
+		return MaskEmails(v)
+	case []string:
+		masked := make([]string, len(v))
+		for i, text := range v {
+			masked[i] = maskContact(key, text).(string)
+		}
+		return masked
+	case error:
+		if masked := MaskEmails(v.Error()); masked != v.Error() {
+			return masked
+		}
+	}
+	return value
 }
 
 // Convenience functions for common log levels
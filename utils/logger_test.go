@@ -0,0 +1,107 @@
+package utils
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMaskEmails(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{"bare address", "bob@example.com", "b***@example.com"},
+		{"inside a sentence", "Receipt sent to Bob.Smith+shop@mail.example.co.uk.", "Receipt sent to B***@mail.example.co.uk."},
+		{"several addresses", "alice@example.com, bob@example.org", "a***@example.com, b***@example.org"},
+		{"in a JSON error", `{"email":"bob@example.com","error":"bounced"}`, `{"email":"b***@example.com","error":"bounced"}`},
+		{"no address", "Payment succeeded for @store", "Payment succeeded for @store"},
+		{"no top-level domain", "user@localhost", "user@localhost"},
+		{"empty", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := MaskEmails(tt.text); got != tt.want {
+				t.Errorf("MaskEmails(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMaskPhone(t *testing.T) {
+	tests := []struct {
+		phone string
+		want  string
+	}{
+		{"+15551234567", "***4567"},
+		{"(555) 123-4567", "***4567"},
+		{"+44 20 7946 0958", "***0958"},
+		{"4567", "****"},
+		{"12", "**"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.phone, func(t *testing.T) {
+			if got := MaskPhone(tt.phone); got != tt.want {
+				t.Errorf("MaskPhone(%q) = %q, want %q", tt.phone, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMaskContact(t *testing.T) {
+	plainErr := errors.New("connection refused")
+	tests := []struct {
+		name  string
+		key   string
+		value interface{}
+		want  interface{}
+	}{
+		{"phone key", "phone", "+15551234567", "***4567"},
+		{"key naming a phone", "receiptPhone", "+15551234567", "***4567"},
+		{"number under another key", "amount", "15551234567", "15551234567"},
+		{"email in a string", "notes", "sent to bob@example.com", "sent to b***@example.com"},
+		{"list of emails", "recipients", []string{"bob@example.com", "alice@example.com"}, []string{"b***@example.com", "a***@example.com"}},
+		{"list of phones", "phones", []string{"+15551234567", "+15559876543"}, []string{"***4567", "***6543"}},
+		{"error naming an email", "error", fmt.Errorf("mailbox bob@example.com full"), "mailbox b***@example.com full"},
+		{"error without an email", "error", plainErr, plainErr},
+		{"number", "amount", 4.50, 4.50},
+		{"nil", "customer", nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := maskContact(tt.key, tt.value); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("maskContact(%q, %v) = %#v, want %#v", tt.key, tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+// Neither the message nor the values of a log line show a customer's contact
+func TestLogMasksContacts(t *testing.T) {
+	var buf bytes.Buffer
+	if err := ConfigureLogging(LogOptions{Level: slog.LevelDebug, Format: "json", Console: &buf}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ConfigureLogging(LogOptions{}) })
+
+	Info("receipt", "Receipt sent to bob@example.com", "email", "bob@example.com", "phone", "+15551234567",
+		"error", errors.New("bounced for bob@example.com"), "payment_id", "pi_1")
+
+	logged := buf.String()
+	for _, hidden := range []string{"bob@example.com", "+15551234567"} {
+		if strings.Contains(logged, hidden) {
+			t.Errorf("log line shows %s: %s", hidden, logged)
+		}
+	}
+	for _, shown := range []string{"Receipt sent to b***@example.com", `"phone":"***4567"`, `"error":"bounced for b***@example.com"`, `"payment_id":"pi_1"`} {
+		if !strings.Contains(logged, shown) {
+			t.Errorf("log line missing %s: %s", shown, logged)
+		}
+	}
+}
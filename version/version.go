@@ -0,0 +1,121 @@
+// Package version identifies the running build. Release builds set the variables with -ldflags:
+//
+//	go build -ldflags "-X checkout/version.Version=v1.4.0 -X checkout/version.Commit=$(git rev-parse --short HEAD) -X checkout/version.BuildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)" -o checkout .
+//
+// A plain go build falls back to the commit and time Go records from the git checkout.
+package version
+
+import (
+	"runtime"
+	"runtime/debug"
+	"strings"
+)
+
+// Set at build time with -ldflags "-X checkout/version.Name=value"
+var (
+	Version   = "dev"
+	Commit    = ""
+	BuildDate = ""
+)
+
+// Info describes the running build, as served by /version
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	BuildDate string `json:"buildDate,omitempty"`
+	GoVersion string `json:"goVersion"`
+}
+
+// Get returns the running build's version, with the commit and build date Go recorded from the
+// checkout when -ldflags didn't set them
+func Get() Info {
+	info := Info{Version: Version, Commit: Commit, BuildDate: BuildDate, GoVersion: runtime.Version()}
+	if info.Commit != "" && info.BuildDate != "" {
+		return info
+	}
+
+	build, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+	modified := false
+	for _, setting := range build.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			if info.Commit == "" && len(setting.Value) >= 7 {
+				info.Commit = setting.Value[:7]
+			}
+		case "vcs.time":
+			if info.BuildDate == "" {
+				info.BuildDate = setting.Value
+			}
+		case "vcs.modified":
+			modified = setting.Value == "true"
+		}
+	}
+	if modified && Commit == "" && info.Commit != "" {
+		info.Commit += "-dirty"
+	}
+	return info
+}
+
+// String is the version with its commit, e.g. "v1.4.0 (3697d44)"
+func String() string {
+	info := Get()
+	if info.Commit == "" {
+		return info.Version
+	}
+	return info.Version + " (" + info.Commit + ")"
+}
+
+// IsRelease reports whether this is a tagged build whose version can be compared with releases
+func IsRelease() bool {
+	_, ok := parse(Version)
+	return ok
+}
+
+// Newer reports whether release is a later version than the running one
+func Newer(release string) bool {
+	return Later(release, Version)
+}
+
+// Later reports whether version a is later than version b. Both are compared as
+// major.minor.patch with an optional leading "v"; anything unparseable is never later.
+func Later(a, b string) bool {
+	first, ok := parse(a)
+	if !ok {
+		return false
+	}
+	second, ok := parse(b)
+	if !ok {
+		return false
+	}
+	for i := range first {
+		if first[i] != second[i] {
+			return first[i] > second[i]
+		}
+	}
+	return false
+}
+
+// parse splits a version like "v1.4.0" or "1.4" into its numbers. Pre-release versions
+// ("v1.5.0-rc1") are not parsed, so they are never offered as updates.
+func parse(version string) ([3]int, bool) {
+	var numbers [3]int
+	parts := strings.Split(strings.TrimPrefix(strings.TrimSpace(version), "v"), ".")
+	if len(parts) == 0 || len(parts) > 3 {
+		return numbers, false
+	}
+	for i, part := range parts {
+		if part == "" {
+			return numbers, false
+		}
+		for _, r := range part {
+			if r < '0' || r > '9' {
+				return numbers, false
+			}
+			numbers[i] = numbers[i]*10 + int(r-'0')
+		}
+	}
+	return numbers, true
+}